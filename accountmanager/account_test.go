@@ -17,11 +17,13 @@
 package accountmanager
 
 import (
+	"bytes"
 	"math/big"
 	"reflect"
 	"testing"
 
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
 )
 
 func Test_newAssetBalance(t *testing.T) {
@@ -941,3 +943,31 @@ func TestAccount_SetDestory(t *testing.T) {
 		a.SetDestory()
 	}
 }
+
+func FuzzDecodeAccount(f *testing.F) {
+	acct, err := NewAccount(common.Name("fuzzaccount"), common.HexToPubKey("0x047db227d7094ce215c3a0f57e1bcc732551fe351f94249471934567e0f5dc1bf795962b8cccb87a2eb56b29fbe37d614e2f4c3c45b789ae4f1f51f4cb21972ffd"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	acct.Balances = append(acct.Balances, newAssetBalance(1, big.NewInt(100)))
+	enc, err := rlp.EncodeToBytes(acct)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(enc)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var acct Account
+		if err := rlp.DecodeBytes(data, &acct); err != nil {
+			return
+		}
+		enc, err := rlp.EncodeToBytes(&acct)
+		if err != nil {
+			t.Fatalf("failed to encode decoded account: %v", err)
+		}
+		if !bytes.Equal(enc, data) {
+			t.Fatalf("re-encoding mismatch: have %x, want %x", enc, data)
+		}
+		acct.GetAllBalances()
+		acct.IsEmpty()
+	})
+}
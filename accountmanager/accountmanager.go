@@ -31,8 +31,9 @@ var acctInfoPrefix = "AcctInfo"
 
 // AccountManager represents account management model.
 type AccountManager struct {
-	sdb SdbIf
-	ast *asset.Asset
+	sdb      SdbIf
+	ast      *asset.Asset
+	readOnly bool
 }
 
 //NewAccountManager create new account manager
@@ -46,6 +47,17 @@ func NewAccountManager(db *state.StateDB) (*AccountManager, error) {
 	}, nil
 }
 
+// NewReadOnlyAccountManager creates an account manager failing all changes
+// of accounts and assets with ErrReadOnly.
+func NewReadOnlyAccountManager(db *state.StateDB) (*AccountManager, error) {
+	am, err := NewAccountManager(db)
+	if err != nil {
+		return nil, err
+	}
+	am.readOnly = true
+	return am, nil
+}
+
 // AccountIsExist check account is exist.
 func (am *AccountManager) AccountIsExist(accountName common.Name) (bool, error) {
 	//check is exist
@@ -78,6 +90,24 @@ func (am *AccountManager) AccountIsEmpty(accountName common.Name) (bool, error)
 
 //CreateAccount contract account pubkey = nil
 func (am *AccountManager) CreateAccount(accountName common.Name, pubkey common.PubKey) error {
+	auctioned, err := am.IsAuctionedName(accountName)
+	if err != nil {
+		return err
+	}
+	if auctioned {
+		return ErrNameAuctioned
+	}
+	return am.createAccount(accountName, pubkey)
+}
+
+func (am *AccountManager) createAccount(accountName common.Name, pubkey common.PubKey) error {
+	reserved, err := am.IsReservedName(accountName)
+	if err != nil {
+		return err
+	}
+	if reserved {
+		return ErrReservedName
+	}
 	//check is exist
 	acct, err := am.GetAccountByName(accountName)
 	if err != nil {
@@ -95,8 +125,7 @@ func (am *AccountManager) CreateAccount(accountName common.Name, pubkey common.P
 		return ErrCreateAccountError
 	}
 
-	am.SetAccount(acctObj)
-	return nil
+	return am.SetAccount(acctObj)
 }
 
 //UpdateAccount update the pubkey of the accunt
@@ -137,6 +166,9 @@ func (am *AccountManager) GetAccountByName(accountName common.Name) (*Account, e
 
 //store account object to db
 func (am *AccountManager) SetAccount(acct *Account) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
 	if acct == nil {
 		return ErrAccountIsNil
 	}
@@ -153,6 +185,9 @@ func (am *AccountManager) SetAccount(acct *Account) error {
 
 //DeleteAccountByName delete account
 func (am *AccountManager) DeleteAccountByName(accountName common.Name) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
 	acct, err := am.GetAccountByName(accountName)
 	if err != nil {
 		return ErrAccountNotExist
@@ -246,23 +281,122 @@ func (am *AccountManager) RecoverTx(signer types.Signer, tx *types.Transaction)
 
 // IsValidSign
 func (am *AccountManager) IsValidSign(accountName common.Name, aType types.ActionType, pub common.PubKey) error {
+	_, err := am.CheckSign(accountName, aType, pub)
+	return err
+}
+
+// CheckSign checks that the signature of the public key satisfies the
+// authority of the account for the action type, and returns the evaluation.
+func (am *AccountManager) CheckSign(accountName common.Name, aType types.ActionType, pub common.PubKey) (*SignCheck, error) {
+	acct, err := am.keySender(accountName, aType)
+	if err != nil {
+		return nil, err
+	}
+	check := newSignCheck(acct, []common.PubKey{pub})
+	if !check.Satisfied {
+		return nil, fmt.Errorf("%v %v have %v excepted %v", acct.AcctName, ErrkeyNotSame, acct.GetPubKey().String(), pub.String())
+	}
+	return check, nil
+}
+
+// IsValidSender checks that the account can send actions of the type, whether
+// signed by its key or executed by its multisig proposals.
+func (am *AccountManager) IsValidSender(accountName common.Name, aType types.ActionType) error {
+	_, err := am.validSender(accountName, aType)
+	return err
+}
+
+func (am *AccountManager) validSender(accountName common.Name, aType types.ActionType) (*Account, error) {
 	acct, err := am.GetAccountByName(accountName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if acct == nil {
-		return ErrAccountNotExist
+		return nil, ErrAccountNotExist
 	}
 	if acct.IsDestoryed() {
-		return ErrAccountIsDestroy
+		return nil, ErrAccountIsDestroy
 	}
 	//TODO action type verify
 
-	if acct.GetPubKey().Compare(pub) != 0 {
-		return fmt.Errorf("%v %v have %v excepted %v", acct.AcctName, ErrkeyNotSame, acct.GetPubKey().String(), pub.String())
+	return acct, nil
+}
+
+// keySender returns the account if its key can sign its actions of the type.
+// The actions of a multisig account are only executed by its proposals, its
+// key only signs its blocks.
+func (am *AccountManager) keySender(accountName common.Name, aType types.ActionType) (*Account, error) {
+	acct, err := am.validSender(accountName, aType)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if aType != types.Miner {
+		m, err := am.GetMultisig(accountName)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			return nil, ErrMultisigSender
+		}
+	}
+	return acct, nil
+}
 
+// SignCheck is the result of evaluating public keys against the authority of
+// an account for an action type.
+type SignCheck struct {
+	Satisfied bool            `json:"satisfied"`
+	Threshold uint64          `json:"threshold"`
+	Weight    uint64          `json:"weight"`
+	Matched   []common.PubKey `json:"matched"`
+}
+
+// CanSign evaluates whether signatures of the public keys satisfy the
+// authority of the account for the action type. The authority of an account
+// is its single public key, of weight 1 for a threshold of 1.
+func (am *AccountManager) CanSign(accountName common.Name, aType types.ActionType, pubs []common.PubKey) (*SignCheck, error) {
+	acct, err := am.keySender(accountName, aType)
+	if err != nil {
+		return nil, err
+	}
+	return newSignCheck(acct, pubs), nil
+}
+
+// newSignCheck evaluates the public keys against the authority of the
+// account.
+func newSignCheck(acct *Account, pubs []common.PubKey) *SignCheck {
+	check := &SignCheck{Threshold: 1, Matched: []common.PubKey{}}
+	for _, pub := range pubs {
+		if acct.GetPubKey().Compare(pub) == 0 {
+			check.Weight = 1
+			check.Matched = append(check.Matched, pub)
+			break
+		}
+	}
+	check.Satisfied = check.Weight >= check.Threshold
+	return check
+}
+
+// ForEachAccount calls fn for each account in name order until fn returns
+// false.
+func (am *AccountManager) ForEachAccount(fn func(*Account) bool) error {
+	var decodeErr error
+	err := am.sdb.ForEachData(acctInfoPrefix, func(name string, value []byte) bool {
+		var acct Account
+		if decodeErr = rlp.DecodeBytes(value, &acct); decodeErr != nil {
+			return false
+		}
+		return fn(&acct)
+	})
+	if err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+//GetAllAssetObject get all asset objects ordered by asset id.
+func (am *AccountManager) GetAllAssetObject() ([]*asset.AssetObject, error) {
+	return am.ast.GetAllAssetObject()
 }
 
 //GetAssetInfoByName get asset info by asset name.
@@ -545,6 +679,9 @@ func (am *AccountManager) TransferAsset(fromAccount common.Name, toAccount commo
 
 //IssueAsset issue asset
 func (am *AccountManager) IssueAsset(asset *asset.AssetObject) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
 	if err := am.ast.IssueAsset(asset.GetAssetName(), asset.GetSymbol(), asset.GetAssetAmount(), asset.GetDecimals(), asset.GetAssetOwner()); err != nil {
 		return err
 	}
@@ -560,6 +697,9 @@ func (am *AccountManager) IssueAsset(asset *asset.AssetObject) error {
 
 //increase asset and add amount to accout balance
 func (am *AccountManager) IncAsset2Acct(fromName common.Name, toName common.Name, assetID uint64, amount *big.Int) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
 	if err := am.ast.IncreaseAsset(fromName, assetID, amount); err != nil {
 		return err
 	}
@@ -582,6 +722,9 @@ func (am *AccountManager) IncAsset2Acct(fromName common.Name, toName common.Name
 // Process account action
 
 func (am *AccountManager) Process(action *types.Action) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
 	snap := am.sdb.Snapshot()
 	err := am.process(action)
 	if err != nil {
@@ -648,6 +791,15 @@ func (am *AccountManager) process(action *types.Action) error {
 			return err
 		}
 		break
+	case types.SetNameRecord:
+		var record NameRecord
+		if err := rlp.DecodeBytes(action.Data(), &record); err != nil {
+			return err
+		}
+		if err := am.SetNameRecord(action.Sender(), &record); err != nil {
+			return err
+		}
+		break
 	case types.Transfer:
 		return am.TransferAsset(action.Sender(), action.Recipient(), action.AssetID(), action.Value())
 	default:
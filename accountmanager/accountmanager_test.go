@@ -557,6 +557,43 @@ func TestAccountManager_IsValidSign(t *testing.T) {
 	}
 }
 
+func TestAccountManager_CanSign(t *testing.T) {
+	pubkey, _ := GeneragePubKey()
+	other, _ := GeneragePubKey()
+	name := common.Name("a123456789signer")
+	if err := acctm.CreateAccount(name, pubkey); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		acct      common.Name
+		pubs      []common.PubKey
+		satisfied bool
+		wantErr   bool
+	}{
+		{"nokey", name, nil, false, false},
+		{"otherkey", name, []common.PubKey{other}, false, false},
+		{"accountkey", name, []common.PubKey{other, pubkey}, true, false},
+		{"notexist", common.Name("a123456789nosuch"), []common.PubKey{pubkey}, false, true},
+	}
+	for _, tt := range tests {
+		check, err := acctm.CanSign(tt.acct, types.Transfer, tt.pubs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. AccountManager.CanSign() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && check.Satisfied != tt.satisfied {
+			t.Errorf("%q. AccountManager.CanSign() satisfied = %v, want %v", tt.name, check.Satisfied, tt.satisfied)
+		}
+	}
+	if check, err := acctm.CheckSign(name, types.Transfer, pubkey); err != nil || check.Weight != 1 || len(check.Matched) != 1 {
+		t.Errorf("AccountManager.CheckSign() = %+v, %v", check, err)
+	}
+	if _, err := acctm.CheckSign(name, types.Transfer, other); err == nil {
+		t.Error("AccountManager.CheckSign() accepted another key")
+	}
+}
+
 func TestAccountManager_GetAccountBalanceByID(t *testing.T) {
 	type fields struct {
 		sdb SdbIf
@@ -1309,3 +1346,33 @@ func TestAccountManager_Process(t *testing.T) {
 	}
 
 }
+
+func TestReadOnlyAccountManager(t *testing.T) {
+	statedb := getStateDB()
+	am, err := NewAccountManager(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := common.Name("readonlyacct")
+	if err := am.CreateAccount(name, common.PubKey{}); err != nil {
+		t.Fatal(err)
+	}
+	am.AddAccountBalanceByID(name, 1, big.NewInt(100))
+
+	roam, err := NewReadOnlyAccountManager(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance, err := roam.GetAccountBalanceByID(name, 1); err != nil || balance.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("balance mismatch: have %v, %v", balance, err)
+	}
+	if err := roam.CreateAccount(common.Name("readonlyacct2"), common.PubKey{}); err != ErrReadOnly {
+		t.Fatalf("create error mismatch: have %v, want %v", err, ErrReadOnly)
+	}
+	if err := roam.AddAccountBalanceByID(name, 1, big.NewInt(1)); err != ErrReadOnly {
+		t.Fatalf("add balance error mismatch: have %v, want %v", err, ErrReadOnly)
+	}
+	if err := roam.AddStorageSize(name, 10); err != ErrReadOnly {
+		t.Fatalf("storage size error mismatch: have %v, want %v", err, ErrReadOnly)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+// accountBalances decodes the balances of an encoded account, none if the
+// account doesn't exist.
+func accountBalances(data []byte) (map[uint64]*big.Int, error) {
+	balances := make(map[uint64]*big.Int)
+	if len(data) == 0 {
+		return balances, nil
+	}
+	var acct Account
+	if err := rlp.DecodeBytes(data, &acct); err != nil {
+		return nil, err
+	}
+	for _, b := range acct.Balances {
+		if b.Balance != nil && b.Balance.Sign() > 0 {
+			balances[b.AssetID] = b.Balance
+		}
+	}
+	return balances, nil
+}
+
+// AssetStatsDeltas returns the changes of the supply and holders of the assets
+// made by the balance changes since the state was opened, in asset id order.
+// The transfers aren't known from the balances and are left empty.
+func AssetStatsDeltas(sdb *state.StateDB) ([]*types.AssetStatsDelta, error) {
+	deltas := make(map[uint64]*types.AssetStatsDelta)
+	delta := func(assetID uint64) *types.AssetStatsDelta {
+		if deltas[assetID] == nil {
+			deltas[assetID] = types.NewAssetStatsDelta(assetID)
+		}
+		return deltas[assetID]
+	}
+
+	var decodeErr error
+	sdb.ForEachDataChange(acctInfoPrefix, func(name string, before, after []byte) {
+		if decodeErr != nil {
+			return
+		}
+		var prevBalances, balances map[uint64]*big.Int
+		if prevBalances, decodeErr = accountBalances(before); decodeErr != nil {
+			return
+		}
+		if balances, decodeErr = accountBalances(after); decodeErr != nil {
+			return
+		}
+		for assetID, balance := range balances {
+			prev, ok := prevBalances[assetID]
+			switch {
+			case !ok:
+				d := delta(assetID)
+				d.HoldersAdded++
+				d.SupplyAdded.Add(d.SupplyAdded, balance)
+			case balance.Cmp(prev) > 0:
+				d := delta(assetID)
+				d.SupplyAdded.Add(d.SupplyAdded, new(big.Int).Sub(balance, prev))
+			case balance.Cmp(prev) < 0:
+				d := delta(assetID)
+				d.SupplyRemoved.Add(d.SupplyRemoved, new(big.Int).Sub(prev, balance))
+			}
+		}
+		for assetID, balance := range prevBalances {
+			if _, ok := balances[assetID]; !ok {
+				d := delta(assetID)
+				d.HoldersRemoved++
+				d.SupplyRemoved.Add(d.SupplyRemoved, balance)
+			}
+		}
+	})
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	list := make([]*types.AssetStatsDelta, 0, len(deltas))
+	for _, d := range deltas {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].AssetID < list[j].AssetID })
+	return list, nil
+}
+
+// BalanceChanges returns the balances of the accounts changed since the state
+// was opened, ordered by account and asset id.
+func BalanceChanges(sdb *state.StateDB) ([]*types.BalanceChange, error) {
+	return balanceChanges(sdb.ForEachDataChange)
+}
+
+// BalanceChangesSince returns the balances of the accounts changed since the
+// snapshot revid of the state was taken, ordered by account and asset id.
+func BalanceChangesSince(sdb *state.StateDB, revid int) ([]*types.BalanceChange, error) {
+	return balanceChanges(func(key string, fn func(account string, before, after []byte)) {
+		sdb.ForEachDataChangeSince(revid, key, fn)
+	})
+}
+
+func balanceChanges(forEach func(key string, fn func(account string, before, after []byte))) ([]*types.BalanceChange, error) {
+	var (
+		changes   []*types.BalanceChange
+		decodeErr error
+	)
+	forEach(acctInfoPrefix, func(name string, before, after []byte) {
+		if decodeErr != nil {
+			return
+		}
+		var prevBalances, balances map[uint64]*big.Int
+		if prevBalances, decodeErr = accountBalances(before); decodeErr != nil {
+			return
+		}
+		if balances, decodeErr = accountBalances(after); decodeErr != nil {
+			return
+		}
+		change := func(assetID uint64, prev, balance *big.Int) {
+			changes = append(changes, &types.BalanceChange{Account: common.Name(name), AssetID: assetID, Previous: prev, Balance: balance})
+		}
+		for assetID, balance := range balances {
+			prev, ok := prevBalances[assetID]
+			switch {
+			case !ok:
+				change(assetID, new(big.Int), balance)
+			case balance.Cmp(prev) != 0:
+				change(assetID, prev, balance)
+			}
+		}
+		for assetID, prev := range prevBalances {
+			if _, ok := balances[assetID]; !ok {
+				change(assetID, prev, new(big.Int))
+			}
+		}
+	})
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Account != changes[j].Account {
+			return changes[i].Account < changes[j].Account
+		}
+		return changes[i].AssetID < changes[j].AssetID
+	})
+	return changes, nil
+}
+
+// AssetSupplies returns the supply and holders of the assets computed from the
+// balances of every account, in asset id order.
+func (am *AccountManager) AssetSupplies() ([]*types.AssetStats, error) {
+	stats := make(map[uint64]*types.AssetStats)
+	err := am.ForEachAccount(func(acct *Account) bool {
+		for _, b := range acct.Balances {
+			if b.Balance == nil || b.Balance.Sign() <= 0 {
+				continue
+			}
+			s := stats[b.AssetID]
+			if s == nil {
+				s = types.NewAssetStats(b.AssetID)
+				stats[b.AssetID] = s
+			}
+			s.Supply.Add(s.Supply, b.Balance)
+			s.Holders++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*types.AssetStats, 0, len(stats))
+	for _, s := range stats {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].AssetID < list[j].AssetID })
+	return list, nil
+}
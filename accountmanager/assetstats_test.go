@@ -0,0 +1,71 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestBalanceChanges(t *testing.T) {
+	sdb := getStateDB()
+	am, err := NewAccountManager(sdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := common.Name("balancealice"), common.Name("balancebob")
+	for _, name := range []common.Name{alice, bob} {
+		if err := am.CreateAccount(name, common.PubKey{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := am.IssueAsset(&asset.AssetObject{AssetName: "balancecoin", Symbol: "bal", Amount: big.NewInt(1000), Decimals: 2, Owner: alice}); err != nil {
+		t.Fatal(err)
+	}
+	coin, err := am.GetAssetInfoByName("balancecoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := am.TransferAsset(alice, bob, coin.AssetId, big.NewInt(300)); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := BalanceChanges(sdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		account  common.Name
+		previous int64
+		balance  int64
+	}{
+		{alice, 0, 700},
+		{bob, 0, 300},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes mismatch: have %d, want %d", len(changes), len(want))
+	}
+	for i, w := range want {
+		c := changes[i]
+		if c.Account != w.account || c.AssetID != coin.AssetId || c.Previous.Int64() != w.previous || c.Balance.Int64() != w.balance {
+			t.Fatalf("change %d mismatch: have %s %d %v->%v, want %s %d %d->%d", i, c.Account, c.AssetID, c.Previous, c.Balance, w.account, coin.AssetId, w.previous, w.balance)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var (
+	nameAuctionPrefix    = "NameAuction"
+	auctionedLengthOwner = "sysNameAuction"
+	auctionedLengthKey   = "MaxLength"
+)
+
+// NameAuction is the auction of an account name, holding the highest bid in
+// escrow until the name is settled or the bid is outbid.
+type NameAuction struct {
+	Bidder   common.Name // highest bidder
+	AssetID  uint64      // asset of the bids
+	Bid      *big.Int    // highest bid, held in escrow
+	Deadline uint64      // last block accepting bids
+	Number   uint64      // block of the highest bid
+}
+
+// SetAuctionedLength makes the names up to maxLength only created by the
+// winners of their auctions.
+func (am *AccountManager) SetAuctionedLength(maxLength uint64) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	b, err := rlp.EncodeToBytes(maxLength)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(auctionedLengthOwner, auctionedLengthKey, b)
+	return nil
+}
+
+// IsAuctionedName reports whether the name is only created by the winner of
+// its auction.
+func (am *AccountManager) IsAuctionedName(name common.Name) (bool, error) {
+	b, err := am.sdb.Get(auctionedLengthOwner, auctionedLengthKey)
+	if err != nil || len(b) == 0 {
+		return false, err
+	}
+	var maxLength uint64
+	if err := rlp.DecodeBytes(b, &maxLength); err != nil {
+		return false, err
+	}
+	return uint64(len(name.String())) <= maxLength, nil
+}
+
+// GetNameAuction returns the auction of the name, nil if it isn't auctioned.
+func (am *AccountManager) GetNameAuction(name common.Name) (*NameAuction, error) {
+	b, err := am.sdb.Get(name.String(), nameAuctionPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var auction NameAuction
+	if err := rlp.DecodeBytes(b, &auction); err != nil {
+		return nil, err
+	}
+	return &auction, nil
+}
+
+func (am *AccountManager) setNameAuction(name common.Name, auction *NameAuction) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	if auction == nil {
+		am.sdb.Put(name.String(), nameAuctionPrefix, nil)
+		return nil
+	}
+	b, err := rlp.EncodeToBytes(auction)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(name.String(), nameAuctionPrefix, b)
+	return nil
+}
+
+// BidName bids amount for the name at block number. The bid is taken from
+// the bidder in escrow and the previous highest bid is refunded. An auction
+// closes the configured duration after its last bid, its first bid must be
+// at least the minimum bid and later ones must raise the highest bid by the
+// minimum increment. A name takes at most one bid per block.
+func (am *AccountManager) BidName(bidder, name common.Name, assetID uint64, amount *big.Int, number uint64, cfg *params.NameAuctionConfig) (err error) {
+	snap := am.sdb.Snapshot()
+	defer func() {
+		if err != nil {
+			am.sdb.RevertToSnapshot(snap)
+		}
+	}()
+	if !common.IsValidName(name.String()) {
+		return ErrAccountNameInvalid
+	}
+	if reserved, err := am.IsReservedName(name); err != nil {
+		return err
+	} else if reserved {
+		return ErrReservedName
+	}
+	if ok, err := am.AccountIsExist(name); err != nil {
+		return err
+	} else if ok {
+		return ErrAccountIsExist
+	}
+	auction, err := am.GetNameAuction(name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case auction == nil:
+		if cfg.MinBid != nil && amount.Cmp(cfg.MinBid) < 0 {
+			return ErrBidTooLow
+		}
+	case number > auction.Deadline:
+		return ErrAuctionClosed
+	case number == auction.Number:
+		return ErrBidRateLimited
+	case assetID != auction.AssetID:
+		return ErrAssetIDInvalid
+	case amount.Cmp(auction.Bid) <= 0:
+		return ErrBidTooLow
+	default:
+		raise := new(big.Int).Mul(auction.Bid, new(big.Int).SetUint64(cfg.MinIncrement))
+		raise.Div(raise, big.NewInt(100))
+		if amount.Cmp(raise.Add(raise, auction.Bid)) < 0 {
+			return ErrBidTooLow
+		}
+	}
+	if err := am.SubAccountBalanceByID(bidder, assetID, amount); err != nil {
+		return err
+	}
+	if auction != nil {
+		if err := am.AddAccountBalanceByID(auction.Bidder, auction.AssetID, auction.Bid); err != nil {
+			return err
+		}
+	}
+	return am.setNameAuction(name, &NameAuction{
+		Bidder:   bidder,
+		AssetID:  assetID,
+		Bid:      new(big.Int).Set(amount),
+		Deadline: number + cfg.Duration,
+		Number:   number,
+	})
+}
+
+// SettleName creates the account of a name whose auction is closed for its
+// winner, and pays the escrowed winning bid to payee.
+func (am *AccountManager) SettleName(winner, name common.Name, pubkey common.PubKey, number uint64, payee common.Name) (err error) {
+	snap := am.sdb.Snapshot()
+	defer func() {
+		if err != nil {
+			am.sdb.RevertToSnapshot(snap)
+		}
+	}()
+	auction, err := am.GetNameAuction(name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case auction == nil:
+		return ErrNoAuction
+	case number <= auction.Deadline:
+		return ErrAuctionOpen
+	case auction.Bidder != winner:
+		return ErrNotAuctionWinner
+	}
+	if err := am.createAccount(name, pubkey); err != nil {
+		return err
+	}
+	// the escrowed bid is burned if there is no payee.
+	if ok, _ := am.AccountIsExist(payee); ok {
+		if err := am.AddAccountBalanceByID(payee, auction.AssetID, auction.Bid); err != nil {
+			return err
+		}
+	}
+	return am.setNameAuction(name, nil)
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+)
+
+func TestNameAuction(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob, payee := common.Name("auctionalice"), common.Name("auctionbob"), common.Name("auctionpayee")
+	for _, name := range []common.Name{alice, bob, payee} {
+		if err := am.CreateAccount(name, common.PubKey{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const assetID = 1
+	cfg := &params.NameAuctionConfig{MaxLength: 8, Duration: 10, MinBid: big.NewInt(100), MinIncrement: 10}
+	if err := am.SetAuctionedLength(cfg.MaxLength); err != nil {
+		t.Fatal(err)
+	}
+	am.AddAccountBalanceByID(alice, assetID, big.NewInt(1000))
+	am.AddAccountBalanceByID(bob, assetID, big.NewInt(1000))
+	balance := func(name common.Name) int64 {
+		b, _ := am.GetAccountBalanceByID(name, assetID)
+		return b.Int64()
+	}
+	name := common.Name("shortnm1")
+	if err := am.CreateAccount(name, common.PubKey{}); err != ErrNameAuctioned {
+		t.Fatalf("create error mismatch: have %v, want %v", err, ErrNameAuctioned)
+	}
+
+	if err := am.BidName(alice, name, assetID, big.NewInt(50), 1, cfg); err != ErrBidTooLow {
+		t.Fatalf("bid error mismatch: have %v, want %v", err, ErrBidTooLow)
+	}
+	if err := am.BidName(alice, name, assetID, big.NewInt(200), 1, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.BidName(bob, name, assetID, big.NewInt(300), 1, cfg); err != ErrBidRateLimited {
+		t.Fatalf("bid error mismatch: have %v, want %v", err, ErrBidRateLimited)
+	}
+	// A bid must raise the highest bid by the minimum increment.
+	for _, bid := range []int64{200, 219} {
+		if err := am.BidName(bob, name, assetID, big.NewInt(bid), 5, cfg); err != ErrBidTooLow {
+			t.Fatalf("bid %d error mismatch: have %v, want %v", bid, err, ErrBidTooLow)
+		}
+	}
+	// The outbid bidder is refunded and the auction extended.
+	if err := am.BidName(bob, name, assetID, big.NewInt(300), 5, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if balance(alice) != 1000 || balance(bob) != 700 {
+		t.Fatalf("balances mismatch: alice %d, bob %d", balance(alice), balance(bob))
+	}
+	if err := am.SettleName(bob, name, common.PubKey{}, 15, payee); err != ErrAuctionOpen {
+		t.Fatalf("settle error mismatch: have %v, want %v", err, ErrAuctionOpen)
+	}
+	if err := am.BidName(alice, name, assetID, big.NewInt(400), 16, cfg); err != ErrAuctionClosed {
+		t.Fatalf("bid error mismatch: have %v, want %v", err, ErrAuctionClosed)
+	}
+	if err := am.SettleName(alice, name, common.PubKey{}, 16, payee); err != ErrNotAuctionWinner {
+		t.Fatalf("settle error mismatch: have %v, want %v", err, ErrNotAuctionWinner)
+	}
+	if err := am.SettleName(bob, name, common.PubKey{}, 16, payee); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := am.AccountIsExist(name); !ok {
+		t.Fatal("settled account not created")
+	}
+	if balance(payee) != 300 {
+		t.Fatalf("payee balance mismatch: have %d, want 300", balance(payee))
+	}
+	if auction, _ := am.GetNameAuction(name); auction != nil {
+		t.Fatal("auction kept after settlement")
+	}
+	if err := am.BidName(alice, name, assetID, big.NewInt(400), 17, cfg); err != ErrAccountIsExist {
+		t.Fatalf("bid error mismatch: have %v, want %v", err, ErrAccountIsExist)
+	}
+	// A failed bid leaves the balances unchanged.
+	if err := am.BidName(alice, common.Name("shortnm2"), assetID, big.NewInt(5000), 17, cfg); err == nil {
+		t.Fatal("bid over the balance accepted")
+	}
+	if balance(alice) != 1000 {
+		t.Fatalf("failed bid charged: balance %d", balance(alice))
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"encoding/json"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
+	"golang.org/x/crypto/sha3"
+)
+
+// DumpBalance is the balance of an account in an asset in a state dump.
+type DumpBalance struct {
+	AssetID uint64   `json:"assetID"`
+	Balance *big.Int `json:"balance"`
+}
+
+// DumpAccount is an account in a state dump.
+type DumpAccount struct {
+	Name      common.Name    `json:"name"`
+	Nonce     uint64         `json:"nonce"`
+	PublicKey common.PubKey  `json:"publicKey"`
+	Code      hexutil.Bytes  `json:"code,omitempty"`
+	CodeHash  common.Hash    `json:"codeHash"`
+	Balances  []*DumpBalance `json:"balances"`
+	Suicide   bool           `json:"suicide,omitempty"`
+	Destroy   bool           `json:"destroy,omitempty"`
+}
+
+func newDumpAccount(acct *Account) *DumpAccount {
+	dump := &DumpAccount{
+		Name:      acct.AcctName,
+		Nonce:     acct.Nonce,
+		PublicKey: acct.PublicKey,
+		Code:      acct.Code,
+		CodeHash:  acct.CodeHash,
+		Balances:  make([]*DumpBalance, len(acct.Balances)),
+		Suicide:   acct.Suicide,
+		Destroy:   acct.Destroy,
+	}
+	for i, b := range acct.Balances {
+		dump.Balances[i] = &DumpBalance{AssetID: b.AssetID, Balance: b.Balance}
+	}
+	return dump
+}
+
+// Dump writes the accounts and assets of the state to w as a JSON object with
+// the root, accounts and assets fields. Accounts are written one at a time as
+// they are read, so the dump isn't held in memory.
+func (am *AccountManager) Dump(root common.Hash, w io.Writer) error {
+	rootJSON, err := json.Marshal(root)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `{"root":`+string(rootJSON)+`,"accounts":[`); err != nil {
+		return err
+	}
+
+	var writeErr error
+	sep := ""
+	err = am.ForEachAccount(func(acct *Account) bool {
+		var data []byte
+		if data, writeErr = json.Marshal(newDumpAccount(acct)); writeErr != nil {
+			return false
+		}
+		if _, writeErr = io.WriteString(w, sep+string(data)); writeErr != nil {
+			return false
+		}
+		sep = ","
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	assets, err := am.GetAllAssetObject()
+	if err != nil {
+		return err
+	}
+	assetsJSON, err := json.Marshal(assets)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, `],"assets":`+string(assetsJSON)+"}\n")
+	return err
+}
+
+// AccountRoot returns a hash over the data of all the accounts in name order
+// and the number of accounts. It only depends on the account data, so nodes
+// with the same accounts at a height have the same root whatever their
+// storage layout.
+func (am *AccountManager) AccountRoot() (common.Hash, int, error) {
+	var (
+		hasher  = sha3.NewLegacyKeccak256()
+		count   int
+		hashErr error
+	)
+	err := am.ForEachAccount(func(acct *Account) bool {
+		if hashErr = rlp.Encode(hasher, acct); hashErr != nil {
+			return false
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return common.Hash{}, 0, err
+	}
+	if hashErr != nil {
+		return common.Hash{}, 0, hashErr
+	}
+	var root common.Hash
+	hasher.Sum(root[:0])
+	return root, count, nil
+}
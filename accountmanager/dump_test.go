@@ -0,0 +1,121 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func TestDump(t *testing.T) {
+	db := fdb.NewMemDatabase()
+	cachedb := state.NewDatabase(db)
+	statedb, _ := state.New(common.Hash{}, cachedb)
+	am, _ := NewAccountManager(statedb)
+	for _, name := range []common.Name{"dumpacctb", "dumpaccta"} {
+		if err := am.CreateAccount(name, common.PubKey{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ao, _ := asset.NewAssetObject("dumpcoin", "dcn", big.NewInt(100), 0, "dumpaccta")
+	if err := am.IssueAsset(ao); err != nil {
+		t.Fatal(err)
+	}
+
+	// Commit the accounts, then add one that is only pending in the state.
+	root := common.BytesToHash([]byte("dumproot"))
+	batch := db.NewBatch()
+	if _, err := statedb.Commit(batch, root, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	statedb.CommitCache(root)
+	statedb, _ = state.New(root, cachedb)
+	am, _ = NewAccountManager(statedb)
+	if err := am.CreateAccount("dumpacctc", common.PubKey{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := am.Dump(root, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var dump struct {
+		Root     common.Hash
+		Accounts []*DumpAccount
+		Assets   []*asset.AssetObject
+	}
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatalf("invalid dump %s: %v", buf.Bytes(), err)
+	}
+	if dump.Root != root {
+		t.Errorf("root mismatch: have %x, want %x", dump.Root, root)
+	}
+	var names []common.Name
+	for _, acct := range dump.Accounts {
+		names = append(names, acct.Name)
+	}
+	if len(names) != 3 || names[0] != "dumpaccta" || names[1] != "dumpacctb" || names[2] != "dumpacctc" {
+		t.Fatalf("dumped accounts mismatch: have %v, want [dumpaccta dumpacctb dumpacctc]", names)
+	}
+	if b := dump.Accounts[0].Balances; len(b) != 1 || b[0].Balance.Int64() != 100 {
+		t.Errorf("dumped balances mismatch: %v", b)
+	}
+	if len(dump.Assets) != 1 || dump.Assets[0].AssetName != "dumpcoin" {
+		t.Errorf("dumped assets mismatch: %v", dump.Assets)
+	}
+}
+
+func TestAccountRoot(t *testing.T) {
+	newManager := func(names ...common.Name) *AccountManager {
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+		am, _ := NewAccountManager(statedb)
+		for _, name := range names {
+			if err := am.CreateAccount(name, common.PubKey{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return am
+	}
+	am1 := newManager("rootaccta", "rootacctb")
+	am2 := newManager("rootacctb", "rootaccta")
+	root1, count, err := am1.AccountRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("account count mismatch: have %d, want 2", count)
+	}
+	if root2, _, _ := am2.AccountRoot(); root1 != root2 {
+		t.Fatalf("root depends on the creation order: %x != %x", root1, root2)
+	}
+	if err := am2.SetNonce("rootaccta", 1); err != nil {
+		t.Fatal(err)
+	}
+	if root2, _, _ := am2.AccountRoot(); root1 == root2 {
+		t.Fatal("root unchanged by a different nonce")
+	}
+}
@@ -35,4 +35,41 @@ var (
 	ErrAmountValueInvalid   = errors.New("amount value is invalid")
 	ErrAccountAssetNotExist = errors.New("account asset not exist")
 	ErrUnkownTxType         = errors.New("Not support action type")
+	ErrAccountHibernated    = errors.New("account is hibernated")
+	ErrAccountNotHibernated = errors.New("account is not hibernated")
+	ErrReadOnly             = errors.New("account manager is read-only")
+	ErrBidTooLow            = errors.New("bid too low")
+	ErrAuctionClosed        = errors.New("name auction is closed")
+	ErrAuctionOpen          = errors.New("name auction is still open")
+	ErrNoAuction            = errors.New("name is not auctioned")
+	ErrNotAuctionWinner     = errors.New("not the winner of the name auction")
+	ErrNameAuctioned        = errors.New("name is only created by its auction")
+	ErrBidRateLimited       = errors.New("name already bid in this block")
+	ErrFeeAssetNotAllowed   = errors.New("asset is not allowed to pay fees")
+	ErrReservedName         = errors.New("name is reserved for system accounts")
+	ErrNameRecordInvalid    = errors.New("name record is invalid")
+	ErrTooManyNameRecords   = errors.New("too many name records")
+	ErrRateLimited          = errors.New("too many actions of the account in the window")
+	ErrInsufficientStake    = errors.New("insufficient staked amount")
+	ErrResourceExhausted    = errors.New("gas quota of the account exhausted")
+	ErrResourceInUse        = errors.New("stake backs gas used in the current window")
+	ErrResourcesDisabled    = errors.New("resource staking is disabled")
+	ErrTreasuryDisabled     = errors.New("fee pool withdrawals are disabled")
+	ErrWithdrawalInvalid    = errors.New("withdrawal proposal is invalid")
+	ErrWithdrawalNotExist   = errors.New("withdrawal proposal not exist")
+	ErrWithdrawalExpired    = errors.New("withdrawal proposal is expired")
+	ErrWithdrawalExecuted   = errors.New("withdrawal proposal is already executed")
+	ErrWithdrawalApproved   = errors.New("withdrawal proposal is already approved by the producer")
+	ErrWithdrawalQuorum     = errors.New("withdrawal proposal lacks a quorum of approvals")
+	ErrMultisigInvalid      = errors.New("multisig authority is invalid")
+	ErrNotMultisig          = errors.New("account is not a multisig account")
+	ErrNotApprover          = errors.New("not an approver of the multisig account")
+	ErrMultisigTxInvalid    = errors.New("multisig proposal is invalid")
+	ErrMultisigTxNotExist   = errors.New("multisig proposal not exist")
+	ErrMultisigTxExpired    = errors.New("multisig proposal is expired")
+	ErrMultisigTxExecuted   = errors.New("multisig proposal is already executed")
+	ErrMultisigTxApproved   = errors.New("multisig proposal is already approved by the account")
+	ErrMultisigThreshold    = errors.New("multisig proposal lacks the threshold of approvals")
+	ErrMultisigTxMismatch   = errors.New("transaction does not match the multisig proposal")
+	ErrMultisigSender       = errors.New("actions of a multisig account are only executed by its proposals")
 )
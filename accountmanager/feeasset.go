@@ -0,0 +1,120 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"strconv"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var feeRateKey = "FeeRate"
+
+// FeeRate is the exchange rate of a whitelisted fee asset: a fee of x in
+// the fee token of the chain costs x * Num / Den, rounded up, of the asset.
+type FeeRate struct {
+	Num *big.Int `json:"num"`
+	Den *big.Int `json:"den"`
+}
+
+// Valid reports whether the rate is positive.
+func (r *FeeRate) Valid() bool {
+	return r.Num != nil && r.Den != nil && r.Num.Sign() > 0 && r.Den.Sign() > 0
+}
+
+// GetFeeRate returns the exchange rate of the fee asset, nil if the asset
+// isn't whitelisted to pay fees.
+func (am *AccountManager) GetFeeRate(assetID uint64) (*FeeRate, error) {
+	b, err := am.sdb.Get(strconv.FormatUint(assetID, 10), feeRateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var rate FeeRate
+	if err := rlp.DecodeBytes(b, &rate); err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// SetFeeRate whitelists the asset to pay fees at the rate, or removes it
+// from the whitelist if rate is nil.
+func (am *AccountManager) SetFeeRate(assetID uint64, rate *FeeRate) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	key := strconv.FormatUint(assetID, 10)
+	if rate == nil {
+		am.sdb.Put(key, feeRateKey, nil)
+		return nil
+	}
+	if !rate.Valid() {
+		return ErrAmountValueInvalid
+	}
+	if asset, err := am.GetAssetInfoByID(assetID); err != nil || asset == nil {
+		return ErrAssetIDInvalid
+	}
+	b, err := rlp.EncodeToBytes(rate)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(key, feeRateKey, b)
+	return nil
+}
+
+// FeeAmount converts amount, priced in the fee token feeTokenID, to the fee
+// asset assetID.
+func (am *AccountManager) FeeAmount(assetID, feeTokenID uint64, amount *big.Int) (*big.Int, error) {
+	if assetID == feeTokenID {
+		return new(big.Int).Set(amount), nil
+	}
+	rate, err := am.GetFeeRate(assetID)
+	if err != nil {
+		return nil, err
+	}
+	if rate == nil {
+		return nil, ErrFeeAssetNotAllowed
+	}
+	value := new(big.Int).Mul(amount, rate.Num)
+	value.Add(value, rate.Den)
+	value.Sub(value, big.NewInt(1))
+	return value.Div(value, rate.Den), nil
+}
+
+// ChargeFee takes a fee of amount, priced in the fee token feeTokenID, from
+// the account in the fee asset assetID and returns the amount taken.
+func (am *AccountManager) ChargeFee(name common.Name, assetID, feeTokenID uint64, amount *big.Int) (*big.Int, error) {
+	value, err := am.FeeAmount(assetID, feeTokenID, amount)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := am.GetAccountBalanceByID(name, assetID)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Cmp(value) < 0 {
+		return nil, ErrInsufficientBalance
+	}
+	if err := am.SubAccountBalanceByID(name, assetID, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestChargeFee(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := common.Name("feeassetuser")
+	if err := am.CreateAccount(user, common.PubKey{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.IssueAsset(&asset.AssetObject{AssetName: "popularcoin", Symbol: "pop", Amount: big.NewInt(1000), Decimals: 2, Owner: user}); err != nil {
+		t.Fatal(err)
+	}
+	pop, err := am.GetAssetInfoByName("popularcoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const feeTokenID = 100
+
+	if _, err := am.ChargeFee(user, pop.AssetId, feeTokenID, big.NewInt(10)); err != ErrFeeAssetNotAllowed {
+		t.Fatalf("charge error mismatch: have %v, want %v", err, ErrFeeAssetNotAllowed)
+	}
+	if err := am.SetFeeRate(pop.AssetId, &FeeRate{Num: big.NewInt(1), Den: big.NewInt(0)}); err != ErrAmountValueInvalid {
+		t.Fatalf("set rate error mismatch: have %v, want %v", err, ErrAmountValueInvalid)
+	}
+	if err := am.SetFeeRate(pop.AssetId+1, &FeeRate{Num: big.NewInt(1), Den: big.NewInt(1)}); err != ErrAssetIDInvalid {
+		t.Fatalf("set rate error mismatch: have %v, want %v", err, ErrAssetIDInvalid)
+	}
+	// 3 pop pay for 2 of the fee token, rounded up.
+	if err := am.SetFeeRate(pop.AssetId, &FeeRate{Num: big.NewInt(3), Den: big.NewInt(2)}); err != nil {
+		t.Fatal(err)
+	}
+	charged, err := am.ChargeFee(user, pop.AssetId, feeTokenID, big.NewInt(11))
+	if err != nil || charged.Int64() != 17 {
+		t.Fatalf("charged %v (%v), want 17", charged, err)
+	}
+	if balance, _ := am.GetAccountBalanceByID(user, pop.AssetId); balance.Int64() != 983 {
+		t.Fatalf("balance %v, want 983", balance)
+	}
+	if _, err := am.ChargeFee(user, pop.AssetId, feeTokenID, big.NewInt(1000)); err != ErrInsufficientBalance {
+		t.Fatalf("charge error mismatch: have %v, want %v", err, ErrInsufficientBalance)
+	}
+	if value, err := am.FeeAmount(feeTokenID, feeTokenID, big.NewInt(11)); err != nil || value.Int64() != 11 {
+		t.Fatalf("fee token amount %v (%v), want 11", value, err)
+	}
+
+	if err := am.SetFeeRate(pop.AssetId, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rate, err := am.GetFeeRate(pop.AssetId); err != nil || rate != nil {
+		t.Fatalf("removed rate %v (%v)", rate, err)
+	}
+}
@@ -85,6 +85,7 @@ type IAccountManager interface {
 type SdbIf interface {
 	Put(account string, key string, value []byte)
 	Get(account string, key string) ([]byte, error)
+	ForEachData(key string, fn func(account string, value []byte) bool) error
 	Snapshot() int
 	RevertToSnapshot(revid int)
 }
@@ -0,0 +1,89 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"strconv"
+
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var minGasPriceKey = "MinGasPrice"
+
+// MinGasPrice is the minimum gas price, in the fee token of the chain, of the
+// transactions carrying an action of the type.
+type MinGasPrice struct {
+	Type  types.ActionType `json:"type"`
+	Price *big.Int         `json:"price"`
+}
+
+// GetMinGasPrice returns the minimum gas price of the actions of the type,
+// zero if the type has none.
+func (am *AccountManager) GetMinGasPrice(actionType types.ActionType) (*big.Int, error) {
+	b, err := am.sdb.Get(strconv.FormatUint(uint64(actionType), 10), minGasPriceKey)
+	if err != nil {
+		return nil, err
+	}
+	price := new(big.Int)
+	if len(b) == 0 {
+		return price, nil
+	}
+	if err := rlp.DecodeBytes(b, price); err != nil {
+		return nil, err
+	}
+	return price, nil
+}
+
+// SetMinGasPrice sets the minimum gas price of the actions of the type, or
+// removes it if the price is nil or zero.
+func (am *AccountManager) SetMinGasPrice(actionType types.ActionType, price *big.Int) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	key := strconv.FormatUint(uint64(actionType), 10)
+	if price == nil || price.Sign() == 0 {
+		am.sdb.Put(key, minGasPriceKey, nil)
+		return nil
+	}
+	if price.Sign() < 0 {
+		return ErrAmountValueInvalid
+	}
+	b, err := rlp.EncodeToBytes(price)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(key, minGasPriceKey, b)
+	return nil
+}
+
+// MinGasPrice returns the minimum gas price, in the fee token of the chain, of
+// the transaction, the highest minimum of its actions.
+func (am *AccountManager) MinGasPrice(tx *types.Transaction) (*big.Int, error) {
+	min := new(big.Int)
+	for _, action := range tx.GetActions() {
+		price, err := am.GetMinGasPrice(action.Type())
+		if err != nil {
+			return nil, err
+		}
+		if price.Cmp(min) > 0 {
+			min = price
+		}
+	}
+	return min, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/types"
+)
+
+func TestMinGasPrice(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price, err := am.GetMinGasPrice(types.Transfer); err != nil || price.Sign() != 0 {
+		t.Fatalf("unset price %v (%v), want 0", price, err)
+	}
+	if err := am.SetMinGasPrice(types.Transfer, big.NewInt(-1)); err != ErrAmountValueInvalid {
+		t.Fatalf("set price error mismatch: have %v, want %v", err, ErrAmountValueInvalid)
+	}
+	if err := am.SetMinGasPrice(types.Transfer, big.NewInt(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.SetMinGasPrice(types.CreateContract, big.NewInt(50)); err != nil {
+		t.Fatal(err)
+	}
+	if price, err := am.GetMinGasPrice(types.CreateContract); err != nil || price.Int64() != 50 {
+		t.Fatalf("contract creation price %v (%v), want 50", price, err)
+	}
+
+	transfer := types.NewAction(types.Transfer, "minpriceuser", "", 0, 0, 0, big.NewInt(0), nil)
+	deploy := types.NewAction(types.CreateContract, "minpriceuser", "", 0, 0, 0, big.NewInt(0), nil)
+	if price, err := am.MinGasPrice(types.NewTransaction(0, big.NewInt(1), transfer)); err != nil || price.Int64() != 2 {
+		t.Fatalf("transfer transaction price %v (%v), want 2", price, err)
+	}
+	if price, err := am.MinGasPrice(types.NewTransaction(0, big.NewInt(1), transfer, deploy)); err != nil || price.Int64() != 50 {
+		t.Fatalf("mixed transaction price %v (%v), want 50", price, err)
+	}
+
+	if err := am.SetMinGasPrice(types.CreateContract, nil); err != nil {
+		t.Fatal(err)
+	}
+	if price, err := am.GetMinGasPrice(types.CreateContract); err != nil || price.Sign() != 0 {
+		t.Fatalf("removed price %v (%v), want 0", price, err)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"strconv"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var (
+	multisigKey        = "Multisig"
+	multisigTxKey      = "MultisigTx"
+	multisigTxCountKey = "MultisigTxCount"
+	maxMultisigSigners = 16
+)
+
+// MultisigApprover is an account approving the multisig proposals of another
+// account with a weight.
+type MultisigApprover struct {
+	Name   common.Name `json:"name"`
+	Weight uint64      `json:"weight"`
+}
+
+// Multisig is the authority of the multisig proposals of an account: a
+// proposal is executed once the approvers approving it weigh the threshold.
+type Multisig struct {
+	Threshold uint64              `json:"threshold"`
+	Approvers []*MultisigApprover `json:"approvers"`
+}
+
+// Weight returns the weight of the approvers among the names.
+func (m *Multisig) Weight(names []common.Name) uint64 {
+	approved := make(map[common.Name]struct{}, len(names))
+	for _, name := range names {
+		approved[name] = struct{}{}
+	}
+	var weight uint64
+	for _, approver := range m.Approvers {
+		if _, ok := approved[approver.Name]; ok {
+			weight += approver.Weight
+		}
+	}
+	return weight
+}
+
+// IsApprover reports whether the account is one of the approvers.
+func (m *Multisig) IsApprover(name common.Name) bool {
+	for _, approver := range m.Approvers {
+		if approver.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MultisigProposal is the data of an action proposing the unsigned
+// transaction with the hash to a multisig account, to be executed before the
+// block with the expiry number.
+type MultisigProposal struct {
+	TxHash common.Hash `json:"txHash"`
+	Expiry uint64      `json:"expiry"`
+}
+
+// MultisigTx is a proposal of a transaction of a multisig account and the
+// approvers approving it.
+type MultisigTx struct {
+	ID        uint64        `json:"id"`
+	Account   common.Name   `json:"account"`
+	Proposer  common.Name   `json:"proposer"`
+	TxHash    common.Hash   `json:"txHash"`
+	Expiry    uint64        `json:"expiry"` // first block the proposal can't be approved and executed in
+	Approvals []common.Name `json:"approvals"`
+	Executed  bool          `json:"executed"`
+}
+
+// MultisigExecution is the data of an action executing the unsigned
+// transaction of the proposal with the id.
+type MultisigExecution struct {
+	ID uint64
+	Tx *types.Transaction
+}
+
+// GetMultisig returns the authority of the multisig proposals of the
+// account, nil if it isn't a multisig account.
+func (am *AccountManager) GetMultisig(name common.Name) (*Multisig, error) {
+	b, err := am.sdb.Get(name.String(), multisigKey)
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	m := new(Multisig)
+	if err := rlp.DecodeBytes(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetMultisig sets the authority of the multisig proposals of the account,
+// or removes it if the authority is nil. The approvers must be distinct
+// existing accounts able to reach the threshold. Once set, the key of the
+// account no longer signs its actions, they are only executed by its
+// proposals, including the ones changing or removing the authority.
+func (am *AccountManager) SetMultisig(name common.Name, m *Multisig) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	if m == nil {
+		am.sdb.Put(name.String(), multisigKey, nil)
+		return nil
+	}
+	if m.Threshold == 0 || len(m.Approvers) == 0 || len(m.Approvers) > maxMultisigSigners {
+		return ErrMultisigInvalid
+	}
+	seen := make(map[common.Name]bool, len(m.Approvers))
+	var weight uint64
+	for _, approver := range m.Approvers {
+		if approver == nil || approver.Weight == 0 || seen[approver.Name] || weight+approver.Weight < weight {
+			return ErrMultisigInvalid
+		}
+		if ok, err := am.AccountIsExist(approver.Name); err != nil {
+			return err
+		} else if !ok {
+			return ErrAccountNotExist
+		}
+		seen[approver.Name] = true
+		weight += approver.Weight
+	}
+	if weight < m.Threshold {
+		return ErrMultisigInvalid
+	}
+	b, err := rlp.EncodeToBytes(m)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(name.String(), multisigKey, b)
+	return nil
+}
+
+// GetMultisigTx returns the proposal of the multisig account with the given
+// id, nil if there is none.
+func (am *AccountManager) GetMultisigTx(name common.Name, id uint64) (*MultisigTx, error) {
+	b, err := am.sdb.Get(name.String(), multisigTxKey+strconv.FormatUint(id, 10))
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	tx := new(MultisigTx)
+	if err := rlp.DecodeBytes(b, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (am *AccountManager) setMultisigTx(tx *MultisigTx) error {
+	b, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(tx.Account.String(), multisigTxKey+strconv.FormatUint(tx.ID, 10), b)
+	return nil
+}
+
+// multisigApprover returns the authority of the multisig account after
+// checking that the account is one of its approvers.
+func (am *AccountManager) multisigApprover(name common.Name, approver common.Name) (*Multisig, error) {
+	if am.readOnly {
+		return nil, ErrReadOnly
+	}
+	m, err := am.GetMultisig(name)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrNotMultisig
+	}
+	if !m.IsApprover(approver) {
+		return nil, ErrNotApprover
+	}
+	return m, nil
+}
+
+// openMultisigTx returns the proposal of the multisig account with the given
+// id if it can still be approved and executed in the block with the given
+// number.
+func (am *AccountManager) openMultisigTx(name common.Name, id uint64, number uint64) (*MultisigTx, error) {
+	tx, err := am.GetMultisigTx(name, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tx == nil:
+		return nil, ErrMultisigTxNotExist
+	case tx.Executed:
+		return nil, ErrMultisigTxExecuted
+	case number >= tx.Expiry:
+		return nil, ErrMultisigTxExpired
+	}
+	return tx, nil
+}
+
+// ProposeMultisig records a proposal of the approver of a transaction of the
+// multisig account, approved by the proposer.
+func (am *AccountManager) ProposeMultisig(name common.Name, proposer common.Name, proposal *MultisigProposal, number uint64) (*MultisigTx, error) {
+	if _, err := am.multisigApprover(name, proposer); err != nil {
+		return nil, err
+	}
+	if proposal.TxHash == (common.Hash{}) || proposal.Expiry <= number {
+		return nil, ErrMultisigTxInvalid
+	}
+
+	var count uint64
+	b, err := am.sdb.Get(name.String(), multisigTxCountKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 0 {
+		if err := rlp.DecodeBytes(b, &count); err != nil {
+			return nil, err
+		}
+	}
+	tx := &MultisigTx{
+		ID:        count + 1,
+		Account:   name,
+		Proposer:  proposer,
+		TxHash:    proposal.TxHash,
+		Expiry:    proposal.Expiry,
+		Approvals: []common.Name{proposer},
+	}
+	if b, err = rlp.EncodeToBytes(tx.ID); err != nil {
+		return nil, err
+	}
+	am.sdb.Put(name.String(), multisigTxCountKey, b)
+	return tx, am.setMultisigTx(tx)
+}
+
+// ApproveMultisig records the approval of the approver of the proposal of
+// the multisig account with the given id.
+func (am *AccountManager) ApproveMultisig(name common.Name, id uint64, approver common.Name, number uint64) (*MultisigTx, error) {
+	if _, err := am.multisigApprover(name, approver); err != nil {
+		return nil, err
+	}
+	tx, err := am.openMultisigTx(name, id, number)
+	if err != nil {
+		return nil, err
+	}
+	for _, approval := range tx.Approvals {
+		if approval == approver {
+			return nil, ErrMultisigTxApproved
+		}
+	}
+	tx.Approvals = append(tx.Approvals, approver)
+	return tx, am.setMultisigTx(tx)
+}
+
+// ExecuteMultisig marks the proposal of the multisig account with the given
+// id executed, once its approvals weigh the threshold of the current
+// approvers and the hash of the transaction to execute matches it. The
+// caller executes the transaction.
+func (am *AccountManager) ExecuteMultisig(name common.Name, id uint64, txHash common.Hash, number uint64) (*MultisigTx, error) {
+	if am.readOnly {
+		return nil, ErrReadOnly
+	}
+	m, err := am.GetMultisig(name)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrNotMultisig
+	}
+	tx, err := am.openMultisigTx(name, id, number)
+	if err != nil {
+		return nil, err
+	}
+	if tx.TxHash != txHash {
+		return nil, ErrMultisigTxMismatch
+	}
+	if m.Weight(tx.Approvals) < m.Threshold {
+		return nil, ErrMultisigThreshold
+	}
+	tx.Executed = true
+	return tx, am.setMultisigTx(tx)
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestMultisig(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet := common.Name("multiwallet")
+	signers := []common.Name{"multisignera", "multisignerb", "multisignerc"}
+	for _, name := range append([]common.Name{wallet}, signers...) {
+		if err := am.CreateAccount(name, common.PubKey{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := am.SetMultisig(wallet, &Multisig{Threshold: 5, Approvers: []*MultisigApprover{{signers[0], 2}, {signers[1], 2}}}); err != ErrMultisigInvalid {
+		t.Fatalf("unreachable threshold error mismatch: have %v, want %v", err, ErrMultisigInvalid)
+	}
+	if err := am.SetMultisig(wallet, &Multisig{Threshold: 1, Approvers: []*MultisigApprover{{"multinobody", 1}}}); err != ErrAccountNotExist {
+		t.Fatalf("missing approver error mismatch: have %v, want %v", err, ErrAccountNotExist)
+	}
+	proposal := &MultisigProposal{TxHash: common.HexToHash("0x01"), Expiry: 20}
+	if _, err := am.ProposeMultisig(wallet, signers[0], proposal, 10); err != ErrNotMultisig {
+		t.Fatalf("propose error mismatch: have %v, want %v", err, ErrNotMultisig)
+	}
+	m := &Multisig{Threshold: 3, Approvers: []*MultisigApprover{{signers[0], 2}, {signers[1], 1}, {signers[2], 1}}}
+	if err := am.SetMultisig(wallet, m); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := am.ProposeMultisig(wallet, wallet, proposal, 10); err != ErrNotApprover {
+		t.Fatalf("propose error mismatch: have %v, want %v", err, ErrNotApprover)
+	}
+	if _, err := am.ProposeMultisig(wallet, signers[1], &MultisigProposal{TxHash: proposal.TxHash, Expiry: 10}, 10); err != ErrMultisigTxInvalid {
+		t.Fatalf("expired propose error mismatch: have %v, want %v", err, ErrMultisigTxInvalid)
+	}
+	tx, err := am.ProposeMultisig(wallet, signers[1], proposal, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.ID != 1 {
+		t.Fatalf("proposal id %d, want 1", tx.ID)
+	}
+	if _, err := am.ExecuteMultisig(wallet, tx.ID, proposal.TxHash, 11); err != ErrMultisigThreshold {
+		t.Fatalf("execute error mismatch: have %v, want %v", err, ErrMultisigThreshold)
+	}
+	if _, err := am.ApproveMultisig(wallet, tx.ID, signers[1], 11); err != ErrMultisigTxApproved {
+		t.Fatalf("approve error mismatch: have %v, want %v", err, ErrMultisigTxApproved)
+	}
+	if _, err := am.ApproveMultisig(wallet, tx.ID, signers[0], 12); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := am.ExecuteMultisig(wallet, tx.ID, common.HexToHash("0x02"), 12); err != ErrMultisigTxMismatch {
+		t.Fatalf("execute error mismatch: have %v, want %v", err, ErrMultisigTxMismatch)
+	}
+	if _, err := am.ExecuteMultisig(wallet, tx.ID, proposal.TxHash, 20); err != ErrMultisigTxExpired {
+		t.Fatalf("execute error mismatch: have %v, want %v", err, ErrMultisigTxExpired)
+	}
+	if _, err := am.ExecuteMultisig(wallet, tx.ID, proposal.TxHash, 19); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := am.ExecuteMultisig(wallet, tx.ID, proposal.TxHash, 19); err != ErrMultisigTxExecuted {
+		t.Fatalf("execute error mismatch: have %v, want %v", err, ErrMultisigTxExecuted)
+	}
+	stored, err := am.GetMultisigTx(wallet, tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Executed || len(stored.Approvals) != 2 {
+		t.Fatalf("stored proposal mismatch: %+v", stored)
+	}
+
+	// Approvals of removed approvers don't count.
+	tx, err = am.ProposeMultisig(wallet, signers[0], &MultisigProposal{TxHash: proposal.TxHash, Expiry: 40}, 30)
+	if err != nil || tx.ID != 2 {
+		t.Fatalf("second proposal mismatch: %v %v", tx, err)
+	}
+	if _, err := am.ApproveMultisig(wallet, tx.ID, signers[2], 31); err != nil {
+		t.Fatal(err)
+	}
+	m = &Multisig{Threshold: 2, Approvers: []*MultisigApprover{{signers[1], 1}, {signers[2], 1}}}
+	if err := am.SetMultisig(wallet, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := am.ExecuteMultisig(wallet, tx.ID, proposal.TxHash, 32); err != ErrMultisigThreshold {
+		t.Fatalf("execute error mismatch: have %v, want %v", err, ErrMultisigThreshold)
+	}
+}
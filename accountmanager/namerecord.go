@@ -0,0 +1,126 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var nameRecordsKey = "NameRecords"
+
+const (
+	// MaxNameRecords is the number of records an account may publish.
+	MaxNameRecords = 16
+	// MaxNameRecordValue is the length of the value of a record in bytes.
+	MaxNameRecordValue = 256
+)
+
+// nameRecordType matches the types of the records, such as "btc" or
+// "profile.hash".
+var nameRecordType = regexp.MustCompile("^[a-z0-9][a-z0-9.-]{0,31}$")
+
+// NameRecord is a typed record an account publishes under its name, such as
+// its payment address on another chain or the hash of its public profile.
+type NameRecord struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// GetNameRecords returns the records published by the account in type order.
+func (am *AccountManager) GetNameRecords(name common.Name) ([]*NameRecord, error) {
+	b, err := am.sdb.Get(name.String(), nameRecordsKey)
+	if err != nil {
+		return nil, err
+	}
+	records := []*NameRecord{}
+	if len(b) == 0 {
+		return records, nil
+	}
+	if err := rlp.DecodeBytes(b, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// GetNameRecord returns the record of the type published by the account, nil
+// if there is none.
+func (am *AccountManager) GetNameRecord(name common.Name, recordType string) (*NameRecord, error) {
+	records, err := am.GetNameRecords(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Type == recordType {
+			return record, nil
+		}
+	}
+	return nil, nil
+}
+
+// SetNameRecord publishes the record under the account name, replacing the
+// record of the same type. A record with an empty value removes it.
+func (am *AccountManager) SetNameRecord(name common.Name, record *NameRecord) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	if !nameRecordType.MatchString(record.Type) || len(record.Value) > MaxNameRecordValue {
+		return ErrNameRecordInvalid
+	}
+	acct, err := am.GetAccountByName(name)
+	if err != nil {
+		return err
+	}
+	if acct == nil {
+		return ErrAccountNotExist
+	}
+	records, err := am.GetNameRecords(name)
+	if err != nil {
+		return err
+	}
+
+	i := sort.Search(len(records), func(i int) bool { return records[i].Type >= record.Type })
+	exists := i < len(records) && records[i].Type == record.Type
+	switch {
+	case record.Value == "" && exists:
+		records = append(records[:i], records[i+1:]...)
+	case record.Value == "":
+		return nil
+	case exists:
+		records[i] = record
+	case len(records) >= MaxNameRecords:
+		return ErrTooManyNameRecords
+	default:
+		records = append(records, nil)
+		copy(records[i+1:], records[i:])
+		records[i] = record
+	}
+
+	if len(records) == 0 {
+		am.sdb.Put(name.String(), nameRecordsKey, nil)
+		return nil
+	}
+	b, err := rlp.EncodeToBytes(records)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(name.String(), nameRecordsKey, b)
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+func TestNameRecords(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := common.Name("recorduser")
+	if err := am.SetNameRecord(user, &NameRecord{Type: "btc", Value: "1abc"}); err != ErrAccountNotExist {
+		t.Fatalf("set error mismatch: have %v, want %v", err, ErrAccountNotExist)
+	}
+	if err := am.CreateAccount(user, common.PubKey{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []*NameRecord{
+		{Type: "", Value: "1abc"},
+		{Type: "BTC", Value: "1abc"},
+		{Type: "btc", Value: strings.Repeat("a", MaxNameRecordValue+1)},
+	} {
+		if err := am.SetNameRecord(user, record); err != ErrNameRecordInvalid {
+			t.Fatalf("set %v error mismatch: have %v, want %v", record, err, ErrNameRecordInvalid)
+		}
+	}
+
+	// records are kept in type order, setting a type again replaces it
+	for _, record := range []*NameRecord{{"profile", "0x01"}, {"btc", "1abc"}, {"eth", "0xabc"}, {"btc", "1def"}} {
+		if err := am.SetNameRecord(user, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := am.GetNameRecords(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("record count mismatch: have %d, want 3", len(records))
+	}
+	if have := fmt.Sprint(records[0], records[1], records[2]); have != "&{btc 1def} &{eth 0xabc} &{profile 0x01}" {
+		t.Fatalf("records mismatch: have %v", have)
+	}
+	if record, err := am.GetNameRecord(user, "eth"); err != nil || record == nil || record.Value != "0xabc" {
+		t.Fatalf("record mismatch: have %v (%v)", record, err)
+	}
+
+	// an empty value removes the record
+	if err := am.SetNameRecord(user, &NameRecord{Type: "eth"}); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := am.GetNameRecord(user, "eth"); err != nil || record != nil {
+		t.Fatalf("removed record returned: %v (%v)", record, err)
+	}
+
+	for i := 2; i < MaxNameRecords; i++ {
+		if err := am.SetNameRecord(user, &NameRecord{Type: fmt.Sprintf("chain%d", i), Value: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := am.SetNameRecord(user, &NameRecord{Type: "onemore", Value: "x"}); err != ErrTooManyNameRecords {
+		t.Fatalf("set error mismatch: have %v, want %v", err, ErrTooManyNameRecords)
+	}
+
+	// records are published by the sender of the action
+	data, _ := rlp.EncodeToBytes(&NameRecord{Type: "btc", Value: "1xyz"})
+	action := types.NewAction(types.SetNameRecord, user, "", 0, 0, 0, big.NewInt(0), data)
+	if err := am.Process(action); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := am.GetNameRecord(user, "btc"); err != nil || record == nil || record.Value != "1xyz" {
+		t.Fatalf("record mismatch: have %v (%v)", record, err)
+	}
+}
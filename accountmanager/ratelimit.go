@@ -0,0 +1,145 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var (
+	rateLimitOwner = "sysAccount"
+	rateLimitKey   = "RateLimit"
+	rateUsageKey   = "RateUsage"
+)
+
+// RateLimit bounds the actions an account may send in a window of blocks, so
+// that a single account can't fill the blocks of a chain without fees. The
+// windows are aligned to multiples of Window, a zero window is one block.
+// System accounts aren't limited.
+type RateLimit struct {
+	MaxActions uint64 `json:"maxActions"`
+	Window     uint64 `json:"window"`
+}
+
+// rateUsage counts the actions an account sent in the window starting at
+// Start.
+type rateUsage struct {
+	Start   uint64
+	Actions uint64
+}
+
+// GetRateLimit returns the limit of the actions of an account, nil if the
+// actions aren't limited.
+func (am *AccountManager) GetRateLimit() (*RateLimit, error) {
+	b, err := am.sdb.Get(rateLimitOwner, rateLimitKey)
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	limit := new(RateLimit)
+	if err := rlp.DecodeBytes(b, limit); err != nil {
+		return nil, err
+	}
+	return limit, nil
+}
+
+// SetRateLimit sets the limit of the actions of an account, or removes it if
+// the limit is nil or allows no actions.
+func (am *AccountManager) SetRateLimit(limit *RateLimit) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	if limit == nil || limit.MaxActions == 0 {
+		am.sdb.Put(rateLimitOwner, rateLimitKey, nil)
+		return nil
+	}
+	b, err := rlp.EncodeToBytes(limit)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(rateLimitOwner, rateLimitKey, b)
+	return nil
+}
+
+// rateUsage returns the limit of the actions of the account and the actions
+// it sent in the window of the block with the given number, a nil limit if
+// its actions aren't limited.
+func (am *AccountManager) rateUsage(name common.Name, number uint64) (*RateLimit, *rateUsage, error) {
+	limit, err := am.GetRateLimit()
+	if err != nil || limit == nil {
+		return nil, nil, err
+	}
+	if system, err := am.IsSystemAccount(name); err != nil || system {
+		return nil, nil, err
+	}
+
+	start := number
+	if limit.Window > 1 {
+		start -= number % limit.Window
+	}
+	usage := new(rateUsage)
+	b, err := am.sdb.Get(name.String(), rateUsageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(b) != 0 {
+		if err := rlp.DecodeBytes(b, usage); err != nil {
+			return nil, nil, err
+		}
+	}
+	if usage.Start != start {
+		usage = &rateUsage{Start: start}
+	}
+	return limit, usage, nil
+}
+
+// RemainingActions returns the actions the account may still send in the
+// window of the block with the given number, and false if its actions aren't
+// limited.
+func (am *AccountManager) RemainingActions(name common.Name, number uint64) (uint64, bool, error) {
+	limit, usage, err := am.rateUsage(name, number)
+	if err != nil || limit == nil {
+		return 0, false, err
+	}
+	if usage.Actions >= limit.MaxActions {
+		return 0, true, nil
+	}
+	return limit.MaxActions - usage.Actions, true, nil
+}
+
+// UseRateLimit counts an action of the account in the block with the given
+// number, failing with ErrRateLimited if the account already sent the most
+// actions of the window.
+func (am *AccountManager) UseRateLimit(name common.Name, number uint64) error {
+	limit, usage, err := am.rateUsage(name, number)
+	if err != nil || limit == nil {
+		return err
+	}
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	if usage.Actions >= limit.MaxActions {
+		return ErrRateLimited
+	}
+	usage.Actions++
+	b, err := rlp.EncodeToBytes(usage)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(name.String(), rateUsageKey, b)
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestRateLimit(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := common.Name("ratelimituser")
+	if err := am.UseRateLimit(user, 1); err != nil {
+		t.Fatalf("unlimited action failed: %v", err)
+	}
+
+	if err := am.SetRateLimit(&RateLimit{MaxActions: 2, Window: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if limit, err := am.GetRateLimit(); err != nil || limit.MaxActions != 2 || limit.Window != 10 {
+		t.Fatalf("limit %+v (%v), want 2 actions in 10 blocks", limit, err)
+	}
+	for _, number := range []uint64{10, 15} {
+		if err := am.UseRateLimit(user, number); err != nil {
+			t.Fatalf("action in block %d failed: %v", number, err)
+		}
+	}
+	if err := am.UseRateLimit(user, 19); err != ErrRateLimited {
+		t.Fatalf("third action of the window error mismatch: have %v, want %v", err, ErrRateLimited)
+	}
+	if remaining, limited, err := am.RemainingActions(user, 19); err != nil || !limited || remaining != 0 {
+		t.Fatalf("remaining actions %d %v (%v), want 0 limited", remaining, limited, err)
+	}
+	if remaining, limited, err := am.RemainingActions(user, 20); err != nil || !limited || remaining != 2 {
+		t.Fatalf("remaining actions of the next window %d %v (%v), want 2 limited", remaining, limited, err)
+	}
+	if err := am.UseRateLimit(common.Name("ratelimitother"), 19); err != nil {
+		t.Fatalf("action of another account failed: %v", err)
+	}
+	if err := am.UseRateLimit(user, 20); err != nil {
+		t.Fatalf("action in the next window failed: %v", err)
+	}
+
+	// system accounts aren't limited
+	if err := am.CreateAccount(common.Name("ratesysacct"), common.PubKey{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.SetReservedPrefix("ratesys"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := am.UseRateLimit(common.Name("ratesysacct"), 30); err != nil {
+			t.Fatalf("system action %d failed: %v", i, err)
+		}
+	}
+
+	if err := am.SetRateLimit(nil); err != nil {
+		t.Fatal(err)
+	}
+	if limit, err := am.GetRateLimit(); err != nil || limit != nil {
+		t.Fatalf("removed limit %+v (%v), want nil", limit, err)
+	}
+	if _, limited, err := am.RemainingActions(user, 21); err != nil || limited {
+		t.Fatalf("actions limited after the limit was removed (%v)", err)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var rentInfoPrefix = "RentInfo"
+
+// RentInfo is the storage rent state of an account. It is kept apart from the
+// account object, so accounts which never held code are stored unchanged.
+type RentInfo struct {
+	StorageSize uint64 // bytes of persistent contract storage
+	RentPaid    uint64 // block number up to which the rent is paid
+	Hibernated  bool   // set when the account couldn't pay its rent
+}
+
+// GetRentInfo returns the storage rent state of the account.
+func (am *AccountManager) GetRentInfo(name common.Name) (*RentInfo, error) {
+	b, err := am.sdb.Get(name.String(), rentInfoPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var info RentInfo
+	if len(b) == 0 {
+		return &info, nil
+	}
+	if err := rlp.DecodeBytes(b, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (am *AccountManager) setRentInfo(name common.Name, info *RentInfo) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	b, err := rlp.EncodeToBytes(info)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(name.String(), rentInfoPrefix, b)
+	return nil
+}
+
+// IsHibernated reports whether the account is hibernated for unpaid rent.
+func (am *AccountManager) IsHibernated(name common.Name) (bool, error) {
+	info, err := am.GetRentInfo(name)
+	if err != nil {
+		return false, err
+	}
+	return info.Hibernated, nil
+}
+
+// SetRentPaid marks the rent of the account as paid up to block number.
+func (am *AccountManager) SetRentPaid(name common.Name, number uint64) error {
+	info, err := am.GetRentInfo(name)
+	if err != nil {
+		return err
+	}
+	info.RentPaid = number
+	return am.setRentInfo(name, info)
+}
+
+// AddStorageSize changes the storage size of the account by delta bytes.
+func (am *AccountManager) AddStorageSize(name common.Name, delta int64) error {
+	info, err := am.GetRentInfo(name)
+	if err != nil {
+		return err
+	}
+	if delta < 0 && uint64(-delta) > info.StorageSize {
+		info.StorageSize = 0
+	} else {
+		info.StorageSize = uint64(int64(info.StorageSize) + delta)
+	}
+	return am.setRentInfo(name, info)
+}
+
+// RentBytes returns the number of bytes the account pays storage rent for.
+func (am *AccountManager) RentBytes(name common.Name) (uint64, error) {
+	acct, err := am.GetAccountByName(name)
+	if err != nil {
+		return 0, err
+	}
+	if acct == nil {
+		return 0, ErrAccountNotExist
+	}
+	info, err := am.GetRentInfo(name)
+	if err != nil {
+		return 0, err
+	}
+	return acct.GetCodeSize() + info.StorageSize, nil
+}
+
+// RentDue returns the rent the account owes for its code and storage for the
+// blocks after its rent was paid up to number, at price per byte and block.
+func (am *AccountManager) RentDue(name common.Name, number uint64, price *big.Int) (*big.Int, error) {
+	acct, err := am.GetAccountByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrAccountNotExist
+	}
+	info, err := am.GetRentInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	if price == nil || number <= info.RentPaid {
+		return new(big.Int), nil
+	}
+	due := new(big.Int).SetUint64(acct.GetCodeSize() + info.StorageSize)
+	due.Mul(due, new(big.Int).SetUint64(number-info.RentPaid))
+	return due.Mul(due, price), nil
+}
+
+// ChargeRent collects the storage rent of the account up to block number and
+// pays it in assetID to payee. An account which can't pay is hibernated and
+// ErrAccountHibernated is returned, like for accounts hibernated before.
+func (am *AccountManager) ChargeRent(name common.Name, number uint64, price *big.Int, assetID uint64, payee common.Name) error {
+	info, err := am.GetRentInfo(name)
+	if err != nil {
+		return err
+	}
+	if info.Hibernated {
+		return ErrAccountHibernated
+	}
+	due, err := am.RentDue(name, number, price)
+	if err != nil {
+		return err
+	}
+	if due.Sign() > 0 {
+		balance, err := am.GetAccountBalanceByID(name, assetID)
+		if err != nil || balance.Cmp(due) < 0 {
+			info.Hibernated = true
+			if err := am.setRentInfo(name, info); err != nil {
+				return err
+			}
+			return ErrAccountHibernated
+		}
+		if err := am.payRent(name, payee, assetID, due); err != nil {
+			return err
+		}
+	}
+	if info.RentPaid == number {
+		return nil
+	}
+	info.RentPaid = number
+	return am.setRentInfo(name, info)
+}
+
+// ReviveAccount pays the outstanding rent of a hibernated account from payer
+// up to block number and wakes the account up again.
+func (am *AccountManager) ReviveAccount(payer, name common.Name, number uint64, price *big.Int, assetID uint64, payee common.Name) error {
+	info, err := am.GetRentInfo(name)
+	if err != nil {
+		return err
+	}
+	if !info.Hibernated {
+		return ErrAccountNotHibernated
+	}
+	due, err := am.RentDue(name, number, price)
+	if err != nil {
+		return err
+	}
+	if due.Sign() > 0 {
+		if err := am.payRent(payer, payee, assetID, due); err != nil {
+			return err
+		}
+	}
+	info.Hibernated = false
+	info.RentPaid = number
+	return am.setRentInfo(name, info)
+}
+
+// payRent transfers the rent to payee, or burns it if there is no payee.
+func (am *AccountManager) payRent(from, payee common.Name, assetID uint64, amount *big.Int) error {
+	if ok, _ := am.AccountIsExist(payee); ok {
+		return am.TransferAsset(from, payee, assetID, amount)
+	}
+	return am.SubAccountBalanceByID(from, assetID, amount)
+}
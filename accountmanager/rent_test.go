@@ -0,0 +1,80 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestStorageRent(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract, payer, payee := common.Name("rentcontract"), common.Name("rentpayer"), common.Name("rentpayee")
+	for _, name := range []common.Name{contract, payer, payee} {
+		if err := am.CreateAccount(name, common.PubKey{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const assetID = 1
+	price := big.NewInt(2)
+	am.AddAccountBalanceByID(contract, assetID, big.NewInt(1000))
+	am.AddAccountBalanceByID(payer, assetID, big.NewInt(1000))
+	if err := am.AddStorageSize(contract, 10); err != nil {
+		t.Fatal(err)
+	}
+	balance := func(name common.Name) int64 {
+		b, _ := am.GetAccountBalanceByID(name, assetID)
+		return b.Int64()
+	}
+
+	// 10 bytes for 20 blocks at 2 per byte and block.
+	if err := am.ChargeRent(contract, 20, price, assetID, payee); err != nil {
+		t.Fatal(err)
+	}
+	if balance(contract) != 600 || balance(payee) != 400 {
+		t.Fatalf("balances mismatch: contract %d, payee %d", balance(contract), balance(payee))
+	}
+	// Charging again in the same block is free.
+	if err := am.ChargeRent(contract, 20, price, assetID, payee); err != nil || balance(contract) != 600 {
+		t.Fatalf("charged twice: err %v, balance %d", err, balance(contract))
+	}
+	// The contract can't pay for another 40 blocks.
+	if err := am.ChargeRent(contract, 60, price, assetID, payee); err != ErrAccountHibernated {
+		t.Fatalf("charge error mismatch: have %v, want %v", err, ErrAccountHibernated)
+	}
+	if err := am.ChargeRent(contract, 61, price, assetID, payee); err != ErrAccountHibernated {
+		t.Fatalf("hibernated account charged: %v", err)
+	}
+	// The payer covers the rent since block 20.
+	if err := am.ReviveAccount(payer, contract, 70, price, assetID, payee); err != nil {
+		t.Fatal(err)
+	}
+	if balance(payer) != 0 || balance(contract) != 600 || balance(payee) != 1400 {
+		t.Fatalf("balances mismatch: payer %d, contract %d, payee %d", balance(payer), balance(contract), balance(payee))
+	}
+	if info, _ := am.GetRentInfo(contract); info.Hibernated || info.RentPaid != 70 {
+		t.Fatalf("account not revived: hibernated %v, rent paid %d", info.Hibernated, info.RentPaid)
+	}
+	if err := am.ReviveAccount(payer, contract, 71, price, assetID, payee); err != ErrAccountNotHibernated {
+		t.Fatalf("revive error mismatch: have %v, want %v", err, ErrAccountNotHibernated)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var resourceKey = "Resource"
+
+// Resource is the system token an account stakes for a gas quota and the gas
+// it used in the current window of the quota. The staked amount is held by
+// the record until it is unstaked.
+type Resource struct {
+	Staked *big.Int `json:"staked"`
+	Start  uint64   `json:"start"` // first block of the window of Used
+	Used   uint64   `json:"used"`
+}
+
+// ResourceUsage is the gas quota of an account in a window.
+type ResourceUsage struct {
+	Staked    *big.Int `json:"staked"`
+	Quota     uint64   `json:"quota"`
+	Used      uint64   `json:"used"`
+	Available uint64   `json:"available"`
+}
+
+func (am *AccountManager) getResource(name common.Name) (*Resource, error) {
+	res := &Resource{Staked: new(big.Int)}
+	b, err := am.sdb.Get(name.String(), resourceKey)
+	if err != nil || len(b) == 0 {
+		return res, err
+	}
+	if err := rlp.DecodeBytes(b, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (am *AccountManager) setResource(name common.Name, res *Resource) error {
+	if res.Staked.Sign() == 0 && res.Used == 0 {
+		am.sdb.Put(name.String(), resourceKey, nil)
+		return nil
+	}
+	b, err := rlp.EncodeToBytes(res)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(name.String(), resourceKey, b)
+	return nil
+}
+
+// GetResourceUsage returns the gas quota of the account in the window of the
+// block with the given number.
+func (am *AccountManager) GetResourceUsage(name common.Name, number uint64, cfg *params.ResourceConfig) (*ResourceUsage, error) {
+	res, err := am.getResource(name)
+	if err != nil {
+		return nil, err
+	}
+	usage := &ResourceUsage{Staked: res.Staked, Quota: cfg.Quota(res.Staked)}
+	if cfg != nil && res.Start == cfg.WindowStart(number) {
+		usage.Used = res.Used
+	}
+	if usage.Used < usage.Quota {
+		usage.Available = usage.Quota - usage.Used
+	}
+	return usage, nil
+}
+
+// StakeResource moves the amount of the system token from the balance of the
+// account to its stake.
+func (am *AccountManager) StakeResource(name common.Name, assetID uint64, amount *big.Int) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	if amount == nil || amount.Sign() <= 0 {
+		return ErrAmountValueInvalid
+	}
+	res, err := am.getResource(name)
+	if err != nil {
+		return err
+	}
+	if err := am.SubAccountBalanceByID(name, assetID, amount); err != nil {
+		return err
+	}
+	res.Staked.Add(res.Staked, amount)
+	return am.setResource(name, res)
+}
+
+// UnstakeResource moves the amount of the system token from the stake of the
+// account back to its balance in the block with the given number. The stake
+// backing the gas used in the window of the block stays staked until the
+// window ends, so the unstaked amount can't be staked again for more gas.
+func (am *AccountManager) UnstakeResource(name common.Name, assetID uint64, amount *big.Int, number uint64, cfg *params.ResourceConfig) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	if amount == nil || amount.Sign() <= 0 {
+		return ErrAmountValueInvalid
+	}
+	res, err := am.getResource(name)
+	if err != nil {
+		return err
+	}
+	if res.Staked.Cmp(amount) < 0 {
+		return ErrInsufficientStake
+	}
+	res.Staked.Sub(res.Staked, amount)
+	if res.Start == cfg.WindowStart(number) && res.Used > cfg.Quota(res.Staked) {
+		return ErrResourceInUse
+	}
+	if err := am.setResource(name, res); err != nil {
+		return err
+	}
+	return am.AddAccountBalanceByID(name, assetID, amount)
+}
+
+// RefundResource returns unused gas taken by UseResource in the window of the
+// block with the given number to the quota of the account.
+func (am *AccountManager) RefundResource(name common.Name, number, gas uint64, cfg *params.ResourceConfig) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	res, err := am.getResource(name)
+	if err != nil {
+		return err
+	}
+	if res.Start != cfg.WindowStart(number) {
+		return nil
+	}
+	if res.Used < gas {
+		gas = res.Used
+	}
+	res.Used -= gas
+	return am.setResource(name, res)
+}
+
+// UseResource takes the gas from the quota of the account in the window of
+// the block with the given number, failing with ErrResourceExhausted if the
+// quota left is lower.
+func (am *AccountManager) UseResource(name common.Name, number, gas uint64, cfg *params.ResourceConfig) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	usage, err := am.GetResourceUsage(name, number, cfg)
+	if err != nil {
+		return err
+	}
+	if usage.Available < gas {
+		return ErrResourceExhausted
+	}
+	return am.setResource(name, &Resource{
+		Staked: usage.Staked,
+		Start:  cfg.WindowStart(number),
+		Used:   usage.Used + gas,
+	})
+}
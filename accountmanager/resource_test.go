@@ -0,0 +1,102 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+)
+
+func TestResourceStaking(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := common.Name("resourceuser")
+	if err := am.CreateAccount(user, common.PubKey{}); err != nil {
+		t.Fatal(err)
+	}
+	const assetID = 1
+	am.AddAccountBalanceByID(user, assetID, big.NewInt(1000))
+	cfg := &params.ResourceConfig{Unit: big.NewInt(100), GasPerUnit: 1000, Window: 10}
+	available := func(number uint64) uint64 {
+		usage, err := am.GetResourceUsage(user, number, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return usage.Available
+	}
+
+	if err := am.UseResource(user, 10, 1, cfg); err != ErrResourceExhausted {
+		t.Fatalf("use error mismatch: have %v, want %v", err, ErrResourceExhausted)
+	}
+	if err := am.StakeResource(user, assetID, big.NewInt(2000)); err == nil {
+		t.Fatal("staked more than the balance")
+	}
+	if err := am.StakeResource(user, assetID, big.NewInt(350)); err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := am.GetAccountBalanceByID(user, assetID); balance.Int64() != 650 {
+		t.Fatalf("balance %v after staking, want 650", balance)
+	}
+	if got := available(10); got != 3000 {
+		t.Fatalf("quota %d, want 3000", got)
+	}
+
+	// The gas taken up front is partly refunded after execution.
+	if err := am.UseResource(user, 12, 2500, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.RefundResource(user, 12, 500, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := available(15); got != 1000 {
+		t.Fatalf("quota left %d, want 1000", got)
+	}
+	if err := am.UseResource(user, 19, 1001, cfg); err != ErrResourceExhausted {
+		t.Fatalf("use error mismatch: have %v, want %v", err, ErrResourceExhausted)
+	}
+	if got := available(20); got != 3000 {
+		t.Fatalf("renewed quota %d, want 3000", got)
+	}
+
+	if err := am.UnstakeResource(user, assetID, big.NewInt(400), 20, cfg); err != ErrInsufficientStake {
+		t.Fatalf("unstake error mismatch: have %v, want %v", err, ErrInsufficientStake)
+	}
+	// The stake backing the gas used in the window is kept until it ends.
+	if err := am.UseResource(user, 25, 2000, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.UnstakeResource(user, assetID, big.NewInt(200), 29, cfg); err != ErrResourceInUse {
+		t.Fatalf("unstake error mismatch: have %v, want %v", err, ErrResourceInUse)
+	}
+	if err := am.UnstakeResource(user, assetID, big.NewInt(100), 29, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.UnstakeResource(user, assetID, big.NewInt(250), 30, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := am.GetAccountBalanceByID(user, assetID); balance.Int64() != 1000 {
+		t.Fatalf("balance %v after unstaking, want 1000", balance)
+	}
+	if got := available(15); got != 0 {
+		t.Fatalf("quota %d after unstaking, want 0", got)
+	}
+}
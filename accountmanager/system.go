@@ -0,0 +1,56 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"strings"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+var (
+	reservedPrefixOwner = "sysAccount"
+	reservedPrefixKey   = "ReservedPrefix"
+)
+
+// SetReservedPrefix reserves the names starting with prefix for system
+// accounts, which are only created at genesis.
+func (am *AccountManager) SetReservedPrefix(prefix string) error {
+	if am.readOnly {
+		return ErrReadOnly
+	}
+	am.sdb.Put(reservedPrefixOwner, reservedPrefixKey, []byte(prefix))
+	return nil
+}
+
+// IsReservedName reports whether the name is reserved for system accounts.
+func (am *AccountManager) IsReservedName(name common.Name) (bool, error) {
+	prefix, err := am.sdb.Get(reservedPrefixOwner, reservedPrefixKey)
+	if err != nil {
+		return false, err
+	}
+	return len(prefix) != 0 && strings.HasPrefix(name.String(), string(prefix)), nil
+}
+
+// IsSystemAccount reports whether the account is an existing system account.
+func (am *AccountManager) IsSystemAccount(name common.Name) (bool, error) {
+	reserved, err := am.IsReservedName(name)
+	if err != nil || !reserved {
+		return false, err
+	}
+	return am.AccountIsExist(name)
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/types"
+)
+
+func TestReservedNames(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, pool := common.Name("reserveduser"), common.Name("ftsystemfee")
+	for _, name := range []common.Name{user, pool} {
+		if err := am.CreateAccount(name, common.PubKey{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := am.SetReservedPrefix("ftsystem"); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(name common.Name) error {
+		return am.Process(types.NewAction(types.CreateAccount, user, name, 0, 1, 0, big.NewInt(0), nil))
+	}
+	if err := create("ftsystemnew"); err != ErrReservedName {
+		t.Fatalf("create error mismatch: have %v, want %v", err, ErrReservedName)
+	}
+	if ok, _ := am.AccountIsExist("ftsystemnew"); ok {
+		t.Fatal("reserved account created")
+	}
+	if err := create("systemnew"); err != nil {
+		t.Fatal(err)
+	}
+	// no other path creates a reserved account either
+	if err := am.CreateAccount("ftsystemvm", common.PubKey{}); err != ErrReservedName {
+		t.Fatalf("create error mismatch: have %v, want %v", err, ErrReservedName)
+	}
+	cfg := &params.NameAuctionConfig{MaxLength: 12}
+	if err := am.BidName(user, "ftsystemab", 1, big.NewInt(0), 1, cfg); err != ErrReservedName {
+		t.Fatalf("bid error mismatch: have %v, want %v", err, ErrReservedName)
+	}
+
+	for name, want := range map[common.Name]bool{pool: true, user: false, "ftsystemnew": false} {
+		if ok, err := am.IsSystemAccount(name); err != nil || ok != want {
+			t.Errorf("%v: system account %v (%v), want %v", name, ok, err, want)
+		}
+	}
+}
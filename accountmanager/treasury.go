@@ -0,0 +1,204 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"strconv"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var (
+	treasuryOwner      = "sysAccount"
+	withdrawalKey      = "Withdrawal"
+	withdrawalCountKey = "WithdrawalCount"
+	maxWithdrawalMemo  = 256
+)
+
+// WithdrawalProposal is the data of an action proposing to pay an amount of
+// an asset from the fee pool to the recipient.
+type WithdrawalProposal struct {
+	Recipient common.Name `json:"recipient"`
+	AssetID   uint64      `json:"assetID"`
+	Amount    *big.Int    `json:"amount"`
+	Memo      string      `json:"memo"`
+}
+
+// Withdrawal is a proposal to withdraw from the fee pool and its approvals.
+// It can be executed until its deadline once a quorum of the activated
+// producers approved it.
+type Withdrawal struct {
+	ID        uint64        `json:"id"`
+	Proposer  common.Name   `json:"proposer"`
+	Recipient common.Name   `json:"recipient"`
+	AssetID   uint64        `json:"assetID"`
+	Amount    *big.Int      `json:"amount"`
+	Memo      string        `json:"memo"`
+	Deadline  uint64        `json:"deadline"` // last block the proposal can be approved and executed in
+	Approvals []common.Name `json:"approvals"`
+	Executed  bool          `json:"executed"`
+}
+
+// Approved returns how many of the producers approved the withdrawal.
+func (w *Withdrawal) Approved(producers []string) int {
+	approved := make(map[common.Name]struct{}, len(w.Approvals))
+	for _, name := range w.Approvals {
+		approved[name] = struct{}{}
+	}
+	n := 0
+	for _, producer := range producers {
+		if _, ok := approved[common.Name(producer)]; ok {
+			delete(approved, common.Name(producer))
+			n++
+		}
+	}
+	return n
+}
+
+// WithdrawalQuorum returns how many of the producers must approve a
+// withdrawal before it can be executed. A producer holding several slots of
+// the schedule counts once, like its approval.
+func WithdrawalQuorum(producers []string) int {
+	distinct := make(map[string]struct{}, len(producers))
+	for _, producer := range producers {
+		distinct[producer] = struct{}{}
+	}
+	return len(distinct)*2/3 + 1
+}
+
+// GetWithdrawal returns the withdrawal proposal with the given id, nil if
+// there is none.
+func (am *AccountManager) GetWithdrawal(id uint64) (*Withdrawal, error) {
+	b, err := am.sdb.Get(treasuryOwner, withdrawalKey+strconv.FormatUint(id, 10))
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	w := new(Withdrawal)
+	if err := rlp.DecodeBytes(b, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (am *AccountManager) setWithdrawal(w *Withdrawal) error {
+	b, err := rlp.EncodeToBytes(w)
+	if err != nil {
+		return err
+	}
+	am.sdb.Put(treasuryOwner, withdrawalKey+strconv.FormatUint(w.ID, 10), b)
+	return nil
+}
+
+// openWithdrawal returns the withdrawal proposal with the given id if it can
+// still be approved and executed in the block with the given number.
+func (am *AccountManager) openWithdrawal(id uint64, number uint64) (*Withdrawal, error) {
+	if am.readOnly {
+		return nil, ErrReadOnly
+	}
+	w, err := am.GetWithdrawal(id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case w == nil:
+		return nil, ErrWithdrawalNotExist
+	case w.Executed:
+		return nil, ErrWithdrawalExecuted
+	case number > w.Deadline:
+		return nil, ErrWithdrawalExpired
+	}
+	return w, nil
+}
+
+// ProposeWithdrawal records a proposal of the producer to withdraw from the
+// fee pool, approved by the proposer, which stays open for the duration in
+// blocks after the block with the given number.
+func (am *AccountManager) ProposeWithdrawal(proposer common.Name, proposal *WithdrawalProposal, number uint64, duration uint64) (*Withdrawal, error) {
+	if am.readOnly {
+		return nil, ErrReadOnly
+	}
+	if proposal.Amount == nil || proposal.Amount.Sign() <= 0 || len(proposal.Memo) > maxWithdrawalMemo {
+		return nil, ErrWithdrawalInvalid
+	}
+	if ok, err := am.AccountIsExist(proposal.Recipient); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrAccountNotExist
+	}
+
+	var count uint64
+	b, err := am.sdb.Get(treasuryOwner, withdrawalCountKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 0 {
+		if err := rlp.DecodeBytes(b, &count); err != nil {
+			return nil, err
+		}
+	}
+	w := &Withdrawal{
+		ID:        count + 1,
+		Proposer:  proposer,
+		Recipient: proposal.Recipient,
+		AssetID:   proposal.AssetID,
+		Amount:    new(big.Int).Set(proposal.Amount),
+		Memo:      proposal.Memo,
+		Deadline:  number + duration,
+		Approvals: []common.Name{proposer},
+	}
+	if b, err = rlp.EncodeToBytes(w.ID); err != nil {
+		return nil, err
+	}
+	am.sdb.Put(treasuryOwner, withdrawalCountKey, b)
+	return w, am.setWithdrawal(w)
+}
+
+// ApproveWithdrawal records the approval of the producer of the withdrawal
+// proposal with the given id.
+func (am *AccountManager) ApproveWithdrawal(id uint64, producer common.Name, number uint64) (*Withdrawal, error) {
+	w, err := am.openWithdrawal(id, number)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range w.Approvals {
+		if name == producer {
+			return nil, ErrWithdrawalApproved
+		}
+	}
+	w.Approvals = append(w.Approvals, producer)
+	return w, am.setWithdrawal(w)
+}
+
+// ExecuteWithdrawal pays the withdrawal proposal with the given id from the
+// pool once a quorum of the producers approved it. Approvals of accounts no
+// longer among the producers don't count.
+func (am *AccountManager) ExecuteWithdrawal(id uint64, pool common.Name, number uint64, producers []string) (*Withdrawal, error) {
+	w, err := am.openWithdrawal(id, number)
+	if err != nil {
+		return nil, err
+	}
+	if w.Approved(producers) < WithdrawalQuorum(producers) {
+		return nil, ErrWithdrawalQuorum
+	}
+	if err := am.TransferAsset(pool, w.Recipient, w.AssetID, w.Amount); err != nil {
+		return nil, err
+	}
+	w.Executed = true
+	return w, am.setWithdrawal(w)
+}
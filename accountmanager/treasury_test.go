@@ -0,0 +1,105 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package accountmanager
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestWithdrawal(t *testing.T) {
+	am, err := NewAccountManager(getStateDB())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, recipient := common.Name("treasurypool"), common.Name("treasuryuser")
+	for _, name := range []common.Name{pool, recipient} {
+		if err := am.CreateAccount(name, common.PubKey{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const assetID = 1
+	am.AddAccountBalanceByID(pool, assetID, big.NewInt(1000))
+	producers := []string{"producera", "producerb", "producerc", "producerd"}
+
+	proposal := &WithdrawalProposal{Recipient: recipient, AssetID: assetID, Amount: big.NewInt(400), Memo: "grant"}
+	if _, err := am.ProposeWithdrawal("producera", &WithdrawalProposal{Recipient: "treasurynone", AssetID: assetID, Amount: big.NewInt(1)}, 10, 5); err != ErrAccountNotExist {
+		t.Fatalf("propose error mismatch: have %v, want %v", err, ErrAccountNotExist)
+	}
+	w, err := am.ProposeWithdrawal("producera", proposal, 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.ID != 1 || w.Deadline != 15 {
+		t.Fatalf("proposal id %d deadline %d, want 1 and 15", w.ID, w.Deadline)
+	}
+	if _, err := am.ApproveWithdrawal(w.ID, "producera", 11); err != ErrWithdrawalApproved {
+		t.Fatalf("approve error mismatch: have %v, want %v", err, ErrWithdrawalApproved)
+	}
+	if _, err := am.ApproveWithdrawal(w.ID, "producerb", 11); err != nil {
+		t.Fatal(err)
+	}
+	// A quorum of four producers is three approvals, a producer holding
+	// several slots counts once.
+	if n := WithdrawalQuorum([]string{"producera", "producera", "producera"}); n != 1 {
+		t.Fatalf("quorum of a single producer mismatch: have %d, want 1", n)
+	}
+	if _, err := am.ExecuteWithdrawal(w.ID, pool, 12, producers); err != ErrWithdrawalQuorum {
+		t.Fatalf("execute error mismatch: have %v, want %v", err, ErrWithdrawalQuorum)
+	}
+	// Approvals of former producers don't count.
+	if _, err := am.ApproveWithdrawal(w.ID, "producerx", 12); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := am.ExecuteWithdrawal(w.ID, pool, 12, producers); err != ErrWithdrawalQuorum {
+		t.Fatalf("execute error mismatch: have %v, want %v", err, ErrWithdrawalQuorum)
+	}
+	if _, err := am.ApproveWithdrawal(w.ID, "producerc", 13); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := am.ExecuteWithdrawal(w.ID, pool, 16, producers); err != ErrWithdrawalExpired {
+		t.Fatalf("execute error mismatch: have %v, want %v", err, ErrWithdrawalExpired)
+	}
+	if _, err := am.ExecuteWithdrawal(w.ID, pool, 15, producers); err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := am.GetAccountBalanceByID(recipient, assetID); balance.Int64() != 400 {
+		t.Fatalf("recipient balance %v, want 400", balance)
+	}
+	if balance, _ := am.GetAccountBalanceByID(pool, assetID); balance.Int64() != 600 {
+		t.Fatalf("pool balance %v, want 600", balance)
+	}
+	if _, err := am.ExecuteWithdrawal(w.ID, pool, 15, producers); err != ErrWithdrawalExecuted {
+		t.Fatalf("execute error mismatch: have %v, want %v", err, ErrWithdrawalExecuted)
+	}
+
+	stored, err := am.GetWithdrawal(w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Executed || len(stored.Approvals) != 4 || stored.Memo != "grant" {
+		t.Fatalf("stored proposal mismatch: %+v", stored)
+	}
+	if next, err := am.ProposeWithdrawal("producerb", proposal, 20, 5); err != nil || next.ID != 2 {
+		t.Fatalf("next proposal id mismatch: %v %v", next, err)
+	}
+	if missing, err := am.GetWithdrawal(3); err != nil || missing != nil {
+		t.Fatalf("missing proposal mismatch: %v %v", missing, err)
+	}
+}
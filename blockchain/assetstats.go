@@ -0,0 +1,181 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+// blockAssetStatsDeltas returns the changes a block made to the statistics of
+// the assets: the balance changes of its state and its successful actions and
+// internal transactions with a value.
+func blockAssetStatsDeltas(block *types.Block, receipts []*types.Receipt, itxs []*types.InternalTx, statedb *state.StateDB) ([]*types.AssetStatsDelta, error) {
+	deltas, err := accountmanager.AssetStatsDeltas(statedb)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint64]*types.AssetStatsDelta, len(deltas))
+	for _, d := range deltas {
+		byID[d.AssetID] = d
+	}
+	transfer := func(assetID uint64, value *big.Int) {
+		if value == nil || value.Sign() <= 0 {
+			return
+		}
+		d := byID[assetID]
+		if d == nil {
+			d = types.NewAssetStatsDelta(assetID)
+			byID[assetID] = d
+			deltas = append(deltas, d)
+		}
+		d.Transfers++
+		d.Volume.Add(d.Volume, value)
+	}
+
+	for i, tx := range block.Txs {
+		if i >= len(receipts) {
+			break
+		}
+		results := receipts[i].ActionResults
+		for j, action := range tx.GetActions() {
+			if j < len(results) && results[j].Status == types.ReceiptStatusSuccessful {
+				transfer(action.AssetID(), action.Value())
+			}
+		}
+	}
+	for _, itx := range itxs {
+		if itx.Error == "" {
+			transfer(itx.AssetID, itx.Value)
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].AssetID < deltas[j].AssetID })
+	return deltas, nil
+}
+
+// writeAssetStats adds the deltas of a block written on top of the head to the
+// asset statistics. The statistics are left alone if they don't follow the
+// parent of the block, they are rebuilt on the next start.
+func (bc *BlockChain) writeAssetStats(batch fdb.Batch, block *types.Block, deltas []*types.AssetStatsDelta) {
+	if rawdb.ReadAssetStatsHead(bc.db) != block.ParentHash() {
+		return
+	}
+	hour := types.TimeHour(block.Time())
+	for _, d := range deltas {
+		stats := rawdb.ReadAssetStats(bc.db, d.AssetID)
+		if stats == nil {
+			stats = types.NewAssetStats(d.AssetID)
+		}
+		stats.Apply(d, hour)
+		rawdb.WriteAssetStats(batch, stats)
+	}
+	if len(deltas) > 0 {
+		rawdb.WriteAssetStatsDeltas(batch, block.Hash(), block.NumberU64(), deltas)
+	}
+	rawdb.WriteAssetStatsHead(batch, block.Hash())
+}
+
+// revertAssetStats removes the deltas of the blocks dropped by a reorg, given
+// from the head down, from the asset statistics which then follow the common
+// ancestor. The statistics are left alone if the hourly volume of the day up
+// to the ancestor was pruned, they are rebuilt on the next start.
+func (bc *BlockChain) revertAssetStats(batch fdb.Batch, blocks types.Blocks, ancestor *types.Block) {
+	if len(blocks) == 0 || rawdb.ReadAssetStatsHead(bc.db) != blocks[0].Hash() {
+		return
+	}
+	ancestorHour := types.TimeHour(ancestor.Time())
+	stats := make(map[uint64]*types.AssetStats)
+	for _, block := range blocks {
+		hour := types.TimeHour(block.Time())
+		for _, d := range rawdb.ReadAssetStatsDeltas(bc.db, block.Hash(), block.NumberU64()) {
+			s := stats[d.AssetID]
+			if s == nil {
+				if s = rawdb.ReadAssetStats(bc.db, d.AssetID); s == nil {
+					s = types.NewAssetStats(d.AssetID)
+				}
+				if !s.Revertible(ancestorHour) {
+					log.Warn("Asset statistics can't be reverted past the pruned volume", "asset", d.AssetID, "number", ancestor.NumberU64(), "hash", ancestor.Hash())
+					return
+				}
+				stats[d.AssetID] = s
+			}
+			s.Revert(d, hour)
+		}
+	}
+	for _, s := range stats {
+		rawdb.WriteAssetStats(batch, s)
+	}
+	rawdb.WriteAssetStatsHead(batch, ancestor.Hash())
+}
+
+// initAssetStats rebuilds the supply and holders of the asset statistics from
+// the head state if the statistics don't follow the head block, as on the first
+// start or after a snapshot import. The transfers can't be recovered without
+// executing the blocks again and are kept as they are.
+func (bc *BlockChain) initAssetStats() error {
+	head := bc.CurrentBlock()
+	if bc.readOnly || rawdb.ReadAssetStatsHead(bc.db) == head.Hash() || !bc.HasState(head.Hash()) {
+		return nil
+	}
+	statedb, err := state.New(head.Hash(), bc.stateCache)
+	if err != nil {
+		return err
+	}
+	am, err := accountmanager.NewAccountManager(statedb)
+	if err != nil {
+		return err
+	}
+	supplies, err := am.AssetSupplies()
+	if err != nil {
+		return err
+	}
+	assets, err := am.GetAllAssetObject()
+	if err != nil {
+		return err
+	}
+
+	// assets nobody holds anymore have no supply left
+	supplied := make(map[uint64]*types.AssetStats, len(assets))
+	for _, asset := range assets {
+		supplied[asset.AssetId] = types.NewAssetStats(asset.AssetId)
+	}
+	for _, s := range supplies {
+		supplied[s.AssetID] = s
+	}
+	batch := bc.db.NewBatch()
+	for assetID, s := range supplied {
+		stats := rawdb.ReadAssetStats(bc.db, assetID)
+		if stats == nil {
+			stats = types.NewAssetStats(assetID)
+		}
+		stats.Supply, stats.Holders = s.Supply, s.Holders
+		rawdb.WriteAssetStats(batch, stats)
+	}
+	rawdb.WriteAssetStatsHead(batch, head.Hash())
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Rebuilt asset statistics", "number", head.NumberU64(), "hash", head.Hash(), "assets", len(supplied))
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// checkAssetStats checks that the indexed supply and holders of the assets
+// match the balances of the head state.
+func checkAssetStats(t *testing.T, chain *BlockChain) {
+	head := chain.CurrentBlock()
+	if have := rawdb.ReadAssetStatsHead(chain.db); have != head.Hash() {
+		t.Fatalf("asset statistics head mismatch: have %x, want %x", have, head.Hash())
+	}
+	statedb, err := state.New(head.Hash(), chain.stateCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, err := accountmanager.NewAccountManager(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	supplies, err := am.AssetSupplies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range supplies {
+		have := rawdb.ReadAssetStats(chain.db, want.AssetID)
+		if have == nil {
+			t.Fatalf("asset %d: statistics missing", want.AssetID)
+		}
+		if have.Supply.Cmp(want.Supply) != 0 || have.Holders != want.Holders {
+			t.Fatalf("asset %d: supply %v holders %d, want %v %d", want.AssetID, have.Supply, have.Holders, want.Supply, want.Holders)
+		}
+	}
+}
+
+func TestAssetStats(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+	checkAssetStats(t, chain)
+	start := rawdb.ReadAssetStats(chain.db, chain.Config().SysTokenID).Transfers
+
+	// skip some slots so that a chain using every slot is heavier
+	prods, ht := makeProduceAndTime(st, 10)
+	skipProds := append(append([]string{}, prods[0:3]...), prods[10:]...)
+	skipHt := append(append([]uint64{}, ht[0:3]...), ht[10:]...)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(skipProds), skipHt, skipProds, makeTransferTx)
+	if err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	checkAssetStats(t, chain)
+	stats := rawdb.ReadAssetStats(chain.db, chain.Config().SysTokenID)
+	if stats.Transfers != start+uint64(len(blocks)) {
+		t.Fatalf("transfers mismatch: have %d, want %d", stats.Transfers, start+uint64(len(blocks)))
+	}
+	if transfers, _ := stats.LastDay(types.TimeHour(chain.CurrentBlock().Time())); transfers != stats.Transfers {
+		t.Fatalf("last day transfers mismatch: have %d, want %d", transfers, stats.Transfers)
+	}
+
+	// the heavier chain without transfers reverts them
+	genesis1, db1, chain1, _, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain1.Stop()
+	_, _, blocks1, err := makeNewChain(t, genesis1, chain1, &db1, len(prods), ht, prods, nil)
+	if err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	if _, err := chain.InsertChain(blocks1); err != nil {
+		t.Fatal(err)
+	}
+	if chain.CurrentBlock().Hash() != blocks1[len(blocks1)-1].Hash() {
+		t.Fatal("chain not reorganised")
+	}
+	checkAssetStats(t, chain)
+	if stats := rawdb.ReadAssetStats(chain.db, chain.Config().SysTokenID); stats.Transfers != start {
+		t.Fatalf("transfers not reverted: have %d, want %d", stats.Transfers, start)
+	}
+}
@@ -18,14 +18,17 @@ package blockchain
 
 import (
 	"fmt"
+	"io"
 	"math/big"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
+	"github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/processor"
 	"github.com/fractalplatform/fractal/processor/vm"
@@ -34,6 +37,7 @@ import (
 	"github.com/fractalplatform/fractal/types"
 	"github.com/fractalplatform/fractal/utils/fdb"
 	"github.com/fractalplatform/fractal/utils/rlp"
+	"github.com/fractalplatform/fractal/utils/supervisor"
 	"github.com/hashicorp/golang-lru"
 )
 
@@ -44,12 +48,31 @@ const (
 	tdCacheLimit        = 1024
 	numberCacheLimit    = 2048
 	maxFutureBlocks     = 256
-	maxTimeFutureBlocks = 30
+	maxTimeFutureBlocks = 30   // Default seconds a block may be ahead of local time to be kept for later
+	maxReplayBlocks     = 1024 // Default number of blocks replayed for the state of a pruned block
+	maxRevertBlocks     = 8192 // Default number of blocks of state changes reverted for the state of an old block
 	badBlockLimit       = 10
+	chainHeadChanSize   = 10
+
+	receiptsCompactInterval = time.Minute // Interval of the checks for finished epochs of uncompacted receipts
 
 	BlockChainVersion = 3
 )
 
+// CacheConfig contains the configuration values for the caches of the
+// blockchain.
+type CacheConfig struct {
+	StateCache         int           // Memory allowance (MB) to use for caching state values in memory
+	StateCommitCache   int           // Memory allowance (MB) to use for keeping the results of executed blocks
+	FutureBlockDrift   time.Duration // How far ahead of local time a block may be to be inserted in time, instead of rejected
+	MaxClockDrift      time.Duration // How far the local clock may drift from the block timestamps of the network before warning, 0 to never warn
+	TxSearchIndex      bool          // Whether to index the actions by account, asset and type
+	CheckpointInterval uint64        // Blocks between the checkpoints co-signed by the producers, 0 to sign none
+	HeaderOnly         bool          // Whether only the headers and total difficulties of the blocks are stored, for light sync
+	MaxReplayBlocks    uint64        // Blocks replayed at most for the state of a pruned block, the default if 0
+	MaxRevertBlocks    uint64        // Blocks of state changes reverted at most for the state of an old block, the default if 0
+}
+
 // BlockChain represents the canonical chain given a database with a genesis
 // block. The Blockchain manages chain imports, reverts, chain reorganisations.
 type BlockChain struct {
@@ -70,8 +93,17 @@ type BlockChain struct {
 	bodyRLPCache     *lru.Cache          // Cache for the most recent block bodies in RLP encoded format
 	blockCache       *lru.Cache          // Cache for the most recent entire blocks
 	futureBlocks     *lru.Cache          // future blocks are blocks added for later processing
+	futureDrift      time.Duration       // maximum time a future block may be ahead of local time
+	clockDrift       *ClockDrift         // drift of the local clock from the blocks pushed by the remotes
+	maxReplay        uint64              // blocks replayed at most for the state of a pruned block
+	maxRevert        uint64              // blocks of state changes reverted at most for the state of an old block
+	now              func() time.Time    // local time, future blocks are relative to
 	badBlocks        *lru.Cache          // Bad block cache
+	stateCommits     *stateCommitCache   // results of the recently executed blocks
 	quit             chan struct{}       // blockchain quit channel
+	chainHeadFeed    event.Feed          // new canonical heads, whoever wrote them
+	forkChoice       *ForkChoice         // decides the canonical chain among competing ones
+	checkpoints      *CheckpointPool     // checkpoints co-signed by the producers
 	running          int32               // running must be called atomically
 	procInterrupt    int32               // procInterrupt must be atomically called, interrupt signaler for block processing
 	wg               sync.WaitGroup      // chain processing wait group for shutting down
@@ -79,10 +111,57 @@ type BlockChain struct {
 	processor        processor.Processor // block processor interface
 	validator        processor.Validator // block and state validator interface
 	station          *BlockchainStation  // p2p station
+	router           *event.Router       // router of the node, the default one if nil
+	readOnly         bool                // set if the database must not be written to
+	txSearch         bool                // set if the actions are added to the transaction search index
+	headerOnly       bool                // set if the database stores the headers of the blocks only
+	fetchedBodies    *lru.Cache          // bodies a header-only chain fetched from the remotes
 }
 
 // NewBlockChain returns a fully initialised block chain using information　available in the database.
-func NewBlockChain(db fdb.Database, vmConfig vm.Config, chainConfig *params.ChainConfig, senderCacher TxSenderCacher) (*BlockChain, error) {
+func NewBlockChain(db fdb.Database, cacheConfig *CacheConfig, vmConfig vm.Config, chainConfig *params.ChainConfig, senderCacher TxSenderCacher) (*BlockChain, error) {
+	return NewBlockChainWithRouter(nil, db, cacheConfig, vmConfig, chainConfig, senderCacher)
+}
+
+// NewBlockChainWithRouter returns a block chain exchanging its events with the
+// rest of its node and the remotes through the router instead of the default
+// one, letting several nodes run in a process. The options configure the
+// downloader syncing the chain.
+func NewBlockChainWithRouter(r *event.Router, db fdb.Database, cacheConfig *CacheConfig, vmConfig vm.Config, chainConfig *params.ChainConfig, senderCacher TxSenderCacher, opts ...DownloaderOption) (*BlockChain, error) {
+	bc, err := newBlockChain(db, cacheConfig, vmConfig, chainConfig, senderCacher, false)
+	if err != nil {
+		return nil, err
+	}
+	bc.router = r
+	networkId := uint64(0)
+	if chainConfig.ChainID != nil {
+		networkId = chainConfig.ChainID.Uint64()
+	}
+	bc.station = newBlcokchainStation(bc, networkId, opts...)
+	supervisor.Go("future blocks", bc.quit, bc.update)
+	supervisor.Go("receipts compaction", bc.quit, bc.compactReceipts)
+
+	headCh := make(chan *event.Event, chainHeadChanSize)
+	headSub := bc.router.Subscribe(nil, headCh, event.ChainHeadEv, &types.Block{})
+	go func() {
+		defer headSub.Unsubscribe()
+		supervisor.Run("chain head", bc.quit, func() { bc.forwardChainHead(headCh, headSub) })
+	}()
+	return bc, nil
+}
+
+// NewReadOnlyBlockChain returns a block chain serving the content of the
+// database without ever writing to it, for tools inspecting the data of a
+// node. The head is neither repaired nor updated and no blocks can be
+// inserted.
+func NewReadOnlyBlockChain(db fdb.Database, cacheConfig *CacheConfig, chainConfig *params.ChainConfig) (*BlockChain, error) {
+	return newBlockChain(fdb.NewReadOnlyDatabase(db), cacheConfig, vm.Config{}, chainConfig, nil, true)
+}
+
+func newBlockChain(db fdb.Database, cacheConfig *CacheConfig, vmConfig vm.Config, chainConfig *params.ChainConfig, senderCacher TxSenderCacher, readOnly bool) (*BlockChain, error) {
+	if cacheConfig == nil {
+		cacheConfig = &CacheConfig{StateCache: state.DefaultCacheSize}
+	}
 	bodyCache, _ := lru.New(bodyCacheLimit)
 	bodyRLPCache, _ := lru.New(bodyCacheLimit)
 	headerCache, _ := lru.New(headerCacheLimit)
@@ -91,45 +170,128 @@ func NewBlockChain(db fdb.Database, vmConfig vm.Config, chainConfig *params.Chai
 	blockCache, _ := lru.New(blockCacheLimit)
 	futureBlocks, _ := lru.New(maxFutureBlocks)
 	badBlocks, _ := lru.New(badBlockLimit)
+	fetchedBodies, _ := lru.New(fetchedBodyCacheLimit)
 
 	bc := &BlockChain{
-		chainConfig:  chainConfig,
-		vmConfig:     vmConfig,
-		db:           db,
-		stateCache:   state.NewDatabase(db),
-		quit:         make(chan struct{}),
-		bodyCache:    bodyCache,
-		headerCache:  headerCache,
-		tdCache:      tdCache,
-		numberCache:  numberCache,
-		bodyRLPCache: bodyRLPCache,
-		blockCache:   blockCache,
-		futureBlocks: futureBlocks,
-		badBlocks:    badBlocks,
-		senderCacher: senderCacher,
-	}
+		chainConfig:   chainConfig,
+		vmConfig:      vmConfig,
+		db:            db,
+		stateCache:    state.NewDatabaseWithCache(db, cacheConfig.StateCache),
+		quit:          make(chan struct{}),
+		bodyCache:     bodyCache,
+		headerCache:   headerCache,
+		tdCache:       tdCache,
+		numberCache:   numberCache,
+		bodyRLPCache:  bodyRLPCache,
+		blockCache:    blockCache,
+		futureBlocks:  futureBlocks,
+		futureDrift:   cacheConfig.FutureBlockDrift,
+		clockDrift:    NewClockDrift(cacheConfig.MaxClockDrift),
+		maxReplay:     cacheConfig.MaxReplayBlocks,
+		maxRevert:     cacheConfig.MaxRevertBlocks,
+		now:           time.Now,
+		badBlocks:     badBlocks,
+		stateCommits:  newStateCommitCache(cacheConfig.StateCommitCache),
+		senderCacher:  senderCacher,
+		readOnly:      readOnly,
+		txSearch:      cacheConfig.TxSearchIndex,
+		fetchedBodies: fetchedBodies,
+	}
+
+	if bc.futureDrift == 0 {
+		bc.futureDrift = maxTimeFutureBlocks * time.Second
+	}
+	if bc.maxReplay == 0 {
+		bc.maxReplay = maxReplayBlocks
+	}
+	if bc.maxRevert == 0 {
+		bc.maxRevert = maxRevertBlocks
+	}
+	bc.forkChoice = newForkChoice(bc)
+	bc.checkpoints = newCheckpointPool(bc, cacheConfig.CheckpointInterval)
 
 	bc.genesisBlock = bc.GetBlockByNumber(0)
 	if bc.genesisBlock == nil {
 		return nil, ErrNoGenesis
 	}
 
+	if err := bc.initHeaderOnly(cacheConfig.HeaderOnly); err != nil {
+		return nil, err
+	}
 	if err := bc.loadLastBlock(); err != nil {
 		return nil, err
 	}
-	bc.station = newBlcokchainStation(bc, 0)
-	go bc.update()
+	if err := bc.resolveTokens(); err != nil {
+		return nil, err
+	}
+	if err := bc.initAssetStats(); err != nil {
+		log.Warn("Failed to rebuild asset statistics", "err", err)
+	}
+	if !bc.txSearch && !readOnly && rawdb.ReadTxSearchTail(db) != nil {
+		// the index has a gap once disabled, it restarts when enabled again
+		rawdb.DeleteTxSearchTail(db)
+	}
 	return bc, nil
 }
 
+// resolveTokens sets the ids of the system and the fee tokens of the chain
+// config to the assets of their names in the head state. A header-only chain
+// keeps the genesis state only.
+func (bc *BlockChain) resolveTokens() error {
+	head := bc.CurrentBlock()
+	if bc.headerOnly {
+		head = bc.genesisBlock
+	}
+	statedb, err := bc.StateAt(head.Hash())
+	if err != nil {
+		return err
+	}
+	accountDB, err := accountmanager.NewAccountManager(statedb)
+	if err != nil {
+		return err
+	}
+	cfg := bc.chainConfig
+	if ok, err := accountDB.AccountIsExist(cfg.SysName); !ok {
+		return fmt.Errorf("system account %v not found: %v", cfg.SysName, err)
+	}
+	sysInfo, err := accountDB.GetAssetInfoByName(cfg.SysToken)
+	if err != nil || sysInfo == nil {
+		return fmt.Errorf("system token %v not found: %v", cfg.SysToken, err)
+	}
+	cfg.SysTokenID = sysInfo.AssetId
+	cfg.SysTokenDecimals = sysInfo.Decimals
+
+	cfg.FeeTokenID = cfg.SysTokenID
+	if cfg.FeeToken != "" && cfg.FeeToken != cfg.SysToken {
+		feeInfo, err := accountDB.GetAssetInfoByName(cfg.FeeToken)
+		if err != nil || feeInfo == nil {
+			return fmt.Errorf("fee token %v not found: %v", cfg.FeeToken, err)
+		}
+		cfg.FeeTokenID = feeInfo.AssetId
+	}
+	return nil
+}
+
 // loadLastBlock loads the last known chain from the database.
 func (bc *BlockChain) loadLastBlock() error {
 	// Restore the last known head block
 	head := rawdb.ReadHeadBlockHash(bc.db)
+	if bc.headerOnly && head != (common.Hash{}) {
+		return bc.loadHeaderHead(head)
+	}
+	if bc.readOnly {
+		return bc.loadReadOnlyHead(head)
+	}
 	if head == (common.Hash{}) {
 		log.Warn("Empty database, resetting chain")
 		return bc.Reset()
 	}
+	head = bc.recoverIntent(head)
+	head = bc.recoverStateCommit(head)
+	head, err := bc.repairHead(head)
+	if err != nil {
+		return err
+	}
 
 	// Make sure the entire head block is available
 	currentBlock := bc.GetBlockByHash(head)
@@ -170,6 +332,186 @@ func (bc *BlockChain) loadLastBlock() error {
 	return nil
 }
 
+// loadReadOnlyHead sets the stored heads as they are, failing instead of
+// repairing them.
+func (bc *BlockChain) loadReadOnlyHead(head common.Hash) error {
+	if intent := rawdb.ReadChainIntent(bc.db); intent != nil {
+		return fmt.Errorf("interrupted chain mutation to block #%d [%x…]", intent.Number, intent.Hash[:4])
+	}
+	if marker := rawdb.ReadStateCommitMarker(bc.db); marker != (common.Hash{}) {
+		return fmt.Errorf("interrupted state commit of block [%x…]", marker[:4])
+	}
+	currentBlock := bc.GetBlockByHash(head)
+	if currentBlock == nil {
+		return fmt.Errorf("non existent head block [%x…]", head[:4])
+	}
+	if problem := bc.checkBlockConsistency(head, currentBlock.NumberU64()); problem != "" {
+		return fmt.Errorf("inconsistent head block #%d [%x…]: %s", currentBlock.NumberU64(), head[:4], problem)
+	}
+	bc.currentBlock.Store(currentBlock)
+	bc.currentFastBlock.Store(currentBlock)
+	if block := bc.GetBlockByHash(rawdb.ReadHeadFastBlockHash(bc.db)); block != nil {
+		bc.currentFastBlock.Store(block)
+	}
+	log.Info("Loaded most recent local full block read-only", "number", currentBlock.Number(), "hash", currentBlock.Hash())
+	return nil
+}
+
+// beginIntent records the chain mutation moving the chain to the block ahead
+// of its writes. The writes must delete the intent last, or in the batch
+// applying them.
+func (bc *BlockChain) beginIntent(op uint8, block *types.Block) {
+	rawdb.WriteChainIntent(bc.db, &rawdb.ChainIntent{
+		Op:     op,
+		Hash:   block.Hash(),
+		Number: block.NumberU64(),
+		Head:   rawdb.ReadHeadBlockHash(bc.db),
+	})
+}
+
+// recoverIntent completes or undoes a chain mutation interrupted by a crash
+// and returns the hash of the head block to continue from. The mutation is
+// replayed if the block it moves the chain to is complete, otherwise the
+// heads are rolled back to the head before it.
+func (bc *BlockChain) recoverIntent(head common.Hash) common.Hash {
+	intent := rawdb.ReadChainIntent(bc.db)
+	if intent == nil {
+		return head
+	}
+	target := intent.Hash
+	if problem := bc.checkBlockConsistency(intent.Hash, intent.Number); problem != "" {
+		target = intent.Head
+		log.Warn("Rolling back interrupted chain mutation", "op", intent.Op, "block", intent.Hash, "problem", problem, "head", target)
+	} else {
+		log.Warn("Replaying interrupted chain mutation", "op", intent.Op, "block", intent.Hash, "head", target)
+	}
+	batch := bc.db.NewBatch()
+	if target != (common.Hash{}) {
+		rawdb.WriteHeadBlockHash(batch, target)
+		rawdb.WriteHeadHeaderHash(batch, target)
+		rawdb.WriteHeadFastBlockHash(batch, target)
+		head = target
+	}
+	rawdb.DeleteChainIntent(batch)
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to recover chain mutation", "err", err)
+	}
+	return head
+}
+
+// recoverStateCommit checks for a state commit interrupted by a crash and
+// returns the hash of the head block to continue from. Commits are written in
+// a single batch, so the state is at the last block committed in full, which
+// becomes the head if it differs from the stored one.
+func (bc *BlockChain) recoverStateCommit(head common.Hash) common.Hash {
+	marker := rawdb.ReadStateCommitMarker(bc.db)
+	if marker == (common.Hash{}) {
+		return head
+	}
+	stateHash := rawdb.ReadOptBlockHash(bc.db)
+	log.Warn("Recovering from interrupted state commit", "block", marker, "state", stateHash, "head", head)
+	if stateHash != head && bc.GetBlockByHash(stateHash) != nil {
+		rawdb.WriteHeadBlockHash(bc.db, stateHash)
+		head = stateHash
+	}
+	rawdb.DeleteStateCommitMarker(bc.db)
+	return head
+}
+
+// repairHead checks that the head block, its total difficulty, its number
+// mapping and the canonical chain agree with each other, walking back to the
+// last block for which they do. The heads, the canonical mappings above that
+// block and the state are rewound to it and the problems found are logged.
+// The empty hash is returned if no consistent block is left.
+func (bc *BlockChain) repairHead(head common.Hash) (common.Hash, error) {
+	number := rawdb.ReadHeaderNumber(bc.db, head)
+	if number == nil {
+		if number = rawdb.ReadHeaderNumber(bc.db, rawdb.ReadHeadHeaderHash(bc.db)); number == nil {
+			return head, nil
+		}
+	}
+	var (
+		problems []string
+		hash     = head
+		n        = *number
+	)
+	for {
+		problem := bc.checkBlockConsistency(hash, n)
+		if problem == "" {
+			break
+		}
+		problems = append(problems, fmt.Sprintf("#%d [%x…]: %s", n, hash[:4], problem))
+		if n == 0 {
+			log.Error("No consistent block left in database", "head", head, "problems", problems)
+			return common.Hash{}, nil
+		}
+		if header := rawdb.ReadHeader(bc.db, hash, n); header != nil {
+			hash = header.ParentHash
+		} else {
+			hash = rawdb.ReadCanonicalHash(bc.db, n-1)
+		}
+		n--
+	}
+	stateHash := rawdb.ReadOptBlockHash(bc.db)
+	if stateHash != (common.Hash{}) && stateHash != hash {
+		problems = append(problems, fmt.Sprintf("state at [%x…]", stateHash[:4]))
+	}
+	if len(problems) == 0 {
+		return head, nil
+	}
+
+	batch := bc.db.NewBatch()
+	for i := n + 1; ; i++ {
+		if rawdb.ReadCanonicalHash(bc.db, i) == (common.Hash{}) {
+			break
+		}
+		rawdb.DeleteCanonicalHash(batch, i)
+	}
+	rawdb.WriteHeadBlockHash(batch, hash)
+	rawdb.WriteHeadHeaderHash(batch, hash)
+	rawdb.WriteHeadFastBlockHash(batch, hash)
+	if stateHash != (common.Hash{}) && stateHash != hash {
+		if err := state.TransToSpecBlock(batch, bc.db, bc.stateCache, stateHash, hash); err != nil {
+			return common.Hash{}, fmt.Errorf("rewind state to block #%d [%x…]: %v", n, hash[:4], err)
+		}
+	} else if err := batch.Write(); err != nil {
+		return common.Hash{}, err
+	}
+	bc.headerCache.Purge()
+	bc.numberCache.Purge()
+	bc.tdCache.Purge()
+	bc.bodyCache.Purge()
+	bc.bodyRLPCache.Purge()
+	bc.blockCache.Purge()
+
+	log.Warn("Repaired inconsistent chain database", "from", head, "number", n, "hash", hash, "problems", problems)
+	return hash, nil
+}
+
+// checkBlockConsistency describes why the block isn't a consistent head, or
+// returns the empty string if it is one.
+func (bc *BlockChain) checkBlockConsistency(hash common.Hash, number uint64) string {
+	switch {
+	case hash == (common.Hash{}):
+		return "missing canonical hash"
+	case rawdb.ReadCanonicalHash(bc.db, number) != hash:
+		return "not canonical"
+	}
+	if n := rawdb.ReadHeaderNumber(bc.db, hash); n == nil || *n != number {
+		return "missing number mapping"
+	}
+	block := rawdb.ReadBlock(bc.db, hash, number)
+	switch {
+	case block == nil:
+		return "missing block"
+	case rawdb.ReadTd(bc.db, hash, number) == nil:
+		return "missing total difficulty"
+	case number > 0 && rawdb.ReadHeader(bc.db, block.ParentHash(), number-1) == nil:
+		return "missing parent header"
+	}
+	return ""
+}
+
 // Reset purges the entire blockchain, restoring it to its genesis state.
 func (bc *BlockChain) Reset() error {
 	return bc.ResetWithGenesisBlock(bc.genesisBlock)
@@ -199,6 +541,9 @@ func (bc *BlockChain) ResetWithGenesisBlock(genesis *types.Block) error {
 
 // SetHead rewinds the local chain to a new head.
 func (bc *BlockChain) SetHead(head uint64) error {
+	if bc.readOnly {
+		return fdb.ErrReadOnly
+	}
 	log.Warn("Rewinding blockchain", "target", head)
 
 	bc.mu.Lock()
@@ -212,6 +557,7 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	bc.bodyRLPCache.Purge()
 	bc.blockCache.Purge()
 	bc.futureBlocks.Purge()
+	bc.stateCommits.purge()
 
 	// If either blocks reached nil, reset to the genesis state
 	if currentBlock := bc.CurrentBlock(); currentBlock == nil {
@@ -223,14 +569,19 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	currentBlock := bc.CurrentBlock()
 	currentFastBlock := bc.CurrentFastBlock()
 
+	bc.beginIntent(rawdb.IntentSetHead, currentBlock)
 	rawdb.WriteHeadBlockHash(bc.db, currentBlock.Hash())
 	rawdb.WriteHeadFastBlockHash(bc.db, currentFastBlock.Hash())
+	rawdb.DeleteChainIntent(bc.db)
 	return bc.loadLastBlock()
 }
 
 // FastSyncCommitHead sets the current head block to the one defined by the hash
 // irrelevant what the chain contents were prior.
 func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
+	if bc.readOnly {
+		return fdb.ErrReadOnly
+	}
 	// Make sure that both the block as well at its state trie exists
 	block := bc.GetBlockByHash(hash)
 	if block == nil {
@@ -294,6 +645,20 @@ func (bc *BlockChain) Processor() processor.Processor {
 	return bc.processor
 }
 
+// ForkChoice returns the fork choice deciding the canonical chain.
+func (bc *BlockChain) ForkChoice() *ForkChoice {
+	return bc.forkChoice
+}
+
+// Downloader returns the downloader synchronising the chain, or nil if the
+// chain is read-only.
+func (bc *BlockChain) Downloader() *Downloader {
+	if bc.station == nil {
+		return nil
+	}
+	return bc.station.downloader
+}
+
 // State returns a new mutable state based on the current HEAD block.
 func (bc *BlockChain) State() (*state.StateDB, error) {
 	return bc.StateAt(bc.CurrentBlock().Hash())
@@ -304,6 +669,63 @@ func (bc *BlockChain) StateAt(block common.Hash) (*state.StateDB, error) {
 	return state.New(block, bc.stateCache)
 }
 
+// StateAtBlock returns the state at the block with the given hash, which must
+// be an ancestor of the current block. If the state changes of the block were
+// pruned, the blocks above the nearest ancestor whose state is retained are
+// replayed on its state. The state is read-only and stops working once the
+// chain moves. The state is refused if more blocks than the configured
+// limits need to be replayed or reverted.
+func (bc *BlockChain) StateAtBlock(hash common.Hash) (*state.StateDB, error) {
+	if hash == bc.CurrentBlock().Hash() {
+		return bc.StateAt(hash)
+	}
+	block := bc.GetBlockByHash(hash)
+	if block == nil {
+		return nil, fmt.Errorf("block %x not found", hash)
+	}
+	var replays []*types.Block
+	for !bc.HasState(block.Hash()) {
+		if uint64(len(replays)) >= bc.maxReplay {
+			return nil, fmt.Errorf("state of block %x is more than %d blocks above a retained state", hash, bc.maxReplay)
+		}
+		replays = append(replays, block)
+		if block = bc.GetBlock(block.ParentHash(), block.NumberU64()-1); block == nil {
+			return nil, fmt.Errorf("no retained state below block %x", hash)
+		}
+	}
+	statedb, err := state.NewReplay(block.Hash(), bc.stateCache, bc.maxRevert)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(replays) - 1; i >= 0; i-- {
+		parent, b := block, replays[i]
+		receipts, _, usedGas, err := bc.processor.Process(b, statedb, bc.vmConfig)
+		if err != nil {
+			return nil, fmt.Errorf("replay block %d: %v", b.NumberU64(), err)
+		}
+		if err := bc.validator.ValidateState(b, parent, statedb, receipts, usedGas); err != nil {
+			return nil, fmt.Errorf("replay block %d: %v", b.NumberU64(), err)
+		}
+		statedb.NextReplayBlock(b.Hash())
+		block = b
+	}
+	return statedb, nil
+}
+
+// DumpState writes the accounts and assets of the state of the block with the
+// given hash to w as JSON. Only the state of the current block is available.
+func (bc *BlockChain) DumpState(root common.Hash, w io.Writer) error {
+	statedb, err := bc.StateAt(root)
+	if err != nil {
+		return err
+	}
+	am, err := accountmanager.NewAccountManager(statedb)
+	if err != nil {
+		return err
+	}
+	return am.Dump(root, w)
+}
+
 // insert injects a new head block into the current block chain.
 func (bc *BlockChain) insert(batch fdb.Batch, block *types.Block) {
 	updateHeads := rawdb.ReadCanonicalHash(bc.db, block.NumberU64()) != block.Hash()
@@ -362,6 +784,9 @@ func (bc *BlockChain) HasBlock(hash common.Hash, number uint64) bool {
 	if bc.blockCache.Contains(hash) {
 		return true
 	}
+	if bc.headerOnly {
+		return bc.HasHeader(hash, number)
+	}
 	return rawdb.HasBody(bc.db, hash, number)
 }
 
@@ -373,6 +798,10 @@ func (bc *BlockChain) HasState(hash common.Hash) bool {
 
 // HasBlockAndState checks if a block and  state  is fully present  in the database or not.
 func (bc *BlockChain) HasBlockAndState(hash common.Hash, number uint64) bool {
+	// a header-only chain keeps no state
+	if bc.headerOnly {
+		return bc.HasBlock(hash, number)
+	}
 	block := bc.GetBlock(hash, number)
 	if block == nil {
 		return false
@@ -444,6 +873,9 @@ func (bc *BlockChain) Stop() {
 	if !atomic.CompareAndSwapInt32(&bc.running, 0, 1) {
 		return
 	}
+	if bc.station != nil {
+		bc.station.stop()
+	}
 	close(bc.quit)
 	atomic.StoreInt32(&bc.procInterrupt, 1)
 
@@ -461,11 +893,34 @@ func (bc *BlockChain) procFutureBlocks() {
 	if len(blocks) > 0 {
 		types.BlockBy(types.Number).Sort(blocks)
 		for i := range blocks {
+			// blocks still ahead of time or of their parent are queued again.
+			bc.futureBlocks.Remove(blocks[i].Hash())
 			bc.InsertChain(blocks[i : i+1])
 		}
 	}
 }
 
+// futureDelay returns how far the block is ahead of local time.
+func (bc *BlockChain) futureDelay(block *types.Block) time.Duration {
+	return time.Duration(block.Time().Int64() - bc.now().UnixNano())
+}
+
+// scheduleFutureBlocks retries the future blocks after the delay, when the
+// block queued last is due.
+func (bc *BlockChain) scheduleFutureBlocks(delay time.Duration) {
+	bc.wg.Add(1)
+	go func() {
+		defer bc.wg.Done()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			bc.procFutureBlocks()
+		case <-bc.quit:
+		}
+	}()
+}
+
 // WriteStatus status of write
 type WriteStatus byte
 
@@ -477,29 +932,38 @@ const (
 
 // Rollback is designed to remove a chain of links from the database that aren't certain enough to be valid.
 func (bc *BlockChain) Rollback(chain []common.Hash) {
+	if bc.readOnly {
+		return
+	}
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
+	currentBlock, currentFastBlock := bc.CurrentBlock(), bc.CurrentFastBlock()
 	for i := len(chain) - 1; i >= 0; i-- {
 		hash := chain[i]
 
-		if currentFastBlock := bc.CurrentFastBlock(); currentFastBlock.Hash() == hash {
-			newFastBlock := bc.GetBlock(currentFastBlock.ParentHash(), currentFastBlock.NumberU64()-1)
-			bc.currentFastBlock.Store(newFastBlock)
-			rawdb.WriteHeadFastBlockHash(bc.db, newFastBlock.Hash())
+		if currentFastBlock.Hash() == hash {
+			currentFastBlock = bc.GetBlock(currentFastBlock.ParentHash(), currentFastBlock.NumberU64()-1)
 		}
-		if currentBlock := bc.CurrentBlock(); currentBlock.Hash() == hash {
-			newBlock := bc.GetBlock(currentBlock.ParentHash(), currentBlock.NumberU64()-1)
-			bc.currentBlock.Store(newBlock)
-			rawdb.WriteHeadBlockHash(bc.db, newBlock.Hash())
+		if currentBlock.Hash() == hash {
+			currentBlock = bc.GetBlock(currentBlock.ParentHash(), currentBlock.NumberU64()-1)
 		}
 	}
+	bc.beginIntent(rawdb.IntentSetHead, currentBlock)
+	rawdb.WriteHeadFastBlockHash(bc.db, currentFastBlock.Hash())
+	rawdb.WriteHeadBlockHash(bc.db, currentBlock.Hash())
+	rawdb.DeleteChainIntent(bc.db)
+	bc.currentFastBlock.Store(currentFastBlock)
+	bc.currentBlock.Store(currentBlock)
 }
 
 var lastWrite uint64
 
 // WriteBlockWithoutState writes only the block and its metadata to the database, but does not write any state.
 func (bc *BlockChain) WriteBlockWithoutState(block *types.Block, td *big.Int) (err error) {
+	if bc.readOnly {
+		return fdb.ErrReadOnly
+	}
 	bc.wg.Add(1)
 	defer bc.wg.Done()
 	if err := bc.WriteTd(block.Hash(), block.NumberU64(), td); err != nil {
@@ -510,7 +974,10 @@ func (bc *BlockChain) WriteBlockWithoutState(block *types.Block, td *big.Int) (e
 }
 
 // WriteBlockWithState writes the block and all associated state to the database.
-func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.Receipt, state *state.StateDB) (err error) {
+func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.Receipt, statedb *state.StateDB) (err error) {
+	if bc.readOnly {
+		return fdb.ErrReadOnly
+	}
 	bc.wg.Add(1)
 	defer bc.wg.Done()
 
@@ -526,41 +993,87 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
+	// Write the block data and its state in a single batch, so that a crash
+	// leaves the chain at a consistent block.
 	externTd := new(big.Int).Add(block.Difficulty(), ptd)
-	if err := bc.WriteTd(block.Hash(), block.NumberU64(), externTd); err != nil {
-		return err
-	}
-
-	// Write other block data using a batch.
 	batch := bc.db.NewBatch()
+	rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), externTd)
 	rawdb.WriteBlock(batch, block)
 
-	_, err = state.Commit(batch, block.Hash(), block.NumberU64())
+	// the balance changes are gone once the state is committed
+	deltas, err := blockAssetStatsDeltas(block, receipts, statedb.InternalTxs(), statedb)
+	if err != nil {
+		return err
+	}
+	_, err = statedb.Commit(batch, block.Hash(), block.NumberU64())
 	if err != nil {
 		return err
 	}
 	rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), receipts)
 	rawdb.WriteTxLookupEntries(batch, block)
-	rawdb.WritePreimages(batch, block.NumberU64(), state.Preimages())
+	rawdb.WritePreimages(batch, block.NumberU64(), statedb.Preimages())
+	bc.writeInternalTxs(batch, block, statedb.InternalTxs())
+	bc.writeAssetStats(batch, block, deltas)
+	if bc.txSearch {
+		if rawdb.ReadTxSearchTail(bc.db) == nil {
+			rawdb.WriteTxSearchTail(batch, block.NumberU64())
+		}
+		rawdb.WriteTxSearchEntries(batch, block)
+	}
 	bc.insert(batch, block)
+	bc.beginIntent(rawdb.IntentInsertBlock, block)
+	rawdb.DeleteChainIntent(batch)
 	// write state
 	bc.stateCache.Lock()
-	if err := batch.Write(); err != nil {
+	if err := state.WriteCommit(bc.db, batch, block.Hash()); err != nil {
 		bc.stateCache.UnLock()
+		rawdb.DeleteChainIntent(bc.db)
 		return err
 	}
-	state.CommitCache(block.Hash())
+	bc.tdCache.Add(block.Hash(), externTd)
+	statedb.CommitCache(block.Hash())
 	bc.stateCache.UnLock()
 	bc.futureBlocks.Remove(block.Hash())
+	markBlocks(1, 0)
 	log.Debug("Insert new block", "producer", block.Coinbase(), "number", block.Number(), "hash", block.Hash().String(), "time", block.Time().Int64(), "txs", len(block.Txs), "gas", block.GasUsed())
 	return nil
 }
 
+// writeInternalTxs stores the internal transactions of a block and adds the
+// block to the internal transaction index of the accounts involved.
+func (bc *BlockChain) writeInternalTxs(batch fdb.Batch, block *types.Block, itxs []*types.InternalTx) {
+	if len(itxs) == 0 {
+		return
+	}
+	number := block.NumberU64()
+	rawdb.WriteInternalTxs(batch, block.Hash(), number, itxs)
+
+	names := make(map[common.Name]struct{})
+	for _, itx := range itxs {
+		names[itx.From] = struct{}{}
+		names[itx.To] = struct{}{}
+	}
+	for name := range names {
+		numbers := rawdb.ReadInternalTxIndex(bc.db, name)
+		i := sort.Search(len(numbers), func(i int) bool { return numbers[i] >= number })
+		if i < len(numbers) && numbers[i] == number {
+			continue
+		}
+		numbers = append(numbers, 0)
+		copy(numbers[i+1:], numbers[i:])
+		numbers[i] = number
+		rawdb.WriteInternalTxIndex(batch, name, numbers)
+	}
+}
+
 // InsertChain attempts to insert the given batch of blocks in to the canonical chain or, otherwise, create a fork.
 func (bc *BlockChain) InsertChain(chain types.Blocks) (int, error) {
+	if bc.readOnly {
+		return 0, fdb.ErrReadOnly
+	}
 	n, events, logs, err := bc.insertChain(chain)
 	events = append(events, &event.Event{Typecode: event.LogsEv, Data: logs})
-	event.SendEvents(events)
+	bc.router.SendEvents(events)
 	return n, err
 }
 
@@ -612,6 +1125,9 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []*event.Event, []*t
 
 		bstart := time.Now()
 		err := bc.validator.ValidateHeader(block.Header(), true)
+		if err == nil && bc.futureDelay(block) > 0 && !bc.HasBlock(block.Hash(), block.NumberU64()) {
+			err = processor.ErrFutureBlock
+		}
 		if err == nil {
 			err = bc.Validator().ValidateBody(block)
 		}
@@ -621,11 +1137,12 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []*event.Event, []*t
 				continue
 			}
 		case err == processor.ErrFutureBlock:
-			max := big.NewInt(time.Now().Unix() + maxTimeFutureBlocks)
-			if block.Time().Cmp(max) > 0 {
-				return i, events, coalescedLogs, fmt.Errorf("future block: %v > %v", block.Time(), max)
+			delay := bc.futureDelay(block)
+			if delay > bc.futureDrift {
+				return i, events, coalescedLogs, fmt.Errorf("future block: %v ahead, allowed %v", delay, bc.futureDrift)
 			}
 			bc.futureBlocks.Add(block.Hash(), block)
+			bc.scheduleFutureBlocks(delay)
 			continue
 		case err == processor.ErrUnknownAncestor && bc.futureBlocks.Contains(block.ParentHash()):
 			bc.futureBlocks.Add(block.Hash(), block)
@@ -634,12 +1151,12 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []*event.Event, []*t
 			// Block competing with the canonical chain, store in the db, but don't process
 			// until the competitor TD goes above the canonical TD
 			currentBlock := bc.CurrentBlock()
-			localTd := bc.GetTd(currentBlock.Hash(), currentBlock.NumberU64())
 			externTd := new(big.Int).Add(bc.GetTd(block.ParentHash(), block.NumberU64()-1), block.Difficulty())
-			if localTd.Cmp(externTd) >= 0 {
+			if !bc.forkChoice.ReorgNeeded(block.Header(), externTd) {
 				if err = bc.WriteBlockWithoutState(block, externTd); err != nil {
 					return i, events, coalescedLogs, err
 				}
+				markBlocks(1, 1)
 			} else {
 				newchain, err := bc.reorgState(currentBlock, block)
 				if err != nil {
@@ -665,14 +1182,14 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []*event.Event, []*t
 
 		currentBlock := bc.CurrentBlock()
 		if currentBlock.Hash() != block.ParentHash() {
-			// check totally difficulty
-			localTd := bc.GetTd(currentBlock.Hash(), currentBlock.NumberU64())
+			// let the fork choice decide between the chains
 			externTd := new(big.Int).Add(bc.GetTd(block.ParentHash(), block.NumberU64()-1), block.Difficulty())
 
-			if localTd.Cmp(externTd) >= 0 {
+			if !bc.forkChoice.ReorgNeeded(block.Header(), externTd) {
 				if err = bc.WriteBlockWithoutState(block, externTd); err != nil {
 					return i, events, coalescedLogs, err
 				}
+				markBlocks(1, 1)
 				continue
 			} else {
 				_, err := bc.reorgState(currentBlock, block)
@@ -690,29 +1207,40 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []*event.Event, []*t
 			parent = chain[i-1]
 		}
 
-		state, err := state.New(parent.Hash(), bc.stateCache)
-		if err != nil {
-			return i, events, coalescedLogs, err
-		}
+		// a block validated before is committed without executing it again
+		commit := bc.stateCommits.get(block.Hash())
+		if commit == nil {
+			state, err := state.New(parent.Hash(), bc.stateCache)
+			if err != nil {
+				return i, events, coalescedLogs, err
+			}
 
-		receipts, logs, usedGas, err := bc.processor.Process(block, state, bc.vmConfig)
-		if err != nil {
-			bc.reportBlock(block, receipts, err)
-			return i, events, coalescedLogs, err
+			receipts, logs, usedGas, err := bc.processor.Process(block, state, bc.vmConfig)
+			if err != nil {
+				bc.reportBlock(block, receipts, err)
+				return i, events, coalescedLogs, err
+			}
+			err = bc.validator.ValidateState(block, parent, state, receipts, usedGas)
+			if err != nil {
+				bc.reportBlock(block, receipts, err)
+				return i, events, coalescedLogs, err
+			}
+			commit = &stateCommit{state: state, receipts: receipts, logs: logs}
+			bc.stateCommits.add(block.Hash(), commit)
 		}
-		err = bc.validator.ValidateState(block, parent, state, receipts, usedGas)
+		state, receipts, logs := commit.state, commit.receipts, commit.logs
+
+		changes, err := accountmanager.BalanceChanges(state)
 		if err != nil {
-			bc.reportBlock(block, receipts, err)
 			return i, events, coalescedLogs, err
 		}
-
 		if err := bc.WriteBlockWithState(block, receipts, state); err != nil {
 			return i, events, coalescedLogs, err
 		}
 
 		log.Info("Inserted new block", "number", block.Number(), "hash", block.Hash().String(), "time", block.Time().Int64(), "txs", len(block.Txs), "gas", block.GasUsed(), "diff", block.Difficulty(), "elapsed", common.PrettyDuration(time.Since(bstart)))
 		coalescedLogs = append(coalescedLogs, logs...)
-		events = append(events, &event.Event{Typecode: event.ChainEv, Data: ChainEvent{block, block.Hash(), logs}})
+		events = append(events, &event.Event{Typecode: event.ChainEv, Data: ChainEvent{block, block.Hash(), logs, changes}})
 		lastCanon = block
 	}
 
@@ -778,33 +1306,92 @@ func (bc *BlockChain) reorgState(oldBlock, newBlock *types.Block) (types.Blocks,
 	}
 
 	var addedTxs []*types.Transaction
+	batch := bc.db.NewBatch()
 	for i := len(newChain) - 1; i >= 0; i-- {
-		rawdb.WriteTxLookupEntries(bc.db, newChain[i])
+		rawdb.WriteTxLookupEntries(batch, newChain[i])
 		addedTxs = append(addedTxs, newChain[i].Txs...)
 	}
 
 	diff := types.TxDifference(deletedTxs, addedTxs)
-	batch := bc.db.NewBatch()
 	for _, tx := range diff {
 		rawdb.DeleteTxLookupEntry(batch, tx.Hash())
 	}
-	batch.Write()
-
-	if len(oldChain) > 0 {
-		go func() {
-			for _, block := range oldChain {
-				event.SendEvent(&event.Event{Typecode: event.ChainSideEv, Data: block})
-			}
-		}()
-		//  rollback state
-		if err := state.TransToSpecBlock(bc.db, bc.stateCache, bc.CurrentBlock().Hash(), oldBlock.Hash()); err != nil {
+	if len(oldChain) == 0 {
+		if err := batch.Write(); err != nil {
 			return nil, err
 		}
-		bc.currentBlock.Store(oldBlock)
+		return newChain, nil
+	}
+
+	conflicts := types.TxConflicts(deletedTxs, addedTxs)
+	for _, conflict := range conflicts {
+		conflict.Number, conflict.Time = newChain[0].NumberU64(), time.Now().Unix()
+		log.Warn("Conflicting transaction dropped by reorg", "account", conflict.Account, "nonce", conflict.Nonce, "dropped", conflict.First, "canonical", conflict.Second)
+	}
+	go func() {
+		for _, block := range oldChain {
+			bc.router.SendEvent(&event.Event{Typecode: event.ChainSideEv, Data: block})
+		}
+		for _, conflict := range conflicts {
+			bc.router.SendEvent(&event.Event{Typecode: event.TxConflictEv, Data: conflict})
+		}
+	}()
+	blockReorgMeter.Mark(int64(len(oldChain)))
+	// rollback state, the common block becomes the head in the same write
+	rawdb.WriteHeadBlockHash(batch, oldBlock.Hash())
+	bc.revertAssetStats(batch, oldChain, oldBlock)
+	if bc.txSearch {
+		for _, block := range oldChain {
+			rawdb.DeleteTxSearchEntries(batch, block)
+		}
 	}
+	if err := state.TransToSpecBlock(batch, bc.db, bc.stateCache, bc.CurrentBlock().Hash(), oldBlock.Hash()); err != nil {
+		return nil, err
+	}
+	bc.currentBlock.Store(oldBlock)
 	return newChain, nil
 }
 
+// forwardChainHead relays the chain head events posted on the router, by the
+// chain itself or by the miner, to the chain head subscribers.
+func (bc *BlockChain) forwardChainHead(ch chan *event.Event, sub event.Subscription) {
+	for {
+		select {
+		case e := <-ch:
+			if block, ok := e.Data.(*types.Block); ok && block != nil {
+				bc.chainHeadFeed.Send(block)
+			}
+		case <-sub.Err():
+			return
+		case <-bc.quit:
+			return
+		}
+	}
+}
+
+// ClockDrift returns the estimator of the drift of the local clock from the
+// block timestamps of the network.
+func (bc *BlockChain) ClockDrift() *ClockDrift {
+	return bc.clockDrift
+}
+
+// Checkpoints returns the pool of the checkpoints co-signed by the producers.
+func (bc *BlockChain) Checkpoints() *CheckpointPool {
+	return bc.checkpoints
+}
+
+// Router returns the router the chain exchanges its events through, nil for
+// the default one.
+func (bc *BlockChain) Router() *event.Router {
+	return bc.router
+}
+
+// SubscribeChainHeadEvent registers a subscription receiving every new
+// canonical head block.
+func (bc *BlockChain) SubscribeChainHeadEvent(ch chan<- *types.Block) event.Subscription {
+	return bc.chainHeadFeed.Subscribe(ch)
+}
+
 func (bc *BlockChain) update() {
 	futureTimer := time.NewTicker(5 * time.Second)
 	defer futureTimer.Stop()
@@ -818,6 +1405,46 @@ func (bc *BlockChain) update() {
 	}
 }
 
+// compactReceipts deflates the receipts of the finished epochs with the
+// dictionary of their epoch, see rawdb.CompactReceiptsEpoch. An epoch is
+// compacted once the head is an epoch past its end, out of the reach of
+// reorganisations, and skipped if it already has a dictionary: an interrupted
+// compaction is finished by the compactreceipts command.
+func (bc *BlockChain) compactReceipts() {
+	ticker := time.NewTicker(receiptsCompactInterval)
+	defer ticker.Stop()
+	var epoch uint64
+	for {
+		select {
+		case <-ticker.C:
+			head := bc.CurrentBlock().NumberU64()
+			for ; (epoch+2)*rawdb.ReceiptsEpochLength <= head+1; epoch++ {
+				select {
+				case <-bc.quit:
+					return
+				default:
+				}
+				if len(rawdb.ReadReceiptsDict(bc.db, epoch)) != 0 {
+					continue
+				}
+				start := time.Now()
+				bc.wg.Add(1)
+				compacted, saved, err := rawdb.CompactReceiptsEpoch(bc.db, epoch)
+				bc.wg.Done()
+				if err != nil {
+					log.Warn("Failed to compact receipts", "epoch", epoch, "err", err)
+					continue
+				}
+				if compacted > 0 {
+					log.Info("Compacted receipts", "epoch", epoch, "blocks", compacted, "saved", common.StorageSize(saved), "elapsed", common.PrettyDuration(time.Since(start)))
+				}
+			}
+		case <-bc.quit:
+			return
+		}
+	}
+}
+
 // BadBlocks returns a list of the last 'bad blocks' that the client has seen on the network
 func (bc *BlockChain) BadBlocks() []*types.Block {
 	blocks := make([]*types.Block, 0, bc.badBlocks.Len())
@@ -894,6 +1521,9 @@ func (bc *BlockChain) GetTdByHash(hash common.Hash) *big.Int {
 
 // WriteTd stores a block's total difficulty into the database, also caching it along the way.
 func (bc *BlockChain) WriteTd(hash common.Hash, number uint64, td *big.Int) error {
+	if bc.readOnly {
+		return fdb.ErrReadOnly
+	}
 	rawdb.WriteTd(bc.db, hash, number, td)
 	bc.tdCache.Add(hash, new(big.Int).Set(td))
 	return nil
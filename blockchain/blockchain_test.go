@@ -17,9 +17,17 @@
 package blockchain
 
 import (
+	"math/big"
 	"testing"
+	"time"
 
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/processor/vm"
 	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
 )
 
 func TestTheLastBlock(t *testing.T) {
@@ -79,8 +87,442 @@ func TestFullTxChain(t *testing.T) {
 		t.Error("newCanonical err", err)
 	}
 	prods, ht := makeProduceAndTime(st, 100)
-	_, _, _, err = makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+
+	// Check that the receipts account for the fees exactly.
+	for _, block := range blocks {
+		receipts := rawdb.ReadReceipts(db, block.Hash(), block.NumberU64())
+		if len(receipts) != len(block.Transactions()) {
+			t.Fatalf("block %d: %d receipts, want %d", block.NumberU64(), len(receipts), len(block.Transactions()))
+		}
+		var cumulative uint64
+		for i, receipt := range receipts {
+			tx := block.Transactions()[i]
+			cumulative += receipt.TotalGasUsed
+			fee := new(big.Int).Mul(tx.GasPrice(), new(big.Int).SetUint64(receipt.TotalGasUsed))
+			if receipt.CumulativeGasUsed != cumulative || receipt.FeeAssetID != tx.GasAssetID() || receipt.Fee.Cmp(fee) != 0 {
+				t.Fatalf("block %d receipt %d: cumulative gas %d fee %v of %d, want %d %v of %d",
+					block.NumberU64(), i, receipt.CumulativeGasUsed, receipt.Fee, receipt.FeeAssetID, cumulative, fee, tx.GasAssetID())
+			}
+			if len(receipt.FeePayments) != 1 || receipt.FeePayments[0].Kind != types.FeeToProducer ||
+				receipt.FeePayments[0].Recipient != block.Coinbase() || receipt.FeePayments[0].Amount.Cmp(fee) != 0 {
+				t.Fatalf("block %d receipt %d: wrong fee payments %v", block.NumberU64(), i, receipt.FeePayments)
+			}
+		}
+		if cumulative != block.GasUsed() {
+			t.Fatalf("block %d: receipts use %d gas, block %d", block.NumberU64(), cumulative, block.GasUsed())
+		}
+	}
+}
+
+func TestRecoverStateCommit(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, nil)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+	if marker := rawdb.ReadStateCommitMarker(chain.db); marker != (common.Hash{}) {
+		t.Fatalf("state commit marker left behind: %x", marker)
+	}
+
+	// Simulate a crash leaving the stored head behind the committed state.
+	head := blocks[len(blocks)-1]
+	rawdb.WriteStateCommitMarker(chain.db, head.Hash())
+	rawdb.WriteHeadBlockHash(chain.db, head.ParentHash())
+	if err := chain.loadLastBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if chain.CurrentBlock().Hash() != head.Hash() || rawdb.ReadHeadBlockHash(chain.db) != head.Hash() {
+		t.Fatalf("recovered head mismatch: have %x, want %x", chain.CurrentBlock().Hash(), head.Hash())
+	}
+	if marker := rawdb.ReadStateCommitMarker(chain.db); marker != (common.Hash{}) {
+		t.Fatalf("state commit marker not removed: %x", marker)
+	}
+}
+
+func TestRecoverChainIntent(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, nil)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+	if intent := rawdb.ReadChainIntent(chain.db); intent != nil {
+		t.Fatalf("chain intent left behind: %v", intent)
+	}
+
+	// Simulate a crash while inserting a block, the batch never written.
+	head := blocks[len(blocks)-1]
+	rawdb.WriteChainIntent(chain.db, &rawdb.ChainIntent{Op: rawdb.IntentInsertBlock, Hash: common.Hash{1}, Number: head.NumberU64() + 1, Head: head.Hash()})
+	if err := chain.loadLastBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if chain.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("rolled back head mismatch: have %x, want %x", chain.CurrentBlock().Hash(), head.Hash())
+	}
+	if intent := rawdb.ReadChainIntent(chain.db); intent != nil {
+		t.Fatalf("chain intent not removed: %v", intent)
+	}
+
+	// Simulate a crash while setting the head, only the head block written.
+	target := blocks[len(blocks)-3]
+	rawdb.WriteChainIntent(chain.db, &rawdb.ChainIntent{Op: rawdb.IntentSetHead, Hash: target.Hash(), Number: target.NumberU64(), Head: head.Hash()})
+	rawdb.WriteHeadBlockHash(chain.db, target.Hash())
+	if err := chain.loadLastBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if chain.CurrentBlock().Hash() != target.Hash() {
+		t.Fatalf("replayed head mismatch: have %x, want %x", chain.CurrentBlock().Hash(), target.Hash())
+	}
+	if hash := rawdb.ReadHeadFastBlockHash(chain.db); hash != target.Hash() {
+		t.Fatalf("replayed fast head mismatch: have %x, want %x", hash, target.Hash())
+	}
+	if hash := rawdb.ReadHeadHeaderHash(chain.db); hash != target.Hash() {
+		t.Fatalf("replayed head header mismatch: have %x, want %x", hash, target.Hash())
+	}
+	if intent := rawdb.ReadChainIntent(chain.db); intent != nil {
+		t.Fatalf("chain intent not removed: %v", intent)
+	}
+	if _, err := chain.State(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepairHead(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, nil)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+
+	// Lose the total difficulty of the head block.
+	head := blocks[len(blocks)-1]
+	rawdb.DeleteTd(chain.db, head.Hash(), head.NumberU64())
+	if err := chain.loadLastBlock(); err != nil {
+		t.Fatal(err)
+	}
+	parent := blocks[len(blocks)-2]
+	if chain.CurrentBlock().Hash() != parent.Hash() || rawdb.ReadHeadBlockHash(chain.db) != parent.Hash() {
+		t.Fatalf("repaired head mismatch: have %x, want %x", chain.CurrentBlock().Hash(), parent.Hash())
+	}
+	if hash := rawdb.ReadHeadHeaderHash(chain.db); hash != parent.Hash() {
+		t.Fatalf("repaired head header mismatch: have %x, want %x", hash, parent.Hash())
+	}
+	if hash := rawdb.ReadCanonicalHash(chain.db, head.NumberU64()); hash != (common.Hash{}) {
+		t.Fatalf("canonical hash above head left behind: %x", hash)
+	}
+	if hash := rawdb.ReadOptBlockHash(chain.db); hash != parent.Hash() {
+		t.Fatalf("state not rewound: have %x, want %x", hash, parent.Hash())
+	}
+	if _, err := chain.State(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnlyBlockChain(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+
+	rochain, err := NewReadOnlyBlockChain(chain.db, nil, chain.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rochain.Stop()
+	head := blocks[len(blocks)-1]
+	if rochain.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", rochain.CurrentBlock().Hash(), head.Hash())
+	}
+	if _, err := rochain.State(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rochain.InsertChain(blocks); err != fdb.ErrReadOnly {
+		t.Fatalf("insert error mismatch: have %v, want %v", err, fdb.ErrReadOnly)
+	}
+	if err := rochain.SetHead(0); err != fdb.ErrReadOnly {
+		t.Fatalf("set head error mismatch: have %v, want %v", err, fdb.ErrReadOnly)
+	}
+	if rawdb.ReadHeadBlockHash(chain.db) != head.Hash() {
+		t.Fatal("head block hash changed")
+	}
+}
+
+func TestFutureBlock(t *testing.T) {
+	_, _, chain, _, err := newCanonical(t, tengine)
 	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+	block1, block2 := chain.GetBlockByNumber(1), chain.GetBlockByNumber(2)
+
+	newchain := newEmptyChain(t, chain)
+	defer newchain.Stop()
+	// Local time runs 300ms behind block 1.
+	start, ahead := time.Now(), 300*time.Millisecond
+	newchain.now = func() time.Time {
+		return time.Unix(0, block1.Time().Int64()).Add(time.Since(start) - ahead)
+	}
+
+	// A block within the drift is kept and inserted when due.
+	if _, err := newchain.InsertChain(types.Blocks{block1}); err != nil {
+		t.Fatal(err)
+	}
+	if number := newchain.CurrentBlock().NumberU64(); number != 0 {
+		t.Fatalf("future block inserted early, head %d", number)
+	}
+	waitHead(t, newchain, 1)
+
+	// A block beyond the drift is rejected.
+	newchain.futureDrift = 0
+	newchain.now = func() time.Time {
+		return time.Unix(0, block2.Time().Int64()).Add(-time.Second)
+	}
+	if _, err := newchain.InsertChain(types.Blocks{block2}); err == nil {
+		t.Fatal("block beyond the drift inserted")
+	}
+	if number := newchain.CurrentBlock().NumberU64(); number != 1 {
+		t.Fatalf("head mismatch: have %d, want 1", number)
+	}
+}
+
+func TestSubscribeChainHeadEvent(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	ch := make(chan *types.Block, 64)
+	sub := chain.SubscribeChainHeadEvent(ch)
+	defer sub.Unsubscribe()
+
+	prods, ht := makeProduceAndTime(st, 1)
+	if _, _, _, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, nil); err != nil {
 		t.Error("makeNewChain err", err)
 	}
+	head := chain.CurrentBlock()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case block := <-ch:
+			if block.Hash() == head.Hash() {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("head %d not notified", head.NumberU64())
+		}
+	}
+}
+
+func TestBlockLimits(t *testing.T) {
+	_, _, chain, _, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+	// Block 1 registers the producers in several transactions of several
+	// actions.
+	block1 := chain.GetBlockByNumber(1)
+	var actions int
+	for _, tx := range block1.Transactions() {
+		if n := len(tx.GetActions()); n > actions {
+			actions = n
+		}
+	}
+	if len(block1.Transactions()) < 2 || actions < 2 {
+		t.Fatalf("block 1 has %d transactions of up to %d actions, want several", len(block1.Transactions()), actions)
+	}
+
+	newchain := newEmptyChain(t, chain)
+	defer newchain.Stop()
+	cfg := newchain.Config()
+	defer func(limits *params.BlockLimitsConfig, fork *big.Int) {
+		cfg.BlockLimits, cfg.BlockLimitsBlock = limits, fork
+	}(cfg.BlockLimits, cfg.BlockLimitsBlock)
+	cfg.BlockLimitsBlock = big.NewInt(1)
+
+	tests := []struct {
+		limits *params.BlockLimitsConfig
+		err    error
+	}{
+		{&params.BlockLimitsConfig{MaxSize: uint64(block1.Size()) - 1}, processor.ErrBlockTooLarge},
+		{&params.BlockLimitsConfig{MaxTxs: uint64(len(block1.Transactions())) - 1}, processor.ErrTooManyTxs},
+		{&params.BlockLimitsConfig{MaxTxActions: uint64(actions) - 1}, processor.ErrTooManyActions},
+		{&params.BlockLimitsConfig{MaxSize: uint64(block1.Size()), MaxTxs: uint64(len(block1.Transactions())), MaxTxActions: uint64(actions)}, nil},
+	}
+	for i, test := range tests {
+		cfg.BlockLimits = test.limits
+		if _, err := newchain.InsertChain(types.Blocks{block1}); err != test.err {
+			t.Fatalf("test %d: insert error mismatch: have %v, want %v", i, err, test.err)
+		}
+	}
+	if newchain.CurrentBlock().Hash() != block1.Hash() {
+		t.Fatal("block within the limits not inserted")
+	}
+
+	// Blocks before the fork block aren't bounded.
+	prefork := newEmptyChain(t, chain)
+	defer prefork.Stop()
+	cfg.BlockLimits = &params.BlockLimitsConfig{MaxSize: 1, MaxTxs: 1, MaxTxActions: 1}
+	cfg.BlockLimitsBlock = big.NewInt(2)
+	if _, err := prefork.InsertChain(types.Blocks{block1}); err != nil {
+		t.Fatalf("block before the fork not inserted: %v", err)
+	}
+}
+
+func TestTxSearchIndex(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+	chain.txSearch = true
+	tail := chain.CurrentBlock().NumberU64() + 1
+
+	// skip some slots so that a chain using every slot is heavier
+	prods, ht := makeProduceAndTime(st, 10)
+	skipProds := append(append([]string{}, prods[0:3]...), prods[10:]...)
+	skipHt := append(append([]uint64{}, ht[0:3]...), ht[10:]...)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(skipProds), skipHt, skipProds, makeTransferTx)
+	if err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	if have := rawdb.ReadTxSearchTail(chain.db); have == nil || *have != tail {
+		t.Fatalf("tail mismatch: have %v, want %d", have, tail)
+	}
+	sender := rawdb.TxSearchBySender(chain.Config().SysName)
+	entries, _, err := rawdb.ReadTxSearchEntries(chain.db, sender, 0, chain.CurrentBlock().NumberU64(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(blocks) {
+		t.Fatalf("entries mismatch: have %d, want %d", len(entries), len(blocks))
+	}
+	for i, entry := range entries {
+		if entry.BlockHash != blocks[i].Hash() || entry.TxHash != blocks[i].Txs[0].Hash() {
+			t.Fatalf("entry %d mismatch: have %+v", i, entry)
+		}
+	}
+
+	// the heavier chain without transactions drops the entries
+	genesis1, db1, chain1, _, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain1.Stop()
+	_, _, blocks1, err := makeNewChain(t, genesis1, chain1, &db1, len(prods), ht, prods, nil)
+	if err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	if _, err := chain.InsertChain(blocks1); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _, _ := rawdb.ReadTxSearchEntries(chain.db, sender, 0, chain.CurrentBlock().NumberU64(), 0); len(entries) != 0 {
+		t.Fatalf("entries of the dropped blocks remain: have %d", len(entries))
+	}
+}
+
+func TestOrphanBlocks(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	// skip some slots so that a chain using every slot is heavier
+	prods, ht := makeProduceAndTime(st, 10)
+	skipProds := append(append([]string{}, prods[0:3]...), prods[10:]...)
+	skipHt := append(append([]uint64{}, ht[0:3]...), ht[10:]...)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(skipProds), skipHt, skipProds, makeTransferTx)
+	if err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	for _, block := range blocks {
+		if hashes := rawdb.ReadAllHashes(chain.db, block.NumberU64()); len(hashes) != 1 || hashes[0] != block.Hash() {
+			t.Fatalf("block %d: hashes mismatch before the reorg: have %x", block.NumberU64(), hashes)
+		}
+	}
+
+	genesis1, db1, chain1, _, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain1.Stop()
+	_, _, blocks1, err := makeNewChain(t, genesis1, chain1, &db1, len(prods), ht, prods, nil)
+	if err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	if _, err := chain.InsertChain(blocks1); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks {
+		number := block.NumberU64()
+		canon := rawdb.ReadCanonicalHash(chain.db, number)
+		if canon == block.Hash() {
+			t.Fatalf("block %d: dropped block still canonical", number)
+		}
+		found := false
+		for _, hash := range rawdb.ReadAllHashes(chain.db, number) {
+			found = found || hash == block.Hash()
+		}
+		if !found {
+			t.Fatalf("block %d: dropped block not stored", number)
+		}
+		if chain.GetBlockByHash(block.Hash()) == nil {
+			t.Fatalf("block %d: dropped block not readable", number)
+		}
+	}
+}
+
+func TestResolveTokens(t *testing.T) {
+	db := fdb.NewMemDatabase()
+	gspec := DefaultGenesis()
+	if _, err := gspec.Commit(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every chain resolves the tokens of its config, whoever builds it.
+	config := *gspec.Config
+	config.SysTokenID, config.FeeTokenID = 0, 0
+	chain, err := NewBlockChain(db, nil, vm.Config{}, &config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.Stop()
+	if config.SysTokenID != 1 || config.FeeTokenID != 1 {
+		t.Fatalf("token ids mismatch: have %d and %d, want 1 and 1", config.SysTokenID, config.FeeTokenID)
+	}
+
+	config.FeeToken = "missingcoin"
+	if _, err := NewBlockChain(db, nil, vm.Config{}, &config, nil); err == nil {
+		t.Fatal("chain built with an unknown fee token")
+	}
 }
@@ -0,0 +1,173 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/types"
+)
+
+const maxQueuedBlocks = 64 // Maximum propagated blocks waiting for their parent
+
+// queuedBlock is a propagated block whose parent hasn't arrived yet.
+type queuedBlock struct {
+	block *types.Block
+	td    *big.Int
+}
+
+// markInflight marks the blocks as being inserted, returning false without
+// marking any if one of them already is.
+func (dl *Downloader) markInflight(blocks types.Blocks) bool {
+	dl.importMutex.Lock()
+	defer dl.importMutex.Unlock()
+	for _, block := range blocks {
+		if _, ok := dl.inflight[block.Hash()]; ok {
+			return false
+		}
+	}
+	for _, block := range blocks {
+		dl.inflight[block.Hash()] = struct{}{}
+	}
+	return true
+}
+
+// unmarkInflight clears the in-flight marks of the blocks.
+func (dl *Downloader) unmarkInflight(blocks types.Blocks) {
+	dl.importMutex.Lock()
+	defer dl.importMutex.Unlock()
+	for _, block := range blocks {
+		delete(dl.inflight, block.Hash())
+	}
+}
+
+// insertTask inserts the blocks of a download task, waiting for propagated
+// blocks of the same range which are being inserted meanwhile.
+func (dl *Downloader) insertTask(task *downloadTask) (int, error) {
+	blocks := task.blocks
+	for !dl.markInflight(blocks) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer dl.unmarkInflight(blocks)
+
+	if task.fast {
+		return dl.blockchain.insertFastChain(blocks, task.states)
+	}
+	if task.light {
+		headers := make([]*types.Header, len(blocks))
+		for i, block := range blocks {
+			headers[i] = block.Header()
+		}
+		return dl.blockchain.InsertHeaderChain(headers)
+	}
+	if _, err := dl.blockchain.InsertChain(blocks); err != nil {
+		// bug: try again...
+		log.Error("bug: try again...")
+		time.Sleep(time.Second)
+		return dl.blockchain.InsertChain(blocks)
+	}
+	return 0, nil
+}
+
+// enqueueBlock inserts a block propagated by a remote, unless it is being
+// inserted already. Blocks whose parent is unknown are queued until the
+// parent arrives, and a download is started to fetch it.
+func (dl *Downloader) enqueueBlock(block *types.Block, td *big.Int) {
+	hash := block.Hash()
+	if !dl.blockchain.HasBlockAndState(block.ParentHash(), block.NumberU64()-1) {
+		dl.importMutex.Lock()
+		if _, ok := dl.queued[hash]; !ok {
+			for len(dl.queued) >= maxQueuedBlocks {
+				dl.dropQueued()
+			}
+			dl.queued[hash] = &queuedBlock{block: block, td: td}
+		}
+		dl.importMutex.Unlock()
+		dl.loopStart()
+		return
+	}
+	if !dl.markInflight(types.Blocks{block}) {
+		return
+	}
+	dl.blockchain.wg.Add(1)
+	go dl.importBlock(block, td)
+}
+
+// dropQueued evicts the lowest queued block, the one most likely to be stale.
+// The import mutex must be held.
+func (dl *Downloader) dropQueued() {
+	var lowest *queuedBlock
+	for _, queued := range dl.queued {
+		if lowest == nil || queued.block.NumberU64() < lowest.block.NumberU64() {
+			lowest = queued
+		}
+	}
+	delete(dl.queued, lowest.block.Hash())
+}
+
+// importBlock inserts a block pushed by a remote directly, falling back to
+// a regular download if it doesn't connect to the local chain. The block
+// must be marked in-flight, and the chain wait group added to so that
+// stopping the chain waits for the import.
+func (dl *Downloader) importBlock(block *types.Block, td *big.Int) {
+	defer dl.blockchain.wg.Done()
+	var err error
+	if dl.blockchain.HeaderOnly() {
+		_, err = dl.blockchain.InsertHeaderChain([]*types.Header{block.Header()})
+	} else {
+		_, err = dl.blockchain.InsertChain(types.Blocks{block})
+	}
+	dl.unmarkInflight(types.Blocks{block})
+	if err != nil {
+		log.Debug("Propagated block import failed", "number", block.NumberU64(), "hash", block.Hash(), "err", err)
+		dl.loopStart()
+		return
+	}
+	dl.broadcastStatus(&NewBlockHashesData{
+		Hash:   block.Hash(),
+		Number: block.NumberU64(),
+		TD:     td,
+	})
+	dl.importQueued()
+}
+
+// importQueued inserts the queued blocks whose parent has arrived, and drops
+// those known already.
+func (dl *Downloader) importQueued() {
+	dl.importMutex.Lock()
+	var ready []*queuedBlock
+	for hash, queued := range dl.queued {
+		block := queued.block
+		switch {
+		case dl.blockchain.HasBlock(hash, block.NumberU64()):
+			delete(dl.queued, hash)
+		case dl.blockchain.HasBlockAndState(block.ParentHash(), block.NumberU64()-1):
+			delete(dl.queued, hash)
+			ready = append(ready, queued)
+		}
+	}
+	dl.importMutex.Unlock()
+
+	for _, queued := range ready {
+		if dl.markInflight(types.Blocks{queued.block}) {
+			dl.blockchain.wg.Add(1)
+			go dl.importBlock(queued.block, queued.td)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func waitHead(t *testing.T, chain *BlockChain, number uint64) {
+	for deadline := time.Now().Add(5 * time.Second); chain.CurrentBlock().NumberU64() != number; {
+		if time.Now().After(deadline) {
+			t.Fatalf("head mismatch: have %d, want %d", chain.CurrentBlock().NumberU64(), number)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newEmptyChain returns a chain with the genesis of chain only, validating
+// the blocks inserted.
+func newEmptyChain(t *testing.T, chain *BlockChain) *BlockChain {
+	newdb := fdb.NewMemDatabase()
+	if _, err := DefaultGenesis().Commit(newdb); err != nil {
+		t.Fatal(err)
+	}
+	newchain, err := NewBlockChain(newdb, nil, vm.Config{}, chain.Config(), txpool.SenderCacher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type bc struct {
+		*BlockChain
+		consensus.IEngine
+	}
+	newchain.SetValidator(processor.NewBlockValidator(&bc{newchain, tengine}, tengine))
+	newchain.SetProcessor(processor.NewStateProcessor(&bc{newchain, tengine}, tengine))
+	return newchain
+}
+
+func TestEnqueueBlock(t *testing.T) {
+	_, _, chain, _, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+	var blocks types.Blocks
+	for i := uint64(1); i <= 4; i++ {
+		blocks = append(blocks, chain.GetBlockByNumber(i))
+	}
+
+	newchain := newEmptyChain(t, chain)
+	defer newchain.Stop()
+	dl := newchain.Downloader()
+	td := chain.GetTd(blocks[3].Hash(), blocks[3].NumberU64())
+
+	// Blocks without their parent are queued once.
+	dl.enqueueBlock(blocks[2], td)
+	dl.enqueueBlock(blocks[1], td)
+	dl.enqueueBlock(blocks[2], td)
+	dl.importMutex.Lock()
+	queued := len(dl.queued)
+	dl.importMutex.Unlock()
+	if queued != 2 {
+		t.Fatalf("queued %d blocks, want 2", queued)
+	}
+
+	// The parent releases the queued blocks.
+	dl.enqueueBlock(blocks[0], td)
+	waitHead(t, newchain, 3)
+
+	// A block being inserted already isn't inserted again.
+	if !dl.markInflight(blocks[3:]) {
+		t.Fatal("block in flight before insertion")
+	}
+	dl.enqueueBlock(blocks[3], td)
+	time.Sleep(100 * time.Millisecond)
+	if number := newchain.CurrentBlock().NumberU64(); number != 3 {
+		t.Fatalf("in-flight block inserted twice, head %d", number)
+	}
+	dl.unmarkInflight(blocks[3:])
+	dl.enqueueBlock(blocks[3], td)
+	waitHead(t, newchain, 4)
+}
@@ -0,0 +1,460 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
+)
+
+const (
+	// maxPendingCheckpoints is the number of checkpoints collecting votes at
+	// once, the lowest are dropped for higher ones.
+	maxPendingCheckpoints = 16
+
+	// maxCheckpointSignatures caps the signatures of a checkpoint received
+	// from a remote.
+	maxCheckpointSignatures = 256
+
+	// maxCheckpointSchedule caps the producers of the schedule attested by a
+	// checkpoint received from a remote.
+	maxCheckpointSchedule = 256
+)
+
+var (
+	errNoCheckpointScheduler = errors.New("no checkpoint scheduler")
+	errNoSealVerifier        = errors.New("no seal verifier")
+	errCheckpointQuorum      = errors.New("checkpoint not signed by a producer quorum")
+	errCheckpointPoolFull    = errors.New("too many checkpoints collecting votes")
+	errCheckpointMismatch    = errors.New("chain conflicts with the signed checkpoint")
+	errCheckpointSchedule    = errors.New("checkpoint schedule mismatch")
+	errCheckpointSigner      = errors.New("checkpoint signer not in the attested schedule")
+	errCheckpointSignature   = errors.New("invalid checkpoint signature")
+)
+
+// CheckpointScheduler returns the producer schedule activated at the block
+// with the header and the keys they sign with, read from the state of the
+// block.
+type CheckpointScheduler func(header *types.Header) ([]*types.CheckpointProducer, error)
+
+// SealVerifier checks that a header is sealed by a producer of the schedule
+// with its key.
+type SealVerifier func(header *types.Header, schedule []*types.CheckpointProducer) error
+
+// pendingCheckpoint is a checkpoint collecting the votes of the producers.
+type pendingCheckpoint struct {
+	schedule []*types.CheckpointProducer
+	sigs     map[string]hexutil.Bytes // signatures by producer
+}
+
+// CheckpointPool collects the votes of the producers on the checkpoints of
+// the chain, taken every interval blocks at irreversible blocks. A checkpoint
+// signed by a quorum of the producers is persisted and served to the remotes,
+// letting new nodes pick the pivot of fast sync and reject the remotes on a
+// chain conflicting with it.
+//
+// Each checkpoint attests the producer schedule activated at its block, whose
+// producers sign the next checkpoint. The checkpoints are thus verified one
+// after the other from the genesis schedule, without the state of their
+// blocks.
+type CheckpointPool struct {
+	chain    *BlockChain
+	interval uint64 // blocks between the checkpoints, 0 if none are signed
+
+	mu         sync.Mutex
+	schedule   CheckpointScheduler
+	verifySeal SealVerifier
+	genesis    []*types.CheckpointProducer // schedule signing the first checkpoint
+	pending    map[types.Checkpoint]*pendingCheckpoint
+	latest     *types.SignedCheckpoint
+	voted      uint64 // number of the last checkpoint signed locally
+}
+
+func newCheckpointPool(chain *BlockChain, interval uint64) *CheckpointPool {
+	return &CheckpointPool{
+		chain:    chain,
+		interval: interval,
+		pending:  make(map[types.Checkpoint]*pendingCheckpoint),
+		latest:   rawdb.ReadCheckpoint(chain.db),
+	}
+}
+
+// SetScheduler sets the function reading the producer schedules, no vote is
+// accepted until it is set.
+func (p *CheckpointPool) SetScheduler(schedule CheckpointScheduler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.schedule = schedule
+}
+
+// SetSealVerifier sets the function checking the seals of the headers
+// against a schedule, no header is verified until it is set.
+func (p *CheckpointPool) SetSealVerifier(verify SealVerifier) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.verifySeal = verify
+}
+
+// VerifySeal checks that a header above the latest checkpoint is sealed by a
+// producer of the schedule attested by it, of the genesis schedule if there
+// is none.
+func (p *CheckpointPool) VerifySeal(header *types.Header, latest *types.SignedCheckpoint) error {
+	p.mu.Lock()
+	verify := p.verifySeal
+	p.mu.Unlock()
+	if verify == nil {
+		return errNoSealVerifier
+	}
+	schedule, err := p.signers(latest)
+	if err != nil {
+		return err
+	}
+	return verify(header, schedule)
+}
+
+// Interval returns the number of blocks between the checkpoints.
+func (p *CheckpointPool) Interval() uint64 {
+	return p.interval
+}
+
+// Latest returns the latest checkpoint signed by a quorum of the producers,
+// or nil if none is known.
+func (p *CheckpointPool) Latest() *types.SignedCheckpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.latest
+}
+
+// At returns the signed checkpoint at the block number, or nil if there is
+// none.
+func (p *CheckpointPool) At(number uint64) *types.SignedCheckpoint {
+	if number == 0 {
+		return nil
+	}
+	if sc := p.After(number - 1); sc != nil && sc.Checkpoint.Number == number {
+		return sc
+	}
+	return nil
+}
+
+// After returns the lowest signed checkpoint above the block number, letting
+// the remotes verify the checkpoints one after the other.
+func (p *CheckpointPool) After(number uint64) *types.SignedCheckpoint {
+	if sc := rawdb.ReadCheckpointAfter(p.chain.db, number); sc != nil {
+		return sc
+	}
+	if latest := p.Latest(); latest != nil && latest.Checkpoint.Number > number {
+		return latest
+	}
+	return nil
+}
+
+// dueHeader returns the header of the block the producers sign next, the
+// highest irreversible block at a multiple of the interval, or nil if there
+// is none.
+func (p *CheckpointPool) dueHeader() *types.Header {
+	if p.interval == 0 {
+		return nil
+	}
+	number := p.chain.forkChoice.Irreversible() / p.interval * p.interval
+	if number == 0 {
+		return nil
+	}
+	return p.chain.GetHeaderByNumber(number)
+}
+
+// Due returns the checkpoint the producers sign next and the schedule it
+// attests, or nil if there is none. Hashing the whole state of the block
+// takes a while.
+func (p *CheckpointPool) Due() (*types.Checkpoint, []*types.CheckpointProducer, error) {
+	header := p.dueHeader()
+	if header == nil {
+		return nil, nil, nil
+	}
+	return p.checkpoint(header)
+}
+
+// checkpoint returns the checkpoint at the block with the header and the
+// schedule it attests.
+func (p *CheckpointPool) checkpoint(header *types.Header) (*types.Checkpoint, []*types.CheckpointProducer, error) {
+	p.mu.Lock()
+	schedule := p.schedule
+	p.mu.Unlock()
+	if schedule == nil {
+		return nil, nil, errNoCheckpointScheduler
+	}
+	producers, err := schedule(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	stateHash, err := p.chain.StateHash(header.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+	cp := &types.Checkpoint{
+		Number:    header.Number.Uint64(),
+		Hash:      header.Hash(),
+		Root:      header.Root,
+		StateHash: stateHash,
+		Schedule:  types.ScheduleHash(producers),
+	}
+	return cp, producers, nil
+}
+
+// Vote signs the due checkpoint as the producer, unless a checkpoint at least
+// as high was signed before, and posts the vote to be relayed to the remotes.
+// It returns nil if there was nothing to sign.
+func (p *CheckpointPool) Vote(producer string, sign func(hash []byte) ([]byte, error)) (*types.CheckpointVote, error) {
+	header := p.dueHeader()
+	p.mu.Lock()
+	if header == nil || header.Number.Uint64() <= p.voted {
+		p.mu.Unlock()
+		return nil, nil
+	}
+	p.mu.Unlock()
+	cp, schedule, err := p.checkpoint(header)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	if cp.Number <= p.voted {
+		p.mu.Unlock()
+		return nil, nil
+	}
+	p.voted = cp.Number
+	p.mu.Unlock()
+
+	sig, err := sign(cp.SigHash(p.chain.chainConfig.ChainID).Bytes())
+	if err != nil {
+		return nil, err
+	}
+	vote := &types.CheckpointVote{
+		Checkpoint:          *cp,
+		Schedule:            schedule,
+		CheckpointSignature: types.CheckpointSignature{Producer: producer, Signature: sig},
+	}
+	if _, err := p.AddVote(vote); err != nil {
+		return nil, err
+	}
+	p.chain.router.SendTo(nil, nil, event.CheckpointVoteMsg, vote)
+	return vote, nil
+}
+
+// AddVote adds the vote of a producer on a checkpoint, reporting whether it
+// is new. A checkpoint gathering the votes of a quorum of the producers
+// becomes the latest signed one. The vote is verified without holding the
+// pool lock, and again if a checkpoint was signed meanwhile.
+func (p *CheckpointPool) AddVote(vote *types.CheckpointVote) (bool, error) {
+	for {
+		p.mu.Lock()
+		latest := p.latest
+		if latest != nil && vote.Checkpoint.Number <= latest.Checkpoint.Number {
+			p.mu.Unlock()
+			return false, nil
+		}
+		if pending := p.pending[vote.Checkpoint]; pending != nil {
+			if _, ok := pending.sigs[vote.Producer]; ok {
+				p.mu.Unlock()
+				return false, nil
+			}
+		}
+		p.mu.Unlock()
+
+		signers, err := p.signers(latest)
+		if err != nil {
+			return false, err
+		}
+		if types.ScheduleHash(vote.Schedule) != vote.Checkpoint.Schedule {
+			return false, errCheckpointSchedule
+		}
+		if err := p.verifySignature(signers, vote); err != nil {
+			return false, err
+		}
+
+		p.mu.Lock()
+		if p.latest != latest {
+			p.mu.Unlock()
+			continue
+		}
+		fresh, err := p.addVerifiedVote(vote, quorum(signers))
+		p.mu.Unlock()
+		return fresh, err
+	}
+}
+
+// addVerifiedVote adds a verified vote, assuming the pool lock is held.
+func (p *CheckpointPool) addVerifiedVote(vote *types.CheckpointVote, need int) (bool, error) {
+	pending := p.pending[vote.Checkpoint]
+	if pending == nil {
+		if len(p.pending) >= maxPendingCheckpoints && !p.evictPending(vote.Checkpoint.Number) {
+			return false, errCheckpointPoolFull
+		}
+		pending = &pendingCheckpoint{schedule: vote.Schedule, sigs: make(map[string]hexutil.Bytes)}
+		p.pending[vote.Checkpoint] = pending
+	}
+	if _, ok := pending.sigs[vote.Producer]; ok {
+		return false, nil
+	}
+	pending.sigs[vote.Producer] = vote.Signature
+	if len(pending.sigs) >= need {
+		p.setLatest(vote.Checkpoint, pending.schedule, pending.sigs)
+	}
+	return true, nil
+}
+
+// AddSigned adds a checkpoint signed by a quorum of the producers, usually
+// received from a remote, reporting whether it became the latest one. It
+// must be signed by the producers of the schedule attested by the latest
+// checkpoint, the checkpoints in between are needed first otherwise.
+func (p *CheckpointPool) AddSigned(sc *types.SignedCheckpoint) (bool, error) {
+	if types.ScheduleHash(sc.Schedule) != sc.Checkpoint.Schedule {
+		return false, errCheckpointSchedule
+	}
+	for {
+		latest := p.Latest()
+		if latest != nil && sc.Checkpoint.Number <= latest.Checkpoint.Number {
+			return false, nil
+		}
+		signers, err := p.signers(latest)
+		if err != nil {
+			return false, err
+		}
+		sigs := make(map[string]hexutil.Bytes, len(sc.Signatures))
+		for _, vote := range sc.Votes() {
+			if _, ok := sigs[vote.Producer]; ok {
+				return false, fmt.Errorf("checkpoint signed twice by %v", vote.Producer)
+			}
+			if err := p.verifySignature(signers, vote); err != nil {
+				return false, err
+			}
+			sigs[vote.Producer] = vote.Signature
+		}
+		if len(sigs) < quorum(signers) {
+			return false, errCheckpointQuorum
+		}
+
+		p.mu.Lock()
+		if p.latest != latest {
+			p.mu.Unlock()
+			continue
+		}
+		p.setLatest(sc.Checkpoint, sc.Schedule, sigs)
+		p.mu.Unlock()
+		return true, nil
+	}
+}
+
+// signers returns the producer schedule signing the checkpoints after the
+// latest one, the genesis schedule if there is none.
+func (p *CheckpointPool) signers(latest *types.SignedCheckpoint) ([]*types.CheckpointProducer, error) {
+	if latest != nil {
+		return latest.Schedule, nil
+	}
+	p.mu.Lock()
+	genesis, schedule := p.genesis, p.schedule
+	p.mu.Unlock()
+	if genesis != nil {
+		return genesis, nil
+	}
+	if schedule == nil {
+		return nil, errNoCheckpointScheduler
+	}
+	genesis, err := schedule(p.chain.Genesis().Header())
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.genesis = genesis
+	p.mu.Unlock()
+	return genesis, nil
+}
+
+// verifySignature checks that the vote is signed by a producer of the
+// schedule with its key.
+func (p *CheckpointPool) verifySignature(signers []*types.CheckpointProducer, vote *types.CheckpointVote) error {
+	for _, producer := range signers {
+		if producer.Name != vote.Producer {
+			continue
+		}
+		pubkey, err := crypto.Ecrecover(vote.Checkpoint.SigHash(p.chain.chainConfig.ChainID).Bytes(), vote.Signature)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(pubkey, producer.PubKey) {
+			return errCheckpointSignature
+		}
+		return nil
+	}
+	return errCheckpointSigner
+}
+
+// quorum returns how many votes of distinct producers of the schedule make a
+// quorum.
+func quorum(schedule []*types.CheckpointProducer) int {
+	return len(schedule)*2/3 + 1
+}
+
+// evictPending drops the lowest checkpoint collecting votes if it is lower
+// than number, reporting whether one was dropped.
+func (p *CheckpointPool) evictPending(number uint64) bool {
+	var lowest *types.Checkpoint
+	for cp := range p.pending {
+		if lowest == nil || cp.Number < lowest.Number {
+			cp := cp
+			lowest = &cp
+		}
+	}
+	if lowest == nil || lowest.Number >= number {
+		return false
+	}
+	delete(p.pending, *lowest)
+	return true
+}
+
+// setLatest makes the checkpoint with the signatures the latest signed one,
+// dropping the votes on the lower checkpoints.
+func (p *CheckpointPool) setLatest(cp types.Checkpoint, schedule []*types.CheckpointProducer, sigs map[string]hexutil.Bytes) {
+	sc := &types.SignedCheckpoint{Checkpoint: cp, Schedule: schedule}
+	for producer, sig := range sigs {
+		sc.Signatures = append(sc.Signatures, types.CheckpointSignature{Producer: producer, Signature: sig})
+	}
+	sort.Slice(sc.Signatures, func(i, j int) bool { return sc.Signatures[i].Producer < sc.Signatures[j].Producer })
+
+	p.latest = sc
+	for pending := range p.pending {
+		if pending.Number <= cp.Number {
+			delete(p.pending, pending)
+		}
+	}
+	if !p.chain.readOnly {
+		rawdb.WriteCheckpoint(p.chain.db, sc)
+	}
+	log.Info("New checkpoint signed by producer quorum", "number", cp.Number, "hash", cp.Hash, "signers", len(sc.Signatures))
+	if header := p.chain.GetHeaderByNumber(cp.Number); header != nil && header.Hash() != cp.Hash {
+		log.Warn("Local chain conflicts with the signed checkpoint", "number", cp.Number, "local", header.Hash(), "checkpoint", cp.Hash)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// testCheckpointKeys are the signing keys of the producers a to e.
+var testCheckpointKeys = func() map[string]*ecdsa.PrivateKey {
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, producer := range []string{"a", "b", "c", "d", "e"} {
+		keys[producer], _ = crypto.GenerateKey()
+	}
+	return keys
+}()
+
+// testCheckpointSchedule returns the schedule of the producers signing with
+// the test keys.
+func testCheckpointSchedule(producers ...string) []*types.CheckpointProducer {
+	schedule := make([]*types.CheckpointProducer, 0, len(producers))
+	for _, producer := range producers {
+		schedule = append(schedule, &types.CheckpointProducer{Name: producer, PubKey: crypto.FromECDSAPub(&testCheckpointKeys[producer].PublicKey)})
+	}
+	return schedule
+}
+
+// testCheckpointScheduler schedules the producers a to d at every block,
+// three of them make a quorum.
+func testCheckpointScheduler(header *types.Header) ([]*types.CheckpointProducer, error) {
+	return testCheckpointSchedule("a", "b", "c", "d"), nil
+}
+
+func testCheckpointSign(chain *BlockChain, producer string) func([]byte) ([]byte, error) {
+	return func(hash []byte) ([]byte, error) { return crypto.Sign(hash, testCheckpointKeys[producer]) }
+}
+
+func testCheckpointVote(chain *BlockChain, cp types.Checkpoint, schedule []*types.CheckpointProducer, producer, key string) *types.CheckpointVote {
+	sig, _ := testCheckpointSign(chain, key)(cp.SigHash(chain.chainConfig.ChainID).Bytes())
+	return &types.CheckpointVote{
+		Checkpoint:          cp,
+		Schedule:            schedule,
+		CheckpointSignature: types.CheckpointSignature{Producer: producer, Signature: sig},
+	}
+}
+
+func testSignedCheckpoint(chain *BlockChain, number uint64, schedule []*types.CheckpointProducer, producers ...string) *types.SignedCheckpoint {
+	cp := types.Checkpoint{Number: number, Hash: common.BigToHash(new(big.Int).SetUint64(number)), Schedule: types.ScheduleHash(schedule)}
+	sc := &types.SignedCheckpoint{Checkpoint: cp, Schedule: schedule}
+	for _, producer := range producers {
+		sc.Signatures = append(sc.Signatures, testCheckpointVote(chain, cp, schedule, producer, producer).CheckpointSignature)
+	}
+	return sc
+}
+
+func TestCheckpointPool(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+	prods, ht := makeProduceAndTime(st, 10)
+	if _, _, _, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, nil); err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	head := chain.CurrentBlock().NumberU64()
+	chain.ForkChoice().SetIrreversible(func() uint64 { return head })
+
+	// No checkpoint is due nor vote accepted without a scheduler.
+	pool := newCheckpointPool(chain, 4)
+	if _, err := pool.Vote("a", testCheckpointSign(chain, "a")); err != errNoCheckpointScheduler {
+		t.Fatalf("vote without scheduler: %v", err)
+	}
+	pool.SetScheduler(testCheckpointScheduler)
+	due, schedule, err := pool.Due()
+	if err != nil || due == nil || due.Number != head/4*4 || due.Hash != chain.GetHeaderByNumber(due.Number).Hash() || due.Schedule != types.ScheduleHash(schedule) {
+		t.Fatalf("due checkpoint mismatch: %+v %v", due, err)
+	}
+	if vote, err := pool.Vote("a", testCheckpointSign(chain, "a")); err != nil || vote == nil {
+		t.Fatalf("vote failed: %v", err)
+	}
+	if vote, err := pool.Vote("a", testCheckpointSign(chain, "a")); vote != nil || err != nil {
+		t.Fatalf("checkpoint signed twice: %v %v", vote, err)
+	}
+
+	// Votes of unscheduled producers, with other keys or on another
+	// schedule are rejected, the others counted once.
+	if _, err := pool.AddVote(testCheckpointVote(chain, *due, schedule, "e", "e")); err != errCheckpointSigner {
+		t.Fatalf("vote of unscheduled producer: %v", err)
+	}
+	if _, err := pool.AddVote(testCheckpointVote(chain, *due, schedule, "b", "e")); err != errCheckpointSignature {
+		t.Fatalf("vote signed with another key: %v", err)
+	}
+	if _, err := pool.AddVote(testCheckpointVote(chain, *due, testCheckpointSchedule("b"), "b", "b")); err != errCheckpointSchedule {
+		t.Fatalf("vote on another schedule: %v", err)
+	}
+	if fresh, err := pool.AddVote(testCheckpointVote(chain, *due, schedule, "b", "b")); !fresh || err != nil {
+		t.Fatalf("vote not added: %v", err)
+	}
+	if fresh, _ := pool.AddVote(testCheckpointVote(chain, *due, schedule, "b", "b")); fresh {
+		t.Fatal("vote added twice")
+	}
+	if pool.Latest() != nil {
+		t.Fatal("checkpoint signed without quorum")
+	}
+	if fresh, err := pool.AddVote(testCheckpointVote(chain, *due, schedule, "c", "c")); !fresh || err != nil {
+		t.Fatalf("vote not added: %v", err)
+	}
+	latest := pool.Latest()
+	if latest == nil || latest.Checkpoint != *due || len(latest.Signatures) != 3 || latest.Signatures[0].Producer != "a" || len(latest.Schedule) != 4 {
+		t.Fatalf("signed checkpoint mismatch: %+v", latest)
+	}
+	if stored := rawdb.ReadCheckpoint(db); stored == nil || stored.Checkpoint != *due {
+		t.Fatalf("signed checkpoint not stored: %+v", stored)
+	}
+	if loaded := newCheckpointPool(chain, 4).Latest(); loaded == nil || loaded.Checkpoint != *due {
+		t.Fatalf("signed checkpoint not loaded: %+v", loaded)
+	}
+
+	// Signed checkpoints need a quorum of distinct producers of the schedule
+	// attested by the latest one, here handing over to the producer e.
+	handover := testCheckpointSchedule("e")
+	signed := testSignedCheckpoint(chain, due.Number+4, handover, "a", "b", "b")
+	if _, err := pool.AddSigned(signed); err == nil {
+		t.Fatal("checkpoint signed twice by a producer accepted")
+	}
+	signed.Signatures = signed.Signatures[:2]
+	if _, err := pool.AddSigned(signed); err != errCheckpointQuorum {
+		t.Fatalf("checkpoint without quorum: %v", err)
+	}
+	signed = testSignedCheckpoint(chain, due.Number+4, handover, "a", "b", "d")
+	if ok, err := pool.AddSigned(signed); !ok || err != nil {
+		t.Fatalf("signed checkpoint not added: %v", err)
+	}
+	if ok, _ := pool.AddSigned(latest); ok {
+		t.Fatal("lower checkpoint replaced the latest")
+	}
+	if pool.Latest().Checkpoint != signed.Checkpoint {
+		t.Fatalf("latest checkpoint mismatch: %+v", pool.Latest())
+	}
+	if next := pool.After(due.Number); next == nil || next.Checkpoint != signed.Checkpoint {
+		t.Fatalf("checkpoint after %d mismatch: %+v", due.Number, next)
+	}
+	if next := pool.After(0); next == nil || next.Checkpoint != *due {
+		t.Fatalf("first checkpoint mismatch: %+v", next)
+	}
+
+	// The next checkpoint is only signed by the handed over schedule.
+	if _, err := pool.AddSigned(testSignedCheckpoint(chain, due.Number+8, schedule, "a", "b", "c")); err != errCheckpointSigner {
+		t.Fatalf("checkpoint signed by the previous schedule: %v", err)
+	}
+	if ok, err := pool.AddSigned(testSignedCheckpoint(chain, due.Number+8, schedule, "e")); !ok || err != nil {
+		t.Fatalf("checkpoint signed by the handed over schedule: %v", err)
+	}
+	tampered := testSignedCheckpoint(chain, due.Number+12, schedule, "a")
+	tampered.Schedule = handover
+	if _, err := pool.AddSigned(tampered); err != errCheckpointSchedule {
+		t.Fatalf("checkpoint with tampered schedule: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/types"
+)
+
+const (
+	clockDriftRemotes      = 32               // number of the latest remotes the drift is estimated from
+	minClockDriftRemotes   = 3                // number of remotes needed to estimate the drift
+	maxClockDriftSample    = time.Minute      // delays beyond are of stale blocks rather than of the clock
+	clockDriftWarnCooldown = 10 * time.Minute // minimum time between two warnings
+)
+
+// ClockDrift estimates the drift of the local clock from the timestamps of
+// the new blocks pushed by the remotes. A block is stamped with the start of
+// its slot and pushed once produced, so it arrives after the propagation
+// delay, offset by the drift of the local clock. Only the latest delay of
+// each remote is kept, so a single remote can't skew the estimate, and the
+// median across the remotes is taken as the drift, the propagation delay
+// included.
+type ClockDrift struct {
+	threshold time.Duration // drift beyond which the clock is off, 0 to never warn
+
+	mu      sync.Mutex
+	samples map[string]time.Duration // latest arrival delay of each remote
+	order   []string                 // remotes of the samples, oldest first
+	warned  time.Time
+}
+
+// NewClockDrift creates an estimator warning when the drift exceeds the
+// threshold.
+func NewClockDrift(threshold time.Duration) *ClockDrift {
+	return &ClockDrift{threshold: threshold, samples: make(map[string]time.Duration)}
+}
+
+// Add records the arrival at now of a new block pushed by the remote. The
+// header is expected to be validated, its seal included, as the timestamp of
+// an unverified block is whatever the remote claims.
+func (c *ClockDrift) Add(now time.Time, remote string, header *types.Header) {
+	delay := time.Duration(now.UnixNano() - header.Time.Int64())
+	if delay > maxClockDriftSample || delay < -maxClockDriftSample {
+		return
+	}
+	c.mu.Lock()
+	if _, ok := c.samples[remote]; ok {
+		for i, name := range c.order {
+			if name == remote {
+				c.order = append(c.order[:i], c.order[i+1:]...)
+				break
+			}
+		}
+	} else if len(c.order) >= clockDriftRemotes {
+		delete(c.samples, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.samples[remote] = delay
+	c.order = append(c.order, remote)
+	drift, ok := c.drift()
+	warn := ok && c.exceeds(drift) && now.Sub(c.warned) >= clockDriftWarnCooldown
+	if warn {
+		c.warned = now
+	}
+	c.mu.Unlock()
+
+	if warn {
+		log.Warn("Local clock seems off from the block timestamps of the network, which can prevent producing in the assigned slots", "drift", drift, "threshold", c.threshold)
+		log.Warn("Please enable network time synchronisation in system settings.")
+	}
+}
+
+// Drift returns the estimated drift, positive if the local clock is ahead of
+// the network, and whether enough blocks arrived to estimate it.
+func (c *ClockDrift) Drift() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.drift()
+}
+
+// Exceeded reports whether the estimated drift is beyond the threshold.
+func (c *ClockDrift) Exceeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	drift, ok := c.drift()
+	return ok && c.exceeds(drift)
+}
+
+func (c *ClockDrift) drift() (time.Duration, bool) {
+	if len(c.samples) < minClockDriftRemotes {
+		return 0, false
+	}
+	sorted := make([]time.Duration, 0, len(c.samples))
+	for _, delay := range c.samples {
+		sorted = append(sorted, delay)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2], true
+}
+
+func (c *ClockDrift) exceeds(drift time.Duration) bool {
+	return c.threshold > 0 && (drift > c.threshold || drift < -c.threshold)
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/types"
+)
+
+func TestClockDrift(t *testing.T) {
+	c := NewClockDrift(time.Second)
+	now := time.Unix(1000, 0)
+	stamped := func(delay time.Duration) *types.Header {
+		return &types.Header{Time: big.NewInt(now.Add(-delay).UnixNano())}
+	}
+	remote := func(i int) string { return fmt.Sprintf("remote%d", i) }
+
+	// The drift is estimated once enough remotes pushed blocks, however many
+	// blocks a single remote pushed.
+	for i := 0; i < 2*minClockDriftRemotes; i++ {
+		c.Add(now, remote(0), stamped(100*time.Millisecond))
+	}
+	if _, ok := c.Drift(); ok {
+		t.Fatal("drift estimated from a single remote")
+	}
+	for i := 1; i < minClockDriftRemotes; i++ {
+		c.Add(now, remote(i), stamped(100*time.Millisecond))
+	}
+	if drift, ok := c.Drift(); !ok || drift != 100*time.Millisecond {
+		t.Fatalf("drift mismatch: have %v, %v, want %v", drift, ok, 100*time.Millisecond)
+	}
+	// Stale blocks are ignored and a remote only counts once, so its
+	// outliers don't move the median.
+	c.Add(now, remote(0), stamped(time.Hour))
+	for i := 0; i < clockDriftRemotes; i++ {
+		c.Add(now, remote(minClockDriftRemotes), stamped(5*time.Second))
+	}
+	if drift, _ := c.Drift(); drift != 100*time.Millisecond {
+		t.Fatalf("drift moved by outliers: have %v", drift)
+	}
+	if c.Exceeded() {
+		t.Fatal("drift within the threshold exceeded")
+	}
+	// Blocks from the future mean the local clock is behind, the oldest
+	// remotes are forgotten.
+	for i := 0; i < clockDriftRemotes; i++ {
+		c.Add(now, remote(100+i), stamped(-2*time.Second))
+	}
+	if drift, _ := c.Drift(); drift != -2*time.Second || !c.Exceeded() {
+		t.Fatalf("drift mismatch: have %v, exceeded %v", drift, c.Exceeded())
+	}
+	if len(c.samples) != clockDriftRemotes {
+		t.Fatalf("remotes kept %d, want %d", len(c.samples), clockDriftRemotes)
+	}
+	if NewClockDrift(0).Exceeded() {
+		t.Fatal("drift exceeded without threshold")
+	}
+}
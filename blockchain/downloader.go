@@ -18,36 +18,180 @@ package blockchain
 import (
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	mapset "github.com/deckarep/golang-set"
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/common"
 	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/supervisor"
+	"github.com/hashicorp/golang-lru"
 )
 
 var (
 	emptyHash = common.Hash{}
+
+	errRequestTimeout = errors.New("timeout")
+	errStationClosed  = errors.New("channel closed")
 )
 
 const (
-	maxKnownBlocks = 1024 // Maximum block hashes to keep in the known list (prevent DOS)
+	maxKnownBlocks   = 1024            // Maximum block hashes to keep in the known list per remote (prevent DOS)
+	handshakeTimeout = 5 * time.Second // Time a new remote station has to send its status
 )
 
+// DownloaderConfig are the tunables of the downloader.
+type DownloaderConfig struct {
+	RequestTimeout   time.Duration `mapstructure:"downloader-requesttimeout"`   // time a remote station has to reply to a request
+	SyncInterval     time.Duration `mapstructure:"downloader-syncinterval"`     // time between synchronisations without new announcements
+	BodyFetchRemotes int           `mapstructure:"downloader-bodyfetchremotes"` // remotes asked in turn for a body fetched on demand
+}
+
+// DefaultDownloaderConfig contains the default downloader tunables.
+var DefaultDownloaderConfig = DownloaderConfig{
+	RequestTimeout:   2 * time.Second,
+	SyncInterval:     10 * time.Second,
+	BodyFetchRemotes: 3,
+}
+
+// Clock is the source of time of the downloader.
+type Clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Transport exchanges the events of the downloader with the remote stations.
+type Transport interface {
+	Subscribe(station router.Station, ch chan *router.Event, typecode int, data interface{}) router.Subscription
+	SendTo(from, to router.Station, typecode int, data interface{}) int
+	StationRegister(station router.Station)
+	StationUnregister(station router.Station)
+}
+
+// routerTransport is the transport of an event router, the default one if
+// nil.
+type routerTransport struct {
+	router *router.Router
+}
+
+func (t routerTransport) Subscribe(station router.Station, ch chan *router.Event, typecode int, data interface{}) router.Subscription {
+	return t.router.Subscribe(station, ch, typecode, data)
+}
+
+func (t routerTransport) SendTo(from, to router.Station, typecode int, data interface{}) int {
+	return t.router.SendTo(from, to, typecode, data)
+}
+
+func (t routerTransport) StationRegister(station router.Station) {
+	t.router.StationRegister(station)
+}
+
+func (t routerTransport) StationUnregister(station router.Station) {
+	t.router.StationUnregister(station)
+}
+
+// DownloaderOption configures a downloader.
+type DownloaderOption func(*Downloader)
+
+// WithClock makes the downloader use the clock instead of the system time.
+func WithClock(clock Clock) DownloaderOption {
+	return func(dl *Downloader) { dl.clock = clock }
+}
+
+// WithConfig makes the downloader use the tunables of the config.
+func WithConfig(config DownloaderConfig) DownloaderOption {
+	return func(dl *Downloader) { dl.config = config }
+}
+
+// WithTransport makes the downloader exchange its events through the
+// transport instead of the event router.
+func WithTransport(transport Transport) DownloaderOption {
+	return func(dl *Downloader) { dl.transport = transport }
+}
+
+// stationStatus tracks a remote station. The fields below the station change
+// while the remote is synced with, they are read and written under the mutex.
 type stationStatus struct {
 	station          router.Station
+	version          uint32 // protocol version spoken with the remote
+	caps             uint64 // capabilities advertised by the remote
 	td               *big.Int
 	currentNumber    uint64
 	currentBlockHash common.Hash
 	ancestor         uint64
+	ancestorHash     common.Hash // hash of the last common ancestor verified with the remote
 	errCh            chan struct{}
+	known            *lru.Cache // blocks the remote is known to have
 	mutex            sync.RWMutex
 }
 
+func newStationStatus(station router.Station, td *big.Int, number uint64, hash common.Hash) *stationStatus {
+	known, _ := lru.New(maxKnownBlocks)
+	return &stationStatus{
+		station:          station,
+		version:          ProtocolVersion,
+		caps:             CapBlockStates | CapState | CapTxChunks,
+		td:               td,
+		currentNumber:    number,
+		currentBlockHash: hash,
+		errCh:            make(chan struct{}),
+		known:            known,
+	}
+}
+
+// statusSnapshot is a consistent copy of the status of a remote station.
+type statusSnapshot struct {
+	Version      uint32
+	Caps         uint64
+	Hash         common.Hash // head block announced by the remote
+	Number       uint64
+	TD           *big.Int
+	Ancestor     uint64 // last common ancestor verified with the remote
+	AncestorHash common.Hash
+}
+
+// Status returns a snapshot of the status of the remote, taken at once.
+func (status *stationStatus) Status() statusSnapshot {
+	status.mutex.RLock()
+	defer status.mutex.RUnlock()
+	snapshot := statusSnapshot{
+		Version:      status.version,
+		Caps:         status.caps,
+		Hash:         status.currentBlockHash,
+		Number:       status.currentNumber,
+		Ancestor:     status.ancestor,
+		AncestorHash: status.ancestorHash,
+	}
+	if status.td != nil {
+		snapshot.TD = new(big.Int).Set(status.td)
+	}
+	return snapshot
+}
+
+// setProtocol sets the protocol version and capabilities negotiated with the
+// remote.
+func (status *stationStatus) setProtocol(version uint32, caps uint64) {
+	status.mutex.Lock()
+	status.version, status.caps = version, caps
+	status.mutex.Unlock()
+}
+
+// serves reports whether the remote serves the requests needing the
+// capabilities. Remotes storing the headers only can't serve a download.
+func (status *stationStatus) serves(caps uint64) bool {
+	status.mutex.RLock()
+	defer status.mutex.RUnlock()
+	return status.caps&CapLightOnly == 0 && status.caps&caps == caps
+}
+
 func (status *stationStatus) updateStatus(hash common.Hash, number uint64, td *big.Int) {
 	status.mutex.Lock()
 	status.currentBlockHash = hash
@@ -56,6 +200,34 @@ func (status *stationStatus) updateStatus(hash common.Hash, number uint64, td *b
 	status.mutex.Unlock()
 }
 
+// getAncestor returns the last common ancestor verified with the remote.
+func (status *stationStatus) getAncestor() (uint64, common.Hash) {
+	status.mutex.RLock()
+	defer status.mutex.RUnlock()
+	return status.ancestor, status.ancestorHash
+}
+
+func (status *stationStatus) setAncestor(number uint64, hash common.Hash) {
+	status.mutex.Lock()
+	status.ancestor = number
+	status.ancestorHash = hash
+	status.mutex.Unlock()
+}
+
+// markKnown marks the block as known by the remote, evicting the least
+// recently marked ones once the limit is reached. It reports false if the
+// block was known already.
+func (status *stationStatus) markKnown(hash common.Hash) bool {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	// Get marks a known block as recently marked again.
+	if _, ok := status.known.Get(hash); ok {
+		return false
+	}
+	status.known.Add(hash, nil)
+	return true
+}
+
 func (status *stationStatus) getStatus() (common.Hash, uint64, *big.Int) {
 	status.mutex.RLock()
 	defer status.mutex.RUnlock()
@@ -64,15 +236,39 @@ func (status *stationStatus) getStatus() (common.Hash, uint64, *big.Int) {
 
 type Downloader struct {
 	station         router.Station
+	config          DownloaderConfig
+	clock           Clock
+	transport       Transport
 	statusCh        chan *router.Event
 	remotes         map[string]*stationStatus
 	remotesMutex    sync.RWMutex
 	blockchain      *BlockChain
 	downloading     int32
+	startingBlock   uint64
 	downloadTrigger chan struct{}
 	// bloom           HashBloom
-	maxNumber   uint64
-	knownBlocks mapset.Set
+	mode  uint32 // SyncMode
+	pivot uint64 // highest block imported by its state changes in fast sync
+
+	importMutex sync.Mutex
+	inflight    map[common.Hash]struct{}     // blocks being inserted
+	queued      map[common.Hash]*queuedBlock // propagated blocks waiting for their parent
+
+	healMutex sync.Mutex
+	heal      StateHealProgress // progress of the state heal after fast sync
+
+	subs     []router.Subscription
+	quit     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// SyncProgress gives the state of the chain synchronisation.
+type SyncProgress struct {
+	StartingBlock uint64 // head number when the last download began
+	CurrentBlock  uint64 // current head number
+	HighestBlock  uint64 // highest head number announced by the remotes
+	Syncing       bool   // set while blocks are downloaded
 }
 
 // type HashBloom [256]byte
@@ -102,63 +298,278 @@ type Downloader struct {
 // 	return bloom.And(bloom, cmp).Cmp(cmp) == 0
 // }
 
-// NewDownloader .
-func NewDownloader(chain *BlockChain) *Downloader {
+// Mode returns the current sync mode.
+func (dl *Downloader) Mode() SyncMode {
+	return SyncMode(atomic.LoadUint32(&dl.mode))
+}
+
+// SetMode changes the sync mode, taking effect with the next download.
+func (dl *Downloader) SetMode(mode SyncMode) error {
+	if !mode.IsValid() {
+		return fmt.Errorf("unknown sync mode %d", mode)
+	}
+	if mode == LightSync && !dl.blockchain.HeaderOnly() {
+		return errors.New("light sync requires a header-only chain database")
+	}
+	if mode != LightSync && dl.blockchain.HeaderOnly() {
+		return errors.New("a header-only chain database needs light sync")
+	}
+	if mode == FastSync {
+		atomic.StoreUint64(&dl.pivot, 0)
+	}
+	dl.switchMode(dl.Mode(), mode)
+	return nil
+}
+
+// switchMode changes the sync mode from the given one, posting a SyncModeEv
+// if it changed.
+func (dl *Downloader) switchMode(from, to SyncMode) {
+	if from == to || !atomic.CompareAndSwapUint32(&dl.mode, uint32(from), uint32(to)) {
+		return
+	}
+	log.Info("Sync mode switched", "from", from, "to", to)
+	dl.transport.SendTo(nil, nil, router.SyncModeEv, SyncModeEvent{From: from, To: to})
+}
+
+// fastSyncPivot returns the highest block to import by its state changes when
+// syncing with a remote at the given head, or 0 if fast sync isn't used.
+func (dl *Downloader) fastSyncPivot(remoteNumber uint64) uint64 {
+	if dl.Mode() != FastSync {
+		return 0
+	}
+	if pivot := atomic.LoadUint64(&dl.pivot); pivot != 0 {
+		return pivot
+	}
+	if remoteNumber <= fastSyncMinFullBlocks {
+		dl.switchMode(FastSync, FullSync)
+		return 0
+	}
+	pivot := remoteNumber - fastSyncMinFullBlocks
+	// a lower checkpoint signed by the producers is preferred, the state the
+	// sync continues from is then the one they agreed on.
+	if latest := dl.blockchain.checkpoints.Latest(); latest != nil {
+		if number := latest.Checkpoint.Number; number < pivot && number > dl.blockchain.CurrentBlock().NumberU64() {
+			pivot = number
+		}
+	}
+	atomic.StoreUint64(&dl.pivot, pivot)
+	log.Info("Fast sync pivot selected", "number", pivot)
+	return pivot
+}
+
+// checkCheckpoint verifies that the chain of a remote contains the latest
+// signed checkpoint if the blocks between start and end cover it.
+func (dl *Downloader) checkCheckpoint(from router.Station, status *stationStatus, start, end uint64) error {
+	latest := dl.blockchain.checkpoints.Latest()
+	if latest == nil || latest.Checkpoint.Number < start || latest.Checkpoint.Number > end {
+		return nil
+	}
+	hashes, err := dl.getBlockHashes(from, status.station, &getBlcokHashByNumber{
+		Number:  latest.Checkpoint.Number,
+		Amount:  1,
+		Skip:    0,
+		Reverse: false}, status.errCh)
+	if err != nil {
+		return err
+	}
+	if len(hashes) != 1 || hashes[0] != latest.Checkpoint.Hash {
+		return errCheckpointMismatch
+	}
+	return nil
+}
+
+// checkPivot switches fast sync to full sync once the state of the pivot
+// block is complete, the blocks above are executed as usual from then on.
+// The state of the pivot is healed against the remotes meanwhile, if it is a
+// signed checkpoint committing to it.
+func (dl *Downloader) checkPivot() {
+	pivot := atomic.LoadUint64(&dl.pivot)
+	if dl.Mode() != FastSync || pivot == 0 {
+		return
+	}
+	head := dl.blockchain.CurrentBlock()
+	if head.NumberU64() >= pivot && dl.blockchain.HasState(head.Hash()) {
+		dl.switchMode(FastSync, FullSync)
+		if header := dl.blockchain.GetHeaderByNumber(pivot); header != nil {
+			dl.startHeal(header.Hash(), pivot)
+		}
+	}
+}
+
+// NewDownloader creates a downloader of the blocks of the chain, using the
+// system time and the event router of the chain unless options say otherwise.
+func NewDownloader(chain *BlockChain, opts ...DownloaderOption) *Downloader {
 	dl := &Downloader{
 		station:         router.NewLocalStation("downloader", nil),
+		config:          DefaultDownloaderConfig,
+		clock:           systemClock{},
+		transport:       routerTransport{chain.router},
 		statusCh:        make(chan *router.Event),
 		blockchain:      chain,
 		remotes:         make(map[string]*stationStatus),
 		downloadTrigger: make(chan struct{}, 1),
-		knownBlocks:     mapset.NewSet(),
+		inflight:        make(map[common.Hash]struct{}),
+		queued:          make(map[common.Hash]*queuedBlock),
+		quit:            make(chan struct{}),
+	}
+	if chain.HeaderOnly() {
+		dl.mode = uint32(LightSync)
+	}
+	for _, opt := range opts {
+		opt(dl)
 	}
-	go dl.syncstatus()
-	go dl.loop()
+	dl.subs = append(dl.subs,
+		dl.transport.Subscribe(nil, dl.statusCh, router.NewBlockHashesMsg, &NewBlockHashesData{}),
+		dl.transport.Subscribe(nil, dl.statusCh, router.NewBlockMsg, &newBlockData{}),
+		dl.transport.Subscribe(nil, dl.statusCh, router.NewMinedEv, NewMinedBlockEvent{}),
+	)
+	dl.wg.Add(2)
+	go dl.supervise("downloader status", dl.syncstatus)
+	go dl.supervise("downloader", dl.loop)
 	return dl
 }
 
-func (dl *Downloader) broadcastStatus(blockhash *NewBlockHashesData) {
-	// if blockhash.Number <= dl.maxNumber && dl.bloom.Test(blockhash.Hash) {
-	// 	return
-	// }
-	// dl.bloom.Add(blockhash.Hash)
+// supervise runs the loop of the downloader, restarting it on panics until
+// the downloader stops.
+func (dl *Downloader) supervise(name string, loop func()) {
+	defer dl.wg.Done()
+	supervisor.Run(name, dl.quit, loop)
+}
+
+// Stop stops the downloader, waiting for the blocks being downloaded to be
+// inserted.
+func (dl *Downloader) Stop() {
+	dl.stopOnce.Do(func() {
+		for _, sub := range dl.subs {
+			sub.Unsubscribe()
+		}
+		close(dl.quit)
+		dl.wg.Wait()
+		log.Info("Downloader stopped")
+	})
+}
+
+// stopped reports whether the downloader is stopping.
+func (dl *Downloader) stopped() bool {
+	select {
+	case <-dl.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// markRemotes marks the block as known by every remote speaking at least the
+// protocol version, returning the stations of those which weren't known to
+// have it yet.
+func (dl *Downloader) markRemotes(hash common.Hash, version uint32) []router.Station {
+	dl.remotesMutex.RLock()
+	defer dl.remotesMutex.RUnlock()
+	stations := make([]router.Station, 0, len(dl.remotes))
+	for _, status := range dl.remotes {
+		if status.version >= version && status.markKnown(hash) {
+			stations = append(stations, status.station)
+		}
+	}
+	return stations
+}
+
+// markRemote marks the block as known by the remote station it came from.
+func (dl *Downloader) markRemote(from router.Station, hash common.Hash) *stationStatus {
+	status := dl.getStationStatus(from.Name())
+	if status != nil {
+		status.markKnown(hash)
+	}
+	return status
+}
 
-	if blockhash.Number <= dl.maxNumber && dl.knownBlocks.Contains(blockhash.Hash) {
+// sampleClockDrift records the arrival of a new block pushed by the remote
+// for the estimate of the drift of the local clock, once its header, seal
+// included, is valid on the local chain.
+func (dl *Downloader) sampleClockDrift(arrived time.Time, from router.Station, header *types.Header) {
+	validator := dl.blockchain.Validator()
+	if validator == nil || validator.ValidateHeader(header, true) != nil {
 		return
 	}
+	dl.blockchain.clockDrift.Add(arrived, from.Name(), header)
+}
 
-	for dl.knownBlocks.Cardinality() >= maxKnownBlocks {
-		dl.knownBlocks.Pop()
+// broadcastStatus announces the block hash to the remotes not known to have
+// the block.
+func (dl *Downloader) broadcastStatus(blockhash *NewBlockHashesData) {
+	for _, station := range dl.markRemotes(blockhash.Hash, minProtocolVersion) {
+		go dl.transport.SendTo(nil, station, router.NewBlockHashesMsg, blockhash)
 	}
-	dl.knownBlocks.Add(blockhash.Hash)
+}
 
-	dl.maxNumber = blockhash.Number
-	go router.SendTo(nil, router.GetStationByName("broadcast"), router.NewBlockHashesMsg, blockhash)
+// propagateBlock pushes a new block in full to the square root of the
+// remotes not known to have it and announces its hash to the rest of them.
+func (dl *Downloader) propagateBlock(block *types.Block, td *big.Int) {
+	stations := dl.markRemotes(block.Hash(), minProtocolVersion)
+
+	push := int(math.Sqrt(float64(len(stations))))
+	if push == 0 && len(stations) > 0 {
+		push = 1
+	}
+	full := &newBlockData{Block: block, TD: td}
+	hash := &NewBlockHashesData{Hash: block.Hash(), Number: block.NumberU64(), TD: td}
+	for i, station := range stations {
+		if i < push {
+			go dl.transport.SendTo(nil, station, router.NewBlockMsg, full)
+		} else {
+			go dl.transport.SendTo(nil, station, router.NewBlockHashesMsg, hash)
+		}
+	}
 }
 
 func (dl *Downloader) syncstatus() {
-	router.Subscribe(nil, dl.statusCh, router.NewBlockHashesMsg, &NewBlockHashesData{})
-	router.Subscribe(nil, dl.statusCh, router.NewMinedEv, NewMinedBlockEvent{})
 	for {
-		e := <-dl.statusCh
+		var e *router.Event
+		select {
+		case e = <-dl.statusCh:
+		case <-dl.quit:
+			return
+		}
 		// NewMinedEv
 		if e.Typecode == router.NewMinedEv {
 			block := e.Data.(NewMinedBlockEvent).Block
-			dl.broadcastStatus(&NewBlockHashesData{
-				Hash:   block.Hash(),
-				Number: block.NumberU64(),
-				TD:     dl.blockchain.GetTd(block.Hash(), block.NumberU64()),
-			})
+			dl.propagateBlock(block, dl.blockchain.GetTd(block.Hash(), block.NumberU64()))
+			continue
+		}
+		// NewBlockMsg
+		if e.Typecode == router.NewBlockMsg {
+			data := e.Data.(*newBlockData)
+			if data.Block == nil || data.Block.Head == nil || data.TD == nil {
+				continue
+			}
+			block := data.Block
+			arrived := dl.blockchain.now()
+			if status := dl.markRemote(e.From, block.Hash()); status != nil {
+				status.updateStatus(block.Hash(), block.NumberU64(), data.TD)
+			}
+			if dl.blockchain.HasBlock(block.Hash(), block.NumberU64()) {
+				continue
+			}
+			if block.NumberU64() > dl.blockchain.CurrentBlock().NumberU64() {
+				dl.sampleClockDrift(arrived, e.From, block.Header())
+			}
+			if !dl.blockchain.forkChoice.RemoteBetter(block.Hash(), block.NumberU64(), data.TD) {
+				continue
+			}
+			dl.enqueueBlock(block, data.TD)
 			continue
 		}
 		// NewBlockHashesMsg
 		hashdata := e.Data.(*NewBlockHashesData)
-		if status := dl.getStationStatus(e.From.Name()); status != nil {
+		if status := dl.markRemote(e.From, hashdata.Hash); status != nil {
 			status.updateStatus(hashdata.Hash, hashdata.Number, hashdata.TD)
 		}
+		// the block may have been pushed in full already
+		if dl.blockchain.HasBlock(hashdata.Hash, hashdata.Number) {
+			continue
+		}
 
-		head := dl.blockchain.CurrentBlock()
-		if hashdata.TD.Cmp(dl.blockchain.GetTd(head.Hash(), head.NumberU64())) > 0 {
+		if dl.blockchain.forkChoice.RemoteBetter(hashdata.Hash, hashdata.Number, hashdata.TD) {
 			dl.loopStart()
 			dl.broadcastStatus(hashdata)
 		}
@@ -177,18 +588,33 @@ func (dl *Downloader) setStationStatus(status *stationStatus) {
 	dl.remotesMutex.Unlock()
 }
 
-// AddStation .
-func (dl *Downloader) AddStation(station router.Station, td *big.Int, number uint64, hash common.Hash) {
-	status := &stationStatus{
-		station:          station,
-		td:               td,
-		currentNumber:    number,
-		currentBlockHash: hash,
-		errCh:            make(chan struct{}),
+// Progress returns the synchronisation progress of the chain.
+func (dl *Downloader) Progress() SyncProgress {
+	current := dl.blockchain.CurrentBlock().NumberU64()
+	highest := current
+	dl.remotesMutex.RLock()
+	for _, status := range dl.remotes {
+		if _, number, _ := status.getStatus(); number > highest {
+			highest = number
+		}
+	}
+	dl.remotesMutex.RUnlock()
+	return SyncProgress{
+		StartingBlock: atomic.LoadUint64(&dl.startingBlock),
+		CurrentBlock:  current,
+		HighestBlock:  highest,
+		Syncing:       atomic.LoadInt32(&dl.downloading) == 1,
 	}
+}
+
+// AddStation adds a remote station at the head given by its status, speaking
+// the negotiated protocol version and advertising the capabilities.
+func (dl *Downloader) AddStation(station router.Station, td *big.Int, number uint64, hash common.Hash, version uint32, caps uint64) {
+	status := newStationStatus(station, td, number, hash)
+	status.setProtocol(version, caps)
+	status.markKnown(hash)
 	dl.setStationStatus(status)
-	head := dl.blockchain.CurrentBlock()
-	if td.Cmp(dl.blockchain.GetTd(head.Hash(), head.NumberU64())) > 0 {
+	if dl.blockchain.forkChoice.RemoteBetter(hash, number, td) {
 		dl.loopStart()
 	}
 }
@@ -203,77 +629,178 @@ func (dl *Downloader) DelStation(station router.Station) {
 	dl.remotesMutex.Unlock()
 }
 
-func (dl *Downloader) bestStation() *stationStatus {
+// bestStation returns the remote with the preferred head among those serving
+// the requests needing the capabilities.
+func (dl *Downloader) bestStation(caps uint64) *stationStatus {
 	dl.remotesMutex.RLock()
 	defer dl.remotesMutex.RUnlock()
 	var (
 		bestStation *stationStatus
-		bestTd      *big.Int
+		bestHead    *ChainHead
 	)
 	for _, station := range dl.remotes {
-		if td := station.td; bestStation == nil || td.Cmp(bestTd) > 0 {
-			bestStation, bestTd = station, td
+		if !station.serves(caps) {
+			continue
+		}
+		hash, number, td := station.getStatus()
+		head := &ChainHead{Hash: hash, Number: number, Td: td}
+		if bestStation == nil || dl.blockchain.forkChoice.Prefer(bestHead, head) {
+			bestStation, bestHead = station, head
 		}
 	}
 	return bestStation
 }
 
-func waitEvent(errch chan struct{}, ch chan *router.Event, timeout time.Duration) (*router.Event, error) {
-	timer := time.After(timeout)
+func (dl *Downloader) waitEvent(errch chan struct{}, ch chan *router.Event, timeout time.Duration) (*router.Event, error) {
+	timer := dl.clock.After(timeout)
 	select {
 	case e := <-ch:
 		return e, nil
 	case <-timer:
-		return nil, errors.New("timeout")
+		return nil, errRequestTimeout
 	case <-errch:
-		return nil, errors.New("channel closed")
+		return nil, errStationClosed
 	}
 }
 
-func syncReq(e *router.Event, recvCode int, recvData interface{}, errch chan struct{}) (interface{}, error) {
+func (dl *Downloader) syncReq(e *router.Event, recvCode int, recvData interface{}, errch chan struct{}) (interface{}, error) {
 	ch := make(chan *router.Event)
-	sub := router.Subscribe(e.From, ch, recvCode, recvData)
+	sub := dl.transport.Subscribe(e.From, ch, recvCode, recvData)
 	defer sub.Unsubscribe()
-	router.SendEvent(e)
-	return waitEvent(errch, ch, 2*time.Second)
+	dl.transport.SendTo(e.From, e.To, e.Typecode, e.Data)
+	return dl.waitEvent(errch, ch, dl.config.RequestTimeout)
 }
 
-func getBlockHashes(from router.Station, to router.Station, req *getBlcokHashByNumber, errch chan struct{}) ([]common.Hash, error) {
+func (dl *Downloader) getBlockHashes(from router.Station, to router.Station, req *getBlcokHashByNumber, errch chan struct{}) ([]common.Hash, error) {
 	ch := make(chan *router.Event)
-	sub := router.Subscribe(from, ch, router.BlockHashMsg, []common.Hash{})
+	sub := dl.transport.Subscribe(from, ch, router.BlockHashMsg, []common.Hash{})
 	defer sub.Unsubscribe()
-	router.SendTo(from, to, router.DownloaderGetBlockHashMsg, req)
-	e, err := waitEvent(errch, ch, 2*time.Second)
+	dl.transport.SendTo(from, to, router.DownloaderGetBlockHashMsg, req)
+	e, err := dl.waitEvent(errch, ch, dl.config.RequestTimeout)
 	if err != nil {
 		return nil, err
 	}
 	return e.Data.([]common.Hash), nil
 }
 
-func getHeaders(from router.Station, to router.Station, req *getBlockHeadersData, errch chan struct{}) ([]*types.Header, error) {
+func (dl *Downloader) getHeaders(from router.Station, to router.Station, req *getBlockHeadersData, errch chan struct{}) ([]*types.Header, error) {
 	ch := make(chan *router.Event)
-	sub := router.Subscribe(from, ch, router.BlockHeadersMsg, []*types.Header{})
+	sub := dl.transport.Subscribe(from, ch, router.BlockHeadersMsg, []*types.Header{})
 	defer sub.Unsubscribe()
-	router.SendTo(from, to, router.DownloaderGetBlockHeadersMsg, req)
-	e, err := waitEvent(errch, ch, 2*time.Second)
+	dl.transport.SendTo(from, to, router.DownloaderGetBlockHeadersMsg, req)
+	e, err := dl.waitEvent(errch, ch, dl.config.RequestTimeout)
 	if err != nil {
 		return nil, err
 	}
 	return e.Data.([]*types.Header), nil
 }
 
-func getBlocks(from router.Station, to router.Station, hashes []common.Hash, errch chan struct{}) ([]*types.Body, error) {
+func (dl *Downloader) getBlocks(from router.Station, to router.Station, hashes []common.Hash, errch chan struct{}) ([]*types.Body, error) {
 	ch := make(chan *router.Event)
-	sub := router.Subscribe(from, ch, router.BlockBodiesMsg, []*types.Body{})
+	sub := dl.transport.Subscribe(from, ch, router.BlockBodiesMsg, []*types.Body{})
 	defer sub.Unsubscribe()
-	router.SendTo(from, to, router.DownloaderGetBlockBodiesMsg, hashes)
-	e, err := waitEvent(errch, ch, 2*time.Second)
+	dl.transport.SendTo(from, to, router.DownloaderGetBlockBodiesMsg, hashes)
+	e, err := dl.waitEvent(errch, ch, dl.config.RequestTimeout)
 	if err != nil {
 		return nil, err
 	}
 	return e.Data.([]*types.Body), nil
 }
 
+func (dl *Downloader) getBlockStates(from router.Station, to router.Station, hashes []common.Hash, errch chan struct{}) ([]*blockStateData, error) {
+	ch := make(chan *router.Event)
+	sub := dl.transport.Subscribe(from, ch, router.BlockStatesMsg, []*blockStateData{})
+	defer sub.Unsubscribe()
+	dl.transport.SendTo(from, to, router.DownloaderGetBlockStatesMsg, hashes)
+	e, err := dl.waitEvent(errch, ch, dl.config.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return e.Data.([]*blockStateData), nil
+}
+
+func (dl *Downloader) getStateHashes(from router.Station, to router.Station, req *getStateHashesData, errch chan struct{}) (*stateHashesData, error) {
+	ch := make(chan *router.Event)
+	sub := dl.transport.Subscribe(from, ch, router.StateHashesMsg, &stateHashesData{})
+	defer sub.Unsubscribe()
+	dl.transport.SendTo(from, to, router.DownloaderGetStateHashesMsg, req)
+	e, err := dl.waitEvent(errch, ch, dl.config.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return e.Data.(*stateHashesData), nil
+}
+
+func (dl *Downloader) getStateItems(from router.Station, to router.Station, req *getStateItemsData, errch chan struct{}) ([][]byte, error) {
+	ch := make(chan *router.Event)
+	sub := dl.transport.Subscribe(from, ch, router.StateItemsMsg, [][]byte{})
+	defer sub.Unsubscribe()
+	dl.transport.SendTo(from, to, router.DownloaderGetStateItemsMsg, req)
+	e, err := dl.waitEvent(errch, ch, dl.config.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return e.Data.([][]byte), nil
+}
+
+func (dl *Downloader) getBlockTxHashes(from router.Station, to router.Station, hash common.Hash, errch chan struct{}) ([]common.Hash, error) {
+	ch := make(chan *router.Event)
+	sub := dl.transport.Subscribe(from, ch, router.BlockTxHashesMsg, []common.Hash{})
+	defer sub.Unsubscribe()
+	dl.transport.SendTo(from, to, router.DownloaderGetBlockTxHashesMsg, hash)
+	e, err := dl.waitEvent(errch, ch, dl.config.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return e.Data.([]common.Hash), nil
+}
+
+func (dl *Downloader) getBlockTxChunk(from router.Station, to router.Station, req *getBlockTxChunkData, errch chan struct{}) (*blockTxChunkData, error) {
+	ch := make(chan *router.Event)
+	sub := dl.transport.Subscribe(from, ch, router.BlockTxChunkMsg, &blockTxChunkData{})
+	defer sub.Unsubscribe()
+	dl.transport.SendTo(from, to, router.DownloaderGetBlockTxChunkMsg, req)
+	e, err := dl.waitEvent(errch, ch, dl.config.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return e.Data.(*blockTxChunkData), nil
+}
+
+// fetchBody fetches the body of the block of the header for a header-only
+// chain, asking the remotes having the block in turn until one serves a body
+// matching the header.
+func (dl *Downloader) fetchBody(header *types.Header) (*types.Body, error) {
+	number := header.Number.Uint64()
+	var remotes []*stationStatus
+	dl.remotesMutex.RLock()
+	for _, status := range dl.remotes {
+		if _, head, _ := status.getStatus(); head >= number && status.serves(0) {
+			remotes = append(remotes, status)
+		}
+	}
+	dl.remotesMutex.RUnlock()
+	if len(remotes) > dl.config.BodyFetchRemotes {
+		remotes = remotes[:dl.config.BodyFetchRemotes]
+	}
+
+	station := router.NewLocalStation(fmt.Sprintf("body%x", header.Hash()), nil)
+	dl.transport.StationRegister(station)
+	defer dl.transport.StationUnregister(station)
+	for _, status := range remotes {
+		bodies, err := dl.getBlocks(station, status.station, []common.Hash{header.Hash()}, status.errCh)
+		if err != nil || len(bodies) != 1 {
+			continue
+		}
+		if types.DeriveTxMerkleRoot(bodies[0].Transactions) != header.TxsRoot {
+			log.Debug("Remote served a mismatching body", "number", number, "remote", fmt.Sprintf("%x", []byte(status.station.Name())))
+			continue
+		}
+		return bodies[0], nil
+	}
+	return nil, errBodyUnavailable
+}
+
 func (dl *Downloader) findAncestor(from router.Station, to router.Station, headNumber uint64, searchStart uint64, errCh chan struct{}) (uint64, error) {
 	if headNumber < 1 {
 		return 0, nil
@@ -283,7 +810,7 @@ func (dl *Downloader) findAncestor(from router.Station, to router.Station, headN
 		searchLength = 32
 	}
 
-	hashes, err := getBlockHashes(from, to, &getBlcokHashByNumber{headNumber, searchLength, 0, true}, errCh)
+	hashes, err := dl.getBlockHashes(from, to, &getBlcokHashByNumber{headNumber, searchLength, 0, true}, errCh)
 	if err != nil {
 		return 0, err
 	}
@@ -307,7 +834,7 @@ func (dl *Downloader) findAncestor(from router.Station, to router.Station, headN
 			targetNumber := uint64(n) + searchStart
 			var hashes []common.Hash
 
-			hashes, err = getBlockHashes(from, to, &getBlcokHashByNumber{targetNumber, 2, 0, false}, errCh)
+			hashes, err = dl.getBlockHashes(from, to, &getBlcokHashByNumber{targetNumber, 2, 0, false}, errCh)
 			if err != nil {
 				return false // doesn't matter true or false
 			}
@@ -341,30 +868,68 @@ func (dl *Downloader) findAncestor(from router.Station, to router.Station, headN
 	return 0, nil
 }
 
+// cachedAncestor validates the common ancestor verified with the remote in
+// the previous round with a single request, which also fetches the remote hash
+// at headNumber in case the chains have converged since. It reports false if
+// either chain has been reorganised below the cached ancestor.
+func (dl *Downloader) cachedAncestor(from router.Station, status *stationStatus, headNumber uint64) (uint64, bool, error) {
+	number, hash := status.getAncestor()
+	if hash == (common.Hash{}) || number > headNumber {
+		return 0, false, nil
+	}
+	if header := dl.blockchain.GetHeaderByNumber(number); header == nil || header.Hash() != hash {
+		return 0, false, nil
+	}
+	req := &getBlcokHashByNumber{Number: number, Amount: 1}
+	if headNumber > number {
+		req.Amount, req.Skip = 2, headNumber-number-1
+	}
+	hashes, err := dl.getBlockHashes(from, status.station, req, status.errCh)
+	if err != nil {
+		return 0, false, err
+	}
+	if uint64(len(hashes)) != req.Amount || hashes[0] != hash {
+		return 0, false, nil
+	}
+	if req.Amount == 2 && dl.blockchain.HasBlock(hashes[1], headNumber) {
+		return headNumber, true, nil
+	}
+	return number, true, nil
+}
+
 func (dl *Downloader) multiplexDownload(status *stationStatus) bool {
 	log.Debug("multiplexDownload start")
 	defer log.Debug("multiplexDownload end")
 	if status == nil {
 		return false
 	}
-	statusHash, statusNumber, statusTD := status.getStatus()
-	head := dl.blockchain.CurrentBlock()
-	if statusTD.Cmp(dl.blockchain.GetTd(head.Hash(), head.NumberU64())) <= 0 {
+	// the remote keeps announcing blocks meanwhile, the round syncs with the
+	// status it had when it started.
+	snapshot := status.Status()
+	statusHash, statusNumber, statusTD := snapshot.Hash, snapshot.Number, snapshot.TD
+	if !dl.blockchain.forkChoice.RemoteBetter(statusHash, statusNumber, statusTD) {
 		return false
 	}
+	head := dl.blockchain.CurrentBlock()
 
 	stationSearch := router.NewLocalStation("downloaderSearch", nil)
-	router.StationRegister(stationSearch)
-	defer router.StationUnregister(stationSearch)
+	dl.transport.StationRegister(stationSearch)
+	defer dl.transport.StationUnregister(stationSearch)
 
 	headNumber := head.NumberU64()
 	if headNumber > statusNumber {
 		headNumber = statusNumber
 	}
-	ancestor, err := dl.findAncestor(stationSearch, status.station, headNumber, status.ancestor+1, status.errCh)
+	ancestor, cached, err := dl.cachedAncestor(stationSearch, status, headNumber)
 	if err != nil {
 		return false
 	}
+	if !cached {
+		ancestor, err = dl.findAncestor(stationSearch, status.station, headNumber, snapshot.Ancestor+1, status.errCh)
+		if err != nil {
+			return false
+		}
+	}
 
 	downloadStart := ancestor + 1
 	downloadAmount := statusNumber - ancestor
@@ -378,6 +943,13 @@ func (dl *Downloader) multiplexDownload(status *stationStatus) bool {
 		downloadAmount = 1024
 	}
 	downloadEnd := ancestor + downloadAmount
+	if err := dl.checkCheckpoint(stationSearch, status, downloadStart, downloadEnd); err != nil {
+		if err == errCheckpointMismatch {
+			log.Warn("Dropping remote on a chain conflicting with the signed checkpoint", "remote", fmt.Sprintf("%x", []byte(status.station.Name())))
+			dl.transport.SendTo(nil, nil, router.P2pDisconectPeer, status.station)
+		}
+		return false
+	}
 	downloadBulk := uint64(64)
 	var numbers []uint64
 	var hashes []common.Hash
@@ -385,7 +957,7 @@ func (dl *Downloader) multiplexDownload(status *stationStatus) bool {
 	for i := downloadStart; i <= downloadEnd; i += downloadSkip + 1 {
 		numbers = append(numbers, i)
 	}
-	hashes, err = getBlockHashes(stationSearch, status.station, &getBlcokHashByNumber{
+	hashes, err = dl.getBlockHashes(stationSearch, status.station, &getBlcokHashByNumber{
 		Number:  downloadStart,
 		Amount:  uint64(len(numbers)),
 		Skip:    downloadSkip,
@@ -395,7 +967,7 @@ func (dl *Downloader) multiplexDownload(status *stationStatus) bool {
 	}
 	if numbers[len(numbers)-1] != downloadEnd {
 		numbers = append(numbers, downloadEnd)
-		hash, err := getBlockHashes(stationSearch, status.station, &getBlcokHashByNumber{
+		hash, err := dl.getBlockHashes(stationSearch, status.station, &getBlcokHashByNumber{
 			Number:  downloadEnd,
 			Amount:  1,
 			Skip:    0,
@@ -417,17 +989,17 @@ func (dl *Downloader) multiplexDownload(status *stationStatus) bool {
 	log.Debug(info3)
 	info4 := fmt.Sprintf("4 numbers:%d hashes:%d\n", len(numbers), len(hashes))
 	log.Debug(info4)
-	n, err := dl.assignDownloadTask(hashes, numbers)
-	status.ancestor = n
+	n, err := dl.assignDownloadTask(hashes, numbers, dl.fastSyncPivot(statusNumber))
+	if header := dl.blockchain.GetHeaderByNumber(n); header != nil {
+		status.setAncestor(n, header.Hash())
+	}
 	if err != nil {
 		log.Warn(fmt.Sprint("Insert error:", n, err))
 	}
+	dl.checkPivot()
+	dl.importQueued()
 
-	head = dl.blockchain.CurrentBlock()
-	if statusTD.Cmp(dl.blockchain.GetTd(head.Hash(), head.NumberU64())) <= 0 {
-		return false
-	}
-	return true
+	return dl.blockchain.forkChoice.RemoteBetter(statusHash, statusNumber, statusTD)
 }
 
 func (dl *Downloader) loopStart() {
@@ -440,39 +1012,56 @@ func (dl *Downloader) loopStart() {
 
 func (dl *Downloader) loop() {
 	download := func() {
+		atomic.StoreUint64(&dl.startingBlock, dl.blockchain.CurrentBlock().NumberU64())
+		atomic.StoreInt32(&dl.downloading, 1)
+		defer atomic.StoreInt32(&dl.downloading, 0)
 		//for status := dl.bestStation(); dl.download(status); {
-		for status := dl.bestStation(); dl.multiplexDownload(status); {
+		for status := dl.bestStation(0); !dl.stopped() && dl.multiplexDownload(status); {
 		}
 	}
-	timer := time.NewTimer(10 * time.Second)
+	timer := dl.clock.After(dl.config.SyncInterval)
 	for {
 		select {
 		case <-dl.downloadTrigger:
 			download()
-			timer.Stop()
-			timer.Reset(10 * time.Second)
-		case <-timer.C:
+			timer = dl.clock.After(dl.config.SyncInterval)
+		case <-timer:
 			dl.loopStart()
+		case <-dl.quit:
+			return
 		}
 	}
 }
 
-func (dl *Downloader) assignDownloadTask(hashes []common.Hash, numbers []uint64) (uint64, error) {
+// assignDownloadTask downloads and inserts the blocks between the numbers,
+// importing those up to the fast sync pivot by their state changes. It
+// returns the number of the last block inserted in order.
+func (dl *Downloader) assignDownloadTask(hashes []common.Hash, numbers []uint64, pivot uint64) (uint64, error) {
 	log.Debug(fmt.Sprint("assingDownloadTask:", len(hashes), len(numbers), numbers))
+	// fast tasks need the state changes of the blocks too
+	var caps uint64
+	if pivot > 0 {
+		caps = CapBlockStates
+	}
 	workers := new(stack)
 	dl.remotesMutex.RLock()
 	for _, v := range dl.remotes {
-		workers.push(v)
+		if v.serves(caps) {
+			workers.push(v)
+		}
 	}
 	dl.remotesMutex.RUnlock()
 	taskes := new(stack)
 	resultCh := make(chan *downloadTask)
 	for i := len(numbers) - 1; i > 0; i-- {
 		taskes.push(&downloadTask{
+			dl:          dl,
 			startNumber: numbers[i-1],
 			startHash:   hashes[i-1],
 			endNumber:   numbers[i],
 			endHash:     hashes[i],
+			fast:        numbers[i] <= pivot,
+			light:       dl.Mode() == LightSync,
 			result:      resultCh,
 		})
 	}
@@ -489,21 +1078,26 @@ func (dl *Downloader) assignDownloadTask(hashes []common.Hash, numbers []uint64)
 		task.(*downloadTask).worker = worker.(*stationStatus)
 		return task.(*downloadTask)
 	}
+	// the blocks are inserted while the later tasks are still downloading
+	queue := newInsertQueue(numbers[:len(numbers)-1])
+	go queue.run(dl.quit, dl.insertTask)
+
 	maxTask := 16
 	taskCount := 0
 	doTask := func() {
-		for taskCount < maxTask {
+		for taskCount < maxTask && !queue.stopped() {
 			task := getReadyTask()
 			if task == nil {
 				break
 			}
 			taskCount++
-			go task.Do()
+			go func() {
+				supervisor.Run("download task", dl.quit, task.Do)
+				task.result <- task
+			}()
 		}
 	}
 	// todo new station to download
-	//var insertWg sync.WaitGroup
-	insertList := make(map[uint64][]*types.Block, len(numbers)-1)
 	for doTask(); taskCount > 0; doTask() {
 		task := <-resultCh
 		taskCount--
@@ -515,56 +1109,51 @@ func (dl *Downloader) assignDownloadTask(hashes []common.Hash, numbers []uint64)
 			taskes.push(task)
 		} else {
 			workers.push(task.worker)
-			insertList[task.startNumber] = task.blocks
-		}
-	}
-	for _, start := range numbers[:len(numbers)-1] {
-		blocks := insertList[start]
-		if blocks == nil {
-			return start - 1, nil
-		}
-		if _, err := dl.blockchain.InsertChain(blocks); err != nil {
-			// bug: try again...
-			log.Error("bug: try again...")
-			time.Sleep(time.Second)
-			if index, err := dl.blockchain.InsertChain(blocks); err != nil {
-				return blocks[index].NumberU64() - 1, err
+			if !queue.push(task) {
+				taskes.clear()
 			}
 		}
 	}
-	return numbers[len(numbers)-1], nil
+	return queue.wait()
 }
 
 type downloadTask struct {
+	dl          *Downloader
 	worker      *stationStatus
 	startNumber uint64
 	startHash   common.Hash
 	endNumber   uint64
 	endHash     common.Hash
+	fast        bool               // fetch the state changes of the blocks too
+	light       bool               // fetch the headers of the blocks only
 	blocks      []*types.Block     // result blocks, length == 0 means failed
+	states      []*blockStateData  // result state changes of fast blocks
 	errorTotal  int                // total error amount
 	result      chan *downloadTask // result channel
 }
 
+// Do downloads the blocks of the task from its worker, leaving the blocks
+// empty on failure. It is rerun if it panics.
 func (task *downloadTask) Do() {
+	task.blocks, task.states = nil, nil
 	defer func() {
 		task.errorTotal++
-		task.result <- task
 	}()
-	if task.worker.currentNumber < task.endNumber {
+	if task.worker.Status().Number < task.endNumber {
 		return
 	}
 	remote := task.worker.station
+	dl := task.dl
 	station := router.NewLocalStation("dl"+remote.Name(), nil)
-	router.StationRegister(station)
-	defer router.StationUnregister(station)
+	dl.transport.StationRegister(station)
+	defer dl.transport.StationUnregister(station)
 
 	reqHash := &getBlcokHashByNumber{task.startNumber, 2, task.endNumber - task.startNumber - 1, false}
 	if task.endNumber == task.startNumber {
 		reqHash.Skip = 0
 		reqHash.Amount = 1
 	}
-	hashes, err := getBlockHashes(station, remote, reqHash, task.worker.errCh)
+	hashes, err := dl.getBlockHashes(station, remote, reqHash, task.worker.errCh)
 	if err != nil || len(hashes) != int(reqHash.Amount) ||
 		hashes[0] != task.startHash || hashes[len(hashes)-1] != task.endHash {
 		log.Debug(fmt.Sprint("err-1:", err, task.startNumber, task.endNumber, len(hashes)))
@@ -575,7 +1164,7 @@ func (task *downloadTask) Do() {
 		return
 	}
 	downloadAmount := task.endNumber - task.startNumber + 1
-	headers, err := getHeaders(station, remote, &getBlockHeadersData{
+	headers, err := dl.getHeaders(station, remote, &getBlockHeadersData{
 		hashOrNumber{
 			Number: task.startNumber,
 		}, downloadAmount, 0, false,
@@ -593,21 +1182,70 @@ func (task *downloadTask) Do() {
 	for i := 1; i < len(headers); i++ {
 		if headers[i].ParentHash != headers[i-1].Hash() || headers[i].Number.Uint64() != headers[i-1].Number.Uint64()+1 {
 			log.Debug(fmt.Sprintf("err-3: phash:%x n->phash:%x\npn+1:%d n:%d", headers[i-1].Hash(), headers[i].ParentHash, headers[i-1].Number.Uint64()+1, headers[i].Number.Uint64()))
+			// a broken header chain is a protocol violation, ban the peer.
+			dl.transport.SendTo(nil, nil, router.P2pBanPeer, remote)
 			return
 		}
 	}
 
+	if task.light {
+		blocks := make([]*types.Block, len(headers))
+		for i, header := range headers {
+			blocks[i] = types.NewBlockWithHeader(header)
+		}
+		task.blocks = blocks
+		return
+	}
+
+	// the transactions of large blocks are fetched by chunks from several
+	// remotes, so that a slow remote doesn't hold the whole block up.
 	reqHashes := make([]common.Hash, 0, len(headers))
+	chunked := make(map[common.Hash][]*types.Transaction)
 	for _, header := range headers {
-		if header.Hash() != emptyHash {
-			reqHashes = append(reqHashes, header.Hash())
+		if header.Hash() == emptyHash {
+			continue
+		}
+		if header.GasUsed >= chunkedBodyGas {
+			if peers := dl.txChunkPeers(task.worker, header.Number.Uint64()); peers != nil {
+				txs, err := dl.fetchTxChunks(station, header, peers)
+				if err != nil {
+					log.Debug(fmt.Sprint("err-6:", err, header.Number))
+					return
+				}
+				chunked[header.Hash()] = txs
+				continue
+			}
 		}
+		reqHashes = append(reqHashes, header.Hash())
 	}
 
-	bodies, err := getBlocks(station, remote, reqHashes, task.worker.errCh)
-	if err != nil || len(bodies) != len(reqHashes) {
-		log.Debug(fmt.Sprint("err-4:", err, len(bodies), len(reqHashes)))
-		return
+	// bodies replies may be cut short by the serving size limit, keep asking
+	// for the rest until every body arrived or the remote stops delivering.
+	bodies := make([]*types.Body, 0, len(reqHashes))
+	for len(bodies) < len(reqHashes) {
+		part, err := dl.getBlocks(station, remote, reqHashes[len(bodies):], task.worker.errCh)
+		if err != nil || len(part) == 0 || len(bodies)+len(part) > len(reqHashes) {
+			log.Debug(fmt.Sprint("err-4:", err, len(bodies), len(part), len(reqHashes)))
+			return
+		}
+		bodies = append(bodies, part...)
+	}
+
+	var states []*blockStateData
+	if task.fast {
+		hashes := make([]common.Hash, len(headers))
+		for i, header := range headers {
+			hashes[i] = header.Hash()
+		}
+		states = make([]*blockStateData, 0, len(hashes))
+		for len(states) < len(hashes) {
+			part, err := dl.getBlockStates(station, remote, hashes[len(states):], task.worker.errCh)
+			if err != nil || len(part) == 0 || len(states)+len(part) > len(hashes) {
+				log.Debug(fmt.Sprint("err-5:", err, len(states), len(part), len(hashes)))
+				return
+			}
+			states = append(states, part...)
+		}
 	}
 
 	blocks := make([]*types.Block, len(headers))
@@ -615,12 +1253,15 @@ func (task *downloadTask) Do() {
 	for i, header := range headers {
 		if header.Hash() == emptyHash {
 			blocks[i] = types.NewBlockWithHeader(header)
+		} else if txs, ok := chunked[header.Hash()]; ok {
+			blocks[i] = types.NewBlockWithHeader(header).WithBody(txs)
 		} else {
 			blocks[i] = types.NewBlockWithHeader(header).WithBody(bodies[bodyIndex].Transactions)
 			bodyIndex++
 		}
 	}
 	task.blocks = blocks
+	task.states = states
 	return
 }
 
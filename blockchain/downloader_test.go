@@ -0,0 +1,500 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+)
+
+func TestPropagateBlock(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 1)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+	block := blocks[len(blocks)-1]
+
+	dl := &Downloader{
+		blockchain: chain,
+		config:     DefaultDownloaderConfig,
+		transport:  routerTransport{},
+		remotes:    make(map[string]*stationStatus),
+	}
+	ch := make(chan *router.Event, 16)
+	// the first station sent the block, it is never sent back.
+	for i := 0; i < 10; i++ {
+		station := router.NewLocalStation(fmt.Sprintf("propagate%d", i), nil)
+		sub1 := router.Subscribe(station, ch, router.NewBlockMsg, &newBlockData{})
+		sub2 := router.Subscribe(station, ch, router.NewBlockHashesMsg, &NewBlockHashesData{})
+		defer sub1.Unsubscribe()
+		defer sub2.Unsubscribe()
+		dl.setStationStatus(newStationStatus(station, big.NewInt(0), 0, common.Hash{}))
+		if i == 0 {
+			dl.markRemote(station, block.Hash())
+		}
+	}
+
+	td := chain.GetTd(block.Hash(), block.NumberU64())
+	dl.propagateBlock(block, td)
+	dl.propagateBlock(block, td)
+	dl.broadcastStatus(&NewBlockHashesData{Hash: block.Hash(), Number: block.NumberU64(), TD: td})
+
+	var full, hashes int
+	timeout := time.After(time.Second)
+	for full+hashes < 9 {
+		select {
+		case e := <-ch:
+			switch data := e.Data.(type) {
+			case *newBlockData:
+				if e.To.Name() == "propagate0" {
+					t.Fatal("block sent back to its sender")
+				}
+				if data.Block.Hash() != block.Hash() || data.TD.Cmp(td) != 0 {
+					t.Fatalf("pushed block mismatch")
+				}
+				full++
+			case *NewBlockHashesData:
+				if e.To.Name() == "propagate0" {
+					t.Fatal("block announced back to its sender")
+				}
+				if data.Hash != block.Hash() || data.Number != block.NumberU64() {
+					t.Fatalf("announced hash mismatch")
+				}
+				hashes++
+			}
+		case <-timeout:
+			t.Fatalf("got %d blocks and %d hashes, want 3 and 6", full, hashes)
+		}
+	}
+	if full != 3 || hashes != 6 {
+		t.Fatalf("got %d blocks and %d hashes, want 3 and 6", full, hashes)
+	}
+	select {
+	case <-ch:
+		t.Fatal("known block propagated twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// testClock is a clock whose timers only expire when fired.
+type testClock struct {
+	mu     sync.Mutex
+	timers []chan time.Time
+	added  chan struct{}
+}
+
+func newTestClock() *testClock {
+	return &testClock{added: make(chan struct{}, 1)}
+}
+
+func (c *testClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.mu.Lock()
+	c.timers = append(c.timers, ch)
+	c.mu.Unlock()
+	select {
+	case c.added <- struct{}{}:
+	default:
+	}
+	return ch
+}
+
+// fire expires the pending timers.
+func (c *testClock) fire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ch := range c.timers {
+		ch <- time.Time{}
+	}
+	c.timers = nil
+}
+
+// testTransport answers the block hash requests from the canonical hashes
+// of a remote chain, unless it is silent.
+type testTransport struct {
+	mu       sync.Mutex
+	subs     map[string]chan *router.Event
+	hashes   []common.Hash
+	silent   bool
+	requests int
+}
+
+type testSubscription struct {
+	tt   *testTransport
+	name string
+}
+
+func (s *testSubscription) Err() <-chan error { return nil }
+
+func (s *testSubscription) Unsubscribe() {
+	s.tt.mu.Lock()
+	delete(s.tt.subs, s.name)
+	s.tt.mu.Unlock()
+}
+
+func (tt *testTransport) Subscribe(station router.Station, ch chan *router.Event, typecode int, data interface{}) router.Subscription {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.subs[station.Name()] = ch
+	return &testSubscription{tt, station.Name()}
+}
+
+func (tt *testTransport) SendTo(from, to router.Station, typecode int, data interface{}) int {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if typecode != router.DownloaderGetBlockHashMsg {
+		return 0
+	}
+	tt.requests++
+	if tt.silent {
+		return 1
+	}
+	req := data.(*getBlcokHashByNumber)
+	var hashes []common.Hash
+	for i, number := uint64(0), req.Number; i < req.Amount && number < uint64(len(tt.hashes)); i++ {
+		hashes = append(hashes, tt.hashes[number])
+		if req.Reverse {
+			if number < req.Skip+1 {
+				break
+			}
+			number -= req.Skip + 1
+		} else {
+			number += req.Skip + 1
+		}
+	}
+	ch := tt.subs[from.Name()]
+	go func() { ch <- &router.Event{From: to, To: from, Typecode: router.BlockHashMsg, Data: hashes} }()
+	return 1
+}
+
+func (tt *testTransport) StationRegister(station router.Station)   {}
+func (tt *testTransport) StationUnregister(station router.Station) {}
+
+func TestDownloaderRequestTimeout(t *testing.T) {
+	clock, transport := newTestClock(), &testTransport{subs: make(map[string]chan *router.Event), silent: true}
+	dl := &Downloader{config: DefaultDownloaderConfig, clock: clock, transport: transport}
+	from, to := router.NewLocalStation("timeoutlocal", nil), router.NewRemoteStation("timeoutremote", nil)
+
+	errCh := make(chan error)
+	go func() {
+		_, err := dl.getBlockHashes(from, to, &getBlcokHashByNumber{1, 1, 0, false}, make(chan struct{}))
+		errCh <- err
+	}()
+	<-clock.added
+	clock.fire()
+	if err := <-errCh; err != errRequestTimeout {
+		t.Fatalf("expected %v, got %v", errRequestTimeout, err)
+	}
+
+	closed := make(chan struct{})
+	close(closed)
+	if _, err := dl.getBlockHashes(from, to, &getBlcokHashByNumber{1, 1, 0, false}, closed); err != errStationClosed {
+		t.Fatalf("expected %v, got %v", errStationClosed, err)
+	}
+}
+
+func TestDownloaderFindAncestor(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 2)
+	if _, _, _, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx); err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	head := chain.CurrentBlock().NumberU64()
+	if head <= 32 {
+		t.Fatalf("chain too short for the binary search: %d blocks", head)
+	}
+
+	from, to := router.NewLocalStation("ancestorlocal", nil), router.NewRemoteStation("ancestorremote", nil)
+	for _, ancestor := range []uint64{0, 1, head / 3, head - 20, head - 1, head} {
+		// the remote chain forks after the ancestor and is longer
+		transport := &testTransport{subs: make(map[string]chan *router.Event)}
+		for number := uint64(0); number <= head+5; number++ {
+			if number <= ancestor {
+				transport.hashes = append(transport.hashes, chain.GetBlockByNumber(number).Hash())
+			} else {
+				transport.hashes = append(transport.hashes, common.BytesToHash([]byte{0xff, byte(number)}))
+			}
+		}
+		dl := &Downloader{blockchain: chain, config: DefaultDownloaderConfig, clock: newTestClock(), transport: transport}
+		found, err := dl.findAncestor(from, to, head, 1, make(chan struct{}))
+		if err != nil {
+			t.Fatalf("ancestor %d: %v", ancestor, err)
+		}
+		if found != ancestor {
+			t.Errorf("ancestor mismatch: have %d, want %d", found, ancestor)
+		}
+	}
+}
+
+func TestDownloaderCachedAncestor(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 1)
+	if _, _, _, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx); err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	head := chain.CurrentBlock().NumberU64()
+	cache := head / 2
+
+	from, to := router.NewLocalStation("cachedlocal", nil), router.NewRemoteStation("cachedremote", nil)
+	tests := []struct {
+		fork     uint64 // the remote chain forks after this block
+		hash     common.Hash
+		ancestor uint64
+		cached   bool
+		requests int
+	}{
+		{fork: head, hash: common.Hash{}, requests: 0},
+		{fork: head, hash: common.HexToHash("0xff"), requests: 0},
+		{fork: head, hash: chain.GetBlockByNumber(cache).Hash(), ancestor: head, cached: true, requests: 1},
+		{fork: head - 2, hash: chain.GetBlockByNumber(cache).Hash(), ancestor: cache, cached: true, requests: 1},
+		{fork: cache - 1, hash: chain.GetBlockByNumber(cache).Hash(), requests: 1},
+	}
+	for i, tt := range tests {
+		transport := &testTransport{subs: make(map[string]chan *router.Event)}
+		for number := uint64(0); number <= head+5; number++ {
+			if number <= tt.fork {
+				transport.hashes = append(transport.hashes, chain.GetBlockByNumber(number).Hash())
+			} else {
+				transport.hashes = append(transport.hashes, common.BytesToHash([]byte{0xff, byte(number)}))
+			}
+		}
+		dl := &Downloader{blockchain: chain, config: DefaultDownloaderConfig, clock: newTestClock(), transport: transport}
+		status := newStationStatus(to, new(big.Int), head+5, transport.hashes[head+5])
+		status.setAncestor(cache, tt.hash)
+
+		ancestor, cached, err := dl.cachedAncestor(from, status, head)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if cached != tt.cached || ancestor != tt.ancestor {
+			t.Errorf("test %d: have (%d, %v), want (%d, %v)", i, ancestor, cached, tt.ancestor, tt.cached)
+		}
+		if transport.requests != tt.requests {
+			t.Errorf("test %d: have %d requests, want %d", i, transport.requests, tt.requests)
+		}
+	}
+}
+
+func TestStationCapabilities(t *testing.T) {
+	_, _, chain, _, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	dl := &Downloader{
+		blockchain: chain,
+		config:     DefaultDownloaderConfig,
+		transport:  routerTransport{},
+		remotes:    make(map[string]*stationStatus),
+	}
+	add := func(name string, td int64, caps uint64) {
+		status := newStationStatus(router.NewLocalStation(name, nil), big.NewInt(td), uint64(td), common.Hash{byte(td)})
+		status.setProtocol(ProtocolVersion, caps)
+		dl.setStationStatus(status)
+	}
+	add("full", 1, CapBlockStates|CapState)
+	add("nostate", 2, CapBlockStates)
+	add("light", 3, CapLightOnly)
+
+	if status := dl.bestStation(0); status == nil || status.station.Name() != "nostate" {
+		t.Fatalf("download station mismatch: have %v, want nostate", status)
+	}
+	if status := dl.bestStation(CapState); status == nil || status.station.Name() != "full" {
+		t.Fatalf("state station mismatch: have %v, want full", status)
+	}
+
+	for _, tt := range []struct {
+		local, remote, want uint32
+		ok                  bool
+	}{
+		{ProtocolVersion, ProtocolVersion, ProtocolVersion, true},
+		{ProtocolVersion, ProtocolVersion + 1, ProtocolVersion, true},
+		{ProtocolVersion + 1, ProtocolVersion, ProtocolVersion, true},
+		{ProtocolVersion, minProtocolVersion, minProtocolVersion, true},
+		{ProtocolVersion, minProtocolVersion - 1, 0, false},
+	} {
+		if have, ok := negotiateVersion(tt.local, tt.remote); have != tt.want || ok != tt.ok {
+			t.Errorf("negotiate %d with %d: have %d %v, want %d %v", tt.local, tt.remote, have, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestStationStatusKnownLimit(t *testing.T) {
+	status := newStationStatus(router.NewRemoteStation("knownremote", nil), new(big.Int), 0, common.Hash{})
+	hash := func(i int) common.Hash { return common.BigToHash(big.NewInt(int64(i))) }
+	for i := 0; i < maxKnownBlocks; i++ {
+		if !status.markKnown(hash(i)) {
+			t.Fatalf("block %d known before marked", i)
+		}
+	}
+	// A block announced again is kept the longest.
+	if status.markKnown(hash(0)) {
+		t.Fatal("known block marked as new")
+	}
+	for i := maxKnownBlocks; i < maxKnownBlocks+10; i++ {
+		status.markKnown(hash(i))
+	}
+	if n := status.known.Len(); n != maxKnownBlocks {
+		t.Fatalf("known set size mismatch: have %d, want %d", n, maxKnownBlocks)
+	}
+	for i := 1; i <= 10; i++ {
+		if status.known.Contains(hash(i)) {
+			t.Fatalf("block %d not evicted first", i)
+		}
+	}
+	for _, i := range []int{0, 11, maxKnownBlocks + 9} {
+		if !status.known.Contains(hash(i)) {
+			t.Fatalf("block %d evicted", i)
+		}
+	}
+}
+
+func TestStationStatusConcurrency(t *testing.T) {
+	_, _, chain, _, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	head := chain.CurrentBlock().NumberU64()
+	transport := &testTransport{subs: make(map[string]chan *router.Event)}
+	for number := uint64(0); number <= head; number++ {
+		transport.hashes = append(transport.hashes, chain.GetHeaderByNumber(number).Hash())
+	}
+	remote := router.NewRemoteStation("concurrentremote", nil)
+	status := newStationStatus(remote, new(big.Int), 0, transport.hashes[0])
+	dl := &Downloader{blockchain: chain, config: DefaultDownloaderConfig, clock: systemClock{}, transport: transport, remotes: make(map[string]*stationStatus)}
+	dl.setStationStatus(status)
+
+	var wg sync.WaitGroup
+	for round := 0; round < 4; round++ {
+		wg.Add(2)
+		// the remote announces its blocks meanwhile
+		go func() {
+			defer wg.Done()
+			for number := uint64(1); number <= head; number++ {
+				status.updateStatus(transport.hashes[number], number, new(big.Int).SetUint64(number))
+			}
+		}()
+		// sync rounds check and record the common ancestor, and the download
+		// tasks check the head of their worker
+		go func(round int) {
+			defer wg.Done()
+			from := router.NewLocalStation(fmt.Sprintf("concurrentround%d", round), nil)
+			for number := uint64(1); number <= head; number++ {
+				if _, _, err := dl.cachedAncestor(from, status, head); err != nil {
+					t.Error(err)
+					return
+				}
+				status.setAncestor(number, transport.hashes[number])
+				task := &downloadTask{dl: dl, worker: status, endNumber: head + 1}
+				task.Do()
+			}
+		}(round)
+	}
+
+	// snapshots are never torn by the writers
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		snapshot := status.Status()
+		if snapshot.Hash != transport.hashes[snapshot.Number] || (snapshot.Number > 0 && snapshot.TD.Uint64() != snapshot.Number) {
+			t.Fatalf("torn head snapshot: %+v", snapshot)
+		}
+		if snapshot.Ancestor > 0 && snapshot.AncestorHash != transport.hashes[snapshot.Ancestor] {
+			t.Fatalf("torn ancestor snapshot: %+v", snapshot)
+		}
+		if !status.serves(0) || dl.Progress().HighestBlock > head {
+			t.Fatal("status mismatch")
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func TestLegacyStatus(t *testing.T) {
+	genesis := common.HexToHash("0x01")
+	status := &statusData{
+		ProtocolVersion: ProtocolVersion,
+		NetworkId:       1,
+		GenesisBlock:    genesis,
+		CurrentBlock:    common.HexToHash("0x02"),
+		CurrentNumber:   2,
+		TD:              big.NewInt(2),
+		ForkID:          newForkID(genesis, nil, 2),
+		Capabilities:    CapBlockStates | CapState | CapTxChunks,
+	}
+
+	// Remotes before the capabilities are sent the status they decode, at
+	// the newest version they speak.
+	legacy := legacyStatus(status)
+	version := router.PayloadVersionOf(router.DownloaderStatusMsg, legacy)
+	if version == router.PayloadVersion(router.DownloaderStatusMsg) {
+		t.Fatal("legacy status sent at the current payload version")
+	}
+	payload, err := router.EncodePayload(router.DownloaderStatusMsg, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := router.DecodePayload(router.DownloaderStatusMsg, version, payload)
+	if err != nil {
+		t.Fatalf("failed to decode legacy status: %v", err)
+	}
+	remote := data.(*statusData)
+	if remote.ProtocolVersion != capabilitiesVersion-1 || remote.Capabilities != 0 {
+		t.Fatalf("legacy status mismatch: version %d, capabilities %d", remote.ProtocolVersion, remote.Capabilities)
+	}
+	if remote.CurrentBlock != status.CurrentBlock || remote.TD.Cmp(status.TD) != 0 || remote.ForkID != status.ForkID {
+		t.Fatalf("legacy status head mismatch: have %v, want %v", remote, status)
+	}
+	if err := checkChainStatus(status, remote, nil); err != nil {
+		t.Fatalf("legacy status rejected: %v", err)
+	}
+	if v, _ := negotiateVersion(ProtocolVersion, remote.ProtocolVersion); v >= capabilitiesVersion {
+		t.Fatalf("negotiated version %d with a legacy remote", v)
+	}
+}
@@ -35,10 +35,12 @@ type NewMinedBlockEvent struct{ Block *types.Block }
 // RemovedLogsEvent is posted when a reorg happens
 type RemovedLogsEvent struct{ Logs []*types.Log }
 
+// ChainEvent is posted when a block is inserted in the canonical chain.
 type ChainEvent struct {
-	Block *types.Block
-	Hash  common.Hash
-	Logs  []*types.Log
+	Block          *types.Block
+	Hash           common.Hash
+	Logs           []*types.Log
+	BalanceChanges []*types.BalanceChange // balances changed by the block
 }
 
 // NewTxsEvent is posted when a batch of transactions enter the transaction pool.
@@ -0,0 +1,128 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// insertFastChain extends the canonical chain with blocks whose transactions
+// aren't executed. The state changes and receipts of every block, as served
+// by another node, are applied instead and verified against the state root,
+// receipt root, bloom and gas used of the header. Internal transactions and
+// preimages of these blocks aren't recorded.
+func (bc *BlockChain) insertFastChain(chain types.Blocks, states []*blockStateData) (int, error) {
+	if len(chain) == 0 {
+		return 0, nil
+	}
+	if len(chain) != len(states) {
+		return 0, fmt.Errorf("fast sync: %d blocks but %d states", len(chain), len(states))
+	}
+	if err := bc.sanityCheck(chain); err != nil {
+		return 0, err
+	}
+
+	bc.wg.Add(1)
+	defer bc.wg.Done()
+
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	var (
+		events        = make([]*event.Event, 0, len(chain)+2)
+		lastCanon     *types.Block
+		coalescedLogs []*types.Log
+	)
+	defer func() {
+		if lastCanon != nil && bc.CurrentBlock().Hash() == lastCanon.Hash() {
+			events = append(events, &event.Event{Typecode: event.ChainHeadEv, Data: lastCanon})
+		}
+		events = append(events, &event.Event{Typecode: event.LogsEv, Data: coalescedLogs})
+		bc.router.SendEvents(events)
+	}()
+
+	for i, block := range chain {
+		if atomic.LoadInt32(&bc.procInterrupt) == 1 {
+			log.Debug("Premature abort during fast blocks processing")
+			break
+		}
+
+		bstart := time.Now()
+		err := bc.validator.ValidateHeader(block.Header(), true)
+		if err == nil {
+			err = bc.Validator().ValidateBody(block)
+		}
+		switch {
+		case err == processor.ErrKnownBlock && bc.CurrentBlock().NumberU64() >= block.NumberU64():
+			continue
+		case err != nil && err != processor.ErrKnownBlock:
+			bc.reportBlock(block, nil, err)
+			return i, err
+		}
+		if block.ParentHash() != bc.CurrentBlock().Hash() {
+			return i, ErrSideBlock
+		}
+		parent := bc.GetBlock(block.ParentHash(), block.NumberU64()-1)
+		if parent == nil {
+			return i, processor.ErrUnknownAncestor
+		}
+
+		statedb, err := state.New(parent.Hash(), bc.stateCache)
+		if err != nil {
+			return i, err
+		}
+		if err := statedb.ApplyChanges(states[i].Changes); err != nil {
+			return i, err
+		}
+		receipts := states[i].Receipts
+		var usedGas uint64
+		if len(receipts) > 0 {
+			usedGas = receipts[len(receipts)-1].CumulativeGasUsed
+		}
+		if err := bc.validator.ValidateState(block, parent, statedb, receipts, usedGas); err != nil {
+			bc.reportBlock(block, receipts, err)
+			return i, err
+		}
+		changes, err := accountmanager.BalanceChanges(statedb)
+		if err != nil {
+			return i, err
+		}
+		if err := bc.WriteBlockWithState(block, receipts, statedb); err != nil {
+			return i, err
+		}
+
+		var logs []*types.Log
+		for _, receipt := range receipts {
+			logs = append(logs, receipt.Logs...)
+		}
+		log.Info("Imported new block state", "number", block.Number(), "hash", block.Hash().String(), "txs", len(block.Txs), "changes", len(states[i].Changes), "elapsed", common.PrettyDuration(time.Since(bstart)))
+		coalescedLogs = append(coalescedLogs, logs...)
+		events = append(events, &event.Event{Typecode: event.ChainEv, Data: ChainEvent{block, block.Hash(), logs, changes}})
+		lastCanon = block
+	}
+	return 0, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/consensus"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func TestFastSync(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 3)
+	if _, _, _, err = makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx); err != nil {
+		t.Error("makeNewChain err", err)
+	}
+	head := chain.CurrentBlock()
+	var blocks types.Blocks
+	var hashes []common.Hash
+	for i := uint64(1); i <= head.NumberU64(); i++ {
+		block := chain.GetBlockByNumber(i)
+		blocks = append(blocks, block)
+		hashes = append(hashes, block.Hash())
+	}
+	var states []*blockStateData
+	for len(states) < len(hashes) {
+		states = append(states, serveBlockStates(chain, hashes[len(states):])...)
+	}
+
+	newdb := fdb.NewMemDatabase()
+	if _, err := DefaultGenesis().Commit(newdb); err != nil {
+		t.Fatal(err)
+	}
+	newchain, err := NewBlockChain(newdb, nil, vm.Config{}, chain.Config(), txpool.SenderCacher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newchain.Stop()
+	type bc struct {
+		*BlockChain
+		consensus.IEngine
+	}
+	newchain.SetValidator(processor.NewBlockValidator(&bc{newchain, tengine}, tengine))
+	newchain.SetProcessor(processor.NewStateProcessor(&bc{newchain, tengine}, tengine))
+
+	// Changes which don't match the state root, or touch keys outside of the
+	// state, are rejected.
+	tampered := serveBlockStates(chain, hashes[:1])
+	tampered[0].Changes[0].Value = append(tampered[0].Changes[0].Value, 1)
+	if _, err := newchain.insertFastChain(blocks[:1], tampered); err == nil {
+		t.Fatal("tampered state changes imported")
+	}
+	foreign := serveBlockStates(chain, hashes[:1])
+	foreign[0].Changes = append(foreign[0].Changes, &types.OptInfo{Key: "LastBlock", Value: head.Hash().Bytes(), Opt: 1})
+	if _, err := newchain.insertFastChain(blocks[:1], foreign); err == nil {
+		t.Fatal("state changes outside of the state imported")
+	}
+	if newchain.CurrentBlock().NumberU64() != 0 {
+		t.Fatal("rejected block became the head")
+	}
+
+	if _, err := newchain.insertFastChain(blocks, states); err != nil {
+		t.Fatal(err)
+	}
+	if newchain.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", newchain.CurrentBlock().Hash(), head.Hash())
+	}
+	if !reflect.DeepEqual(stateItems(t, newdb, head.Hash()), stateItems(t, db, head.Hash())) {
+		t.Fatal("state mismatch after fast sync")
+	}
+	for _, block := range blocks {
+		have := rawdb.ReadReceipts(newdb, block.Hash(), block.NumberU64())
+		want := rawdb.ReadReceipts(db, block.Hash(), block.NumberU64())
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("block %d: receipts mismatch", block.NumberU64())
+		}
+	}
+
+	// The downloader turns to full sync once the pivot state is complete.
+	dl := newchain.Downloader()
+	ch := make(chan *router.Event, 4)
+	sub := router.Subscribe(nil, ch, router.SyncModeEv, SyncModeEvent{})
+	defer sub.Unsubscribe()
+	if err := dl.SetMode(LightSync); err == nil {
+		t.Fatal("light sync enabled")
+	}
+	if err := dl.SetMode(FastSync); err != nil {
+		t.Fatal(err)
+	}
+	if pivot := dl.fastSyncPivot(head.NumberU64() + fastSyncMinFullBlocks); pivot != head.NumberU64() {
+		t.Fatalf("pivot mismatch: have %d, want %d", pivot, head.NumberU64())
+	}
+	dl.checkPivot()
+	if dl.Mode() != FullSync {
+		t.Fatalf("mode mismatch: have %v, want %v", dl.Mode(), FullSync)
+	}
+	for _, want := range []SyncModeEvent{{FullSync, FastSync}, {FastSync, FullSync}} {
+		if e := <-ch; e.Data.(SyncModeEvent) != want {
+			t.Fatalf("mode event mismatch: have %+v, want %+v", e.Data, want)
+		}
+	}
+}
+
+func TestSyncModeText(t *testing.T) {
+	for _, mode := range []SyncMode{FullSync, FastSync, LightSync} {
+		text, err := mode.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded SyncMode
+		if err := decoded.UnmarshalText(text); err != nil || decoded != mode {
+			t.Fatalf("%v: decoded %v, %v", mode, decoded, err)
+		}
+	}
+	var mode SyncMode
+	if err := mode.UnmarshalText([]byte("slow")); err == nil {
+		t.Fatal("unknown sync mode decoded")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// producerWindow is the number of recent blocks whose producers are counted
+// by the producer-weighted fork rule.
+const producerWindow = 64
+
+// ChainHead is the head of a chain competing for the canonical one. Header is
+// nil for the heads only announced by remotes, whose blocks aren't known: a
+// rule then reports whether the chain may be preferred once fetched.
+type ChainHead struct {
+	Hash   common.Hash
+	Number uint64
+	Td     *big.Int
+	Header *types.Header
+}
+
+// ForkRule decides between the local chain and a competing one.
+type ForkRule interface {
+	// Prefer reports whether the extern head should replace the local one.
+	Prefer(fc *ForkChoice, local, extern *ChainHead) bool
+	// Name returns the name the rule is configured by.
+	Name() string
+}
+
+// TdRule prefers the chain with the highest total difficulty.
+type TdRule struct{}
+
+// Prefer implements ForkRule.
+func (TdRule) Prefer(fc *ForkChoice, local, extern *ChainHead) bool {
+	return extern.Td.Cmp(local.Td) > 0
+}
+
+// Name implements ForkRule.
+func (TdRule) Name() string { return "td" }
+
+// IrreversibleRule never gives up the irreversible blocks of the local chain,
+// and prefers the highest total difficulty among the chains keeping them.
+type IrreversibleRule struct{}
+
+// Prefer implements ForkRule.
+func (IrreversibleRule) Prefer(fc *ForkChoice, local, extern *ChainHead) bool {
+	if extern.Header == nil {
+		// An unknown head not above the irreversible block forks below it.
+		if extern.Number <= fc.Irreversible() {
+			return false
+		}
+	} else if fc.forkNumber(extern.Header) < fc.Irreversible() {
+		return false
+	}
+	return extern.Td.Cmp(local.Td) > 0
+}
+
+// Name implements ForkRule.
+func (IrreversibleRule) Name() string { return "irreversible" }
+
+// ProducerRule prefers the chain built by the most distinct producers over
+// the recent blocks, which a minority of producers can't outweigh, then the
+// highest total difficulty.
+type ProducerRule struct{}
+
+// Prefer implements ForkRule.
+func (ProducerRule) Prefer(fc *ForkChoice, local, extern *ChainHead) bool {
+	if extern.Header == nil || local.Header == nil {
+		// The producers of an unknown chain are only counted once fetched,
+		// which may outweigh a higher total difficulty.
+		return extern.Hash != local.Hash
+	}
+	localProducers, externProducers := fc.producers(local.Header), fc.producers(extern.Header)
+	if localProducers != externProducers {
+		return externProducers > localProducers
+	}
+	return extern.Td.Cmp(local.Td) > 0
+}
+
+// Name implements ForkRule.
+func (ProducerRule) Name() string { return "producer" }
+
+// ParseForkRule returns the fork rule of the name.
+func ParseForkRule(name string) (ForkRule, error) {
+	for _, rule := range []ForkRule{TdRule{}, IrreversibleRule{}, ProducerRule{}} {
+		if rule.Name() == name {
+			return rule, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown fork rule %q", name)
+}
+
+// ForkChoice decides which chain is canonical, for the blocks inserted as
+// well as for the remotes to sync with.
+type ForkChoice struct {
+	chain        *BlockChain
+	rule         ForkRule
+	irreversible func() uint64
+	mu           sync.RWMutex
+}
+
+func newForkChoice(chain *BlockChain) *ForkChoice {
+	return &ForkChoice{chain: chain, rule: TdRule{}}
+}
+
+// Rule returns the fork rule in use.
+func (fc *ForkChoice) Rule() ForkRule {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.rule
+}
+
+// SetRule changes the fork rule.
+func (fc *ForkChoice) SetRule(rule ForkRule) {
+	fc.mu.Lock()
+	fc.rule = rule
+	fc.mu.Unlock()
+}
+
+// SetIrreversible sets the source of the irreversible block number, usually
+// the consensus engine.
+func (fc *ForkChoice) SetIrreversible(irreversible func() uint64) {
+	fc.mu.Lock()
+	fc.irreversible = irreversible
+	fc.mu.Unlock()
+}
+
+// Irreversible returns the number of the highest irreversible local block,
+// 0 if unknown.
+func (fc *ForkChoice) Irreversible() uint64 {
+	fc.mu.RLock()
+	irreversible := fc.irreversible
+	fc.mu.RUnlock()
+	if irreversible == nil {
+		return 0
+	}
+	return irreversible()
+}
+
+// LocalHead returns the head of the local chain.
+func (fc *ForkChoice) LocalHead() *ChainHead {
+	header := fc.chain.CurrentHeader()
+	return &ChainHead{
+		Hash:   header.Hash(),
+		Number: header.Number.Uint64(),
+		Td:     fc.chain.GetTd(header.Hash(), header.Number.Uint64()),
+		Header: header,
+	}
+}
+
+// ReorgNeeded reports whether the block of the total difficulty, whose
+// parent is known, should become the local head.
+func (fc *ForkChoice) ReorgNeeded(header *types.Header, td *big.Int) bool {
+	extern := &ChainHead{Hash: header.Hash(), Number: header.Number.Uint64(), Td: td, Header: header}
+	return fc.Rule().Prefer(fc, fc.LocalHead(), extern)
+}
+
+// RemoteBetter reports whether the head announced by a remote is preferred
+// to the local one, which is worth syncing. The rule in use judges the head
+// by its header and local total difficulty if the block is known locally.
+func (fc *ForkChoice) RemoteBetter(hash common.Hash, number uint64, td *big.Int) bool {
+	extern := &ChainHead{Hash: hash, Number: number, Td: td}
+	if header := fc.chain.GetHeader(hash, number); header != nil {
+		if localTd := fc.chain.GetTd(hash, number); localTd != nil {
+			extern.Header, extern.Td = header, localTd
+		}
+	}
+	return fc.Prefer(fc.LocalHead(), extern)
+}
+
+// Prefer reports whether the extern head is preferred to the local one.
+func (fc *ForkChoice) Prefer(local, extern *ChainHead) bool {
+	return fc.Rule().Prefer(fc, local, extern)
+}
+
+// forkNumber returns the number of the highest canonical ancestor of the
+// header, stopping at the irreversible block.
+func (fc *ForkChoice) forkNumber(header *types.Header) uint64 {
+	irreversible := fc.Irreversible()
+	hash, number := header.ParentHash, header.Number.Uint64()-1
+	for number > irreversible && rawdb.ReadCanonicalHash(fc.chain.db, number) != hash {
+		parent := fc.chain.GetHeader(hash, number)
+		if parent == nil {
+			return 0
+		}
+		hash, number = parent.ParentHash, number-1
+	}
+	if rawdb.ReadCanonicalHash(fc.chain.db, number) != hash {
+		return 0
+	}
+	return number
+}
+
+// producers counts the distinct producers of the recent blocks up to the
+// header.
+func (fc *ForkChoice) producers(header *types.Header) int {
+	seen := make(map[common.Name]struct{})
+	for i := 0; i < producerWindow && header != nil; i++ {
+		seen[header.Coinbase] = struct{}{}
+		if header.Number.Sign() == 0 {
+			break
+		}
+		header = fc.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	return len(seen)
+}
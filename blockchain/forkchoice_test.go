@@ -0,0 +1,126 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestIrreversibleRule(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, nil)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+
+	prods = append(prods[0:3], prods[10:]...)
+	ht = append(ht[0:3], ht[10:]...)
+	genesis1, db1, chain1, _, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain1.Stop()
+	if _, _, _, err = makeNewChain(t, genesis1, chain1, &db1, len(prods), ht, prods, makeTransferTx); err != nil {
+		t.Error("makeNewChain err", err)
+	}
+
+	// The heavier fork starts below the irreversible head, it is refused.
+	head := chain1.CurrentBlock()
+	chain1.ForkChoice().SetRule(IrreversibleRule{})
+	chain1.ForkChoice().SetIrreversible(func() uint64 { return head.NumberU64() })
+	if _, err := chain1.InsertChain(blocks); err != nil {
+		t.Fatal(err)
+	}
+	if chain1.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("irreversible block reorganised, head %d", chain1.CurrentBlock().NumberU64())
+	}
+	// A remote announcing the known fork isn't synced with either.
+	local := chain1.ForkChoice().LocalHead()
+	extern := blocks[len(blocks)-1]
+	td := new(big.Int).Add(local.Td, common.Big1)
+	if chain1.ForkChoice().RemoteBetter(extern.Hash(), extern.NumberU64(), td) {
+		t.Fatal("remote forking below the irreversible block preferred")
+	}
+	// Nor is an unknown head not above the irreversible block.
+	if chain1.ForkChoice().RemoteBetter(common.Hash{1}, head.NumberU64(), td) {
+		t.Fatal("unknown remote not above the irreversible block preferred")
+	}
+	if !chain1.ForkChoice().RemoteBetter(common.Hash{1}, head.NumberU64()+1, td) {
+		t.Fatal("heavier unknown remote not preferred")
+	}
+	// Without irreversible block the heaviest chain wins.
+	chain1.ForkChoice().SetIrreversible(nil)
+	if !chain1.ForkChoice().RemoteBetter(extern.Hash(), extern.NumberU64(), td) {
+		t.Fatal("heavier remote not preferred")
+	}
+}
+
+func TestProducerRuleRemote(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, nil)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+
+	fc := chain.ForkChoice()
+	fc.SetRule(ProducerRule{})
+	local := fc.LocalHead()
+	lighter := new(big.Int).Sub(local.Td, common.Big1)
+	// The producers of an unknown head may outweigh its total difficulty.
+	if !fc.RemoteBetter(common.Hash{1}, local.Number, lighter) {
+		t.Fatal("unknown remote not synced with")
+	}
+	// A known head is judged by its producers and local total difficulty,
+	// whatever the announced one.
+	parent := blocks[len(blocks)-2]
+	if fc.RemoteBetter(parent.Hash(), parent.NumberU64(), new(big.Int).Add(local.Td, common.Big1)) {
+		t.Fatal("known remote with fewer producers preferred")
+	}
+	if fc.RemoteBetter(local.Hash, local.Number, local.Td) {
+		t.Fatal("local head preferred to itself")
+	}
+}
+
+func TestParseForkRule(t *testing.T) {
+	for _, rule := range []ForkRule{TdRule{}, IrreversibleRule{}, ProducerRule{}} {
+		parsed, err := ParseForkRule(rule.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != rule {
+			t.Fatalf("rule mismatch: have %s, want %s", parsed.Name(), rule.Name())
+		}
+	}
+	if _, err := ParseForkRule("longest"); err == nil {
+		t.Fatal("unknown rule parsed")
+	}
+}
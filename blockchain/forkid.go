@@ -0,0 +1,166 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"sort"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+)
+
+var (
+	// errRemoteStale is returned if a remote fork checksum is a subset of our
+	// already applied forks, but the announced next fork block is not on our
+	// already passed chain.
+	errRemoteStale = errors.New("remote needs update")
+
+	// errLocalIncompatibleOrStale is returned if a remote fork checksum does
+	// not match any local checksum variation, signalling that the two chains
+	// have diverged in the past at some point.
+	errLocalIncompatibleOrStale = errors.New("local incompatible or needs update")
+)
+
+// forkID is a fork identifier: the CRC32 checksum of the genesis hash and
+// the fork blocks already passed, plus the block number of the next fork.
+type forkID struct {
+	Hash [4]byte // CRC32 checksum of the genesis block and passed fork block numbers
+	Next uint64  // Block number of the next upcoming fork, or 0 if no forks are known
+}
+
+// gatherForks returns the sorted fork block numbers of the chain config,
+// without duplicates and genesis forks.
+func gatherForks(config *params.ChainConfig) []uint64 {
+	if config == nil {
+		return nil
+	}
+	forks := make([]uint64, 0, len(config.ForkBlocks))
+	for _, number := range config.ForkBlocks {
+		if number != 0 {
+			forks = append(forks, number)
+		}
+	}
+	if config.ExtensionBlock != nil && config.ExtensionBlock.Sign() > 0 {
+		forks = append(forks, config.ExtensionBlock.Uint64())
+	}
+	if config.BlockLimitsBlock != nil && config.BlockLimitsBlock.Sign() > 0 {
+		forks = append(forks, config.BlockLimitsBlock.Uint64())
+	}
+	if config.StorageRentBlock != nil && config.StorageRentBlock.Sign() > 0 {
+		forks = append(forks, config.StorageRentBlock.Uint64())
+	}
+	if config.WasmBlock != nil && config.WasmBlock.Sign() > 0 {
+		forks = append(forks, config.WasmBlock.Uint64())
+	}
+	if config.NativeBlock != nil && config.NativeBlock.Sign() > 0 {
+		forks = append(forks, config.NativeBlock.Uint64())
+	}
+	if config.FeeAssetBlock != nil && config.FeeAssetBlock.Sign() > 0 {
+		forks = append(forks, config.FeeAssetBlock.Uint64())
+	}
+	if config.CreateOpBlock != nil && config.CreateOpBlock.Sign() > 0 {
+		forks = append(forks, config.CreateOpBlock.Uint64())
+	}
+	for _, fork := range config.ActionForks {
+		if fork.Block != 0 {
+			forks = append(forks, fork.Block)
+		}
+	}
+	sort.Slice(forks, func(i, j int) bool { return forks[i] < forks[j] })
+	for i := 1; i < len(forks); i++ {
+		if forks[i] == forks[i-1] {
+			forks = append(forks[:i], forks[i+1:]...)
+			i--
+		}
+	}
+	return forks
+}
+
+// newForkID calculates the fork identifier of a chain at the given head.
+func newForkID(genesis common.Hash, forks []uint64, head uint64) forkID {
+	hash := crc32.ChecksumIEEE(genesis[:])
+	for _, fork := range forks {
+		if fork > head {
+			return forkID{Hash: checksumToBytes(hash), Next: fork}
+		}
+		hash = checksumUpdate(hash, fork)
+	}
+	return forkID{Hash: checksumToBytes(hash), Next: 0}
+}
+
+// checkForkID validates a remote fork identifier against the local chain,
+// whose current head is given. Remote peers are accepted if they are on the
+// same fork, are syncing up to our forks, or we are syncing up to theirs.
+func checkForkID(genesis common.Hash, forks []uint64, head uint64, id forkID) error {
+	// Calculate the checksums of all fork variations of the local chain.
+	sums := make([][4]byte, len(forks)+1)
+	hash := crc32.ChecksumIEEE(genesis[:])
+	sums[0] = checksumToBytes(hash)
+	for i, fork := range forks {
+		hash = checksumUpdate(hash, fork)
+		sums[i+1] = checksumToBytes(hash)
+	}
+	// Append a sentinel so the last fork never becomes the next one.
+	forks = append(forks[:len(forks):len(forks)], ^uint64(0))
+
+	for i, fork := range forks {
+		// Skip all forks already passed by the local head.
+		if head >= fork {
+			continue
+		}
+		if sums[i] == id.Hash {
+			// Same fork state. Reject if the remote announces a fork we have
+			// already passed without applying it.
+			if id.Next > 0 && head >= id.Next {
+				return errLocalIncompatibleOrStale
+			}
+			return nil
+		}
+		// The remote may be syncing and not yet have passed some of our forks.
+		for j := 0; j < i; j++ {
+			if sums[j] == id.Hash {
+				if forks[j] != id.Next {
+					return errRemoteStale
+				}
+				return nil
+			}
+		}
+		// We may be syncing and not yet have passed some of the remote's forks.
+		for j := i + 1; j < len(sums); j++ {
+			if sums[j] == id.Hash {
+				return nil
+			}
+		}
+		return errLocalIncompatibleOrStale
+	}
+	return errLocalIncompatibleOrStale
+}
+
+func checksumUpdate(hash uint32, fork uint64) uint32 {
+	var blob [8]byte
+	binary.BigEndian.PutUint64(blob[:], fork)
+	return crc32.Update(hash, crc32.IEEETable, blob[:])
+}
+
+func checksumToBytes(hash uint32) [4]byte {
+	var blob [4]byte
+	binary.BigEndian.PutUint32(blob[:], hash)
+	return blob
+}
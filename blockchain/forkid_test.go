@@ -0,0 +1,80 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+)
+
+func TestGatherForks(t *testing.T) {
+	forks := gatherForks(&params.ChainConfig{
+		ForkBlocks:       []uint64{300, 0, 100, 300, 200},
+		StorageRentBlock: big.NewInt(150),
+		WasmBlock:        big.NewInt(175),
+		NativeBlock:      big.NewInt(125),
+		FeeAssetBlock:    big.NewInt(275),
+		CreateOpBlock:    big.NewInt(325),
+		ActionForks:      []*params.ActionFork{{Block: 250, ActionTypes: []uint64{1}}, {Block: 200}, {Block: 0}},
+	})
+	want := []uint64{100, 125, 150, 175, 200, 250, 275, 300, 325}
+	if len(forks) != len(want) {
+		t.Fatalf("forks mismatch: have %v, want %v", forks, want)
+	}
+	for i := range want {
+		if forks[i] != want[i] {
+			t.Fatalf("forks mismatch: have %v, want %v", forks, want)
+		}
+	}
+}
+
+func TestCheckForkID(t *testing.T) {
+	genesis := common.HexToHash("0x01")
+	forks := []uint64{100, 200}
+	other := common.HexToHash("0x02")
+
+	tests := []struct {
+		head uint64
+		id   forkID
+		err  error
+	}{
+		// Same fork state, with and without the next fork announced.
+		{50, newForkID(genesis, forks, 50), nil},
+		{150, newForkID(genesis, forks, 150), nil},
+		{250, newForkID(genesis, forks, 250), nil},
+		// Remote is syncing and has not passed our forks yet.
+		{250, newForkID(genesis, forks, 50), nil},
+		// Remote is on an old version which doesn't know about the next fork.
+		{150, newForkID(genesis, nil, 150), errRemoteStale},
+		// We are syncing and the remote already passed our next fork.
+		{50, newForkID(genesis, forks, 250), nil},
+		// Remote announces a fork we have already passed without applying it.
+		{250, forkID{Hash: newForkID(genesis, forks, 250).Hash, Next: 220}, errLocalIncompatibleOrStale},
+		// Remote is on another chain.
+		{50, newForkID(other, forks, 50), errLocalIncompatibleOrStale},
+		// Remote has applied a fork we don't know about.
+		{250, newForkID(genesis, []uint64{100, 150, 200}, 250), errLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		if err := checkForkID(genesis, forks, tt.head, tt.id); err != tt.err {
+			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
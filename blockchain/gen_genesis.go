@@ -40,6 +40,8 @@ func (g Genesis) MarshalJSON() ([]byte, error) {
 		Coinbase      common.Name           `json:"coinbase"`
 		AllocAccounts []*GenesisAccount     `json:"allocAccounts"`
 		AllocAssets   []*asset.AssetObject  `json:"allocAssets"`
+
+		AllocProducers []*dpos.GenesisProducer `json:"allocProducers,omitempty"`
 	}
 	var enc genesisJSON
 	enc.Config = g.Config
@@ -51,6 +53,7 @@ func (g Genesis) MarshalJSON() ([]byte, error) {
 	enc.Coinbase = g.Coinbase
 	enc.AllocAccounts = g.AllocAccounts
 	enc.AllocAssets = g.AllocAssets
+	enc.AllocProducers = g.AllocProducers
 	return json.Marshal(&enc)
 }
 
@@ -67,6 +70,8 @@ func (g *Genesis) UnmarshalJSON(input []byte) error {
 		Coinbase      common.Name           `json:"coinbase"`
 		AllocAccounts []*GenesisAccount     `json:"allocAccounts"`
 		AllocAssets   []*asset.AssetObject  `json:"allocAssets"`
+
+		AllocProducers []*dpos.GenesisProducer `json:"allocProducers,omitempty"`
 	}
 	var dec genesisJSON
 	if err := json.Unmarshal(input, &dec); err != nil {
@@ -100,5 +105,8 @@ func (g *Genesis) UnmarshalJSON(input []byte) error {
 	if len(dec.AllocAssets) > 0 {
 		g.AllocAssets = dec.AllocAssets
 	}
+	if len(dec.AllocProducers) > 0 {
+		g.AllocProducers = dec.AllocProducers
+	}
 	return nil
 }
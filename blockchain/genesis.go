@@ -22,11 +22,11 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/log"
 	am "github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/asset"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/consensus/dpos"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/rawdb"
 	"github.com/fractalplatform/fractal/state"
@@ -36,8 +36,16 @@ import (
 
 // GenesisAccount is an account in the state of the genesis block.
 type GenesisAccount struct {
-	Name   common.Name   `json:"name,omitempty"`
-	PubKey common.PubKey `json:"pubKey,omitempty"`
+	Name     common.Name       `json:"name,omitempty"`
+	PubKey   common.PubKey     `json:"pubKey,omitempty"`
+	Balances []*GenesisBalance `json:"balances,omitempty"`
+}
+
+// GenesisBalance is an amount of a genesis asset held by a genesis account.
+// It is transferred from the asset owner, so the issued supply must cover it.
+type GenesisBalance struct {
+	Asset  string   `json:"asset"`
+	Amount *big.Int `json:"amount"`
 }
 
 // Genesis specifies the header fields, state of a genesis block.
@@ -51,6 +59,8 @@ type Genesis struct {
 	Coinbase      common.Name          `json:"coinbase"`
 	AllocAccounts []*GenesisAccount    `json:"allocAccounts"`
 	AllocAssets   []*asset.AssetObject `json:"allocAssets"`
+
+	AllocProducers []*dpos.GenesisProducer `json:"allocProducers,omitempty"`
 }
 
 // SetupGenesisBlock The returned chain configuration is never nil.
@@ -69,12 +79,18 @@ func SetupGenesisBlock(db fdb.Database, genesis *Genesis) (*params.ChainConfig,
 			genesis = DefaultGenesis()
 		}
 		block, err := genesis.Commit(db)
+		if err != nil {
+			return genesis.Config, genesis.Dpos, common.Hash{}, err
+		}
 		log.Info("Writing genesis block", "hash", block.Hash().Hex())
-		return genesis.Config, genesis.Dpos, block.Hash(), err
+		return genesis.Config, genesis.Dpos, block.Hash(), nil
 	}
 
 	// Check whether the genesis block is already written.
 	if genesis != nil {
+		if err := genesis.ValidateAllocs(); err != nil {
+			return genesis.Config, genesis.Dpos, common.Hash{}, err
+		}
 		hash := genesis.ToBlock(nil).Hash()
 		if hash != stored {
 			return genesis.Config, genesis.Dpos, hash, &GenesisMismatchError{stored, hash}
@@ -96,7 +112,8 @@ func SetupGenesisBlock(db fdb.Database, genesis *Genesis) (*params.ChainConfig,
 }
 
 // ToBlock creates the genesis block and writes state of a genesis specification
-// to the given database (or discards it if nil).
+// to the given database (or discards it if nil). It panics if the allocations
+// don't validate, see ValidateAllocs.
 func (g *Genesis) ToBlock(db fdb.Database) *types.Block {
 	if db == nil {
 		db = fdb.NewMemDatabase()
@@ -115,10 +132,13 @@ func (g *Genesis) ToBlock(db fdb.Database) *types.Block {
 	if !common.IsValidName(g.Dpos.SystemName) {
 		panic(fmt.Sprintf("genesis invalid dpos account name %v", g.Dpos.SystemName))
 	}
-	g.AllocAccounts = append(g.AllocAccounts, &GenesisAccount{
-		Name:   common.StrToName(g.Dpos.AccountName),
-		PubKey: common.PubKey{},
-	})
+	// an exported genesis already lists the dpos account with the stakes
+	if !g.hasAccount(common.StrToName(g.Dpos.AccountName)) {
+		g.AllocAccounts = append(g.AllocAccounts, &GenesisAccount{
+			Name:   common.StrToName(g.Dpos.AccountName),
+			PubKey: common.PubKey{},
+		})
+	}
 	if err := dpos.Genesis(g.Dpos, statedb, number.Uint64()); err != nil {
 		panic(fmt.Sprintf("genesis dpos err %v", g.Dpos.SystemName))
 	}
@@ -129,12 +149,58 @@ func (g *Genesis) ToBlock(db fdb.Database) *types.Block {
 		}
 	}
 
+	// System accounts are only created at genesis, next to the system and
+	// dpos accounts the fee pool. Chains configuring neither keep the state
+	// of the genesis they had before the system namespace.
+	if g.hasSystemNamespace() {
+		if ok, _ := accountManager.AccountIsExist(g.Config.FeePool()); !ok {
+			if err := accountManager.CreateAccount(g.Config.FeePool(), common.PubKey{}); err != nil {
+				panic(fmt.Sprintf("genesis create fee pool err %v", err))
+			}
+		}
+		if err := accountManager.SetReservedPrefix(g.Config.ReservedPrefix()); err != nil {
+			panic(fmt.Sprintf("genesis reserved prefix err %v", err))
+		}
+	}
+	if auction := g.Config.NameAuction; auction != nil {
+		if err := accountManager.SetAuctionedLength(auction.MaxLength); err != nil {
+			panic(fmt.Sprintf("genesis name auction err %v", err))
+		}
+	}
+
 	for _, asset := range g.AllocAssets {
 		if err := accountManager.IssueAsset(asset); err != nil {
 			panic(fmt.Sprintf("genesis issue asset err %v", err))
 		}
 	}
 
+	for _, account := range g.AllocAccounts {
+		for _, balance := range account.Balances {
+			asset, err := accountManager.GetAssetInfoByName(balance.Asset)
+			if err != nil {
+				panic(fmt.Sprintf("genesis balance asset %v err %v", balance.Asset, err))
+			}
+			if err := accountManager.TransferAsset(asset.GetAssetOwner(), account.Name, asset.GetAssetId(), balance.Amount); err != nil {
+				panic(fmt.Sprintf("genesis balance %v of %v err %v", balance.Asset, account.Name, err))
+			}
+		}
+	}
+
+	for _, producer := range g.AllocProducers {
+		names := []string{producer.Name}
+		for _, voter := range producer.Voters {
+			names = append(names, voter.Name)
+		}
+		for _, name := range names {
+			if ok, _ := accountManager.AccountIsExist(common.StrToName(name)); !ok {
+				panic(fmt.Sprintf("genesis producer or voter %v is not a genesis account", name))
+			}
+		}
+	}
+	if err := dpos.GenesisProducers(g.Dpos, statedb, number.Uint64(), g.AllocProducers); err != nil {
+		panic(fmt.Sprintf("genesis producers err %v", err))
+	}
+
 	root := statedb.IntermediateRoot()
 	head := &types.Header{
 		Number:     number,
@@ -162,6 +228,9 @@ func (g *Genesis) ToBlock(db fdb.Database) *types.Block {
 // Commit writes the block and state of a genesis specification to the database.
 // The block is committed as the canonical head block.
 func (g *Genesis) Commit(db fdb.Database) (*types.Block, error) {
+	if err := g.ValidateAllocs(); err != nil {
+		return nil, err
+	}
 	block := g.ToBlock(db)
 	if block.Number().Sign() != 0 {
 		return nil, fmt.Errorf("can't commit genesis block with number > 0")
@@ -181,11 +250,30 @@ func (g *Genesis) Commit(db fdb.Database) (*types.Block, error) {
 	if dposConfig == nil {
 		dposConfig = dpos.DefaultConfig
 	}
+	if err := dposConfig.Write(db, append([]byte("ft-dpos-"), block.Hash().Bytes()...)); err != nil {
+		return nil, err
+	}
 
 	rawdb.WriteChainConfig(db, block.Hash(), config)
 	return block, nil
 }
 
+// hasSystemNamespace reports whether the genesis reserves the names of the
+// system accounts and creates the fee pool, which it does if the config sets
+// either or has a treasury paying into the fee pool.
+func (g *Genesis) hasSystemNamespace() bool {
+	return g.Config.SysPrefix != "" || g.Config.FeePoolName != "" || g.Config.Treasury != nil
+}
+
+func (g *Genesis) hasAccount(name common.Name) bool {
+	for _, account := range g.AllocAccounts {
+		if account.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *Genesis) dposOrDefault(ghash common.Hash) *dpos.Config {
 	if g != nil {
 		return g.Dpos
@@ -216,6 +304,39 @@ func DefaultGenesis() *Genesis {
 	}
 }
 
+// DefaultTestnetGenesis returns the test network genesis block.
+func DefaultTestnetGenesis() *Genesis {
+	gtime, _ := time.Parse("2006-01-02 15:04:05.999999999", "2019-01-16 00:00:00")
+	return &Genesis{
+		Config:        params.TestnetChainconfig,
+		Dpos:          dpos.TestnetConfig,
+		Timestamp:     uint64(gtime.UnixNano()),
+		ExtraData:     hexutil.MustDecode(hexutil.Encode([]byte("ft testnet Genesis Block"))),
+		GasLimit:      params.GenesisGasLimit,
+		Difficulty:    params.GenesisDifficulty,
+		Coinbase:      params.TestnetChainconfig.SysName,
+		AllocAccounts: DefaultGenesisAccounts(),
+		AllocAssets:   DefaultGenesisAssets(),
+	}
+}
+
+// DefaultDevGenesis returns the genesis block of a local development network,
+// whose system account key is the well known default key.
+func DefaultDevGenesis() *Genesis {
+	gtime, _ := time.Parse("2006-01-02 15:04:05.999999999", "2019-01-16 00:00:00")
+	return &Genesis{
+		Config:        params.DevChainconfig,
+		Dpos:          dpos.DevConfig,
+		Timestamp:     uint64(gtime.UnixNano()),
+		ExtraData:     hexutil.MustDecode(hexutil.Encode([]byte("ft dev Genesis Block"))),
+		GasLimit:      params.GenesisGasLimit,
+		Difficulty:    params.GenesisDifficulty,
+		Coinbase:      params.DevChainconfig.SysName,
+		AllocAccounts: DefaultGenesisAccounts(),
+		AllocAssets:   DefaultGenesisAssets(),
+	}
+}
+
 // DefaultGenesisAccounts returns the ft net genesis accounts.
 func DefaultGenesisAccounts() []*GenesisAccount {
 	pubKey := common.HexToPubKey(params.DefaultPubkeyHex)
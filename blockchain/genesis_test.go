@@ -22,10 +22,12 @@ import (
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
+	am "github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/consensus/dpos"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/state"
 	"github.com/fractalplatform/fractal/utils/fdb"
 )
 
@@ -128,3 +130,48 @@ func TestSetupGenesis(t *testing.T) {
 		}
 	}
 }
+
+func TestGenesisSystemAccounts(t *testing.T) {
+	// A genesis without the system namespace doesn't create the fee pool.
+	db := fdb.NewMemDatabase()
+	genesis := DefaultGenesis()
+	block, err := genesis.Commit(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb, err := state.New(block.Hash(), state.NewDatabase(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountManager, err := am.NewAccountManager(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := accountManager.AccountIsExist(genesis.Config.FeePool()); ok {
+		t.Error("fee pool created without the system namespace")
+	}
+
+	db = fdb.NewMemDatabase()
+	genesis = DefaultGenesis()
+	config := *genesis.Config
+	config.SysPrefix = params.DefaultSysPrefix
+	genesis.Config = &config
+	if block, err = genesis.Commit(db); err != nil {
+		t.Fatal(err)
+	}
+	if statedb, err = state.New(block.Hash(), state.NewDatabase(db)); err != nil {
+		t.Fatal(err)
+	}
+	accountManager, err = am.NewAccountManager(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []common.Name{genesis.Config.SysName, common.StrToName(genesis.Dpos.AccountName), genesis.Config.FeePool()} {
+		if ok, err := accountManager.IsSystemAccount(name); err != nil || !ok {
+			t.Errorf("%v is no system account (%v)", name, err)
+		}
+	}
+	if ok, _ := accountManager.IsReservedName(common.Name(params.DefaultSysPrefix + "new")); !ok {
+		t.Error("system account name not reserved")
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	am "github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/consensus/dpos"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+// ExportGenesis returns a genesis specification seeding a new chain with the
+// state of the canonical block at number: the accounts with their keys and
+// balances, the assets and the producers with the votes for them. The supply
+// of an asset is the sum of its balances, so that its owner keeps its own
+// balance after paying the others. Contract code and storage, nonces and
+// pending unstakes aren't carried over.
+//
+// The chain and dpos configurations are those of the exported chain with the
+// given chain id, which must differ from the exported one: the nonces start
+// over, so the transactions of the exported chain must not be valid on the new
+// one. Forks activated up to the exported block are active from the genesis,
+// later forks keep their distance to it. Exporting the same block always
+// returns the same specification.
+func ExportGenesis(db fdb.Database, number uint64, chainID *big.Int) (*Genesis, error) {
+	if chainID == nil || chainID.Sign() <= 0 {
+		return nil, errors.New("chain id of the new chain required")
+	}
+	hash := rawdb.ReadCanonicalHash(db, number)
+	if hash == (common.Hash{}) {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	header := rawdb.ReadHeader(db, hash, number)
+	if header == nil {
+		return nil, fmt.Errorf("header %d %x not found", number, hash)
+	}
+	ghash := rawdb.ReadCanonicalHash(db, 0)
+	gheader := rawdb.ReadHeader(db, ghash, 0)
+	if gheader == nil {
+		return nil, fmt.Errorf("genesis header %x not found", ghash)
+	}
+	config := rawdb.ReadChainConfig(db, ghash)
+	if config == nil {
+		return nil, fmt.Errorf("chain config of genesis %x not found", ghash)
+	}
+	if config.ChainID != nil && config.ChainID.Cmp(chainID) == 0 {
+		return nil, fmt.Errorf("chain id %v of the new chain equals the exported one", chainID)
+	}
+	config.ChainID = new(big.Int).Set(chainID)
+	rebaseForks(config, number)
+	dposConfig := new(dpos.Config)
+	if err := dposConfig.Read(db, append([]byte("ft-dpos-"), ghash.Bytes()...)); err != nil {
+		return nil, err
+	}
+
+	statedb, err := state.New(hash, state.NewDatabase(db))
+	if err != nil {
+		return nil, err
+	}
+	accountManager, err := am.NewReadOnlyAccountManager(statedb)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := accountManager.GetAllAssetObject()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint64]*asset.AssetObject, len(assets))
+	supply := make(map[uint64]*big.Int, len(assets))
+	for _, obj := range assets {
+		byID[obj.AssetId] = obj
+		supply[obj.AssetId] = new(big.Int)
+	}
+
+	g := &Genesis{
+		Config:     config,
+		Dpos:       dposConfig,
+		Timestamp:  header.Time.Uint64(),
+		ExtraData:  gheader.Extra,
+		GasLimit:   header.GasLimit,
+		Difficulty: gheader.Difficulty,
+		Coinbase:   gheader.Coinbase,
+	}
+	var (
+		names    []string
+		accounts = make(map[common.Name]bool)
+		walkErr  error
+	)
+	err = accountManager.ForEachAccount(func(acct *am.Account) bool {
+		if acct.IsDestoryed() {
+			return true
+		}
+		account := &GenesisAccount{Name: acct.AcctName, PubKey: acct.PublicKey}
+		for _, balance := range acct.Balances {
+			if balance.Balance.Sign() <= 0 {
+				continue
+			}
+			obj, ok := byID[balance.AssetID]
+			if !ok {
+				walkErr = fmt.Errorf("account %v holds unknown asset %d", acct.AcctName, balance.AssetID)
+				return false
+			}
+			supply[obj.AssetId].Add(supply[obj.AssetId], balance.Balance)
+			if obj.Owner == acct.AcctName {
+				continue
+			}
+			account.Balances = append(account.Balances, &GenesisBalance{
+				Asset:  obj.AssetName,
+				Amount: new(big.Int).Set(balance.Balance),
+			})
+		}
+		g.AllocAccounts = append(g.AllocAccounts, account)
+		names = append(names, acct.AcctName.String())
+		accounts[acct.AcctName] = true
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	// assets are issued in id order again, so that they keep their ids
+	for _, obj := range assets {
+		if !accounts[obj.Owner] {
+			return nil, fmt.Errorf("owner %v of asset %v not found", obj.Owner, obj.AssetName)
+		}
+		g.AllocAssets = append(g.AllocAssets, &asset.AssetObject{
+			AssetName: obj.AssetName,
+			Symbol:    obj.Symbol,
+			Amount:    supply[obj.AssetId],
+			Decimals:  obj.Decimals,
+			Owner:     obj.Owner,
+		})
+	}
+
+	if g.AllocProducers, err = dpos.ExportProducers(dposConfig, statedb, names); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// rebaseForks moves the fork blocks of the config to a chain whose genesis
+// follows the block at number: passed forks activate at the genesis, later
+// ones as many blocks after it as they were after the block.
+func rebaseForks(config *params.ChainConfig, number uint64) {
+	rebase := func(block uint64) uint64 {
+		if block <= number {
+			return 0
+		}
+		return block - number
+	}
+	for i, block := range config.ForkBlocks {
+		config.ForkBlocks[i] = rebase(block)
+	}
+	for _, fork := range config.ActionForks {
+		fork.Block = rebase(fork.Block)
+	}
+	for _, block := range []*big.Int{config.ExtensionBlock, config.StorageRentBlock, config.BlockLimitsBlock, config.WasmBlock, config.NativeBlock, config.FeeAssetBlock, config.CreateOpBlock} {
+		if block != nil {
+			block.SetUint64(rebase(block.Uint64()))
+		}
+	}
+}
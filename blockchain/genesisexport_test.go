@@ -0,0 +1,220 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	am "github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/consensus/dpos"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func stateAccounts(t *testing.T, statedb *state.StateDB) map[common.Name]*am.Account {
+	accountManager, err := am.NewAccountManager(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := make(map[common.Name]*am.Account)
+	err = accountManager.ForEachAccount(func(acct *am.Account) bool {
+		accounts[acct.AcctName] = acct
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return accounts
+}
+
+func TestExportGenesis(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	if _, _, _, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx); err != nil {
+		t.Error("makeNewChain err", err)
+	}
+	head := chain.CurrentBlock()
+
+	chainID := new(big.Int).Add(chain.Config().ChainID, big.NewInt(1))
+	exported, err := ExportGenesis(db, head.NumberU64(), chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.Config.ChainID.Cmp(chainID) != 0 {
+		t.Fatalf("chain id %v, want %v", exported.Config.ChainID, chainID)
+	}
+	data, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ExportGenesis(db, head.NumberU64(), chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if againData, _ := json.Marshal(again); !bytes.Equal(data, againData) {
+		t.Fatal("exports of the same block differ")
+	}
+	if len(exported.AllocProducers) == 0 {
+		t.Fatal("no producers exported")
+	}
+
+	// The new chain starts from the genesis decoded from the export.
+	fork := new(Genesis)
+	if err := json.Unmarshal(data, fork); err != nil {
+		t.Fatal(err)
+	}
+	forkdb := fdb.NewMemDatabase()
+	block, err := fork.Commit(forkdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldState, err := chain.StateAt(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newState, err := state.New(block.Hash(), state.NewDatabase(forkdb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldAccounts, newAccounts := stateAccounts(t, oldState), stateAccounts(t, newState)
+	if len(oldAccounts) != len(newAccounts) {
+		t.Fatalf("fork has %d accounts, want %d", len(newAccounts), len(oldAccounts))
+	}
+	for name, acct := range oldAccounts {
+		forked, ok := newAccounts[name]
+		if !ok {
+			t.Fatalf("account %v missing in the fork", name)
+		}
+		if forked.PublicKey != acct.PublicKey {
+			t.Errorf("account %v key %v, want %v", name, forked.PublicKey, acct.PublicKey)
+		}
+		want, _ := acct.GetAllBalances()
+		got, _ := forked.GetAllBalances()
+		for id, balance := range want {
+			if balance.Sign() != 0 && (got[id] == nil || got[id].Cmp(balance) != 0) {
+				t.Errorf("account %v balance of asset %d %v, want %v", name, id, got[id], balance)
+			}
+		}
+	}
+
+	oldNames := make([]string, 0, len(oldAccounts))
+	for name := range oldAccounts {
+		oldNames = append(oldNames, name.String())
+	}
+	oldProducers, err := dpos.ExportProducers(exported.Dpos, oldState, oldNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProducers, err := dpos.ExportProducers(fork.Dpos, newState, oldNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(oldProducers, newProducers) {
+		t.Fatal("producers of the fork differ")
+	}
+}
+
+func TestExportGenesisChainID(t *testing.T) {
+	_, db, chain, _, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	// The nonces start over, so the new chain needs its own chain id.
+	for _, chainID := range []*big.Int{nil, new(big.Int), new(big.Int).Set(chain.Config().ChainID)} {
+		if _, err := ExportGenesis(db, chain.CurrentBlock().NumberU64(), chainID); err == nil {
+			t.Fatalf("export with chain id %v succeeded", chainID)
+		}
+	}
+}
+
+func TestExportGenesisForks(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	if _, _, _, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx); err != nil {
+		t.Error("makeNewChain err", err)
+	}
+	number := chain.CurrentBlock().NumberU64()
+	if number < 2 {
+		t.Fatalf("head block %d, want at least 2", number)
+	}
+
+	// Forks passed at the exported block activate at the new genesis, the
+	// later ones keep their distance to the exported block.
+	ghash := chain.Genesis().Hash()
+	config := *rawdb.ReadChainConfig(db, ghash)
+	config.ForkBlocks = []uint64{1, number, number + 5}
+	config.ActionForks = []*params.ActionFork{{Block: number - 1, ActionTypes: []uint64{1}}, {Block: number + 3, ActionTypes: []uint64{2}}}
+	config.ExtensionBlock = new(big.Int).SetUint64(number)
+	config.StorageRentBlock = new(big.Int).SetUint64(number + 10)
+	config.WasmBlock = new(big.Int).SetUint64(number + 2)
+	config.NativeBlock = new(big.Int).SetUint64(number - 1)
+	config.FeeAssetBlock = new(big.Int).SetUint64(number + 4)
+	config.CreateOpBlock = new(big.Int).SetUint64(number)
+	rawdb.WriteChainConfig(db, ghash, &config)
+
+	exported, err := ExportGenesis(db, number, new(big.Int).Add(config.ChainID, big.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forks := exported.Config.ForkBlocks; !reflect.DeepEqual(forks, []uint64{0, 0, 5}) {
+		t.Errorf("fork blocks %v, want [0 0 5]", forks)
+	}
+	if forks := exported.Config.ActionForks; forks[0].Block != 0 || forks[1].Block != 3 {
+		t.Errorf("action fork blocks %d %d, want 0 3", forks[0].Block, forks[1].Block)
+	}
+	if block := exported.Config.ExtensionBlock; block.Sign() != 0 {
+		t.Errorf("extension block %v, want 0", block)
+	}
+	if block := exported.Config.StorageRentBlock; block.Uint64() != 10 {
+		t.Errorf("storage rent block %v, want 10", block)
+	}
+	if block := exported.Config.WasmBlock; block.Uint64() != 2 {
+		t.Errorf("wasm block %v, want 2", block)
+	}
+	if block := exported.Config.NativeBlock; block.Sign() != 0 {
+		t.Errorf("native block %v, want 0", block)
+	}
+	if block := exported.Config.FeeAssetBlock; block.Uint64() != 4 {
+		t.Errorf("fee asset block %v, want 4", block)
+	}
+	if block := exported.Config.CreateOpBlock; block.Sign() != 0 {
+		t.Errorf("create opcode block %v, want 0", block)
+	}
+	if exported.Config.BlockLimitsBlock != nil {
+		t.Errorf("block limits block %v, want nil", exported.Config.BlockLimitsBlock)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+)
+
+// GenesisAlloc is a bulk list of accounts and assets to be merged into a
+// genesis specification, e.g. balances migrated from another chain.
+type GenesisAlloc struct {
+	Accounts []*GenesisAccount    `json:"allocAccounts"`
+	Assets   []*asset.AssetObject `json:"allocAssets"`
+}
+
+// ReadGenesisAllocJSON decodes an allocation in the genesis JSON layout.
+func ReadGenesisAllocJSON(r io.Reader) (*GenesisAlloc, error) {
+	alloc := new(GenesisAlloc)
+	if err := json.NewDecoder(r).Decode(alloc); err != nil {
+		return nil, err
+	}
+	return alloc, nil
+}
+
+// ReadGenesisAllocCSV decodes an allocation from CSV rows of the form
+// name,pubkey[,asset,amount]. Rows of the same account are merged, so an
+// account holding several assets is listed once per asset. Blank lines and
+// lines starting with '#' are skipped.
+func ReadGenesisAllocCSV(r io.Reader) (*GenesisAlloc, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	alloc := new(GenesisAlloc)
+	accounts := make(map[common.Name]*GenesisAccount)
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != 2 && len(record) != 4 {
+			return nil, fmt.Errorf("record %d: want 2 or 4 fields, got %d", n, len(record))
+		}
+		name := common.Name(strings.TrimSpace(record[0]))
+		pubKey := common.HexToPubKey(strings.TrimSpace(record[1]))
+		account, ok := accounts[name]
+		if !ok {
+			account = &GenesisAccount{Name: name, PubKey: pubKey}
+			accounts[name] = account
+			alloc.Accounts = append(alloc.Accounts, account)
+		} else if account.PubKey != pubKey {
+			return nil, fmt.Errorf("record %d: conflicting public key for %v", n, name)
+		}
+		if len(record) == 2 {
+			continue
+		}
+		amount, ok := new(big.Int).SetString(strings.TrimSpace(record[3]), 10)
+		if !ok || amount.Sign() < 0 {
+			return nil, fmt.Errorf("record %d: invalid amount %q", n, record[3])
+		}
+		account.Balances = append(account.Balances, &GenesisBalance{
+			Asset:  strings.TrimSpace(record[2]),
+			Amount: amount,
+		})
+	}
+	return alloc, nil
+}
+
+// ReadGenesisAlloc decodes an allocation file, choosing the format by the
+// file extension (.csv or .json).
+func ReadGenesisAlloc(path string, r io.Reader) (*GenesisAlloc, error) {
+	switch {
+	case strings.HasSuffix(strings.ToLower(path), ".csv"):
+		return ReadGenesisAllocCSV(r)
+	case strings.HasSuffix(strings.ToLower(path), ".json"):
+		return ReadGenesisAllocJSON(r)
+	}
+	return nil, fmt.Errorf("unknown allocation format of %v, want .csv or .json", path)
+}
+
+// ReadGenesisAllocFile decodes an allocation file, see ReadGenesisAlloc.
+func ReadGenesisAllocFile(path string) (*GenesisAlloc, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadGenesisAlloc(path, file)
+}
+
+// Import merges an allocation into the genesis specification. Accounts and
+// assets are checked for duplicates and names and the balances against the
+// supply up front, so that an invalid entry among thousands fails before any
+// state is written.
+func (g *Genesis) Import(alloc *GenesisAlloc) error {
+	accounts := make(map[common.Name]bool, len(g.AllocAccounts)+len(alloc.Accounts))
+	for _, account := range g.AllocAccounts {
+		accounts[account.Name] = true
+	}
+	assets := make(map[string]bool, len(g.AllocAssets)+len(alloc.Assets))
+	for _, asset := range g.AllocAssets {
+		assets[asset.AssetName] = true
+	}
+
+	for _, asset := range alloc.Assets {
+		if assets[asset.AssetName] {
+			return fmt.Errorf("duplicate genesis asset %v", asset.AssetName)
+		}
+		if asset.Amount == nil || asset.Amount.Sign() < 0 {
+			return fmt.Errorf("invalid supply of genesis asset %v", asset.AssetName)
+		}
+		assets[asset.AssetName] = true
+	}
+	for _, account := range alloc.Accounts {
+		if !common.IsValidName(account.Name.String()) {
+			return fmt.Errorf("invalid genesis account name %v", account.Name)
+		}
+		if accounts[account.Name] {
+			return fmt.Errorf("duplicate genesis account %v", account.Name)
+		}
+		accounts[account.Name] = true
+	}
+
+	merged := *g
+	merged.AllocAssets = append(append([]*asset.AssetObject{}, g.AllocAssets...), alloc.Assets...)
+	merged.AllocAccounts = append(append([]*GenesisAccount{}, g.AllocAccounts...), alloc.Accounts...)
+	if err := merged.ValidateAllocs(); err != nil {
+		return err
+	}
+	g.AllocAssets, g.AllocAccounts = merged.AllocAssets, merged.AllocAccounts
+	return nil
+}
+
+// ValidateAllocs checks that the balances of the genesis accounts are amounts
+// of genesis assets, and that the supply of each asset covers the balances
+// transferred from its owner.
+func (g *Genesis) ValidateAllocs() error {
+	var (
+		supply = make(map[string]*big.Int, len(g.AllocAssets))
+		owners = make(map[string]common.Name, len(g.AllocAssets))
+	)
+	for _, asset := range g.AllocAssets {
+		if asset.Amount == nil || asset.Amount.Sign() < 0 {
+			return fmt.Errorf("invalid supply of genesis asset %v", asset.AssetName)
+		}
+		supply[asset.AssetName] = new(big.Int).Set(asset.Amount)
+		owners[asset.AssetName] = asset.Owner
+	}
+	for _, account := range g.AllocAccounts {
+		for _, balance := range account.Balances {
+			left, ok := supply[balance.Asset]
+			if !ok {
+				return fmt.Errorf("genesis account %v holds unknown asset %v", account.Name, balance.Asset)
+			}
+			if balance.Amount == nil || balance.Amount.Sign() < 0 {
+				return fmt.Errorf("invalid %v balance of genesis account %v", balance.Asset, account.Name)
+			}
+			// the owner keeps what isn't transferred
+			if account.Name == owners[balance.Asset] {
+				continue
+			}
+			if left.Sub(left, balance.Amount).Sign() < 0 {
+				return fmt.Errorf("genesis balances of %v exceed its supply", balance.Asset)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	am "github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func TestGenesisImport(t *testing.T) {
+	var csv strings.Builder
+	csv.WriteString("# name,pubkey,asset,amount\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&csv, "migrate%05d,%s,%s,%d\n", i, params.DefaultPubkeyHex, params.DefaultChainconfig.SysToken, i)
+		fmt.Fprintf(&csv, "migrate%05d,%s,migratedtoken,%d\n", i, params.DefaultPubkeyHex, 2*i)
+	}
+	alloc, err := ReadGenesisAllocCSV(strings.NewReader(csv.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alloc.Accounts) != 1000 || len(alloc.Accounts[7].Balances) != 2 {
+		t.Fatalf("read %d accounts, want 1000 with 2 balances each", len(alloc.Accounts))
+	}
+
+	genesis := DefaultGenesis()
+	if err := genesis.Import(alloc); err == nil {
+		t.Fatal("imported balances of an unknown asset")
+	}
+	alloc.Assets = []*asset.AssetObject{{
+		AssetName: "migratedtoken",
+		Symbol:    "mgt",
+		Amount:    big.NewInt(1000000000),
+		Decimals:  8,
+		Owner:     params.DefaultChainconfig.SysName,
+	}}
+	if err := genesis.Import(alloc); err != nil {
+		t.Fatal(err)
+	}
+	if err := genesis.Import(alloc); err == nil {
+		t.Fatal("imported duplicate accounts")
+	}
+
+	db := fdb.NewMemDatabase()
+	block, err := genesis.Commit(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb, err := state.New(block.Hash(), state.NewDatabase(db))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountManager, err := am.NewAccountManager(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := accountManager.GetAssetInfoByName("migratedtoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := accountManager.GetAccountBalanceByID(common.Name("migrate00999"), migrated.GetAssetId())
+	if err != nil || balance.Cmp(big.NewInt(1998)) != 0 {
+		t.Fatalf("migrated balance %v (%v), want 1998", balance, err)
+	}
+	balance, err = accountManager.GetAccountBalanceByID(params.DefaultChainconfig.SysName, migrated.GetAssetId())
+	if err != nil || balance.Cmp(big.NewInt(1000000000-999*1000)) != 0 {
+		t.Fatalf("owner balance %v (%v), want the unallocated supply", balance, err)
+	}
+	if rawdb.ReadCanonicalHash(db, 0) != block.Hash() {
+		t.Fatal("genesis block not written")
+	}
+}
+
+func TestGenesisSupply(t *testing.T) {
+	alloc := &GenesisAlloc{
+		Accounts: []*GenesisAccount{
+			{Name: "migrate00001", PubKey: common.HexToPubKey(params.DefaultPubkeyHex), Balances: []*GenesisBalance{{Asset: "migratedtoken", Amount: big.NewInt(600)}}},
+			{Name: "migrate00002", PubKey: common.HexToPubKey(params.DefaultPubkeyHex), Balances: []*GenesisBalance{{Asset: "migratedtoken", Amount: big.NewInt(600)}}},
+		},
+		Assets: []*asset.AssetObject{{
+			AssetName: "migratedtoken",
+			Symbol:    "mgt",
+			Amount:    big.NewInt(1000),
+			Decimals:  8,
+			Owner:     params.DefaultChainconfig.SysName,
+		}},
+	}
+	genesis := DefaultGenesis()
+	if err := genesis.Import(alloc); err == nil {
+		t.Fatal("imported balances exceeding the supply")
+	}
+	if len(genesis.AllocAccounts) != len(DefaultGenesis().AllocAccounts) {
+		t.Fatal("failed import changed the genesis")
+	}
+
+	// A genesis file exceeding the supply fails to commit instead of panicking.
+	genesis.AllocAccounts = append(genesis.AllocAccounts, alloc.Accounts...)
+	genesis.AllocAssets = append(genesis.AllocAssets, alloc.Assets...)
+	if _, err := genesis.Commit(fdb.NewMemDatabase()); err == nil {
+		t.Fatal("committed balances exceeding the supply")
+	}
+	if _, _, _, err := SetupGenesisBlock(fdb.NewMemDatabase(), genesis); err == nil {
+		t.Fatal("set up balances exceeding the supply")
+	}
+
+	alloc.Assets[0].Amount = big.NewInt(1200)
+	if err := genesis.ValidateAllocs(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := genesis.Commit(fdb.NewMemDatabase()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadGenesisAllocCSVErrors(t *testing.T) {
+	tests := []string{
+		"onlyname\n",
+		"migrate00001,04,ftoken\n",
+		"migrate00001,04,ftoken,-5\n",
+		"migrate00001,04,ftoken,1x\n",
+		"migrate00001,04\nmigrate00001,05\n",
+	}
+	for _, test := range tests {
+		if _, err := ReadGenesisAllocCSV(strings.NewReader(test)); err == nil {
+			t.Errorf("%q: no error", test)
+		}
+	}
+}
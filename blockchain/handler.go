@@ -18,39 +18,56 @@ package blockchain
 
 import (
 	"fmt"
-	"time"
+	"strconv"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/common"
 	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/types"
 )
 
 type BlockchainStation struct {
 	station    router.Station
+	router     *router.Router // router of the node, the default one if nil
 	peerCh     chan *router.Event
 	blockchain *BlockChain
 	networkId  uint64
+	forks      []uint64
 	downloader *Downloader
+	subs       []router.Subscription
+	quit       chan struct{}
 }
 
 func errResp(code errCode, format string, v ...interface{}) error {
 	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
 }
 
-func newBlcokchainStation(bc *BlockChain, networkId uint64) *BlockchainStation {
+func newBlcokchainStation(bc *BlockChain, networkId uint64, opts ...DownloaderOption) *BlockchainStation {
 	bs := &BlockchainStation{
+		router:     bc.router,
 		peerCh:     make(chan *router.Event),
 		blockchain: bc,
 		networkId:  networkId,
-		downloader: NewDownloader(bc),
+		forks:      gatherForks(bc.chainConfig),
+		downloader: NewDownloader(bc, opts...),
+		quit:       make(chan struct{}),
+	}
+	bs.subs = []router.Subscription{
+		bs.router.Subscribe(nil, bs.peerCh, router.P2pNewPeer, nil),
+		bs.router.Subscribe(nil, bs.peerCh, router.P2pDelPeer, nil),
+		bs.router.Subscribe(nil, bs.peerCh, router.DownloaderGetStatus, ""),
+		bs.router.Subscribe(nil, bs.peerCh, router.DownloaderGetBlockHashMsg, &getBlcokHashByNumber{}),
+		bs.router.Subscribe(nil, bs.peerCh, router.DownloaderGetBlockHeadersMsg, &getBlockHeadersData{}),
+		bs.router.Subscribe(nil, bs.peerCh, router.DownloaderGetBlockBodiesMsg, []common.Hash{}),
+		bs.router.Subscribe(nil, bs.peerCh, router.DownloaderGetBlockStatesMsg, []common.Hash{}),
+		bs.router.Subscribe(nil, bs.peerCh, router.DownloaderGetStateHashesMsg, &getStateHashesData{}),
+		bs.router.Subscribe(nil, bs.peerCh, router.DownloaderGetStateItemsMsg, &getStateItemsData{}),
+		bs.router.Subscribe(nil, bs.peerCh, router.DownloaderGetBlockTxHashesMsg, common.Hash{}),
+		bs.router.Subscribe(nil, bs.peerCh, router.DownloaderGetBlockTxChunkMsg, &getBlockTxChunkData{}),
+		bs.router.Subscribe(nil, bs.peerCh, router.CheckpointVoteMsg, &types.CheckpointVote{}),
+		bs.router.Subscribe(nil, bs.peerCh, router.GetCheckpointMsg, uint64(0)),
+		bs.router.Subscribe(nil, bs.peerCh, router.CheckpointMsg, &types.SignedCheckpoint{}),
 	}
-	router.Subscribe(nil, bs.peerCh, router.P2pNewPeer, nil)
-	router.Subscribe(nil, bs.peerCh, router.P2pDelPeer, nil)
-	router.Subscribe(nil, bs.peerCh, router.DownloaderGetStatus, "")
-	router.Subscribe(nil, bs.peerCh, router.DownloaderGetBlockHashMsg, &getBlcokHashByNumber{})
-	router.Subscribe(nil, bs.peerCh, router.DownloaderGetBlockHeadersMsg, &getBlockHeadersData{})
-	router.Subscribe(nil, bs.peerCh, router.DownloaderGetBlockBodiesMsg, []common.Hash{})
 
 	go bs.loop()
 	return bs
@@ -63,24 +80,39 @@ func (bs *BlockchainStation) chainStatus() *statusData {
 	number := head.Number.Uint64()
 	td := bs.blockchain.GetTd(hash, number)
 	return &statusData{
-		ProtocolVersion: uint32(1),
-		NetworkId:       0,
+		ProtocolVersion: uint32(ProtocolVersion),
+		NetworkId:       bs.networkId,
 		TD:              td,
 		CurrentBlock:    hash,
 		CurrentNumber:   number,
 		GenesisBlock:    genesis.Hash(),
+		ForkID:          newForkID(genesis.Hash(), bs.forks, number),
+		Capabilities:    bs.capabilities(),
 	}
 }
 
-func checkChainStatus(local *statusData, remote *statusData) error {
+// capabilities returns the requests the node is able to serve.
+func (bs *BlockchainStation) capabilities() uint64 {
+	if bs.downloader.Mode() == LightSync {
+		return CapLightOnly
+	}
+	return CapBlockStates | CapState | CapTxChunks
+}
+
+// checkChainStatus validates the status of a remote peer against ours, the
+// peer is rejected if it is on another chain or on an incompatible fork.
+func checkChainStatus(local *statusData, remote *statusData, forks []uint64) error {
 	if local.GenesisBlock != remote.GenesisBlock {
 		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", remote.GenesisBlock[:8], local.GenesisBlock[:8])
 	}
 	if local.NetworkId != remote.NetworkId {
 		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", remote.NetworkId, local.NetworkId)
 	}
-	if local.ProtocolVersion != remote.ProtocolVersion {
-		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", remote.ProtocolVersion, local.ProtocolVersion)
+	if _, ok := negotiateVersion(local.ProtocolVersion, remote.ProtocolVersion); !ok {
+		return errResp(ErrProtocolVersionMismatch, "%d (< %d)", remote.ProtocolVersion, minProtocolVersion)
+	}
+	if err := checkForkID(local.GenesisBlock, forks, local.CurrentNumber, remote.ForkID); err != nil {
+		return errResp(ErrForkIDRejected, "%v", err)
 	}
 	return nil
 }
@@ -88,34 +120,62 @@ func checkChainStatus(local *statusData, remote *statusData) error {
 func (bs *BlockchainStation) handshake(e *router.Event) {
 	station := router.NewLocalStation("shake"+e.From.Name(), nil)
 	ch := make(chan *router.Event)
-	sub := router.Subscribe(station, ch, router.DownloaderStatusMsg, &statusData{})
+	sub := bs.router.Subscribe(station, ch, router.DownloaderStatusMsg, &statusData{})
 	defer sub.Unsubscribe()
-	defer router.StationUnregister(station)
+	defer bs.router.StationUnregister(station)
 
-	router.SendTo(station, e.From, router.DownloaderGetStatus, "")
+	bs.router.SendTo(station, e.From, router.DownloaderGetStatus, strconv.Itoa(ProtocolVersion))
 	disconnect := func() {
-		router.SendTo(nil, nil, router.P2pDisconectPeer, e.From)
+		bs.router.SendTo(nil, nil, router.P2pDisconectPeer, e.From)
 	}
-	timer := time.After(5 * time.Second)
+	timer := bs.downloader.clock.After(handshakeTimeout)
 	select {
 	case e := <-ch:
 		remote := e.Data.(*statusData)
-		if err := checkChainStatus(bs.chainStatus(), remote); err != nil {
-			disconnect()
+		local := bs.chainStatus()
+		if err := checkChainStatus(local, remote, bs.forks); err != nil {
+			if local.GenesisBlock != remote.GenesisBlock || local.NetworkId != remote.NetworkId {
+				// peers of another network will never be useful, ban them.
+				bs.router.SendTo(nil, nil, router.P2pBanPeer, e.From)
+			} else {
+				disconnect()
+			}
 			log.Warn(fmt.Sprintln("handshake error:", err))
 			return
 		}
 		log.Info(fmt.Sprintf("new remote station:%x", []byte(e.From.Name())))
-		bs.downloader.AddStation(e.From, remote.TD, remote.CurrentNumber, remote.CurrentBlock)
+		version, _ := negotiateVersion(local.ProtocolVersion, remote.ProtocolVersion)
+		caps := remote.Capabilities
+		if version < capabilitiesVersion {
+			caps = CapBlockStates | CapState
+		}
+		bs.downloader.AddStation(e.From, remote.TD, remote.CurrentNumber, remote.CurrentBlock, version, caps)
+		if version >= capabilitiesVersion {
+			bs.requestCheckpoint(e.From)
+		}
 	case <-timer:
 		log.Warn("handshake timeout", e.From.Name())
 		disconnect()
 	}
 }
 
+// stop stops serving the remotes and the downloader.
+func (bs *BlockchainStation) stop() {
+	for _, sub := range bs.subs {
+		sub.Unsubscribe()
+	}
+	close(bs.quit)
+	bs.downloader.Stop()
+}
+
 func (bs *BlockchainStation) loop() {
 	for {
-		e := <-bs.peerCh
+		var e *router.Event
+		select {
+		case e = <-bs.peerCh:
+		case <-bs.quit:
+			return
+		}
 		switch e.Typecode {
 		case router.P2pNewPeer:
 			go bs.handshake(e)
@@ -132,85 +192,89 @@ func (bs *BlockchainStation) loop() {
 func (bs *BlockchainStation) handleMsg(e *router.Event) error {
 	switch e.Typecode {
 	case router.DownloaderGetStatus:
+		// remotes before capabilitiesVersion request the status without
+		// their version and only decode the legacy status
 		status := bs.chainStatus()
-		router.ReplyEvent(e, router.DownloaderStatusMsg, status)
+		if version, err := strconv.ParseUint(e.Data.(string), 10, 32); err != nil || version < capabilitiesVersion {
+			bs.router.ReplyEvent(e, router.DownloaderStatusMsg, legacyStatus(status))
+		} else {
+			bs.router.ReplyEvent(e, router.DownloaderStatusMsg, status)
+		}
 
 	case router.DownloaderGetBlockHashMsg:
-		query := e.Data.(*getBlcokHashByNumber)
-		hashes := make([]common.Hash, 0, query.Amount)
-		for len(hashes) < int(query.Amount) {
-			header := bs.blockchain.GetHeaderByNumber(query.Number)
-			if header == nil {
-				break
-			}
-			hashes = append(hashes, header.Hash())
-			if query.Reverse {
-				if query.Number < query.Skip+1 {
-					break
-				}
-				query.Number -= query.Skip + 1
-			} else {
-				query.Number += query.Skip + 1
-			}
-		}
-		router.ReplyEvent(e, router.BlockHashMsg, hashes)
-	// Block header query, collect the requested headers and reply
+		hashes := serveBlockHashes(bs.blockchain, e.Data.(*getBlcokHashByNumber))
+		bs.router.ReplyEvent(e, router.BlockHashMsg, hashes)
 	case router.DownloaderGetBlockHeadersMsg:
-		// Decode the complex header query
-		query := e.Data.(*getBlockHeadersData)
-		if query.Origin.Hash != (common.Hash{}) {
-			header := bs.blockchain.GetHeaderByHash(query.Origin.Hash)
-			if header == nil {
-				router.ReplyEvent(e, router.BlockHeadersMsg, []*types.Header{})
-				return nil
-			}
-			query.Origin.Number = header.Number.Uint64()
+		headers := serveBlockHeaders(bs.blockchain, e.Data.(*getBlockHeadersData))
+		bs.router.ReplyEvent(e, router.BlockHeadersMsg, headers)
+	case router.DownloaderGetBlockBodiesMsg:
+		bodies := serveBlockBodies(bs.blockchain, e.Data.([]common.Hash))
+		bs.router.ReplyEvent(e, router.BlockBodiesMsg, bodies)
+	case router.DownloaderGetBlockStatesMsg:
+		states := serveBlockStates(bs.blockchain, e.Data.([]common.Hash))
+		bs.router.ReplyEvent(e, router.BlockStatesMsg, states)
+	case router.DownloaderGetStateHashesMsg:
+		hashes := serveStateHashes(bs.blockchain, e.Data.(*getStateHashesData))
+		bs.router.ReplyEvent(e, router.StateHashesMsg, hashes)
+	case router.DownloaderGetStateItemsMsg:
+		values := serveStateItems(bs.blockchain, e.Data.(*getStateItemsData))
+		bs.router.ReplyEvent(e, router.StateItemsMsg, values)
+	case router.DownloaderGetBlockTxHashesMsg:
+		hashes := serveBlockTxHashes(bs.blockchain, e.Data.(common.Hash))
+		bs.router.ReplyEvent(e, router.BlockTxHashesMsg, hashes)
+	case router.DownloaderGetBlockTxChunkMsg:
+		chunk := serveBlockTxChunk(bs.blockchain, e.Data.(*getBlockTxChunkData))
+		bs.router.ReplyEvent(e, router.BlockTxChunkMsg, chunk)
+	case router.CheckpointVoteMsg:
+		bs.relayCheckpointVote(e.From, e.Data.(*types.CheckpointVote))
+	case router.GetCheckpointMsg:
+		if next := bs.blockchain.checkpoints.After(e.Data.(uint64)); next != nil {
+			bs.router.ReplyEvent(e, router.CheckpointMsg, next)
 		}
-
-		// Gather headers until the fetch or network limits is reached
-		var (
-			headers []*types.Header
-		)
-		for len(headers) < int(query.Amount) {
-			// Retrieve the next header satisfying the query
-			origin := bs.blockchain.GetHeaderByNumber(query.Origin.Number)
-			if origin == nil {
-				break
-			}
-			headers = append(headers, origin)
-
-			// Advance to the next header of the query
-			if query.Reverse {
-				// Number based traversal towards the genesis block
-				if query.Origin.Number < query.Skip+1 {
-					break
-				}
-				query.Origin.Number -= query.Skip + 1
-			} else {
-				// Number based traversal towards the leaf block
-				query.Origin.Number += query.Skip + 1
-			}
+	case router.CheckpointMsg:
+		checkpoint := e.Data.(*types.SignedCheckpoint)
+		latest, err := bs.blockchain.checkpoints.AddSigned(checkpoint)
+		if err != nil {
+			log.Debug("Rejected checkpoint", "number", checkpoint.Checkpoint.Number, "err", err)
+		} else if latest {
+			// the checkpoint attests the schedule signing the next one
+			bs.requestCheckpoint(e.From)
 		}
+	}
+	return nil
+}
 
-		router.ReplyEvent(e, router.BlockHeadersMsg, headers)
-		return nil
-	case router.DownloaderGetBlockBodiesMsg:
-		// Decode the retrieval message
-		hashes := e.Data.([]common.Hash)
-		// Gather blocks until the fetch or network limits is reached
-		var (
-			bodies []*types.Body
-		)
-		for _, hash := range hashes {
-			// Retrieve the requested block body, stopping if enough was found
-			body := bs.blockchain.GetBody(hash)
-			if body == nil {
-				break
+// requestCheckpoint asks a remote for its lowest signed checkpoint above our
+// latest one.
+func (bs *BlockchainStation) requestCheckpoint(remote router.Station) {
+	var number uint64
+	if latest := bs.blockchain.checkpoints.Latest(); latest != nil {
+		number = latest.Checkpoint.Number
+	}
+	bs.router.SendTo(nil, remote, router.GetCheckpointMsg, number)
+}
+
+// relayCheckpointVote adds a vote of a remote to the checkpoint pool, and
+// relays the new votes and the local ones to the remotes not known to have
+// them.
+func (bs *BlockchainStation) relayCheckpointVote(from router.Station, vote *types.CheckpointVote) {
+	hash := vote.Hash()
+	if from != nil {
+		bs.downloader.markRemote(from, hash)
+		fresh, err := bs.blockchain.checkpoints.AddVote(vote)
+		if err != nil {
+			log.Debug("Rejected checkpoint vote", "number", vote.Checkpoint.Number, "producer", vote.Producer, "err", err)
+			if latest := bs.blockchain.checkpoints.Latest(); latest == nil || latest.Checkpoint.Number < vote.Checkpoint.Number {
+				// the vote may be signed by a schedule attested by checkpoints we miss
+				bs.requestCheckpoint(from)
 			}
-			bodies = append(bodies, body)
+			return
+		}
+		if !fresh {
+			return
 		}
-		router.ReplyEvent(e, router.BlockBodiesMsg, bodies)
-		return nil
 	}
-	return nil
+	for _, station := range bs.downloader.markRemotes(hash, capabilitiesVersion) {
+		go bs.router.SendTo(nil, station, router.CheckpointVoteMsg, vote)
+	}
 }
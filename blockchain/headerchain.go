@@ -0,0 +1,230 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+// fetchedBodyCacheLimit is the number of block bodies fetched from the
+// remotes a header-only chain keeps in memory.
+const fetchedBodyCacheLimit = 1024
+
+var (
+	errNotHeaderOnly   = errors.New("chain database stores full blocks")
+	errBodyUnavailable = errors.New("block body unavailable from the remotes")
+)
+
+// HeaderOnly reports whether the chain stores the headers and total
+// difficulties of the blocks only. Their bodies are fetched from the remotes
+// when needed, and no state is kept above the genesis block.
+func (bc *BlockChain) HeaderOnly() bool {
+	return bc.headerOnly
+}
+
+// initHeaderOnly checks that the database stores the blocks the way the chain
+// is configured to, marking a new database header-only if it is. A read-only
+// chain stores the blocks the way its database does.
+func (bc *BlockChain) initHeaderOnly(headerOnly bool) error {
+	marked := rawdb.ReadHeaderOnly(bc.db)
+	switch {
+	case bc.readOnly:
+	case marked && !headerOnly:
+		return errors.New("chain database stores the headers only, it needs light sync")
+	case !marked && headerOnly:
+		if number := rawdb.ReadHeaderNumber(bc.db, rawdb.ReadHeadBlockHash(bc.db)); number != nil && *number > 0 {
+			return errors.New("chain database stores full blocks, it can't light sync")
+		}
+		rawdb.WriteHeaderOnly(bc.db)
+		marked = true
+	}
+	bc.headerOnly = marked
+	return nil
+}
+
+// loadHeaderHead sets the head of a header-only chain. The heads are written
+// along with the headers, so they need no repair.
+func (bc *BlockChain) loadHeaderHead(head common.Hash) error {
+	header := bc.GetHeaderByHash(head)
+	if header == nil {
+		return fmt.Errorf("non existent head header [%x…]", head[:4])
+	}
+	block := bc.headerBlock(header)
+	bc.currentBlock.Store(block)
+	bc.currentFastBlock.Store(block)
+	log.Info("Loaded most recent local header", "number", header.Number, "hash", head, "td", bc.GetTd(head, header.Number.Uint64()))
+	return nil
+}
+
+// headerBlock returns the block of a header-only chain with the header, the
+// full block if its body is at hand.
+func (bc *BlockChain) headerBlock(header *types.Header) *types.Block {
+	if block := bc.GetBlock(header.Hash(), header.Number.Uint64()); block != nil {
+		return block
+	}
+	return types.NewBlockWithHeader(header)
+}
+
+// InsertHeaderChain extends a header-only chain with the headers, which must
+// be ordered and linked. The chain keeps no state to read the producers
+// from, so the headers above the latest signed checkpoint must be sealed by a
+// producer of the schedule it attests, and the ones below it are committed by
+// its hash. The head never moves to a branch conflicting with the latest
+// checkpoint, which also means the chain stops following the remotes when the
+// schedule changes until the checkpoint attesting the new one is signed. No
+// chain events are posted, their subscribers need the state. It returns the
+// index of the header failing to insert.
+func (bc *BlockChain) InsertHeaderChain(headers []*types.Header) (int, error) {
+	if bc.readOnly {
+		return 0, fdb.ErrReadOnly
+	}
+	if !bc.headerOnly {
+		return 0, errNotHeaderOnly
+	}
+	for i := 1; i < len(headers); i++ {
+		if headers[i].Number.Uint64() != headers[i-1].Number.Uint64()+1 || headers[i].ParentHash != headers[i-1].Hash() {
+			return 0, fmt.Errorf("non contiguous insert: item %d is #%d, item %d is #%d", i-1, headers[i-1].Number, i, headers[i].Number)
+		}
+	}
+
+	bc.wg.Add(1)
+	defer bc.wg.Done()
+
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	for i, header := range headers {
+		if atomic.LoadInt32(&bc.procInterrupt) == 1 {
+			log.Debug("Premature abort during header processing")
+			break
+		}
+		hash, number := header.Hash(), header.Number.Uint64()
+		if bc.HasHeader(hash, number) {
+			continue
+		}
+		if err := bc.validator.ValidateHeader(header, false); err != nil {
+			return i, err
+		}
+		latest := bc.checkpoints.Latest()
+		if latest != nil && number <= latest.Checkpoint.Number {
+			if number == latest.Checkpoint.Number && hash != latest.Checkpoint.Hash {
+				return i, errCheckpointMismatch
+			}
+		} else if err := bc.checkpoints.VerifySeal(header, latest); err != nil {
+			return i, err
+		}
+		ptd := bc.GetTd(header.ParentHash, number-1)
+		if ptd == nil {
+			return i, processor.ErrUnknownAncestor
+		}
+		td := new(big.Int).Add(ptd, header.Difficulty)
+
+		batch := bc.db.NewBatch()
+		rawdb.WriteHeader(batch, header)
+		rawdb.WriteTd(batch, hash, number, td)
+		reorg := bc.forkChoice.ReorgNeeded(header, td)
+		if reorg {
+			if err := bc.writeHeaderHead(batch, header, latest); err != nil {
+				return i, err
+			}
+		}
+		if err := batch.Write(); err != nil {
+			return i, err
+		}
+		bc.tdCache.Add(hash, td)
+		if reorg {
+			block := types.NewBlockWithHeader(header)
+			bc.currentBlock.Store(block)
+			bc.currentFastBlock.Store(block)
+		}
+		log.Debug("Inserted new header", "number", number, "hash", hash, "head", reorg)
+	}
+	return 0, nil
+}
+
+// writeHeaderHead makes the header the head of the chain, moving the canonical
+// hashes over to its branch. It fails if the canonical chain reaches the
+// latest checkpoint and the branch of the header doesn't.
+func (bc *BlockChain) writeHeaderHead(batch fdb.Batch, header *types.Header, latest *types.SignedCheckpoint) error {
+	number := header.Number.Uint64()
+	// the branch of the header may only fork off above a checkpoint in the
+	// canonical chain
+	floor := uint64(0)
+	if latest != nil && rawdb.ReadCanonicalHash(bc.db, latest.Checkpoint.Number) == latest.Checkpoint.Hash {
+		floor = latest.Checkpoint.Number
+		if number < floor {
+			return errCheckpointMismatch
+		}
+	}
+	hash, parent := header.ParentHash, number-1
+	for rawdb.ReadCanonicalHash(bc.db, parent) != hash {
+		if floor > 0 && parent <= floor {
+			return errCheckpointMismatch
+		}
+		rawdb.WriteCanonicalHash(batch, hash, parent)
+		hash = bc.GetHeader(hash, parent).ParentHash
+		parent--
+	}
+	for n := number + 1; rawdb.ReadCanonicalHash(bc.db, n) != (common.Hash{}); n++ {
+		rawdb.DeleteCanonicalHash(batch, n)
+	}
+	rawdb.WriteCanonicalHash(batch, header.Hash(), number)
+	rawdb.WriteHeadBlockHash(batch, header.Hash())
+	rawdb.WriteHeadHeaderHash(batch, header.Hash())
+	rawdb.WriteHeadFastBlockHash(batch, header.Hash())
+	return nil
+}
+
+// FetchBlock returns the block with the hash and number. A header-only chain
+// fetches the body of the block from the remotes, keeping the bodies fetched
+// last in memory.
+func (bc *BlockChain) FetchBlock(hash common.Hash, number uint64) (*types.Block, error) {
+	if block := bc.GetBlock(hash, number); block != nil {
+		return block, nil
+	}
+	header := bc.GetHeader(hash, number)
+	if header == nil || !bc.headerOnly {
+		return nil, fmt.Errorf("block #%d [%x…] not found", number, hash[:4])
+	}
+	block := types.NewBlockWithHeader(header)
+	if types.DeriveTxMerkleRoot(nil) == header.TxsRoot {
+		return block, nil
+	}
+	if cached, ok := bc.fetchedBodies.Get(hash); ok {
+		return block.WithBody(cached.(*types.Body).Transactions), nil
+	}
+	dl := bc.Downloader()
+	if dl == nil {
+		return nil, errBodyUnavailable
+	}
+	body, err := dl.fetchBody(header)
+	if err != nil {
+		return nil, err
+	}
+	bc.fetchedBodies.Add(hash, body)
+	return block.WithBody(body.Transactions), nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/crypto"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func TestHeaderOnlyChain(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 1)
+	if _, _, _, err = makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx); err != nil {
+		t.Error("makeNewChain err", err)
+	}
+	head := chain.CurrentBlock()
+	var headers []*types.Header
+	for i := uint64(1); i <= head.NumberU64(); i++ {
+		headers = append(headers, chain.GetHeaderByNumber(i))
+	}
+
+	newdb := fdb.NewMemDatabase()
+	if _, err := DefaultGenesis().Commit(newdb); err != nil {
+		t.Fatal(err)
+	}
+	config := &CacheConfig{StateCache: state.DefaultCacheSize, HeaderOnly: true}
+	newchain, err := NewBlockChain(newdb, config, vm.Config{}, chain.Config(), txpool.SenderCacher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newchain.Stop()
+	type bc struct {
+		*BlockChain
+		consensus.IEngine
+	}
+	newchain.SetValidator(processor.NewBlockValidator(&bc{newchain, tengine}, tengine))
+
+	if _, err := chain.InsertHeaderChain(headers); err != errNotHeaderOnly {
+		t.Fatalf("headers inserted into a full chain: %v", err)
+	}
+	if n, err := newchain.InsertHeaderChain(headers[1:]); err == nil || n != 0 {
+		t.Fatalf("unlinked headers inserted: %d %v", n, err)
+	}
+
+	// The seals are verified against the genesis schedule without a signed
+	// checkpoint.
+	if _, err := newchain.InsertHeaderChain(headers); err != errNoSealVerifier {
+		t.Fatalf("headers inserted without seal verifier: %v", err)
+	}
+	schedule := []*types.CheckpointProducer{{Name: chain.Config().SysName.String(), PubKey: crypto.FromECDSAPub(&sysnameprikey.PublicKey)}}
+	for _, producer := range producers {
+		schedule = append(schedule, &types.CheckpointProducer{Name: producer.name, PubKey: crypto.FromECDSAPub(&producer.prikey.PublicKey)})
+	}
+	newchain.checkpoints.SetScheduler(func(*types.Header) ([]*types.CheckpointProducer, error) { return schedule[:len(schedule)-1], nil })
+	newchain.checkpoints.SetSealVerifier(func(header *types.Header, schedule []*types.CheckpointProducer) error {
+		return tengine.VerifyScheduledSeal(newchain, header, schedule)
+	})
+	n, err := newchain.InsertHeaderChain(headers)
+	if err == nil || headers[n].Coinbase.String() != producers[len(producers)-1].name {
+		t.Fatalf("header of unscheduled producer inserted: %d %v", n, err)
+	}
+	newchain.checkpoints.genesis = nil
+	newchain.checkpoints.SetScheduler(func(*types.Header) ([]*types.CheckpointProducer, error) { return schedule, nil })
+	forged := types.CopyHeader(headers[n])
+	forged.Extra = append([]byte{}, forged.Extra...)
+	forged.Extra[len(forged.Extra)-2] ^= 1
+	if _, err := newchain.InsertHeaderChain([]*types.Header{forged}); err == nil {
+		t.Fatal("header with forged seal inserted")
+	}
+	if _, err := newchain.InsertHeaderChain(headers); err != nil {
+		t.Fatal(err)
+	}
+	if newchain.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", newchain.CurrentBlock().Hash(), head.Hash())
+	}
+	if td, want := newchain.GetTd(head.Hash(), head.NumberU64()), chain.GetTd(head.Hash(), head.NumberU64()); td.Cmp(want) != 0 {
+		t.Fatalf("td mismatch: have %v, want %v", td, want)
+	}
+	if !newchain.HasBlock(head.Hash(), head.NumberU64()) || newchain.GetBlock(head.Hash(), head.NumberU64()) != nil {
+		t.Fatal("header-only chain stores a body")
+	}
+
+	// The head never moves to a branch conflicting with the latest checkpoint.
+	checkpoint := headers[len(headers)/2]
+	latest := &types.SignedCheckpoint{Checkpoint: types.Checkpoint{Number: checkpoint.Number.Uint64(), Hash: checkpoint.Hash()}}
+	below := types.CopyHeader(headers[len(headers)/2-2])
+	if err := newchain.writeHeaderHead(newdb.NewBatch(), below, latest); err != errCheckpointMismatch {
+		t.Fatalf("head moved below the checkpoint: %v", err)
+	}
+	fork := types.CopyHeader(checkpoint)
+	fork.Number = new(big.Int).Add(checkpoint.Number, common.Big1)
+	fork.ParentHash = common.HexToHash("0x01")
+	if err := newchain.writeHeaderHead(newdb.NewBatch(), fork, latest); err != errCheckpointMismatch {
+		t.Fatalf("head moved to a branch conflicting with the checkpoint: %v", err)
+	}
+
+	// The downloader can only light sync a header-only chain.
+	dl := newchain.Downloader()
+	if dl.Mode() != LightSync {
+		t.Fatalf("mode mismatch: have %v, want %v", dl.Mode(), LightSync)
+	}
+	if err := dl.SetMode(FullSync); err == nil {
+		t.Fatal("full sync enabled")
+	}
+	if _, err := NewBlockChain(newdb, nil, vm.Config{}, chain.Config(), txpool.SenderCacher); err == nil {
+		t.Fatal("header-only database opened as a full chain")
+	}
+
+	// Bodies are fetched from the remotes having the block, and cached.
+	var block *types.Block
+	for _, header := range headers {
+		if b := chain.GetBlock(header.Hash(), header.Number.Uint64()); len(b.Transactions()) > 0 {
+			block = b
+			break
+		}
+	}
+	if block == nil {
+		t.Fatal("no block with transactions")
+	}
+	if _, err := newchain.FetchBlock(block.Hash(), block.NumberU64()); err != errBodyUnavailable {
+		t.Fatalf("body fetched without remotes: %v", err)
+	}
+	remote := router.NewLocalStation("bodyremote", nil)
+	ch := make(chan *router.Event, 1)
+	sub := router.Subscribe(remote, ch, router.DownloaderGetBlockBodiesMsg, []common.Hash{})
+	defer sub.Unsubscribe()
+	go func() {
+		e := <-ch
+		hashes := e.Data.([]common.Hash)
+		router.SendTo(remote, e.From, router.BlockBodiesMsg, []*types.Body{chain.GetBody(hashes[0])})
+	}()
+	dl.setStationStatus(newStationStatus(remote, chain.GetTd(head.Hash(), head.NumberU64()), head.NumberU64(), head.Hash()))
+	for i := 0; i < 2; i++ {
+		fetched, err := newchain.FetchBlock(block.Hash(), block.NumberU64())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if types.DeriveTxMerkleRoot(fetched.Transactions()) != block.Header().TxsRoot || fetched.Hash() != block.Hash() {
+			t.Fatal("fetched block mismatch")
+		}
+	}
+}
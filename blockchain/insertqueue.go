@@ -0,0 +1,120 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"errors"
+
+	"github.com/fractalplatform/fractal/utils/supervisor"
+)
+
+const insertQueueSize = 4 // Maximum downloaded tasks waiting for the inserter
+
+// errInsertPanicked is returned by the inserter stopped by a panicking
+// insertion.
+var errInsertPanicked = errors.New("block insertion panicked")
+
+// insertQueue hands the downloaded tasks over to the inserter in block order,
+// holding back those which arrive ahead of their predecessors. The queue to
+// the inserter is bounded, so fetching stalls once it is that far ahead.
+type insertQueue struct {
+	starts  []uint64                 // start numbers of the tasks in block order
+	next    int                      // index of the next task to hand over
+	pending map[uint64]*downloadTask // fetched tasks waiting for their predecessors
+	tasks   chan *downloadTask
+	done    chan struct{} // closed once the inserter stops
+
+	inserted uint64 // number of the last block inserted in order
+	err      error  // insertion error which stopped the inserter
+}
+
+func newInsertQueue(starts []uint64) *insertQueue {
+	return &insertQueue{
+		starts:   starts,
+		pending:  make(map[uint64]*downloadTask),
+		tasks:    make(chan *downloadTask, insertQueueSize),
+		done:     make(chan struct{}),
+		inserted: starts[0] - 1,
+	}
+}
+
+// run inserts the queued tasks until the queue is closed or an insertion
+// fails, insert returns the index of the failed block on error. A panicking
+// insertion is recovered by the supervisor and stops the inserter with
+// errInsertPanicked, as does closing quit.
+func (q *insertQueue) run(quit <-chan struct{}, insert func(*downloadTask) (int, error)) {
+	defer close(q.done)
+	var current *downloadTask // task being inserted
+	supervisor.Run("downloader insert", quit, func() {
+		if current != nil {
+			return
+		}
+		for task := range q.tasks {
+			current = task
+			if index, err := insert(task); err != nil {
+				q.inserted, q.err = task.blocks[index].NumberU64()-1, err
+				return
+			}
+			q.inserted = task.endNumber
+			current = nil
+		}
+	})
+	if current != nil && q.err == nil {
+		q.err = errInsertPanicked
+	}
+}
+
+// push queues a fetched task, handing over every task which is next in order.
+// It reports false if the inserter has stopped.
+func (q *insertQueue) push(task *downloadTask) bool {
+	if q.stopped() {
+		return false
+	}
+	q.pending[task.startNumber] = task
+	for q.next < len(q.starts) {
+		task, ok := q.pending[q.starts[q.next]]
+		if !ok {
+			break
+		}
+		select {
+		case q.tasks <- task:
+		case <-q.done:
+			return false
+		}
+		delete(q.pending, q.starts[q.next])
+		q.next++
+	}
+	return true
+}
+
+// stopped reports whether the inserter has stopped.
+func (q *insertQueue) stopped() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait closes the queue and waits for the inserter to finish, returning the
+// number of the last block inserted in order and the insertion error if any.
+func (q *insertQueue) wait() (uint64, error) {
+	close(q.tasks)
+	<-q.done
+	return q.inserted, q.err
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/types"
+)
+
+func newQueueTask(start, end uint64) *downloadTask {
+	task := &downloadTask{startNumber: start, endNumber: end}
+	for n := start; n <= end; n++ {
+		task.blocks = append(task.blocks, types.NewBlockWithHeader(&types.Header{Number: new(big.Int).SetUint64(n)}))
+	}
+	return task
+}
+
+func TestInsertQueueOrder(t *testing.T) {
+	queue := newInsertQueue([]uint64{1, 11, 21, 31})
+	var order []uint64
+	go queue.run(nil, func(task *downloadTask) (int, error) {
+		order = append(order, task.startNumber)
+		return 0, nil
+	})
+	for _, start := range []uint64{31, 11, 1, 21} {
+		if !queue.push(newQueueTask(start, start+10)) {
+			t.Fatalf("inserter stopped at task %d", start)
+		}
+	}
+	inserted, err := queue.wait()
+	if err != nil || inserted != 41 {
+		t.Fatalf("have (%d, %v), want (41, nil)", inserted, err)
+	}
+	want := []uint64{1, 11, 21, 31}
+	if len(order) != len(want) {
+		t.Fatalf("insertion order mismatch: have %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("insertion order mismatch: have %v, want %v", order, want)
+		}
+	}
+}
+
+func TestInsertQueueMissingTask(t *testing.T) {
+	queue := newInsertQueue([]uint64{1, 11, 21})
+	go queue.run(nil, func(task *downloadTask) (int, error) { return 0, nil })
+	queue.push(newQueueTask(1, 11))
+	queue.push(newQueueTask(21, 31))
+	if inserted, err := queue.wait(); err != nil || inserted != 11 {
+		t.Fatalf("have (%d, %v), want (11, nil)", inserted, err)
+	}
+}
+
+func TestInsertQueueError(t *testing.T) {
+	errInsert := errors.New("insert failed")
+	queue := newInsertQueue([]uint64{1, 11, 21})
+	go queue.run(nil, func(task *downloadTask) (int, error) {
+		if task.startNumber == 11 {
+			return 3, errInsert
+		}
+		return 0, nil
+	})
+	queue.push(newQueueTask(1, 11))
+	queue.push(newQueueTask(11, 21))
+	<-queue.done
+	if !queue.stopped() {
+		t.Fatal("inserter not stopped")
+	}
+	if queue.push(newQueueTask(21, 31)) {
+		t.Fatal("task queued after the inserter stopped")
+	}
+	if inserted, err := queue.wait(); err != errInsert || inserted != 13 {
+		t.Fatalf("have (%d, %v), want (13, %v)", inserted, err, errInsert)
+	}
+}
+
+func TestInsertQueuePanic(t *testing.T) {
+	queue := newInsertQueue([]uint64{1, 11, 21})
+	go queue.run(nil, func(task *downloadTask) (int, error) {
+		if task.startNumber == 11 {
+			panic("insert panicked")
+		}
+		return 0, nil
+	})
+	queue.push(newQueueTask(1, 11))
+	queue.push(newQueueTask(11, 21))
+	<-queue.done
+	if queue.push(newQueueTask(21, 31)) {
+		t.Fatal("task queued after the inserter stopped")
+	}
+	if inserted, err := queue.wait(); err != errInsertPanicked || inserted != 11 {
+		t.Fatalf("have (%d, %v), want (11, %v)", inserted, err, errInsertPanicked)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fractalplatform/fractal/params"
+)
+
+// Network is a named network preset.
+type Network struct {
+	Name      string
+	Genesis   func() *Genesis // returns a new genesis specification
+	BootNodes []string        // enode URLs of the bootstrap nodes
+}
+
+var networks = map[string]*Network{
+	"mainnet": {"mainnet", DefaultGenesis, params.MainnetBootnodes},
+	"testnet": {"testnet", DefaultTestnetGenesis, params.TestnetBootnodes},
+	"dev":     {"dev", DefaultDevGenesis, nil},
+}
+
+// NetworkNames returns the names of the network presets in order.
+func NetworkNames() []string {
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupNetwork returns the network preset with the given name.
+func LookupNetwork(name string) (*Network, error) {
+	network, ok := networks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q, want one of %s", name, strings.Join(NetworkNames(), ", "))
+	}
+	return network, nil
+}
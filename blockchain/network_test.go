@@ -0,0 +1,51 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestNetworks(t *testing.T) {
+	hashes := make(map[common.Hash]string)
+	chainIDs := make(map[uint64]string)
+	for _, name := range NetworkNames() {
+		network, err := LookupNetwork(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		genesis := network.Genesis()
+		hash := genesis.ToBlock(nil).Hash()
+		if other, ok := hashes[hash]; ok {
+			t.Errorf("%s and %s share genesis block %x", name, other, hash)
+		}
+		hashes[hash] = name
+		chainID := genesis.Config.ChainID.Uint64()
+		if other, ok := chainIDs[chainID]; ok {
+			t.Errorf("%s and %s share chain id %d", name, other, chainID)
+		}
+		chainIDs[chainID] = name
+	}
+	if name := hashes[defaultgenesisBlockHash]; name != "mainnet" {
+		t.Errorf("default genesis block is %q, want mainnet", name)
+	}
+	if _, err := LookupNetwork("nonet"); err == nil {
+		t.Error("unknown network found")
+	}
+}
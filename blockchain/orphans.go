@@ -0,0 +1,40 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"github.com/fractalplatform/fractal/metrics"
+)
+
+var (
+	blockInsertMeter = metrics.NewRegisteredMeter("blockchain/blocks/inserted", nil)
+	blockOrphanMeter = metrics.NewRegisteredMeter("blockchain/blocks/orphaned", nil)
+	blockReorgMeter  = metrics.NewRegisteredMeter("blockchain/blocks/reorged", nil)
+	blockOrphanGauge = metrics.NewRegisteredGaugeFloat64("blockchain/blocks/orphanrate", nil)
+)
+
+// markBlocks counts the blocks written to the database, with or without their
+// state, and the ones of them written on a side chain, and updates the share
+// of the written blocks that lost the race for their number when they arrived.
+// Canonical blocks dropped by reorgs are counted apart by blockReorgMeter.
+func markBlocks(inserted, orphaned int) {
+	blockInsertMeter.Mark(int64(inserted))
+	blockOrphanMeter.Mark(int64(orphaned))
+	if total := blockInsertMeter.Count(); total > 0 {
+		blockOrphanGauge.Update(float64(blockOrphanMeter.Count()) / float64(total))
+	}
+}
@@ -17,16 +17,28 @@
 package blockchain
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 
 	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
 	"github.com/fractalplatform/fractal/types"
 	"github.com/fractalplatform/fractal/utils/rlp"
 )
 
-const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
+const (
+	ProtocolVersion    = 3                // Version of the chain sync protocol, exchanged in the status message
+	minProtocolVersion = 2                // Oldest version of the chain sync protocol spoken with remotes
+	ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
+
+	// capabilitiesVersion is the protocol version introducing the capabilities
+	// in the status message, the transaction chunks and the signed
+	// checkpoints. Remotes speaking an older version serve the blocks, their
+	// state changes and the state, and are never sent the newer messages.
+	capabilitiesVersion = 3
+)
 
 type errCode int
 
@@ -40,6 +52,7 @@ const (
 	ErrNoStatusMsg
 	ErrExtraStatusMsg
 	ErrSuspendedPeer
+	ErrForkIDRejected
 )
 
 func (e errCode) String() string {
@@ -57,8 +70,18 @@ var errorToString = map[int]string{
 	ErrNoStatusMsg:             "No status message",
 	ErrExtraStatusMsg:          "Extra status message",
 	ErrSuspendedPeer:           "Suspended peer",
+	ErrForkIDRejected:          "Fork ID rejected",
 }
 
+// Capabilities of a node, advertised in its status message. The downloader
+// only sends a remote the requests it is able to serve.
+const (
+	CapBlockStates uint64 = 1 << iota // serves the state changes and receipts of blocks
+	CapState                          // serves the keys and values of its state
+	CapLightOnly                      // stores the headers only, serves no bodies
+	CapTxChunks                       // serves the transactions of a block by chunks
+)
+
 // statusData is the network packet for the status message.
 type statusData struct {
 	ProtocolVersion uint32
@@ -67,6 +90,61 @@ type statusData struct {
 	CurrentBlock    common.Hash
 	CurrentNumber   uint64
 	TD              *big.Int
+	ForkID          forkID
+	Capabilities    uint64
+}
+
+// legacyStatusData is the status message of the protocol versions before
+// capabilitiesVersion.
+type legacyStatusData struct {
+	ProtocolVersion uint32
+	NetworkId       uint64
+	GenesisBlock    common.Hash
+	CurrentBlock    common.Hash
+	CurrentNumber   uint64
+	TD              *big.Int
+	ForkID          forkID
+}
+
+// legacyStatus returns the status sent to remotes speaking a protocol version
+// before capabilitiesVersion, announcing the newest version they speak.
+func legacyStatus(status *statusData) *legacyStatusData {
+	return &legacyStatusData{
+		ProtocolVersion: capabilitiesVersion - 1,
+		NetworkId:       status.NetworkId,
+		GenesisBlock:    status.GenesisBlock,
+		CurrentBlock:    status.CurrentBlock,
+		CurrentNumber:   status.CurrentNumber,
+		TD:              status.TD,
+		ForkID:          status.ForkID,
+	}
+}
+
+// upgradeStatus converts the status of a remote speaking a protocol version
+// before capabilitiesVersion, which advertises no capabilities.
+func upgradeStatus(data interface{}) interface{} {
+	legacy := data.(*legacyStatusData)
+	return &statusData{
+		ProtocolVersion: legacy.ProtocolVersion,
+		NetworkId:       legacy.NetworkId,
+		GenesisBlock:    legacy.GenesisBlock,
+		CurrentBlock:    legacy.CurrentBlock,
+		CurrentNumber:   legacy.CurrentNumber,
+		TD:              legacy.TD,
+		ForkID:          legacy.ForkID,
+	}
+}
+
+// negotiateVersion returns the protocol version spoken with a remote, the
+// older of both versions, or false if the remote is too old.
+func negotiateVersion(local, remote uint32) (uint32, bool) {
+	if remote < minProtocolVersion {
+		return 0, false
+	}
+	if remote < local {
+		return remote, true
+	}
+	return local, true
 }
 
 // Number = 0, Amount = 4
@@ -137,6 +215,60 @@ type newBlockData struct {
 	TD    *big.Int
 }
 
+// blockStateData is the network packet for the state changes and receipts of
+// a block, fast sync applies them instead of executing the block.
+type blockStateData struct {
+	Changes  []*types.OptInfo
+	Receipts []*types.Receipt
+}
+
+// getStateHashesData is the network packet for requesting the keys of the
+// state at a block with the hashes of their values, in key order from Origin.
+type getStateHashesData struct {
+	Block  common.Hash
+	Origin []byte
+	Amount uint64
+}
+
+// stateHashData is a key of the state with the keccak256 hash of its value.
+type stateHashData struct {
+	Key  []byte
+	Hash common.Hash
+}
+
+// stateHashesData is the network packet for the keys and value hashes of a
+// state. No keys without Complete set means the state isn't available.
+type stateHashesData struct {
+	Hashes   []*stateHashData
+	Complete bool // the keys reach the last key of the state
+}
+
+// getStateItemsData is the network packet for requesting the values of keys
+// of the state at a block.
+type getStateItemsData struct {
+	Block common.Hash
+	Keys  [][]byte
+}
+
+// getBlockTxChunkData is the network packet for requesting a chunk of the
+// transactions of a block split in Chunks chunks, or the parity of the chunks
+// if Index is Chunks.
+type getBlockTxChunkData struct {
+	Block  common.Hash
+	Chunks uint64
+	Index  uint64
+}
+
+// blockTxChunkData is the network packet for a chunk of the transactions of a
+// block. Data holds the RLP encoded transactions of the chunk, or for the
+// parity the byte-wise XOR of all the chunks padded to the longest one, with
+// the Lengths of the chunks.
+type blockTxChunkData struct {
+	Index   uint64
+	Data    []byte
+	Lengths []uint64
+}
+
 // blockBody represents the data content of a single block.
 type blockBody struct {
 	Transactions []*types.Transaction // Transactions contained within a block
@@ -144,3 +276,60 @@ type blockBody struct {
 
 // blockBodiesData is the network packet for block content distribution.
 type blockBodiesData []*blockBody
+
+func init() {
+	router.RegisterPayload(router.DownloaderGetStatus, 0, "", nil)
+	router.RegisterPayload(router.DownloaderStatusMsg, 1, &statusData{}, nil)
+	router.RegisterLegacyPayload(router.DownloaderStatusMsg, 0, &legacyStatusData{}, upgradeStatus)
+	router.RegisterPayload(router.DownloaderGetBlockHashMsg, 0, &getBlcokHashByNumber{}, nil)
+	router.RegisterPayload(router.DownloaderGetBlockHeadersMsg, 0, &getBlockHeadersData{}, nil)
+	router.RegisterPayload(router.DownloaderGetBlockBodiesMsg, 0, []common.Hash{}, nil)
+	router.RegisterPayload(router.BlockHashMsg, 0, []common.Hash{}, nil)
+	router.RegisterPayload(router.BlockHeadersMsg, 0, []*types.Header{}, nil)
+	router.RegisterPayload(router.BlockBodiesMsg, 0, []*types.Body{}, nil)
+	router.RegisterPayload(router.NewBlockHashesMsg, 0, &NewBlockHashesData{}, nil)
+	router.RegisterPayload(router.NewBlockMsg, 0, &newBlockData{}, validateNewBlock)
+	router.RegisterPayload(router.DownloaderGetBlockStatesMsg, 0, []common.Hash{}, nil)
+	router.RegisterPayload(router.BlockStatesMsg, 0, []*blockStateData{}, nil)
+	router.RegisterPayload(router.DownloaderGetStateHashesMsg, 0, &getStateHashesData{}, nil)
+	router.RegisterPayload(router.StateHashesMsg, 0, &stateHashesData{}, nil)
+	router.RegisterPayload(router.DownloaderGetStateItemsMsg, 0, &getStateItemsData{}, nil)
+	router.RegisterPayload(router.StateItemsMsg, 0, [][]byte{}, nil)
+	router.RegisterPayload(router.DownloaderGetBlockTxHashesMsg, 0, common.Hash{}, nil)
+	router.RegisterPayload(router.BlockTxHashesMsg, 0, []common.Hash{}, nil)
+	router.RegisterPayload(router.DownloaderGetBlockTxChunkMsg, 0, &getBlockTxChunkData{}, nil)
+	router.RegisterPayload(router.BlockTxChunkMsg, 0, &blockTxChunkData{}, nil)
+	router.RegisterPayload(router.CheckpointVoteMsg, 0, &types.CheckpointVote{}, validateCheckpointVote)
+	router.RegisterPayload(router.GetCheckpointMsg, 0, uint64(0), nil)
+	router.RegisterPayload(router.CheckpointMsg, 0, &types.SignedCheckpoint{}, validateCheckpoint)
+}
+
+// validateNewBlock checks that a propagated block carries a header.
+func validateNewBlock(data interface{}) error {
+	if block := data.(*newBlockData).Block; block == nil || block.Head == nil {
+		return errors.New("block without header")
+	}
+	return nil
+}
+
+// validateCheckpoint checks that a served checkpoint carries a sane number of
+// signatures and scheduled producers.
+func validateCheckpoint(data interface{}) error {
+	sc := data.(*types.SignedCheckpoint)
+	if n := len(sc.Signatures); n == 0 || n > maxCheckpointSignatures {
+		return fmt.Errorf("checkpoint with %d signatures", n)
+	}
+	if n := len(sc.Schedule); n == 0 || n > maxCheckpointSchedule {
+		return fmt.Errorf("checkpoint with %d scheduled producers", n)
+	}
+	return nil
+}
+
+// validateCheckpointVote checks that a relayed vote carries a sane number of
+// scheduled producers.
+func validateCheckpointVote(data interface{}) error {
+	if n := len(data.(*types.CheckpointVote).Schedule); n == 0 || n > maxCheckpointSchedule {
+		return fmt.Errorf("checkpoint vote with %d scheduled producers", n)
+	}
+	return nil
+}
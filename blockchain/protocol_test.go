@@ -0,0 +1,72 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// fuzzedPayloads are the typecodes of the payloads announcing and serving
+// blocks, which are decoded from any remote.
+var fuzzedPayloads = []int{
+	router.NewBlockHashesMsg,
+	router.BlockHeadersMsg,
+	router.BlockBodiesMsg,
+	router.NewBlockMsg,
+}
+
+func FuzzDecodePayload(f *testing.F) {
+	header := &types.Header{
+		ParentHash: common.HexToHash("0x01"),
+		Coinbase:   common.Name("coinbase"),
+		Difficulty: big.NewInt(1),
+		Number:     big.NewInt(1),
+		GasLimit:   1000000,
+		Time:       big.NewInt(1),
+		Extra:      []byte("fuzz"),
+	}
+	tx := types.NewTransaction(0, big.NewInt(1), types.NewAction(types.Transfer, common.Name("from"), common.Name("to"), 0, 0, 21000, big.NewInt(1), nil))
+	block := &types.Block{Head: header, Txs: []*types.Transaction{tx}}
+	seeds := map[int]interface{}{
+		router.NewBlockHashesMsg: &NewBlockHashesData{Hash: header.Hash(), Number: 1, TD: big.NewInt(1)},
+		router.BlockHeadersMsg:   []*types.Header{header},
+		router.BlockBodiesMsg:    []*types.Body{{Transactions: block.Txs}},
+		router.NewBlockMsg:       &newBlockData{Block: block, TD: big.NewInt(1)},
+	}
+	for i, typecode := range fuzzedPayloads {
+		payload, err := router.EncodePayload(typecode, seeds[typecode])
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(i), payload)
+	}
+	f.Fuzz(func(t *testing.T, index uint8, payload []byte) {
+		typecode := fuzzedPayloads[int(index)%len(fuzzedPayloads)]
+		data, err := router.DecodePayload(typecode, router.PayloadVersion(typecode), payload)
+		if err != nil {
+			return
+		}
+		if _, err := router.EncodePayload(typecode, data); err != nil {
+			t.Fatalf("failed to encode decoded payload %d: %v", typecode, err)
+		}
+	})
+}
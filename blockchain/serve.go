@@ -0,0 +1,289 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"errors"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/metrics"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+const (
+	maxHashServe      = 512             // Amount of block hashes to be served per request
+	maxHeaderServe    = 192             // Amount of block headers to be served per request
+	maxBodyServe      = 128             // Amount of block bodies to be served per request
+	maxStateServe     = 128             // Amount of block states to be served per request
+	maxStateHashServe = 1024            // Amount of state key hashes to be served per request
+	maxStateItemServe = 256             // Amount of state values to be served per request
+	softResponseLimit = 2 * 1024 * 1024 // Target maximum size of returned bodies and states
+)
+
+var (
+	serveHashReqMeter   = metrics.NewRegisteredMeter("blockchain/serve/hashes/requests", nil)
+	serveHashOutMeter   = metrics.NewRegisteredMeter("blockchain/serve/hashes/out", nil)
+	serveHeaderReqMeter = metrics.NewRegisteredMeter("blockchain/serve/headers/requests", nil)
+	serveHeaderOutMeter = metrics.NewRegisteredMeter("blockchain/serve/headers/out", nil)
+	serveBodyReqMeter   = metrics.NewRegisteredMeter("blockchain/serve/bodies/requests", nil)
+	serveBodyOutMeter   = metrics.NewRegisteredMeter("blockchain/serve/bodies/out", nil)
+	serveStateReqMeter  = metrics.NewRegisteredMeter("blockchain/serve/states/requests", nil)
+	serveStateOutMeter  = metrics.NewRegisteredMeter("blockchain/serve/states/out", nil)
+	serveCappedMeter    = metrics.NewRegisteredMeter("blockchain/serve/capped", nil)
+)
+
+// nextServeNumber advances number by skip+1 blocks in the query direction,
+// reporting false once the traversal would run past genesis or overflow.
+func nextServeNumber(number, skip uint64, reverse bool) (uint64, bool) {
+	if reverse {
+		if number < skip+1 {
+			return 0, false
+		}
+		return number - skip - 1, true
+	}
+	next := number + skip + 1
+	if next <= number {
+		return 0, false
+	}
+	return next, true
+}
+
+// capServeAmount limits a requested amount to the serving cap.
+func capServeAmount(amount, limit uint64) uint64 {
+	if amount > limit {
+		serveCappedMeter.Mark(1)
+		return limit
+	}
+	return amount
+}
+
+// serveBlockHashes collects the canonical hashes requested by query, see
+// getBlcokHashByNumber for the skip and reverse semantics.
+func serveBlockHashes(bc *BlockChain, query *getBlcokHashByNumber) []common.Hash {
+	serveHashReqMeter.Mark(1)
+	amount := capServeAmount(query.Amount, maxHashServe)
+	hashes := make([]common.Hash, 0, amount)
+	for number, ok := query.Number, true; ok && uint64(len(hashes)) < amount; {
+		header := bc.GetHeaderByNumber(number)
+		if header == nil {
+			break
+		}
+		hashes = append(hashes, header.Hash())
+		number, ok = nextServeNumber(number, query.Skip, query.Reverse)
+	}
+	serveHashOutMeter.Mark(int64(len(hashes)))
+	return hashes
+}
+
+// serveBlockHeaders collects the canonical headers requested by query,
+// starting from the origin hash when one is given.
+func serveBlockHeaders(bc *BlockChain, query *getBlockHeadersData) []*types.Header {
+	serveHeaderReqMeter.Mark(1)
+	number := query.Origin.Number
+	if query.Origin.Hash != (common.Hash{}) {
+		header := bc.GetHeaderByHash(query.Origin.Hash)
+		if header == nil {
+			return []*types.Header{}
+		}
+		number = header.Number.Uint64()
+	}
+	amount := capServeAmount(query.Amount, maxHeaderServe)
+	headers := make([]*types.Header, 0, amount)
+	for ok := true; ok && uint64(len(headers)) < amount; {
+		header := bc.GetHeaderByNumber(number)
+		if header == nil {
+			break
+		}
+		headers = append(headers, header)
+		number, ok = nextServeNumber(number, query.Skip, query.Reverse)
+	}
+	serveHeaderOutMeter.Mark(int64(len(headers)))
+	return headers
+}
+
+// serveBlockBodies collects the bodies of the given blocks, stopping at the
+// first unknown block or once the reply grows past softResponseLimit.
+func serveBlockBodies(bc *BlockChain, hashes []common.Hash) []*types.Body {
+	serveBodyReqMeter.Mark(1)
+	if uint64(len(hashes)) > maxBodyServe {
+		serveCappedMeter.Mark(1)
+		hashes = hashes[:maxBodyServe]
+	}
+	bodies := make([]*types.Body, 0, len(hashes))
+	size := 0
+	for _, hash := range hashes {
+		if size >= softResponseLimit {
+			serveCappedMeter.Mark(1)
+			break
+		}
+		body := bc.GetBody(hash)
+		if body == nil {
+			break
+		}
+		bodies = append(bodies, body)
+		size += len(bc.GetBodyRLP(hash))
+	}
+	serveBodyOutMeter.Mark(int64(len(bodies)))
+	return bodies
+}
+
+// serveBlockStates collects the state changes and receipts of the given
+// blocks, stopping at the first block without them or once the reply grows
+// past softResponseLimit.
+func serveBlockStates(bc *BlockChain, hashes []common.Hash) []*blockStateData {
+	serveStateReqMeter.Mark(1)
+	if uint64(len(hashes)) > maxStateServe {
+		serveCappedMeter.Mark(1)
+		hashes = hashes[:maxStateServe]
+	}
+	states := make([]*blockStateData, 0, len(hashes))
+	size := 0
+	for _, hash := range hashes {
+		if size >= softResponseLimit {
+			serveCappedMeter.Mark(1)
+			break
+		}
+		number := rawdb.ReadHeaderNumber(bc.db, hash)
+		if number == nil {
+			break
+		}
+		stateOut := rawdb.ReadBlockStateOut(bc.db, hash)
+		if stateOut == nil {
+			break
+		}
+		data := &blockStateData{
+			Changes:  stateOut.Changes,
+			Receipts: rawdb.ReadReceipts(bc.db, hash, *number),
+		}
+		states = append(states, data)
+		if enc, err := rlp.EncodeToBytes(data); err == nil {
+			size += len(enc)
+		}
+	}
+	serveStateOutMeter.Mark(int64(len(states)))
+	return states
+}
+
+var (
+	serveStateHashReqMeter = metrics.NewRegisteredMeter("blockchain/serve/statehashes/requests", nil)
+	serveStateHashOutMeter = metrics.NewRegisteredMeter("blockchain/serve/statehashes/out", nil)
+	serveStateItemReqMeter = metrics.NewRegisteredMeter("blockchain/serve/stateitems/requests", nil)
+	serveStateItemOutMeter = metrics.NewRegisteredMeter("blockchain/serve/stateitems/out", nil)
+
+	// errServeDone stops the iteration over the state once a reply is complete.
+	errServeDone = errors.New("serve done")
+)
+
+// serveStateHashes collects the keys of the state at the requested block with
+// the hashes of their values, in key order from the origin. Nothing is served
+// if the state at the block isn't available.
+func serveStateHashes(bc *BlockChain, query *getStateHashesData) *stateHashesData {
+	serveStateHashReqMeter.Mark(1)
+	amount := capServeAmount(query.Amount, maxStateHashServe)
+	hashes := make([]*stateHashData, 0, amount)
+
+	bc.stateCache.RLock()
+	defer bc.stateCache.RUnLock()
+	err := state.ForEachStateItemFrom(bc.db, query.Block, query.Origin, func(key, value []byte) error {
+		if uint64(len(hashes)) >= amount {
+			return errServeDone
+		}
+		hashes = append(hashes, &stateHashData{Key: common.CopyBytes(key), Hash: crypto.Keccak256Hash(value)})
+		return nil
+	})
+	if err != nil && err != errServeDone {
+		return &stateHashesData{Hashes: []*stateHashData{}}
+	}
+	serveStateHashOutMeter.Mark(int64(len(hashes)))
+	return &stateHashesData{Hashes: hashes, Complete: err == nil}
+}
+
+// serveStateItems collects the values of the requested keys of the state at
+// the block, empty for the keys which don't exist. The reply is cut short once
+// it grows past softResponseLimit.
+func serveStateItems(bc *BlockChain, query *getStateItemsData) [][]byte {
+	serveStateItemReqMeter.Mark(1)
+	keys := query.Keys
+	if uint64(len(keys)) > maxStateItemServe {
+		serveCappedMeter.Mark(1)
+		keys = keys[:maxStateItemServe]
+	}
+
+	bc.stateCache.RLock()
+	values, err := state.ReadStateItems(bc.db, query.Block, keys)
+	bc.stateCache.RUnLock()
+	if err != nil {
+		return [][]byte{}
+	}
+	size := 0
+	for i, value := range values {
+		if size >= softResponseLimit {
+			serveCappedMeter.Mark(1)
+			values = values[:i]
+			break
+		}
+		size += len(keys[i]) + len(value)
+	}
+	serveStateItemOutMeter.Mark(int64(len(values)))
+	return values
+}
+
+var (
+	serveTxChunkReqMeter = metrics.NewRegisteredMeter("blockchain/serve/txchunks/requests", nil)
+	serveTxChunkOutMeter = metrics.NewRegisteredMeter("blockchain/serve/txchunks/out", nil)
+)
+
+// serveBlockTxHashes lists the hashes of the transactions of the block, empty
+// if the block is unknown.
+func serveBlockTxHashes(bc *BlockChain, hash common.Hash) []common.Hash {
+	body := bc.GetBody(hash)
+	if body == nil {
+		return []common.Hash{}
+	}
+	hashes := make([]common.Hash, len(body.Transactions))
+	for i, tx := range body.Transactions {
+		hashes[i] = tx.Hash()
+	}
+	return hashes
+}
+
+// serveBlockTxChunk encodes the requested chunk of the transactions of the
+// block, or the parity of the chunks. The reply has no data if the block is
+// unknown or the chunk out of range.
+func serveBlockTxChunk(bc *BlockChain, query *getBlockTxChunkData) *blockTxChunkData {
+	serveTxChunkReqMeter.Mark(1)
+	reply := &blockTxChunkData{Index: query.Index}
+	body := bc.GetBody(query.Block)
+	if body == nil || query.Chunks == 0 || query.Chunks > maxTxChunks || query.Index > query.Chunks {
+		return reply
+	}
+	chunks, err := encodeTxChunks(body.Transactions, int(query.Chunks))
+	if err != nil {
+		return reply
+	}
+	if query.Index < query.Chunks {
+		reply.Data = chunks[query.Index]
+	} else {
+		reply.Data, reply.Lengths = txChunksParity(chunks)
+	}
+	serveTxChunkOutMeter.Mark(1)
+	return reply
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestServe(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+	head := chain.CurrentBlock().NumberU64()
+
+	hashTests := []struct {
+		query *getBlcokHashByNumber
+		want  []uint64
+	}{
+		{&getBlcokHashByNumber{0, 4, 0, false}, []uint64{0, 1, 2, 3}},
+		{&getBlcokHashByNumber{0, 4, 2, false}, []uint64{0, 3, 6, 9}},
+		{&getBlcokHashByNumber{9, 4, 1, true}, []uint64{9, 7, 5, 3}},
+		{&getBlcokHashByNumber{9, 4, 3, true}, []uint64{9, 5, 1}},
+		{&getBlcokHashByNumber{head - 1, 4, 0, false}, []uint64{head - 1, head}},
+		{&getBlcokHashByNumber{head + 1, 4, 0, false}, nil},
+		{&getBlcokHashByNumber{0, 2, ^uint64(0), false}, []uint64{0}},
+	}
+	for i, tt := range hashTests {
+		hashes := serveBlockHashes(chain, tt.query)
+		if len(hashes) != len(tt.want) {
+			t.Fatalf("hash test %d: got %d hashes, want %d", i, len(hashes), len(tt.want))
+		}
+		for j, number := range tt.want {
+			if hashes[j] != chain.GetHeaderByNumber(number).Hash() {
+				t.Errorf("hash test %d: hash %d mismatch", i, j)
+			}
+		}
+	}
+	if hashes := serveBlockHashes(chain, &getBlcokHashByNumber{0, maxHashServe + 1, 0, false}); uint64(len(hashes)) > maxHashServe {
+		t.Errorf("served %d hashes, cap is %d", len(hashes), maxHashServe)
+	}
+
+	origin := blocks[4]
+	headers := serveBlockHeaders(chain, &getBlockHeadersData{hashOrNumber{Hash: origin.Hash()}, 3, 0, true})
+	if len(headers) != 3 {
+		t.Fatalf("got %d headers, want 3", len(headers))
+	}
+	for i, header := range headers {
+		if header.Number.Uint64() != origin.NumberU64()-uint64(i) {
+			t.Errorf("header %d: number %d", i, header.Number.Uint64())
+		}
+	}
+	if headers := serveBlockHeaders(chain, &getBlockHeadersData{hashOrNumber{Hash: common.Hash{1}}, 3, 0, false}); len(headers) != 0 {
+		t.Errorf("served %d headers for an unknown origin", len(headers))
+	}
+	if headers := serveBlockHeaders(chain, &getBlockHeadersData{hashOrNumber{Number: 0}, maxHeaderServe + 1, 0, false}); uint64(len(headers)) > maxHeaderServe {
+		t.Errorf("served %d headers, cap is %d", len(headers), maxHeaderServe)
+	}
+
+	hashes := []common.Hash{blocks[0].Hash(), blocks[1].Hash(), {1}, blocks[2].Hash()}
+	bodies := serveBlockBodies(chain, hashes)
+	if len(bodies) != 2 {
+		t.Fatalf("got %d bodies, want 2", len(bodies))
+	}
+	for i, body := range bodies {
+		txs := blocks[i].Transactions()
+		if len(body.Transactions) != len(txs) {
+			t.Fatalf("body %d: got %d txs, want %d", i, len(body.Transactions), len(txs))
+		}
+		for j, tx := range body.Transactions {
+			if tx.Hash() != txs[j].Hash() {
+				t.Errorf("body %d: tx %d mismatch", i, j)
+			}
+		}
+	}
+}
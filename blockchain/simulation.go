@@ -0,0 +1,544 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+// simStep is the longest virtual time advanced at once while a simulation
+// runs, and simYield the real time the nodes are given to process the timers
+// expired by each step.
+const (
+	simStep  = 10 * time.Millisecond
+	simYield = time.Millisecond
+)
+
+// SimConfig configures a simulated network of nodes running in a process.
+type SimConfig struct {
+	Genesis     *Genesis                                  // genesis of the chain of every node
+	ChainConfig *params.ChainConfig                       // configuration of the chain of every node
+	NewEngine   func(chain *BlockChain) consensus.IEngine // creates the engine validating the blocks of a node
+	TxPool      txpool.Config                             // configuration of the pool of every node
+	Latency     time.Duration                             // delay of every message between two nodes
+	Jitter      time.Duration                             // maximum random delay added to the latency
+	Seed        int64                                     // seed of the jitter, for reproducible runs
+}
+
+// Simulation is a network of nodes, each with its own blockchain, downloader
+// and transaction pool, exchanging their messages in memory instead of over
+// p2p connections. The messages between nodes are encoded and decoded as on
+// the wire and delayed by the latency; partitions drop them. The downloaders
+// and the message delays run on the virtual time of the simulation, which
+// only passes while the simulation runs, so the timeouts and the order of
+// the messages don't depend on the speed of the machine.
+type Simulation struct {
+	config SimConfig
+	nodes  []*SimNode
+	clock  *SimClock
+
+	mu    sync.Mutex
+	rand  *rand.Rand
+	links map[[2]int]bool // connected pairs of nodes, lowest index first
+	group map[int]int     // partition group of each node, all in 0 if unset
+}
+
+// SimNode is a node of a simulation.
+type SimNode struct {
+	ID     int
+	Router *event.Router
+	Chain  *BlockChain
+	TxPool *txpool.TxPool
+
+	sim   *Simulation
+	mu    sync.Mutex
+	peers map[int]event.Station // remote stations of the connected nodes
+	subs  []event.Subscription
+	quit  chan struct{}
+}
+
+// simPeer is the data of the remote station of a node as seen by another.
+type simPeer struct {
+	id int
+}
+
+// simAdaptor sends out the events of a node addressed to remote stations.
+type simAdaptor struct {
+	node *SimNode
+}
+
+// NewSimulation creates a simulation of n unconnected nodes sharing the genesis.
+func NewSimulation(config SimConfig, n int) (*Simulation, error) {
+	if config.Genesis == nil || config.ChainConfig == nil || config.NewEngine == nil {
+		return nil, errors.New("simulation needs a genesis, a chain config and an engine")
+	}
+	sim := &Simulation{
+		config: config,
+		clock:  NewSimClock(),
+		rand:   rand.New(rand.NewSource(config.Seed)),
+		links:  make(map[[2]int]bool),
+		group:  make(map[int]int),
+	}
+	for id := 0; id < n; id++ {
+		node, err := sim.newNode(id)
+		if err != nil {
+			sim.Stop()
+			return nil, err
+		}
+		sim.nodes = append(sim.nodes, node)
+	}
+	return sim, nil
+}
+
+func (sim *Simulation) newNode(id int) (*SimNode, error) {
+	db := fdb.NewMemDatabase()
+	if _, err := sim.config.Genesis.Commit(db); err != nil {
+		return nil, err
+	}
+	r := event.NewRouter()
+	chain, err := NewBlockChainWithRouter(r, db, nil, vm.Config{}, sim.config.ChainConfig, txpool.SenderCacher, WithClock(sim.clock))
+	if err != nil {
+		return nil, err
+	}
+	engine := sim.config.NewEngine(chain)
+	bc := &struct {
+		*BlockChain
+		consensus.IEngine
+	}{chain, engine}
+	chain.SetValidator(processor.NewBlockValidator(bc, engine))
+	chain.SetProcessor(processor.NewStateProcessor(bc, engine))
+
+	node := &SimNode{
+		ID:     id,
+		Router: r,
+		Chain:  chain,
+		TxPool: txpool.New(sim.config.TxPool, sim.config.ChainConfig, chain),
+		sim:    sim,
+		peers:  make(map[int]event.Station),
+		quit:   make(chan struct{}),
+	}
+	r.AdaptorRegister(&simAdaptor{node})
+
+	// The node drops the peers its subsystems disconnect or ban.
+	ch := make(chan *event.Event)
+	node.subs = []event.Subscription{
+		r.Subscribe(nil, ch, event.P2pDisconectPeer, nil),
+		r.Subscribe(nil, ch, event.P2pBanPeer, nil),
+	}
+	go node.loop(ch)
+	return node, nil
+}
+
+// Nodes returns the nodes of the simulation.
+func (sim *Simulation) Nodes() []*SimNode {
+	return sim.nodes
+}
+
+// Node returns the node of the index.
+func (sim *Simulation) Node(id int) *SimNode {
+	return sim.nodes[id]
+}
+
+// Clock returns the virtual time of the simulation.
+func (sim *Simulation) Clock() *SimClock {
+	return sim.clock
+}
+
+// Run lets the virtual time pass by d, expiring the timers of the nodes and
+// delivering their messages in the order of their deadlines.
+func (sim *Simulation) Run(d time.Duration) {
+	for end := sim.clock.Now().Add(d); sim.clock.Now().Before(end); {
+		sim.step(end)
+	}
+}
+
+// step advances the virtual time to the next deadline, by simStep at most and
+// not beyond end, and gives the nodes time to process the expired timers.
+func (sim *Simulation) step(end time.Time) {
+	next := sim.clock.Now().Add(simStep)
+	if deadline, ok := sim.clock.next(); ok && deadline.Before(next) {
+		next = deadline
+	}
+	if end.Before(next) {
+		next = end
+	}
+	sim.clock.AdvanceTo(next)
+	time.Sleep(simYield)
+}
+
+// Connect connects the two nodes, each seeing the other as a new peer.
+func (sim *Simulation) Connect(a, b int) {
+	sim.mu.Lock()
+	key := linkKey(a, b)
+	if a == b || sim.links[key] {
+		sim.mu.Unlock()
+		return
+	}
+	sim.links[key] = true
+	sim.mu.Unlock()
+
+	sim.nodes[a].addPeer(b)
+	sim.nodes[b].addPeer(a)
+}
+
+// ConnectAll connects every pair of nodes.
+func (sim *Simulation) ConnectAll() {
+	for a := range sim.nodes {
+		for b := a + 1; b < len(sim.nodes); b++ {
+			sim.Connect(a, b)
+		}
+	}
+}
+
+// Disconnect disconnects the two nodes.
+func (sim *Simulation) Disconnect(a, b int) {
+	sim.mu.Lock()
+	key := linkKey(a, b)
+	if !sim.links[key] {
+		sim.mu.Unlock()
+		return
+	}
+	delete(sim.links, key)
+	sim.mu.Unlock()
+
+	sim.nodes[a].delPeer(b)
+	sim.nodes[b].delPeer(a)
+}
+
+// Partition splits the nodes into the groups, the messages between nodes of
+// different groups being dropped until Heal. The nodes left out of the groups
+// form one more group. The connections are kept, as on a network whose
+// packets are lost.
+func (sim *Simulation) Partition(groups ...[]int) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.group = make(map[int]int)
+	for i, group := range groups {
+		for _, id := range group {
+			sim.group[id] = i + 1
+		}
+	}
+}
+
+// Heal ends the partition.
+func (sim *Simulation) Heal() {
+	sim.Partition()
+}
+
+// Stop stops every node.
+func (sim *Simulation) Stop() {
+	for _, node := range sim.nodes {
+		node.stop()
+	}
+}
+
+// WaitSync runs the simulation until the nodes, every node if none given, have
+// the same head block, failing after the timeout of virtual time.
+func (sim *Simulation) WaitSync(timeout time.Duration, ids ...int) error {
+	if len(ids) == 0 {
+		for id := range sim.nodes {
+			ids = append(ids, id)
+		}
+	}
+	deadline := sim.clock.Now().Add(timeout)
+	for {
+		head := sim.nodes[ids[0]].Chain.CurrentBlock()
+		synced := true
+		for _, id := range ids[1:] {
+			if sim.nodes[id].Chain.CurrentBlock().Hash() != head.Hash() {
+				synced = false
+				break
+			}
+		}
+		if synced {
+			return nil
+		}
+		if !sim.clock.Now().Before(deadline) {
+			return fmt.Errorf("nodes %v not synced after %v", ids, timeout)
+		}
+		sim.step(deadline)
+	}
+}
+
+// delay returns the delay of a message between two nodes, and whether the
+// message is dropped.
+func (sim *Simulation) delay(from, to int) (time.Duration, bool) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	if !sim.links[linkKey(from, to)] || sim.group[from] != sim.group[to] {
+		return 0, false
+	}
+	delay := sim.config.Latency
+	if sim.config.Jitter > 0 {
+		delay += time.Duration(sim.rand.Int63n(int64(sim.config.Jitter)))
+	}
+	return delay, true
+}
+
+func linkKey(a, b int) [2]int {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]int{a, b}
+}
+
+// peerName returns the name of the remote station of a node, 8 bytes long as
+// the ids of the p2p peers prefixing the names of their stations.
+func peerName(id int) string {
+	return fmt.Sprintf("sim%05d", id)
+}
+
+// Import inserts the blocks into the chain of the node and announces the head
+// to its peers, as if the node produced them.
+func (n *SimNode) Import(blocks types.Blocks) error {
+	if _, err := n.Chain.InsertChain(blocks); err != nil {
+		return err
+	}
+	head := n.Chain.CurrentBlock()
+	n.Router.SendEvent(&event.Event{Typecode: event.NewMinedEv, Data: NewMinedBlockEvent{Block: head}})
+	return nil
+}
+
+// Peers returns the number of the peers of the node.
+func (n *SimNode) Peers() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.peers)
+}
+
+func (n *SimNode) addPeer(id int) {
+	station := event.NewRemoteStation(peerName(id), &simPeer{id})
+	n.mu.Lock()
+	n.peers[id] = station
+	n.mu.Unlock()
+	n.Router.StationRegister(station)
+	go n.Router.SendEvent(&event.Event{From: station, Typecode: event.P2pNewPeer})
+}
+
+func (n *SimNode) delPeer(id int) {
+	n.mu.Lock()
+	station, ok := n.peers[id]
+	delete(n.peers, id)
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+	n.Router.StationUnregister(station)
+	go n.Router.SendEvent(&event.Event{From: station, Typecode: event.P2pDelPeer})
+}
+
+func (n *SimNode) peer(id int) event.Station {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.peers[id]
+}
+
+func (n *SimNode) loop(ch chan *event.Event) {
+	for {
+		select {
+		case e := <-ch:
+			if station, ok := e.Data.(event.Station); ok {
+				if peer, ok := station.Data().(*simPeer); ok {
+					go n.sim.Disconnect(n.ID, peer.id)
+				}
+			}
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+func (n *SimNode) stop() {
+	for _, sub := range n.subs {
+		sub.Unsubscribe()
+	}
+	close(n.quit)
+	n.TxPool.Stop()
+	n.Chain.Stop()
+}
+
+// SendOut implements event.ProtoAdaptor, delivering the event to the remote
+// node, or to every peer if the station is a broadcast one.
+func (a *simAdaptor) SendOut(e *event.Event) error {
+	if e.To.IsBroadcast() {
+		a.node.mu.Lock()
+		ids := make([]int, 0, len(a.node.peers))
+		for id := range a.node.peers {
+			ids = append(ids, id)
+		}
+		a.node.mu.Unlock()
+		for _, id := range ids {
+			a.deliver(id, "", e)
+		}
+		return nil
+	}
+	peer, ok := e.To.Data().(*simPeer)
+	if !ok {
+		return fmt.Errorf("station %q isn't a simulated peer", e.To.Name())
+	}
+	return a.deliver(peer.id, e.To.Name()[len(peerName(peer.id)):], e)
+}
+
+// deliver sends the event to the station named to of the node after the
+// delay of the link, encoded and decoded as on the wire.
+func (a *simAdaptor) deliver(id int, to string, e *event.Event) error {
+	payload, err := event.EncodePayload(e.Typecode, e.Data)
+	if err != nil {
+		return err
+	}
+	version := event.PayloadVersionOf(e.Typecode, e.Data)
+	delay, ok := a.node.sim.delay(a.node.ID, id)
+	if !ok {
+		return nil
+	}
+	remote := a.node.sim.nodes[id]
+	a.node.sim.clock.AfterFunc(delay, func() {
+		data, err := event.DecodePayload(e.Typecode, version, payload)
+		if err != nil {
+			return
+		}
+		from := remote.peer(a.node.ID)
+		if from == nil {
+			return
+		}
+		if e.From != nil {
+			from = event.NewRemoteStation(from.Name()+e.From.Name(), from.Data())
+		}
+		remote.Router.SendEvent(&event.Event{
+			From:     from,
+			To:       remote.Router.GetStationByName(to),
+			Typecode: e.Typecode,
+			Data:     data,
+		})
+	})
+	return nil
+}
+
+// SimClock is a virtual clock whose timers only expire when it is advanced,
+// in the order of their deadlines, those with the same deadline in the order
+// they were set.
+type SimClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers simTimers
+}
+
+// simTimer is a timer of a virtual clock, sending the time on ch or calling
+// fn in a new goroutine when it expires.
+type simTimer struct {
+	deadline time.Time
+	seq      uint64
+	ch       chan time.Time
+	fn       func()
+}
+
+// simTimers is a heap of timers ordered by deadline.
+type simTimers []*simTimer
+
+func (t simTimers) Len() int { return len(t) }
+func (t simTimers) Less(i, j int) bool {
+	if t[i].deadline.Equal(t[j].deadline) {
+		return t[i].seq < t[j].seq
+	}
+	return t[i].deadline.Before(t[j].deadline)
+}
+func (t simTimers) Swap(i, j int)       { t[i], t[j] = t[j], t[i] }
+func (t *simTimers) Push(x interface{}) { *t = append(*t, x.(*simTimer)) }
+func (t *simTimers) Pop() interface{} {
+	old := *t
+	timer := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return timer
+}
+
+// NewSimClock creates a virtual clock starting at the zero time.
+func NewSimClock() *SimClock {
+	return new(SimClock)
+}
+
+// Now returns the virtual time.
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After implements Clock, the channel receives the virtual time once it
+// passed d.
+func (c *SimClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.add(&simTimer{ch: ch}, d)
+	return ch
+}
+
+// AfterFunc calls fn in its own goroutine once the virtual time passed d.
+func (c *SimClock) AfterFunc(d time.Duration, fn func()) {
+	c.add(&simTimer{fn: fn}, d)
+}
+
+func (c *SimClock) add(timer *simTimer, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer.deadline = c.now.Add(d)
+	timer.seq = c.seq
+	c.seq++
+	heap.Push(&c.timers, timer)
+}
+
+// next returns the deadline of the next timer, if any.
+func (c *SimClock) next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	return c.timers[0].deadline, true
+}
+
+// AdvanceTo moves the virtual time forward to t, expiring the timers due by
+// then in the order of their deadlines.
+func (c *SimClock) AdvanceTo(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) > 0 && !c.timers[0].deadline.After(t) {
+		timer := heap.Pop(&c.timers).(*simTimer)
+		c.now = timer.deadline
+		if timer.fn != nil {
+			go timer.fn()
+		} else {
+			timer.ch <- c.now
+		}
+	}
+	if t.After(c.now) {
+		c.now = t
+	}
+}
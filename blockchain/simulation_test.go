@@ -0,0 +1,134 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/consensus/dpos"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
+)
+
+func TestSimulationSyncAcrossPartition(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 2)
+	if _, _, _, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx); err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	var blocks types.Blocks
+	for number := uint64(1); number <= chain.CurrentBlock().NumberU64(); number++ {
+		blocks = append(blocks, chain.GetBlockByNumber(number))
+	}
+	third := len(blocks) / 3
+
+	sim, err := NewSimulation(SimConfig{
+		Genesis:     DefaultGenesis(),
+		ChainConfig: params.DefaultChainconfig,
+		NewEngine: func(chain *BlockChain) consensus.IEngine {
+			return &tdpos{dpos.New(dpos.DefaultConfig, chain)}
+		},
+		TxPool:  txpool.Config{Rejournal: time.Hour, Lifetime: time.Hour, GlobalSlots: 4096, GlobalQueue: 1024, AccountSlots: 16, AccountQueue: 64},
+		Latency: 5 * time.Millisecond,
+		Jitter:  5 * time.Millisecond,
+		Seed:    1,
+	}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Stop()
+
+	if err := sim.Node(0).Import(blocks[:third]); err != nil {
+		t.Fatal(err)
+	}
+	sim.ConnectAll()
+	if err := sim.WaitSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Node 2 is cut off, the blocks produced meanwhile only reach node 1.
+	sim.Partition([]int{0, 1}, []int{2})
+	if err := sim.Node(0).Import(blocks[third : 2*third]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.WaitSync(10*time.Second, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	sim.Run(100 * time.Millisecond)
+	if have, want := sim.Node(2).Chain.CurrentBlock().NumberU64(), blocks[third-1].NumberU64(); have != want {
+		t.Fatalf("partitioned node head mismatch: have %d, want %d", have, want)
+	}
+
+	// Once healed, the next block brings node 2 up to date.
+	sim.Heal()
+	if err := sim.Node(0).Import(blocks[2*third:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.WaitSync(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := sim.Node(2).Chain.CurrentBlock().Hash(), blocks[len(blocks)-1].Hash(); have != want {
+		t.Fatalf("head mismatch: have %x, want %x", have, want)
+	}
+	for _, node := range sim.Nodes() {
+		if node.Peers() != 2 {
+			t.Fatalf("node %d peers mismatch: have %d, want 2", node.ID, node.Peers())
+		}
+	}
+}
+
+func TestSimClock(t *testing.T) {
+	clock := NewSimClock()
+	start := clock.Now()
+	late := clock.After(2 * time.Second)
+	early := clock.After(time.Second)
+	fired := make(chan int, 2)
+	clock.AfterFunc(time.Second, func() { fired <- 1 })
+
+	// Timers only expire as the virtual time passes their deadline.
+	clock.AdvanceTo(start.Add(999 * time.Millisecond))
+	select {
+	case <-early:
+		t.Fatal("timer expired before its deadline")
+	default:
+	}
+	clock.AdvanceTo(start.Add(time.Second))
+	if now := <-early; !now.Equal(start.Add(time.Second)) {
+		t.Fatalf("expiry time mismatch: have %v, want %v", now, start.Add(time.Second))
+	}
+	if <-fired != 1 {
+		t.Fatal("function not called")
+	}
+	select {
+	case <-late:
+		t.Fatal("later timer expired early")
+	default:
+	}
+	clock.AdvanceTo(start.Add(time.Hour))
+	<-late
+	if now := clock.Now(); !now.Equal(start.Add(time.Hour)) {
+		t.Fatalf("time mismatch: have %v, want %v", now, start.Add(time.Hour))
+	}
+}
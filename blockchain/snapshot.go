@@ -0,0 +1,342 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+// snapshotVersion is the version of the snapshot format. Version 2 hashes the
+// state the way the checkpoints do.
+const snapshotVersion = 2
+
+var (
+	errSnapshotNotEmpty     = errors.New("database is not empty")
+	errSnapshotNoCheckpoint = errors.New("no checkpoint of the snapshot block")
+)
+
+// SnapshotMeta describes a snapshot of the chain at a block. A snapshot is an
+// RLP stream of the metadata, the genesis block, the recent blocks ending with
+// the snapshot block, then the state items in key order.
+//
+// The state root of a header only commits to the state changed by the block, so
+// StateHash commits to the whole state, see state.StateHasher. It is the state
+// hash of the checkpoint of the block, which the snapshot is imported against.
+type SnapshotMeta struct {
+	Version   uint64      `json:"version"`
+	Genesis   common.Hash `json:"genesis"`
+	Number    uint64      `json:"number"`
+	Hash      common.Hash `json:"hash"`
+	Blocks    uint64      `json:"blocks"`
+	Items     uint64      `json:"items"`
+	StateHash common.Hash `json:"stateHash"`
+}
+
+type snapshotBlock struct {
+	Block    *types.Block
+	Td       *big.Int
+	Receipts []*types.Receipt
+}
+
+type snapshotItem struct {
+	Key   []byte
+	Value []byte
+}
+
+// ExportSnapshot writes a snapshot of the canonical chain at the given block
+// number to w, with up to blocks recent blocks. The state must not have been
+// pruned above the block. Only the snapshots of checkpointed blocks can be
+// imported.
+func (bc *BlockChain) ExportSnapshot(number, blocks uint64, w io.Writer) (*SnapshotMeta, error) {
+	// The state can't be moved while it is read.
+	bc.stateCache.RLock()
+	defer bc.stateCache.RUnLock()
+	return ExportSnapshot(bc.db, number, blocks, w)
+}
+
+// ExportSnapshot writes a snapshot of the canonical chain at the given block
+// number to w, with up to blocks recent blocks. The database must not be
+// written meanwhile.
+func ExportSnapshot(db fdb.Database, number, blocks uint64, w io.Writer) (*SnapshotMeta, error) {
+	if number == 0 {
+		return nil, errors.New("can't snapshot the genesis block")
+	}
+	hash := rawdb.ReadCanonicalHash(db, number)
+	if hash == (common.Hash{}) {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	if blocks == 0 {
+		blocks = 1
+	}
+	if blocks > number {
+		blocks = number
+	}
+	meta := &SnapshotMeta{
+		Version: snapshotVersion,
+		Genesis: rawdb.ReadCanonicalHash(db, 0),
+		Number:  number,
+		Hash:    hash,
+		Blocks:  blocks,
+	}
+
+	// The metadata commits to the state, so it is hashed ahead of writing it.
+	hasher := state.NewStateHasher()
+	err := state.ForEachStateItem(db, hash, func(key, value []byte) error {
+		return hasher.Add(key, crypto.Keccak256Hash(value))
+	})
+	if err != nil {
+		return nil, err
+	}
+	meta.Items, meta.StateHash = hasher.Items(), hasher.Sum()
+
+	bw := bufio.NewWriter(w)
+	if err := rlp.Encode(bw, meta); err != nil {
+		return nil, err
+	}
+	// the genesis block comes ahead of the recent blocks
+	for _, n := range append([]uint64{0}, numbers(number-blocks+1, number)...) {
+		block, err := readSnapshotBlock(db, n)
+		if err != nil {
+			return nil, err
+		}
+		if err := rlp.Encode(bw, block); err != nil {
+			return nil, err
+		}
+	}
+	err = state.ForEachStateItem(db, hash, func(key, value []byte) error {
+		return rlp.Encode(bw, &snapshotItem{key, value})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, bw.Flush()
+}
+
+// stateHashChunk is the number of state items hashed at once while holding
+// the state lock.
+const stateHashChunk = 4096
+
+var errStateChunkDone = errors.New("state chunk done")
+
+// StateHash returns the commitment to the whole state at the block, see
+// state.StateHasher. The state is hashed a chunk at a time, so blocks are
+// inserted meanwhile, the block must remain an ancestor of the head.
+func (bc *BlockChain) StateHash(hash common.Hash) (common.Hash, error) {
+	hasher := state.NewStateHasher()
+	var origin []byte
+	for {
+		var last []byte
+		bc.stateCache.RLock()
+		err := state.ForEachStateItemFrom(bc.db, hash, origin, func(key, value []byte) error {
+			if len(last) > 0 && hasher.Items()%stateHashChunk == 0 {
+				return errStateChunkDone
+			}
+			last = key
+			return hasher.Add(key, crypto.Keccak256Hash(value))
+		})
+		bc.stateCache.RUnLock()
+		switch err {
+		case nil:
+			return hasher.Sum(), nil
+		case errStateChunkDone:
+			origin = append(common.CopyBytes(last), 0)
+		default:
+			return common.Hash{}, err
+		}
+	}
+}
+
+// numbers returns the numbers from first to last.
+func numbers(first, last uint64) []uint64 {
+	var ns []uint64
+	for n := first; n <= last; n++ {
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+func readSnapshotBlock(db fdb.Database, number uint64) (*snapshotBlock, error) {
+	hash := rawdb.ReadCanonicalHash(db, number)
+	block := rawdb.ReadBlock(db, hash, number)
+	td := rawdb.ReadTd(db, hash, number)
+	if block == nil || td == nil {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	return &snapshotBlock{block, td, rawdb.ReadReceipts(db, hash, number)}, nil
+}
+
+// ImportSnapshot reads a snapshot from r into an empty database, verifying the
+// blocks and the state against the checkpoint of the snapshot block, obtained
+// from a trusted source. Nothing is written to the database if the snapshot
+// doesn't verify, except possibly state items and blocks that aren't part of
+// the chain.
+func ImportSnapshot(db fdb.Database, r io.Reader, checkpoint *types.Checkpoint) (*SnapshotMeta, error) {
+	if checkpoint == nil {
+		return nil, errSnapshotNoCheckpoint
+	}
+	if rawdb.ReadCanonicalHash(db, 0) != (common.Hash{}) || rawdb.ReadHeadBlockHash(db) != (common.Hash{}) {
+		return nil, errSnapshotNotEmpty
+	}
+	stream := rlp.NewStream(bufio.NewReader(r), 0)
+	meta := new(SnapshotMeta)
+	if err := stream.Decode(meta); err != nil {
+		return nil, fmt.Errorf("invalid snapshot metadata: %v", err)
+	}
+	if meta.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", meta.Version)
+	}
+	if meta.Number != checkpoint.Number || meta.Hash != checkpoint.Hash {
+		return nil, fmt.Errorf("snapshot block mismatch: have %d %x, checkpoint %d %x", meta.Number, meta.Hash, checkpoint.Number, checkpoint.Hash)
+	}
+	if meta.StateHash != checkpoint.StateHash {
+		return nil, fmt.Errorf("snapshot state hash mismatch: have %x, checkpoint %x", meta.StateHash, checkpoint.StateHash)
+	}
+	if meta.Number == 0 || meta.Blocks == 0 || meta.Blocks > meta.Number {
+		return nil, fmt.Errorf("invalid snapshot of %d blocks at block %d", meta.Blocks, meta.Number)
+	}
+
+	batch := db.NewBatch()
+	write := func() error {
+		if batch.ValueSize() < fdb.IdealBatchSize {
+			return nil
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+		return nil
+	}
+
+	// blocks
+	genesis := new(snapshotBlock)
+	if err := stream.Decode(genesis); err != nil {
+		return nil, fmt.Errorf("invalid snapshot genesis block: %v", err)
+	}
+	if genesis.Block.NumberU64() != 0 || genesis.Block.Hash() != meta.Genesis {
+		return nil, fmt.Errorf("snapshot genesis block mismatch: have %x, want %x", genesis.Block.Hash(), meta.Genesis)
+	}
+	writeSnapshotBlock(batch, genesis)
+	chain := []*types.Block{genesis.Block}
+	parent := genesis
+	for i := uint64(0); i < meta.Blocks; i++ {
+		block := new(snapshotBlock)
+		if err := stream.Decode(block); err != nil {
+			return nil, fmt.Errorf("invalid snapshot block: %v", err)
+		}
+		if err := verifySnapshotBlock(block, parent, meta.Number-meta.Blocks+1+i); err != nil {
+			return nil, err
+		}
+		writeSnapshotBlock(batch, block)
+		if err := write(); err != nil {
+			return nil, err
+		}
+		chain = append(chain, block.Block)
+		parent = block
+	}
+	if head := chain[len(chain)-1]; head.Hash() != meta.Hash || head.Root() != checkpoint.Root {
+		return nil, fmt.Errorf("snapshot block mismatch: have %x root %x, checkpoint %x root %x", head.Hash(), head.Root(), meta.Hash, checkpoint.Root)
+	}
+
+	// state
+	var (
+		hasher = state.NewStateHasher()
+		last   []byte
+	)
+	for i := uint64(0); i < meta.Items; i++ {
+		item := new(snapshotItem)
+		if err := stream.Decode(item); err != nil {
+			return nil, fmt.Errorf("invalid snapshot state item: %v", err)
+		}
+		if !state.IsStateKey(item.Key) || len(item.Value) == 0 {
+			return nil, fmt.Errorf("invalid snapshot state item %q", item.Key)
+		}
+		if last != nil && bytes.Compare(item.Key, last) <= 0 {
+			return nil, fmt.Errorf("snapshot state item %q out of order", item.Key)
+		}
+		last = item.Key
+		if err := hasher.Add(item.Key, crypto.Keccak256Hash(item.Value)); err != nil {
+			return nil, err
+		}
+		if err := batch.Put(item.Key, item.Value); err != nil {
+			return nil, err
+		}
+		if err := write(); err != nil {
+			return nil, err
+		}
+	}
+	if stateHash := hasher.Sum(); stateHash != checkpoint.StateHash {
+		return nil, fmt.Errorf("snapshot state hash mismatch: have %x, checkpoint %x", stateHash, checkpoint.StateHash)
+	}
+
+	// The blocks become the canonical chain along with the state.
+	for _, block := range chain {
+		rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+	}
+	head := chain[len(chain)-1]
+	rawdb.WriteHeadBlockHash(batch, head.Hash())
+	rawdb.WriteHeadHeaderHash(batch, head.Hash())
+	rawdb.WriteHeadFastBlockHash(batch, head.Hash())
+	if err := state.CommitImportedState(db, batch, head.ParentHash(), head.Hash(), head.NumberU64()); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// verifySnapshotBlock checks that block is the block with the given number
+// whose parent is parent, unless the parent is the genesis block of a snapshot
+// without the blocks in between.
+func verifySnapshotBlock(block, parent *snapshotBlock, number uint64) error {
+	if block.Block.NumberU64() != number {
+		return fmt.Errorf("snapshot block number mismatch: have %d, want %d", block.Block.NumberU64(), number)
+	}
+	if hash := types.DeriveTxMerkleRoot(block.Block.Txs); hash != block.Block.TxHash() {
+		return fmt.Errorf("snapshot block %d transaction root mismatch: have %x, want %x", number, hash, block.Block.TxHash())
+	}
+	if hash := types.DeriveReceiPtMerkleRoot(block.Receipts); hash != block.Block.ReceiptHash() {
+		return fmt.Errorf("snapshot block %d receipt root mismatch: have %x, want %x", number, hash, block.Block.ReceiptHash())
+	}
+	if parent.Block.NumberU64()+1 != number {
+		return nil
+	}
+	if block.Block.ParentHash() != parent.Block.Hash() {
+		return fmt.Errorf("snapshot block %d parent mismatch: have %x, want %x", number, block.Block.ParentHash(), parent.Block.Hash())
+	}
+	if td := new(big.Int).Add(parent.Td, block.Block.Difficulty()); td.Cmp(block.Td) != 0 {
+		return fmt.Errorf("snapshot block %d total difficulty mismatch: have %v, want %v", number, block.Td, td)
+	}
+	return nil
+}
+
+func writeSnapshotBlock(batch fdb.Batch, block *snapshotBlock) {
+	rawdb.WriteTd(batch, block.Block.Hash(), block.Block.NumberU64(), block.Td)
+	rawdb.WriteBlock(batch, block.Block)
+	rawdb.WriteReceipts(batch, block.Block.Hash(), block.Block.NumberU64(), block.Receipts)
+	rawdb.WriteTxLookupEntries(batch, block.Block)
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func stateItems(t *testing.T, db fdb.Database, hash common.Hash) map[string]string {
+	items := make(map[string]string)
+	err := state.ForEachStateItem(db, hash, func(key, value []byte) error {
+		items[string(key)] = string(value)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func TestSnapshot(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+
+	// Snapshot a block below the head, so the state is reverted.
+	snap, rest := blocks[len(blocks)-4], blocks[len(blocks)-3:]
+	var buf bytes.Buffer
+	meta, err := chain.ExportSnapshot(snap.NumberU64(), 8, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Hash != snap.Hash() || meta.Blocks != 8 || meta.Items == 0 {
+		t.Fatalf("snapshot metadata mismatch: %+v", meta)
+	}
+	data := buf.Bytes()
+	stateHash, err := chain.StateHash(snap.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.StateHash != stateHash {
+		t.Fatalf("snapshot state hash mismatch: have %x, want %x", meta.StateHash, stateHash)
+	}
+	checkpoint := &types.Checkpoint{Number: snap.NumberU64(), Hash: snap.Hash(), Root: snap.Root(), StateHash: stateHash}
+
+	// A tampered snapshot doesn't import.
+	tampered := common.CopyBytes(data)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := ImportSnapshot(fdb.NewMemDatabase(), bytes.NewReader(tampered), checkpoint); err == nil {
+		t.Fatal("tampered snapshot imported")
+	}
+	if _, err := ImportSnapshot(fdb.NewMemDatabase(), bytes.NewReader(data), nil); err != errSnapshotNoCheckpoint {
+		t.Fatalf("import without a checkpoint: have %v, want %v", err, errSnapshotNoCheckpoint)
+	}
+	other := &types.Checkpoint{Number: blocks[0].NumberU64(), Hash: blocks[0].Hash(), Root: blocks[0].Root(), StateHash: stateHash}
+	if _, err := ImportSnapshot(fdb.NewMemDatabase(), bytes.NewReader(data), other); err == nil {
+		t.Fatal("snapshot of another block imported")
+	}
+	// A snapshot asserting another state hash doesn't import.
+	forged := *checkpoint
+	forged.StateHash = common.Hash{1}
+	if _, err := ImportSnapshot(fdb.NewMemDatabase(), bytes.NewReader(data), &forged); err == nil {
+		t.Fatal("snapshot imported against another state hash")
+	}
+
+	newdb := fdb.NewMemDatabase()
+	imported, err := ImportSnapshot(newdb, bytes.NewReader(data), checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported, meta) {
+		t.Fatalf("imported metadata mismatch: have %+v, want %+v", imported, meta)
+	}
+	if !reflect.DeepEqual(stateItems(t, newdb, snap.Hash()), stateItems(t, db, snap.Hash())) {
+		t.Fatal("imported state mismatch")
+	}
+	if _, err := ImportSnapshot(newdb, bytes.NewReader(data), checkpoint); err != errSnapshotNotEmpty {
+		t.Fatalf("import into a used database: have %v, want %v", err, errSnapshotNotEmpty)
+	}
+
+	// The imported node continues the chain.
+	newchain, err := NewBlockChain(newdb, nil, vm.Config{}, chain.Config(), txpool.SenderCacher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newchain.Stop()
+	type bc struct {
+		*BlockChain
+		consensus.IEngine
+	}
+	newchain.SetValidator(processor.NewBlockValidator(&bc{newchain, tengine}, tengine))
+	newchain.SetProcessor(processor.NewStateProcessor(&bc{newchain, tengine}, tengine))
+	if newchain.CurrentBlock().Hash() != snap.Hash() {
+		t.Fatalf("imported head mismatch: have %x, want %x", newchain.CurrentBlock().Hash(), snap.Hash())
+	}
+	if _, err := newchain.InsertChain(rest); err != nil {
+		t.Fatal(err)
+	}
+	head := rest[len(rest)-1]
+	if hash := rawdb.ReadOptBlockHash(newdb); hash != head.Hash() {
+		t.Fatalf("state head mismatch: have %x, want %x", hash, head.Hash())
+	}
+	if !reflect.DeepEqual(stateItems(t, newdb, head.Hash()), stateItems(t, db, head.Hash())) {
+		t.Fatal("state mismatch after the imported block")
+	}
+}
+
+func TestStateAtBlock(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Error("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 10)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx)
+	if err != nil {
+		t.Error("makeNewChain err", err)
+	}
+
+	sysName := common.StrToName(chain.Config().SysName.String())
+	nonce := func(hash common.Hash) uint64 {
+		statedb, err := chain.StateAtBlock(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		am, err := accountmanager.NewAccountManager(statedb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := am.GetNonce(sysName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	nonces := make([]uint64, len(blocks))
+	for i, block := range blocks {
+		nonces[i] = nonce(block.Hash())
+	}
+
+	// The state of the pruned blocks is replayed from the retained ones.
+	if err := state.PruneStateOuts(db, 3, 4, func(int, int) {}); err != nil {
+		t.Fatal(err)
+	}
+	if chain.HasState(blocks[len(blocks)-6].Hash()) && chain.HasState(blocks[len(blocks)-7].Hash()) {
+		t.Fatal("no state changes pruned")
+	}
+	for i, block := range blocks {
+		if n := nonce(block.Hash()); n != nonces[i] {
+			t.Errorf("block %d: nonce %d, want %d", block.NumberU64(), n, nonces[i])
+		}
+	}
+
+	// The states deeper than the limits are refused.
+	var replayed *types.Block
+	for _, block := range blocks {
+		if !chain.HasState(block.Hash()) && !chain.HasState(block.ParentHash()) {
+			replayed = block
+			break
+		}
+	}
+	if replayed == nil {
+		t.Fatal("no block replayed from two blocks below")
+	}
+	chain.maxReplay = 1
+	if _, err := chain.StateAtBlock(replayed.Hash()); err == nil {
+		t.Error("state replayed beyond the replay limit")
+	}
+	chain.maxReplay, chain.maxRevert = maxReplayBlocks, 1
+	if _, err := chain.StateAtBlock(blocks[0].Hash()); err == nil {
+		t.Error("state reverted beyond the revert limit")
+	}
+}
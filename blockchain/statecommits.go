@@ -0,0 +1,116 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"sync"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/metrics"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/hashicorp/golang-lru"
+)
+
+const (
+	// defaultStateCommitCache is the memory allowance in megabytes of the
+	// executed blocks kept when none is configured.
+	defaultStateCommitCache = 64
+	// stateCommitBlocks bounds the number of executed blocks kept whatever
+	// their size.
+	stateCommitBlocks = 1024
+)
+
+var (
+	stateCommitHitMeter  = metrics.NewRegisteredMeter("blockchain/statecommits/hit", nil)
+	stateCommitMissMeter = metrics.NewRegisteredMeter("blockchain/statecommits/miss", nil)
+)
+
+// stateCommit is the validated result of executing a block on top of its
+// parent state.
+type stateCommit struct {
+	state    *state.StateDB
+	receipts []*types.Receipt
+	logs     []*types.Log
+	size     common.StorageSize
+}
+
+// stateCommitCache keeps the results of the recently executed blocks by block
+// hash, so that a block validated again, e.g. received from the downloader and
+// then broadcast, or switched back to by a reorg, isn't executed again. As a
+// block hash covers its parent hash, a result only applies on the state it
+// was computed from.
+type stateCommitCache struct {
+	mu      sync.Mutex
+	commits *lru.Cache
+	size    common.StorageSize // memory used by the kept results
+	limit   common.StorageSize // memory allowance of the kept results
+}
+
+func newStateCommitCache(limit int) *stateCommitCache {
+	if limit <= 0 {
+		limit = defaultStateCommitCache
+	}
+	c := &stateCommitCache{limit: common.StorageSize(limit * 1024 * 1024)}
+	c.commits, _ = lru.NewWithEvict(stateCommitBlocks, func(key, value interface{}) {
+		c.size -= value.(*stateCommit).size
+	})
+	return c
+}
+
+// add keeps the result of executing a block, evicting the least recently used
+// results beyond the memory allowance. A result larger than the allowance
+// isn't kept.
+func (c *stateCommitCache) add(hash common.Hash, commit *stateCommit) {
+	commit.size = commit.state.Size()
+	for _, receipt := range commit.receipts {
+		commit.size += receipt.Size()
+	}
+	if commit.size > c.limit {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.commits.Remove(hash)
+	c.commits.Add(hash, commit)
+	c.size += commit.size
+	for c.size > c.limit {
+		c.commits.RemoveOldest()
+	}
+}
+
+// get returns the kept result of executing a block, nil if there is none.
+func (c *stateCommitCache) get(hash common.Hash) *stateCommit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if commit, ok := c.commits.Get(hash); ok {
+		stateCommitHitMeter.Mark(1)
+		return commit.(*stateCommit)
+	}
+	stateCommitMissMeter.Mark(1)
+	return nil
+}
+
+// purge drops all the kept results.
+func (c *stateCommitCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.commits.Purge()
+}
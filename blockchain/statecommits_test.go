@@ -0,0 +1,95 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func TestStateCommitCache(t *testing.T) {
+	newCommit := func(t *testing.T, values int) *stateCommit {
+		sdb, err := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < values; i++ {
+			sdb.Put("account", string(rune('a'+i)), make([]byte, 1024))
+		}
+		return &stateCommit{state: sdb}
+	}
+	cache := newStateCommitCache(1)
+	if cache.limit != 1024*1024 {
+		t.Fatalf("limit mismatch: have %v", cache.limit)
+	}
+	// leave room for two commits of two values
+	size := newCommit(t, 2).state.Size()
+	cache.limit = 2*size + size/2
+
+	cache.add(common.Hash{1}, newCommit(t, 2))
+	cache.add(common.Hash{2}, newCommit(t, 2))
+	if cache.get(common.Hash{1}) == nil || cache.get(common.Hash{2}) == nil {
+		t.Fatal("kept commits missing")
+	}
+	// the least recently used commit is evicted beyond the allowance
+	cache.get(common.Hash{1})
+	cache.add(common.Hash{3}, newCommit(t, 2))
+	if cache.get(common.Hash{2}) != nil {
+		t.Fatal("least recently used commit kept beyond the allowance")
+	}
+	if cache.get(common.Hash{1}) == nil || cache.get(common.Hash{3}) == nil {
+		t.Fatal("recent commits missing")
+	}
+	// a commit larger than the allowance isn't kept
+	cache.add(common.Hash{4}, newCommit(t, 8))
+	if cache.get(common.Hash{4}) != nil {
+		t.Fatal("commit beyond the allowance kept")
+	}
+	if cache.size > cache.limit {
+		t.Fatalf("size beyond the allowance: have %v, limit %v", cache.size, cache.limit)
+	}
+	cache.purge()
+	if cache.size != 0 || cache.get(common.Hash{1}) != nil {
+		t.Fatalf("commits kept after purge, size %v", cache.size)
+	}
+}
+
+func TestStateCommitsKept(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 3)
+	_, _, blocks, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx)
+	if err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	for _, block := range blocks {
+		commit := chain.stateCommits.get(block.Hash())
+		if commit == nil {
+			t.Fatalf("block %d: executed block not kept", block.NumberU64())
+		}
+		if len(commit.receipts) != len(block.Txs) {
+			t.Fatalf("block %d: receipts mismatch: have %d, want %d", block.NumberU64(), len(commit.receipts), len(block.Txs))
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/metrics"
+	"github.com/fractalplatform/fractal/state"
+)
+
+const (
+	maxHealRetries = 5 // Failed attempts in a row before the state heal gives up
+
+	// maxHealScan caps the local keys compared with a range listed by a
+	// remote, the range is cut short at the last one.
+	maxHealScan = 2 * maxStateHashServe
+)
+
+var (
+	errStateUnavailable   = errors.New("state not available")
+	errInvalidStateHashes = errors.New("invalid state hashes")
+	errInvalidStateItems  = errors.New("invalid state items")
+	errStateRangeDone     = errors.New("state range done")
+
+	healScannedMeter = metrics.NewRegisteredMeter("blockchain/heal/scanned", nil)
+	healMissingMeter = metrics.NewRegisteredMeter("blockchain/heal/missing", nil)
+	healHealedMeter  = metrics.NewRegisteredMeter("blockchain/heal/healed", nil)
+)
+
+// StateHealProgress gives the state of the heal of the state at the block
+// where fast sync switched to full sync.
+type StateHealProgress struct {
+	Block   common.Hash // block whose state is healed
+	Number  uint64      // number of the block
+	Scanned uint64      // keys compared with the remotes
+	Missing uint64      // keys found missing, stale or unknown to the remotes
+	Healed  uint64      // keys written or deleted
+	Healing bool        // set while the state is healed
+	Done    bool        // set once the state matched the signed checkpoint
+}
+
+// HealProgress returns the progress of the last state heal.
+func (dl *Downloader) HealProgress() StateHealProgress {
+	dl.healMutex.Lock()
+	defer dl.healMutex.Unlock()
+	return dl.heal
+}
+
+// startHeal starts healing the state at the block, unless a heal is running.
+// The block must be a signed checkpoint, whose state hash the healed state is
+// verified against.
+func (dl *Downloader) startHeal(hash common.Hash, number uint64) {
+	checkpoint := dl.blockchain.checkpoints.At(number)
+	if checkpoint == nil || checkpoint.Checkpoint.Hash != hash {
+		log.Warn("No signed checkpoint commits to the state, not healing it", "number", number, "hash", hash)
+		return
+	}
+	dl.healMutex.Lock()
+	defer dl.healMutex.Unlock()
+	if dl.heal.Healing {
+		return
+	}
+	dl.heal = StateHealProgress{Block: hash, Number: number, Healing: true}
+	go dl.healState(hash, checkpoint.Checkpoint.StateHash)
+}
+
+// healState compares the state at the block with the state served by the
+// remotes, a range of keys at a time in key order. It fetches the values of
+// the keys which are missing or stale and deletes the keys the remotes don't
+// list. It gives up after maxHealRetries failed attempts in a row, or as many
+// passes over the state not matching the commitment.
+//
+// The values are checked against the hashes listed by the remotes, and the
+// listings against the commitment to the whole state once the last key was
+// reached. A pass whose listings don't match the commitment may have written
+// the items of a lying remote, so the state is healed again from the first
+// key, until a pass matches.
+func (dl *Downloader) healState(hash, commitment common.Hash) {
+	station := router.NewLocalStation("downloaderHeal", nil)
+	dl.transport.StationRegister(station)
+	defer dl.transport.StationUnregister(station)
+
+	var origin []byte
+	hasher := state.NewStateHasher()
+	for failures, passes := 0, 0; failures < maxHealRetries && passes < maxHealRetries; {
+		status := dl.bestStation(CapState)
+		if status == nil {
+			failures++
+			<-dl.clock.After(dl.config.RequestTimeout)
+			continue
+		}
+		next, done, err := dl.healRange(station, status, hash, origin, hasher)
+		if err != nil {
+			log.Debug("State heal failed", "station", status.station.Name(), "err", err)
+			failures++
+			continue
+		}
+		failures = 0
+		if !done {
+			origin = next
+			continue
+		}
+		if sum := hasher.Sum(); sum != commitment {
+			log.Warn("Healed state conflicts with the signed checkpoint, healing again", "hash", hash, "have", sum, "want", commitment)
+			passes++
+			origin, hasher = nil, state.NewStateHasher()
+			continue
+		}
+		progress := dl.updateHeal(func(progress *StateHealProgress) { progress.Healing, progress.Done = false, true })
+		log.Info("State heal complete", "hash", hash, "scanned", progress.Scanned, "healed", progress.Healed)
+		return
+	}
+	dl.updateHeal(func(progress *StateHealProgress) { progress.Healing = false })
+	log.Warn("State heal aborted", "hash", hash, "origin", origin)
+}
+
+// updateHeal updates the heal progress, returning a copy.
+func (dl *Downloader) updateHeal(update func(*StateHealProgress)) StateHealProgress {
+	dl.healMutex.Lock()
+	defer dl.healMutex.Unlock()
+	update(&dl.heal)
+	return dl.heal
+}
+
+// healRange heals the keys from origin on up to the last one listed by the
+// remote, adding the listed ones to the hasher of the state once healed. It
+// returns the origin of the next range, or done once the last key of the state
+// was reached.
+func (dl *Downloader) healRange(from router.Station, status *stationStatus, hash common.Hash, origin []byte, hasher *state.StateHasher) ([]byte, bool, error) {
+	reply, err := dl.getStateHashes(from, status.station, &getStateHashesData{
+		Block:  hash,
+		Origin: origin,
+		Amount: maxStateHashServe,
+	}, status.errCh)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(reply.Hashes) == 0 && !reply.Complete {
+		return nil, false, errStateUnavailable
+	}
+	listed := reply.Hashes
+	for i, item := range listed {
+		if !state.IsStateKey(item.Key) || bytes.Compare(item.Key, origin) < 0 ||
+			(i > 0 && bytes.Compare(item.Key, listed[i-1].Key) <= 0) {
+			dl.transport.SendTo(nil, nil, router.P2pBanPeer, status.station)
+			return nil, false, errInvalidStateHashes
+		}
+	}
+
+	// the local keys of the range, the range ends at the last listed key
+	// unless the remote listed the last key of the state
+	var local []*stateHashData
+	dl.blockchain.stateCache.RLock()
+	err = state.ForEachStateItemFrom(dl.blockchain.db, hash, origin, func(key, value []byte) error {
+		if (!reply.Complete && bytes.Compare(key, listed[len(listed)-1].Key) > 0) || len(local) >= maxHealScan {
+			return errStateRangeDone
+		}
+		local = append(local, &stateHashData{Key: key, Hash: crypto.Keccak256Hash(value)})
+		return nil
+	})
+	dl.blockchain.stateCache.RUnLock()
+	complete := reply.Complete
+	switch {
+	case err == errStateRangeDone && len(local) >= maxHealScan:
+		// too many local keys, the range ends at the last one compared
+		end := local[len(local)-1].Key
+		for len(listed) > 0 && bytes.Compare(listed[len(listed)-1].Key, end) > 0 {
+			listed = listed[:len(listed)-1]
+		}
+		complete = false
+	case err != nil && err != errStateRangeDone:
+		return nil, false, err
+	}
+
+	var missing []*stateHashData
+	var deleted [][]byte
+	for i, j := 0, 0; i < len(listed) || j < len(local); {
+		switch {
+		case j == len(local) || (i < len(listed) && bytes.Compare(listed[i].Key, local[j].Key) < 0):
+			missing = append(missing, listed[i])
+			i++
+		case i == len(listed) || bytes.Compare(listed[i].Key, local[j].Key) > 0:
+			deleted = append(deleted, local[j].Key)
+			j++
+		default:
+			if listed[i].Hash != local[j].Hash {
+				missing = append(missing, listed[i])
+			}
+			i, j = i+1, j+1
+		}
+	}
+	healScannedMeter.Mark(int64(len(listed)))
+	healMissingMeter.Mark(int64(len(missing) + len(deleted)))
+	dl.updateHeal(func(progress *StateHealProgress) {
+		progress.Scanned += uint64(len(listed))
+		progress.Missing += uint64(len(missing) + len(deleted))
+	})
+
+	// replies may be cut short, keep asking for the rest
+	for len(missing) > 0 {
+		request := missing
+		if len(request) > maxStateItemServe {
+			request = request[:maxStateItemServe]
+		}
+		reqKeys := make([][]byte, len(request))
+		for i, item := range request {
+			reqKeys[i] = item.Key
+		}
+		values, err := dl.getStateItems(from, status.station, &getStateItemsData{Block: hash, Keys: reqKeys}, status.errCh)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(values) == 0 || len(values) > len(request) {
+			return nil, false, errStateUnavailable
+		}
+		for i, value := range values {
+			if len(value) == 0 || crypto.Keccak256Hash(value) != request[i].Hash {
+				dl.transport.SendTo(nil, nil, router.P2pBanPeer, status.station)
+				return nil, false, errInvalidStateItems
+			}
+		}
+		if err := dl.healItems(hash, reqKeys[:len(values)], values); err != nil {
+			return nil, false, err
+		}
+		missing = missing[len(values):]
+	}
+	if len(deleted) > 0 {
+		if err := dl.healItems(hash, deleted, make([][]byte, len(deleted))); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, item := range listed {
+		if err := hasher.Add(item.Key, item.Hash); err != nil {
+			return nil, false, err
+		}
+	}
+	if complete {
+		return nil, true, nil
+	}
+	var end []byte
+	if len(listed) > 0 {
+		end = listed[len(listed)-1].Key
+	}
+	if len(local) > 0 && bytes.Compare(local[len(local)-1].Key, end) > 0 {
+		end = local[len(local)-1].Key
+	}
+	return append(common.CopyBytes(end), 0), false, nil
+}
+
+// healItems writes the values of the keys to the state at the block, deleting
+// the keys with a nil value.
+func (dl *Downloader) healItems(hash common.Hash, keys, values [][]byte) error {
+	healed, err := state.HealStateItems(dl.blockchain.stateCache, hash, keys, values)
+	if err != nil {
+		return err
+	}
+	healHealedMeter.Mark(int64(healed))
+	dl.updateHeal(func(progress *StateHealProgress) { progress.Healed += uint64(healed) })
+	return nil
+}
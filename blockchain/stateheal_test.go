@@ -0,0 +1,172 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"math/big"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+// serveTransport answers the state requests from the state of a remote chain,
+// in replies of at most maxHashes keys and maxItems values.
+type serveTransport struct {
+	*testTransport
+	chain     *BlockChain
+	maxHashes uint64
+	maxItems  int
+	tamper    bool
+	banned    int
+}
+
+func (st *serveTransport) SendTo(from, to router.Station, typecode int, data interface{}) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var (
+		code  int
+		reply interface{}
+	)
+	switch typecode {
+	case router.P2pBanPeer:
+		st.banned++
+		return 1
+	case router.DownloaderGetStateHashesMsg:
+		query := *data.(*getStateHashesData)
+		if query.Amount > st.maxHashes {
+			query.Amount = st.maxHashes
+		}
+		code, reply = router.StateHashesMsg, serveStateHashes(st.chain, &query)
+	case router.DownloaderGetStateItemsMsg:
+		query := *data.(*getStateItemsData)
+		if len(query.Keys) > st.maxItems {
+			query.Keys = query.Keys[:st.maxItems]
+		}
+		values := serveStateItems(st.chain, &query)
+		if st.tamper {
+			values[0] = append(common.CopyBytes(values[0]), 1)
+		}
+		code, reply = router.StateItemsMsg, values
+	default:
+		return 0
+	}
+	ch := st.subs[from.Name()]
+	go func() { ch <- &router.Event{From: to, To: from, Typecode: code, Data: reply} }()
+	return 1
+}
+
+func TestStateHeal(t *testing.T) {
+	genesis, db, chain, st, err := newCanonical(t, tengine)
+	if err != nil {
+		t.Fatal("newCanonical err", err)
+	}
+	defer chain.Stop()
+
+	prods, ht := makeProduceAndTime(st, 1)
+	if _, _, _, err := makeNewChain(t, genesis, chain, &db, len(prods), ht, prods, makeTransferTx); err != nil {
+		t.Fatal("makeNewChain err", err)
+	}
+	head := chain.CurrentBlock()
+	want := stateItems(t, db, head.Hash())
+	var keys []string
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	commitment, err := chain.StateHash(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tamper     bool
+		commitment common.Hash
+	}{
+		{false, commitment},
+		{true, commitment},
+		{false, common.HexToHash("0x01")},
+	}
+	for _, tt := range tests {
+		tamper := tt.tamper
+		// the local copy of the chain lost a key, has a stale one and one the
+		// state doesn't have
+		newdb := db.(*fdb.MemDatabase).Copy()
+		newchain, err := NewBlockChain(newdb, nil, vm.Config{}, chain.Config(), txpool.SenderCacher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lost, stale := keys[len(keys)/3], keys[2*len(keys)/3]
+		newdb.Delete([]byte(lost))
+		newchain.stateCache.DeleteCache(lost)
+		newdb.Put([]byte(stale), []byte("stale"))
+		newchain.stateCache.PutCache(stale, []byte("stale"))
+		extra := keys[len(keys)/2] + "extra"
+		newdb.Put([]byte(extra), []byte("extra"))
+
+		transport := &serveTransport{
+			testTransport: &testTransport{subs: make(map[string]chan *router.Event)},
+			chain:         chain,
+			maxHashes:     7,
+			maxItems:      1,
+			tamper:        tamper,
+		}
+		remote := router.NewRemoteStation("healremote", nil)
+		dl := &Downloader{
+			blockchain: newchain,
+			config:     DefaultDownloaderConfig,
+			clock:      newTestClock(),
+			transport:  transport,
+			remotes:    map[string]*stationStatus{remote.Name(): newStationStatus(remote, new(big.Int), head.NumberU64(), head.Hash())},
+		}
+		dl.heal = StateHealProgress{Block: head.Hash(), Number: head.NumberU64(), Healing: true}
+		dl.healState(head.Hash(), tt.commitment)
+
+		progress := dl.HealProgress()
+		if tt.commitment != commitment {
+			if progress.Done || progress.Healing || progress.Scanned != maxHealRetries*uint64(len(keys)) {
+				t.Errorf("heal against another commitment: progress %+v", progress)
+			}
+		} else if tamper {
+			if progress.Done || progress.Healing || progress.Healed != 0 || transport.banned == 0 {
+				t.Errorf("tampered heal: progress %+v, %d bans", progress, transport.banned)
+			}
+			if value, _ := newdb.Get([]byte(stale)); string(value) != "stale" {
+				t.Errorf("tampered value healed: %q", value)
+			}
+		} else {
+			if !progress.Done || progress.Healing || progress.Scanned != uint64(len(keys)) ||
+				progress.Missing != 3 || progress.Healed != 3 {
+				t.Errorf("heal progress mismatch: %+v, %d keys", progress, len(keys))
+			}
+			if have := stateItems(t, newdb, head.Hash()); !reflect.DeepEqual(have, want) {
+				t.Error("state mismatch after heal")
+			}
+			for _, key := range []string{lost, stale} {
+				if value, err := newchain.stateCache.Get(key); err != nil || string(value) != want[key] {
+					t.Errorf("key %q: cached value mismatch", key)
+				}
+			}
+		}
+		newchain.Stop()
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import "fmt"
+
+// SyncMode represents the synchronisation mode of the downloader.
+type SyncMode uint32
+
+const (
+	FullSync  SyncMode = iota // Synchronise the entire chain by executing every block
+	FastSync                  // Apply the verified state changes of old blocks, execute the recent ones
+	LightSync                 // Download only the headers, needs a header-only chain database
+)
+
+// fastSyncMinFullBlocks is the number of blocks below the remote head which
+// are executed in full, even in fast sync mode.
+const fastSyncMinFullBlocks = 64
+
+// SyncModeEvent is posted when the downloader switches its sync mode.
+type SyncModeEvent struct {
+	From SyncMode
+	To   SyncMode
+}
+
+// IsValid reports whether the mode is known.
+func (mode SyncMode) IsValid() bool {
+	return mode >= FullSync && mode <= LightSync
+}
+
+// String implements the stringer interface.
+func (mode SyncMode) String() string {
+	switch mode {
+	case FullSync:
+		return "full"
+	case FastSync:
+		return "fast"
+	case LightSync:
+		return "light"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the mode as its name.
+func (mode SyncMode) MarshalText() ([]byte, error) {
+	if !mode.IsValid() {
+		return nil, fmt.Errorf("unknown sync mode %d", mode)
+	}
+	return []byte(mode.String()), nil
+}
+
+// UnmarshalText decodes the mode from its name.
+func (mode *SyncMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "full":
+		*mode = FullSync
+	case "fast":
+		*mode = FastSync
+	case "light":
+		*mode = LightSync
+	default:
+		return fmt.Errorf(`unknown sync mode %q, want "full", "fast" or "light"`, text)
+	}
+	return nil
+}
@@ -113,7 +113,7 @@ func newCanonical(t *testing.T, engine consensus.IEngine) (*Genesis, fdb.Databas
 	)
 
 	// Initialize a fresh chain with only a genesis block
-	blockchain, _ := NewBlockChain(db, vm.Config{}, params.DefaultChainconfig, txpool.SenderCacher)
+	blockchain, _ := NewBlockChain(db, nil, vm.Config{}, params.DefaultChainconfig, txpool.SenderCacher)
 
 	type bc struct {
 		*BlockChain
@@ -121,8 +121,6 @@ func newCanonical(t *testing.T, engine consensus.IEngine) (*Genesis, fdb.Databas
 	}
 
 	// Create validator and txProcessor
-	cfg := blockchain.Config()
-	cfg.SysTokenID = 1
 	validator := processor.NewBlockValidator(&bc{blockchain, engine}, engine)
 	txProcessor := processor.NewStateProcessor(&bc{blockchain, engine}, engine)
 
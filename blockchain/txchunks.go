@@ -0,0 +1,267 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+const (
+	// chunkedBodyGas is the gas used from which the transactions of a block
+	// are downloaded by chunks from several remotes instead of from one.
+	chunkedBodyGas = 10000000
+	// maxTxChunks bounds the number of chunks the transactions of a block are
+	// split in, one more remote serves the parity of the chunks.
+	maxTxChunks = 8
+	// minTxChunks is the number of chunks below which a block is downloaded
+	// from a single remote.
+	minTxChunks = 2
+	// maxTxChunkElems bounds the length of the lists decoded from a chunk
+	// received from a remote.
+	maxTxChunkElems = 1 << 16
+)
+
+var errTxChunk = errors.New("invalid transaction chunk")
+
+// txChunkBounds returns the range of the transactions in the chunk of the
+// transactions of a block split in the given number of chunks.
+func txChunkBounds(txs, chunks, index int) (int, int) {
+	return index * txs / chunks, (index + 1) * txs / chunks
+}
+
+// encodeTxChunks splits the transactions in chunks and encodes them.
+func encodeTxChunks(txs []*types.Transaction, chunks int) ([][]byte, error) {
+	encoded := make([][]byte, chunks)
+	for i := range encoded {
+		start, end := txChunkBounds(len(txs), chunks, i)
+		data, err := rlp.EncodeToBytes(txs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = data
+	}
+	return encoded, nil
+}
+
+// txChunksParity returns the byte-wise XOR of the chunks padded to the longest
+// one, and the lengths of the chunks. Any chunk is the XOR of the parity and
+// the other chunks, so the transactions of a block can be rebuilt from all the
+// chunks but one and the parity.
+func txChunksParity(chunks [][]byte) ([]byte, []uint64) {
+	var (
+		parity  []byte
+		lengths = make([]uint64, len(chunks))
+	)
+	for i, chunk := range chunks {
+		lengths[i] = uint64(len(chunk))
+		if len(chunk) > len(parity) {
+			parity = append(parity, make([]byte, len(chunk)-len(parity))...)
+		}
+		for j, b := range chunk {
+			parity[j] ^= b
+		}
+	}
+	return parity, lengths
+}
+
+// decodeTxChunk decodes a chunk of transactions and verifies their hashes
+// against the ones listed for the chunk.
+func decodeTxChunk(data []byte, hashes []common.Hash) ([]*types.Transaction, error) {
+	var txs []*types.Transaction
+	if err := rlp.DecodeBytesLimit(data, &txs, maxTxChunkElems); err != nil {
+		return nil, err
+	}
+	if len(txs) != len(hashes) {
+		return nil, fmt.Errorf("%v: %d transactions, want %d", errTxChunk, len(txs), len(hashes))
+	}
+	for i, tx := range txs {
+		if tx.Hash() != hashes[i] {
+			return nil, fmt.Errorf("%v: transaction %d hash mismatch", errTxChunk, i)
+		}
+	}
+	return txs, nil
+}
+
+// txChunkPeers returns the remotes to download the transactions of the block
+// at the number from by chunks, the worker first, or nil if too few remotes
+// serve chunks of the block.
+func (dl *Downloader) txChunkPeers(worker *stationStatus, number uint64) []*stationStatus {
+	if !worker.serves(CapTxChunks) {
+		return nil
+	}
+	peers := []*stationStatus{worker}
+	dl.remotesMutex.RLock()
+	for _, status := range dl.remotes {
+		if len(peers) > maxTxChunks {
+			break
+		}
+		if _, current, _ := status.getStatus(); status != worker && current >= number && status.serves(CapTxChunks) {
+			peers = append(peers, status)
+		}
+	}
+	dl.remotesMutex.RUnlock()
+	if len(peers) < minTxChunks+1 {
+		return nil
+	}
+	return peers
+}
+
+// fetchTxChunks downloads the transactions of the block by chunks spread
+// across the remotes. The transaction hashes are fetched from the first
+// remote and verified against the header, each of the other remotes serves a
+// chunk verified against the hashes, and the last one the parity of the
+// chunks, so that the transactions are complete without the slowest remote.
+func (dl *Downloader) fetchTxChunks(from router.Station, header *types.Header, peers []*stationStatus) ([]*types.Transaction, error) {
+	hashes, err := dl.getBlockTxHashes(from, peers[0].station, header.Hash(), peers[0].errCh)
+	if err != nil {
+		return nil, err
+	}
+	if common.MerkleRoot(hashes) != header.TxsRoot {
+		return nil, fmt.Errorf("%v: hashes don't match the transactions root", errTxChunk)
+	}
+	if len(hashes) == 0 {
+		return []*types.Transaction{}, nil
+	}
+	chunks := len(peers) - 1
+	if chunks > len(hashes) {
+		chunks = len(hashes)
+	}
+	if chunks < minTxChunks {
+		// too few transactions to split, the first remote serves them all
+		return dl.fetchTxChunk(from, peers[0], header.Hash(), hashes, 1, 0)
+	}
+	chunkHashes := func(index int) []common.Hash {
+		start, end := txChunkBounds(len(hashes), chunks, index)
+		return hashes[start:end]
+	}
+
+	type result struct {
+		index int
+		reply *blockTxChunkData
+		err   error
+	}
+	results := make(chan result, chunks+1)
+	for i := 0; i <= chunks; i++ {
+		// the remote which served the hashes serves the parity
+		peer := peers[0]
+		if i < chunks {
+			peer = peers[i+1]
+		}
+		go func(index int, peer *stationStatus) {
+			station := router.NewLocalStation(fmt.Sprintf("txchunk%x%d%s", header.Hash().Bytes()[:8], index, peer.station.Name()), nil)
+			dl.transport.StationRegister(station)
+			defer dl.transport.StationUnregister(station)
+			reply, err := dl.getBlockTxChunk(station, peer.station, &getBlockTxChunkData{
+				Block:  header.Hash(),
+				Chunks: uint64(chunks),
+				Index:  uint64(index),
+			}, peer.errCh)
+			results <- result{index, reply, err}
+		}(i, peer)
+	}
+
+	var (
+		txs    = make([][]*types.Transaction, chunks)
+		data   = make([][]byte, chunks)
+		parity *blockTxChunkData
+		have   int
+		failed int
+	)
+	for have < chunks {
+		if failed > 1 {
+			return nil, fmt.Errorf("%v: %d remotes failed", errTxChunk, failed)
+		}
+		if have == chunks-1 && parity != nil {
+			// rebuild the missing chunk from the parity and the others, an
+			// invalid parity counts as a failed remote
+			index := 0
+			for txs[index] != nil {
+				index++
+			}
+			chunk, err := rebuildTxChunk(parity, data, index, chunkHashes(index))
+			if err == nil {
+				txs[index] = chunk
+				break
+			}
+			parity = nil
+			failed++
+			continue
+		}
+		res := <-results
+		switch {
+		case res.err != nil || res.reply == nil || len(res.reply.Data) == 0 || res.reply.Index != uint64(res.index):
+			failed++
+		case res.index == chunks:
+			parity = res.reply
+		default:
+			chunk, err := decodeTxChunk(res.reply.Data, chunkHashes(res.index))
+			if err != nil {
+				failed++
+				continue
+			}
+			txs[res.index], data[res.index] = chunk, res.reply.Data
+			have++
+		}
+	}
+	all := make([]*types.Transaction, 0, len(hashes))
+	for _, chunk := range txs {
+		all = append(all, chunk...)
+	}
+	return all, nil
+}
+
+// rebuildTxChunk rebuilds the chunk at the index from the parity and the
+// other chunks and verifies it against the hashes.
+func rebuildTxChunk(parity *blockTxChunkData, data [][]byte, index int, hashes []common.Hash) ([]*types.Transaction, error) {
+	if len(parity.Lengths) != len(data) || parity.Lengths[index] > uint64(len(parity.Data)) {
+		return nil, fmt.Errorf("%v: parity lengths mismatch", errTxChunk)
+	}
+	rebuilt := append([]byte{}, parity.Data...)
+	for i, chunk := range data {
+		if i == index {
+			continue
+		}
+		if len(chunk) > len(rebuilt) {
+			return nil, fmt.Errorf("%v: chunk longer than parity", errTxChunk)
+		}
+		for j, b := range chunk {
+			rebuilt[j] ^= b
+		}
+	}
+	return decodeTxChunk(rebuilt[:parity.Lengths[index]], hashes)
+}
+
+// fetchTxChunk downloads a single chunk of the transactions of the block from
+// the remote and verifies it against the hashes.
+func (dl *Downloader) fetchTxChunk(from router.Station, peer *stationStatus, block common.Hash, hashes []common.Hash, chunks, index int) ([]*types.Transaction, error) {
+	reply, err := dl.getBlockTxChunk(from, peer.station, &getBlockTxChunkData{
+		Block:  block,
+		Chunks: uint64(chunks),
+		Index:  uint64(index),
+	}, peer.errCh)
+	if err != nil {
+		return nil, err
+	}
+	start, end := txChunkBounds(len(hashes), chunks, index)
+	return decodeTxChunk(reply.Data, hashes[start:end])
+}
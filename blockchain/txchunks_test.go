@@ -0,0 +1,144 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// chunkTransport serves the transactions of a block by chunks from every
+// remote, except the silent ones which never reply and the corrupt ones which
+// reply with chunks of other transactions.
+type chunkTransport struct {
+	mu      sync.Mutex
+	subs    map[string]chan *router.Event
+	txs     []*types.Transaction
+	silent  map[string]bool
+	corrupt map[string]bool
+}
+
+func (ct *chunkTransport) Subscribe(station router.Station, ch chan *router.Event, typecode int, data interface{}) router.Subscription {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.subs[station.Name()] = ch
+	return &chunkSubscription{}
+}
+
+func (ct *chunkTransport) SendTo(from, to router.Station, typecode int, data interface{}) int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if ct.silent[to.Name()] {
+		return 1
+	}
+	reply := &router.Event{From: to, To: from}
+	switch typecode {
+	case router.DownloaderGetBlockTxHashesMsg:
+		hashes := make([]common.Hash, len(ct.txs))
+		for i, tx := range ct.txs {
+			hashes[i] = tx.Hash()
+		}
+		reply.Typecode, reply.Data = router.BlockTxHashesMsg, hashes
+	case router.DownloaderGetBlockTxChunkMsg:
+		req := data.(*getBlockTxChunkData)
+		txs := ct.txs
+		if ct.corrupt[to.Name()] {
+			txs = append([]*types.Transaction{}, txs...)
+			txs[0], txs[len(txs)-1] = txs[len(txs)-1], txs[0]
+		}
+		chunks, _ := encodeTxChunks(txs, int(req.Chunks))
+		chunk := &blockTxChunkData{Index: req.Index}
+		if req.Index < req.Chunks {
+			chunk.Data = chunks[req.Index]
+		} else {
+			chunk.Data, chunk.Lengths = txChunksParity(chunks)
+		}
+		reply.Typecode, reply.Data = router.BlockTxChunkMsg, chunk
+	default:
+		return 0
+	}
+	ch := ct.subs[from.Name()]
+	go func() { ch <- reply }()
+	return 1
+}
+
+func (ct *chunkTransport) StationRegister(station router.Station)   {}
+func (ct *chunkTransport) StationUnregister(station router.Station) {}
+
+type chunkSubscription struct{}
+
+func (s *chunkSubscription) Err() <-chan error { return nil }
+func (s *chunkSubscription) Unsubscribe()      {}
+
+func TestFetchTxChunks(t *testing.T) {
+	var txs []*types.Transaction
+	for i := 0; i < 10; i++ {
+		action := types.NewAction(types.Transfer, common.StrToName("chunkfromtest"), common.StrToName("chunktotest1"), uint64(i), 0, 30000, big.NewInt(1), nil)
+		txs = append(txs, types.NewTransaction(0, big.NewInt(1), action))
+	}
+	header := &types.Header{Number: big.NewInt(1), TxsRoot: types.DeriveTxMerkleRoot(txs)}
+
+	tests := []struct {
+		silent, corrupt []int
+		fail            bool
+	}{
+		{},
+		{silent: []int{2}},                 // a chunk rebuilt from the parity
+		{corrupt: []int{0}},                // the parity is not needed
+		{corrupt: []int{3}},                // an invalid chunk rebuilt from the parity
+		{corrupt: []int{1, 3}, fail: true}, // two chunks missing
+		{corrupt: []int{0, 3}, fail: true}, // the parity and a chunk missing
+	}
+	for i, tt := range tests {
+		transport := &chunkTransport{
+			subs:    make(map[string]chan *router.Event),
+			txs:     txs,
+			silent:  make(map[string]bool),
+			corrupt: make(map[string]bool),
+		}
+		dl := &Downloader{config: DefaultDownloaderConfig, clock: systemClock{}, transport: transport, remotes: make(map[string]*stationStatus)}
+		var peers []*stationStatus
+		for j := 0; j < 4; j++ {
+			peers = append(peers, newStationStatus(router.NewRemoteStation(fmt.Sprintf("chunk%d-%d", i, j), nil), new(big.Int), 1, common.Hash{}))
+		}
+		for _, j := range tt.silent {
+			transport.silent[peers[j].station.Name()] = true
+		}
+		for _, j := range tt.corrupt {
+			transport.corrupt[peers[j].station.Name()] = true
+		}
+		have, err := dl.fetchTxChunks(router.NewLocalStation(fmt.Sprintf("chunklocal%d", i), nil), header, peers)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("test %d: fetched despite missing chunks", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %d: failed to fetch: %v", i, err)
+		}
+		if types.DeriveTxMerkleRoot(have) != header.TxsRoot {
+			t.Fatalf("test %d: transactions mismatch", i)
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/node"
 	"github.com/fractalplatform/fractal/utils/console"
 	"github.com/fractalplatform/fractal/wallet"
 	"github.com/fractalplatform/fractal/wallet/cache"
@@ -274,7 +275,9 @@ func getWallet() (*wallet.Wallet, error) {
 	case filepath.IsAbs(ftconfig.NodeCfg.KeyStoreDir):
 		keydir = ftconfig.NodeCfg.KeyStoreDir
 	case ftconfig.NodeCfg.DataDir != "":
-		if ftconfig.NodeCfg.KeyStoreDir == "" {
+		if ftconfig.NodeCfg.KeyStoreDir == "" && ftconfig.NodeCfg.DataLayout == node.LayoutInstance {
+			keydir = filepath.Join(ftconfig.NodeCfg.InstanceDir(), "keystore")
+		} else if ftconfig.NodeCfg.KeyStoreDir == "" {
 			keydir = filepath.Join(ftconfig.NodeCfg.DataDir, "keystore")
 		} else {
 			keydir, err = filepath.Abs(ftconfig.NodeCfg.KeyStoreDir)
@@ -0,0 +1,73 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/utils/fdb"
+	"github.com/spf13/cobra"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// compactReceiptsCmd represents the compactreceipts command
+var compactReceiptsCmd = &cobra.Command{
+	Use:   "compactreceipts",
+	Short: "Compress the stored receipts with a shared dictionary per epoch",
+	Long: `Rewrite the receipts and logs of each epoch of 4096 blocks as per block bundles deflated with a
+dictionary shared by the epoch, trained on its receipts. Receipts stored by older versions in the
+legacy layout and the bundles compressed on their own are both rewritten.
+The node must be stopped. An interrupted compaction continues where it stopped when run again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := compactReceipts(); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(compactReceiptsCmd)
+	compactReceiptsCmd.Flags().StringVarP(&ftconfig.NodeCfg.DataDir, "datadir", "d", defaultDataDir(), "Data directory for the databases and keystore")
+}
+
+func compactReceipts() error {
+	path := filepath.Join(ftconfig.NodeCfg.DataDir, ftconfig.NodeCfg.Name, "chaindata")
+	db, err := fdb.NewLDBDatabase(path, ftconfig.FtServiceCfg.DatabaseCache, makeDatabaseHandles())
+	if err != nil {
+		return fmt.Errorf("Failed to open database %v: %v", path, err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	err = rawdb.CompactReceipts(db, func(epochs, compacted int, saved uint64) {
+		fmt.Printf("Compacted %d epochs, %d blocks, saved %v, elapsed %v\n", epochs, compacted, common.StorageSize(saved), time.Since(start).Round(time.Second))
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to compact receipts: %v", err)
+	}
+	fmt.Println("Compacting database")
+	if err := db.LDB().CompactRange(util.Range{}); err != nil {
+		return fmt.Errorf("Failed to compact database: %v", err)
+	}
+	fmt.Printf("Receipt compaction done, elapsed %v\n", time.Since(start).Round(time.Second))
+	return nil
+}
@@ -16,21 +16,16 @@
 package main
 
 import (
-	"io"
-	"os"
-
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/ftservice"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/node"
-	colorable "github.com/mattn/go-colorable"
-	"github.com/mattn/go-isatty"
 )
 
-var glogger *log.GlogHandler
-
 type ftConfig struct {
 	ConfigFileFlag  string
 	GenesisFileFlag string
+	NetworkFlag     string
+	InstancesFlag   []string
 	NodeCfg         *node.Config
 	FtServiceCfg    *ftservice.Config
 }
@@ -41,31 +36,36 @@ type LogConfig struct {
 	Level        int    `mapstructure:"log-level"`
 	Vmodule      string `mapstructure:"log-vmodule"`
 	BacktraceAt  string `mapstructure:"log-backtraceat"`
+	JSON         bool   `mapstructure:"log-json"`
+	File         string `mapstructure:"log-file"`
+	MaxSize      int    `mapstructure:"log-maxsize"`
+	MaxBackups   int    `mapstructure:"log-maxbackups"`
+}
+
+// DebugConfig diagnostics config
+type DebugConfig struct {
+	PProfAddr string `mapstructure:"debug-pprofaddr"`
 }
 
 func defaultLogConfig() *LogConfig {
 	return &LogConfig{
 		PrintOrigins: false,
 		Level:        3,
+		MaxSize:      100,
+		MaxBackups:   10,
 	}
 }
 
-func init() {
-	usecolor := (isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd())) && os.Getenv("TERM") != "dumb"
-	output := io.Writer(os.Stderr)
-	if usecolor {
-		output = colorable.NewColorableStderr()
-	}
-
-	glogger = log.NewGlogHandler(log.StreamHandler(output, log.TerminalFormat(usecolor)))
-}
-
 //Setup initializes logging based on the LogConfig
-func (lc *LogConfig) Setup() {
-	// logging
-	log.PrintOrigins(lc.PrintOrigins)
-	glogger.Verbosity(log.Lvl(lc.Level))
-	glogger.Vmodule(lc.Vmodule)
-	glogger.BacktraceAt(lc.BacktraceAt)
-	log.Root().SetHandler(glogger)
+func (lc *LogConfig) Setup() error {
+	return log.Setup(&log.Config{
+		Level:        lc.Level,
+		Vmodule:      lc.Vmodule,
+		BacktraceAt:  lc.BacktraceAt,
+		PrintOrigins: lc.PrintOrigins,
+		JSON:         lc.JSON,
+		File:         lc.File,
+		MaxSize:      lc.MaxSize,
+		MaxBackups:   lc.MaxBackups,
+	})
 }
@@ -0,0 +1,169 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The configuration is built from, in increasing priority, the defaults,
+// the config file, the environment and the command line flags. An
+// environment variable is named after the config file key in upper case,
+// with dashes replaced by underscores and prefixed with FT_, e.g.
+// FT_NODE_HTTPPORT for node-httpport.
+const envPrefix = "ft"
+
+// configSection is a part of the configuration whose fields are keyed by
+// their mapstructure tags.
+type configSection struct {
+	name  string
+	value interface{}
+}
+
+func configSections() []configSection {
+	return append([]configSection{
+		{"log", logConfig},
+		{"debug", debugConfig},
+	}, ftconfig.sections()...)
+}
+
+// sections returns the sections configuring a chain instance.
+func (c *ftConfig) sections() []configSection {
+	return []configSection{
+		{"node", c.NodeCfg},
+		{"p2p", c.NodeCfg.P2PConfig},
+		{"ftservice", c.FtServiceCfg},
+		{"downloader", c.FtServiceCfg.Downloader},
+		{"txpool", c.FtServiceCfg.TxPool},
+		{"miner", c.FtServiceCfg.Miner},
+		{"gasprice", &c.FtServiceCfg.GasPrice},
+		{"metrics", c.FtServiceCfg.MetricsConf},
+	}
+}
+
+// configKeys returns the config file keys of the section fields in order.
+func configKeys(section interface{}) (keys []string, values []reflect.Value) {
+	v := reflect.ValueOf(section).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("mapstructure")
+		if key == "" || key == "-" || strings.Contains(key, ",") {
+			continue
+		}
+		keys, values = append(keys, key), append(values, v.Field(i))
+	}
+	return keys, values
+}
+
+// loadConfig applies the config file and the environment to the
+// configuration, then the flags given on the command line.
+func loadConfig() error {
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	for _, section := range configSections() {
+		keys, _ := configKeys(section.value)
+		for _, key := range keys {
+			viper.BindEnv(key)
+		}
+	}
+	for _, section := range configSections() {
+		if err := viper.Unmarshal(section.value); err != nil {
+			return fmt.Errorf("Unmarshal %s config err: %v", section.name, err)
+		}
+	}
+
+	flags := pflag.NewFlagSet("ft", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	addFlags(flags)
+	return flags.Parse(os.Args[1:])
+}
+
+// loadInstanceConfig loads the configuration of a further chain instance
+// from its config file, applied to the defaults. The file takes the keys of
+// the instance sections of the config file, and the network preset or the
+// genesis file of the instance as network and genesis.
+func loadInstanceConfig(file string) (*ftConfig, error) {
+	cfg := defaultFtConfig()
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	for _, section := range cfg.sections() {
+		if err := v.Unmarshal(section.value); err != nil {
+			return nil, fmt.Errorf("Unmarshal %s config err: %v", section.name, err)
+		}
+	}
+	cfg.ConfigFileFlag = file
+	cfg.NetworkFlag = v.GetString("network")
+	cfg.GenesisFileFlag = v.GetString("genesis")
+	return cfg, nil
+}
+
+// dumpConfigCmd represents the dumpconfig command
+var dumpConfigCmd = &cobra.Command{
+	Use:   "dumpconfig",
+	Short: "Print the effective configuration as a config file",
+	Long: `Print the configuration resulting from the defaults, the config file, the
+FT_ prefixed environment variables and the flags, in the format of the config file.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := loadConfig(); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := dumpConfig(); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(dumpConfigCmd)
+	addFlags(dumpConfigCmd.Flags())
+}
+
+func dumpConfig() error {
+	for i, section := range configSections() {
+		var items yaml.MapSlice
+		keys, values := configKeys(section.value)
+		for j, key := range keys {
+			value := values[j].Interface()
+			if d, ok := value.(time.Duration); ok {
+				value = d.String()
+			}
+			items = append(items, yaml.MapItem{Key: key, Value: value})
+		}
+		out, err := yaml.Marshal(items)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("# %s\n%s", section.name, out)
+	}
+	return nil
+}
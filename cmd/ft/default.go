@@ -19,9 +19,10 @@ package main
 import (
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
+	"github.com/fractalplatform/fractal/blockchain"
 	"github.com/fractalplatform/fractal/ftservice"
 	"github.com/fractalplatform/fractal/ftservice/gasprice"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/metrics"
 	"github.com/fractalplatform/fractal/node"
 	"github.com/fractalplatform/fractal/p2p"
@@ -33,6 +34,9 @@ var (
 	// log config
 	logConfig = defaultLogConfig()
 
+	// diagnostics config
+	debugConfig = &DebugConfig{}
+
 	//ft config
 	ftconfig = defaultFtConfig()
 )
@@ -46,10 +50,23 @@ func defaultFtConfig() *ftConfig {
 
 func defaultFtServiceConfig() *ftservice.Config {
 	return &ftservice.Config{
-		DatabaseHandles: makeDatabaseHandles(),
-		DatabaseCache:   768,
-		TxPool:          defaultTxPoolConfig(),
-		Miner:           defaultMinerConfig(),
+		DatabaseHandles:    makeDatabaseHandles(),
+		DatabaseCache:      768,
+		StateCache:         256,
+		StateCommitCache:   64,
+		FutureBlockDrift:   30,
+		MaxClockDrift:      1000,
+		SyncMode:           "full",
+		ForkRule:           "td",
+		CheckpointInterval: 1000,
+		MaxReplayBlocks:    1024,
+		MaxRevertBlocks:    8192,
+		ReadyMaxBlockAge:   60,
+		MQBridgeTopic:      "fractal",
+		WebhookRetries:     5,
+		Downloader:         defaultDownloaderConfig(),
+		TxPool:             defaultTxPoolConfig(),
+		Miner:              defaultMinerConfig(),
 		GasPrice: gasprice.Config{
 			Blocks:     20,
 			Percentile: 60,
@@ -75,19 +92,27 @@ func defaultNodeConfig() *node.Config {
 		WSModules: []string{"ft"},
 		Logger:    log.New(),
 
+		AuthModules: []string{"admin", "debug", "keystore", "miner", "p2p", "personal"},
+
 		P2PConfig: defaultP2pConfig(),
 	}
 }
 
 func defaultP2pConfig() *p2p.Config {
 	cfg := &p2p.Config{
-		MaxPeers:   10,
-		Name:       "Fractal-P2P",
-		ListenAddr: ":2018",
+		MaxPeers:    10,
+		Name:        "Fractal-P2P",
+		ListenAddr:  ":2018",
+		BanDuration: 24 * time.Hour,
 	}
 	return cfg
 }
 
+func defaultDownloaderConfig() *blockchain.DownloaderConfig {
+	cfg := blockchain.DefaultDownloaderConfig
+	return &cfg
+}
+
 func defaultTxPoolConfig() *txpool.Config {
 	return &txpool.Config{
 		Journal:   "transactions.rlp",
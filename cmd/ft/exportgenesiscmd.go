@@ -0,0 +1,101 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/fractalplatform/fractal/blockchain"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/utils/fdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportGenesisNumber  uint64
+	exportGenesisChainID uint64
+)
+
+// exportGenesisCmd represents the exportgenesis command
+var exportGenesisCmd = &cobra.Command{
+	Use:   "exportgenesis <file>",
+	Short: "Export the state at a block as the genesis of a new chain",
+	Long: `Export the accounts, assets, balances and producers of the canonical chain at
+--number, the head block by default, into a genesis file for launching a new chain,
+e.g. a fork or a test network seeded from the main network. Contract code and storage
+aren't exported. The node must be stopped and the state at --number must not have
+been pruned. The new chain needs its own --chainid, as the nonces start over.
+Forks already activated are active from the new genesis.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exportGenesis(args[0]); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exportGenesisCmd)
+	exportGenesisCmd.Flags().StringVarP(&ftconfig.NodeCfg.DataDir, "datadir", "d", defaultDataDir(), "Data directory for the databases and keystore")
+	exportGenesisCmd.Flags().Uint64Var(&exportGenesisNumber, "number", 0, "Number of the exported block, 0 for the head block")
+	exportGenesisCmd.Flags().Uint64Var(&exportGenesisChainID, "chainid", 0, "Chain id of the new chain, required to differ from the exported chain")
+}
+
+func exportGenesis(file string) error {
+	path := filepath.Join(ftconfig.NodeCfg.DataDir, ftconfig.NodeCfg.Name, "chaindata")
+	db, err := fdb.NewReadOnlyLDBDatabase(path, ftconfig.FtServiceCfg.DatabaseCache, makeDatabaseHandles())
+	if err != nil {
+		return fmt.Errorf("Failed to open database %v: %v", path, err)
+	}
+	defer db.Close()
+
+	number := exportGenesisNumber
+	if number == 0 {
+		head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db))
+		if head == nil {
+			return errors.New("head block not found")
+		}
+		number = *head
+	}
+	genesis, err := blockchain.ExportGenesis(db, number, new(big.Int).SetUint64(exportGenesisChainID))
+	if err != nil {
+		return fmt.Errorf("Failed to export genesis: %v", err)
+	}
+	data, err := json.MarshalIndent(genesis, "", "  ")
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return err
+	}
+	fmt.Printf("Exported block %d: %d accounts, %d assets, %d producers\n", number, len(genesis.AllocAccounts), len(genesis.AllocAssets), len(genesis.AllocProducers))
+	return nil
+}
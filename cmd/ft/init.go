@@ -17,21 +17,37 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/fractalplatform/fractal/blockchain"
+	"github.com/fractalplatform/fractal/utils/fdb"
 	"github.com/spf13/cobra"
 )
 
-var dataDir string
+var (
+	dataDir    string
+	allocFiles []string
+	genesisOut string
+)
 
 // initCmd represents the init command
 var initCmd = &cobra.Command{
 	Use:   "init <genesisPath>",
 	Short: "Bootstrap and initialize a new genesis block",
-	Long:  `Bootstrap and initialize a new genesis block`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Bootstrap and initialize a new genesis block.
+Accounts, keys, assets and balances migrated from other chains can be added
+in bulk with --alloc, from CSV rows of name,pubkey[,asset,amount] or JSON
+files in the genesis allocAccounts/allocAssets layout. Balances are
+transferred from the asset owners, whose supply must cover them. Use --out to
+save the merged genesis for other nodes of the network, or admin.importGenesisAlloc
+to merge the allocations on a running node.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := initGenesis(args); err != nil {
 			fmt.Println(err)
@@ -42,6 +58,8 @@ var initCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(initCmd)
 	initCmd.Flags().StringVarP(&ftconfig.NodeCfg.DataDir, "datadir", "d", defaultDataDir(), "Data directory for the databases and keystore")
+	initCmd.Flags().StringSliceVar(&allocFiles, "alloc", nil, "CSV or JSON files of accounts and assets to add to the genesis")
+	initCmd.Flags().StringVar(&genesisOut, "out", "", "File to write the merged genesis JSON to")
 }
 
 // initGenesis will initialise the given JSON format genesis file and writes it as
@@ -58,6 +76,47 @@ func initGenesis(args []string) error {
 	}
 	defer file.Close()
 
-	// todo init genesis
+	genesis := new(blockchain.Genesis)
+	if err := json.NewDecoder(file).Decode(genesis); err != nil {
+		return fmt.Errorf("invalid genesis file: %v(%v)", genesisPath, err)
+	}
+
+	start := time.Now()
+	for _, path := range allocFiles {
+		alloc, err := blockchain.ReadGenesisAllocFile(path)
+		if err != nil {
+			return fmt.Errorf("Failed to read allocation %v: %v", path, err)
+		}
+		if err := genesis.Import(alloc); err != nil {
+			return fmt.Errorf("Failed to import allocation %v: %v", path, err)
+		}
+		fmt.Printf("Imported %d accounts, %d assets from %v\n", len(alloc.Accounts), len(alloc.Assets), path)
+	}
+	if err := genesis.ValidateAllocs(); err != nil {
+		return fmt.Errorf("invalid genesis file: %v(%v)", genesisPath, err)
+	}
+	if len(genesisOut) != 0 {
+		data, err := json.MarshalIndent(genesis, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(genesisOut, data, 0644); err != nil {
+			return fmt.Errorf("Failed to write genesis file: %v", err)
+		}
+	}
+
+	path := filepath.Join(ftconfig.NodeCfg.DataDir, ftconfig.NodeCfg.Name, "chaindata")
+	db, err := fdb.NewLDBDatabase(path, ftconfig.FtServiceCfg.DatabaseCache, makeDatabaseHandles())
+	if err != nil {
+		return fmt.Errorf("Failed to open database %v: %v", path, err)
+	}
+	defer db.Close()
+
+	accounts, assets := len(genesis.AllocAccounts), len(genesis.AllocAssets)
+	_, _, hash, err := blockchain.SetupGenesisBlock(db, genesis)
+	if err != nil {
+		return fmt.Errorf("Failed to write genesis block: %v", err)
+	}
+	fmt.Printf("Genesis %x with %d accounts, %d assets, elapsed %v\n", hash, accounts, assets, time.Since(start).Round(time.Millisecond))
 	return nil
 }
@@ -0,0 +1,80 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/utils/fdb"
+	"github.com/spf13/cobra"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	pruneKeep     uint64
+	pruneInterval uint64
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Prune the state changes of old blocks from the database",
+	Long: `Prune the state changes saved for blocks deeper than --keep below the head block.
+The chain can't be reorganised below the kept blocks, nor can pruned blocks be traced.
+With --interval the state stays readable at every interval-th block below, the state of
+the blocks in between is reconstructed by replaying the blocks from the closest one.
+The node must be stopped. An interrupted pruning continues where it stopped when run again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := pruneState(); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().StringVarP(&ftconfig.NodeCfg.DataDir, "datadir", "d", defaultDataDir(), "Data directory for the databases and keystore")
+	pruneCmd.Flags().Uint64Var(&pruneKeep, "keep", 4096, "Number of blocks below the head block whose state changes are kept")
+	pruneCmd.Flags().Uint64Var(&pruneInterval, "interval", 0, "Blocks between the pruned blocks whose state stays readable, 0 to keep none")
+}
+
+func pruneState() error {
+	path := filepath.Join(ftconfig.NodeCfg.DataDir, ftconfig.NodeCfg.Name, "chaindata")
+	db, err := fdb.NewLDBDatabase(path, ftconfig.FtServiceCfg.DatabaseCache, makeDatabaseHandles())
+	if err != nil {
+		return fmt.Errorf("Failed to open database %v: %v", path, err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	err = state.PruneStateOuts(db, pruneKeep, pruneInterval, func(checked, pruned int) {
+		fmt.Printf("Checked %d blocks, pruned %d, elapsed %v\n", checked, pruned, time.Since(start).Round(time.Second))
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to prune state: %v", err)
+	}
+	fmt.Println("Compacting database")
+	if err := db.LDB().CompactRange(util.Range{}); err != nil {
+		return fmt.Errorf("Failed to compact database: %v", err)
+	}
+	fmt.Printf("Pruning done, elapsed %v\n", time.Since(start).Round(time.Second))
+	return nil
+}
@@ -0,0 +1,283 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/ftclient"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/utils/fdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replayFrom uint64
+	replayTo   uint64
+)
+
+// replayAuditCmd represents the replayaudit command
+var replayAuditCmd = &cobra.Command{
+	Use:   "replayaudit <chain> <chain>",
+	Short: "Find the transactions included in both chains of a split",
+	Long: `Find the transactions the canonical blocks of two chains both include since their
+split, identical or with actions carrying the same signature, and report the accounts
+which sent them. A chain is a data directory, read with its node stopped, or the URL of
+the RPC endpoint of a node. Give --from, the first block after the split. The blocks of
+the first chain are indexed in memory.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := replayAudit(args[0], args[1]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(replayAuditCmd)
+	replayAuditCmd.Flags().Uint64Var(&replayFrom, "from", 1, "First block compared, the first block after the split")
+	replayAuditCmd.Flags().Uint64Var(&replayTo, "to", 0, "Last block compared, 0 for the lowest head of the chains")
+}
+
+// auditAction is an action of a transaction compared by the replay audit.
+type auditAction struct {
+	sender common.Name
+	sig    string // R and S of the signature, empty if unsigned
+}
+
+// auditTx is a transaction compared by the replay audit.
+type auditTx struct {
+	hash    common.Hash
+	number  uint64
+	actions []auditAction
+}
+
+// auditSource reads the transactions of the canonical blocks of a chain.
+type auditSource interface {
+	head() (uint64, error)
+	txs(number uint64) ([]*auditTx, error)
+	close()
+}
+
+func signatureKey(r, s *big.Int) string {
+	if r == nil || s == nil || (r.Sign() == 0 && s.Sign() == 0) {
+		return ""
+	}
+	return r.Text(16) + ":" + s.Text(16)
+}
+
+// dbAuditSource reads the chain of a data directory.
+type dbAuditSource struct {
+	db fdb.Database
+}
+
+func (s *dbAuditSource) head() (uint64, error) {
+	number := rawdb.ReadHeaderNumber(s.db, rawdb.ReadHeadBlockHash(s.db))
+	if number == nil {
+		return 0, errors.New("head block not found")
+	}
+	return *number, nil
+}
+
+func (s *dbAuditSource) txs(number uint64) ([]*auditTx, error) {
+	hash := rawdb.ReadCanonicalHash(s.db, number)
+	block := rawdb.ReadBlock(s.db, hash, number)
+	if block == nil {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	txs := make([]*auditTx, 0, len(block.Txs))
+	for _, tx := range block.Txs {
+		atx := &auditTx{hash: tx.Hash(), number: number}
+		for _, action := range tx.GetActions() {
+			_, r, s := action.RawSignatureValues()
+			atx.actions = append(atx.actions, auditAction{action.Sender(), signatureKey(r, s)})
+		}
+		txs = append(txs, atx)
+	}
+	return txs, nil
+}
+
+func (s *dbAuditSource) close() { s.db.Close() }
+
+// rpcAuditSource reads the chain of a node through its RPC endpoint.
+type rpcAuditSource struct {
+	client *ftclient.Client
+}
+
+func (s *rpcAuditSource) head() (uint64, error) {
+	block, err := s.client.CurrentBlock(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return block.Number.Uint64(), nil
+}
+
+func (s *rpcAuditSource) txs(number uint64) ([]*auditTx, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	block, err := s.client.BlockByNumber(ctx, rpc.BlockNumber(number))
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %v", number, err)
+	}
+	txs := make([]*auditTx, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		atx := &auditTx{hash: tx.Hash, number: number}
+		for _, action := range tx.RPCActions {
+			atx.actions = append(atx.actions, auditAction{action.From, signatureKey((*big.Int)(action.R), (*big.Int)(action.S))})
+		}
+		txs = append(txs, atx)
+	}
+	return txs, nil
+}
+
+func (s *rpcAuditSource) close() { s.client.Close() }
+
+// openAuditSource opens the chain of a data directory or, given a URL, of a
+// node.
+func openAuditSource(chain string) (auditSource, error) {
+	if strings.Contains(chain, "://") || strings.HasSuffix(chain, ".ipc") {
+		client, err := ftclient.Dial(chain)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to connect to %v: %v", chain, err)
+		}
+		return &rpcAuditSource{client}, nil
+	}
+	path := filepath.Join(chain, ftconfig.NodeCfg.Name, "chaindata")
+	db, err := fdb.NewReadOnlyLDBDatabase(path, ftconfig.FtServiceCfg.DatabaseCache, makeDatabaseHandles())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database %v: %v", path, err)
+	}
+	return &dbAuditSource{db}, nil
+}
+
+// replayFinding is a transaction of the second chain replaying one of the
+// first chain.
+type replayFinding struct {
+	first, second *auditTx
+	sameTx        bool // identical transactions, otherwise actions with the same signature
+}
+
+// findReplays returns the transactions of the blocks from to to of the second
+// chain which replay a transaction of the first chain, in block order.
+func findReplays(a, b auditSource, from, to uint64) ([]*replayFinding, error) {
+	byHash := make(map[common.Hash]*auditTx)
+	bySig := make(map[string]*auditTx)
+	for number := from; number <= to; number++ {
+		txs, err := a.txs(number)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range txs {
+			byHash[tx.hash] = tx
+			for _, action := range tx.actions {
+				if action.sig != "" {
+					bySig[action.sig] = tx
+				}
+			}
+		}
+	}
+
+	var findings []*replayFinding
+	for number := from; number <= to; number++ {
+		txs, err := b.txs(number)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range txs {
+			if first := byHash[tx.hash]; first != nil {
+				findings = append(findings, &replayFinding{first, tx, true})
+				continue
+			}
+			for _, action := range tx.actions {
+				if first := bySig[action.sig]; action.sig != "" && first != nil {
+					findings = append(findings, &replayFinding{first, tx, false})
+					break
+				}
+			}
+		}
+	}
+	return findings, nil
+}
+
+func replayAudit(chainA, chainB string) error {
+	a, err := openAuditSource(chainA)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	b, err := openAuditSource(chainB)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	to := replayTo
+	for _, source := range []auditSource{a, b} {
+		head, err := source.head()
+		if err != nil {
+			return err
+		}
+		if to == 0 || head < to {
+			to = head
+		}
+	}
+	if replayFrom == 0 || replayFrom > to {
+		return fmt.Errorf("invalid block range %d-%d", replayFrom, to)
+	}
+
+	start := time.Now()
+	findings, err := findReplays(a, b, replayFrom, to)
+	if err != nil {
+		return fmt.Errorf("Failed to compare the chains: %v", err)
+	}
+	accounts := make(map[common.Name]int)
+	for _, f := range findings {
+		kind := "transaction"
+		if !f.sameTx {
+			kind = "signature"
+		}
+		var senders []string
+		for _, action := range f.second.actions {
+			accounts[action.sender]++
+			senders = append(senders, action.sender.String())
+		}
+		fmt.Printf("Replayed %s %x: block %d of the first chain, %x in block %d of the second, accounts %s\n",
+			kind, f.first.hash, f.first.number, f.second.hash, f.second.number, strings.Join(senders, ","))
+	}
+
+	names := make([]common.Name, 0, len(accounts))
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	for _, name := range names {
+		fmt.Printf("Affected account %s: %d replayed actions\n", name, accounts[name])
+	}
+	fmt.Printf("Compared blocks %d-%d, found %d replayed transactions, elapsed %v\n", replayFrom, to, len(findings), time.Since(start).Round(time.Second))
+	return nil
+}
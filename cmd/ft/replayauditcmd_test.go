@@ -0,0 +1,95 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+// memAuditSource is a chain whose blocks are given by number.
+type memAuditSource map[uint64][]*auditTx
+
+func (s memAuditSource) head() (uint64, error) {
+	var head uint64
+	for number := range s {
+		if number > head {
+			head = number
+		}
+	}
+	return head, nil
+}
+
+func (s memAuditSource) txs(number uint64) ([]*auditTx, error) {
+	txs, ok := s[number]
+	if !ok {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	return txs, nil
+}
+
+func (s memAuditSource) close() {}
+
+func newAuditTx(hash byte, number uint64, sender, sig string) *auditTx {
+	return &auditTx{hash: common.Hash{hash}, number: number, actions: []auditAction{{common.Name(sender), sig}}}
+}
+
+func TestFindReplays(t *testing.T) {
+	a := memAuditSource{
+		1: {newAuditTx(1, 1, "alice", "1:1"), newAuditTx(2, 1, "bob", "2:2")},
+		2: {newAuditTx(3, 2, "carol", "3:3"), newAuditTx(4, 2, "dave", "")},
+		3: {newAuditTx(5, 3, "erin", "5:5")},
+	}
+	b := memAuditSource{
+		// the same transaction a block later
+		1: {newAuditTx(6, 1, "frank", "6:6")},
+		2: {newAuditTx(1, 2, "alice", "1:1")},
+		// another transaction with the signature of an action, and unsigned
+		// actions which never match
+		3: {newAuditTx(7, 3, "bob", "2:2"), newAuditTx(8, 3, "dave", "")},
+	}
+
+	findings, err := findReplays(a, b, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || !findings[0].sameTx || findings[0].first.number != 1 || findings[0].second.number != 2 {
+		t.Fatalf("findings mismatch: have %+v", findings)
+	}
+
+	findings, err = findReplays(a, b, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 2 {
+		t.Fatalf("findings mismatch: have %d, want 2", len(findings))
+	}
+	if f := findings[1]; f.sameTx || f.first.hash != (common.Hash{2}) || f.second.hash != (common.Hash{7}) {
+		t.Fatalf("signature replay mismatch: have %x in %x", f.second.hash, f.first.hash)
+	}
+
+	// a missing block of either chain fails the audit
+	if _, err := findReplays(a, b, 1, 4); err == nil {
+		t.Fatal("expected error for a missing block")
+	}
+	delete(b, 2)
+	if _, err := findReplays(a, b, 1, 3); err == nil {
+		t.Fatal("expected error for a missing block")
+	}
+}
@@ -18,20 +18,23 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/blockchain"
-	"github.com/fractalplatform/fractal/event"
 	"github.com/fractalplatform/fractal/ftservice"
+	"github.com/fractalplatform/fractal/internal/debug"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/metrics"
 	"github.com/fractalplatform/fractal/metrics/influxdb"
 	"github.com/fractalplatform/fractal/node"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -43,99 +46,120 @@ var RootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
-		if viper.ConfigFileUsed() != "" {
-			viperUmarshalConfig()
+		if err := loadConfig(); err != nil {
+			fmt.Println(err)
+			return
 		}
 
-		logConfig.Setup()
+		if err := logConfig.Setup(); err != nil {
+			fmt.Println("ft setup logging failed: ", err)
+			return
+		}
+		if debugConfig.PProfAddr != "" {
+			if _, err := debug.Start(debugConfig.PProfAddr); err != nil {
+				log.Error("ft start diagnostics failed.", "err", err)
+				return
+			}
+		}
 
-		event.InitRounter()
+		SetupMetrics()
 
-		node, err := makeNode()
-		if err != nil {
-			log.Error("ft make node failed.", "err", err)
+		configs := []*ftConfig{ftconfig}
+		for _, file := range ftconfig.InstancesFlag {
+			cfg, err := loadInstanceConfig(file)
+			if err != nil {
+				log.Error("ft load instance config failed.", "file", file, "err", err)
+				return
+			}
+			configs = append(configs, cfg)
+		}
+		if err := checkInstances(configs); err != nil {
+			log.Error("ft check instances failed.", "err", err)
 			return
 		}
 
-		if err := registerService(node); err != nil {
-			log.Error("ft start node failed.", "err", err)
-			return
+		var nodes []*node.Node
+		for _, cfg := range configs {
+			node, err := makeNode(cfg)
+			if err != nil {
+				log.Error("ft make node failed.", "err", err)
+				return
+			}
+			if err := registerService(node, cfg); err != nil {
+				log.Error("ft start node failed.", "err", err)
+				return
+			}
+			nodes = append(nodes, node)
 		}
 
-		if err := startNode(node); err != nil {
+		if err := startNodes(nodes); err != nil {
 			log.Error("ft start node failed.", "err", err)
 			return
 		}
 
-		node.Wait()
-
+		for _, node := range nodes {
+			node.Wait()
+		}
 	},
 }
 
-func viperUmarshalConfig() {
-	err := viper.Unmarshal(logConfig)
-	if err != nil {
-		fmt.Println("Unmarshal logConfig err: ", err)
-		os.Exit(-1)
-	}
-
-	err = viper.Unmarshal(ftconfig.NodeCfg)
-	if err != nil {
-		fmt.Println("Unmarshal NodeCfg err: ", err)
-		os.Exit(-1)
-	}
-
-	err = viper.Unmarshal(ftconfig.FtServiceCfg.TxPool)
-	if err != nil {
-		fmt.Println("Unmarshal TxPool err: ", err)
-		os.Exit(-1)
-	}
-
-	err = viper.Unmarshal(&ftconfig.FtServiceCfg.Miner)
-	if err != nil {
-		fmt.Println("Unmarshal miner err: ", err)
-		os.Exit(-1)
-	}
-
-	err = viper.Unmarshal(ftconfig.FtServiceCfg)
-	if err != nil {
-		fmt.Println("Unmarshal FtServiceCfg err: ", err)
-		os.Exit(-1)
-	}
-
-	err = viper.Unmarshal(ftconfig.FtServiceCfg.Miner)
-	if err != nil {
-		fmt.Println("Unmarshal MinerConfig err: ", err)
-		os.Exit(-1)
-	}
-
-	err = viper.Unmarshal(ftconfig.NodeCfg.P2PConfig)
-	if err != nil {
-		fmt.Println("Unmarshal MinerConfig err: ", err)
-		os.Exit(-1)
+// checkInstances makes sure the chain instances run in the process don't
+// share their instance directories or IPC endpoints.
+func checkInstances(configs []*ftConfig) error {
+	dirs := make(map[string]string)
+	ipcs := make(map[string]string)
+	for _, cfg := range configs {
+		name := cfg.ConfigFileFlag
+		if name == "" {
+			name = "command line"
+		}
+		if cfg.NodeCfg.DataDir != "" {
+			dir := cfg.NodeCfg.InstanceDir()
+			if other, ok := dirs[dir]; ok {
+				return fmt.Errorf("instances %q and %q share the instance directory %s", other, name, dir)
+			}
+			dirs[dir] = name
+		}
+		if ipc := cfg.NodeCfg.IPCEndpoint(); ipc != "" {
+			if other, ok := ipcs[ipc]; ok {
+				return fmt.Errorf("instances %q and %q share the IPC endpoint %s", other, name, ipc)
+			}
+			ipcs[ipc] = name
+		}
 	}
-
+	return nil
 }
 
-func makeNode() (*node.Node, error) {
-	// set miner config
-	SetupMetrics()
+func makeNode(cfg *ftConfig) (*node.Node, error) {
+	if len(cfg.NetworkFlag) != 0 {
+		if len(cfg.GenesisFileFlag) != 0 {
+			return nil, errors.New("network preset and genesis file are mutually exclusive")
+		}
+		network, err := blockchain.LookupNetwork(cfg.NetworkFlag)
+		if err != nil {
+			return nil, err
+		}
+		cfg.FtServiceCfg.Genesis = network.Genesis()
+		if len(cfg.NodeCfg.P2PBootNodes) == 0 && len(network.BootNodes) != 0 {
+			cfg.NodeCfg.P2PBootNodes = strings.Join(network.BootNodes, ",")
+		}
+	}
 
 	// Make sure we have a valid genesis JSON
-	if len(ftconfig.GenesisFileFlag) != 0 {
-		file, err := os.Open(ftconfig.GenesisFileFlag)
+	if len(cfg.GenesisFileFlag) != 0 {
+		file, err := os.Open(cfg.GenesisFileFlag)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to read genesis file: %v(%v)", ftconfig.GenesisFileFlag, err)
+			return nil, fmt.Errorf("Failed to read genesis file: %v(%v)", cfg.GenesisFileFlag, err)
 		}
 		defer file.Close()
 
 		genesis := new(blockchain.Genesis)
 		if err := json.NewDecoder(file).Decode(genesis); err != nil {
-			return nil, fmt.Errorf("invalid genesis file: %v(%v)", ftconfig.GenesisFileFlag, err)
+			return nil, fmt.Errorf("invalid genesis file: %v(%v)", cfg.GenesisFileFlag, err)
 		}
-		ftconfig.FtServiceCfg.Genesis = genesis
+		cfg.FtServiceCfg.Genesis = genesis
 	}
-	return node.New(ftconfig.NodeCfg)
+	return node.New(cfg.NodeCfg)
 }
 
 func SetupMetrics() {
@@ -152,10 +176,15 @@ func SetupMetrics() {
 	}
 }
 
-// start up the node itself
-func startNode(stack *node.Node) error {
-	if err := stack.Start(); err != nil {
-		return err
+// start up the nodes, stopping the ones started if one fails
+func startNodes(stacks []*node.Node) error {
+	for i, stack := range stacks {
+		if err := stack.Start(); err != nil {
+			for _, started := range stacks[:i] {
+				started.Stop()
+			}
+			return err
+		}
 	}
 	go func() {
 		sigc := make(chan os.Signal, 1)
@@ -163,7 +192,9 @@ func startNode(stack *node.Node) error {
 		defer signal.Stop(sigc)
 		<-sigc
 		log.Info("Got interrupt, shutting down...")
-		go stack.Stop()
+		for _, stack := range stacks {
+			go stack.Stop()
+		}
 		for i := 10; i > 0; i-- {
 			<-sigc
 			if i > 1 {
@@ -174,11 +205,11 @@ func startNode(stack *node.Node) error {
 	return nil
 }
 
-func registerService(stack *node.Node) error {
+func registerService(stack *node.Node, cfg *ftConfig) error {
 	var err error
 	// register ftservice
 	err = stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
-		return ftservice.New(ctx, ftconfig.FtServiceCfg)
+		return ftservice.New(ctx, cfg.FtServiceCfg)
 	})
 	return err
 }
@@ -197,20 +228,38 @@ func initConfig() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
-	falgs := RootCmd.Flags()
+	addFlags(RootCmd.Flags())
+}
+
+// addFlags defines the node flags. The flags default to the current
+// configuration, so defining them again once the configuration is loaded
+// only changes what is given on the command line.
+func addFlags(falgs *pflag.FlagSet) {
 	// logging
 	falgs.BoolVar(&logConfig.PrintOrigins, "log_debug", logConfig.PrintOrigins, "Prepends log messages with call-site location (file and line number)")
 	falgs.IntVar(&logConfig.Level, "log_level", logConfig.Level, "Logging verbosity: 0=silent, 1=error, 2=warn, 3=info, 4=debug, 5=detail")
 	falgs.StringVar(&logConfig.Vmodule, "log_vmodule", logConfig.Vmodule, "Per-module verbosity: comma-separated list of <pattern>=<level> (e.g. eth/*=5,p2p=4)")
 	falgs.StringVar(&logConfig.BacktraceAt, "log_backtrace", logConfig.BacktraceAt, "Request a stack trace at a specific logging statement (e.g. \"block.go:271\")")
+	falgs.BoolVar(&logConfig.JSON, "log_json", logConfig.JSON, "Write log records as JSON objects, one per line")
+	falgs.StringVar(&logConfig.File, "log_file", logConfig.File, "Write logs to the file instead of standard error")
+	falgs.IntVar(&logConfig.MaxSize, "log_maxsize", logConfig.MaxSize, "Size (MB) beyond which the log file is rotated, 0 to never rotate")
+	falgs.IntVar(&logConfig.MaxBackups, "log_maxbackups", logConfig.MaxBackups, "Number of rotated log files kept")
+
+	// diagnostics
+	falgs.StringVar(&debugConfig.PProfAddr, "debug_pprofaddr", debugConfig.PProfAddr, "Loopback address serving pprof, goroutine dumps and GC statistics (e.g. localhost:6060), disabled if empty")
 
 	// config file
 	falgs.StringVarP(&ftconfig.ConfigFileFlag, "config", "c", "", "TOML configuration file")
 	falgs.StringVarP(&ftconfig.GenesisFileFlag, "genesis", "g", "", "genesis json file")
+	falgs.StringVar(&ftconfig.NetworkFlag, "network", "", "Network preset selecting the genesis, bootnodes and consensus parameters: "+strings.Join(blockchain.NetworkNames(), ", "))
+	falgs.StringSliceVar(&ftconfig.InstancesFlag, "instances", nil, "Config files of further chain instances run in the process, each with its own instance directory, endpoints and network or genesis")
 
 	// node
 	falgs.StringVarP(&ftconfig.NodeCfg.DataDir, "datadir", "d", ftconfig.NodeCfg.DataDir, "Data directory for the databases and keystore")
+	falgs.StringVar(&ftconfig.NodeCfg.DataLayout, "datalayout", ftconfig.NodeCfg.DataLayout, `Data directory layout: "shared" keeps the keystore and IPC endpoint in the datadir, "instance" in the directory of the node`)
 	falgs.BoolVar(&ftconfig.NodeCfg.UseLightweightKDF, "lightkdf", ftconfig.NodeCfg.UseLightweightKDF, "Reduce key-derivation RAM & CPU usage at some expense of KDF strength")
+	falgs.StringVar(&ftconfig.NodeCfg.HDPath, "hdpath", ftconfig.NodeCfg.HDPath, "Base derivation path of accounts recovered from a mnemonic (default m/44'/60'/0'/0)")
+	falgs.StringVar(&ftconfig.NodeCfg.ExternalSigner, "signer", ftconfig.NodeCfg.ExternalSigner, "Socket path of an external signer holding the account keys")
 	falgs.StringVar(&ftconfig.NodeCfg.IPCPath, "ipcpath", ftconfig.NodeCfg.IPCPath, "RPC:ipc file name")
 	falgs.StringVar(&ftconfig.NodeCfg.HTTPHost, "http_host", ftconfig.NodeCfg.HTTPHost, "RPC:http host address")
 	falgs.IntVar(&ftconfig.NodeCfg.HTTPPort, "http_port", ftconfig.NodeCfg.HTTPPort, "RPC:http host port")
@@ -219,15 +268,45 @@ func init() {
 	falgs.StringSliceVar(&ftconfig.NodeCfg.HTTPVirtualHosts, "http_vhosts", ftconfig.NodeCfg.HTTPVirtualHosts, "virtual hostnames from which to accept requests")
 	falgs.StringVar(&ftconfig.NodeCfg.WSHost, "ws_host", ftconfig.NodeCfg.WSHost, "RPC:websocket host address")
 	falgs.IntVar(&ftconfig.NodeCfg.WSPort, "ws_port", ftconfig.NodeCfg.WSPort, "RPC:websocket host port")
-	falgs.StringSliceVar(&ftconfig.NodeCfg.WSModules, "ws_api", ftconfig.NodeCfg.HTTPModules, "RPC:ws api's offered over the WS-RPC interface")
+	falgs.StringSliceVar(&ftconfig.NodeCfg.WSModules, "ws_api", ftconfig.NodeCfg.WSModules, "RPC:ws api's offered over the WS-RPC interface")
 	falgs.StringSliceVar(&ftconfig.NodeCfg.WSOrigins, "ws_origins", ftconfig.NodeCfg.WSOrigins, "RPC:ws origins from which to accept websockets requests")
 	falgs.BoolVar(&ftconfig.NodeCfg.WSExposeAll, "ws_exposeall", ftconfig.NodeCfg.WSExposeAll, "RPC:ws exposes all API modules via the WebSocket RPC interface rather than just the public ones.")
+	falgs.StringVar(&ftconfig.NodeCfg.JWTSecret, "rpc_jwtsecret", ftconfig.NodeCfg.JWTSecret, "RPC:hex encoded secret signing the tokens of the http and ws auth modules, or a file holding it (generated in the datadir if empty)")
+	falgs.StringSliceVar(&ftconfig.NodeCfg.AuthModules, "rpc_authmodules", ftconfig.NodeCfg.AuthModules, "RPC:http and ws API modules requiring a token signed with the JWT secret")
 
 	// ftservice
 	falgs.IntVar(&ftconfig.FtServiceCfg.DatabaseCache, "FtService_databasecache", ftconfig.FtServiceCfg.DatabaseCache, "Megabytes of memory allocated to internal database caching")
+	falgs.IntVar(&ftconfig.FtServiceCfg.StateCache, "FtService_statecache", ftconfig.FtServiceCfg.StateCache, "Megabytes of memory allocated to state caching")
+	falgs.IntVar(&ftconfig.FtServiceCfg.StateCommitCache, "FtService_statecommitcache", ftconfig.FtServiceCfg.StateCommitCache, "Megabytes of memory allocated to keeping executed blocks, so that a block validated again isn't executed again")
+	falgs.IntVar(&ftconfig.FtServiceCfg.FutureBlockDrift, "FtService_futureblockdrift", ftconfig.FtServiceCfg.FutureBlockDrift, "Seconds a block may be ahead of local time to be inserted when due")
+	falgs.IntVar(&ftconfig.FtServiceCfg.MaxClockDrift, "FtService_maxclockdrift", ftconfig.FtServiceCfg.MaxClockDrift, "Milliseconds the local clock may drift from the block timestamps of the peers before warning, 0 to never warn")
+	falgs.BoolVar(&ftconfig.FtServiceCfg.TxSearchIndex, "FtService_txsearchindex", ftconfig.FtServiceCfg.TxSearchIndex, "Index the transactions by account, asset and action type from the next block for the search RPC")
+	falgs.StringVar(&ftconfig.FtServiceCfg.SyncMode, "FtService_syncmode", ftconfig.FtServiceCfg.SyncMode, `Blockchain sync mode ("full", "fast" or "light", which stores the block headers only)`)
+	falgs.StringVar(&ftconfig.FtServiceCfg.ForkRule, "FtService_forkrule", ftconfig.FtServiceCfg.ForkRule, `Fork choice rule ("td", "irreversible" or "producer")`)
+	falgs.Uint64Var(&ftconfig.FtServiceCfg.CheckpointInterval, "FtService_checkpointinterval", ftconfig.FtServiceCfg.CheckpointInterval, "Blocks between the checkpoints co-signed by the producers for fast syncing nodes, 0 to sign none")
+	falgs.Uint64Var(&ftconfig.FtServiceCfg.MaxReplayBlocks, "FtService_maxreplayblocks", ftconfig.FtServiceCfg.MaxReplayBlocks, "Blocks replayed at most to read the state of a pruned block")
+	falgs.Uint64Var(&ftconfig.FtServiceCfg.MaxRevertBlocks, "FtService_maxrevertblocks", ftconfig.FtServiceCfg.MaxRevertBlocks, "Blocks of state changes reverted at most to read the state of an old block")
+	falgs.StringVar(&ftconfig.FtServiceCfg.HealthAddr, "FtService_healthaddr", ftconfig.FtServiceCfg.HealthAddr, "Listening address of the /health and /ready probe endpoints (e.g. localhost:8547), disabled if empty")
+	falgs.IntVar(&ftconfig.FtServiceCfg.ReadyMaxBlockAge, "FtService_readymaxblockage", ftconfig.FtServiceCfg.ReadyMaxBlockAge, "Seconds since the head block beyond which the node isn't ready, 0 to ignore")
+	falgs.IntVar(&ftconfig.FtServiceCfg.ReadyMinPeers, "FtService_readyminpeers", ftconfig.FtServiceCfg.ReadyMinPeers, "Number of peers needed for the node to be ready")
+	falgs.StringVar(&ftconfig.FtServiceCfg.GRPCAddr, "FtService_grpcaddr", ftconfig.FtServiceCfg.GRPCAddr, "Listening address of the gRPC API (e.g. localhost:8548), disabled if empty")
+	falgs.StringVar(&ftconfig.FtServiceCfg.MQBridgeURL, "FtService_mqbridgeurl", ftconfig.FtServiceCfg.MQBridgeURL, "URL of the message queue new blocks, receipts and reorgs are published to (e.g. nats://localhost:4222), disabled if empty")
+	falgs.StringVar(&ftconfig.FtServiceCfg.MQBridgeTopic, "FtService_mqbridgetopic", ftconfig.FtServiceCfg.MQBridgeTopic, "Prefix of the message queue subjects published to")
+	falgs.StringSliceVar(&ftconfig.FtServiceCfg.MQBridgeEvents, "FtService_mqbridgeevents", ftconfig.FtServiceCfg.MQBridgeEvents, `Events published to the message queue ("blocks", "receipts", "reorgs"), all if empty`)
+	falgs.Uint64Var(&ftconfig.FtServiceCfg.MQBridgeReplayFrom, "FtService_mqbridgereplayfrom", ftconfig.FtServiceCfg.MQBridgeReplayFrom, "Number of the first block published to the message queue, 0 to resume after the last published block")
+	falgs.StringSliceVar(&ftconfig.FtServiceCfg.WebhookURLs, "FtService_webhookurls", ftconfig.FtServiceCfg.WebhookURLs, "URLs POSTed JSON notifications of the transfers to and key changes of the watched accounts, disabled if empty")
+	falgs.StringVar(&ftconfig.FtServiceCfg.WebhookSecret, "FtService_webhooksecret", ftconfig.FtServiceCfg.WebhookSecret, "Key of the HMAC-SHA256 signatures of the webhook notifications, unsigned if empty")
+	falgs.StringSliceVar(&ftconfig.FtServiceCfg.WebhookAccounts, "FtService_webhookaccounts", ftconfig.FtServiceCfg.WebhookAccounts, "Accounts whose activity is notified to the webhooks")
+	falgs.IntVar(&ftconfig.FtServiceCfg.WebhookRetries, "FtService_webhookretries", ftconfig.FtServiceCfg.WebhookRetries, "Retries of a failed webhook notification before dropping it")
+	falgs.Uint64Var(&ftconfig.FtServiceCfg.WebhookConfirmations, "FtService_webhookconfirmations", ftconfig.FtServiceCfg.WebhookConfirmations, "Blocks on top of a block before its activity is notified to the webhooks")
 
 	// consensus
 
+	// downloader
+	falgs.DurationVar(&ftconfig.FtServiceCfg.Downloader.RequestTimeout, "downloader_requesttimeout", ftconfig.FtServiceCfg.Downloader.RequestTimeout, "Time a remote node has to reply to a request of the downloader")
+	falgs.DurationVar(&ftconfig.FtServiceCfg.Downloader.SyncInterval, "downloader_syncinterval", ftconfig.FtServiceCfg.Downloader.SyncInterval, "Time between synchronisations without new block announcements")
+	falgs.IntVar(&ftconfig.FtServiceCfg.Downloader.BodyFetchRemotes, "downloader_bodyfetchremotes", ftconfig.FtServiceCfg.Downloader.BodyFetchRemotes, "Remote nodes asked in turn for a block body fetched on demand")
+
 	// txpool
 	falgs.BoolVar(&ftconfig.FtServiceCfg.TxPool.NoLocals, "txpool_nolocals", ftconfig.FtServiceCfg.TxPool.NoLocals, "Disables price exemptions for locally submitted transactions")
 	falgs.StringVar(&ftconfig.FtServiceCfg.TxPool.Journal, "txpool_journal", ftconfig.FtServiceCfg.TxPool.Journal, "Disk journal for local transaction to survive node restarts")
@@ -239,12 +318,16 @@ func init() {
 	falgs.Uint64Var(&ftconfig.FtServiceCfg.TxPool.GlobalSlots, "txpool_globalslots", ftconfig.FtServiceCfg.TxPool.GlobalSlots, "Maximum number of executable transaction slots for all accounts")
 	falgs.Uint64Var(&ftconfig.FtServiceCfg.TxPool.GlobalQueue, "txpool_globalqueue", ftconfig.FtServiceCfg.TxPool.GlobalQueue, "Minimum number of non-executable transaction slots for all accounts")
 	falgs.DurationVar(&ftconfig.FtServiceCfg.TxPool.Lifetime, "txpool_lifetime", ftconfig.FtServiceCfg.TxPool.Lifetime, "Maximum amount of time non-executable transaction are queued")
+	falgs.DurationVar(&ftconfig.FtServiceCfg.TxPool.PendingLifetime, "txpool_pendinglifetime", ftconfig.FtServiceCfg.TxPool.PendingLifetime, "Maximum amount of time any transaction stays in the pool before expiring (0 = no limit)")
+	falgs.BoolVar(&ftconfig.FtServiceCfg.TxPool.DryRun, "txpool_dryrun", ftconfig.FtServiceCfg.TxPool.DryRun, "Execute executable transactions on the head state and reject the ones that would fail")
 
 	// miner
-	falgs.BoolVar(&ftconfig.FtServiceCfg.Miner.Start, "miner_start", false, "miner start")
+	falgs.BoolVar(&ftconfig.FtServiceCfg.Miner.Start, "miner_start", ftconfig.FtServiceCfg.Miner.Start, "miner start")
 	falgs.StringVar(&ftconfig.FtServiceCfg.Miner.Name, "miner_coinbase", ftconfig.FtServiceCfg.Miner.Name, "name for block mining rewards")
 	falgs.StringVar(&ftconfig.FtServiceCfg.Miner.PrivateKey, "miner_private", ftconfig.FtServiceCfg.Miner.PrivateKey, "hex of private key for block mining rewards")
 	falgs.StringVar(&ftconfig.FtServiceCfg.Miner.ExtraData, "miner_extra", ftconfig.FtServiceCfg.Miner.ExtraData, "Block extra data set by the miner")
+	falgs.Uint64Var(&ftconfig.FtServiceCfg.Miner.GasLimit, "miner_gaslimit", ftconfig.FtServiceCfg.Miner.GasLimit, "Block gas limit voted for by the miner, 0 to follow the gas used")
+	falgs.BoolVar(&ftconfig.FtServiceCfg.Miner.RefuseDrift, "miner_refusedrift", ftconfig.FtServiceCfg.Miner.RefuseDrift, "Skip the producer slots while the local clock drifts beyond FtService_maxclockdrift")
 
 	// gas price oracle
 	falgs.IntVar(&ftconfig.FtServiceCfg.GasPrice.Blocks, "gpo_blocks", ftconfig.FtServiceCfg.GasPrice.Blocks, "Number of recent blocks to check for gas prices")
@@ -265,22 +348,42 @@ func init() {
 		"Maximum number of pending connection attempts (defaults used if set to 0)")
 	falgs.IntVar(&ftconfig.NodeCfg.P2PConfig.DialRatio, "p2p_dialratio", ftconfig.NodeCfg.P2PConfig.DialRatio,
 		"DialRatio controls the ratio of inbound to dialed connections")
+	falgs.IntVar(&ftconfig.NodeCfg.P2PConfig.MaxInboundPeers, "p2p_maxinbound", ftconfig.NodeCfg.P2PConfig.MaxInboundPeers,
+		"Maximum number of inbound peers (defaults to the slots not reserved for dialing if set to 0)")
+	falgs.IntVar(&ftconfig.NodeCfg.P2PConfig.MaxOutboundPeers, "p2p_maxoutbound", ftconfig.NodeCfg.P2PConfig.MaxOutboundPeers,
+		"Maximum number of dialed peers (derived from p2p_dialratio if set to 0)")
+	falgs.IntVar(&ftconfig.NodeCfg.P2PConfig.MaxSubnetDials, "p2p_maxsubnetdials", ftconfig.NodeCfg.P2PConfig.MaxSubnetDials,
+		"Maximum number of dialed peers per /24 subnet (defaults to 2 if set to 0, unlimited if negative)")
+	falgs.IntVar(&ftconfig.NodeCfg.P2PConfig.MaxUploadRate, "p2p_maxupload", ftconfig.NodeCfg.P2PConfig.MaxUploadRate,
+		"Maximum bytes per second for serving chain data to all peers (unlimited if set to 0)")
+	falgs.IntVar(&ftconfig.NodeCfg.P2PConfig.MaxDownloadRate, "p2p_maxdownload", ftconfig.NodeCfg.P2PConfig.MaxDownloadRate,
+		"Maximum bytes per second for fetching chain data from all peers (unlimited if set to 0)")
+	falgs.IntVar(&ftconfig.NodeCfg.P2PConfig.PeerUploadRate, "p2p_peerupload", ftconfig.NodeCfg.P2PConfig.PeerUploadRate,
+		"Maximum bytes per second for serving chain data to a single peer (unlimited if set to 0)")
+	falgs.IntVar(&ftconfig.NodeCfg.P2PConfig.PeerDownloadRate, "p2p_peerdownload", ftconfig.NodeCfg.P2PConfig.PeerDownloadRate,
+		"Maximum bytes per second for fetching chain data from a single peer (unlimited if set to 0)")
 	falgs.StringVar(&ftconfig.NodeCfg.P2PConfig.ListenAddr, "p2p_listenaddr", ftconfig.NodeCfg.P2PConfig.ListenAddr,
 		"Network listening address")
 	falgs.StringVar(&ftconfig.NodeCfg.P2PConfig.NodeDatabase, "p2p_nodedb", ftconfig.NodeCfg.P2PConfig.NodeDatabase,
 		"The path to the database containing the previously seen live nodes in the network")
+	falgs.StringVar(&ftconfig.NodeCfg.P2PConfig.BanList, "p2p_banlist", ftconfig.NodeCfg.P2PConfig.BanList,
+		"The path to the file the peer ban list is persisted to")
+	falgs.DurationVar(&ftconfig.NodeCfg.P2PConfig.BanDuration, "p2p_banduration", ftconfig.NodeCfg.P2PConfig.BanDuration,
+		"Time a misbehaving peer stays banned")
 	falgs.StringVar(&ftconfig.NodeCfg.P2PConfig.Name, "p2p_nodename", ftconfig.NodeCfg.P2PConfig.Name,
 		"The node name of this server")
 	falgs.BoolVar(&ftconfig.NodeCfg.P2PConfig.NoDiscovery, "p2p_nodiscover", ftconfig.NodeCfg.P2PConfig.NoDiscovery,
 		"Disables the peer discovery mechanism (manual peer addition)")
 	falgs.BoolVar(&ftconfig.NodeCfg.P2PConfig.NoDial, "p2p_nodial", ftconfig.NodeCfg.P2PConfig.NoDial,
 		"The server will not dial any peers.")
+	falgs.StringVar(&ftconfig.NodeCfg.P2PNodeKey, "p2p_nodekey", ftconfig.NodeCfg.P2PNodeKey,
+		"Hex encoded node private key or key file (generated in the datadir if empty)")
 	falgs.StringVar(&ftconfig.NodeCfg.P2PBootNodes, "p2p_bootnodes", ftconfig.NodeCfg.P2PBootNodes,
-		"Node list file. BootstrapNodes are used to establish connectivity with the rest of the network")
+		"Node list file or comma separated enode URLs. BootstrapNodes are used to establish connectivity with the rest of the network")
 	falgs.StringVar(&ftconfig.NodeCfg.P2PStaticNodes, "p2p_staticnodes", ftconfig.NodeCfg.P2PStaticNodes,
-		"Node list file. Static nodes are used as pre-configured connections which are always maintained and re-connected on disconnects")
-	falgs.StringVar(&ftconfig.NodeCfg.P2PTrustNodes, "p2p_trustnodes", ftconfig.NodeCfg.P2PStaticNodes,
-		"Node list file. Trusted nodes are usesd as pre-configured connections which are always allowed to connect, even above the peer limit")
+		"Node list file or comma separated enode URLs. Static nodes are used as pre-configured connections which are always maintained and re-connected on disconnects")
+	falgs.StringVar(&ftconfig.NodeCfg.P2PTrustNodes, "p2p_trustnodes", ftconfig.NodeCfg.P2PTrustNodes,
+		"Node list file or comma separated enode URLs. Trusted nodes are used as pre-configured connections which are always allowed to connect, even above the peer limit")
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
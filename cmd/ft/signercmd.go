@@ -0,0 +1,126 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/console"
+	"github.com/fractalplatform/fractal/wallet/external"
+)
+
+var signerCmd = &cobra.Command{
+	Use:   "signer <socket>",
+	Short: "Run an external signer holding the account keys",
+	Long: `
+    ft signer /path/to/signer.ipc
+
+serves the keys of the keystore on the given socket. Start the node with
+--signer /path/to/signer.ipc to sign with these keys, the node never loads
+them itself. Every signing request is shown here and has to be confirmed
+with the passphrase of the key.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		w, err := getWallet()
+		if err != nil {
+			fmt.Println("get wallet error ", err)
+			return
+		}
+		listener, _, err := external.StartSigner(args[0], external.NewSignerAPI(w, new(consoleUI)))
+		if err != nil {
+			fmt.Println("start signer error ", err)
+			return
+		}
+		defer listener.Close()
+		fmt.Println("Signer listening on", args[0])
+
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+		<-sigc
+	},
+}
+
+// consoleUI asks for approval of signing requests on the terminal, one request
+// at a time.
+type consoleUI struct {
+	mutex sync.Mutex
+}
+
+// ApproveTx shows every action of the transaction, as the signature covers
+// all of them, and the hash signed.
+func (ui *consoleUI) ApproveTx(req *external.SignTxRequest, tx *types.Transaction, hash common.Hash) (bool, string, error) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+
+	actions := tx.GetActions()
+	fmt.Println("-------- Transaction signing request --------")
+	fmt.Printf("Key:       %x\n", req.Address)
+	fmt.Printf("Chain ID:  %v\n", req.ChainID.ToInt())
+	fmt.Printf("Gas price: %v (asset %v)\n", tx.GasPrice(), tx.GasAssetID())
+	fmt.Printf("Actions:   %d, signing action %d\n", len(actions), req.Action)
+	for i, action := range actions {
+		fmt.Printf("-------- Action %d --------\n", i)
+		fmt.Printf("Type:      %v\n", action.Type())
+		fmt.Printf("From:      %v\n", action.Sender())
+		fmt.Printf("To:        %v\n", action.Recipient())
+		fmt.Printf("Nonce:     %v\n", action.Nonce())
+		fmt.Printf("Asset:     %v\n", action.AssetID())
+		fmt.Printf("Value:     %v\n", action.Value())
+		fmt.Printf("Gas:       %v\n", action.Gas())
+		fmt.Printf("Data:      %x\n", action.Data())
+		for _, ext := range action.Extensions() {
+			fmt.Printf("Extension: version %d, data %x\n", ext.Version, []byte(ext.Data))
+		}
+	}
+	fmt.Println("---------------------------------------------")
+	fmt.Printf("Signing hash: %x\n", hash)
+	return ui.approve()
+}
+
+func (ui *consoleUI) ApproveSignData(req *external.SignDataRequest) (bool, string, error) {
+	ui.mutex.Lock()
+	defer ui.mutex.Unlock()
+
+	fmt.Println("-------- Data signing request --------")
+	fmt.Printf("Key:  %x\n", req.Address)
+	fmt.Printf("Hash: %x\n", []byte(req.Hash))
+	return ui.approve()
+}
+
+func (ui *consoleUI) approve() (bool, string, error) {
+	ok, err := console.Stdin.PromptConfirm("Approve?")
+	if err != nil || !ok {
+		return false, "", err
+	}
+	passphrase, err := console.Stdin.PromptPassword("Passphrase: ")
+	if err != nil {
+		return false, "", err
+	}
+	return true, passphrase, nil
+}
+
+func init() {
+	RootCmd.AddCommand(signerCmd)
+}
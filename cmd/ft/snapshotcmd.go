@@ -0,0 +1,185 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fractalplatform/fractal/blockchain"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+	"github.com/spf13/cobra"
+)
+
+var (
+	snapshotNumber     uint64
+	snapshotBlocks     uint64
+	snapshotCheckpoint string
+)
+
+// snapshotCmd represents the snapshot command
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Export and import snapshots of the chain state",
+	Long: `A snapshot holds the state at a block, the recent blocks ending with it and the
+genesis block. Its metadata commits to the state with the state hash of the
+checkpoint of the block. Importing a snapshot into an empty data directory
+bootstraps a node at the block.`,
+}
+
+var snapshotExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Export a snapshot of the chain at a block",
+	Long: `Export a snapshot of the canonical chain at --number, the block of the latest
+checkpoint by default. Only the snapshots of checkpointed blocks can be imported.
+The node must be stopped, otherwise use admin.exportSnapshot. The state changes of
+the blocks above --number must not have been pruned.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := exportSnapshot(args[0]); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+var snapshotImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import a snapshot into an empty data directory",
+	Long: `Import a snapshot into an empty data directory, verifying the blocks and the
+state against the checkpoint of the snapshot block. --checkpoint is a JSON file of
+the checkpoint, as returned by ft.getCheckpoint of a trusted node. Remove the chain
+data before importing again after a failure.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := importSnapshot(args[0]); err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotExportCmd, snapshotImportCmd)
+	for _, cmd := range []*cobra.Command{snapshotExportCmd, snapshotImportCmd} {
+		cmd.Flags().StringVarP(&ftconfig.NodeCfg.DataDir, "datadir", "d", defaultDataDir(), "Data directory for the databases and keystore")
+	}
+	snapshotExportCmd.Flags().Uint64Var(&snapshotNumber, "number", 0, "Number of the snapshot block, 0 for the block of the latest checkpoint")
+	snapshotExportCmd.Flags().Uint64Var(&snapshotBlocks, "blocks", 256, "Number of recent blocks in the snapshot, ending with the snapshot block")
+	snapshotImportCmd.Flags().StringVar(&snapshotCheckpoint, "checkpoint", "", "JSON file of the trusted checkpoint of the snapshot block")
+}
+
+func exportSnapshot(file string) error {
+	path := filepath.Join(ftconfig.NodeCfg.DataDir, ftconfig.NodeCfg.Name, "chaindata")
+	db, err := fdb.NewReadOnlyLDBDatabase(path, ftconfig.FtServiceCfg.DatabaseCache, makeDatabaseHandles())
+	if err != nil {
+		return fmt.Errorf("Failed to open database %v: %v", path, err)
+	}
+	defer db.Close()
+
+	number := snapshotNumber
+	if number == 0 {
+		latest := rawdb.ReadCheckpoint(db)
+		if latest == nil {
+			return errors.New("no checkpoint found, give --number")
+		}
+		number = latest.Checkpoint.Number
+	}
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	meta, err := blockchain.ExportSnapshot(db, number, snapshotBlocks, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return fmt.Errorf("Failed to export snapshot: %v", err)
+	}
+	printSnapshotMeta(meta)
+	fmt.Printf("Export done, elapsed %v\n", time.Since(start).Round(time.Second))
+	return nil
+}
+
+func importSnapshot(file string) error {
+	checkpoint, err := readCheckpoint(snapshotCheckpoint)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	path := filepath.Join(ftconfig.NodeCfg.DataDir, ftconfig.NodeCfg.Name, "chaindata")
+	db, err := fdb.NewLDBDatabase(path, ftconfig.FtServiceCfg.DatabaseCache, makeDatabaseHandles())
+	if err != nil {
+		return fmt.Errorf("Failed to open database %v: %v", path, err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	meta, err := blockchain.ImportSnapshot(db, in, checkpoint)
+	if err != nil {
+		return fmt.Errorf("Failed to import snapshot: %v", err)
+	}
+	printSnapshotMeta(meta)
+	fmt.Printf("Import done, elapsed %v\n", time.Since(start).Round(time.Second))
+	return nil
+}
+
+// readCheckpoint reads a checkpoint from a JSON file, either signed, as
+// returned by ft.getCheckpoint, or alone.
+func readCheckpoint(file string) (*types.Checkpoint, error) {
+	if file == "" {
+		return nil, errors.New("give the trusted checkpoint of the snapshot block with --checkpoint")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var signed types.SignedCheckpoint
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %v: %v", file, err)
+	}
+	if signed.Checkpoint.Hash != (common.Hash{}) {
+		return &signed.Checkpoint, nil
+	}
+	checkpoint := new(types.Checkpoint)
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %v: %v", file, err)
+	}
+	if checkpoint.Hash == (common.Hash{}) {
+		return nil, fmt.Errorf("no checkpoint in %v", file)
+	}
+	return checkpoint, nil
+}
+
+func printSnapshotMeta(meta *blockchain.SnapshotMeta) {
+	fmt.Printf("Snapshot block %d %x\n", meta.Number, meta.Hash)
+	fmt.Printf("Genesis %x, %d recent blocks, %d state items, state hash %x\n", meta.Genesis, meta.Blocks, meta.Items, meta.StateHash)
+}
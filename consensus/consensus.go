@@ -20,6 +20,7 @@ import (
 	"math/big"
 
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/event"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/processor/vm"
 	"github.com/fractalplatform/fractal/rpc"
@@ -94,6 +95,9 @@ type IEngine interface {
 
 	ProcessAction(chainCfg *params.ChainConfig, state *state.StateDB, action *types.Action) error
 
+	// ActivatedProducers returns the producers of the activated schedule in the state.
+	ActivatedProducers(state *state.StateDB) ([]string, error)
+
 	IAPI
 }
 
@@ -114,4 +118,7 @@ type IConsensus interface {
 	IEngine
 	ITxProcessor
 	ITxPool
+
+	// Router returns the router the miner exchanges its events through.
+	Router() *event.Router
 }
@@ -18,9 +18,11 @@ package dpos
 
 import (
 	"encoding/json"
+	"fmt"
 	"math/big"
 	"sort"
 
+	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/consensus"
 	"github.com/fractalplatform/fractal/rpc"
 )
@@ -72,6 +74,20 @@ func (api *API) Account(name string) (interface{}, error) {
 	return nil, nil
 }
 
+// SigningKey returns the block signing key of a producer, nil if it signs
+// with its owner authority.
+func (api *API) SigningKey(name string) (interface{}, error) {
+	sys, err := api.system()
+	if err != nil {
+		return nil, err
+	}
+	key, err := sys.GetSigningKey(name)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return common.BytesToPubKey(key), nil
+}
+
 func (api *API) Producers() ([]map[string]interface{}, error) {
 	pfileds := []map[string]interface{}{}
 
@@ -141,6 +157,161 @@ func (api *API) ValidateEpcho() (interface{}, error) {
 	return api.Epcho(height)
 }
 
+// ProducerStanding is a registered producer ranked by the stake voted to it.
+type ProducerStanding struct {
+	Rank          int      `json:"rank"`
+	Name          string   `json:"name"`
+	URL           string   `json:"url"`
+	Quantity      *big.Int `json:"quantity"`      // stake of the producer itself
+	TotalQuantity *big.Int `json:"totalQuantity"` // stake of the producer and its voters
+	Height        uint64   `json:"height"`
+	Scheduled     bool     `json:"scheduled"` // in the schedule of the current epoch
+}
+
+// VoteStanding is the stake of an account in the election of the producers.
+type VoteStanding struct {
+	Account       string   `json:"account"`
+	Producer      string   `json:"producer"` // the producer voted for, the account itself for a producer
+	Quantity      *big.Int `json:"quantity"`
+	Height        uint64   `json:"height"`
+	TotalQuantity *big.Int `json:"totalQuantity"` // total stake of the producer
+}
+
+// Schedule is the producer schedule of an epoch, elected in the state of the
+// last block DelayEcho epochs before the epoch starts.
+type Schedule struct {
+	Epoch                  uint64   `json:"epoch"`
+	Start                  uint64   `json:"start"`  // timestamp of the first slot
+	Height                 uint64   `json:"height"` // block of the election
+	Producers              []string `json:"producers"`
+	ActivatedTotalQuantity *big.Int `json:"activatedTotalQuantity"`
+	TotalQuantity          *big.Int `json:"totalQuantity"`
+}
+
+// GetProducers returns the registered producers ranked by their total stake.
+func (api *API) GetProducers() ([]*ProducerStanding, error) {
+	sys, err := api.system()
+	if err != nil {
+		return nil, err
+	}
+	producers, err := sys.Producers()
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := api.GetSchedule(api.dpos.config.epoch(api.chain.CurrentHeader().Time.Uint64()))
+	if err != nil {
+		return nil, err
+	}
+	scheduled := make(map[string]bool, len(schedule.Producers))
+	for _, name := range schedule.Producers {
+		scheduled[name] = true
+	}
+
+	prods := producerInfoArray(producers)
+	sort.Sort(prods)
+	standings := make([]*ProducerStanding, 0, len(prods))
+	for i, prod := range prods {
+		standings = append(standings, &ProducerStanding{
+			Rank:          i + 1,
+			Name:          prod.Name,
+			URL:           prod.URL,
+			Quantity:      prod.Quantity,
+			TotalQuantity: prod.TotalQuantity,
+			Height:        prod.Height,
+			Scheduled:     scheduled[prod.Name],
+		})
+	}
+	return standings, nil
+}
+
+// GetVotes returns the vote of the account, or its own stake if it is a
+// producer, nil if it takes no part in the election.
+func (api *API) GetVotes(account string) (*VoteStanding, error) {
+	sys, err := api.system()
+	if err != nil {
+		return nil, err
+	}
+	if prod, err := sys.GetProducer(account); err != nil {
+		return nil, err
+	} else if prod != nil {
+		return &VoteStanding{
+			Account:       account,
+			Producer:      prod.Name,
+			Quantity:      prod.Quantity,
+			Height:        prod.Height,
+			TotalQuantity: prod.TotalQuantity,
+		}, nil
+	}
+	voter, err := sys.GetVoter(account)
+	if err != nil || voter == nil {
+		return nil, err
+	}
+	standing := &VoteStanding{
+		Account:  account,
+		Producer: voter.Producer,
+		Quantity: voter.Quantity,
+		Height:   voter.Height,
+	}
+	if prod, err := sys.GetProducer(voter.Producer); err != nil {
+		return nil, err
+	} else if prod != nil {
+		standing.TotalQuantity = prod.TotalQuantity
+	}
+	return standing, nil
+}
+
+// GetSchedule returns the producer schedule of the epoch, which is known as
+// soon as the chain passed its election DelayEcho epochs ahead.
+func (api *API) GetSchedule(epoch uint64) (*Schedule, error) {
+	cfg := api.dpos.config
+	start := epoch * cfg.epochInterval()
+	delay := cfg.DelayEcho * cfg.epochInterval()
+	target := uint64(0)
+	if start > delay {
+		target = start - delay
+	}
+	head := api.chain.CurrentHeader()
+	if target > head.Time.Uint64() {
+		return nil, fmt.Errorf("schedule of epoch %d not elected yet", epoch)
+	}
+	height := api.electionHeight(head.Number.Uint64(), target)
+
+	sys, err := api.system()
+	if err != nil {
+		return nil, err
+	}
+	gstate, err := sys.GetState(height)
+	if err != nil {
+		return nil, err
+	}
+	if gstate == nil {
+		return nil, fmt.Errorf("election state of epoch %d at %d not found", epoch, height)
+	}
+	return &Schedule{
+		Epoch:                  epoch,
+		Start:                  start,
+		Height:                 height,
+		Producers:              gstate.ActivatedProducerSchedule,
+		ActivatedTotalQuantity: gstate.ActivatedTotalQuantity,
+		TotalQuantity:          gstate.TotalQuantity,
+	}, nil
+}
+
+// GetIrreversible is dpos_irreversible under the name of the other snapshot
+// endpoints.
+func (api *API) GetIrreversible() (interface{}, error) {
+	return api.Irreversible()
+}
+
+// electionHeight returns the highest block up to head whose time isn't after
+// the timestamp, as IsValidateProducer walks back to, searching by halves.
+func (api *API) electionHeight(head uint64, timestamp uint64) uint64 {
+	return uint64(sort.Search(int(head), func(i int) bool {
+		header := api.chain.GetHeaderByNumber(uint64(i) + 1)
+		return header == nil || header.Time.Uint64() > timestamp
+	}))
+}
+
 func (api *API) system() (*System, error) {
 	state, err := api.chain.StateAt(api.chain.CurrentHeader().Hash())
 	if err != nil {
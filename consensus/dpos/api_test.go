@@ -0,0 +1,124 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package dpos
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+type testChain struct {
+	consensus.IChainReader
+	headers []*types.Header
+	state   *state.StateDB
+}
+
+func (c *testChain) CurrentHeader() *types.Header { return c.headers[len(c.headers)-1] }
+
+func (c *testChain) GetHeaderByNumber(number uint64) *types.Header {
+	if number >= uint64(len(c.headers)) {
+		return nil
+	}
+	return c.headers[number]
+}
+
+func (c *testChain) StateAt(hash common.Hash) (*state.StateDB, error) { return c.state, nil }
+
+func TestAPIStandings(t *testing.T) {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig
+	db := &LDB{IDatabase: &stateDB{name: cfg.AccountName, state: statedb}}
+
+	// each block elects a schedule of its own
+	chain := &testChain{state: statedb}
+	for h := uint64(0); h < 90; h++ {
+		chain.headers = append(chain.headers, &types.Header{
+			Number: new(big.Int).SetUint64(h),
+			Time:   new(big.Int).SetUint64(h * cfg.blockInterval()),
+		})
+		if err := db.SetState(&globalState{
+			Height:                    h,
+			ActivatedProducerSchedule: []string{fmt.Sprintf("prod%d", h)},
+			ActivatedTotalQuantity:    big.NewInt(0),
+			TotalQuantity:             big.NewInt(0),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{dpos: New(cfg, nil), chain: chain}
+
+	// 18 blocks an epoch, elected 2 epochs ahead
+	schedule, err := api.GetSchedule(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule.Height != 18 || !reflect.DeepEqual(schedule.Producers, []string{"prod18"}) {
+		t.Fatalf("schedule of epoch 3 elected at %d: %v, want prod18 at 18", schedule.Height, schedule.Producers)
+	}
+	if schedule, err = api.GetSchedule(6); err != nil || schedule.Height != 72 {
+		t.Fatalf("schedule of epoch 6 %+v (%v), want the election at 72", schedule, err)
+	}
+	if _, err := api.GetSchedule(7); err == nil {
+		t.Fatal("returned the schedule of an epoch not elected yet")
+	}
+
+	for _, prod := range []*producerInfo{
+		{Name: "prod36", Quantity: big.NewInt(10), TotalQuantity: big.NewInt(12)},
+		{Name: "prodother", Quantity: big.NewInt(20), TotalQuantity: big.NewInt(20)},
+	} {
+		if err := db.SetProducer(prod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetVoter(&voterInfo{Name: "apivoter", Producer: "prod36", Quantity: big.NewInt(2), Height: 5}); err != nil {
+		t.Fatal(err)
+	}
+	standings, err := api.GetProducers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(standings) != 2 || standings[0].Name != "prodother" || standings[0].Rank != 1 || standings[0].Scheduled {
+		t.Fatalf("first standing mismatch: %+v", standings[0])
+	}
+	if standings[1].Name != "prod36" || standings[1].Rank != 2 || !standings[1].Scheduled {
+		t.Fatalf("second standing mismatch: %+v", standings[1])
+	}
+
+	if vote, err := api.GetVotes("apivoter"); err != nil || vote.Producer != "prod36" || vote.Quantity.Int64() != 2 || vote.TotalQuantity.Int64() != 12 {
+		t.Fatalf("vote %+v (%v), want 2 for prod36 of 12", vote, err)
+	}
+	if vote, err := api.GetVotes("prodother"); err != nil || vote.Producer != "prodother" || vote.Quantity.Int64() != 20 {
+		t.Fatalf("producer stake %+v (%v), want 20", vote, err)
+	}
+	if vote, err := api.GetVotes("apistranger"); err != nil || vote != nil {
+		t.Fatalf("stranger vote %+v (%v), want nil", vote, err)
+	}
+	if info, err := api.GetIrreversible(); err != nil || info.(*Irreversible_Ret).LastIrreversible != api.dpos.calcLastIrreversible() {
+		t.Fatalf("irreversible %+v (%v), want last irreversible %d", info, err, api.dpos.calcLastIrreversible())
+	}
+}
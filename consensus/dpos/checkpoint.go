@@ -0,0 +1,90 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package dpos
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// CheckpointSchedule returns the activated producers in the state with the
+// keys they sign with, their signing key if they set one and the key of
+// their account otherwise. A checkpoint attests the schedule at its block,
+// whose producers sign the next checkpoint.
+func (dpos *Dpos) CheckpointSchedule(state *state.StateDB) ([]*types.CheckpointProducer, error) {
+	names, err := dpos.ActivatedProducers(state)
+	if err != nil {
+		return nil, err
+	}
+	accountDB, err := accountmanager.NewAccountManager(state)
+	if err != nil {
+		return nil, err
+	}
+	db := &LDB{IDatabase: &stateDB{name: dpos.config.AccountName, state: state}}
+	schedule := make([]*types.CheckpointProducer, 0, len(names))
+	for _, name := range names {
+		key, err := db.GetSigningKey(name)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			acct, err := accountDB.GetAccountByName(common.StrToName(name))
+			if err != nil {
+				return nil, err
+			}
+			if acct == nil {
+				return nil, fmt.Errorf("%v %v", errInvalidBlockProducer, name)
+			}
+			key = acct.GetPubKey().Bytes()
+		}
+		schedule = append(schedule, &types.CheckpointProducer{Name: name, PubKey: key})
+	}
+	return schedule, nil
+}
+
+// VerifyScheduledSeal checks that a header is sealed by its producer with
+// the key the schedule lists for it. It verifies the headers of a chain
+// keeping no state against the schedule attested by a checkpoint, the slots
+// of the producers aren't checked.
+func (dpos *Dpos) VerifyScheduledSeal(chain consensus.IChainReader, header *types.Header, schedule []*types.CheckpointProducer) error {
+	if header.Number.Sign() == 0 {
+		return errUnknownBlock
+	}
+	if parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1); parent != nil && dpos.config.nextslot(parent.Time.Uint64()) > header.Time.Uint64() {
+		return errInvalidTimestamp
+	}
+	pubkey, err := ecrecover(header, chain.Config().ChainID.Bytes())
+	if err != nil {
+		return err
+	}
+	for _, producer := range schedule {
+		if producer.Name != header.Coinbase.String() {
+			continue
+		}
+		if !bytes.Equal(producer.PubKey, pubkey) {
+			return errMismatchSignerAndValidator
+		}
+		return nil
+	}
+	return fmt.Errorf("%v %v", errInvalidBlockProducer, header.Coinbase)
+}
@@ -45,6 +45,46 @@ var DefaultConfig = &Config{
 	Decimals:             18,
 }
 
+// TestnetConfig configures the test network, with lower quantities to become
+// a producer.
+var TestnetConfig = &Config{
+	MaxURLLen:            512,
+	UnitStake:            big.NewInt(1000),
+	ProducerMinQuantity:  big.NewInt(1),
+	VoterMinQuantity:     big.NewInt(1),
+	ActivatedMinQuantity: big.NewInt(10),
+	BlockInterval:        3000,
+	BlockFrequency:       6,
+	ProducerScheduleSize: 3,
+	DelayEcho:            2,
+	AccountName:          "ftsystemdpos",
+	SystemName:           "ftsystemio",
+	SystemURL:            "www.fractalproject.com",
+	ExtraBlockReward:     big.NewInt(1),
+	BlockReward:          big.NewInt(5),
+	Decimals:             18,
+}
+
+// DevConfig configures a local development network, producing blocks every
+// second.
+var DevConfig = &Config{
+	MaxURLLen:            512,
+	UnitStake:            big.NewInt(1),
+	ProducerMinQuantity:  big.NewInt(1),
+	VoterMinQuantity:     big.NewInt(1),
+	ActivatedMinQuantity: big.NewInt(1),
+	BlockInterval:        1000,
+	BlockFrequency:       6,
+	ProducerScheduleSize: 3,
+	DelayEcho:            2,
+	AccountName:          "ftsystemdpos",
+	SystemName:           "ftsystemio",
+	SystemURL:            "www.fractalproject.com",
+	ExtraBlockReward:     big.NewInt(1),
+	BlockReward:          big.NewInt(5),
+	Decimals:             18,
+}
+
 // Config dpos configures
 type Config struct {
 	// consensus fileds
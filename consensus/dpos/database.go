@@ -35,6 +35,10 @@ type IDB interface {
 	Producers() ([]*producerInfo, error)
 	ProducersSize() (uint64, error)
 
+	SetSigningKey(string, []byte) error
+	DelSigningKey(string) error
+	GetSigningKey(string) ([]byte, error)
+
 	SetVoter(*voterInfo) error
 	DelVoter(string, string) error
 	GetVoter(string) (*voterInfo, error)
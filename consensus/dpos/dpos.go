@@ -17,6 +17,7 @@
 package dpos
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/big"
@@ -290,13 +291,29 @@ func (dpos *Dpos) VerifySeal(chain consensus.IChainReader, header *types.Header)
 		return err
 	}
 
+	if valid, err := dpos.isValidSigner(state, proudcer, pubkey); err != nil {
+		return err
+	} else if !valid {
+		return fmt.Errorf("invalid block signature")
+	}
+	return dpos.calcProposedIrreversible(chain)
+}
+
+// isValidSigner reports whether a producer signs blocks with the key. A
+// producer with a signing key signs with it instead of its owner authority.
+func (dpos *Dpos) isValidSigner(state *state.StateDB, producer string, pubkey []byte) (bool, error) {
 	db := &stateDB{
+		name:  dpos.config.AccountName,
 		state: state,
 	}
-	if !db.IsValidSign(proudcer, pubkey) {
-		return fmt.Errorf("invalid block signature")
+	signingKey, err := (&LDB{IDatabase: db}).GetSigningKey(producer)
+	if err != nil {
+		return false, err
 	}
-	return dpos.calcProposedIrreversible(chain)
+	if signingKey != nil {
+		return bytes.Equal(signingKey, pubkey), nil
+	}
+	return db.IsValidSign(producer, pubkey), nil
 }
 
 // CalcDifficulty is the difficulty adjustment algorithm.
@@ -374,6 +391,12 @@ func (dpos *Dpos) Engine() consensus.IEngine {
 	return dpos
 }
 
+// IrreversibleNumber returns the number of the highest block confirmed by
+// enough producers to be irreversible.
+func (dpos *Dpos) IrreversibleNumber() uint64 {
+	return dpos.proposedIrreversibleNum
+}
+
 func (dpos *Dpos) calcLastIrreversible() uint64 {
 	irreversibles := UInt64Slice{}
 	for _, irreversible := range dpos.producerIrreversibleNum {
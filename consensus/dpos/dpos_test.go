@@ -0,0 +1,65 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package dpos
+
+import (
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func TestIsValidSigner(t *testing.T) {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dpos := New(DefaultConfig, nil)
+	hot, _ := crypto.GenerateKey()
+	owner, _ := crypto.GenerateKey()
+	hotKey, ownerKey := crypto.FromECDSAPub(&hot.PublicKey), crypto.FromECDSAPub(&owner.PublicKey)
+
+	am, err := accountmanager.NewAccountManager(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := am.CreateAccount("testproducer", common.BytesToPubKey(ownerKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a signing key the producer signs with its owner key
+	if valid, err := dpos.isValidSigner(statedb, "testproducer", ownerKey); err != nil || !valid {
+		t.Errorf("owner key rejected --- %v(%v)", err, valid)
+	}
+	if valid, err := dpos.isValidSigner(statedb, "testproducer", hotKey); err != nil || valid {
+		t.Errorf("unknown key accepted --- %v(%v)", err, valid)
+	}
+
+	db := &LDB{IDatabase: &stateDB{name: DefaultConfig.AccountName, state: statedb}}
+	if err := db.SetSigningKey("testproducer", hotKey); err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := dpos.isValidSigner(statedb, "testproducer", hotKey); err != nil || !valid {
+		t.Errorf("signing key rejected --- %v(%v)", err, valid)
+	}
+	if valid, err := dpos.isValidSigner(statedb, "testproducer", ownerKey); err != nil || valid {
+		t.Errorf("owner key accepted instead of the signing key --- %v(%v)", err, valid)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package dpos
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/state"
+)
+
+// GenesisProducer is a producer registered in the state of a genesis block,
+// with the stake it stakes itself and the stakes voted to it, in units of
+// the stake. The staked assets are held by the dpos account, so they must be
+// part of its genesis balance.
+type GenesisProducer struct {
+	Name       string          `json:"name"`
+	URL        string          `json:"url,omitempty"`
+	Quantity   *big.Int        `json:"quantity"`
+	SigningKey hexutil.Bytes   `json:"signingKey,omitempty"`
+	Voters     []*GenesisVoter `json:"voters,omitempty"`
+}
+
+// GenesisVoter is a vote for a genesis producer.
+type GenesisVoter struct {
+	Name     string   `json:"name"`
+	Quantity *big.Int `json:"quantity"`
+}
+
+// ExportProducers returns the producers registered in the state in name
+// order. Votes aren't listed by the state, so the votes of the given
+// accounts are looked up and attached to the producers they vote for.
+func ExportProducers(cfg *Config, state *state.StateDB, accounts []string) ([]*GenesisProducer, error) {
+	db := &LDB{IDatabase: &stateDB{name: cfg.AccountName, state: state}}
+	prods, err := db.Producers()
+	if err != nil {
+		return nil, err
+	}
+	producers := make([]*GenesisProducer, 0, len(prods))
+	byName := make(map[string]*GenesisProducer, len(prods))
+	for _, prod := range prods {
+		key, err := db.GetSigningKey(prod.Name)
+		if err != nil {
+			return nil, err
+		}
+		producer := &GenesisProducer{
+			Name:       prod.Name,
+			URL:        prod.URL,
+			Quantity:   new(big.Int).Set(prod.Quantity),
+			SigningKey: key,
+		}
+		producers = append(producers, producer)
+		byName[prod.Name] = producer
+	}
+	sort.Slice(producers, func(i, j int) bool { return producers[i].Name < producers[j].Name })
+
+	for _, name := range accounts {
+		voter, err := db.GetVoter(name)
+		if err != nil {
+			return nil, err
+		}
+		if voter == nil {
+			continue
+		}
+		producer, ok := byName[voter.Producer]
+		if !ok {
+			return nil, fmt.Errorf("voter %v votes for unknown producer %v", name, voter.Producer)
+		}
+		producer.Voters = append(producer.Voters, &GenesisVoter{
+			Name:     voter.Name,
+			Quantity: new(big.Int).Set(voter.Quantity),
+		})
+	}
+	return producers, nil
+}
+
+// GenesisProducers registers producers and their voters in the state written
+// by Genesis at the given height. The producers are elected at the end of the
+// first epoch, the system producer mints until then.
+func GenesisProducers(cfg *Config, state *state.StateDB, height uint64, producers []*GenesisProducer) error {
+	db := &LDB{IDatabase: &stateDB{name: cfg.AccountName, state: state}}
+	total := big.NewInt(0)
+	voted := make(map[string]bool)
+	for _, producer := range producers {
+		if producer.Quantity == nil || producer.Quantity.Sign() < 0 {
+			return fmt.Errorf("invalid quantity of genesis producer %v", producer.Name)
+		}
+		voted[producer.Name] = true
+	}
+	for _, producer := range producers {
+		prod := &producerInfo{
+			Name:          producer.Name,
+			URL:           producer.URL,
+			Quantity:      new(big.Int).Set(producer.Quantity),
+			TotalQuantity: new(big.Int).Set(producer.Quantity),
+			Height:        height,
+		}
+		for _, voter := range producer.Voters {
+			if voted[voter.Name] {
+				return fmt.Errorf("genesis voter %v is a producer or votes twice", voter.Name)
+			}
+			if voter.Quantity == nil || voter.Quantity.Sign() <= 0 {
+				return fmt.Errorf("invalid quantity of genesis voter %v", voter.Name)
+			}
+			voted[voter.Name] = true
+			if err := db.SetVoter(&voterInfo{
+				Name:     voter.Name,
+				Producer: producer.Name,
+				Quantity: new(big.Int).Set(voter.Quantity),
+				Height:   height,
+			}); err != nil {
+				return err
+			}
+			prod.TotalQuantity.Add(prod.TotalQuantity, voter.Quantity)
+		}
+		if err := db.SetProducer(prod); err != nil {
+			return err
+		}
+		if len(producer.SigningKey) > 0 {
+			if err := db.SetSigningKey(producer.Name, producer.SigningKey); err != nil {
+				return err
+			}
+		}
+		total.Add(total, prod.TotalQuantity)
+	}
+
+	for _, h := range []uint64{height, height + 1} {
+		gstate, err := db.GetState(h)
+		if err != nil {
+			return err
+		}
+		if gstate == nil {
+			return fmt.Errorf("missing dpos state at %d", h)
+		}
+		gstate.TotalQuantity = new(big.Int).Set(total)
+		if err := db.SetState(gstate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
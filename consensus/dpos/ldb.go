@@ -48,6 +48,8 @@ var (
 	ProducersKeyPrefix = "prods"
 	// StateKeyPrefix height --> globalState
 	StateKeyPrefix = "state"
+	// SigningKeyPrefix producer name --> block signing key
+	SigningKeyPrefix = "signkey"
 	// Separator Split characters
 	Separator = "_"
 
@@ -288,6 +290,30 @@ func (db *LDB) ProducersSize() (uint64, error) {
 	return size, nil
 }
 
+// GetSigningKey returns the block signing key of a producer, nil if the
+// producer signs with its owner authority.
+func (db *LDB) GetSigningKey(name string) ([]byte, error) {
+	key := strings.Join([]string{SigningKeyPrefix, name}, Separator)
+	val, err := db.Get(key)
+	if err != nil || len(val) == 0 {
+		return nil, err
+	}
+	return val, nil
+}
+
+// SetSigningKey sets the block signing key of a producer.
+func (db *LDB) SetSigningKey(name string, pubkey []byte) error {
+	key := strings.Join([]string{SigningKeyPrefix, name}, Separator)
+	return db.Put(key, pubkey)
+}
+
+// DelSigningKey removes the block signing key of a producer, which signs with
+// its owner authority again.
+func (db *LDB) DelSigningKey(name string) error {
+	key := strings.Join([]string{SigningKeyPrefix, name}, Separator)
+	return db.Delete(key)
+}
+
 func (db *LDB) GetState(height uint64) (*globalState, error) {
 	if height == LastBlockHeight {
 		var err error
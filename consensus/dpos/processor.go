@@ -17,9 +17,11 @@
 package dpos
 
 import (
+	"errors"
 	"math/big"
 
 	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/utils/rlp"
 
 	"github.com/fractalplatform/fractal/params"
@@ -37,6 +39,10 @@ type UpdateProducer struct {
 	Stake *big.Int
 }
 
+type UpdateProducerKey struct {
+	Key common.PubKey
+}
+
 type VoteProducer struct {
 	Producer string
 	Stake    *big.Int
@@ -59,6 +65,28 @@ func (dpos *Dpos) ProcessAction(chainCfg *params.ChainConfig, state *state.State
 	return err
 }
 
+// ActivatedProducers returns the producers of the activated schedule in the
+// state.
+func (dpos *Dpos) ActivatedProducers(state *state.StateDB) ([]string, error) {
+	sys := &System{
+		config: dpos.config,
+		IDB: &LDB{
+			IDatabase: &stateDB{
+				name:  dpos.config.AccountName,
+				state: state,
+			},
+		},
+	}
+	gstate, err := sys.GetState(LastBlockHeight)
+	if err != nil {
+		return nil, err
+	}
+	if gstate == nil {
+		return nil, errors.New("producer schedule not found")
+	}
+	return gstate.ActivatedProducerSchedule, nil
+}
+
 func (dpos *Dpos) processAction(chainCfg *params.ChainConfig, state *state.StateDB, action *types.Action) error {
 	sys := &System{
 		config: dpos.config,
@@ -87,6 +115,14 @@ func (dpos *Dpos) processAction(chainCfg *params.ChainConfig, state *state.State
 		if err := sys.UpdateProducer(action.Sender().String(), arg.Url, arg.Stake); err != nil {
 			return err
 		}
+	case types.UpdateProducerKey:
+		arg := &UpdateProducerKey{}
+		if err := rlp.DecodeBytes(action.Data(), &arg); err != nil {
+			return err
+		}
+		if err := sys.UpdateProducerKey(action.Sender().String(), arg.Key); err != nil {
+			return err
+		}
 	case types.UnregProducer:
 		if err := sys.UnregProducer(action.Sender().String()); err != nil {
 			return err
@@ -21,6 +21,9 @@ import (
 	"math/big"
 	"math/rand"
 	"strings"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
 )
 
 type System struct {
@@ -143,6 +146,26 @@ func (sys *System) UpdateProducer(producer string, url string, stake *big.Int) e
 	return nil
 }
 
+// UpdateProducerKey sets the key a producer signs blocks with, so that its
+// owner key can stay offline. The empty key makes the producer sign with its
+// owner authority again.
+func (sys *System) UpdateProducerKey(producer string, key common.PubKey) error {
+	prod, err := sys.GetProducer(producer)
+	if err != nil {
+		return err
+	}
+	if prod == nil {
+		return fmt.Errorf("invalid producer %v(not exist)", producer)
+	}
+	if key == (common.PubKey{}) {
+		return sys.DelSigningKey(producer)
+	}
+	if _, err := crypto.UnmarshalPubkey(key.Bytes()); err != nil {
+		return fmt.Errorf("invalid signing key %v(%v)", key.Hex(), err)
+	}
+	return sys.SetSigningKey(producer, key.Bytes())
+}
+
 // UnregProducer  unregister a producer
 func (sys *System) UnregProducer(producer string) error {
 	// parameter validity
@@ -189,6 +212,9 @@ func (sys *System) UnregProducer(producer string) error {
 		if err := sys.DelProducer(prod.Name); err != nil {
 			return err
 		}
+		if err := sys.DelSigningKey(prod.Name); err != nil {
+			return err
+		}
 		gstate.TotalQuantity = new(big.Int).Sub(gstate.TotalQuantity, prod.Quantity)
 		if err := sys.SetState(gstate); err != nil {
 			return err
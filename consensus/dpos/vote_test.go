@@ -17,8 +17,12 @@
 package dpos
 
 import (
+	"bytes"
 	"math/big"
 	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
 )
 
 var (
@@ -197,3 +201,59 @@ func TestVote(t *testing.T) {
 		t.Errorf("wrong err: %v", err)
 	}
 }
+
+func TestUpdateProducerKey(t *testing.T) {
+	ldb, function := newTestLDB()
+	db, err := NewLDB(ldb)
+	defer function()
+	if err != nil {
+		t.Errorf("create db failed --- %v", err)
+	}
+	dpos := &System{
+		config: DefaultConfig,
+		IDB:    db,
+	}
+	dpos.SetState(&globalState{
+		Height:                 0,
+		ActivatedTotalQuantity: big.NewInt(0),
+	})
+
+	producer := "testproducer"
+	prikey, _ := crypto.GenerateKey()
+	key := common.BytesToPubKey(crypto.FromECDSAPub(&prikey.PublicKey))
+	if err := dpos.UpdateProducerKey(producer, key); err == nil {
+		t.Errorf("UpdateProducerKey of unknown producer should failed")
+	}
+	if err := dpos.RegProducer(producer, "testurl", minproducerstake); err != nil {
+		t.Fatalf("RegProducer failed --- %v", err)
+	}
+	if err := dpos.UpdateProducerKey(producer, common.BytesToPubKey([]byte{1, 2, 3})); err == nil {
+		t.Errorf("UpdateProducerKey with invalid key should failed")
+	}
+	if signingKey, err := dpos.GetSigningKey(producer); err != nil || signingKey != nil {
+		t.Errorf("signing key mismatch --- %v(%x)", err, signingKey)
+	}
+
+	if err := dpos.UpdateProducerKey(producer, key); err != nil {
+		t.Fatalf("UpdateProducerKey failed --- %v", err)
+	}
+	if signingKey, err := dpos.GetSigningKey(producer); err != nil || !bytes.Equal(signingKey, key.Bytes()) {
+		t.Errorf("signing key mismatch --- %v(%x)", err, signingKey)
+	}
+	if err := dpos.UpdateProducerKey(producer, common.PubKey{}); err != nil {
+		t.Fatalf("UpdateProducerKey failed --- %v", err)
+	}
+	if signingKey, err := dpos.GetSigningKey(producer); err != nil || signingKey != nil {
+		t.Errorf("cleared signing key remains --- %v(%x)", err, signingKey)
+	}
+
+	if err := dpos.UpdateProducerKey(producer, key); err != nil {
+		t.Fatalf("UpdateProducerKey failed --- %v", err)
+	}
+	if err := dpos.UnregProducer(producer); err != nil {
+		t.Fatalf("UnregProducer failed --- %v", err)
+	}
+	if signingKey, err := dpos.GetSigningKey(producer); err != nil || signingKey != nil {
+		t.Errorf("signing key of unregistered producer remains --- %v(%x)", err, signingKey)
+	}
+}
@@ -33,9 +33,11 @@ type API struct {
 	chain consensus.IChainReader
 }
 
-func (api *API) Start() bool {
-	api.miner.Start()
-	return true
+func (api *API) Start() (bool, error) {
+	if err := api.miner.Start(); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 func (api *API) Stop() bool {
@@ -71,6 +73,16 @@ func (api *API) SetExtra(extra []byte) error {
 	return nil
 }
 
+// SetGasLimit sets the gas limit voted for in the blocks mined, zero removes
+// the vote.
+func (api *API) SetGasLimit(target uint64) error {
+	if target != 0 && target < params.MinGasLimit {
+		return fmt.Errorf("gas limit below minimum. %d < %v", target, params.MinGasLimit)
+	}
+	api.miner.SetGasLimit(target)
+	return nil
+}
+
 func (miner *Miner) APIs(chain consensus.IChainReader) []rpc.API {
 	apis := []rpc.API{
 		{
@@ -18,14 +18,20 @@ package miner
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"sync/atomic"
 
-	"github.com/ethereum/go-ethereum/log"
+	"github.com/fractalplatform/fractal/blockchain"
 	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/state"
 	"github.com/fractalplatform/fractal/types"
 )
 
+// ErrHeaderOnlyChain is returned starting the miner of a chain storing the
+// headers only, which keeps no state to mint blocks on.
+var ErrHeaderOnlyChain = errors.New("can't mine on a header-only chain")
+
 // Miner creates blocks and searches for proof values.
 type Miner struct {
 	worker *Worker
@@ -33,6 +39,7 @@ type Miner struct {
 	mining      int32
 	canStart    int32 // can start indicates whether we can start the mining operation
 	shouldStart int32 // should start indicates whether we should start after sync
+	disabled    error // reason the mining operation can't start, set before it does
 }
 
 // NewMiner creates a miner.
@@ -79,19 +86,29 @@ func (miner *Miner) update() {
 	// 	}
 }
 
+// Disable refuses to start the mining operation for the reason, it must be
+// called before the miner is started.
+func (miner *Miner) Disable(reason error) {
+	miner.disabled = reason
+}
+
 // Start start worker
-func (miner *Miner) Start() {
+func (miner *Miner) Start() error {
+	if miner.disabled != nil {
+		return miner.disabled
+	}
 	atomic.StoreInt32(&miner.shouldStart, 1)
 	if atomic.LoadInt32(&miner.canStart) == 0 {
 		log.Error("Network syncing, will start miner afterwards")
-		return
+		return nil
 	}
 	if !atomic.CompareAndSwapInt32(&miner.mining, 0, 1) {
 		log.Error("miner already started")
-		return
+		return nil
 	}
 	log.Info("Starting mining operation")
 	miner.worker.start()
+	return nil
 }
 
 // Stop stop worker
@@ -120,6 +137,26 @@ func (miner *Miner) SetCoinbase(name string, privKey *ecdsa.PrivateKey) {
 	miner.worker.setCoinbase(name, privKey)
 }
 
+// SetGasLimit sets the gas limit the producer votes for, the gas limit of the
+// blocks mined moves towards it within the bounds of the validators. Zero
+// removes the vote.
+func (miner *Miner) SetGasLimit(target uint64) {
+	miner.worker.setGasTarget(target)
+}
+
+// SetClockDrift checks the drift of the local clock before minting a block,
+// warning when it exceeds the threshold of the estimator, or skipping the
+// slot if refuse is set.
+func (miner *Miner) SetClockDrift(clock *blockchain.ClockDrift, refuse bool) {
+	miner.worker.setClockDrift(clock, refuse)
+}
+
+// SetCheckpoints lets the producer sign the checkpoints of the pool while
+// mining.
+func (miner *Miner) SetCheckpoints(checkpoints *blockchain.CheckpointPool) {
+	miner.worker.setCheckpoints(checkpoints)
+}
+
 // SetExtra extra data
 func (miner *Miner) SetExtra(extra []byte) {
 	miner.worker.setExtra(extra)
@@ -28,13 +28,14 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
+	"github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/blockchain"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/consensus"
 	"github.com/fractalplatform/fractal/consensus/dpos"
 	"github.com/fractalplatform/fractal/crypto"
 	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/processor"
 	"github.com/fractalplatform/fractal/processor/vm"
@@ -47,6 +48,9 @@ const (
 	txChanSize = 4096
 	// chainHeadChanSize is the size of channel listening to ChainHeadEvent.
 	chainHeadChanSize = 10
+	// blockOverhead is the size reserved in a block for the header and the
+	// encoding of the transaction list.
+	blockOverhead = 1024
 )
 
 // Worker is the main object which takes care of applying messages to the new state
@@ -60,8 +64,17 @@ type Worker struct {
 
 	currentWork *Work
 
+	gasTarget uint64 // gas limit voted by the producer, zero if none
+
+	clock       *blockchain.ClockDrift // drift of the local clock from the network, unchecked if nil
+	refuseDrift bool                   // whether to skip the slots while the drift exceeds its threshold
+
+	checkpoints *blockchain.CheckpointPool // checkpoints signed by the producer, none if nil
+	voting      int32                      // set while a checkpoint is signed
+
 	mining int32
 	quit   chan struct{}
+	wg     sync.WaitGroup
 }
 
 func newWorker(consensus consensus.IConsensus) *Worker {
@@ -77,11 +90,11 @@ func newWorker(consensus consensus.IConsensus) *Worker {
 // update keeps track of events.
 func (worker *Worker) update() {
 	txsCh := make(chan *event.Event, txChanSize)
-	txsSub := event.Subscribe(nil, txsCh, event.TxEv, []*types.Transaction{})
+	txsSub := worker.Router().Subscribe(nil, txsCh, event.TxEv, []*types.Transaction{})
 	defer txsSub.Unsubscribe()
 
 	chainHeadCh := make(chan *event.Event, chainHeadChanSize)
-	chainHeadSub := event.Subscribe(nil, chainHeadCh, event.ChainHeadEv, &types.Block{})
+	chainHeadSub := worker.Router().Subscribe(nil, chainHeadCh, event.ChainHeadEv, &types.Block{})
 	defer chainHeadSub.Unsubscribe()
 out:
 	for {
@@ -102,7 +115,7 @@ out:
 					from := action.Sender()
 					txs[from] = append(txs[from], tx)
 				}
-				worker.commitTransactions(worker.currentWork, types.NewTransactionsByPriceAndNonce(txs), uint64(time.Second))
+				worker.commitTransactions(worker.currentWork, worker.sortTransactions(worker.currentWork, txs), uint64(time.Second))
 			}
 			// System stopped
 		case <-txsSub.Err():
@@ -118,7 +131,11 @@ func (worker *Worker) start() {
 		log.Warn("worker already started")
 		return
 	}
-	go worker.mintLoop()
+	worker.wg.Add(1)
+	go func() {
+		defer worker.wg.Done()
+		worker.mintLoop()
+	}()
 }
 
 func (worker *Worker) mintLoop() {
@@ -136,6 +153,15 @@ func (worker *Worker) mintLoop() {
 		select {
 		case now := <-ticker:
 			worker.mintBlock(int64(dpos.Slot(uint64(now.UnixNano()))))
+			// hashing the state of a checkpoint takes longer than a slot
+			if atomic.CompareAndSwapInt32(&worker.voting, 0, 1) {
+				worker.wg.Add(1)
+				go func() {
+					defer worker.wg.Done()
+					defer atomic.StoreInt32(&worker.voting, 0)
+					worker.voteCheckpoint()
+				}()
+			}
 		case <-worker.quit:
 			worker.quit = make(chan struct{})
 			return
@@ -155,6 +181,15 @@ func (worker *Worker) mintBlock(timestamp int64) {
 		log.Debug("failed to mint the block", "timestamp", timestamp, "err", err)
 		return
 	}
+	// The slots are assigned by time, a drifting clock produces out of them.
+	if clock, refuse := worker.clockDrift(); clock != nil && clock.Exceeded() {
+		drift, _ := clock.Drift()
+		if refuse {
+			log.Error("Refusing to mint the block, local clock drifts from the network", "timestamp", timestamp, "drift", drift)
+			return
+		}
+		log.Warn("Minting the block although local clock drifts from the network", "timestamp", timestamp, "drift", drift)
+	}
 	bstart := time.Now()
 outer:
 	for i := 0; i < 10; i++ {
@@ -176,12 +211,32 @@ outer:
 	}
 }
 
+// voteCheckpoint signs the due checkpoint as the producer.
+func (worker *Worker) voteCheckpoint() {
+	worker.mu.Lock()
+	checkpoints, producer, privKey := worker.checkpoints, worker.coinbase, worker.privKey
+	worker.mu.Unlock()
+	if checkpoints == nil || privKey == nil {
+		return
+	}
+	vote, err := checkpoints.Vote(producer, func(hash []byte) ([]byte, error) {
+		return crypto.Sign(hash, privKey)
+	})
+	if err != nil {
+		log.Debug("Failed to sign the checkpoint", "producer", producer, "err", err)
+	} else if vote != nil {
+		log.Info("Signed checkpoint", "producer", producer, "number", vote.Checkpoint.Number, "hash", vote.Checkpoint.Hash)
+	}
+}
+
 func (worker *Worker) stop() {
 	if !atomic.CompareAndSwapInt32(&worker.mining, 1, 0) {
 		log.Warn("woker already stopped")
 		return
 	}
 	close(worker.quit)
+	// wait for the block being minted
+	worker.wg.Wait()
 }
 
 func (worker *Worker) setCoinbase(name string, privKey *ecdsa.PrivateKey) {
@@ -197,6 +252,29 @@ func (worker *Worker) setExtra(extra []byte) {
 	worker.extra = extra
 }
 
+func (worker *Worker) setClockDrift(clock *blockchain.ClockDrift, refuse bool) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+	worker.clock = clock
+	worker.refuseDrift = refuse
+}
+
+func (worker *Worker) setCheckpoints(checkpoints *blockchain.CheckpointPool) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+	worker.checkpoints = checkpoints
+}
+
+func (worker *Worker) clockDrift() (*blockchain.ClockDrift, bool) {
+	worker.mu.Lock()
+	defer worker.mu.Unlock()
+	return worker.clock, worker.refuseDrift
+}
+
+func (worker *Worker) setGasTarget(target uint64) {
+	atomic.StoreUint64(&worker.gasTarget, target)
+}
+
 func (worker *Worker) pending() (*types.Block, *state.StateDB) {
 	worker.mu.Lock()
 	defer worker.mu.Unlock()
@@ -252,7 +330,7 @@ func (worker *Worker) commitNewWork(timestamp int64) (*types.Block, error) {
 		return nil, fmt.Errorf("got error when fetch pending transactions, err: %v", err)
 	}
 
-	txs := types.NewTransactionsByPriceAndNonce(pending)
+	txs := worker.sortTransactions(work, pending)
 	worker.commitTransactions(work, txs, dpos.BlockInterval())
 
 	worker.mu.Lock()
@@ -283,17 +361,22 @@ func (worker *Worker) commitNewWork(timestamp int64) (*types.Block, error) {
 		if bytes.Compare(block.ParentHash().Bytes(), worker.CurrentHeader().Hash().Bytes()) != 0 {
 			return nil, fmt.Errorf("old parent hash")
 		}
+		changes, err := accountmanager.BalanceChanges(work.currentState)
+		if err != nil {
+			return nil, fmt.Errorf("balance changes of block, err: %v", err)
+		}
 		if err := worker.WriteBlockWithState(block, work.currentReceipts, work.currentState); err != nil {
 			return nil, fmt.Errorf("writing block to chain, err: %v", err)
 		}
 
-		event.SendEvent(&event.Event{Typecode: event.ChainHeadEv, Data: block})
-		event.SendEvent(&event.Event{Typecode: event.ChainEv, Data: blockchain.ChainEvent{
-			Block: block,
-			Hash:  block.Hash(),
-			Logs:  logs,
+		worker.Router().SendEvent(&event.Event{Typecode: event.ChainHeadEv, Data: block})
+		worker.Router().SendEvent(&event.Event{Typecode: event.ChainEv, Data: blockchain.ChainEvent{
+			Block:          block,
+			Hash:           block.Hash(),
+			Logs:           logs,
+			BalanceChanges: changes,
 		}})
-		event.SendEvent(&event.Event{Typecode: event.NewMinedEv, Data: blockchain.NewMinedBlockEvent{
+		worker.Router().SendEvent(&event.Event{Typecode: event.NewMinedEv, Data: blockchain.NewMinedBlockEvent{
 			Block: block,
 		}})
 		return block, nil
@@ -303,9 +386,43 @@ func (worker *Worker) commitNewWork(timestamp int64) (*types.Block, error) {
 	return block, nil
 }
 
+// sortTransactions orders the transactions to assemble a block of: grouped
+// by sender in nonce order, the groups by the gas price of their next
+// transaction exchanged to the fee token on the state of the work, highest
+// first. Transactions paying gas in an asset without fee rate sort last.
+func (worker *Worker) sortTransactions(work *Work, txs map[common.Name][]*types.Transaction) *types.TransactionsByPriceAndNonce {
+	cfg := worker.Config()
+	if !cfg.IsFeeAsset(work.currentHeader.Number.Uint64()) {
+		return types.NewTransactionsByPriceAndNonce(txs)
+	}
+	feeTokenID := cfg.FeeTokenID
+	accountDB, err := accountmanager.NewAccountManager(work.currentState)
+	if err != nil {
+		log.Warn("Failed to exchange gas prices, sorting by nominal price", "err", err)
+		return types.NewTransactionsByPriceAndNonce(txs)
+	}
+	return types.NewTransactionsByFeeAndNonce(txs, func(tx *types.Transaction) *big.Int {
+		if tx.GasAssetID() == feeTokenID {
+			return tx.GasPrice()
+		}
+		rate, err := accountDB.GetFeeRate(tx.GasAssetID())
+		if err != nil || rate == nil {
+			return new(big.Int)
+		}
+		price := new(big.Int).Mul(tx.GasPrice(), rate.Den)
+		return price.Div(price, rate.Num)
+	})
+}
+
 func (worker *Worker) commitTransactions(work *Work, txs *types.TransactionsByPriceAndNonce, interval uint64) {
 	var coalescedLogs []*types.Log
+	limits := worker.Config().BlockLimits
 	for {
+		if uint64(len(work.currentTxs)) >= limits.BlockTxs() {
+			log.Debug("Not enough room for further transactions", "have", len(work.currentTxs))
+			break
+		}
+
 		if work.currentGasPool.Gas() < params.ActionGas {
 			log.Debug("Not enough gas for further transactions", "have", work.currentGasPool, "want", params.ActionGas)
 			break
@@ -323,6 +440,18 @@ func (worker *Worker) commitTransactions(work *Work, txs *types.TransactionsByPr
 			break
 		}
 
+		if n := uint64(len(tx.GetActions())); n == 0 || n > limits.TxActions() {
+			log.Trace("Skipping transaction with invalid number of actions", "hash", tx.Hash(), "actions", n)
+			txs.Pop()
+			continue
+		}
+		if work.currentSize+uint64(tx.Size())+blockOverhead > limits.BlockSize() {
+			// Pop the current oversized transaction without shifting in the next from the account
+			log.Trace("Block size exceeded for current block", "hash", tx.Hash(), "size", tx.Size())
+			txs.Pop()
+			continue
+		}
+
 		action := tx.GetActions()[0]
 
 		from := action.Sender()
@@ -346,6 +475,16 @@ func (worker *Worker) commitTransactions(work *Work, txs *types.TransactionsByPr
 			log.Trace("Skipping account with hight nonce", "sender", from, "nonce", action.Nonce())
 			txs.Pop()
 
+		case accountmanager.ErrRateLimited:
+			// The account sent its most actions of the window, keep the rest for later blocks
+			log.Trace("Skipping rate limited account", "sender", from)
+			txs.Pop()
+
+		case accountmanager.ErrResourceExhausted:
+			// The gas quota of the account is used up, keep its free transactions for later blocks
+			log.Trace("Skipping account with exhausted gas quota", "sender", from)
+			txs.Pop()
+
 		case nil:
 			// Everything ok, collect the logs and shift in the next transaction from the same account
 			coalescedLogs = append(coalescedLogs, logs...)
@@ -378,6 +517,7 @@ func (worker *Worker) commitTransaction(work *Work, tx *types.Transaction) ([]*t
 	}
 	work.currentTxs = append(work.currentTxs, tx)
 	work.currentReceipts = append(work.currentReceipts, receipt)
+	work.currentSize += uint64(tx.Size())
 	return receipt.Logs, nil
 }
 
@@ -385,11 +525,15 @@ func (worker *Worker) calcGasLimit(parent *types.Block) uint64 {
 	if atomic.LoadInt32(&worker.mining) == 0 {
 		return math.MaxUint64
 	}
+	if target := atomic.LoadUint64(&worker.gasTarget); target > 0 {
+		return params.CalcGasLimitTarget(parent, target)
+	}
 	return worker.IConsensus.CalcGasLimit(parent)
 }
 
 type Work struct {
 	currentCnt      int
+	currentSize     uint64
 	currentGasPool  *common.GasPool
 	currentHeader   *types.Header
 	currentTxs      []*types.Transaction
@@ -0,0 +1,93 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+// testEngine applies transactions without executing them, refusing the ones
+// of the rate limited accounts.
+type testEngine struct {
+	consensus.IConsensus
+	limited map[common.Name]bool
+	applied []*types.Transaction
+}
+
+func (e *testEngine) Config() *params.ChainConfig {
+	return params.DefaultChainconfig
+}
+
+func (e *testEngine) ApplyTransaction(coinbase *common.Name, gp *common.GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config) (*types.Receipt, uint64, error) {
+	e.applied = append(e.applied, tx)
+	if e.limited[tx.GetActions()[0].Sender()] {
+		return nil, 0, accountmanager.ErrRateLimited
+	}
+	return &types.Receipt{}, 0, nil
+}
+
+func TestCommitRateLimitedTransactions(t *testing.T) {
+	var (
+		limited = common.Name("limited")
+		sender  = common.Name("sender")
+		engine  = &testEngine{limited: map[common.Name]bool{limited: true}}
+		worker  = &Worker{IConsensus: engine}
+	)
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	work := &Work{
+		currentGasPool: new(common.GasPool).AddGas(math.MaxUint64),
+		currentHeader:  &types.Header{Number: big.NewInt(1), Time: new(big.Int).SetUint64(uint64(time.Now().UnixNano()))},
+		currentState:   statedb,
+	}
+	newTx := func(from common.Name, nonce uint64, price int64) *types.Transaction {
+		action := types.NewAction(types.Transfer, from, "receiver", nonce, 0, params.ActionGas, big.NewInt(1), nil)
+		return types.NewTransaction(0, big.NewInt(price), action)
+	}
+	txs := map[common.Name][]*types.Transaction{
+		limited: {newTx(limited, 0, 2), newTx(limited, 1, 2)},
+		sender:  {newTx(sender, 0, 1), newTx(sender, 1, 1)},
+	}
+	worker.commitTransactions(work, types.NewTransactionsByPriceAndNonce(txs), uint64(time.Hour))
+
+	// The limited account is skipped after its first refused transaction
+	if len(engine.applied) != 3 {
+		t.Fatalf("applied transactions mismatch: have %d, want 3", len(engine.applied))
+	}
+	if len(work.currentTxs) != 2 {
+		t.Fatalf("included transactions mismatch: have %d, want 2", len(work.currentTxs))
+	}
+	for i, tx := range work.currentTxs {
+		if from := tx.GetActions()[0].Sender(); from != sender {
+			t.Errorf("transaction %d: sender mismatch: have %s, want %s", i, from, sender)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package event
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var (
+	// ErrUnknownPayload is returned when decoding the payload of a typecode
+	// events aren't exchanged with other nodes for.
+	ErrUnknownPayload = errors.New("unknown event payload")
+	// ErrPayloadVersion is returned when decoding a payload of another
+	// version than the registered one.
+	ErrPayloadVersion = errors.New("event payload version mismatch")
+	// ErrInvalidPayload is returned when a payload doesn't decode to, or
+	// doesn't validate as, the data registered for its typecode.
+	ErrInvalidPayload = errors.New("invalid event payload")
+)
+
+// payloadCodec describes the data carried between nodes by the events of a
+// typecode.
+type payloadCodec struct {
+	version  uint
+	validate func(data interface{}) error
+	legacy   map[uint]*legacyCodec
+}
+
+// legacyCodec describes an older encoding of a payload still accepted from,
+// and sent to, nodes speaking an older protocol.
+type legacyCodec struct {
+	typ     reflect.Type
+	upgrade func(data interface{}) interface{}
+}
+
+// Limits guarding the decoding of payloads received from other nodes against
+// inputs claiming huge allocations.
+const (
+	maxPayloadSize  = 16 * 1024 * 1024
+	maxPayloadElems = 1 << 16
+)
+
+var payloads [EndSize]*payloadCodec
+
+// RegisterPayload registers the data carried between nodes by the events of a
+// typecode, with the version of its encoding and an optional function checking
+// the shape of the decoded data. The version must be bumped on each change of
+// the encoding. Events of typecodes without a payload are local only and are
+// never accepted from other nodes. Registering a typecode twice panics.
+func RegisterPayload(typecode int, version uint, data interface{}, validate func(data interface{}) error) {
+	if typecode < 0 || typecode >= EndSize {
+		panic(fmt.Sprintf("payload typecode %d out of range", typecode))
+	}
+	if payloads[typecode] != nil {
+		panic(fmt.Sprintf("payload of typecode %d registered twice", typecode))
+	}
+	if data == nil {
+		panic(fmt.Sprintf("payload of typecode %d without data", typecode))
+	}
+	bindTypeToCode(typecode, data)
+	payloads[typecode] = &payloadCodec{version: version, validate: validate}
+}
+
+// RegisterLegacyPayload registers an older version of the payload of a
+// typecode, encoded as data. Received payloads of that version are decoded
+// into data and converted by upgrade into the data registered for the
+// typecode. Events carrying data are sent at that version. The payload of
+// the typecode must be registered first.
+func RegisterLegacyPayload(typecode int, version uint, data interface{}, upgrade func(data interface{}) interface{}) {
+	if typecode < 0 || typecode >= EndSize || payloads[typecode] == nil {
+		panic(fmt.Sprintf("legacy payload of typecode %d registered before its payload", typecode))
+	}
+	codec := payloads[typecode]
+	if version >= codec.version || codec.legacy[version] != nil {
+		panic(fmt.Sprintf("legacy payload version %d of typecode %d invalid", version, typecode))
+	}
+	if codec.legacy == nil {
+		codec.legacy = make(map[uint]*legacyCodec)
+	}
+	codec.legacy[version] = &legacyCodec{typ: reflect.TypeOf(data), upgrade: upgrade}
+}
+
+// PayloadVersion returns the version of the payload registered for a
+// typecode, 0 if there is none.
+func PayloadVersion(typecode int) uint {
+	if typecode < 0 || typecode >= EndSize || payloads[typecode] == nil {
+		return 0
+	}
+	return payloads[typecode].version
+}
+
+// PayloadVersionOf returns the version a payload of a typecode is encoded at
+// when carrying data: the legacy version registered for its type, the
+// version of the typecode otherwise.
+func PayloadVersionOf(typecode int, data interface{}) uint {
+	if typecode < 0 || typecode >= EndSize || payloads[typecode] == nil {
+		return 0
+	}
+	codec := payloads[typecode]
+	typ := reflect.TypeOf(data)
+	for version, legacy := range codec.legacy {
+		if legacy.typ == typ {
+			return version
+		}
+	}
+	return codec.version
+}
+
+// EncodePayload encodes the data of an event sent to other nodes.
+func EncodePayload(typecode int, data interface{}) ([]byte, error) {
+	if typecode < 0 || typecode >= EndSize || payloads[typecode] == nil {
+		return nil, ErrUnknownPayload
+	}
+	return rlp.EncodeToBytes(data)
+}
+
+// DecodePayload decodes the data of an event received from another node into
+// the type registered for its typecode and checks its shape. Payloads of
+// typecodes without a registered payload, of another version or of another
+// shape are rejected. Payloads of a registered legacy version are upgraded.
+func DecodePayload(typecode int, version uint, payload []byte) (interface{}, error) {
+	if typecode < 0 || typecode >= EndSize || payloads[typecode] == nil {
+		return nil, ErrUnknownPayload
+	}
+	codec := payloads[typecode]
+	legacy := codec.legacy[version]
+	if version != codec.version && legacy == nil {
+		return nil, ErrPayloadVersion
+	}
+	if len(payload) > maxPayloadSize {
+		return nil, fmt.Errorf("%v: payload of %d bytes exceeds %d", ErrInvalidPayload, len(payload), maxPayloadSize)
+	}
+	typ := typeList[typecode]
+	if legacy != nil {
+		typ = legacy.typ
+	}
+	data, err := decodePayloadData(typ, payload)
+	if err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		data = legacy.upgrade(data)
+	}
+	if codec.validate != nil {
+		if err := codec.validate(data); err != nil {
+			return nil, fmt.Errorf("%v: %v", ErrInvalidPayload, err)
+		}
+	}
+	return data, nil
+}
+
+// decodePayloadData decodes a payload into a new value of type typ.
+func decodePayloadData(typ reflect.Type, payload []byte) (interface{}, error) {
+	isPtr := typ.Kind() == reflect.Ptr
+	if isPtr {
+		typ = typ.Elem()
+	}
+	obj := reflect.New(typ)
+	if err := rlp.DecodeBytesLimit(payload, obj.Interface(), maxPayloadElems); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidPayload, err)
+	}
+	if !isPtr {
+		return obj.Elem().Interface(), nil
+	}
+	return obj.Interface(), nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package event
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+// testPayloadCode is a typecode the tests of this package don't subscribe to.
+const testPayloadCode = StateItemsMsg
+
+type testPayload struct {
+	Name  string
+	Items []uint64
+}
+
+// legacyTestPayload is version 1 of testPayload.
+type legacyTestPayload struct {
+	Name string
+}
+
+func TestDecodePayload(t *testing.T) {
+	RegisterPayload(testPayloadCode, 2, &testPayload{}, func(data interface{}) error {
+		if data.(*testPayload).Name == "" {
+			return errors.New("no name")
+		}
+		return nil
+	})
+	if v := PayloadVersion(testPayloadCode); v != 2 {
+		t.Fatalf("version mismatch: have %d, want 2", v)
+	}
+
+	want := &testPayload{Name: "test", Items: []uint64{1, 2}}
+	payload, err := EncodePayload(testPayloadCode, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := DecodePayload(testPayloadCode, 2, payload)
+	if err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if have, ok := data.(*testPayload); !ok || have.Name != want.Name || len(have.Items) != 2 {
+		t.Fatalf("payload mismatch: have %v, want %v", data, want)
+	}
+
+	if _, err := DecodePayload(testPayloadCode, 1, payload); err != ErrPayloadVersion {
+		t.Fatalf("other version: error mismatch: have %v, want %v", err, ErrPayloadVersion)
+	}
+	if _, err := DecodePayload(P2pBanPeer, 0, payload); err != ErrUnknownPayload {
+		t.Fatalf("local typecode: error mismatch: have %v, want %v", err, ErrUnknownPayload)
+	}
+	if _, err := DecodePayload(EndSize+1, 0, payload); err != ErrUnknownPayload {
+		t.Fatalf("unknown typecode: error mismatch: have %v, want %v", err, ErrUnknownPayload)
+	}
+	if _, err := EncodePayload(P2pBanPeer, want); err != ErrUnknownPayload {
+		t.Fatalf("local typecode encoded: error mismatch: have %v, want %v", err, ErrUnknownPayload)
+	}
+
+	malformed, _ := rlp.EncodeToBytes([]string{"test"})
+	if _, err := DecodePayload(testPayloadCode, 2, malformed); err == nil {
+		t.Fatal("malformed payload decoded")
+	}
+	unnamed, _ := rlp.EncodeToBytes(&testPayload{Items: []uint64{1}})
+	if _, err := DecodePayload(testPayloadCode, 2, unnamed); err == nil {
+		t.Fatal("invalid payload accepted")
+	}
+	oversized, _ := rlp.EncodeToBytes(&testPayload{Name: "test", Items: make([]uint64, maxPayloadElems+1)})
+	if _, err := DecodePayload(testPayloadCode, 2, oversized); err == nil {
+		t.Fatal("payload with too many elements decoded")
+	}
+
+	// Payloads of the legacy version are sent at it and upgraded when decoded.
+	RegisterLegacyPayload(testPayloadCode, 1, &legacyTestPayload{}, func(data interface{}) interface{} {
+		return &testPayload{Name: data.(*legacyTestPayload).Name}
+	})
+	legacy := &legacyTestPayload{Name: "legacy"}
+	if v := PayloadVersionOf(testPayloadCode, legacy); v != 1 {
+		t.Fatalf("legacy version mismatch: have %d, want 1", v)
+	}
+	if v := PayloadVersionOf(testPayloadCode, want); v != 2 {
+		t.Fatalf("version mismatch: have %d, want 2", v)
+	}
+	payload, err = EncodePayload(testPayloadCode, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = DecodePayload(testPayloadCode, 1, payload)
+	if err != nil {
+		t.Fatalf("failed to decode legacy payload: %v", err)
+	}
+	if have, ok := data.(*testPayload); !ok || have.Name != legacy.Name {
+		t.Fatalf("upgraded payload mismatch: have %v, want %v", data, legacy)
+	}
+	unnamed, _ = rlp.EncodeToBytes(&legacyTestPayload{})
+	if _, err := DecodePayload(testPayloadCode, 1, unnamed); err == nil {
+		t.Fatal("invalid legacy payload accepted")
+	}
+	if _, err := DecodePayload(testPayloadCode, 0, payload); err != ErrPayloadVersion {
+		t.Fatalf("unknown version: error mismatch: have %v, want %v", err, ErrPayloadVersion)
+	}
+}
@@ -32,6 +32,7 @@ type Router struct {
 	unnamedFeeds map[int]*Feed
 	namedFeeds   map[string]map[int]*Feed
 	adaptor      ProtoAdaptor
+	relays       map[Partition]Station // relay station of each partition
 	mutex        sync.RWMutex
 	stations     map[string]Station
 	stationMutex sync.RWMutex
@@ -74,6 +75,37 @@ const (
 
 	NewMinedEv
 
+	P2pBanPeer // ban and disconnect a misbehaving remote station
+
+	TxHashMsg // announce transaction hashes
+	GetTxsMsg // request transactions by hash
+
+	NewBlockMsg // propagate a full block
+
+	DownloaderGetBlockStatesMsg // request the state changes and receipts of blocks
+	BlockStatesMsg              // state changes and receipts of blocks
+
+	SyncModeEv // the downloader switched its sync mode
+
+	DownloaderGetStateHashesMsg // request the keys and value hashes of a state
+	StateHashesMsg              // keys and value hashes of a state
+	DownloaderGetStateItemsMsg  // request the values of state keys
+	StateItemsMsg               // values of state keys
+
+	RelayEv // an event to a station of another partition, handed to the relay of the partition
+
+	DownloaderGetBlockTxHashesMsg // request the transaction hashes of a block
+	BlockTxHashesMsg              // transaction hashes of a block
+	DownloaderGetBlockTxChunkMsg  // request a chunk of the transactions of a block
+	BlockTxChunkMsg               // chunk of the transactions of a block, or the parity of the chunks
+
+	TxExpiredEv  // a transaction dropped from the pool after its lifetime
+	TxConflictEv // two different transactions spending the same nonce of an account
+
+	CheckpointVoteMsg // signature of a producer on a checkpoint
+	GetCheckpointMsg  // request the latest checkpoint signed by a producer quorum
+	CheckpointMsg     // latest checkpoint signed by a producer quorum
+
 	EndSize
 )
 
@@ -86,25 +118,49 @@ var typeList = [EndSize]reflect.Type{
 	P2pNewPeer:       nil,
 	P2pDelPeer:       nil,
 	P2pDisconectPeer: nil,
+	P2pBanPeer:       nil,
 	ChainEv:          nil,
 	ChainSideEv:      nil,
 	ChainHeadEv:      nil,
 	LogsEv:           nil,
 	TxEv:             nil,
+	SyncModeEv:       nil,
+	TxExpiredEv:      nil,
+	TxConflictEv:     nil,
 }
 
-func InitRounter() {
-	router = &Router{
+// NewRouter creates a router independent of the default one, letting several
+// nodes run in a process. The methods of a nil router use the default one.
+func NewRouter() *Router {
+	return &Router{
 		unnamedFeeds: make(map[int]*Feed),
 		namedFeeds:   make(map[string]map[int]*Feed),
+		relays:       make(map[Partition]Station),
 		stations:     make(map[string]Station),
 	}
+}
+
+func InitRounter() {
+	router = NewRouter()
 	clear = make([]Subscription, 0)
 }
 
+// get returns the router, the default one if nil.
+func (r *Router) get() *Router {
+	if r == nil {
+		return router
+	}
+	return r
+}
+
 // ReplyEvent is equivalent to `SendTo(e.To, e.From, typecode, data)`
 func ReplyEvent(e *Event, typecode int, data interface{}) {
-	SendEvent(&Event{
+	router.ReplyEvent(e, typecode, data)
+}
+
+// ReplyEvent is equivalent to `r.SendTo(e.To, e.From, typecode, data)`
+func (r *Router) ReplyEvent(e *Event, typecode int, data interface{}) {
+	r.SendEvent(&Event{
 		From:     e.To,
 		To:       e.From,
 		Typecode: typecode,
@@ -136,83 +192,129 @@ func bindTypeToCode(typecode int, data interface{}) {
 
 // GetStationByName retrun Station by Station's name
 func GetStationByName(name string) Station {
-	router.stationMutex.RLock()
-	defer router.stationMutex.RUnlock()
-	return router.stations[name]
+	return router.GetStationByName(name)
+}
+
+// GetStationByName returns the station registered to the router by name.
+func (r *Router) GetStationByName(name string) Station {
+	r = r.get()
+	r.stationMutex.RLock()
+	defer r.stationMutex.RUnlock()
+	return r.stations[name]
 }
 
 // StationRegister register 'Station' to Router
 func StationRegister(station Station) {
-	router.stationMutex.Lock()
-	router.stations[station.Name()] = station
-	router.stationMutex.Unlock()
+	router.StationRegister(station)
+}
+
+// StationRegister registers the station to the router.
+func (r *Router) StationRegister(station Station) {
+	r = r.get()
+	r.stationMutex.Lock()
+	r.stations[station.Name()] = station
+	r.stationMutex.Unlock()
 }
 
 // StationUnregister unregister 'Station'
 func StationUnregister(station Station) {
-	router.stationMutex.Lock()
-	delete(router.stations, station.Name())
-	router.stationMutex.Unlock()
+	router.StationUnregister(station)
 }
 
-func bindChannelToStation(station Station, typecode int, channel chan *Event) Subscription {
+// StationUnregister unregisters the station from the router.
+func (r *Router) StationUnregister(station Station) {
+	r = r.get()
+	r.stationMutex.Lock()
+	delete(r.stations, station.Name())
+	r.stationMutex.Unlock()
+}
+
+func (r *Router) bindChannelToStation(station Station, typecode int, channel chan *Event) Subscription {
 	name := station.Name()
-	_, ok := router.namedFeeds[name]
+	_, ok := r.namedFeeds[name]
 	if !ok {
-		router.namedFeeds[name] = make(map[int]*Feed)
+		r.namedFeeds[name] = make(map[int]*Feed)
 	}
-	feed, ok := router.namedFeeds[name][typecode]
+	feed, ok := r.namedFeeds[name][typecode]
 	if !ok {
 		feed = &Feed{}
-		router.namedFeeds[name][typecode] = feed
+		r.namedFeeds[name][typecode] = feed
 	}
 	return feed.Subscribe(channel)
 }
 
-func bindChannelToTypecode(typecode int, channel chan *Event) Subscription {
-	feed, ok := router.unnamedFeeds[typecode]
+func (r *Router) bindChannelToTypecode(typecode int, channel chan *Event) Subscription {
+	feed, ok := r.unnamedFeeds[typecode]
 	if !ok {
 		feed = &Feed{}
-		router.unnamedFeeds[typecode] = feed
+		r.unnamedFeeds[typecode] = feed
 	}
 	return feed.Subscribe(channel)
 }
 
 // Subscribe .
 func Subscribe(station Station, channel chan *Event, typecode int, data interface{}) Subscription {
-	router.mutex.Lock()
-	defer router.mutex.Unlock()
+	return router.Subscribe(station, channel, typecode, data)
+}
+
+// Subscribe subscribes the channel to the events of the typecode sent to the
+// station, or to any station if nil.
+func (r *Router) Subscribe(station Station, channel chan *Event, typecode int, data interface{}) Subscription {
+	r = r.get()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
 	bindTypeToCode(typecode, data)
 
 	var sub Subscription
 
 	if station != nil {
-		StationRegister(station)
-		sub = bindChannelToStation(station, typecode, channel)
+		r.StationRegister(station)
+		sub = r.bindChannelToStation(station, typecode, channel)
 	} else {
-		sub = bindChannelToTypecode(typecode, channel)
+		sub = r.bindChannelToTypecode(typecode, channel)
+	}
+	if r == router {
+		// Clear unsubscribes from the default router only
+		clear = append(clear, sub)
 	}
-	clear = append(clear, sub)
 	return sub
 }
 
 // AdaptorRegister register P2P interface to Router
 func AdaptorRegister(adaptor ProtoAdaptor) {
-	router.mutex.Lock()
-	defer router.mutex.Unlock()
-	if router.adaptor == nil {
-		router.adaptor = adaptor
+	router.AdaptorRegister(adaptor)
+}
+
+// AdaptorRegister registers the adaptor sending out the events addressed to
+// remote stations, unless one is registered already.
+func (r *Router) AdaptorRegister(adaptor ProtoAdaptor) {
+	r = r.get()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.adaptor == nil {
+		r.adaptor = adaptor
 	}
 }
 
 // SendTo  is equivalent to SendEvent(&Event{From: from, To: to, Type: typecode, Data: data})
 func SendTo(from, to Station, typecode int, data interface{}) int {
-	return SendEvent(&Event{From: from, To: to, Typecode: typecode, Data: data})
+	return router.SendTo(from, to, typecode, data)
+}
+
+// SendTo is equivalent to r.SendEvent(&Event{From: from, To: to, Type: typecode, Data: data})
+func (r *Router) SendTo(from, to Station, typecode int, data interface{}) int {
+	return r.SendEvent(&Event{From: from, To: to, Typecode: typecode, Data: data})
 }
 
 // SendEvent send event
 func SendEvent(e *Event) (nsent int) {
+	return router.SendEvent(e)
+}
+
+// SendEvent sends the event to its station, out through the adaptor if the
+// station is remote, or to the subscribers of its typecode if it has none.
+func (r *Router) SendEvent(e *Event) (nsent int) {
 
 	//if e.Typecode >= EndSize || (typeList[e.Typecode] != nil && reflect.TypeOf(e.Data) != typeList[e.Typecode]) {
 	//	fmt.Println("SendEvent Err:", e.Typecode, EndSize, reflect.TypeOf(e.Data), typeList[e.Typecode])
@@ -220,42 +322,102 @@ func SendEvent(e *Event) (nsent int) {
 	//return
 	//}
 
-	router.mutex.RLock()
-	defer router.mutex.RUnlock()
+	r = r.get()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 	if e.To != nil {
 		if e.To.IsRemote() {
-			sendToAdaptor(e)
+			r.sendToAdaptor(e)
 			return 1
 		}
-		//if len(e.To.Name()) != 0 {
-		feeds, ok := router.namedFeeds[e.To.Name()]
-		if ok {
-			feed, ok := feeds[e.Typecode]
-			if ok {
-				nsent = feed.Send(e)
-			}
+		if e.From != nil && !e.From.IsRemote() && e.From.Partition() != e.To.Partition() {
+			return r.sendToRelay(e)
 		}
-		return
-		//}
+		return r.sendToStation(e)
 	}
 
-	if feed, ok := router.unnamedFeeds[e.Typecode]; ok {
+	if feed, ok := r.unnamedFeeds[e.Typecode]; ok {
 		nsent = feed.Send(e)
 		return
 	}
 	return
 }
 
-func sendToAdaptor(e *Event) {
-	if router.adaptor != nil {
-		router.adaptor.SendOut(e)
+// sendToStation sends the event to the subscribers of the local station it
+// is addressed to.
+func (r *Router) sendToStation(e *Event) (nsent int) {
+	feeds, ok := r.namedFeeds[e.To.Name()]
+	if ok {
+		feed, ok := feeds[e.Typecode]
+		if ok {
+			nsent = feed.Send(e)
+		}
+	}
+	return
+}
+
+// sendToRelay hands the event, crossing partitions, to the relay station of
+// the partition of its receiver as the data of a RelayEv. The event is dropped
+// if the partition has no relay.
+func (r *Router) sendToRelay(e *Event) int {
+	relay, ok := r.relays[e.To.Partition()]
+	if !ok {
+		return 0
+	}
+	return r.sendToStation(&Event{From: e.From, To: relay, Typecode: RelayEv, Data: e})
+}
+
+// SetRelay designates the station receiving, as RelayEv events, the events
+// sent from other partitions to the stations of the partition. A nil station
+// removes the relay of the partition.
+func SetRelay(partition Partition, station Station) {
+	router.SetRelay(partition, station)
+}
+
+// SetRelay is SetRelay on the router.
+func (r *Router) SetRelay(partition Partition, station Station) {
+	r = r.get()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if station == nil {
+		delete(r.relays, partition)
+		return
+	}
+	r.relays[partition] = station
+}
+
+// Deliver sends an event to the station it is addressed to regardless of the
+// partition of its sender. Relays deliver the events handed to them with it.
+func Deliver(e *Event) int {
+	return router.Deliver(e)
+}
+
+// Deliver is Deliver on the router.
+func (r *Router) Deliver(e *Event) int {
+	r = r.get()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if e.To == nil || e.To.IsRemote() {
+		return 0
+	}
+	return r.sendToStation(e)
+}
+
+func (r *Router) sendToAdaptor(e *Event) {
+	if r.adaptor != nil {
+		r.adaptor.SendOut(e)
 	}
 }
 
 // SendEvents .
 func SendEvents(es []*Event) (nsent int) {
+	return router.SendEvents(es)
+}
+
+// SendEvents sends the events in order.
+func (r *Router) SendEvents(es []*Event) (nsent int) {
 	for _, e := range es {
-		nsent += SendEvent(e)
+		nsent += r.SendEvent(e)
 	}
 	return
 }
@@ -162,3 +162,60 @@ func TestUnsubscribe(t *testing.T) {
 
 	Clear()
 }
+
+func TestSendEventAcrossPartitions(t *testing.T) {
+	InitRounter()
+	var (
+		sender   = NewPartitionStation("PartitionSender", 1, nil)
+		local    = NewPartitionStation("PartitionLocal", 1, nil)
+		receiver = NewPartitionStation("PartitionReceiver", 2, nil)
+		relay    = NewPartitionStation("PartitionRelay", 2, nil)
+
+		localCh    = make(chan *Event, 1)
+		receiverCh = make(chan *Event, 1)
+		relayCh    = make(chan *Event, 1)
+	)
+	defer Clear()
+	Subscribe(local, localCh, RouterTestString, "")
+	Subscribe(receiver, receiverCh, RouterTestString, "")
+	Subscribe(relay, relayCh, RelayEv, &Event{})
+
+	// stations of the same partition exchange events directly
+	if n := SendTo(sender, local, RouterTestString, "direct"); n != 1 {
+		t.Fatalf("direct sends mismatch: have %d, want 1", n)
+	}
+	if e := <-localCh; e.Data.(string) != "direct" {
+		t.Fatalf("direct event mismatch: have %v", e.Data)
+	}
+
+	// without relay the events to another partition are dropped
+	if n := SendTo(sender, receiver, RouterTestString, "dropped"); n != 0 {
+		t.Fatalf("unrelayed sends mismatch: have %d, want 0", n)
+	}
+
+	// the relay of the partition of the receiver gets them and delivers them
+	SetRelay(2, relay)
+	if n := SendTo(sender, receiver, RouterTestString, "relayed"); n != 1 {
+		t.Fatalf("relayed sends mismatch: have %d, want 1", n)
+	}
+	e := <-relayCh
+	if e.Typecode != RelayEv || e.From != sender {
+		t.Fatalf("relay event mismatch: have %+v", e)
+	}
+	if n := Deliver(e.Data.(*Event)); n != 1 {
+		t.Fatalf("delivered sends mismatch: have %d, want 1", n)
+	}
+	if e := <-receiverCh; e.Data.(string) != "relayed" || e.From != sender {
+		t.Fatalf("relayed event mismatch: have %+v", e)
+	}
+	select {
+	case e := <-receiverCh:
+		t.Fatalf("unexpected event %+v", e)
+	default:
+	}
+
+	SetRelay(2, nil)
+	if n := SendTo(sender, receiver, RouterTestString, "dropped"); n != 0 {
+		t.Fatalf("sends after the relay removal mismatch: have %d, want 0", n)
+	}
+}
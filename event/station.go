@@ -16,16 +16,26 @@
 
 package event
 
+// Partition identifies a group of stations exchanging events directly. Events
+// between stations of different partitions go through the relay station of
+// the partition of the receiver, see SetRelay.
+type Partition uint32
+
+// DefaultPartition is the partition of the stations created without one.
+const DefaultPartition Partition = 0
+
 type Station interface {
 	Name() string
 	IsRemote() bool
 	IsBroadcast() bool
 	Data() interface{}
+	Partition() Partition
 }
 
 type BaseStation struct {
-	name    string
-	usrData interface{}
+	name      string
+	usrData   interface{}
+	partition Partition
 }
 
 type LocalStation struct {
@@ -48,6 +58,11 @@ func (bs *BaseStation) Data() interface{} {
 	return bs.usrData
 }
 
+// Partition returns the partition of the station.
+func (bs *BaseStation) Partition() Partition {
+	return bs.partition
+}
+
 func NewLocalStation(name string, data interface{}) Station {
 	return &LocalStation{
 		BaseStation{
@@ -57,6 +72,17 @@ func NewLocalStation(name string, data interface{}) Station {
 	}
 }
 
+// NewPartitionStation creates a local station in the partition.
+func NewPartitionStation(name string, partition Partition, data interface{}) Station {
+	return &LocalStation{
+		BaseStation{
+			name:      name,
+			usrData:   data,
+			partition: partition,
+		},
+	}
+}
+
 func (*LocalStation) IsRemote() bool {
 	return false
 }
@@ -0,0 +1,429 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ftclient provides a client for the fractal JSON-RPC API.
+package ftclient
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/internal/api"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+// Error codes of the errors returned by the node, see ErrorCode.
+const (
+	ErrCodeExecutionReverted = api.ErrCodeExecutionReverted
+	ErrCodeNonceTooLow       = api.ErrCodeNonceTooLow
+	ErrCodeInsufficientFee   = api.ErrCodeInsufficientFee
+	ErrCodeUnknownAccount    = api.ErrCodeUnknownAccount
+)
+
+// ErrNotFound is returned if the requested item does not exist.
+var ErrNotFound = errors.New("not found")
+
+const (
+	defaultRetries    = 3
+	defaultBackoff    = 100 * time.Millisecond
+	maxBackoff        = 5 * time.Second
+	receiptPollPeriod = time.Second
+)
+
+// Client is a client of the fractal JSON-RPC API.
+type Client struct {
+	c       *rpc.Client
+	retries int
+	backoff time.Duration
+}
+
+// Dial connects a client to the given URL.
+func Dial(rawurl string) (*Client, error) {
+	return DialContext(context.Background(), rawurl)
+}
+
+// DialContext connects a client to the given URL with the given context.
+func DialContext(ctx context.Context, rawurl string) (*Client, error) {
+	c, err := rpc.DialContext(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c), nil
+}
+
+// NewClient creates a client that uses the given RPC client.
+func NewClient(c *rpc.Client) *Client {
+	return &Client{c: c, retries: defaultRetries, backoff: defaultBackoff}
+}
+
+// Close closes the underlying RPC connection.
+func (fc *Client) Close() {
+	fc.c.Close()
+}
+
+// SetRetry sets how many times calls failing to reach the node are retried
+// and the delay before the first retry, which doubles on every retry. Errors
+// returned by the node are never retried.
+func (fc *Client) SetRetry(retries int, backoff time.Duration) {
+	fc.retries, fc.backoff = retries, backoff
+}
+
+// ErrorCode returns the code of an error returned by the node, or 0 if it
+// has none.
+func ErrorCode(err error) int {
+	if rpcErr, ok := err.(rpc.Error); ok {
+		return rpcErr.ErrorCode()
+	}
+	return 0
+}
+
+// ErrorData returns the data of an error returned by the node, or nil if it
+// has none.
+func ErrorData(err error) interface{} {
+	if dataErr, ok := err.(rpc.DataError); ok {
+		return dataErr.ErrorData()
+	}
+	return nil
+}
+
+// call calls the method, retrying if the node could not be reached.
+func (fc *Client) call(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	backoff := fc.backoff
+	for attempt := 0; ; attempt++ {
+		err := fc.c.CallContext(ctx, result, method, args...)
+		if err == nil || attempt >= fc.retries || ctx.Err() != nil {
+			return err
+		}
+		if _, ok := err.(rpc.Error); ok {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Block is a block as returned by the node, with its full transactions.
+type Block struct {
+	Number       *big.Int                `json:"number"`
+	Hash         common.Hash             `json:"hash"`
+	ParentHash   common.Hash             `json:"parentHash"`
+	Bloom        types.Bloom             `json:"logsBloom"`
+	Root         common.Hash             `json:"stateRoot"`
+	Coinbase     common.Name             `json:"miner"`
+	Difficulty   *big.Int                `json:"difficulty"`
+	Extra        hexutil.Bytes           `json:"extraData"`
+	Size         float64                 `json:"size"`
+	GasLimit     uint64                  `json:"gasLimit"`
+	GasUsed      uint64                  `json:"gasUsed"`
+	Time         *big.Int                `json:"timestamp"`
+	TxsRoot      common.Hash             `json:"transactionsRoot"`
+	ReceiptsRoot common.Hash             `json:"receiptsRoot"`
+	Transactions []*types.RPCTransaction `json:"transactions"`
+}
+
+func (fc *Client) getBlock(ctx context.Context, method string, args ...interface{}) (*Block, error) {
+	var block *Block
+	if err := fc.call(ctx, &block, method, args...); err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, ErrNotFound
+	}
+	return block, nil
+}
+
+// CurrentBlock returns the current head block.
+func (fc *Client) CurrentBlock(ctx context.Context) (*Block, error) {
+	return fc.getBlock(ctx, "ft_getCurrentBlock", true)
+}
+
+// BlockByHash returns the block with the given hash.
+func (fc *Client) BlockByHash(ctx context.Context, hash common.Hash) (*Block, error) {
+	return fc.getBlock(ctx, "ft_getBlockByHash", hash, true)
+}
+
+// BlockByNumber returns the canonical block with the given number.
+func (fc *Client) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*Block, error) {
+	return fc.getBlock(ctx, "ft_getBlockByNumber", number, true)
+}
+
+// BlocksByRange returns the canonical blocks from start to end inclusive. The
+// node returns a bounded number of blocks, the range continues after the last.
+func (fc *Client) BlocksByRange(ctx context.Context, start, end rpc.BlockNumber) ([]*Block, error) {
+	var blocks []*Block
+	err := fc.call(ctx, &blocks, "ft_getBlocksByRange", start, end, true)
+	return blocks, err
+}
+
+// OrphanBlocksAt returns the non-canonical blocks stored at the number.
+func (fc *Client) OrphanBlocksAt(ctx context.Context, number uint64) ([]*Block, error) {
+	var blocks []*Block
+	err := fc.call(ctx, &blocks, "ft_getOrphanBlocksAt", number, true)
+	return blocks, err
+}
+
+// BlockTrace returns the execution trace of the canonical block with the
+// given number.
+func (fc *Client) BlockTrace(ctx context.Context, number rpc.BlockNumber) (*types.BlockTrace, error) {
+	var trace *types.BlockTrace
+	if err := fc.call(ctx, &trace, "ft_getBlockTrace", number); err != nil {
+		return nil, err
+	}
+	if trace == nil {
+		return nil, ErrNotFound
+	}
+	return trace, nil
+}
+
+// SubscribeBlockTraces subscribes to the execution traces of the canonical
+// blocks from the given number on, or of the new blocks if the number is
+// latest. The traces of blocks dropped by a reorganisation are sent again with
+// Removed set. Subscriptions need a websocket or IPC connection.
+func (fc *Client) SubscribeBlockTraces(ctx context.Context, from rpc.BlockNumber, ch chan<- *types.BlockTrace) (*rpc.ClientSubscription, error) {
+	return fc.c.Subscribe(ctx, "ft", ch, "blockTraces", from)
+}
+
+// TransactionByHash returns the transaction with the given hash, from the
+// chain or the transaction pool.
+func (fc *Client) TransactionByHash(ctx context.Context, hash common.Hash) (*types.RPCTransaction, error) {
+	var tx *types.RPCTransaction
+	if err := fc.call(ctx, &tx, "ft_getTransactionByHash", hash); err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, ErrNotFound
+	}
+	return tx, nil
+}
+
+// TransactionReceipt returns the receipt of the transaction with the given
+// hash, or ErrNotFound if the transaction is not in the chain yet.
+func (fc *Client) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.RPCReceipt, error) {
+	var receipt *types.RPCReceipt
+	if err := fc.call(ctx, &receipt, "ft_getTransactionReceipt", hash); err != nil {
+		return nil, err
+	}
+	if receipt == nil {
+		return nil, ErrNotFound
+	}
+	return receipt, nil
+}
+
+// WaitForReceipt waits until the transaction with the given hash is in the
+// chain and returns its receipt.
+func (fc *Client) WaitForReceipt(ctx context.Context, hash common.Hash) (*types.RPCReceipt, error) {
+	ticker := time.NewTicker(receiptPollPeriod)
+	defer ticker.Stop()
+	for {
+		receipt, err := fc.TransactionReceipt(ctx, hash)
+		if err != ErrNotFound {
+			return receipt, err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// GasPrice returns the suggested gas price.
+func (fc *Client) GasPrice(ctx context.Context) (*big.Int, error) {
+	var price *big.Int
+	err := fc.call(ctx, &price, "ft_gasPrice")
+	return price, err
+}
+
+// SendTransaction sends a signed transaction to the node.
+func (fc *Client) SendTransaction(ctx context.Context, tx *types.Transaction) (common.Hash, error) {
+	data, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	var hash common.Hash
+	err = fc.call(ctx, &hash, "ft_sendRawTransaction", hexutil.Bytes(data))
+	return hash, err
+}
+
+// CallMsg contains the parameters of a message call.
+type CallMsg struct {
+	ActionType types.ActionType `json:"actionType"`
+	From       common.Name      `json:"from"`
+	To         common.Name      `json:"to"`
+	AssetID    uint64           `json:"assetId"`
+	Gas        uint64           `json:"gas"`
+	GasPrice   *big.Int         `json:"gasPrice"`
+	Value      *big.Int         `json:"value"`
+	Data       hexutil.Bytes    `json:"data"`
+}
+
+// CallContract executes a message call on the state of the given block and
+// returns its output, without changing the chain.
+func (fc *Client) CallContract(ctx context.Context, msg CallMsg, number rpc.BlockNumber) ([]byte, error) {
+	var result hexutil.Bytes
+	err := fc.call(ctx, &result, "ft_call", msg, number)
+	return result, err
+}
+
+// EstimateGas returns the gas needed to execute the message call on the
+// pending state.
+func (fc *Client) EstimateGas(ctx context.Context, msg CallMsg) (uint64, error) {
+	var gas hexutil.Uint64
+	err := fc.call(ctx, &gas, "ft_estimateGas", msg)
+	return uint64(gas), err
+}
+
+// AccountIsExist reports whether the account exists.
+func (fc *Client) AccountIsExist(ctx context.Context, name common.Name) (bool, error) {
+	var exist bool
+	err := fc.call(ctx, &exist, "account_accountIsExist", name)
+	return exist, err
+}
+
+// AccountByName returns the account with the given name.
+func (fc *Client) AccountByName(ctx context.Context, name common.Name) (*accountmanager.Account, error) {
+	var account *accountmanager.Account
+	if err := fc.call(ctx, &account, "account_getAccountByName", name); err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, ErrNotFound
+	}
+	return account, nil
+}
+
+// NonceAt returns the nonce of the account on the current state.
+func (fc *Client) NonceAt(ctx context.Context, name common.Name) (uint64, error) {
+	var nonce uint64
+	err := fc.call(ctx, &nonce, "account_getNonce", name)
+	return nonce, err
+}
+
+// BalanceAt returns the balance of the account in the asset.
+func (fc *Client) BalanceAt(ctx context.Context, name common.Name, assetID uint64) (*big.Int, error) {
+	var balance *big.Int
+	err := fc.call(ctx, &balance, "account_getAccountBalanceByID", name, assetID)
+	return balance, err
+}
+
+// CodeAt returns the contract code of the account.
+func (fc *Client) CodeAt(ctx context.Context, name common.Name) ([]byte, error) {
+	var code hexutil.Bytes
+	err := fc.call(ctx, &code, "account_getCode", name)
+	return code, err
+}
+
+// AssetByName returns the asset with the given name.
+func (fc *Client) AssetByName(ctx context.Context, name string) (*asset.AssetObject, error) {
+	var obj *asset.AssetObject
+	if err := fc.call(ctx, &obj, "account_getAssetInfoByName", name); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, ErrNotFound
+	}
+	return obj, nil
+}
+
+// AssetByID returns the asset with the given ID.
+func (fc *Client) AssetByID(ctx context.Context, assetID uint64) (*asset.AssetObject, error) {
+	var obj *asset.AssetObject
+	if err := fc.call(ctx, &obj, "account_getAssetInfoByID", assetID); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, ErrNotFound
+	}
+	return obj, nil
+}
+
+// NameRecords returns the records published under the account name.
+func (fc *Client) NameRecords(ctx context.Context, name common.Name) ([]*accountmanager.NameRecord, error) {
+	var records []*accountmanager.NameRecord
+	err := fc.call(ctx, &records, "account_getNameRecords", name)
+	return records, err
+}
+
+// NameRecord resolves the account name to its record of the type.
+func (fc *Client) NameRecord(ctx context.Context, name common.Name, recordType string) (*accountmanager.NameRecord, error) {
+	var record *accountmanager.NameRecord
+	if err := fc.call(ctx, &record, "account_getNameRecord", name, recordType); err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+// MinGasPrice returns the minimum gas price, in the fee token, of the
+// transactions carrying an action of the type.
+func (fc *Client) MinGasPrice(ctx context.Context, actionType types.ActionType) (*big.Int, error) {
+	price := new(big.Int)
+	err := fc.call(ctx, price, "account_getMinGasPrice", actionType)
+	return price, err
+}
+
+// RateLimit returns the limit of the actions an account may send in a window
+// of blocks, nil if the actions aren't limited.
+func (fc *Client) RateLimit(ctx context.Context) (*accountmanager.RateLimit, error) {
+	var limit *accountmanager.RateLimit
+	err := fc.call(ctx, &limit, "account_getRateLimit")
+	return limit, err
+}
+
+// ResourceUsage returns the staked amount and the gas quota of the account in
+// the window of the next block.
+func (fc *Client) ResourceUsage(ctx context.Context, name common.Name) (*accountmanager.ResourceUsage, error) {
+	usage := new(accountmanager.ResourceUsage)
+	err := fc.call(ctx, usage, "account_getResourceUsage", name)
+	return usage, err
+}
+
+// TxConflicts returns the latest incidents of different transactions spending
+// the same nonce of the account, of all the accounts if the account is empty.
+func (fc *Client) TxConflicts(ctx context.Context, account common.Name) ([]*types.TxConflict, error) {
+	var conflicts []*types.TxConflict
+	err := fc.call(ctx, &conflicts, "txpool_conflicts", account)
+	return conflicts, err
+}
+
+// AssetStats returns the supply, holders and transfer volume of the asset
+// indexed by the node.
+func (fc *Client) AssetStats(ctx context.Context, assetID uint64) (*api.AssetStats, error) {
+	var stats *api.AssetStats
+	if err := fc.call(ctx, &stats, "account_getAssetStats", assetID); err != nil {
+		return nil, err
+	}
+	if stats == nil {
+		return nil, ErrNotFound
+	}
+	return stats, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ftclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/types"
+)
+
+type FakeAccountAPI struct {
+	nonceCalls int32
+}
+
+func (s *FakeAccountAPI) GetNonce(name common.Name) uint64 {
+	atomic.AddInt32(&s.nonceCalls, 1)
+	return 5
+}
+
+type FakeChainAPI struct{}
+
+func (FakeChainAPI) GetTransactionReceipt(hash common.Hash) *types.RPCReceipt {
+	return nil
+}
+
+// BlockTraces notifies the traces of the blocks from fromBlock to
+// fromBlock+2 right away, before the subscription is activated.
+func (FakeChainAPI) BlockTraces(ctx context.Context, fromBlock rpc.BlockNumber) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	for n := uint64(fromBlock); n < uint64(fromBlock)+3; n++ {
+		notifier.Notify(sub.ID, &types.BlockTrace{Number: n, Coinbase: common.Name("producer")})
+	}
+	return sub, nil
+}
+
+// newTestClient starts a server answering the first failures requests with
+// an HTTP error and returns a client connected to it.
+func newTestClient(t *testing.T, failures int32) (*Client, *FakeAccountAPI, func()) {
+	accounts := new(FakeAccountAPI)
+	server := rpc.NewServer()
+	if err := server.RegisterName("account", accounts); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("ft", FakeChainAPI{}); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	client, err := Dial(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetRetry(2, time.Millisecond)
+	return client, accounts, func() {
+		client.Close()
+		httpServer.Close()
+		server.Stop()
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	client, _, stop := newTestClient(t, 2)
+	defer stop()
+	if nonce, err := client.NonceAt(context.Background(), "testaccount"); err != nil || nonce != 5 {
+		t.Fatalf("nonce mismatch: have %d, %v, want 5", nonce, err)
+	}
+
+	client, _, stop = newTestClient(t, 3)
+	defer stop()
+	if _, err := client.NonceAt(context.Background(), "testaccount"); err == nil {
+		t.Fatal("expected error after the retries")
+	}
+}
+
+func TestNonceManager(t *testing.T) {
+	client, accounts, stop := newTestClient(t, 0)
+	defer stop()
+
+	ctx := context.Background()
+	nm := NewNonceManager(client)
+	for want := uint64(5); want < 8; want++ {
+		if nonce, err := nm.Next(ctx, "testaccount"); err != nil || nonce != want {
+			t.Fatalf("nonce mismatch: have %d, %v, want %d", nonce, err, want)
+		}
+	}
+	nm.Reset("testaccount")
+	if nonce, err := nm.Next(ctx, "testaccount"); err != nil || nonce != 5 {
+		t.Fatalf("nonce mismatch after reset: have %d, %v, want 5", nonce, err)
+	}
+	if calls := atomic.LoadInt32(&accounts.nonceCalls); calls != 2 {
+		t.Fatalf("nonce fetched %d times, want 2", calls)
+	}
+}
+
+func TestWaitForReceipt(t *testing.T) {
+	client, _, stop := newTestClient(t, 0)
+	defer stop()
+
+	if _, err := client.TransactionReceipt(context.Background(), common.Hash{}); err != ErrNotFound {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.WaitForReceipt(ctx, common.Hash{}); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestSubscribeBlockTraces(t *testing.T) {
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("ft", FakeChainAPI{}); err != nil {
+		t.Fatal(err)
+	}
+	wsServer := httptest.NewServer(server.WebsocketHandler([]string{"*"}))
+	defer wsServer.Close()
+	client, err := Dial("ws" + strings.TrimPrefix(wsServer.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ch := make(chan *types.BlockTrace)
+	sub, err := client.SubscribeBlockTraces(context.Background(), 5, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	for want := uint64(5); want < 8; want++ {
+		select {
+		case trace := <-ch:
+			if trace.Number != want {
+				t.Fatalf("trace number mismatch: have %d, want %d", trace.Number, want)
+			}
+		case err := <-sub.Err():
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatalf("trace of block %d not received", want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ftclient
+
+import (
+	"context"
+	"sync"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+// NonceManager hands out the nonces of the actions sent by accounts, so that
+// several transactions can be sent without waiting for each to be included.
+type NonceManager struct {
+	client *Client
+	mu     sync.Mutex
+	nonces map[common.Name]uint64
+}
+
+// NewNonceManager creates a nonce manager fetching the nonces of the
+// accounts with the client.
+func NewNonceManager(client *Client) *NonceManager {
+	return &NonceManager{client: client, nonces: make(map[common.Name]uint64)}
+}
+
+// Next returns the next nonce of the account. The nonce of the account is
+// fetched from the node on first use and counted locally after that.
+func (nm *NonceManager) Next(ctx context.Context, name common.Name) (uint64, error) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	nonce, ok := nm.nonces[name]
+	if !ok {
+		var err error
+		if nonce, err = nm.client.NonceAt(ctx, name); err != nil {
+			return 0, err
+		}
+	}
+	nm.nonces[name] = nonce + 1
+	return nonce, nil
+}
+
+// Reset forgets the nonce of the account, so that it is fetched from the
+// node again. It should be called when a transaction of the account was
+// rejected, e.g. with ErrCodeNonceTooLow.
+func (nm *NonceManager) Reset(name common.Name) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	delete(nm.nonces, name)
+}
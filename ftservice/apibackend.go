@@ -18,13 +18,20 @@ package ftservice
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"math/big"
+	"net"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/blockchain"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/event"
 	"github.com/fractalplatform/fractal/ftservice/gasprice"
+	"github.com/fractalplatform/fractal/p2p"
 	"github.com/fractalplatform/fractal/p2p/enode"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/processor"
@@ -87,6 +94,12 @@ func (b *APIBackend) GetPoolTransaction(hash common.Hash) *types.Transaction {
 	return b.ftservice.txPool.Get(hash)
 }
 
+// GetPoolNonce returns the next nonce of the account, including the
+// transactions pending in the pool.
+func (b *APIBackend) GetPoolNonce(name common.Name) (uint64, error) {
+	return b.ftservice.txPool.State().GetNonce(name)
+}
+
 func (b *APIBackend) Stats() (pending int, queued int) {
 	return b.ftservice.txPool.Stats()
 }
@@ -95,18 +108,54 @@ func (b *APIBackend) TxPoolContent() (map[common.Name][]*types.Transaction, map[
 	return b.ftservice.TxPool().Content()
 }
 
+func (b *APIBackend) TxConflicts(account common.Name) []*types.TxConflict {
+	return b.ftservice.conflicts.conflicts(account)
+}
+
 func (b *APIBackend) ChainDb() fdb.Database {
 	return b.ftservice.chainDb
 }
 
+// Router returns the router of the node the service runs in.
+func (b *APIBackend) Router() *event.Router {
+	return b.ftservice.blockchain.Router()
+}
+
 func (b *APIBackend) CurrentBlock() *types.Block {
 	return b.ftservice.blockchain.CurrentBlock()
 }
 
+// ExportSnapshot writes a snapshot of the chain at the given block number to w.
+func (b *APIBackend) ExportSnapshot(number, blocks uint64, w io.Writer) (*blockchain.SnapshotMeta, error) {
+	return b.ftservice.blockchain.ExportSnapshot(number, blocks, w)
+}
+
+// SyncMode returns the sync mode of the downloader.
+func (b *APIBackend) SyncMode() blockchain.SyncMode {
+	return b.ftservice.blockchain.Downloader().Mode()
+}
+
+// SetSyncMode changes the sync mode of the downloader.
+func (b *APIBackend) SetSyncMode(mode blockchain.SyncMode) error {
+	return b.ftservice.blockchain.Downloader().SetMode(mode)
+}
+
 func (b *APIBackend) GetBlock(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	if b.ftservice.blockchain.HeaderOnly() {
+		return b.fetchBlock(b.ftservice.blockchain.GetHeaderByHash(hash))
+	}
 	return b.ftservice.blockchain.GetBlockByHash(hash), nil
 }
 
+// fetchBlock returns the block of the header of a header-only chain, whose
+// body is fetched from the remotes.
+func (b *APIBackend) fetchBlock(header *types.Header) (*types.Block, error) {
+	if header == nil {
+		return nil, nil
+	}
+	return b.ftservice.blockchain.FetchBlock(header.Hash(), header.Number.Uint64())
+}
+
 func (b *APIBackend) GetReceipts(ctx context.Context, hash common.Hash) ([]*types.Receipt, error) {
 	if number := rawdb.ReadHeaderNumber(b.ftservice.chainDb, hash); number != nil {
 		return rawdb.ReadReceipts(b.ftservice.chainDb, hash, *number), nil
@@ -141,6 +190,10 @@ func (b *APIBackend) BlockByNumber(ctx context.Context, blockNr rpc.BlockNumber)
 		return block, nil
 	}
 
+	if b.ftservice.blockchain.HeaderOnly() {
+		header, _ := b.HeaderByNumber(ctx, blockNr)
+		return b.fetchBlock(header)
+	}
 	// Otherwise resolve and return the block
 	if blockNr == rpc.LatestBlockNumber {
 		return b.ftservice.blockchain.CurrentBlock(), nil
@@ -160,10 +213,20 @@ func (b *APIBackend) StateAndHeaderByNumber(ctx context.Context, blockNr rpc.Blo
 	if header == nil || err != nil {
 		return nil, nil, err
 	}
-	stateDb, err := b.ftservice.blockchain.StateAt(b.ftservice.blockchain.CurrentBlock().Hash())
+	stateDb, err := b.ftservice.blockchain.StateAtBlock(header.Hash())
 	return stateDb, header, err
 }
 
+// StateAt returns the state after the block with the given hash.
+func (b *APIBackend) StateAt(hash common.Hash) (*state.StateDB, error) {
+	return b.ftservice.blockchain.StateAt(hash)
+}
+
+// Processor returns the processor used to apply the blocks of the chain.
+func (b *APIBackend) Processor() processor.Processor {
+	return b.ftservice.blockchain.Processor()
+}
+
 func (b *APIBackend) GetEVM(ctx context.Context, account *accountmanager.AccountManager, state *state.StateDB, from common.Name, assetID uint64, gasPrice *big.Int, header *types.Header, vmCfg vm.Config) (*vm.EVM, func() error, error) {
 	account.AddAccountBalanceByID(from, assetID, math.MaxBig256)
 	vmError := func() error { return nil }
@@ -236,11 +299,33 @@ func (b *APIBackend) RemoveTrustedPeer(url string) error {
 	return err
 }
 
+// TrustedPeers returns the trusted peer set.
+func (b *APIBackend) TrustedPeers() []string {
+	ns := b.ftservice.p2pServer.TrustedPeers()
+	nodes := make([]string, len(ns))
+	for i, node := range ns {
+		nodes[i] = node.String()
+	}
+	return nodes
+}
+
 // PeerCount returns the number of connected peers.
 func (b *APIBackend) PeerCount() int {
 	return b.ftservice.p2pServer.PeerCount()
 }
 
+// PeerSlots returns the inbound and outbound connection slot usage.
+func (b *APIBackend) PeerSlots() map[string]int {
+	slots := b.ftservice.p2pServer.PeerSlots()
+	return map[string]int{
+		"maxPeers":    slots.MaxPeers,
+		"inbound":     slots.Inbound,
+		"maxInbound":  slots.MaxInbound,
+		"outbound":    slots.Outbound,
+		"maxOutbound": slots.MaxOutbound,
+	}
+}
+
 // Peers returns all connected peers.
 func (b *APIBackend) Peers() []string {
 	ps := b.ftservice.p2pServer.Peers()
@@ -251,6 +336,62 @@ func (b *APIBackend) Peers() []string {
 	return peers
 }
 
+// BanPeer bans a remote node and drops its connection.
+func (b *APIBackend) BanPeer(url string, d time.Duration) error {
+	node, err := enode.ParseV4(url)
+	if err != nil {
+		return fmt.Errorf("invalid enode: %v", err)
+	}
+	return b.ftservice.p2pServer.BanPeer(node, d, "banned by admin")
+}
+
+// BanIP bans all connections from an IP address.
+func (b *APIBackend) BanIP(ip string, d time.Duration) error {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return fmt.Errorf("invalid ip: %v", ip)
+	}
+	return b.ftservice.p2pServer.BanIP(addr, d, "banned by admin")
+}
+
+// UnbanPeer lifts the ban of a remote node.
+func (b *APIBackend) UnbanPeer(url string) (bool, error) {
+	node, err := enode.ParseV4(url)
+	if err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	return b.ftservice.p2pServer.UnbanPeer(node.ID()), nil
+}
+
+// UnbanIP lifts the ban of an IP address.
+func (b *APIBackend) UnbanIP(ip string) (bool, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false, fmt.Errorf("invalid ip: %v", ip)
+	}
+	return b.ftservice.p2pServer.UnbanIP(addr), nil
+}
+
+// Bans returns the active entries of the peer ban list.
+func (b *APIBackend) Bans() []*p2p.BanEntry {
+	return b.ftservice.p2pServer.Bans()
+}
+
+// DiscoveredNodes returns the nodes known to the discovery table.
+func (b *APIBackend) DiscoveredNodes() []string {
+	ns := b.ftservice.p2pServer.DiscoveredNodes()
+	nodes := make([]string, len(ns))
+	for i, node := range ns {
+		nodes[i] = node.String()
+	}
+	return nodes
+}
+
+// NodeInfo returns the local node's identity record.
+func (b *APIBackend) NodeInfo() *p2p.NodeInfo {
+	return b.ftservice.p2pServer.NodeInfo()
+}
+
 // SelfNode returns the local node's endpoint information.
 func (b *APIBackend) SelfNode() string {
 	return b.ftservice.p2pServer.Self().String()
@@ -34,6 +34,56 @@ type Config struct {
 	SkipBcVersionCheck bool `mapstructure:"ftservice-skipvcversioncheck"`
 	DatabaseHandles    int  `mapstructure:"ftservice-databasehandles"`
 	DatabaseCache      int  `mapstructure:"ftservice-databasecache"`
+	StateCache         int  `mapstructure:"ftservice-statecache"`
+	StateCommitCache   int  `mapstructure:"ftservice-statecommitcache"`
+
+	// Seconds a received block may be ahead of local time to be kept until due
+	FutureBlockDrift int `mapstructure:"ftservice-futureblockdrift"`
+
+	// Milliseconds the local clock may drift from the block timestamps of the
+	// network before warning, 0 to never warn
+	MaxClockDrift int `mapstructure:"ftservice-maxclockdrift"`
+
+	// Whether to index the actions by account, asset and type for the search RPC
+	TxSearchIndex bool `mapstructure:"ftservice-txsearchindex"`
+
+	// Synchronisation mode, "full" or "fast"
+	SyncMode string `mapstructure:"ftservice-syncmode"`
+
+	// Fork choice rule, "td", "irreversible" or "producer"
+	ForkRule string `mapstructure:"ftservice-forkrule"`
+
+	// Blocks between the checkpoints co-signed by the producers, 0 to sign none
+	CheckpointInterval uint64 `mapstructure:"ftservice-checkpointinterval"`
+
+	// Blocks replayed at most for the state of a pruned block, and blocks of
+	// state changes reverted at most for the state of an old block
+	MaxReplayBlocks uint64 `mapstructure:"ftservice-maxreplayblocks"`
+	MaxRevertBlocks uint64 `mapstructure:"ftservice-maxrevertblocks"`
+
+	// Health probe options
+	HealthAddr       string `mapstructure:"ftservice-healthaddr"`       // listening address of /health and /ready, disabled if empty
+	ReadyMaxBlockAge int    `mapstructure:"ftservice-readymaxblockage"` // seconds since the head block beyond which the node isn't ready, 0 to ignore
+	ReadyMinPeers    int    `mapstructure:"ftservice-readyminpeers"`    // peers needed to be ready
+
+	// Listening address of the gRPC API, disabled if empty
+	GRPCAddr string `mapstructure:"ftservice-grpcaddr"`
+
+	// Message queue bridge options
+	MQBridgeURL        string   `mapstructure:"ftservice-mqbridgeurl"`        // URL of the message queue chain events are published to, disabled if empty
+	MQBridgeTopic      string   `mapstructure:"ftservice-mqbridgetopic"`      // prefix of the subjects published to
+	MQBridgeEvents     []string `mapstructure:"ftservice-mqbridgeevents"`     // kinds of the events published, all if empty
+	MQBridgeReplayFrom uint64   `mapstructure:"ftservice-mqbridgereplayfrom"` // first block to publish, 0 to resume after the last published block
+
+	// Account activity webhook options
+	WebhookURLs          []string `mapstructure:"ftservice-webhookurls"`          // endpoints notified of the activity of the watched accounts, disabled if empty
+	WebhookSecret        string   `mapstructure:"ftservice-webhooksecret"`        // key of the HMAC signatures of the notifications, unsigned if empty
+	WebhookAccounts      []string `mapstructure:"ftservice-webhookaccounts"`      // watched accounts
+	WebhookRetries       int      `mapstructure:"ftservice-webhookretries"`       // retries of a failed notification before dropping it
+	WebhookConfirmations uint64   `mapstructure:"ftservice-webhookconfirmations"` // blocks on top of a block before its activity is notified
+
+	// Downloader options
+	Downloader *blockchain.DownloaderConfig
 
 	// Transaction pool options
 	TxPool *txpool.Config
@@ -54,4 +104,8 @@ type MinerConfig struct {
 	Name       string `mapstructure:"miner-name"`
 	PrivateKey string `mapstructure:"miner-private"`
 	ExtraData  string `mapstructure:"miner-extra"`
+	GasLimit   uint64 `mapstructure:"miner-gaslimit"` // gas limit voted for, zero to follow the usage
+
+	// Whether to skip the slots while the clock drifts beyond ftservice-maxclockdrift
+	RefuseDrift bool `mapstructure:"miner-refusedrift"`
 }
@@ -20,10 +20,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math/big"
+	"net"
+	"net/http"
 	"sync"
+	"time"
 
-	"github.com/ethereum/go-ethereum/log"
-	am "github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/blockchain"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/consensus"
@@ -31,7 +32,11 @@ import (
 	"github.com/fractalplatform/fractal/consensus/miner"
 	"github.com/fractalplatform/fractal/crypto"
 	"github.com/fractalplatform/fractal/ftservice/gasprice"
+	"github.com/fractalplatform/fractal/ftservice/grpcapi"
+	"github.com/fractalplatform/fractal/ftservice/mqbridge"
+	"github.com/fractalplatform/fractal/ftservice/webhook"
 	"github.com/fractalplatform/fractal/internal/api"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/node"
 	"github.com/fractalplatform/fractal/p2p"
 	adaptor "github.com/fractalplatform/fractal/p2p/protoadaptor"
@@ -40,7 +45,9 @@ import (
 	"github.com/fractalplatform/fractal/processor/vm"
 	"github.com/fractalplatform/fractal/rawdb"
 	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/state"
 	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
 	"github.com/fractalplatform/fractal/utils/fdb"
 	"github.com/fractalplatform/fractal/wallet"
 )
@@ -60,6 +67,11 @@ type FtService struct {
 	gasPrice     *big.Int
 	lock         sync.RWMutex // Protects the variadic fields (e.g. gas price)
 	APIBackend   *APIBackend
+	healthServer *http.Server
+	mqBridge     *mqbridge.Bridge
+	webhooks     *webhook.Dispatcher
+	grpcServer   *grpcapi.Server
+	conflicts    *conflictMonitor
 }
 
 // New creates a new ftservice object (including the initialisation of the common ftservice object)
@@ -81,6 +93,7 @@ func New(ctx *node.ServiceContext, config *Config) (*FtService, error) {
 		wallet:       ctx.Wallet,
 		p2pServer:    ctx.P2P,
 		shutdownChan: make(chan bool),
+		conflicts:    newConflictMonitor(ctx.Router, maxTxConflicts),
 	}
 
 	if !config.SkipBcVersionCheck {
@@ -92,39 +105,81 @@ func New(ctx *node.ServiceContext, config *Config) (*FtService, error) {
 	}
 
 	//blockchain
-	ftservice.blockchain, err = blockchain.NewBlockChain(chainDb, vm.Config{}, ftservice.chainConfig, txpool.SenderCacher)
+	var dlopts []blockchain.DownloaderOption
+	if config.Downloader != nil {
+		dlopts = append(dlopts, blockchain.WithConfig(*config.Downloader))
+	}
+	ftservice.blockchain, err = blockchain.NewBlockChainWithRouter(ctx.Router, chainDb, &blockchain.CacheConfig{StateCache: config.StateCache, StateCommitCache: config.StateCommitCache, FutureBlockDrift: time.Duration(config.FutureBlockDrift) * time.Second, MaxClockDrift: time.Duration(config.MaxClockDrift) * time.Millisecond, TxSearchIndex: config.TxSearchIndex, CheckpointInterval: config.CheckpointInterval, MaxReplayBlocks: config.MaxReplayBlocks, MaxRevertBlocks: config.MaxRevertBlocks, HeaderOnly: config.SyncMode == blockchain.LightSync.String()}, vm.Config{}, ftservice.chainConfig, txpool.SenderCacher, dlopts...)
 	if err != nil {
 		return nil, err
 	}
 
-	statedb, err := ftservice.blockchain.State()
-	if err != nil {
-		panic(fmt.Sprintf("state db err %v", err))
+	if dl := ftservice.blockchain.Downloader(); dl != nil && config.SyncMode != "" {
+		var mode blockchain.SyncMode
+		if err := mode.UnmarshalText([]byte(config.SyncMode)); err != nil {
+			return nil, err
+		}
+		if err := dl.SetMode(mode); err != nil {
+			return nil, err
+		}
 	}
-	accountManager, err := am.NewAccountManager(statedb)
-	if err != nil {
-		panic(fmt.Sprintf("genesis accountManager new err: %v", err))
+
+	if config.ForkRule != "" {
+		rule, err := blockchain.ParseForkRule(config.ForkRule)
+		if err != nil {
+			return nil, err
+		}
+		ftservice.blockchain.ForkChoice().SetRule(rule)
 	}
-	if ok, err := accountManager.AccountIsExist(chainCfg.SysName); !ok {
-		panic(fmt.Sprintf("system account is not exist %v", err))
+
+	// The bridges are built before the subsystems start, so that a bad
+	// configuration leaves nothing running.
+	if config.MQBridgeURL != "" {
+		ftservice.mqBridge, err = mqbridge.Open(&mqbridge.Config{
+			URL:        config.MQBridgeURL,
+			Topic:      config.MQBridgeTopic,
+			Events:     config.MQBridgeEvents,
+			ReplayFrom: config.MQBridgeReplayFrom,
+		}, ftservice.blockchain, chainDb)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	assetInfo, err := accountManager.GetAssetInfoByName(chainCfg.SysToken)
-	if err != nil {
-		panic(fmt.Sprintf("genesis system asset err %v", err))
+	if len(config.WebhookURLs) > 0 {
+		ftservice.webhooks, err = webhook.New(&webhook.Config{
+			URLs:          config.WebhookURLs,
+			Secret:        config.WebhookSecret,
+			Accounts:      config.WebhookAccounts,
+			Retries:       config.WebhookRetries,
+			Confirmations: config.WebhookConfirmations,
+		}, ftservice.blockchain, chainDb)
+		if err != nil {
+			return nil, err
+		}
 	}
-	chainCfg.SysTokenID = assetInfo.AssetId
-	chainCfg.SysTokenDecimals = assetInfo.Decimals
 
 	// txpool
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
 	}
 
+	config.TxPool.GasAssetID = chainCfg.FeeTokenID
 	ftservice.txPool = txpool.New(*config.TxPool, ftservice.chainConfig, ftservice.blockchain)
 
 	engine := dpos.New(dposCfg, ftservice.blockchain)
 	ftservice.engine = engine
+	ftservice.blockchain.ForkChoice().SetIrreversible(engine.IrreversibleNumber)
+	ftservice.blockchain.Checkpoints().SetScheduler(func(header *types.Header) ([]*types.CheckpointProducer, error) {
+		state, err := ftservice.blockchain.StateAt(header.Hash())
+		if err != nil {
+			return nil, err
+		}
+		return engine.CheckpointSchedule(state)
+	})
+	ftservice.blockchain.Checkpoints().SetSealVerifier(func(header *types.Header, schedule []*types.CheckpointProducer) error {
+		return engine.VerifyScheduledSeal(ftservice.blockchain, header, schedule)
+	})
 
 	type bc struct {
 		*blockchain.BlockChain
@@ -145,6 +200,7 @@ func New(ctx *node.ServiceContext, config *Config) (*FtService, error) {
 
 	ftservice.blockchain.SetValidator(validator)
 	ftservice.blockchain.SetProcessor(txProcessor)
+	ftservice.txPool.SetExecutor(newTxExecutor(txProcessor))
 
 	bcc.Processor = txProcessor
 	ftservice.miner = miner.NewMiner(bcc)
@@ -160,17 +216,36 @@ func New(ctx *node.ServiceContext, config *Config) (*FtService, error) {
 		log.Error("miner private error", err)
 	}
 	ftservice.miner.SetExtra([]byte(config.Miner.ExtraData))
-	if config.Miner.Start {
-		ftservice.miner.Start()
+	ftservice.miner.SetGasLimit(config.Miner.GasLimit)
+	ftservice.miner.SetClockDrift(ftservice.blockchain.ClockDrift(), config.Miner.RefuseDrift)
+	ftservice.miner.SetCheckpoints(ftservice.blockchain.Checkpoints())
+	if ftservice.blockchain.HeaderOnly() {
+		ftservice.miner.Disable(miner.ErrHeaderOnlyChain)
 	}
-
 	ftservice.APIBackend = &APIBackend{ftservice: ftservice}
 
 	ftservice.SetGasPrice(ftservice.TxPool().GasPrice())
 
+	if config.Miner.Start {
+		if err := ftservice.miner.Start(); err != nil {
+			return nil, err
+		}
+	}
+
 	return ftservice, nil
 }
 
+// newTxExecutor returns the executor dry-running the transactions of the pool
+// on the head state as the producer of the next block would apply them.
+func newTxExecutor(p processor.Processor) txpool.TxExecutor {
+	return func(statedb *state.StateDB, header *types.Header, tx *types.Transaction) error {
+		var usedGas uint64
+		gp := new(common.GasPool).AddGas(header.GasLimit)
+		_, _, err := p.ApplyTransaction(nil, gp, statedb, header, tx, &usedGas, vm.Config{})
+		return err
+	}
+}
+
 // APIs return the collection of RPC services the ftservice package offers.
 func (fs *FtService) APIs() []rpc.API {
 	apis := api.GetAPIs(fs.APIBackend)
@@ -180,17 +255,96 @@ func (fs *FtService) APIs() []rpc.API {
 // Start implements node.Service, starting all internal goroutines.
 func (fs *FtService) Start() error {
 	log.Info("start fractal service...")
+	fs.conflicts.start()
+	if fs.mqBridge != nil {
+		fs.mqBridge.Start()
+	}
+	if fs.webhooks != nil {
+		fs.webhooks.Start()
+	}
+	if err := fs.startGRPC(); err != nil {
+		return err
+	}
+	return fs.startHealth()
+}
+
+// startGRPC opens the gRPC API if it is configured.
+func (fs *FtService) startGRPC() error {
+	if fs.config.GRPCAddr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", fs.config.GRPCAddr)
+	if err != nil {
+		return err
+	}
+	fs.grpcServer = grpcapi.NewServer(fs.APIBackend)
+	go fs.grpcServer.Serve(listener)
+	log.Info("gRPC endpoint opened", "addr", listener.Addr())
 	return nil
 }
 
-// Stop implements node.Service, terminating all internal goroutine
+// Stop implements node.Service, terminating all internal goroutine. The
+// subsystems stop in dependency order, those feeding the blockchain before it
+// and the database last once flushed, so the last block written is kept.
 func (fs *FtService) Stop() error {
-	fs.blockchain.Stop()
-	fs.txPool.Stop()
-	fs.chainDb.Close()
+	lc := new(lifecycle)
+	if fs.healthServer != nil {
+		lc.register("health", defaultStopTimeout, fs.healthServer.Close)
+	}
+	if fs.grpcServer != nil {
+		lc.register("grpc", defaultStopTimeout, func() error {
+			fs.grpcServer.Stop()
+			return nil
+		})
+	}
+	lc.register("miner", defaultStopTimeout, func() error {
+		if fs.miner.Mining() {
+			fs.miner.Stop()
+		}
+		return nil
+	})
+	if dl := fs.blockchain.Downloader(); dl != nil {
+		lc.register("downloader", defaultStopTimeout, func() error {
+			dl.Stop()
+			return nil
+		})
+	}
+	lc.register("txpool", defaultStopTimeout, func() error {
+		fs.txPool.Stop()
+		return nil
+	})
+	lc.register("conflicts", defaultStopTimeout, func() error {
+		fs.conflicts.stop()
+		return nil
+	})
+	if fs.mqBridge != nil {
+		lc.register("mqbridge", defaultStopTimeout, func() error {
+			fs.mqBridge.Stop()
+			return nil
+		})
+	}
+	if fs.webhooks != nil {
+		lc.register("webhooks", defaultStopTimeout, func() error {
+			fs.webhooks.Stop()
+			return nil
+		})
+	}
+	lc.registerWait("blockchain", chainStopTimeout, func() error {
+		fs.blockchain.Stop()
+		return nil
+	})
+	lc.register("database", defaultStopTimeout, func() error {
+		var err error
+		if flusher, ok := fs.chainDb.(fdb.Flusher); ok {
+			err = flusher.Flush()
+		}
+		fs.chainDb.Close()
+		return err
+	})
+	err := lc.shutdown()
 	close(fs.shutdownChan)
 	log.Info("ftservice stopped")
-	return nil
+	return err
 }
 
 func (fs *FtService) GasPrice() *big.Int {
@@ -14,4 +14,96 @@
 // You should have received a copy of the GNU General Public License
 // along with this program. If not, see <http://www.gnu.org/licenses/>.
 
-package ftservice_test
+package ftservice
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/blockchain"
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/consensus/dpos"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func TestTxExecutor(t *testing.T) {
+	event.InitRounter()
+	var (
+		db       = fdb.NewMemDatabase()
+		gspec    = blockchain.DefaultGenesis()
+		config   = *gspec.Config
+		sysKey   = "289c2857d4598e37fb9647507e47a309d6133539bf21a8b9cb6df88fd5232032"
+		priv, _  = crypto.HexToECDSA(sysKey)
+		signer   = types.NewSigner(config.ChainID)
+		sysName  = config.SysName
+		transfer = func(nonce uint64, value *big.Int) *types.Transaction {
+			action := types.NewAction(types.Transfer, sysName, sysName, nonce, 1, 210000, value, nil)
+			tx := types.NewTransaction(1, big.NewInt(2), action)
+			if err := types.SignAction(action, tx, signer, priv); err != nil {
+				t.Fatal(err)
+			}
+			return tx
+		}
+	)
+	config.SysTokenID, config.FeeTokenID = 1, 1
+	gspec.Config = &config
+	genesis, err := gspec.Commit(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.NewBlockChain(db, nil, vm.Config{}, &config, txpool.SenderCacher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Stop()
+	engine := dpos.New(dpos.DefaultConfig, chain)
+	executor := newTxExecutor(processor.NewStateProcessor(&struct {
+		*blockchain.BlockChain
+		consensus.IEngine
+	}{chain, engine}, engine))
+
+	header := &types.Header{
+		ParentHash: genesis.Hash(),
+		Coinbase:   sysName,
+		Number:     big.NewInt(1),
+		GasLimit:   genesis.GasLimit(),
+		Time:       new(big.Int).Add(genesis.Time(), big.NewInt(1)),
+		Difficulty: genesis.Difficulty(),
+	}
+	statedb, err := chain.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	am, err := accountmanager.NewAccountManager(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := am.GetAccountBalanceByID(sysName, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the executor applies the transaction as the next block would
+	if err := executor(statedb.Copy(), header, transfer(0, big.NewInt(1))); err != nil {
+		t.Fatalf("executable transaction failed: %v", err)
+	}
+	for _, tx := range []*types.Transaction{
+		transfer(0, new(big.Int).Add(balance, big.NewInt(1))),
+		transfer(1, big.NewInt(1)),
+	} {
+		if err := executor(statedb.Copy(), header, tx); err == nil {
+			t.Fatalf("transaction %x executed", tx.Hash())
+		}
+	}
+	// the dry-runs leave the head state untouched
+	if nonce, err := am.GetNonce(sysName); err != nil || nonce != 0 {
+		t.Fatalf("head state modified: nonce %d, err %v", nonce, err)
+	}
+}
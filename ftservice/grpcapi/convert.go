@@ -0,0 +1,154 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package grpcapi
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// bigString returns the decimal string of an amount, empty if it is nil.
+func bigString(x *big.Int) string {
+	if x == nil {
+		return ""
+	}
+	return x.String()
+}
+
+func newHeader(block *types.Block) *Header {
+	head := block.Header()
+	return &Header{
+		Number:           head.Number.Uint64(),
+		Hash:             block.Hash().Bytes(),
+		ParentHash:       head.ParentHash.Bytes(),
+		Coinbase:         head.Coinbase.String(),
+		StateRoot:        head.Root.Bytes(),
+		TransactionsRoot: head.TxsRoot.Bytes(),
+		ReceiptsRoot:     head.ReceiptsRoot.Bytes(),
+		LogsBloom:        head.Bloom.Bytes(),
+		Difficulty:       bigString(head.Difficulty),
+		GasLimit:         head.GasLimit,
+		GasUsed:          head.GasUsed,
+		Timestamp:        head.Time.Uint64(),
+		ExtraData:        head.Extra,
+	}
+}
+
+func newBlock(block *types.Block, fullTx bool) *Block {
+	b := &Block{Header: newHeader(block), Size: uint64(block.Size())}
+	for i, tx := range block.Transactions() {
+		if fullTx {
+			b.Transactions = append(b.Transactions, newTransaction(tx.NewRPCTransaction(block.Hash(), block.NumberU64(), uint64(i))))
+		} else {
+			b.TransactionHashes = append(b.TransactionHashes, tx.Hash().Bytes())
+		}
+	}
+	return b
+}
+
+func newTransaction(tx *types.RPCTransaction) *Transaction {
+	t := &Transaction{
+		Hash:        tx.Hash.Bytes(),
+		BlockNumber: tx.BlockNumber,
+		Index:       tx.TransactionIndex,
+		GasAssetId:  tx.GasAssetID,
+		GasPrice:    bigString(tx.GasPrice),
+		GasCost:     bigString(tx.GasCost),
+	}
+	if tx.BlockHash != (common.Hash{}) {
+		t.BlockHash = tx.BlockHash.Bytes()
+	}
+	for _, a := range tx.RPCActions {
+		t.Actions = append(t.Actions, &Action{
+			Type:     a.Type,
+			Nonce:    a.Nonce,
+			From:     a.From.String(),
+			To:       a.To.String(),
+			AssetId:  a.AssetID,
+			GasLimit: a.GasLimit,
+			Value:    bigString(a.Amount),
+			Payload:  a.Payload,
+			Hash:     a.Hash.Bytes(),
+			Index:    a.ActionIdex,
+		})
+	}
+	return t
+}
+
+func newReceipt(r *types.RPCReceipt) *Receipt {
+	receipt := &Receipt{
+		TransactionHash:   r.Hash.Bytes(),
+		BlockHash:         r.BlockHash.Bytes(),
+		BlockNumber:       r.BlockNumber,
+		Index:             r.TransactionIndex,
+		CumulativeGasUsed: r.CumulativeGasUsed,
+		TotalGasUsed:      r.TotalGasUsed,
+		FeeAssetId:        r.FeeAssetID,
+		Fee:               bigString(r.Fee),
+	}
+	for _, a := range r.ActionResults {
+		receipt.ActionResults = append(receipt.ActionResults, &ActionResult{
+			ActionType: a.ActionType,
+			Status:     a.Status,
+			Index:      a.Index,
+			GasUsed:    a.GasUsed,
+			Error:      a.Error,
+		})
+	}
+	for _, p := range r.FeePayments {
+		receipt.FeePayments = append(receipt.FeePayments, &FeePayment{Kind: p.Kind, Recipient: p.Recipient.String(), Amount: bigString(p.Amount)})
+	}
+	for _, l := range r.Logs {
+		entry := &Log{Name: l.Name.String(), Data: l.Data, ActionIndex: uint64(l.ActionIndex), Index: uint64(l.Index)}
+		for _, topic := range l.Topics {
+			entry.Topics = append(entry.Topics, topic.Bytes())
+		}
+		receipt.Logs = append(receipt.Logs, entry)
+	}
+	return receipt
+}
+
+func newAccount(acct *accountmanager.Account) *Account {
+	a := &Account{
+		Name:      acct.AcctName.String(),
+		Nonce:     acct.Nonce,
+		PublicKey: acct.PublicKey.Bytes(),
+		CodeHash:  acct.CodeHash.Bytes(),
+		CodeSize:  acct.CodeSize,
+		Suicide:   acct.Suicide,
+		Destroyed: acct.Destroy,
+	}
+	for _, b := range acct.Balances {
+		a.Balances = append(a.Balances, &Balance{AssetId: b.AssetID, Amount: bigString(b.Balance)})
+	}
+	return a
+}
+
+func newAsset(obj *asset.AssetObject) *Asset {
+	return &Asset{
+		Id:       obj.AssetId,
+		Name:     obj.AssetName,
+		Symbol:   obj.Symbol,
+		Amount:   bigString(obj.Amount),
+		Decimals: obj.Decimals,
+		Owner:    obj.Owner.String(),
+	}
+}
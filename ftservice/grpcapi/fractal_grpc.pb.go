@@ -0,0 +1,499 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// The gRPC API of a fractal node. It mirrors the block, transaction, account
+// and asset methods of the JSON-RPC API. Hashes are 32 bytes, amounts are
+// decimal strings.
+//
+// Regenerate the Go code with:
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative fractal.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: fractal.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Fractal_GetCurrentBlock_FullMethodName       = "/fractal.v1.Fractal/GetCurrentBlock"
+	Fractal_GetBlockByNumber_FullMethodName      = "/fractal.v1.Fractal/GetBlockByNumber"
+	Fractal_GetBlockByHash_FullMethodName        = "/fractal.v1.Fractal/GetBlockByHash"
+	Fractal_GetTransaction_FullMethodName        = "/fractal.v1.Fractal/GetTransaction"
+	Fractal_GetTransactionReceipt_FullMethodName = "/fractal.v1.Fractal/GetTransactionReceipt"
+	Fractal_SendRawTransaction_FullMethodName    = "/fractal.v1.Fractal/SendRawTransaction"
+	Fractal_GetAccount_FullMethodName            = "/fractal.v1.Fractal/GetAccount"
+	Fractal_GetNonce_FullMethodName              = "/fractal.v1.Fractal/GetNonce"
+	Fractal_GetAsset_FullMethodName              = "/fractal.v1.Fractal/GetAsset"
+	Fractal_SubscribeNewHeads_FullMethodName     = "/fractal.v1.Fractal/SubscribeNewHeads"
+)
+
+// FractalClient is the client API for Fractal service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FractalClient interface {
+	// Blocks, by number (negative for the latest) or hash.
+	GetCurrentBlock(ctx context.Context, in *GetCurrentBlockRequest, opts ...grpc.CallOption) (*Block, error)
+	GetBlockByNumber(ctx context.Context, in *GetBlockByNumberRequest, opts ...grpc.CallOption) (*Block, error)
+	GetBlockByHash(ctx context.Context, in *GetBlockByHashRequest, opts ...grpc.CallOption) (*Block, error)
+	// Transactions, from the chain or the transaction pool, and receipts.
+	GetTransaction(ctx context.Context, in *GetTransactionRequest, opts ...grpc.CallOption) (*Transaction, error)
+	GetTransactionReceipt(ctx context.Context, in *GetTransactionReceiptRequest, opts ...grpc.CallOption) (*Receipt, error)
+	SendRawTransaction(ctx context.Context, in *SendRawTransactionRequest, opts ...grpc.CallOption) (*SendRawTransactionResponse, error)
+	// Accounts and assets at the head of the chain.
+	GetAccount(ctx context.Context, in *GetAccountRequest, opts ...grpc.CallOption) (*Account, error)
+	GetNonce(ctx context.Context, in *GetNonceRequest, opts ...grpc.CallOption) (*GetNonceResponse, error)
+	GetAsset(ctx context.Context, in *GetAssetRequest, opts ...grpc.CallOption) (*Asset, error)
+	// Headers of the blocks becoming the head of the chain.
+	SubscribeNewHeads(ctx context.Context, in *SubscribeNewHeadsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Header], error)
+}
+
+type fractalClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFractalClient(cc grpc.ClientConnInterface) FractalClient {
+	return &fractalClient{cc}
+}
+
+func (c *fractalClient) GetCurrentBlock(ctx context.Context, in *GetCurrentBlockRequest, opts ...grpc.CallOption) (*Block, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Block)
+	err := c.cc.Invoke(ctx, Fractal_GetCurrentBlock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fractalClient) GetBlockByNumber(ctx context.Context, in *GetBlockByNumberRequest, opts ...grpc.CallOption) (*Block, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Block)
+	err := c.cc.Invoke(ctx, Fractal_GetBlockByNumber_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fractalClient) GetBlockByHash(ctx context.Context, in *GetBlockByHashRequest, opts ...grpc.CallOption) (*Block, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Block)
+	err := c.cc.Invoke(ctx, Fractal_GetBlockByHash_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fractalClient) GetTransaction(ctx context.Context, in *GetTransactionRequest, opts ...grpc.CallOption) (*Transaction, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Transaction)
+	err := c.cc.Invoke(ctx, Fractal_GetTransaction_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fractalClient) GetTransactionReceipt(ctx context.Context, in *GetTransactionReceiptRequest, opts ...grpc.CallOption) (*Receipt, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Receipt)
+	err := c.cc.Invoke(ctx, Fractal_GetTransactionReceipt_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fractalClient) SendRawTransaction(ctx context.Context, in *SendRawTransactionRequest, opts ...grpc.CallOption) (*SendRawTransactionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendRawTransactionResponse)
+	err := c.cc.Invoke(ctx, Fractal_SendRawTransaction_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fractalClient) GetAccount(ctx context.Context, in *GetAccountRequest, opts ...grpc.CallOption) (*Account, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Account)
+	err := c.cc.Invoke(ctx, Fractal_GetAccount_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fractalClient) GetNonce(ctx context.Context, in *GetNonceRequest, opts ...grpc.CallOption) (*GetNonceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetNonceResponse)
+	err := c.cc.Invoke(ctx, Fractal_GetNonce_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fractalClient) GetAsset(ctx context.Context, in *GetAssetRequest, opts ...grpc.CallOption) (*Asset, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Asset)
+	err := c.cc.Invoke(ctx, Fractal_GetAsset_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fractalClient) SubscribeNewHeads(ctx context.Context, in *SubscribeNewHeadsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Header], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Fractal_ServiceDesc.Streams[0], Fractal_SubscribeNewHeads_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeNewHeadsRequest, Header]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fractal_SubscribeNewHeadsClient = grpc.ServerStreamingClient[Header]
+
+// FractalServer is the server API for Fractal service.
+// All implementations must embed UnimplementedFractalServer
+// for forward compatibility.
+type FractalServer interface {
+	// Blocks, by number (negative for the latest) or hash.
+	GetCurrentBlock(context.Context, *GetCurrentBlockRequest) (*Block, error)
+	GetBlockByNumber(context.Context, *GetBlockByNumberRequest) (*Block, error)
+	GetBlockByHash(context.Context, *GetBlockByHashRequest) (*Block, error)
+	// Transactions, from the chain or the transaction pool, and receipts.
+	GetTransaction(context.Context, *GetTransactionRequest) (*Transaction, error)
+	GetTransactionReceipt(context.Context, *GetTransactionReceiptRequest) (*Receipt, error)
+	SendRawTransaction(context.Context, *SendRawTransactionRequest) (*SendRawTransactionResponse, error)
+	// Accounts and assets at the head of the chain.
+	GetAccount(context.Context, *GetAccountRequest) (*Account, error)
+	GetNonce(context.Context, *GetNonceRequest) (*GetNonceResponse, error)
+	GetAsset(context.Context, *GetAssetRequest) (*Asset, error)
+	// Headers of the blocks becoming the head of the chain.
+	SubscribeNewHeads(*SubscribeNewHeadsRequest, grpc.ServerStreamingServer[Header]) error
+	mustEmbedUnimplementedFractalServer()
+}
+
+// UnimplementedFractalServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFractalServer struct{}
+
+func (UnimplementedFractalServer) GetCurrentBlock(context.Context, *GetCurrentBlockRequest) (*Block, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCurrentBlock not implemented")
+}
+func (UnimplementedFractalServer) GetBlockByNumber(context.Context, *GetBlockByNumberRequest) (*Block, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlockByNumber not implemented")
+}
+func (UnimplementedFractalServer) GetBlockByHash(context.Context, *GetBlockByHashRequest) (*Block, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlockByHash not implemented")
+}
+func (UnimplementedFractalServer) GetTransaction(context.Context, *GetTransactionRequest) (*Transaction, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTransaction not implemented")
+}
+func (UnimplementedFractalServer) GetTransactionReceipt(context.Context, *GetTransactionReceiptRequest) (*Receipt, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTransactionReceipt not implemented")
+}
+func (UnimplementedFractalServer) SendRawTransaction(context.Context, *SendRawTransactionRequest) (*SendRawTransactionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendRawTransaction not implemented")
+}
+func (UnimplementedFractalServer) GetAccount(context.Context, *GetAccountRequest) (*Account, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAccount not implemented")
+}
+func (UnimplementedFractalServer) GetNonce(context.Context, *GetNonceRequest) (*GetNonceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNonce not implemented")
+}
+func (UnimplementedFractalServer) GetAsset(context.Context, *GetAssetRequest) (*Asset, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAsset not implemented")
+}
+func (UnimplementedFractalServer) SubscribeNewHeads(*SubscribeNewHeadsRequest, grpc.ServerStreamingServer[Header]) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeNewHeads not implemented")
+}
+func (UnimplementedFractalServer) mustEmbedUnimplementedFractalServer() {}
+func (UnimplementedFractalServer) testEmbeddedByValue()                 {}
+
+// UnsafeFractalServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FractalServer will
+// result in compilation errors.
+type UnsafeFractalServer interface {
+	mustEmbedUnimplementedFractalServer()
+}
+
+func RegisterFractalServer(s grpc.ServiceRegistrar, srv FractalServer) {
+	// If the following call pancis, it indicates UnimplementedFractalServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Fractal_ServiceDesc, srv)
+}
+
+func _Fractal_GetCurrentBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCurrentBlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FractalServer).GetCurrentBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fractal_GetCurrentBlock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FractalServer).GetCurrentBlock(ctx, req.(*GetCurrentBlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fractal_GetBlockByNumber_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBlockByNumberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FractalServer).GetBlockByNumber(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fractal_GetBlockByNumber_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FractalServer).GetBlockByNumber(ctx, req.(*GetBlockByNumberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fractal_GetBlockByHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBlockByHashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FractalServer).GetBlockByHash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fractal_GetBlockByHash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FractalServer).GetBlockByHash(ctx, req.(*GetBlockByHashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fractal_GetTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FractalServer).GetTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fractal_GetTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FractalServer).GetTransaction(ctx, req.(*GetTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fractal_GetTransactionReceipt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTransactionReceiptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FractalServer).GetTransactionReceipt(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fractal_GetTransactionReceipt_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FractalServer).GetTransactionReceipt(ctx, req.(*GetTransactionReceiptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fractal_SendRawTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendRawTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FractalServer).SendRawTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fractal_SendRawTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FractalServer).SendRawTransaction(ctx, req.(*SendRawTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fractal_GetAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FractalServer).GetAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fractal_GetAccount_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FractalServer).GetAccount(ctx, req.(*GetAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fractal_GetNonce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNonceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FractalServer).GetNonce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fractal_GetNonce_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FractalServer).GetNonce(ctx, req.(*GetNonceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fractal_GetAsset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAssetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FractalServer).GetAsset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Fractal_GetAsset_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FractalServer).GetAsset(ctx, req.(*GetAssetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Fractal_SubscribeNewHeads_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeNewHeadsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FractalServer).SubscribeNewHeads(m, &grpc.GenericServerStream[SubscribeNewHeadsRequest, Header]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fractal_SubscribeNewHeadsServer = grpc.ServerStreamingServer[Header]
+
+// Fractal_ServiceDesc is the grpc.ServiceDesc for Fractal service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Fractal_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fractal.v1.Fractal",
+	HandlerType: (*FractalServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetCurrentBlock",
+			Handler:    _Fractal_GetCurrentBlock_Handler,
+		},
+		{
+			MethodName: "GetBlockByNumber",
+			Handler:    _Fractal_GetBlockByNumber_Handler,
+		},
+		{
+			MethodName: "GetBlockByHash",
+			Handler:    _Fractal_GetBlockByHash_Handler,
+		},
+		{
+			MethodName: "GetTransaction",
+			Handler:    _Fractal_GetTransaction_Handler,
+		},
+		{
+			MethodName: "GetTransactionReceipt",
+			Handler:    _Fractal_GetTransactionReceipt_Handler,
+		},
+		{
+			MethodName: "SendRawTransaction",
+			Handler:    _Fractal_SendRawTransaction_Handler,
+		},
+		{
+			MethodName: "GetAccount",
+			Handler:    _Fractal_GetAccount_Handler,
+		},
+		{
+			MethodName: "GetNonce",
+			Handler:    _Fractal_GetNonce_Handler,
+		},
+		{
+			MethodName: "GetAsset",
+			Handler:    _Fractal_GetAsset_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeNewHeads",
+			Handler:       _Fractal_SubscribeNewHeads_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "fractal.proto",
+}
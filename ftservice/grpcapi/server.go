@@ -0,0 +1,257 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package grpcapi serves the gRPC API of a node, which mirrors the block,
+// transaction, account and asset methods of the JSON-RPC API. The protobuf
+// definitions are in fractal.proto.
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/internal/api"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// headChanSize is the size of the channels of the new heads streams.
+const headChanSize = 16
+
+// Server serves the gRPC API with the backend of the JSON-RPC API.
+type Server struct {
+	UnimplementedFractalServer
+
+	b        api.Backend
+	chain    *api.PublicBlockChainAPI
+	fractal  *api.PublicFractalAPI
+	accounts *api.AccountAPI
+	server   *grpc.Server
+	quit     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewServer creates a server of the gRPC API of the backend.
+func NewServer(b api.Backend) *Server {
+	s := &Server{
+		b:        b,
+		chain:    api.NewPublicBlockChainAPI(b),
+		fractal:  api.NewPublicFractalAPI(b),
+		accounts: api.NewAccountAPI(b),
+		server:   grpc.NewServer(),
+		quit:     make(chan struct{}),
+	}
+	RegisterFractalServer(s.server, s)
+	return s
+}
+
+// Serve serves the API on the listener until the server is stopped.
+func (s *Server) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Stop closes the streams and stops the server once the pending calls are
+// done.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+		s.server.GracefulStop()
+	})
+}
+
+// GetCurrentBlock returns the head block.
+func (s *Server) GetCurrentBlock(ctx context.Context, req *GetCurrentBlockRequest) (*Block, error) {
+	return newBlock(s.b.CurrentBlock(), req.FullTransactions), nil
+}
+
+// GetBlockByNumber returns the canonical block with the number, the head
+// block if the number is negative.
+func (s *Server) GetBlockByNumber(ctx context.Context, req *GetBlockByNumberRequest) (*Block, error) {
+	number := rpc.BlockNumber(req.Number)
+	if number < 0 {
+		number = rpc.LatestBlockNumber
+	}
+	block, err := s.b.BlockByNumber(ctx, number)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if block == nil {
+		return nil, status.Errorf(codes.NotFound, "block %d not found", req.Number)
+	}
+	return newBlock(block, req.FullTransactions), nil
+}
+
+// GetBlockByHash returns the block with the hash.
+func (s *Server) GetBlockByHash(ctx context.Context, req *GetBlockByHashRequest) (*Block, error) {
+	hash, err := toHash(req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	block, err := s.b.GetBlock(ctx, hash)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if block == nil {
+		return nil, status.Errorf(codes.NotFound, "block %x not found", hash)
+	}
+	return newBlock(block, req.FullTransactions), nil
+}
+
+// GetTransaction returns the transaction with the hash, from the chain or
+// the transaction pool.
+func (s *Server) GetTransaction(ctx context.Context, req *GetTransactionRequest) (*Transaction, error) {
+	hash, err := toHash(req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	tx := s.chain.GetTransactionByHash(ctx, hash)
+	if tx == nil {
+		return nil, status.Errorf(codes.NotFound, "transaction %x not found", hash)
+	}
+	return newTransaction(tx), nil
+}
+
+// GetTransactionReceipt returns the receipt of the transaction with the hash.
+func (s *Server) GetTransactionReceipt(ctx context.Context, req *GetTransactionReceiptRequest) (*Receipt, error) {
+	hash, err := toHash(req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := s.chain.GetTransactionReceipt(ctx, hash)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if receipt == nil {
+		return nil, status.Errorf(codes.NotFound, "receipt of %x not found", hash)
+	}
+	return newReceipt(receipt), nil
+}
+
+// SendRawTransaction adds the signed transaction to the transaction pool.
+func (s *Server) SendRawTransaction(ctx context.Context, req *SendRawTransactionRequest) (*SendRawTransactionResponse, error) {
+	if err := rlp.DecodeBytes(req.Transaction, new(types.Transaction)); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid transaction: %v", err)
+	}
+	hash, err := s.fractal.SendRawTransaction(ctx, req.Transaction)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &SendRawTransactionResponse{Hash: hash.Bytes()}, nil
+}
+
+// GetAccount returns the account with the name.
+func (s *Server) GetAccount(ctx context.Context, req *GetAccountRequest) (*Account, error) {
+	acct, err := s.accounts.GetAccountByName(ctx, common.Name(req.Name))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if acct == nil {
+		return nil, status.Errorf(codes.NotFound, "account %s not found", req.Name)
+	}
+	return newAccount(acct), nil
+}
+
+// GetNonce returns the nonce of the account with the name.
+func (s *Server) GetNonce(ctx context.Context, req *GetNonceRequest) (*GetNonceResponse, error) {
+	nonce, err := s.accounts.GetNonce(ctx, common.Name(req.Name))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &GetNonceResponse{Nonce: nonce}, nil
+}
+
+// GetAsset returns the asset with the id or the name.
+func (s *Server) GetAsset(ctx context.Context, req *GetAssetRequest) (*Asset, error) {
+	var (
+		obj *asset.AssetObject
+		err error
+	)
+	switch a := req.Asset.(type) {
+	case *GetAssetRequest_Id:
+		obj, err = s.accounts.GetAssetInfoByID(ctx, a.Id)
+	case *GetAssetRequest_Name:
+		if obj, err = s.accounts.GetAssetInfoByName(ctx, a.Name); err == asset.ErrAssetIdInvalid {
+			// names of no asset resolve to the invalid id 0
+			obj, err = nil, nil
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "asset id or name missing")
+	}
+	if err == asset.ErrAssetIdInvalid {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if obj == nil {
+		return nil, status.Error(codes.NotFound, "asset not found")
+	}
+	return newAsset(obj), nil
+}
+
+// SubscribeNewHeads streams the headers of the blocks becoming the head of
+// the chain, until the client cancels the stream or the server stops.
+func (s *Server) SubscribeNewHeads(req *SubscribeNewHeadsRequest, stream Fractal_SubscribeNewHeadsServer) error {
+	ch := make(chan *router.Event, headChanSize)
+	sub := s.b.Router().Subscribe(nil, ch, router.ChainHeadEv, &types.Block{})
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case e := <-ch:
+			if err := stream.Send(newHeader(e.Data.(*types.Block))); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.quit:
+			return status.Error(codes.Unavailable, "server stopped")
+		}
+	}
+}
+
+// toHash checks the length of a hash of a request.
+func toHash(b []byte) (common.Hash, error) {
+	if len(b) != common.HashLength {
+		return common.Hash{}, status.Errorf(codes.InvalidArgument, "hash of %d bytes, want %d", len(b), common.HashLength)
+	}
+	return common.BytesToHash(b), nil
+}
+
+// toStatus converts an error of the JSON-RPC API to a gRPC status, by its
+// JSON-RPC error code if it has one.
+func toStatus(err error) error {
+	code := codes.Unknown
+	if e, ok := err.(interface{ ErrorCode() int }); ok {
+		switch e.ErrorCode() {
+		case api.ErrCodeNonceTooLow, api.ErrCodeInsufficientFee:
+			code = codes.FailedPrecondition
+		case api.ErrCodeUnknownAccount:
+			code = codes.NotFound
+		case api.ErrCodeExecutionReverted:
+			code = codes.Aborted
+		}
+	}
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package grpcapi
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/internal/api"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testBackend serves a chain of blocks and rejects the transactions sent
+// with sendErr, the other methods of the backend aren't used by the tests.
+type testBackend struct {
+	api.Backend
+	router  *router.Router
+	blocks  []*types.Block
+	sendErr error
+}
+
+func newTestBackend(n int) *testBackend {
+	b := &testBackend{router: router.NewRouter()}
+	parent := common.Hash{}
+	for i := 0; i < n; i++ {
+		block := types.NewBlockWithHeader(&types.Header{
+			ParentHash: parent,
+			Coinbase:   common.Name("producer"),
+			Number:     big.NewInt(int64(i)),
+			Time:       big.NewInt(int64(i)),
+			Difficulty: big.NewInt(1),
+		})
+		b.blocks = append(b.blocks, block)
+		parent = block.Hash()
+	}
+	return b
+}
+
+func (b *testBackend) Router() *router.Router     { return b.router }
+func (b *testBackend) CurrentBlock() *types.Block { return b.blocks[len(b.blocks)-1] }
+
+func (b *testBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	if number == rpc.LatestBlockNumber {
+		return b.CurrentBlock(), nil
+	}
+	if int(number) < len(b.blocks) {
+		return b.blocks[number], nil
+	}
+	return nil, nil
+}
+
+func (b *testBackend) GetBlock(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	for _, block := range b.blocks {
+		if block.Hash() == hash {
+			return block, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *testBackend) SendTx(ctx context.Context, tx *types.Transaction) error { return b.sendErr }
+
+// newTestClient serves the API of the backend in memory and returns a client
+// connected to it.
+func newTestClient(t *testing.T, b api.Backend) (FractalClient, *Server, func()) {
+	listener := bufconn.Listen(1 << 20)
+	server := NewServer(b)
+	go server.Serve(listener)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFractalClient(conn), server, func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func TestServerBlocks(t *testing.T) {
+	b := newTestBackend(3)
+	client, _, stop := newTestClient(t, b)
+	defer stop()
+	ctx := context.Background()
+
+	block, err := client.GetBlockByNumber(ctx, &GetBlockByNumberRequest{Number: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Header.Number != 1 || common.BytesToHash(block.Header.Hash) != b.blocks[1].Hash() || block.Header.Coinbase != "producer" {
+		t.Fatalf("block mismatch: have number %d, hash %x", block.Header.Number, block.Header.Hash)
+	}
+	if block, err = client.GetBlockByNumber(ctx, &GetBlockByNumberRequest{Number: -1}); err != nil || block.Header.Number != 2 {
+		t.Fatalf("latest block mismatch: have %v, %v", block, err)
+	}
+	if block, err = client.GetBlockByHash(ctx, &GetBlockByHashRequest{Hash: b.blocks[0].Hash().Bytes()}); err != nil || block.Header.Number != 0 {
+		t.Fatalf("block by hash mismatch: have %v, %v", block, err)
+	}
+
+	tests := []struct {
+		call func() error
+		code codes.Code
+	}{
+		{func() error { _, err := client.GetBlockByNumber(ctx, &GetBlockByNumberRequest{Number: 5}); return err }, codes.NotFound},
+		{func() error {
+			_, err := client.GetBlockByHash(ctx, &GetBlockByHashRequest{Hash: []byte{1}})
+			return err
+		}, codes.InvalidArgument},
+		{func() error {
+			_, err := client.GetBlockByHash(ctx, &GetBlockByHashRequest{Hash: make([]byte, 32)})
+			return err
+		}, codes.NotFound},
+	}
+	for i, test := range tests {
+		if code := status.Code(test.call()); code != test.code {
+			t.Errorf("test %d: code mismatch: have %v, want %v", i, code, test.code)
+		}
+	}
+}
+
+func TestServerSendRawTransaction(t *testing.T) {
+	b := newTestBackend(1)
+	client, _, stop := newTestClient(t, b)
+	defer stop()
+	ctx := context.Background()
+
+	if _, err := client.SendRawTransaction(ctx, &SendRawTransactionRequest{Transaction: []byte{0x01}}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("malformed transaction: have %v, want %v", err, codes.InvalidArgument)
+	}
+	tx := types.NewTransaction(1, big.NewInt(1), types.NewAction(types.Transfer, common.Name("sender"), common.Name("recipient"), 0, 1, 21000, big.NewInt(1), nil))
+	encoded, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.SendRawTransaction(ctx, &SendRawTransactionRequest{Transaction: encoded})
+	if err != nil || common.BytesToHash(resp.Hash) != tx.Hash() {
+		t.Fatalf("hash mismatch: have %v, %v, want %x", resp, err, tx.Hash())
+	}
+	b.sendErr = txpool.ErrNonceTooLow
+	if _, err := client.SendRawTransaction(ctx, &SendRawTransactionRequest{Transaction: encoded}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("rejected transaction: have %v, want %v", err, codes.FailedPrecondition)
+	}
+}
+
+func TestServerSubscribeNewHeads(t *testing.T) {
+	b := newTestBackend(3)
+	client, server, stop := newTestClient(t, b)
+	defer stop()
+
+	stream, err := client.SubscribeNewHeads(context.Background(), &SubscribeNewHeadsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The subscription starts with the first message, resend the head until
+	// it is received.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			b.router.SendEvent(&router.Event{Typecode: router.ChainHeadEv, Data: b.CurrentBlock()})
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	head, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Number != 2 || common.BytesToHash(head.Hash) != b.CurrentBlock().Hash() {
+		t.Fatalf("head mismatch: have number %d, hash %x", head.Number, head.Hash)
+	}
+
+	go server.Stop()
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if status.Code(err) != codes.Unavailable {
+				t.Fatalf("stream end mismatch: have %v, want %v", err, codes.Unavailable)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ftservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/rawdb"
+)
+
+// HealthStatus reports the state of the node to health and readiness
+// probes.
+type HealthStatus struct {
+	Syncing       bool     `json:"syncing"`
+	CurrentBlock  uint64   `json:"currentBlock"`
+	HighestBlock  uint64   `json:"highestBlock"`
+	Peers         int      `json:"peers"`
+	LastBlockAge  float64  `json:"lastBlockAge"` // seconds since the head block was produced
+	DatabaseError string   `json:"databaseError,omitempty"`
+	Problems      []string `json:"problems,omitempty"` // reasons why the node isn't ready
+}
+
+// Healthy reports whether the node is alive and its database readable.
+func (h *HealthStatus) Healthy() bool {
+	return h.DatabaseError == ""
+}
+
+// Ready reports whether the node is synchronised and can serve requests.
+func (h *HealthStatus) Ready() bool {
+	return len(h.Problems) == 0
+}
+
+// check sets the problems keeping the node from being ready.
+func (h *HealthStatus) check(maxBlockAge time.Duration, minPeers int) {
+	h.Problems = nil
+	if h.DatabaseError != "" {
+		h.Problems = append(h.Problems, "database: "+h.DatabaseError)
+	}
+	if h.Syncing || h.HighestBlock > h.CurrentBlock {
+		h.Problems = append(h.Problems, fmt.Sprintf("syncing: block %d of %d", h.CurrentBlock, h.HighestBlock))
+	}
+	if h.Peers < minPeers {
+		h.Problems = append(h.Problems, fmt.Sprintf("peers: %d of %d", h.Peers, minPeers))
+	}
+	if maxBlockAge > 0 && h.LastBlockAge > maxBlockAge.Seconds() {
+		h.Problems = append(h.Problems, fmt.Sprintf("last block age: %.0fs", h.LastBlockAge))
+	}
+}
+
+// Health returns the state of the node.
+func (fs *FtService) Health() *HealthStatus {
+	status := new(HealthStatus)
+	if dl := fs.blockchain.Downloader(); dl != nil {
+		progress := dl.Progress()
+		status.Syncing = progress.Syncing
+		status.CurrentBlock, status.HighestBlock = progress.CurrentBlock, progress.HighestBlock
+	} else {
+		status.CurrentBlock = fs.blockchain.CurrentBlock().NumberU64()
+		status.HighestBlock = status.CurrentBlock
+	}
+	if fs.p2pServer != nil {
+		status.Peers = fs.p2pServer.PeerCount()
+	}
+	head := fs.blockchain.CurrentBlock()
+	status.LastBlockAge = time.Duration(time.Now().UnixNano() - head.Time().Int64()).Seconds()
+
+	// Read the head from the database rather than the caches.
+	hash := rawdb.ReadHeadBlockHash(fs.chainDb)
+	if number := rawdb.ReadHeaderNumber(fs.chainDb, hash); number == nil {
+		status.DatabaseError = "head block number unreadable"
+	} else if rawdb.ReadHeader(fs.chainDb, hash, *number) == nil {
+		status.DatabaseError = "head header unreadable"
+	}
+	status.check(time.Duration(fs.config.ReadyMaxBlockAge)*time.Second, fs.config.ReadyMinPeers)
+	return status
+}
+
+// healthHandler serves the probes. "/health" answers 200 if the node is
+// healthy and "/ready" if it is ready, 503 otherwise, both with the status as
+// JSON.
+func healthHandler(status func() *HealthStatus) http.Handler {
+	serve := func(w http.ResponseWriter, ok bool, status *HealthStatus) {
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		s := status()
+		serve(w, s.Healthy(), s)
+	})
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		s := status()
+		serve(w, s.Ready(), s)
+	})
+	return mux
+}
+
+// startHealth opens the endpoint of the health probes.
+func (fs *FtService) startHealth() error {
+	if fs.config.HealthAddr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", fs.config.HealthAddr)
+	if err != nil {
+		return err
+	}
+	fs.healthServer = &http.Server{
+		Handler:      healthHandler(fs.Health),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go fs.healthServer.Serve(listener)
+	log.Info("Health endpoint opened", "url", fmt.Sprintf("http://%s", listener.Addr()))
+	return nil
+}
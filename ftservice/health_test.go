@@ -0,0 +1,59 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ftservice
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	status := &HealthStatus{CurrentBlock: 10, HighestBlock: 10, Peers: 3, LastBlockAge: 2}
+	handler := healthHandler(func() *HealthStatus {
+		status.check(time.Minute, 1)
+		return status
+	})
+	probe := func(path string) (int, *HealthStatus) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var s HealthStatus
+		if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, &s
+	}
+
+	if code, _ := probe("/ready"); code != http.StatusOK {
+		t.Fatalf("ready code mismatch: have %d, want %d", code, http.StatusOK)
+	}
+	// Behind the remotes and with a stale head.
+	status.HighestBlock, status.LastBlockAge = 20, 120
+	code, s := probe("/ready")
+	if code != http.StatusServiceUnavailable || len(s.Problems) != 2 {
+		t.Fatalf("ready mismatch: have %d %q", code, s.Problems)
+	}
+	if code, _ := probe("/health"); code != http.StatusOK {
+		t.Fatalf("health code mismatch: have %d, want %d", code, http.StatusOK)
+	}
+	status.DatabaseError = "head header unreadable"
+	if code, _ := probe("/health"); code != http.StatusServiceUnavailable {
+		t.Fatalf("health code mismatch: have %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ftservice
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fractalplatform/fractal/log"
+)
+
+const (
+	// defaultStopTimeout is the time a subsystem has to stop.
+	defaultStopTimeout = 10 * time.Second
+	// chainStopTimeout is the time the blockchain has to finish inserting
+	// the block in progress.
+	chainStopTimeout = 30 * time.Second
+)
+
+// stopStep is a subsystem stopped on shutdown.
+type stopStep struct {
+	name    string
+	timeout time.Duration
+	stop    func() error
+	wait    bool // the next subsystems don't stop before this one, however long it takes
+}
+
+// lifecycle stops the subsystems of the service in the order they were
+// registered, the ones depending on others first.
+type lifecycle struct {
+	steps []stopStep
+}
+
+// register adds the subsystem stopped after those registered before it.
+func (lc *lifecycle) register(name string, timeout time.Duration, stop func() error) {
+	lc.steps = append(lc.steps, stopStep{name: name, timeout: timeout, stop: stop})
+}
+
+// registerWait adds a subsystem the next ones are only stopped after, even if
+// it doesn't stop in time, such as the blockchain writing to the database
+// closed after it.
+func (lc *lifecycle) registerWait(name string, timeout time.Duration, stop func() error) {
+	lc.steps = append(lc.steps, stopStep{name: name, timeout: timeout, stop: stop, wait: true})
+}
+
+// shutdown stops the subsystems in order. A subsystem not stopped in time is
+// left behind so the next ones still stop, and reported in the error with
+// those which failed. The subsystems registered to be waited for hold up the
+// next ones until they stopped, with a warning once their timeout passed.
+func (lc *lifecycle) shutdown() error {
+	var failures []string
+	for _, step := range lc.steps {
+		start := time.Now()
+		errc := make(chan error, 1)
+		go func(stop func() error) { errc <- stop() }(step.stop)
+
+		timer := time.NewTimer(step.timeout)
+		select {
+		case err := <-errc:
+			if err != nil {
+				log.Error("Failed to stop subsystem", "name", step.name, "err", err)
+				failures = append(failures, fmt.Sprintf("%s: %v", step.name, err))
+			} else {
+				log.Debug("Subsystem stopped", "name", step.name, "elapsed", time.Since(start))
+			}
+		case <-timer.C:
+			if step.wait {
+				log.Warn("Waiting for subsystem to stop", "name", step.name, "timeout", step.timeout)
+				if err := <-errc; err != nil {
+					log.Error("Failed to stop subsystem", "name", step.name, "err", err)
+					failures = append(failures, fmt.Sprintf("%s: %v", step.name, err))
+				}
+				log.Warn("Subsystem stopped late", "name", step.name, "elapsed", time.Since(start))
+				break
+			}
+			log.Error("Subsystem not stopped in time", "name", step.name, "timeout", step.timeout)
+			failures = append(failures, fmt.Sprintf("%s: not stopped in %v", step.name, step.timeout))
+		}
+		timer.Stop()
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("shutdown: %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ftservice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLifecycleShutdown(t *testing.T) {
+	var (
+		order []string
+		block = make(chan struct{})
+	)
+	defer close(block)
+	stop := func(name string, err error) func() error {
+		return func() error {
+			order = append(order, name)
+			return err
+		}
+	}
+	lc := new(lifecycle)
+	lc.register("rpc", time.Second, stop("rpc", nil))
+	lc.register("miner", time.Second, stop("miner", errors.New("failed")))
+	lc.register("downloader", 50*time.Millisecond, func() error {
+		<-block
+		return nil
+	})
+	// the database is only closed once the blockchain stopped, however late
+	lc.registerWait("blockchain", 20*time.Millisecond, func() error {
+		time.Sleep(100 * time.Millisecond)
+		return stop("blockchain", nil)()
+	})
+	lc.register("database", time.Second, stop("database", nil))
+
+	err := lc.shutdown()
+	if have, want := strings.Join(order, ","), "rpc,miner,blockchain,database"; have != want {
+		t.Fatalf("stop order mismatch: have %s, want %s", have, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), "miner: failed") || !strings.Contains(err.Error(), "downloader: not stopped") {
+		t.Fatalf("shutdown error mismatch: %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package mqbridge republishes chain events to external message queues.
+package mqbridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/internal/api"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+// Kinds of the events republished by the bridge, each published to the
+// subject of the topic followed by the kind.
+const (
+	Blocks   = "blocks"   // new canonical blocks
+	Receipts = "receipts" // receipts of the new canonical blocks
+	Reorgs   = "reorgs"   // canonical blocks dropped by a reorganisation
+)
+
+const (
+	chainHeadChanSize = 16
+	retryInterval     = 5 * time.Second
+)
+
+var cursorKey = []byte("mqbridge-cursor")
+
+// Chain is the chain the bridge republishes the events of.
+type Chain interface {
+	CurrentBlock() *types.Block
+	GetBlock(hash common.Hash, number uint64) *types.Block
+	GetBlockByNumber(number uint64) *types.Block
+	GetReceiptsByHash(hash common.Hash) []*types.Receipt
+	Config() *params.ChainConfig
+	Router() *event.Router
+}
+
+// Config configures the bridge.
+type Config struct {
+	URL        string   // URL of the message queue, e.g. nats://localhost:4222
+	Topic      string   // prefix of the subjects published to
+	Events     []string // kinds of the events to publish, all if empty
+	ReplayFrom uint64   // number of the first block to publish, 0 to resume from the cursor
+}
+
+// BlockID identifies a block.
+type BlockID struct {
+	Number uint64      `json:"number"`
+	Hash   common.Hash `json:"hash"`
+}
+
+// ReceiptsMessage is the message published for the receipts of a block.
+type ReceiptsMessage struct {
+	BlockHash   common.Hash         `json:"blockHash"`
+	BlockNumber uint64              `json:"blockNumber"`
+	Receipts    []*types.RPCReceipt `json:"receipts"`
+}
+
+// ReorgMessage is the message published for the canonical blocks dropped by
+// a reorganisation, from the old head down to the common ancestor.
+type ReorgMessage struct {
+	Dropped  []BlockID `json:"dropped"`
+	Ancestor BlockID   `json:"ancestor"`
+}
+
+// Bridge republishes the new blocks, their receipts and reorganisations of a
+// chain to a message queue. Every block is published at least once: the
+// cursor of the last published block is persisted and publishing resumes
+// after it on failures and restarts.
+type Bridge struct {
+	chain     Chain
+	db        fdb.Database
+	publisher Publisher
+	topic     string
+	events    map[string]bool
+	cursor    BlockID
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// Open creates a bridge publishing the events of the chain to the message
+// queue at the URL of the config. The cursor is stored in db.
+func Open(config *Config, chain Chain, db fdb.Database) (*Bridge, error) {
+	publisher, err := Dial(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	bridge, err := New(config, chain, db, publisher)
+	if err != nil {
+		publisher.Close()
+		return nil, err
+	}
+	return bridge, nil
+}
+
+// New creates a bridge publishing the events of the chain with publisher.
+// The cursor is stored in db.
+func New(config *Config, chain Chain, db fdb.Database, publisher Publisher) (*Bridge, error) {
+	b := &Bridge{
+		chain:     chain,
+		db:        db,
+		publisher: publisher,
+		topic:     config.Topic,
+		events:    make(map[string]bool),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, kind := range config.Events {
+		switch kind {
+		case Blocks, Receipts, Reorgs:
+			b.events[kind] = true
+		default:
+			return nil, fmt.Errorf("unknown event kind %q", kind)
+		}
+	}
+	if len(b.events) == 0 {
+		b.events = map[string]bool{Blocks: true, Receipts: true, Reorgs: true}
+	}
+
+	switch data, _ := db.Get(cursorKey); {
+	case config.ReplayFrom > 0:
+		parent := chain.GetBlockByNumber(config.ReplayFrom - 1)
+		if parent == nil {
+			return nil, fmt.Errorf("replay block %d not found", config.ReplayFrom)
+		}
+		b.cursor = BlockID{parent.NumberU64(), parent.Hash()}
+	case len(data) > 0:
+		if err := rlp.DecodeBytes(data, &b.cursor); err != nil {
+			return nil, err
+		}
+	default:
+		head := chain.CurrentBlock()
+		b.cursor = BlockID{head.NumberU64(), head.Hash()}
+	}
+	return b, nil
+}
+
+// Start starts publishing the events.
+func (b *Bridge) Start() {
+	headCh := make(chan *event.Event, chainHeadChanSize)
+	headSub := b.chain.Router().Subscribe(nil, headCh, event.ChainHeadEv, &types.Block{})
+	go b.loop(headCh, headSub)
+}
+
+// Stop stops publishing and closes the publisher.
+func (b *Bridge) Stop() {
+	close(b.quit)
+	<-b.done
+	b.publisher.Close()
+}
+
+func (b *Bridge) loop(headCh chan *event.Event, headSub event.Subscription) {
+	defer close(b.done)
+	defer headSub.Unsubscribe()
+
+	retry := time.NewTicker(retryInterval)
+	defer retry.Stop()
+	for {
+		if err := b.sync(); err != nil {
+			log.Warn("Failed to publish chain events", "cursor", b.cursor.Number, "err", err)
+		}
+		select {
+		case <-headCh:
+		case <-retry.C:
+		case <-b.quit:
+			return
+		}
+	}
+}
+
+// sync publishes the events from the cursor up to the head of the chain.
+func (b *Bridge) sync() error {
+	if err := b.unwind(); err != nil {
+		return err
+	}
+	head := b.chain.CurrentBlock().NumberU64()
+	for number := b.cursor.Number + 1; number <= head; number++ {
+		select {
+		case <-b.quit:
+			return nil
+		default:
+		}
+		block := b.chain.GetBlockByNumber(number)
+		if block == nil || block.ParentHash() != b.cursor.Hash {
+			// The chain was reorganised meanwhile, unwind on the next sync
+			return nil
+		}
+		if err := b.publishBlock(block); err != nil {
+			return err
+		}
+		if err := b.setCursor(BlockID{number, block.Hash()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unwind moves the cursor back to the canonical chain, publishing the blocks
+// dropped by a reorganisation.
+func (b *Bridge) unwind() error {
+	var msg ReorgMessage
+	block := b.chain.GetBlock(b.cursor.Hash, b.cursor.Number)
+	for block != nil {
+		if canon := b.chain.GetBlockByNumber(block.NumberU64()); canon != nil && canon.Hash() == block.Hash() {
+			break
+		}
+		msg.Dropped = append(msg.Dropped, BlockID{block.NumberU64(), block.Hash()})
+		block = b.chain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	}
+	if block == nil {
+		return fmt.Errorf("cursor block %d %x not found", b.cursor.Number, b.cursor.Hash)
+	}
+	if len(msg.Dropped) == 0 {
+		return nil
+	}
+	msg.Ancestor = BlockID{block.NumberU64(), block.Hash()}
+	if b.events[Reorgs] {
+		if err := b.publish(Reorgs, msg); err != nil {
+			return err
+		}
+	}
+	return b.setCursor(msg.Ancestor)
+}
+
+// publishBlock publishes the events of a new canonical block.
+func (b *Bridge) publishBlock(block *types.Block) error {
+	if b.events[Blocks] {
+		if err := b.publish(Blocks, api.RPCMarshalBlock(b.chain.Config().ChainID, block, true, false)); err != nil {
+			return err
+		}
+	}
+	if b.events[Receipts] {
+		msg := ReceiptsMessage{BlockHash: block.Hash(), BlockNumber: block.NumberU64()}
+		receipts := b.chain.GetReceiptsByHash(block.Hash())
+		for i, tx := range block.Transactions() {
+			if i < len(receipts) {
+				msg.Receipts = append(msg.Receipts, receipts[i].NewRPCReceipt(block.Hash(), block.NumberU64(), uint64(i), tx))
+			}
+		}
+		if err := b.publish(Receipts, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) publish(kind string, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.publisher.Publish(b.topic+"."+kind, data)
+}
+
+func (b *Bridge) setCursor(cursor BlockID) error {
+	data, err := rlp.EncodeToBytes(&cursor)
+	if err != nil {
+		return err
+	}
+	if err := b.db.Put(cursorKey, data); err != nil {
+		return err
+	}
+	b.cursor = cursor
+	return nil
+}
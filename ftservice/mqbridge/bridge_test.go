@@ -0,0 +1,263 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mqbridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+type testChain struct {
+	blocks map[common.Hash]*types.Block
+	canon  []*types.Block
+}
+
+func newTestChain() *testChain {
+	genesis := types.NewBlockWithHeader(&types.Header{Number: new(big.Int), Time: new(big.Int), Difficulty: new(big.Int)})
+	return &testChain{
+		blocks: map[common.Hash]*types.Block{genesis.Hash(): genesis},
+		canon:  []*types.Block{genesis},
+	}
+}
+
+// extend makes n canonical blocks on top of parent, replacing the canonical
+// blocks after parent.
+func (c *testChain) extend(parent *types.Block, n int, seed byte) {
+	c.canon = c.canon[:parent.NumberU64()+1]
+	for i := 0; i < n; i++ {
+		block := types.NewBlockWithHeader(&types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), big.NewInt(1)),
+			Time:       new(big.Int),
+			Difficulty: new(big.Int),
+			Extra:      []byte{seed},
+		})
+		c.blocks[block.Hash()] = block
+		c.canon = append(c.canon, block)
+		parent = block
+	}
+}
+
+func (c *testChain) CurrentBlock() *types.Block { return c.canon[len(c.canon)-1] }
+
+func (c *testChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	if block := c.blocks[hash]; block != nil && block.NumberU64() == number {
+		return block
+	}
+	return nil
+}
+
+func (c *testChain) GetBlockByNumber(number uint64) *types.Block {
+	if number < uint64(len(c.canon)) {
+		return c.canon[number]
+	}
+	return nil
+}
+
+func (c *testChain) GetReceiptsByHash(hash common.Hash) []*types.Receipt { return nil }
+func (c *testChain) Config() *params.ChainConfig                         { return params.DefaultChainconfig }
+func (c *testChain) Router() *event.Router                               { return nil }
+
+type testMessage struct {
+	subject string
+	data    []byte
+}
+
+type testPublisher struct {
+	messages []testMessage
+	fail     bool
+}
+
+func (p *testPublisher) Publish(subject string, data []byte) error {
+	if p.fail {
+		return errors.New("unavailable")
+	}
+	p.messages = append(p.messages, testMessage{subject, data})
+	return nil
+}
+
+func (p *testPublisher) Close() error { return nil }
+
+// publishedBlocks returns the numbers of the published blocks and clears
+// the messages.
+func (p *testPublisher) publishedBlocks(t *testing.T) []uint64 {
+	var numbers []uint64
+	for _, msg := range p.messages {
+		if msg.subject != "test."+Blocks {
+			continue
+		}
+		var block struct{ Number uint64 }
+		if err := json.Unmarshal(msg.data, &block); err != nil {
+			t.Fatal(err)
+		}
+		numbers = append(numbers, block.Number)
+	}
+	p.messages = nil
+	return numbers
+}
+
+func TestBridgeCursor(t *testing.T) {
+	chain, db, publisher := newTestChain(), fdb.NewMemDatabase(), new(testPublisher)
+	chain.extend(chain.CurrentBlock(), 3, 0)
+
+	config := &Config{Topic: "test", Events: []string{Blocks}, ReplayFrom: 2}
+	bridge, err := New(config, chain, db, publisher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bridge.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if have := fmt.Sprint(publisher.publishedBlocks(t)); have != "[2 3]" {
+		t.Fatalf("published blocks mismatch: have %s, want [2 3]", have)
+	}
+
+	// Publishing resumes after the stored cursor, failed blocks are retried.
+	chain.extend(chain.CurrentBlock(), 2, 0)
+	config.ReplayFrom = 0
+	if bridge, err = New(config, chain, db, publisher); err != nil {
+		t.Fatal(err)
+	}
+	publisher.fail = true
+	if err := bridge.sync(); err == nil {
+		t.Fatal("expected publish error")
+	}
+	publisher.fail = false
+	if err := bridge.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if have := fmt.Sprint(publisher.publishedBlocks(t)); have != "[4 5]" {
+		t.Fatalf("published blocks mismatch: have %s, want [4 5]", have)
+	}
+}
+
+func TestBridgeReorg(t *testing.T) {
+	chain, db, publisher := newTestChain(), fdb.NewMemDatabase(), new(testPublisher)
+	chain.extend(chain.CurrentBlock(), 4, 0)
+
+	bridge, err := New(&Config{Topic: "test", Events: []string{Blocks, Reorgs}, ReplayFrom: 1}, chain, db, publisher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bridge.sync(); err != nil {
+		t.Fatal(err)
+	}
+	publisher.publishedBlocks(t)
+
+	dropped := []*types.Block{chain.canon[4], chain.canon[3]}
+	chain.extend(chain.canon[2], 3, 1)
+	if err := bridge.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(publisher.messages) == 0 || publisher.messages[0].subject != "test."+Reorgs {
+		t.Fatalf("reorg not published first: %v", publisher.messages)
+	}
+	var reorg ReorgMessage
+	if err := json.Unmarshal(publisher.messages[0].data, &reorg); err != nil {
+		t.Fatal(err)
+	}
+	if len(reorg.Dropped) != 2 || reorg.Dropped[0].Hash != dropped[0].Hash() || reorg.Dropped[1].Hash != dropped[1].Hash() {
+		t.Fatalf("dropped blocks mismatch: %v", reorg.Dropped)
+	}
+	if reorg.Ancestor.Number != 2 || reorg.Ancestor.Hash != chain.canon[2].Hash() {
+		t.Fatalf("ancestor mismatch: %v", reorg.Ancestor)
+	}
+	if have := fmt.Sprint(publisher.publishedBlocks(t)); have != "[3 4 5]" {
+		t.Fatalf("published blocks mismatch: have %s, want [3 4 5]", have)
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprint(conn, "INFO {}\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "PUB"):
+				payload, _ := r.ReadString('\n')
+				received <- strings.TrimSpace(line) + " " + strings.TrimSpace(payload)
+			case strings.HasPrefix(line, "PING"):
+				fmt.Fprint(conn, "PONG\r\n")
+			}
+		}
+	}()
+
+	publisher, err := Dial("nats://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	if err := publisher.Publish("test.blocks", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if have := <-received; have != "PUB test.blocks 5 hello" {
+		t.Fatalf("received message mismatch: have %q", have)
+	}
+	if _, err := Dial("kafka://localhost:9092"); err == nil {
+		t.Fatal("expected error for unregistered scheme")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	chain, db, publisher := newTestChain(), fdb.NewMemDatabase(), new(testPublisher)
+	RegisterPublisher("test", func(u *url.URL) (Publisher, error) {
+		if u.Host != "queue" {
+			return nil, fmt.Errorf("unexpected host %q", u.Host)
+		}
+		return publisher, nil
+	})
+
+	bridge, err := Open(&Config{URL: "test://queue", Topic: "test"}, chain, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bridge.publisher != publisher {
+		t.Fatal("bridge not publishing to the queue of the URL")
+	}
+	if _, err := Open(&Config{URL: "kafka://queue"}, chain, db); err == nil {
+		t.Fatal("expected error for a scheme without publisher")
+	}
+}
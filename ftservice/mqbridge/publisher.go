@@ -0,0 +1,180 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package mqbridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Publisher publishes messages to a message queue.
+type Publisher interface {
+	// Publish publishes the message to the subject, returning once the
+	// message queue received it.
+	Publish(subject string, data []byte) error
+	Close() error
+}
+
+var (
+	publishersMu sync.RWMutex
+	publishers   = map[string]func(u *url.URL) (Publisher, error){
+		"nats": newNATSPublisher,
+	}
+)
+
+// RegisterPublisher registers the constructor of the publishers of the URLs
+// with the scheme, e.g. to publish to Kafka with a client library.
+func RegisterPublisher(scheme string, newPublisher func(u *url.URL) (Publisher, error)) {
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+	publishers[scheme] = newPublisher
+}
+
+// Dial creates a publisher of the message queue at the URL.
+func Dial(rawurl string) (Publisher, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	publishersMu.RLock()
+	newPublisher, ok := publishers[u.Scheme]
+	publishersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no publisher for scheme %q", u.Scheme)
+	}
+	return newPublisher(u)
+}
+
+const (
+	natsDefaultPort = "4222"
+	natsTimeout     = 10 * time.Second
+)
+
+// natsPublisher publishes to a NATS server, waiting for the server to
+// acknowledge every message with a PING. The connection is reopened after
+// failures.
+type natsPublisher struct {
+	addr string
+	user *url.Userinfo
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newNATSPublisher(u *url.URL) (Publisher, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), natsDefaultPort)
+	}
+	return &natsPublisher{addr: addr, user: u.User}, nil
+}
+
+func (p *natsPublisher) Publish(subject string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	err := p.send(fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(data), data))
+	if err == nil {
+		err = p.expect("PONG")
+	}
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+func (p *natsPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+func (p *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, natsTimeout)
+	if err != nil {
+		return err
+	}
+	p.conn, p.r = conn, bufio.NewReader(conn)
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false}
+	if p.user != nil {
+		options["user"] = p.user.Username()
+		if pass, ok := p.user.Password(); ok {
+			options["pass"] = pass
+		}
+	}
+	connect, _ := json.Marshal(options)
+	if err = p.expect("INFO"); err == nil {
+		if err = p.send(fmt.Sprintf("CONNECT %s\r\nPING\r\n", connect)); err == nil {
+			err = p.expect("PONG")
+		}
+	}
+	if err != nil {
+		conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+func (p *natsPublisher) send(s string) error {
+	p.conn.SetWriteDeadline(time.Now().Add(natsTimeout))
+	_, err := p.conn.Write([]byte(s))
+	return err
+}
+
+// expect reads the server messages until one starting with op, answering
+// the pings of the server.
+func (p *natsPublisher) expect(op string) error {
+	p.conn.SetReadDeadline(time.Now().Add(natsTimeout))
+	for {
+		line, err := p.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, op):
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("nats: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case line == "PING":
+			if err := p.send("PONG\r\n"); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ftservice
+
+import (
+	"sync"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/types"
+)
+
+const (
+	maxTxConflicts     = 1024 // number of the latest conflicts kept
+	txConflictChanSize = 64
+)
+
+// conflictMonitor records the conflicting transactions detected by the pool
+// and the chain, keeping the latest incidents to be queried by risk systems.
+type conflictMonitor struct {
+	router    *event.Router // router of the node, the default one if nil
+	mu        sync.RWMutex
+	limit     int
+	incidents []*types.TxConflict
+	seen      map[[2]common.Hash]bool // pairs of the kept incidents
+
+	quit chan struct{}
+	done chan struct{} // closed when the loop returns, nil if never started
+}
+
+func newConflictMonitor(r *event.Router, limit int) *conflictMonitor {
+	return &conflictMonitor{
+		router: r,
+		limit:  limit,
+		seen:   make(map[[2]common.Hash]bool),
+		quit:   make(chan struct{}),
+	}
+}
+
+// start starts recording the conflicts posted on the router.
+func (m *conflictMonitor) start() {
+	ch := make(chan *event.Event, txConflictChanSize)
+	sub := m.router.Subscribe(nil, ch, event.TxConflictEv, &types.TxConflict{})
+	m.done = make(chan struct{})
+	go m.loop(ch, sub)
+}
+
+// stop stops recording the conflicts.
+func (m *conflictMonitor) stop() {
+	close(m.quit)
+	if m.done != nil {
+		<-m.done
+	}
+}
+
+func (m *conflictMonitor) loop(ch chan *event.Event, sub event.Subscription) {
+	defer close(m.done)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-ch:
+			if conflict, ok := ev.Data.(*types.TxConflict); ok {
+				m.add(conflict)
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// add records the conflict, unless the same pair of transactions is already
+// recorded, dropping the oldest incident once the limit is reached.
+func (m *conflictMonitor) add(conflict *types.TxConflict) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pair := [2]common.Hash{conflict.First, conflict.Second}
+	if m.seen[pair] {
+		return
+	}
+	if conflict.FeeBump {
+		log.Debug("Transaction fees bumped", "account", conflict.Account, "nonce", conflict.Nonce, "source", conflict.Source, "first", conflict.First, "second", conflict.Second)
+	} else {
+		log.Warn("Possible double-spend attempt", "account", conflict.Account, "nonce", conflict.Nonce, "source", conflict.Source, "first", conflict.First, "second", conflict.Second)
+	}
+	if len(m.incidents) >= m.limit {
+		oldest := m.incidents[0]
+		delete(m.seen, [2]common.Hash{oldest.First, oldest.Second})
+		m.incidents = m.incidents[1:]
+	}
+	m.incidents = append(m.incidents, conflict)
+	m.seen[pair] = true
+}
+
+// conflicts returns the recorded incidents of the account, of all the
+// accounts if empty, oldest first.
+func (m *conflictMonitor) conflicts(account common.Name) []*types.TxConflict {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]*types.TxConflict, 0)
+	for _, conflict := range m.incidents {
+		if account == "" || conflict.Account == account {
+			result = append(result, conflict)
+		}
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package ftservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/types"
+)
+
+func TestConflictMonitor(t *testing.T) {
+	event.InitRounter()
+	m := newConflictMonitor(nil, 2)
+	m.start()
+	defer m.stop()
+
+	conflict := func(account string, first, second byte) *types.TxConflict {
+		return &types.TxConflict{
+			Account: common.Name(account),
+			First:   common.BytesToHash([]byte{first}),
+			Second:  common.BytesToHash([]byte{second}),
+			Source:  types.ConflictTxPool,
+		}
+	}
+	event.SendEvent(&event.Event{Typecode: event.TxConflictEv, Data: conflict("alice", 1, 2)})
+	for i := 0; len(m.conflicts("")) == 0; i++ {
+		if i == 100 {
+			t.Fatal("conflict event not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The same pair is recorded once, the oldest incidents dropped past the limit.
+	m.add(conflict("alice", 1, 2))
+	m.add(conflict("bob", 3, 4))
+	if have := m.conflicts(""); len(have) != 2 {
+		t.Fatalf("conflicts mismatch: have %d, want 2", len(have))
+	}
+	m.add(conflict("alice", 5, 6))
+	if have := m.conflicts("alice"); len(have) != 1 || have[0].First != common.BytesToHash([]byte{5}) {
+		t.Fatalf("alice conflicts mismatch: %+v", have)
+	}
+	if have := m.conflicts("bob"); len(have) != 1 {
+		t.Fatalf("bob conflicts mismatch: have %d, want 1", len(have))
+	}
+	m.add(conflict("alice", 1, 2))
+	if have := m.conflicts(""); len(have) != 2 || have[1].First != common.BytesToHash([]byte{1}) {
+		t.Fatalf("dropped conflict not recorded again: %+v", have)
+	}
+}
+
+func TestConflictMonitorStopUnstarted(t *testing.T) {
+	m := newConflictMonitor(nil, 1)
+	done := make(chan struct{})
+	go func() {
+		m.stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stop of an unstarted monitor blocked")
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package webhook notifies HTTP endpoints of the activity of watched accounts.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// Kinds of the notifications.
+const (
+	Transfer  = "transfer"  // a watched account received a transfer
+	KeyChange = "keyChange" // a watched account changed its key
+	Reverted  = "reverted"  // event of a notification whose block left the canonical chain
+)
+
+// Headers of the notification requests.
+const (
+	SignatureHeader = "X-Fractal-Signature" // "sha256=" followed by the hex HMAC-SHA256 of the body
+	EventHeader     = "X-Fractal-Event"     // kind of the notification
+	DeliveryHeader  = "X-Fractal-Delivery"  // id of the notification, the same on every retry
+)
+
+const (
+	chainHeadChanSize = 16
+	queueSize         = 1024
+	requestTimeout    = 10 * time.Second
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = time.Minute
+	maxRevertBlocks   = 1024 // notified blocks reverted at most on a reorganisation
+)
+
+// Chain is the chain the watched accounts are active on.
+type Chain interface {
+	CurrentBlock() *types.Block
+	GetBlock(hash common.Hash, number uint64) *types.Block
+	GetBlockByNumber(number uint64) *types.Block
+	GetReceiptsByHash(hash common.Hash) []*types.Receipt
+	Router() *event.Router
+}
+
+// Config configures the dispatcher.
+type Config struct {
+	URLs          []string      // endpoints every notification is POSTed to
+	Secret        string        // key of the HMAC signatures of the bodies, unsigned if empty
+	Accounts      []string      // watched accounts
+	Retries       int           // retries of a failed delivery before dropping it
+	Confirmations uint64        // blocks on top of a block before its activity is notified
+	RetryDelay    time.Duration // delay before the first retry, doubled on every retry, 1s if zero
+}
+
+// Notification is the JSON body POSTed for an activity of a watched account.
+type Notification struct {
+	ID          string         `json:"id"`
+	Kind        string         `json:"kind"`
+	Account     common.Name    `json:"account"`
+	BlockNumber uint64         `json:"blockNumber"`
+	BlockHash   common.Hash    `json:"blockHash"`
+	TxHash      common.Hash    `json:"txHash"`
+	ActionIndex uint64         `json:"actionIndex"`
+	From        common.Name    `json:"from,omitempty"`
+	AssetID     uint64         `json:"assetID"`
+	Amount      *big.Int       `json:"amount,omitempty"`
+	Internal    bool           `json:"internal"` // moved by a contract rather than the action itself
+	PubKey      *common.PubKey `json:"pubKey,omitempty"`
+	Reverted    bool           `json:"reverted,omitempty"` // the activity left the canonical chain, with the id of its notification and a "-reverted" suffix
+}
+
+// Sign returns the value of the signature header of the body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// endpoint delivers the notifications to one URL in order, retrying each
+// until it is accepted or out of retries.
+type endpoint struct {
+	url   string
+	queue chan *Notification
+}
+
+// Dispatcher notifies the endpoints of the transfers to and the key changes
+// of the watched accounts in the new canonical blocks. Notifications are
+// delivered at least once while the node runs: a delivery is retried until
+// the endpoint answers with a 2xx status or the retries run out. When
+// notified blocks leave the canonical chain, their notifications are sent
+// again as reverted ones, the latest block first, before the activity of the
+// new canonical blocks.
+type Dispatcher struct {
+	chain         Chain
+	db            rawdb.DatabaseReader
+	client        *http.Client
+	secret        []byte
+	accounts      map[common.Name]bool
+	retries       int
+	retryDelay    time.Duration
+	confirmations uint64
+	endpoints     []*endpoint
+	cursor        uint64      // number of the last block notified
+	cursorHash    common.Hash // hash of the last block notified
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// New creates a dispatcher of the activity in the chain, whose internal
+// transactions are read from db.
+func New(config *Config, chain Chain, db rawdb.DatabaseReader) (*Dispatcher, error) {
+	if len(config.URLs) == 0 {
+		return nil, fmt.Errorf("no webhook URLs")
+	}
+	d := &Dispatcher{
+		chain:         chain,
+		db:            db,
+		client:        &http.Client{Timeout: requestTimeout},
+		secret:        []byte(config.Secret),
+		accounts:      make(map[common.Name]bool),
+		retries:       config.Retries,
+		retryDelay:    config.RetryDelay,
+		confirmations: config.Confirmations,
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if d.retryDelay <= 0 {
+		d.retryDelay = defaultRetryDelay
+	}
+	for _, name := range config.Accounts {
+		if !common.IsValidName(name) {
+			return nil, fmt.Errorf("invalid webhook account %q", name)
+		}
+		d.accounts[common.Name(name)] = true
+	}
+	for _, url := range config.URLs {
+		d.endpoints = append(d.endpoints, &endpoint{url: url, queue: make(chan *Notification, queueSize)})
+	}
+	if head := chain.CurrentBlock().NumberU64(); head > d.confirmations {
+		d.cursor = head - d.confirmations
+	}
+	if block := chain.GetBlockByNumber(d.cursor); block != nil {
+		d.cursorHash = block.Hash()
+	}
+	return d, nil
+}
+
+// Start starts notifying the activity after the current head.
+func (d *Dispatcher) Start() {
+	headCh := make(chan *event.Event, chainHeadChanSize)
+	headSub := d.chain.Router().Subscribe(nil, headCh, event.ChainHeadEv, &types.Block{})
+	go d.loop(headCh, headSub)
+	for _, ep := range d.endpoints {
+		go d.deliver(ep)
+	}
+}
+
+// Stop stops notifying, dropping the undelivered notifications.
+func (d *Dispatcher) Stop() {
+	close(d.quit)
+	<-d.done
+}
+
+// loop syncs on the new heads. The heads are only a wake-up, the sync blocks
+// on full queues in its own goroutine without holding up the chain events.
+func (d *Dispatcher) loop(headCh chan *event.Event, headSub event.Subscription) {
+	defer close(d.done)
+	defer headSub.Unsubscribe()
+
+	wake := make(chan struct{}, 1)
+	synced := make(chan struct{})
+	go func() {
+		defer close(synced)
+		for {
+			select {
+			case <-wake:
+				d.sync()
+			case <-d.quit:
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-headCh:
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		case <-d.quit:
+			<-synced
+			return
+		}
+	}
+}
+
+// sync notifies the reversal of the activity in the notified blocks which
+// left the canonical chain, then the activity in the canonical blocks from
+// the cursor up to the confirmed head.
+func (d *Dispatcher) sync() {
+	if !d.revert() {
+		return
+	}
+	head := d.chain.CurrentBlock().NumberU64()
+	if head < d.confirmations {
+		return
+	}
+	for number := d.cursor + 1; number <= head-d.confirmations; number++ {
+		block := d.chain.GetBlockByNumber(number)
+		// the chain reorganised meanwhile, the next sync reverts
+		if block == nil || block.ParentHash() != d.cursorHash {
+			return
+		}
+		for _, n := range d.Notifications(block) {
+			if !d.enqueue(n) {
+				return
+			}
+		}
+		d.cursor, d.cursorHash = number, block.Hash()
+	}
+}
+
+// revert moves the cursor back to the common ancestor of the last notified
+// block and the canonical chain, notifying the reversal of the activity in
+// the blocks in between. It reports false if the dispatcher stopped.
+func (d *Dispatcher) revert() bool {
+	for depth := 0; ; depth++ {
+		canonical := d.chain.GetBlockByNumber(d.cursor)
+		if canonical != nil && canonical.Hash() == d.cursorHash {
+			return true
+		}
+		var block *types.Block
+		if depth < maxRevertBlocks {
+			block = d.chain.GetBlock(d.cursorHash, d.cursor)
+		}
+		if block == nil || d.cursor == 0 {
+			// the notified blocks can't be followed back, continue from
+			// the canonical chain
+			log.Error("Webhook notified blocks not found, skipping their reversal", "number", d.cursor, "hash", d.cursorHash)
+			if canonical == nil {
+				return true
+			}
+			d.cursorHash = canonical.Hash()
+			return true
+		}
+		for _, n := range d.Notifications(block) {
+			n.ID += "-reverted"
+			n.Reverted = true
+			if !d.enqueue(n) {
+				return false
+			}
+		}
+		d.cursor, d.cursorHash = d.cursor-1, block.ParentHash()
+	}
+}
+
+// Notifications returns the notifications of the activity of the watched
+// accounts in the block.
+func (d *Dispatcher) Notifications(block *types.Block) []*Notification {
+	receipts := d.chain.GetReceiptsByHash(block.Hash())
+	itxs := rawdb.ReadInternalTxs(d.db, block.Hash(), block.NumberU64())
+	trace := types.NewBlockTrace(block, receipts, itxs)
+
+	var notifications []*Notification
+	add := func(tx *types.TxTrace, at *types.ActionTrace, n *Notification) {
+		n.Kind = Transfer
+		if n.PubKey != nil {
+			n.Kind = KeyChange
+		}
+		n.ID = fmt.Sprintf("%x-%d-%d", tx.Hash, at.Index, len(notifications))
+		n.BlockNumber, n.BlockHash = block.NumberU64(), block.Hash()
+		n.TxHash, n.ActionIndex = tx.Hash, at.Index
+		notifications = append(notifications, n)
+	}
+	for i, tx := range trace.Txs {
+		actions := block.Transactions()[i].GetActions()
+		for _, at := range tx.Actions {
+			if at.Status != types.ReceiptStatusSuccessful {
+				continue
+			}
+			actionType := types.ActionType(at.ActionType)
+			if d.accounts[at.To] && actionType.MovesValue() && at.Value.Sign() > 0 {
+				add(tx, at, &Notification{Account: at.To, From: at.From, AssetID: at.AssetID, Amount: at.Value})
+			}
+			for _, itx := range at.InternalTxs {
+				if itx.Error == "" && d.accounts[itx.To] && itx.Value != nil && itx.Value.Sign() > 0 {
+					add(tx, at, &Notification{Account: itx.To, From: itx.From, AssetID: itx.AssetID, Amount: itx.Value, Internal: true})
+				}
+			}
+			if actionType == types.UpdateAccount && d.accounts[at.From] {
+				var key common.PubKey
+				key.SetBytes(actions[at.Index].Data())
+				add(tx, at, &Notification{Account: at.From, PubKey: &key})
+			}
+		}
+	}
+	return notifications
+}
+
+// enqueue queues the notification on every endpoint, waiting for room in
+// the full queues. It reports false if the dispatcher stopped meanwhile.
+func (d *Dispatcher) enqueue(n *Notification) bool {
+	for _, ep := range d.endpoints {
+		select {
+		case ep.queue <- n:
+		case <-d.quit:
+			return false
+		}
+	}
+	return true
+}
+
+func (d *Dispatcher) deliver(ep *endpoint) {
+	for {
+		select {
+		case n := <-ep.queue:
+			body, err := json.Marshal(n)
+			if err != nil {
+				log.Error("Failed to encode webhook notification", "id", n.ID, "err", err)
+				continue
+			}
+			delay := d.retryDelay
+			for attempt := 0; ; attempt++ {
+				err := d.post(ep.url, n, body)
+				if err == nil {
+					break
+				}
+				if attempt >= d.retries {
+					log.Warn("Dropping undelivered webhook notification", "url", ep.url, "id", n.ID, "err", err)
+					break
+				}
+				log.Debug("Retrying webhook notification", "url", ep.url, "id", n.ID, "delay", delay, "err", err)
+				select {
+				case <-time.After(delay):
+				case <-d.quit:
+					return
+				}
+				if delay *= 2; delay > maxRetryDelay {
+					delay = maxRetryDelay
+				}
+			}
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// post POSTs the body of the notification, failing unless the endpoint
+// answers with a 2xx status.
+func (d *Dispatcher) post(url string, n *Notification, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Reverted {
+		req.Header.Set(EventHeader, Reverted)
+	} else {
+		req.Header.Set(EventHeader, n.Kind)
+	}
+	req.Header.Set(DeliveryHeader, n.ID)
+	if len(d.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(d.secret, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
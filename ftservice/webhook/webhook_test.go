@@ -0,0 +1,237 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+type testChain struct {
+	blocks   []*types.Block // canonical blocks
+	all      map[common.Hash]*types.Block
+	receipts map[common.Hash][]*types.Receipt
+}
+
+func (c *testChain) CurrentBlock() *types.Block { return c.blocks[len(c.blocks)-1] }
+
+func (c *testChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	if block := c.all[hash]; block != nil && block.NumberU64() == number {
+		return block
+	}
+	return nil
+}
+
+// setCanonical makes the blocks the canonical chain.
+func (c *testChain) setCanonical(blocks ...*types.Block) {
+	c.blocks = blocks
+	for _, block := range blocks {
+		c.all[block.Hash()] = block
+	}
+}
+
+func (c *testChain) GetBlockByNumber(number uint64) *types.Block {
+	if number < uint64(len(c.blocks)) {
+		return c.blocks[number]
+	}
+	return nil
+}
+
+func (c *testChain) GetReceiptsByHash(hash common.Hash) []*types.Receipt { return c.receipts[hash] }
+func (c *testChain) Router() *event.Router                               { return nil }
+
+// newTestChain returns a chain whose block 1 transfers to the watched
+// account, to another account, and changes the key of the watched account.
+func newTestChain() *testChain {
+	genesis := types.NewBlockWithHeader(&types.Header{Number: new(big.Int), Time: new(big.Int), Difficulty: new(big.Int)})
+	key := common.HexToPubKey("047db227d7094ce215c3a0f57e1bcc732551fe351f94249471934567e0f5dc1bf795962b8cccb87a2eb56b29fbe37d614e2f4c3c45b789ae4f1f51f4cb21972ffd")
+	txs := []*types.Transaction{
+		types.NewTransaction(0, big.NewInt(1),
+			types.NewAction(types.Transfer, "senderacct", "custodian", 0, 1, 21000, big.NewInt(5), nil),
+			types.NewAction(types.Transfer, "senderacct", "otheracct", 1, 1, 21000, big.NewInt(6), nil),
+		),
+		types.NewTransaction(0, big.NewInt(1),
+			types.NewAction(types.Transfer, "senderacct", "custodian", 2, 1, 21000, big.NewInt(7), nil),
+		),
+		types.NewTransaction(0, big.NewInt(1),
+			types.NewAction(types.UpdateAccount, "custodian", "", 0, 0, 21000, new(big.Int), key.Bytes()),
+		),
+	}
+	var receipts []*types.Receipt
+	for i, tx := range txs {
+		receipt := types.NewReceipt(nil, 0, 0)
+		for j := range tx.GetActions() {
+			status := types.ReceiptStatusSuccessful
+			if i == 1 {
+				status = types.ReceiptStatusFailed
+			}
+			receipt.ActionResults = append(receipt.ActionResults, &types.ActionResult{Status: status, Index: uint64(j)})
+		}
+		receipts = append(receipts, receipt)
+	}
+	block := types.NewBlock(&types.Header{ParentHash: genesis.Hash(), Number: big.NewInt(1), Time: new(big.Int), Difficulty: new(big.Int)}, txs, receipts)
+	chain := &testChain{
+		all:      make(map[common.Hash]*types.Block),
+		receipts: map[common.Hash][]*types.Receipt{block.Hash(): receipts},
+	}
+	chain.setCanonical(genesis, block)
+	return chain
+}
+
+func TestNotifications(t *testing.T) {
+	chain := newTestChain()
+	d, err := New(&Config{URLs: []string{"http://localhost"}, Accounts: []string{"custodian"}}, chain, fdb.NewMemDatabase())
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications := d.Notifications(chain.blocks[1])
+	if len(notifications) != 2 {
+		t.Fatalf("%d notifications, want 2", len(notifications))
+	}
+	if n := notifications[0]; n.Kind != Transfer || n.Account != "custodian" || n.From != "senderacct" || n.Amount.Int64() != 5 {
+		t.Fatalf("transfer notification mismatch: %+v", n)
+	}
+	if n := notifications[1]; n.Kind != KeyChange || n.Account != "custodian" || n.PubKey == nil || n.TxHash != chain.blocks[1].Transactions()[2].Hash() {
+		t.Fatalf("key change notification mismatch: %+v", n)
+	}
+	if notifications[0].ID == notifications[1].ID {
+		t.Fatal("notifications share an id")
+	}
+}
+
+func TestReorgNotifications(t *testing.T) {
+	chain := newTestChain()
+	genesis, notified := chain.blocks[0], chain.blocks[1]
+	chain.setCanonical(genesis)
+	d, err := New(&Config{URLs: []string{"http://localhost"}, Accounts: []string{"custodian"}}, chain, fdb.NewMemDatabase())
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := func() (ids []string) {
+		for {
+			select {
+			case n := <-d.endpoints[0].queue:
+				if n.Reverted != strings.HasSuffix(n.ID, "-reverted") {
+					t.Fatalf("reverted notification mismatch: %+v", n)
+				}
+				ids = append(ids, n.ID)
+			default:
+				return ids
+			}
+		}
+	}
+	chain.setCanonical(genesis, notified)
+	d.sync()
+	want := queued()
+	if len(want) != 2 {
+		t.Fatalf("%d notifications, want 2", len(want))
+	}
+
+	// The notifications of the block leaving the canonical chain are
+	// reverted before the new blocks are notified.
+	empty := func(parent *types.Block, time int64) *types.Block {
+		return types.NewBlockWithHeader(&types.Header{ParentHash: parent.Hash(), Number: new(big.Int).Add(parent.Number(), big.NewInt(1)), Time: big.NewInt(time), Difficulty: new(big.Int)})
+	}
+	fork := empty(genesis, 1)
+	chain.setCanonical(genesis, fork, empty(fork, 2))
+	d.sync()
+	ids := queued()
+	if len(ids) != len(want) {
+		t.Fatalf("%d notifications after the reorganisation, want %d reverted", len(ids), len(want))
+	}
+	for i, id := range ids {
+		if id != want[i]+"-reverted" {
+			t.Fatalf("notification %d id %s, want %s", i, id, want[i]+"-reverted")
+		}
+	}
+	if d.cursor != 2 || d.cursorHash != chain.CurrentBlock().Hash() {
+		t.Fatalf("cursor %d %x, want the head", d.cursor, d.cursorHash)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		received = make(chan *Notification, 1)
+	)
+	secret := []byte("secret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		fail := attempts < 3
+		mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if sig := r.Header.Get(SignatureHeader); sig != Sign(secret, body) {
+			t.Errorf("signature mismatch: have %s, want %s", sig, Sign(secret, body))
+		}
+		n := new(Notification)
+		if err := json.Unmarshal(body, n); err != nil {
+			t.Error(err)
+		}
+		if r.Header.Get(DeliveryHeader) != n.ID || r.Header.Get(EventHeader) != n.Kind {
+			t.Errorf("headers mismatch: %v", r.Header)
+		}
+		received <- n
+	}))
+	defer server.Close()
+
+	chain := newTestChain()
+	d, err := New(&Config{
+		URLs:       []string{server.URL},
+		Secret:     string(secret),
+		Accounts:   []string{"custodian"},
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	}, chain, fdb.NewMemDatabase())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go d.deliver(d.endpoints[0])
+	defer close(d.quit)
+
+	d.enqueue(d.Notifications(chain.blocks[1])[0])
+	select {
+	case n := <-received:
+		if n.Amount.Int64() != 5 {
+			t.Fatalf("delivered amount %v, want 5", n.Amount)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification not delivered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Fatalf("%d attempts, want 3", attempts)
+	}
+}
@@ -24,7 +24,12 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/blockchain"
 	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/types"
 )
 
 type AccountAPI struct {
@@ -37,6 +42,7 @@ func NewAccountAPI(b Backend) *AccountAPI {
 
 var (
 	ErrGetAccounManagerErr = errors.New("get account manager failure")
+	ErrAssetStatsNotFound  = errors.New("asset statistics not found")
 )
 
 //AccountIsExist
@@ -63,6 +69,21 @@ func (aapi *AccountAPI) GetAccountByName(ctx context.Context, accountName common
 	return am.GetAccountByName(accountName)
 }
 
+// CanSign evaluates whether signatures of the public keys satisfy the
+// authority of the account for the action type, so that wallets can check
+// the keys before assembling a transaction.
+func (aapi *AccountAPI) CanSign(ctx context.Context, accountName common.Name, actionType types.ActionType, pubKeys []common.PubKey) (*accountmanager.SignCheck, error) {
+	am, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if am == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	signCheck, err := am.CanSign(accountName, actionType, pubKeys)
+	return signCheck, toRPCError(err, &accountErrorData{Account: accountName})
+}
+
 //GetAccountBalanceByID
 func (aapi *AccountAPI) GetAccountBalanceByID(ctx context.Context, accountName common.Name, assetID uint64) (*big.Int, error) {
 	am, err := aapi.b.GetAccountManager()
@@ -72,7 +93,8 @@ func (aapi *AccountAPI) GetAccountBalanceByID(ctx context.Context, accountName c
 	if am == nil {
 		return nil, ErrGetAccounManagerErr
 	}
-	return am.GetAccountBalanceByID(accountName, assetID)
+	balance, err := am.GetAccountBalanceByID(accountName, assetID)
+	return balance, toRPCError(err, &accountErrorData{Account: accountName})
 }
 
 //func (aapi *AccountAPI) GetAccountBalanceByName(ctx context.Context, accountName common.Name, assetName string) (*big.Int, error) {
@@ -111,7 +133,7 @@ func (aapi *AccountAPI) GetCode(ctx context.Context, accountName common.Name) (h
 
 	result, err := acct.GetCode(accountName)
 	if err != nil {
-		return nil, err
+		return nil, toRPCError(err, &accountErrorData{Account: accountName})
 	}
 	return (hexutil.Bytes)(result), nil
 
@@ -126,7 +148,8 @@ func (aapi *AccountAPI) GetNonce(ctx context.Context, accountName common.Name) (
 	if acct == nil {
 		return 0, ErrGetAccounManagerErr
 	}
-	return acct.GetNonce(accountName)
+	nonce, err := acct.GetNonce(accountName)
+	return nonce, toRPCError(err, &accountErrorData{Account: accountName})
 
 }
 
@@ -153,3 +176,208 @@ func (aapi *AccountAPI) GetAssetInfoByID(ctx context.Context, assetID uint64) (*
 	}
 	return acct.GetAssetInfoByID(assetID)
 }
+
+// GetNameRecords returns the records published under the account name.
+func (aapi *AccountAPI) GetNameRecords(ctx context.Context, accountName common.Name) ([]*accountmanager.NameRecord, error) {
+	acct, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	return acct.GetNameRecords(accountName)
+}
+
+// GetNameRecord resolves the account name to its record of the type, such as
+// its payment address on another chain, nil if there is none.
+func (aapi *AccountAPI) GetNameRecord(ctx context.Context, accountName common.Name, recordType string) (*accountmanager.NameRecord, error) {
+	acct, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	return acct.GetNameRecord(accountName, recordType)
+}
+
+// AssetStats are the statistics of an asset at the block they were last
+// updated, with the transfers of the day before that block.
+type AssetStats struct {
+	AssetID      uint64   `json:"assetID"`
+	Supply       *big.Int `json:"supply"`
+	Holders      uint64   `json:"holders"`
+	Transfers    uint64   `json:"transfers"`
+	Volume       *big.Int `json:"volume"`
+	Transfers24h uint64   `json:"transfers24h"`
+	Volume24h    *big.Int `json:"volume24h"`
+	BlockNumber  uint64   `json:"blockNumber"`
+}
+
+// GetAssetStats returns the circulating supply, holder count and transfer
+// volume of an asset indexed by the node.
+func (aapi *AccountAPI) GetAssetStats(ctx context.Context, assetID uint64) (*AssetStats, error) {
+	db := aapi.b.ChainDb()
+	stats := rawdb.ReadAssetStats(db, assetID)
+	if stats == nil {
+		return nil, ErrAssetStatsNotFound
+	}
+	result := &AssetStats{
+		AssetID:   stats.AssetID,
+		Supply:    stats.Supply,
+		Holders:   stats.Holders,
+		Transfers: stats.Transfers,
+		Volume:    stats.Volume,
+	}
+	hash := rawdb.ReadAssetStatsHead(db)
+	hour := types.TimeHour(aapi.b.CurrentBlock().Time())
+	if number := rawdb.ReadHeaderNumber(db, hash); number != nil {
+		result.BlockNumber = *number
+		if header := rawdb.ReadHeader(db, hash, *number); header != nil {
+			hour = types.TimeHour(header.Time)
+		}
+	}
+	result.Transfers24h, result.Volume24h = stats.LastDay(hour)
+	return result, nil
+}
+
+// GetFeeRate returns the exchange rate at which the asset pays fees, nil if
+// the asset isn't whitelisted to pay fees.
+func (aapi *AccountAPI) GetFeeRate(ctx context.Context, assetID uint64) (*accountmanager.FeeRate, error) {
+	acct, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	return acct.GetFeeRate(assetID)
+}
+
+// GetMinGasPrice returns the minimum gas price, in the fee token, of the
+// transactions carrying an action of the type.
+func (aapi *AccountAPI) GetMinGasPrice(ctx context.Context, actionType types.ActionType) (*big.Int, error) {
+	acct, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	return acct.GetMinGasPrice(actionType)
+}
+
+// GetRateLimit returns the limit of the actions an account may send in a
+// window of blocks, nil if the actions aren't limited.
+func (aapi *AccountAPI) GetRateLimit(ctx context.Context) (*accountmanager.RateLimit, error) {
+	acct, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	return acct.GetRateLimit()
+}
+
+// GetResourceUsage returns the staked amount and the gas quota of the account
+// in the window of the next block.
+func (aapi *AccountAPI) GetResourceUsage(ctx context.Context, accountName common.Name) (*accountmanager.ResourceUsage, error) {
+	acct, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	number := aapi.b.CurrentBlock().NumberU64() + 1
+	return acct.GetResourceUsage(accountName, number, aapi.b.ChainConfig().Resources)
+}
+
+// GetWithdrawal returns the withdrawal proposal of the fee pool with the
+// given id and its approvals, nil if there is none.
+func (aapi *AccountAPI) GetWithdrawal(ctx context.Context, id uint64) (*accountmanager.Withdrawal, error) {
+	acct, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	return acct.GetWithdrawal(id)
+}
+
+// GetMultisig returns the approvers of the multisig proposals of the account,
+// nil if it isn't a multisig account.
+func (aapi *AccountAPI) GetMultisig(ctx context.Context, accountName common.Name) (*accountmanager.Multisig, error) {
+	acct, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	return acct.GetMultisig(accountName)
+}
+
+// GetMultisigTx returns the proposal of the multisig account with the given
+// id and its approvals, nil if there is none.
+func (aapi *AccountAPI) GetMultisigTx(ctx context.Context, accountName common.Name, id uint64) (*accountmanager.MultisigTx, error) {
+	acct, err := aapi.b.GetAccountManager()
+	if err != nil {
+		return nil, err
+	}
+	if acct == nil {
+		return nil, ErrGetAccounManagerErr
+	}
+	return acct.GetMultisigTx(accountName, id)
+}
+
+// chainEvChanSize is the size of the channels of the subscriptions listening
+// to the blocks inserted in the canonical chain.
+const chainEvChanSize = 10
+
+// BalanceChange is a change of the balance of an asset of an account made by
+// a block of the canonical chain.
+type BalanceChange struct {
+	*types.BalanceChange
+	BlockHash   common.Hash `json:"blockHash"`
+	BlockNumber uint64      `json:"blockNumber"`
+}
+
+// BalanceChanges creates a subscription notified whenever a block inserted in
+// the canonical chain changes the balance of the asset of the account. The
+// block may still be dropped by a reorg, the clients wait for the number of
+// confirmations they need before acting on a change.
+func (aapi *AccountAPI) BalanceChanges(ctx context.Context, name common.Name, assetID uint64) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		ch := make(chan *router.Event, chainEvChanSize)
+		sub := aapi.b.Router().Subscribe(nil, ch, router.ChainEv, blockchain.ChainEvent{})
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case e := <-ch:
+				ev := e.Data.(blockchain.ChainEvent)
+				for _, change := range ev.BalanceChanges {
+					if change.Account == name && change.AssetID == assetID {
+						notifier.Notify(rpcSub.ID, &BalanceChange{change, ev.Hash, ev.Block.NumberU64()})
+					}
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
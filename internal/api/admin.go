@@ -0,0 +1,206 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/fractalplatform/fractal/blockchain"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/p2p"
+)
+
+// PrivateAdminAPI is the collection of administrative APIs exposed over
+// the private admin endpoint.
+type PrivateAdminAPI struct {
+	b Backend
+}
+
+// NewPrivateAdminAPI creates a new API definition for the private admin methods.
+func NewPrivateAdminAPI(b Backend) *PrivateAdminAPI {
+	return &PrivateAdminAPI{b}
+}
+
+// AddTrustedPeer allows a remote node to always connect, even if slots are full
+func (api *PrivateAdminAPI) AddTrustedPeer(url string) (bool, error) {
+	if err := api.b.AddTrustedPeer(url); err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	return true, nil
+}
+
+// RemoveTrustedPeer removes a remote node from the trusted peer set, but it
+// does not disconnect it automatically.
+func (api *PrivateAdminAPI) RemoveTrustedPeer(url string) (bool, error) {
+	if err := api.b.RemoveTrustedPeer(url); err != nil {
+		return false, fmt.Errorf("invalid enode: %v", err)
+	}
+	return true, nil
+}
+
+// TrustedPeers returns the enode urls of the trusted peer set
+func (api *PrivateAdminAPI) TrustedPeers() []string {
+	return api.b.TrustedPeers()
+}
+
+// BanPeer bans a remote node for the given number of seconds and drops its
+// connection. Zero seconds uses the configured ban duration.
+func (api *PrivateAdminAPI) BanPeer(url string, seconds uint64) (bool, error) {
+	if err := api.b.BanPeer(url, time.Duration(seconds)*time.Second); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// BanIP bans all connections from an IP address for the given number of
+// seconds. Zero seconds uses the configured ban duration.
+func (api *PrivateAdminAPI) BanIP(ip string, seconds uint64) (bool, error) {
+	if err := api.b.BanIP(ip, time.Duration(seconds)*time.Second); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UnbanPeer lifts the ban of a remote node.
+func (api *PrivateAdminAPI) UnbanPeer(url string) (bool, error) {
+	return api.b.UnbanPeer(url)
+}
+
+// UnbanIP lifts the ban of an IP address.
+func (api *PrivateAdminAPI) UnbanIP(ip string) (bool, error) {
+	return api.b.UnbanIP(ip)
+}
+
+// Bans returns the active entries of the peer ban list.
+func (api *PrivateAdminAPI) Bans() []*p2p.BanEntry {
+	return api.b.Bans()
+}
+
+// NodeInfo returns the public identity record of the local node.
+func (api *PrivateAdminAPI) NodeInfo() *p2p.NodeInfo {
+	return api.b.NodeInfo()
+}
+
+// SetLogLevel sets the log verbosity of the modules without a rule of their
+// own: 0=silent, 1=error, 2=warn, 3=info, 4=debug, 5=detail.
+func (api *PrivateAdminAPI) SetLogLevel(level int) (bool, error) {
+	if level < 0 || level > int(log.LvlTrace) {
+		return false, fmt.Errorf("invalid log level %d", level)
+	}
+	log.SetVerbosity(log.Lvl(level))
+	return true, nil
+}
+
+// SetLogModules replaces the per-module log verbosity rules, a comma-separated
+// list of <pattern>=<level> such as "p2p=4,blockchain=5".
+func (api *PrivateAdminAPI) SetLogModules(ruleset string) (bool, error) {
+	if err := log.SetVmodule(ruleset); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LogModules returns the per-module log verbosity rules in use.
+func (api *PrivateAdminAPI) LogModules() string {
+	return log.Vmodule()
+}
+
+// ExportSnapshot writes a snapshot of the chain at the given block number, with
+// up to blocks recent blocks, to a file of the node. Blocks aren't inserted
+// while the state is written.
+func (api *PrivateAdminAPI) ExportSnapshot(number, blocks uint64, file string) (*blockchain.SnapshotMeta, error) {
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := api.b.ExportSnapshot(number, blocks, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+	return meta, nil
+}
+
+// GenesisAllocResult is the genesis merged by ImportGenesisAlloc.
+type GenesisAllocResult struct {
+	Accounts int `json:"accounts"`
+	Assets   int `json:"assets"`
+}
+
+// ImportGenesisAlloc merges the accounts and assets of allocation files of the
+// node, CSV or JSON, into a genesis file and writes the merged genesis to out,
+// e.g. to start a network migrated from another chain. The chain of the node
+// is left alone.
+func (api *PrivateAdminAPI) ImportGenesisAlloc(genesisFile string, allocFiles []string, out string) (*GenesisAllocResult, error) {
+	data, err := ioutil.ReadFile(genesisFile)
+	if err != nil {
+		return nil, err
+	}
+	genesis := new(blockchain.Genesis)
+	if err := json.Unmarshal(data, genesis); err != nil {
+		return nil, fmt.Errorf("invalid genesis file %v: %v", genesisFile, err)
+	}
+	for _, file := range allocFiles {
+		alloc, err := blockchain.ReadGenesisAllocFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allocation %v: %v", file, err)
+		}
+		if err := genesis.Import(alloc); err != nil {
+			return nil, fmt.Errorf("invalid allocation %v: %v", file, err)
+		}
+	}
+	if err := genesis.ValidateAllocs(); err != nil {
+		return nil, err
+	}
+	if data, err = json.MarshalIndent(genesis, "", "  "); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out)
+		return nil, err
+	}
+	return &GenesisAllocResult{len(genesis.AllocAccounts), len(genesis.AllocAssets)}, nil
+}
+
+// SyncMode returns the sync mode of the node.
+func (api *PrivateAdminAPI) SyncMode() blockchain.SyncMode {
+	return api.b.SyncMode()
+}
+
+// SetSyncMode changes the sync mode of the node, taking effect with the next
+// download.
+func (api *PrivateAdminAPI) SetSyncMode(mode blockchain.SyncMode) (bool, error) {
+	if err := api.b.SetSyncMode(mode); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -19,13 +19,19 @@ package api
 
 import (
 	"context"
+	"io"
 	"math/big"
+	"time"
 
 	"github.com/fractalplatform/fractal/consensus"
 
 	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/blockchain"
 	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/p2p"
 	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/processor"
 	"github.com/fractalplatform/fractal/processor/vm"
 	"github.com/fractalplatform/fractal/rpc"
 	"github.com/fractalplatform/fractal/state"
@@ -40,6 +46,7 @@ type Backend interface {
 	// ftservice API
 	ChainDb() fdb.Database
 	ChainConfig() *params.ChainConfig
+	Router() *router.Router
 	SuggestPrice(ctx context.Context) (*big.Int, error)
 
 	// BlockChain API
@@ -50,6 +57,11 @@ type Backend interface {
 	GetBlock(ctx context.Context, blockHash common.Hash) (*types.Block, error)
 	GetReceipts(ctx context.Context, blockHash common.Hash) ([]*types.Receipt, error)
 	GetTd(blockHash common.Hash) *big.Int
+	StateAt(hash common.Hash) (*state.StateDB, error)
+	Processor() processor.Processor
+	ExportSnapshot(number, blocks uint64, w io.Writer) (*blockchain.SnapshotMeta, error)
+	SyncMode() blockchain.SyncMode
+	SetSyncMode(mode blockchain.SyncMode) error
 	GetEVM(ctx context.Context, account *accountmanager.AccountManager, state *state.StateDB, from common.Name, assetID uint64, gasPrice *big.Int, header *types.Header, vmCfg vm.Config) (*vm.EVM, func() error, error)
 
 	// TxPool API
@@ -58,6 +70,8 @@ type Backend interface {
 	GetPoolTransaction(txHash common.Hash) *types.Transaction
 	Stats() (pending int, queued int)
 	TxPoolContent() (map[common.Name][]*types.Transaction, map[common.Name][]*types.Transaction)
+	GetPoolNonce(name common.Name) (uint64, error)
+	TxConflicts(account common.Name) []*types.TxConflict
 
 	//Account API
 	GetAccountManager() (*accountmanager.AccountManager, error)
@@ -72,8 +86,17 @@ type Backend interface {
 	RemovePeer(url string) error
 	AddTrustedPeer(url string) error
 	RemoveTrustedPeer(url string) error
+	TrustedPeers() []string
 	PeerCount() int
+	PeerSlots() map[string]int
 	Peers() []string
+	DiscoveredNodes() []string
+	BanPeer(url string, d time.Duration) error
+	BanIP(ip string, d time.Duration) error
+	UnbanPeer(url string) (bool, error)
+	UnbanIP(ip string) (bool, error)
+	Bans() []*p2p.BanEntry
+	NodeInfo() *p2p.NodeInfo
 	SelfNode() string
 
 	Engine() consensus.IEngine
@@ -82,6 +105,7 @@ type Backend interface {
 }
 
 func GetAPIs(apiBackend Backend) []rpc.API {
+	nonceLock := new(AddrLocker)
 	apis := []rpc.API{
 		{
 			Namespace: "txpool",
@@ -114,6 +138,18 @@ func GetAPIs(apiBackend Backend) []rpc.API {
 			Version:   "1.0",
 			Service:   NewPrivateP2pAPI(apiBackend),
 			Public:    true,
+		}, {
+			Namespace: "admin",
+			Version:   "1.0",
+			Service:   NewPrivateAdminAPI(apiBackend),
+		}, {
+			Namespace: "personal",
+			Version:   "1.0",
+			Service:   NewPrivatePersonalAPI(apiBackend, nonceLock),
+		}, {
+			Namespace: "debug",
+			Version:   "1.0",
+			Service:   NewPrivateDebugAPI(apiBackend),
 		},
 	}
 	return append(apis, apiBackend.APIs()...)
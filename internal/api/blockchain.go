@@ -24,9 +24,9 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/processor"
 	"github.com/fractalplatform/fractal/processor/vm"
@@ -84,6 +84,85 @@ func (s *PublicBlockChainAPI) GetBlockByNumber(ctx context.Context, blockNr rpc.
 	return nil, err
 }
 
+// maxBlocksByRange caps the blocks returned by a GetBlocksByRange request.
+const maxBlocksByRange = 1000
+
+// blockRangeStream streams the blocks of a GetBlocksByRange request, reading
+// each block as it is written to the client.
+type blockRangeStream struct {
+	ctx      context.Context
+	api      *PublicBlockChainAPI
+	next, to uint64
+	fullTx   bool
+}
+
+// Next implements rpc.Stream.
+func (s *blockRangeStream) Next() (interface{}, bool) {
+	if s.next > s.to {
+		return nil, false
+	}
+	block, err := s.api.b.BlockByNumber(s.ctx, rpc.BlockNumber(s.next))
+	if err != nil || block == nil {
+		return nil, false
+	}
+	s.next++
+	return s.api.rpcOutputBlock(s.api.b.ChainConfig().ChainID, block, true, s.fullTx), true
+}
+
+// GetBlocksByRange returns the canonical blocks from start to end inclusive in
+// a single response, a negative number meaning the chain head. The blocks are
+// streamed to the client as they are read. At most maxBlocksByRange blocks
+// are returned and the range ends at the chain head, the clients continue
+// from the block after the last one returned. When fullTx is true all
+// transactions in the blocks are returned in full detail, otherwise only the
+// transaction hash is returned.
+func (s *PublicBlockChainAPI) GetBlocksByRange(ctx context.Context, start, end rpc.BlockNumber, fullTx bool) (rpc.Stream, error) {
+	head := s.b.CurrentBlock().NumberU64()
+	from, to := head, head
+	if start >= 0 {
+		from = uint64(start)
+	}
+	if end >= 0 && uint64(end) < to {
+		to = uint64(end)
+	}
+	if end >= 0 && from > uint64(end) {
+		return nil, fmt.Errorf("invalid block range %d-%d", from, end)
+	}
+	if to-from >= maxBlocksByRange {
+		to = from + maxBlocksByRange - 1
+	}
+	return &blockRangeStream{ctx: ctx, api: s, next: from, to: to, fullTx: fullTx}, nil
+}
+
+// GetOrphanBlocksAt returns the blocks stored at a number that aren't in the
+// canonical chain, siblings of the canonical block that lost the fork choice.
+// When fullTx is true all transactions in the blocks are returned in full
+// detail, otherwise only the transaction hash is returned.
+func (s *PublicBlockChainAPI) GetOrphanBlocksAt(ctx context.Context, number uint64, fullTx bool) ([]map[string]interface{}, error) {
+	db := s.b.ChainDb()
+	canon := rawdb.ReadCanonicalHash(db, number)
+	orphans := []map[string]interface{}{}
+	for _, hash := range rawdb.ReadAllHashes(db, number) {
+		if hash == canon {
+			continue
+		}
+		block, err := s.b.GetBlock(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if block != nil {
+			orphans = append(orphans, s.rpcOutputBlock(s.b.ChainConfig().ChainID, block, true, fullTx))
+		}
+	}
+	return orphans, nil
+}
+
+// GetCheckpoint returns the latest checkpoint signed by a quorum of the
+// producers, or nil if none is known.
+func (s *PublicBlockChainAPI) GetCheckpoint() *types.SignedCheckpoint {
+	return rawdb.ReadCheckpoint(s.b.ChainDb())
+}
+
 // rpcOutputBlock uses the generalized output filler, then adds the total difficulty field, which requires
 // a `PublicBlockchainAPI`.
 func (s *PublicBlockChainAPI) rpcOutputBlock(chainID *big.Int, b *types.Block, inclTx bool, fullTx bool) map[string]interface{} {
@@ -125,6 +204,137 @@ func (s *PublicBlockChainAPI) GetTransactionReceipt(ctx context.Context, hash co
 	return receipt.NewRPCReceipt(blockHash, blockNumber, index, tx), nil
 }
 
+// GetInternalTxs returns the internal transactions sent or received by an
+// account in the canonical blocks between fromBlock and toBlock inclusive.
+func (s *PublicBlockChainAPI) GetInternalTxs(ctx context.Context, name common.Name, fromBlock, toBlock rpc.BlockNumber) ([]*types.InternalTx, error) {
+	head := s.b.CurrentBlock().NumberU64()
+	from, to := head, head
+	if fromBlock >= 0 {
+		from = uint64(fromBlock)
+	}
+	if toBlock >= 0 {
+		to = uint64(toBlock)
+	}
+	if from > to {
+		return nil, fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+
+	db := s.b.ChainDb()
+	itxs := []*types.InternalTx{}
+	for _, number := range rawdb.ReadInternalTxIndex(db, name) {
+		if number < from || number > to {
+			continue
+		}
+		hash := rawdb.ReadCanonicalHash(db, number)
+		for _, itx := range rawdb.ReadInternalTxs(db, hash, number) {
+			if itx.Involves(name) {
+				itxs = append(itxs, itx)
+			}
+		}
+	}
+	return itxs, nil
+}
+
+// maxTxSearchResults caps the actions returned by a transaction search.
+const maxTxSearchResults = 1000
+
+// TxSearchArgs selects the actions of a transaction search by exactly one of
+// account, sender, recipient, asset and action type. The block range defaults
+// to the whole index.
+type TxSearchArgs struct {
+	Account    common.Name       `json:"account"`
+	Sender     common.Name       `json:"sender"`
+	Recipient  common.Name       `json:"recipient"`
+	AssetID    *uint64           `json:"assetID"`
+	ActionType *types.ActionType `json:"actionType"`
+	FromBlock  *rpc.BlockNumber  `json:"fromBlock"`
+	ToBlock    *rpc.BlockNumber  `json:"toBlock"`
+	Limit      int               `json:"limit"`
+}
+
+// TxSearchAction is an action found by a transaction search.
+type TxSearchAction struct {
+	BlockHash   common.Hash `json:"blockHash"`
+	BlockNumber uint64      `json:"blockNumber"`
+	TxHash      common.Hash `json:"txHash"`
+	TxIndex     uint32      `json:"txIndex"`
+	ActionIndex uint32      `json:"actionIndex"`
+}
+
+// TxSearchResult is a page of the actions found by a transaction search.
+type TxSearchResult struct {
+	Actions     []*TxSearchAction `json:"actions"`
+	Next        uint64            `json:"next"`        // block to continue the search from, 0 if the range is done
+	IndexedFrom uint64            `json:"indexedFrom"` // first block of the search index
+}
+
+// SearchTxs returns the actions of the canonical blocks selected by the
+// transaction search index, in block order. The page ends at the first block
+// boundary after limit actions, the search continues from the next block of
+// the result.
+func (s *PublicBlockChainAPI) SearchTxs(ctx context.Context, args TxSearchArgs) (*TxSearchResult, error) {
+	db := s.b.ChainDb()
+	tail := rawdb.ReadTxSearchTail(db)
+	if tail == nil {
+		return nil, fmt.Errorf("transaction search index disabled")
+	}
+
+	var fields []rawdb.TxSearchField
+	if args.Account != "" {
+		fields = append(fields, rawdb.TxSearchByAccount(args.Account))
+	}
+	if args.Sender != "" {
+		fields = append(fields, rawdb.TxSearchBySender(args.Sender))
+	}
+	if args.Recipient != "" {
+		fields = append(fields, rawdb.TxSearchByRecipient(args.Recipient))
+	}
+	if args.AssetID != nil {
+		fields = append(fields, rawdb.TxSearchByAsset(*args.AssetID))
+	}
+	if args.ActionType != nil {
+		fields = append(fields, rawdb.TxSearchByActionType(*args.ActionType))
+	}
+	if len(fields) != 1 {
+		return nil, fmt.Errorf("exactly one of account, sender, recipient, assetID and actionType must be set")
+	}
+
+	from, to := *tail, s.b.CurrentBlock().NumberU64()
+	if args.FromBlock != nil && *args.FromBlock >= 0 {
+		from = uint64(*args.FromBlock)
+	}
+	if args.ToBlock != nil && *args.ToBlock >= 0 {
+		to = uint64(*args.ToBlock)
+	}
+	if from > to {
+		return nil, fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+	limit := args.Limit
+	if limit <= 0 || limit > maxTxSearchResults {
+		limit = maxTxSearchResults
+	}
+
+	entries, next, err := rawdb.ReadTxSearchEntries(db, fields[0], from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	result := &TxSearchResult{Actions: []*TxSearchAction{}, Next: next, IndexedFrom: *tail}
+	for _, entry := range entries {
+		// entries of blocks dropped while the index was disabled remain
+		if rawdb.ReadCanonicalHash(db, entry.BlockNumber) != entry.BlockHash {
+			continue
+		}
+		result.Actions = append(result.Actions, &TxSearchAction{
+			BlockHash:   entry.BlockHash,
+			BlockNumber: entry.BlockNumber,
+			TxHash:      entry.TxHash,
+			TxIndex:     entry.TxIndex,
+			ActionIndex: entry.ActionIndex,
+		})
+	}
+	return result, nil
+}
+
 type CallArgs struct {
 	ActionType types.ActionType `json:"actionType"`
 	From       common.Name      `json:"from"`
@@ -179,10 +389,13 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 	// and apply the message.
 	gp := new(common.GasPool).AddGas(math.MaxUint64)
 	action := types.NewAction(args.ActionType, args.From, args.To, 0, assetID, gas, value, args.Data)
-	res, gas, failed, err, _ := processor.ApplyMessage(account, evm, action, gp, gasPrice, assetID, s.b.ChainConfig(), s.b.Engine())
+	res, gas, failed, err, vmerr := processor.ApplyMessage(account, evm, action, gp, gasPrice, assetID, s.b.ChainConfig(), s.b.Engine())
 	if err := vmError(); err != nil {
 		return nil, 0, false, err
 	}
+	if err == nil && vmerr == vm.ErrExecutionReverted {
+		err = toRPCError(vmerr, &revertErrorData{Return: res})
+	}
 	return res, gas, failed, err
 }
 
@@ -0,0 +1,181 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// GetBlockTrace returns the execution trace of the transactions of a block:
+// the results of their actions, their internal transactions, logs and the
+// balance changes they made.
+func (s *PublicBlockChainAPI) GetBlockTrace(ctx context.Context, blockNr rpc.BlockNumber) (*types.BlockTrace, error) {
+	if blockNr == rpc.PendingBlockNumber {
+		return nil, fmt.Errorf("pending block has no trace")
+	}
+	block, err := s.b.BlockByNumber(ctx, blockNr)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	return s.blockTrace(ctx, block)
+}
+
+func (s *PublicBlockChainAPI) blockTrace(ctx context.Context, block *types.Block) (*types.BlockTrace, error) {
+	receipts, err := s.b.GetReceipts(ctx, block.Hash())
+	if err != nil {
+		return nil, err
+	}
+	itxs := rawdb.ReadInternalTxs(s.b.ChainDb(), block.Hash(), block.NumberU64())
+	return types.NewBlockTrace(block, receipts, itxs), nil
+}
+
+// traceRetryInterval is the delay before a trace subscription resumes the
+// notifications refused until it was activated.
+const traceRetryInterval = 100 * time.Millisecond
+
+// BlockTraces creates a subscription notified of the execution trace of every
+// block inserted in the canonical chain, starting with the canonical blocks
+// from fromBlock on, or with the next block if fromBlock is latest. The traces
+// of the blocks dropped by a reorganisation are notified again with removed
+// set, from the old head down, before the traces of the new canonical blocks.
+func (s *PublicBlockChainAPI) BlockTraces(ctx context.Context, fromBlock rpc.BlockNumber) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	head := s.b.CurrentBlock()
+	cursor := &traceCursor{next: head.NumberU64() + 1, last: head.Hash()}
+	if fromBlock >= 0 {
+		if uint64(fromBlock) > cursor.next {
+			return nil, fmt.Errorf("block %d is ahead of the head %d", fromBlock, head.NumberU64())
+		}
+		cursor.next, cursor.last = uint64(fromBlock), common.Hash{}
+		if cursor.next > 0 {
+			cursor.last = rawdb.ReadCanonicalHash(s.b.ChainDb(), cursor.next-1)
+		}
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		ch := make(chan *router.Event, chainEvChanSize)
+		sub := s.b.Router().Subscribe(nil, ch, router.ChainHeadEv, &types.Block{})
+		defer sub.Unsubscribe()
+
+		stop := func() bool {
+			select {
+			case <-rpcSub.Err():
+				return true
+			case <-notifier.Closed():
+				return true
+			default:
+				return false
+			}
+		}
+		full := false
+		notify := func(trace *types.BlockTrace) bool {
+			if stop() {
+				return false
+			}
+			err := notifier.Notify(rpcSub.ID, trace)
+			full = err == rpc.ErrNotificationBufferFull
+			return err == nil
+		}
+		for {
+			if err := s.traceToHead(cursor, notify); err != nil {
+				log.Warn("Block trace subscription failed", "next", cursor.next, "err", err)
+				return
+			}
+			// Resume the backfill refused before the activation
+			var retry <-chan time.Time
+			if full {
+				retry = time.After(traceRetryInterval)
+			}
+			select {
+			case <-ch:
+			case <-retry:
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
+// traceCursor is the position of a trace subscription: the number of the next
+// block to notify and the hash of the last block notified.
+type traceCursor struct {
+	next uint64
+	last common.Hash
+}
+
+// traceToHead notifies the traces of the blocks dropped from the canonical
+// chain since the last notified block, then the traces of the canonical blocks
+// up to the head.
+func (s *PublicBlockChainAPI) traceToHead(cursor *traceCursor, notify func(*types.BlockTrace) bool) error {
+	ctx := context.Background()
+	db := s.b.ChainDb()
+	head := s.b.CurrentBlock().NumberU64()
+	for cursor.next > 0 && (cursor.next-1 > head || rawdb.ReadCanonicalHash(db, cursor.next-1) != cursor.last) {
+		block, err := s.b.GetBlock(ctx, cursor.last)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			return fmt.Errorf("dropped block %x not found", cursor.last)
+		}
+		trace, err := s.blockTrace(ctx, block)
+		if err != nil {
+			return err
+		}
+		trace.Removed = true
+		if !notify(trace) {
+			return nil
+		}
+		cursor.next, cursor.last = block.NumberU64(), block.ParentHash()
+	}
+	for ; cursor.next <= head; cursor.next++ {
+		block, err := s.b.BlockByNumber(ctx, rpc.BlockNumber(cursor.next))
+		if err != nil {
+			return err
+		}
+		if block == nil || block.ParentHash() != cursor.last {
+			// The chain was reorganised meanwhile, unwind on the next head
+			return nil
+		}
+		trace, err := s.blockTrace(ctx, block)
+		if err != nil {
+			return err
+		}
+		if !notify(trace) {
+			return nil
+		}
+		cursor.last = block.Hash()
+	}
+	return nil
+}
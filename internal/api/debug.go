@@ -0,0 +1,201 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// TraceConfig holds the options of a transaction trace.
+type TraceConfig struct {
+	*vm.LogConfig
+	// Tracer selects a built-in tracer, "callTracer" or "stateDiffTracer".
+	// The structured opcode logger is used if it is empty.
+	Tracer string `json:"tracer"`
+}
+
+// StructLogResult is the result of a trace by the structured opcode logger.
+type StructLogResult struct {
+	Failed      bool           `json:"failed"`
+	ReturnValue hexutil.Bytes  `json:"returnValue"`
+	StructLogs  []vm.StructLog `json:"structLogs"`
+}
+
+// PrivateDebugAPI is the collection of debugging APIs exposed over the
+// private debug endpoint.
+type PrivateDebugAPI struct {
+	b Backend
+}
+
+// NewPrivateDebugAPI creates a new API definition for the private debug methods.
+func NewPrivateDebugAPI(b Backend) *PrivateDebugAPI {
+	return &PrivateDebugAPI{b}
+}
+
+// TraceTransaction re-executes the transaction in the state it was included
+// in and returns the trace collected by the configured tracer.
+func (api *PrivateDebugAPI) TraceTransaction(ctx context.Context, hash common.Hash, config *TraceConfig) (interface{}, error) {
+	tx, blockHash, _, index := rawdb.ReadTransaction(api.b.ChainDb(), hash)
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %x not found", hash)
+	}
+	block, err := api.b.GetBlock(ctx, blockHash)
+	if block == nil || err != nil {
+		return nil, fmt.Errorf("block %x not found", blockHash)
+	}
+	statedb, err := api.b.StateAt(block.ParentHash())
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		tracer vm.Tracer
+		logger *vm.StructLogger
+	)
+	if config == nil {
+		config = &TraceConfig{}
+	}
+	switch config.Tracer {
+	case "":
+		logger = vm.NewStructLogger(config.LogConfig)
+		tracer = logger
+	case "callTracer":
+		tracer = vm.NewCallTracer()
+	case "stateDiffTracer":
+		tracer = vm.NewStateDiffTracer()
+	default:
+		return nil, fmt.Errorf("unknown tracer %q", config.Tracer)
+	}
+
+	var (
+		processor = api.b.Processor()
+		header    = block.Header()
+		gp        = new(common.GasPool).AddGas(block.GasLimit())
+		usedGas   = new(uint64)
+	)
+	for i, btx := range block.Transactions() {
+		cfg := vm.Config{}
+		if uint64(i) == index {
+			cfg = vm.Config{Debug: true, Tracer: tracer}
+		}
+		statedb.Prepare(btx.Hash(), blockHash, i)
+		receipt, _, err := processor.ApplyTransaction(nil, gp, statedb, header, btx, usedGas, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(i) < index {
+			continue
+		}
+		switch t := tracer.(type) {
+		case *vm.CallTracer:
+			return t.Result(), nil
+		case *vm.StateDiffTracer:
+			return t.Result(), nil
+		}
+		failed := false
+		for _, result := range receipt.ActionResults {
+			failed = failed || result.Status == types.ReceiptStatusFailed
+		}
+		return &StructLogResult{
+			Failed:      failed,
+			ReturnValue: logger.Output(),
+			StructLogs:  logger.StructLogs(),
+		}, nil
+	}
+	return nil, fmt.Errorf("transaction %x not found in block %x", hash, blockHash)
+}
+
+// AccountRootResult is the account root of the state at a block.
+type AccountRootResult struct {
+	Number   uint64      `json:"number"`
+	Hash     common.Hash `json:"hash"`
+	Root     common.Hash `json:"root"`
+	Accounts int         `json:"accounts"`
+}
+
+// AccountRootCompareResult compares the account roots of two nodes.
+type AccountRootCompareResult struct {
+	Local  *AccountRootResult `json:"local"`
+	Remote *AccountRootResult `json:"remote"`
+	Match  bool               `json:"match"`
+}
+
+// AccountRoot returns the deterministic hash over all the accounts in the
+// state after the block, to compare with other nodes.
+func (api *PrivateDebugAPI) AccountRoot(ctx context.Context, blockNr rpc.BlockNumber) (*AccountRootResult, error) {
+	header, err := api.b.HeaderByNumber(ctx, blockNr)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, fmt.Errorf("block %d not found", blockNr)
+	}
+	statedb, err := api.b.StateAt(header.Hash())
+	if err != nil {
+		return nil, err
+	}
+	am, err := accountmanager.NewAccountManager(statedb)
+	if err != nil {
+		return nil, err
+	}
+	root, count, err := am.AccountRoot()
+	if err != nil {
+		return nil, err
+	}
+	return &AccountRootResult{
+		Number:   header.Number.Uint64(),
+		Hash:     header.Hash(),
+		Root:     root,
+		Accounts: count,
+	}, nil
+}
+
+// CompareAccountRoot compares the account root at the block with the one of
+// the node at the url. Both nodes must have the same block at the height for
+// the roots to be comparable.
+func (api *PrivateDebugAPI) CompareAccountRoot(ctx context.Context, blockNr rpc.BlockNumber, url string) (*AccountRootCompareResult, error) {
+	local, err := api.AccountRoot(ctx, blockNr)
+	if err != nil {
+		return nil, err
+	}
+	client, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	remote := new(AccountRootResult)
+	if err := client.CallContext(ctx, remote, "debug_accountRoot", rpc.BlockNumber(local.Number)); err != nil {
+		return nil, err
+	}
+	if remote.Hash != local.Hash {
+		return nil, fmt.Errorf("block %d mismatch: local %x, remote %x", local.Number, local.Hash, remote.Hash)
+	}
+	return &AccountRootCompareResult{
+		Local:  local,
+		Remote: remote,
+		Match:  local.Root == remote.Root,
+	}, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/txpool"
+)
+
+// Error codes of the JSON-RPC errors returned by the API. The codes are part
+// of the API, clients branch on them instead of the error messages, so they
+// must never change.
+const (
+	ErrCodeExecutionReverted = 3      // contract execution reverted, data holds the return data
+	ErrCodeNonceTooLow       = -32010 // nonce lower than the one of the account
+	ErrCodeInsufficientFee   = -32011 // gas price, gas asset or balance too low to pay the fee
+	ErrCodeUnknownAccount    = -32012 // account does not exist
+)
+
+// errorCodes maps the errors of the handlers to their error codes.
+var errorCodes = map[error]int{
+	vm.ErrExecutionReverted:           ErrCodeExecutionReverted,
+	txpool.ErrNonceTooLow:             ErrCodeNonceTooLow,
+	txpool.ErrUnderpriced:             ErrCodeInsufficientFee,
+	txpool.ErrReplaceUnderpriced:      ErrCodeInsufficientFee,
+	txpool.ErrInsufficientFundsForGas: ErrCodeInsufficientFee,
+	txpool.ErrInvalidGasAsset:         ErrCodeInsufficientFee,
+	accountmanager.ErrAccountNotExist: ErrCodeUnknownAccount,
+}
+
+// rpcError is an error returned by the API with a stable error code and
+// structured data.
+type rpcError struct {
+	err  error
+	code int
+	data interface{}
+}
+
+func (e *rpcError) Error() string          { return e.err.Error() }
+func (e *rpcError) ErrorCode() int         { return e.code }
+func (e *rpcError) ErrorData() interface{} { return e.data }
+
+// toRPCError attaches the error code of err and data to it. Errors without
+// an error code are returned unchanged.
+func toRPCError(err error, data interface{}) error {
+	code, ok := errorCodes[err]
+	if !ok {
+		return err
+	}
+	return &rpcError{err: err, code: code, data: data}
+}
+
+// accountErrorData is the data of errors about an account.
+type accountErrorData struct {
+	Account common.Name `json:"account"`
+}
+
+// revertErrorData is the data of errors of reverted executions.
+type revertErrorData struct {
+	Return hexutil.Bytes `json:"return"`
+}
+
+// txErrorData is the data of errors about a transaction.
+type txErrorData struct {
+	Hash common.Hash `json:"hash"`
+}
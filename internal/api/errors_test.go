@@ -0,0 +1,69 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+
+package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/txpool"
+)
+
+func TestToRPCError(t *testing.T) {
+	tests := []struct {
+		err  error
+		data interface{}
+		code int
+	}{
+		{vm.ErrExecutionReverted, &revertErrorData{Return: []byte{1}}, ErrCodeExecutionReverted},
+		{txpool.ErrNonceTooLow, &txErrorData{Hash: common.Hash{1}}, ErrCodeNonceTooLow},
+		{txpool.ErrUnderpriced, &txErrorData{Hash: common.Hash{2}}, ErrCodeInsufficientFee},
+		{txpool.ErrReplaceUnderpriced, &txErrorData{Hash: common.Hash{3}}, ErrCodeInsufficientFee},
+		{txpool.ErrInsufficientFundsForGas, &txErrorData{Hash: common.Hash{4}}, ErrCodeInsufficientFee},
+		{txpool.ErrInvalidGasAsset, &txErrorData{Hash: common.Hash{5}}, ErrCodeInsufficientFee},
+		{accountmanager.ErrAccountNotExist, &accountErrorData{Account: "testaccount"}, ErrCodeUnknownAccount},
+	}
+	for _, test := range tests {
+		err := toRPCError(test.err, test.data)
+		coded, ok := err.(rpc.Error)
+		if !ok || coded.ErrorCode() != test.code {
+			t.Errorf("%v: error code mismatch: have %v, want %d", test.err, err, test.code)
+			continue
+		}
+		withData, ok := err.(rpc.DataError)
+		if !ok || withData.ErrorData() != test.data {
+			t.Errorf("%v: error data mismatch", test.err)
+		}
+		if err.Error() != test.err.Error() {
+			t.Errorf("%v: error message changed to %q", test.err, err.Error())
+		}
+	}
+
+	// Errors without a code are returned as they are.
+	plain := errors.New("plain")
+	if err := toRPCError(plain, &txErrorData{}); err != plain {
+		t.Errorf("uncoded error wrapped: %v", err)
+	}
+	if err := toRPCError(nil, &txErrorData{}); err != nil {
+		t.Errorf("nil error wrapped: %v", err)
+	}
+}
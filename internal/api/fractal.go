@@ -18,12 +18,10 @@ package api
 
 import (
 	"context"
-	"errors"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/fractalplatform/fractal/common"
-	"github.com/fractalplatform/fractal/crypto"
 	"github.com/fractalplatform/fractal/types"
 	"github.com/fractalplatform/fractal/utils/rlp"
 )
@@ -54,48 +52,34 @@ func (s *PublicFractalAPI) SendRawTransaction(ctx context.Context, encodedTx hex
 }
 
 type SendArgs struct {
-	ChainID    *big.Int         `json:"chainID"`
-	ActionType types.ActionType `json:"actionType"`
-	GasAssetID uint64           `json:"gasAssetId"`
-	From       common.Name      `json:"from"`
-	To         common.Name      `json:"to"`
-	Nonce      uint64           `json:"nonce"`
-	AssetID    uint64           `json:"assetId"`
-	Gas        uint64           `json:"gas"`
-	GasPrice   *big.Int         `json:"gasPrice"`
-	Value      *big.Int         `json:"value"`
-	Data       hexutil.Bytes    `json:"data"`
-	Passphrase string           `json:"password"`
+	ChainID    *big.Int                 `json:"chainID"`
+	ActionType types.ActionType         `json:"actionType"`
+	GasAssetID uint64                   `json:"gasAssetId"`
+	From       common.Name              `json:"from"`
+	To         common.Name              `json:"to"`
+	Nonce      uint64                   `json:"nonce"`
+	AssetID    uint64                   `json:"assetId"`
+	Gas        uint64                   `json:"gas"`
+	GasPrice   *big.Int                 `json:"gasPrice"`
+	Value      *big.Int                 `json:"value"`
+	Data       hexutil.Bytes            `json:"data"`
+	Passphrase string                   `json:"password"`
+	Extensions []*types.ActionExtension `json:"extensions,omitempty"`
 }
 
 func (s *PublicFractalAPI) SendTransaction(ctx context.Context, args SendArgs) (common.Hash, error) {
-	acct, err := s.b.GetAccountManager()
+	cacheAcct, err := walletAccount(s.b, args.From)
 	if err != nil {
 		return common.Hash{}, err
 	}
-	if acct == nil {
-		return common.Hash{}, ErrGetAccounManagerErr
-	}
-	fromAcct, err := acct.GetAccountByName(args.From)
-	if err != nil {
-		return common.Hash{}, err
-	}
-	if fromAcct == nil {
-		return common.Hash{}, errors.New("invalid user")
-	}
 
-	pubByte, _ := crypto.UnmarshalPubkey(fromAcct.PublicKey.Bytes())
-	if !s.b.Wallet().HasAddress(crypto.PubkeyToAddress(*pubByte)) {
-		return common.Hash{}, errors.New("user not in local wallet")
+	if args.GasAssetID == 0 {
+		args.GasAssetID = s.b.ChainConfig().FeeTokenID
 	}
-	cacheAcct, err := s.b.Wallet().Find(crypto.PubkeyToAddress(*pubByte))
-	if err != nil {
-		return common.Hash{}, err
-	}
-
 	assetID := uint64(args.AssetID)
 	gas := uint64(args.Gas)
 	action := types.NewAction(args.ActionType, args.From, args.To, args.Nonce, assetID, gas, args.Value, args.Data)
+	action.SetExtensions(args.Extensions...)
 	tx := types.NewTransaction(args.GasAssetID, args.GasPrice, action)
 
 	tx, err = s.b.Wallet().SignTxWithPassphrase(cacheAcct, args.Passphrase, tx, action, args.ChainID)
@@ -18,12 +18,15 @@ package api
 
 import (
 	"context"
+	"time"
+
 	"github.com/ethereum/go-ethereum/common/hexutil"
 
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/crypto"
 	"github.com/fractalplatform/fractal/types"
 	"github.com/fractalplatform/fractal/utils/rlp"
+	"github.com/fractalplatform/fractal/wallet/keystore"
 )
 
 type PrivateKeyStoreAPI struct {
@@ -107,8 +110,9 @@ func (api *PrivateKeyStoreAPI) ListAccount(ctx context.Context) ([]map[string]in
 	ret := make([]map[string]interface{}, 0)
 	for _, account := range accounts {
 		tmpa := map[string]interface{}{
-			"address": account.Addr,
-			"path":    account.Path,
+			"address":  account.Addr,
+			"path":     account.Path,
+			"unlocked": api.b.Wallet().IsUnlocked(account.Addr),
 		}
 		ret = append(ret, tmpa)
 	}
@@ -134,7 +138,7 @@ func (api *PrivateKeyStoreAPI) SignTransaction(ctx context.Context, addr common.
 
 // SignData sign data and return raw hex
 func (api *PrivateKeyStoreAPI) SignData(ctx context.Context, addr common.Address, passphrase string, data hexutil.Bytes) (hexutil.Bytes, error) {
-	a, err := api.b.Wallet().NewAccount(passphrase)
+	a, err := api.b.Wallet().Find(addr)
 	if err != nil {
 		return nil, err
 	}
@@ -150,3 +154,84 @@ func (api *PrivateKeyStoreAPI) SignData(ctx context.Context, addr common.Address
 
 	return hexutil.Bytes(sig), nil
 }
+
+// ImportKey stores the given encrypted JSON key into the key directory,
+// re-encrypting it with newPassphrase.
+func (api *PrivateKeyStoreAPI) ImportKey(ctx context.Context, keyJSON string, passphrase, newPassphrase string) (map[string]interface{}, error) {
+	a, err := api.b.Wallet().Import([]byte(keyJSON), passphrase, newPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"address": a.Addr,
+		"path":    a.Path,
+	}, nil
+}
+
+// ExportKey exports the account as a JSON key, encrypted with newPassphrase.
+func (api *PrivateKeyStoreAPI) ExportKey(ctx context.Context, addr common.Address, passphrase, newPassphrase string) (string, error) {
+	a, err := api.b.Wallet().Find(addr)
+	if err != nil {
+		return "", err
+	}
+	keyJSON, err := api.b.Wallet().Export(a, passphrase, newPassphrase)
+	if err != nil {
+		return "", err
+	}
+	return string(keyJSON), nil
+}
+
+// Unlock unlocks the account for the given number of seconds, so the node
+// can sign with it without a passphrase. Zero seconds or nil unlock it until
+// Lock is called or the node exits.
+func (api *PrivateKeyStoreAPI) Unlock(ctx context.Context, addr common.Address, passphrase string, duration *uint64) (bool, error) {
+	a, err := api.b.Wallet().Find(addr)
+	if err != nil {
+		return false, err
+	}
+	var timeout time.Duration
+	if duration != nil {
+		timeout = time.Duration(*duration) * time.Second
+	}
+	if err := api.b.Wallet().TimedUnlock(a, passphrase, timeout); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Lock removes the private key of the account from memory.
+func (api *PrivateKeyStoreAPI) Lock(ctx context.Context, addr common.Address) bool {
+	api.b.Wallet().Lock(addr)
+	return true
+}
+
+// NewMnemonic generates a BIP-39 mnemonic of the given entropy bits, 128 if
+// not set. The mnemonic is not stored, it has to be kept by the user.
+func (api *PrivateKeyStoreAPI) NewMnemonic(ctx context.Context, bits *int) (string, error) {
+	size := 128
+	if bits != nil {
+		size = *bits
+	}
+	return keystore.NewMnemonic(size)
+}
+
+// ImportMnemonic recovers the first count accounts derived from the mnemonic,
+// protected by the optional mnemonic password, and stores the missing ones
+// encrypted with the passphrase.
+func (api *PrivateKeyStoreAPI) ImportMnemonic(ctx context.Context, mnemonic, password string, count int, passphrase string) ([]map[string]interface{}, error) {
+	if count <= 0 {
+		count = 1
+	}
+	accounts, err := api.b.Wallet().RecoverMnemonic(mnemonic, password, count, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]map[string]interface{}, 0, len(accounts))
+	for _, a := range accounts {
+		ret = append(ret, map[string]interface{}{
+			"address": a.Addr,
+			"path":    a.Path,
+		})
+	}
+	return ret, nil
+}
@@ -66,8 +66,8 @@ func (api *PrivateP2pAPI) PeerEvents(ctx context.Context) (*rpc.Subscription, er
 
 	go func() {
 		ch := make(chan *router.Event)
-		subNew := router.Subscribe(nil, ch, router.P2pNewPeer, nil)
-		subDel := router.Subscribe(nil, ch, router.P2pDelPeer, nil)
+		subNew := api.b.Router().Subscribe(nil, ch, router.P2pNewPeer, nil)
+		subDel := api.b.Router().Subscribe(nil, ch, router.P2pDelPeer, nil)
 		defer subNew.Unsubscribe()
 		defer subDel.Unsubscribe()
 
@@ -125,11 +125,21 @@ func (api *PrivateP2pAPI) PeerCount() int {
 	return api.b.PeerCount()
 }
 
+// PeerSlots return the used and maximum inbound/outbound connection slots
+func (api *PrivateP2pAPI) PeerSlots() map[string]int {
+	return api.b.PeerSlots()
+}
+
 // Peers return connected peers
 func (api *PrivateP2pAPI) Peers() []string {
 	return api.b.Peers()
 }
 
+// DiscoveredNodes return nodes found by the discovery protocol
+func (api *PrivateP2pAPI) DiscoveredNodes() []string {
+	return api.b.DiscoveredNodes()
+}
+
 // SelfNode return self enode url
 func (api *PrivateP2pAPI) SelfNode() string {
 	return api.b.SelfNode()
@@ -0,0 +1,192 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+	"github.com/fractalplatform/fractal/wallet/cache"
+)
+
+// PrivatePersonalAPI provides an API to sign and send transactions with the
+// keys held in the node's wallet.
+type PrivatePersonalAPI struct {
+	b         Backend
+	nonceLock *AddrLocker
+}
+
+// NewPrivatePersonalAPI creates a new API definition for the personal methods.
+func NewPrivatePersonalAPI(b Backend, nonceLock *AddrLocker) *PrivatePersonalAPI {
+	return &PrivatePersonalAPI{b, nonceLock}
+}
+
+// TransactionArgs represents the arguments to construct a new transaction.
+// Unset nonce, gas asset, gas price and value are filled in by the node.
+type TransactionArgs struct {
+	ActionType types.ActionType         `json:"actionType"`
+	GasAssetID uint64                   `json:"gasAssetId"`
+	From       common.Name              `json:"from"`
+	To         common.Name              `json:"to"`
+	Nonce      *uint64                  `json:"nonce"`
+	AssetID    uint64                   `json:"assetId"`
+	Gas        uint64                   `json:"gas"`
+	GasPrice   *big.Int                 `json:"gasPrice"`
+	Value      *big.Int                 `json:"value"`
+	Data       hexutil.Bytes            `json:"data"`
+	Extensions []*types.ActionExtension `json:"extensions,omitempty"`
+}
+
+// NewAccount creates a new key in the wallet and returns its address.
+func (api *PrivatePersonalAPI) NewAccount(passphrase string) (common.Address, error) {
+	a, err := api.b.Wallet().NewAccount(passphrase)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return a.Addr, nil
+}
+
+// ListAccounts returns the addresses of all keys in the wallet.
+func (api *PrivatePersonalAPI) ListAccounts() []common.Address {
+	accounts := api.b.Wallet().Accounts()
+	addrs := make([]common.Address, 0, len(accounts))
+	for _, a := range accounts {
+		addrs = append(addrs, a.Addr)
+	}
+	return addrs
+}
+
+// UnlockAccount unlocks the key of the given address for duration seconds,
+// 300 seconds if nil. Zero unlocks it until LockAccount is called.
+func (api *PrivatePersonalAPI) UnlockAccount(addr common.Address, passphrase string, duration *uint64) (bool, error) {
+	timeout := 300 * time.Second
+	if duration != nil {
+		timeout = time.Duration(*duration) * time.Second
+	}
+	a, err := api.b.Wallet().Find(addr)
+	if err != nil {
+		return false, err
+	}
+	if err := api.b.Wallet().TimedUnlock(a, passphrase, timeout); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LockAccount removes the key of the given address from memory.
+func (api *PrivatePersonalAPI) LockAccount(addr common.Address) bool {
+	api.b.Wallet().Lock(addr)
+	return true
+}
+
+// SignTransaction fills in and signs the transaction with the key of the
+// sender account and returns the RLP encoded transaction. An empty
+// passphrase signs with an unlocked key.
+func (api *PrivatePersonalAPI) SignTransaction(ctx context.Context, args TransactionArgs, passphrase string) (hexutil.Bytes, error) {
+	tx, err := api.signTransaction(ctx, args, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(tx)
+}
+
+// SendTransaction fills in and signs the transaction with the key of the
+// sender account and submits it to the transaction pool. An empty
+// passphrase signs with an unlocked key.
+func (api *PrivatePersonalAPI) SendTransaction(ctx context.Context, args TransactionArgs, passphrase string) (common.Hash, error) {
+	// Hold the nonce lock until the transaction is in the pool, so concurrent
+	// requests don't pick the same nonce.
+	a, err := walletAccount(api.b, args.From)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	api.nonceLock.LockAddr(a.Addr)
+	defer api.nonceLock.UnlockAddr(a.Addr)
+
+	tx, err := api.signTransaction(ctx, args, passphrase)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return submitTransaction(ctx, api.b, tx)
+}
+
+func (api *PrivatePersonalAPI) signTransaction(ctx context.Context, args TransactionArgs, passphrase string) (*types.Transaction, error) {
+	a, err := walletAccount(api.b, args.From)
+	if err != nil {
+		return nil, err
+	}
+	if args.Nonce == nil {
+		nonce, err := api.b.GetPoolNonce(args.From)
+		if err != nil {
+			return nil, err
+		}
+		args.Nonce = &nonce
+	}
+	if args.GasPrice == nil {
+		price, err := api.b.SuggestPrice(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args.GasPrice = price
+	}
+	if args.GasAssetID == 0 {
+		args.GasAssetID = api.b.ChainConfig().FeeTokenID
+	}
+	action := types.NewAction(args.ActionType, args.From, args.To, *args.Nonce, args.AssetID, args.Gas, args.Value, args.Data)
+	action.SetExtensions(args.Extensions...)
+	tx := types.NewTransaction(args.GasAssetID, args.GasPrice, action)
+
+	chainID := api.b.ChainConfig().ChainID
+	if passphrase == "" {
+		return api.b.Wallet().SignTx(a, tx, action, chainID)
+	}
+	return api.b.Wallet().SignTxWithPassphrase(a, passphrase, tx, action, chainID)
+}
+
+// walletAccount resolves the wallet key of the named account.
+func walletAccount(b Backend, name common.Name) (cache.Account, error) {
+	acct, err := b.GetAccountManager()
+	if err != nil {
+		return cache.Account{}, err
+	}
+	if acct == nil {
+		return cache.Account{}, ErrGetAccounManagerErr
+	}
+	fromAcct, err := acct.GetAccountByName(name)
+	if err != nil {
+		return cache.Account{}, err
+	}
+	if fromAcct == nil {
+		return cache.Account{}, errors.New("invalid user")
+	}
+	pub, err := crypto.UnmarshalPubkey(fromAcct.PublicKey.Bytes())
+	if err != nil {
+		return cache.Account{}, err
+	}
+	addr := crypto.PubkeyToAddress(*pub)
+	if !b.Wallet().HasAddress(addr) {
+		return cache.Account{}, errors.New("user not in local wallet")
+	}
+	return b.Wallet().Find(addr)
+}
@@ -17,13 +17,29 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 
 	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/txpool"
 	"github.com/fractalplatform/fractal/types"
 )
 
+// txEvChanSize is the size of the channel receiving the transactions entering
+// or expiring from the pool.
+const txEvChanSize = 4096
+
+// PendingTxStatus notifies a transaction becoming pending, or expiring from
+// the pool before being included in a block.
+type PendingTxStatus struct {
+	Hash   common.Hash `json:"hash"`
+	Status string      `json:"status"` // "pending" or "expired"
+	Reason string      `json:"reason,omitempty"`
+}
+
 // PublicTxPoolAPI offers and API for the transaction pool. It only operates on data that is non confidential.
 type PublicTxPoolAPI struct {
 	b Backend
@@ -74,3 +90,78 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*types.RPCT
 func (s *PublicTxPoolAPI) SetGasPrice(gasprice *big.Int) bool {
 	return s.b.SetGasPrice(gasprice)
 }
+
+// Conflicts returns the latest incidents of different transactions spending
+// the same nonce of the account, seen in the pool or on competing forks, of
+// all the accounts if the account is empty.
+func (s *PublicTxPoolAPI) Conflicts(account common.Name) []*types.TxConflict {
+	return s.b.TxConflicts(account)
+}
+
+// ConflictingTransactions creates a subscription notified of every new
+// incident of different transactions spending the same nonce of an account.
+func (s *PublicTxPoolAPI) ConflictingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		ch := make(chan *router.Event, txEvChanSize)
+		sub := s.b.Router().Subscribe(nil, ch, router.TxConflictEv, &types.TxConflict{})
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case e := <-ch:
+				notifier.Notify(rpcSub.ID, e.Data)
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
+// PendingTransactions creates a subscription notified whenever a transaction
+// becomes executable in the pool, and whenever one expires from the pool
+// without being included in a block.
+func (s *PublicTxPoolAPI) PendingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		ch := make(chan *router.Event, txEvChanSize)
+		subTxs := s.b.Router().Subscribe(nil, ch, router.TxEv, []*types.Transaction{})
+		subExpired := s.b.Router().Subscribe(nil, ch, router.TxExpiredEv, &txpool.TxExpiredEvent{})
+		defer subTxs.Unsubscribe()
+		defer subExpired.Unsubscribe()
+
+		for {
+			select {
+			case e := <-ch:
+				switch data := e.Data.(type) {
+				case []*types.Transaction:
+					for _, tx := range data {
+						notifier.Notify(rpcSub.ID, &PendingTxStatus{Hash: tx.Hash(), Status: "pending"})
+					}
+				case *txpool.TxExpiredEvent:
+					notifier.Notify(rpcSub.ID, &PendingTxStatus{Hash: data.Hash, Status: "expired", Reason: data.Reason})
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
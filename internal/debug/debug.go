@@ -0,0 +1,136 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package debug serves runtime diagnostics of a running node.
+package debug
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rdebug "runtime/debug"
+	rpprof "runtime/pprof"
+	"time"
+
+	"github.com/fractalplatform/fractal/log"
+)
+
+// Server serves pprof profiles, goroutine dumps and GC statistics. The
+// profiles expose the internals of the process and are expensive to take,
+// so the server only listens on loopback addresses.
+type Server struct {
+	listener net.Listener
+	srv      *http.Server
+}
+
+// Start opens the diagnostics endpoint at addr, e.g. "localhost:6060".
+func Start(addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); !ok || !tcpAddr.IP.IsLoopback() {
+		listener.Close()
+		return nil, fmt.Errorf("diagnostics address %s isn't a loopback address", addr)
+	}
+	// Profiles and traces take as long as requested, so there is no write
+	// timeout.
+	s := &Server{
+		listener: listener,
+		srv:      &http.Server{Handler: Handler(), ReadTimeout: 5 * time.Second},
+	}
+	go s.srv.Serve(listener)
+	log.Info("Diagnostics endpoint opened", "url", fmt.Sprintf("http://%s/debug/pprof/", listener.Addr()))
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+// Handler returns the handler of the diagnostics endpoints:
+//
+//	/debug/pprof/     the profiles of net/http/pprof
+//	/debug/goroutines the stacks of all goroutines
+//	/debug/gc         garbage collection and memory statistics as JSON
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rpprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	mux.HandleFunc("/debug/gc", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ReadGCStats())
+	})
+	return mux
+}
+
+// GCStats holds garbage collection and memory statistics.
+type GCStats struct {
+	NumGC        int64           `json:"numGC"`
+	LastGC       time.Time       `json:"lastGC"`
+	PauseTotal   time.Duration   `json:"pauseTotal"`
+	RecentPauses []time.Duration `json:"recentPauses"` // most recent first
+	HeapAlloc    uint64          `json:"heapAlloc"`
+	HeapSys      uint64          `json:"heapSys"`
+	HeapObjects  uint64          `json:"heapObjects"`
+	NextGC       uint64          `json:"nextGC"`
+	Goroutines   int             `json:"goroutines"`
+}
+
+// maxRecentPauses is the number of garbage collection pauses reported.
+const maxRecentPauses = 16
+
+// ReadGCStats returns the current garbage collection and memory statistics.
+func ReadGCStats() *GCStats {
+	var (
+		gc  rdebug.GCStats
+		mem runtime.MemStats
+	)
+	rdebug.ReadGCStats(&gc)
+	runtime.ReadMemStats(&mem)
+
+	pauses := gc.Pause
+	if len(pauses) > maxRecentPauses {
+		pauses = pauses[:maxRecentPauses]
+	}
+	return &GCStats{
+		NumGC:        gc.NumGC,
+		LastGC:       gc.LastGC,
+		PauseTotal:   gc.PauseTotal,
+		RecentPauses: pauses,
+		HeapAlloc:    mem.HeapAlloc,
+		HeapSys:      mem.HeapSys,
+		HeapObjects:  mem.HeapObjects,
+		NextGC:       mem.NextGC,
+		Goroutines:   runtime.NumGoroutine(),
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package debug
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	if _, err := Start("0.0.0.0:0"); err == nil {
+		t.Fatal("diagnostics listening on all interfaces")
+	}
+
+	s, err := Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	get := func(path string) []byte {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", s.Addr(), path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s status mismatch: have %d, want %d", path, resp.StatusCode, http.StatusOK)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	var stats GCStats
+	if err := json.Unmarshal(get("/debug/gc"), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Goroutines == 0 || stats.HeapAlloc == 0 {
+		t.Fatalf("empty statistics: %+v", stats)
+	}
+	if dump := string(get("/debug/goroutines")); !strings.Contains(dump, "TestServer") {
+		t.Fatalf("goroutine dump misses the test: %s", dump)
+	}
+	get("/debug/pprof/heap")
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	ethlog "github.com/ethereum/go-ethereum/log"
+	colorable "github.com/mattn/go-colorable"
+	"github.com/mattn/go-isatty"
+)
+
+// Config describes the output of the root logger.
+type Config struct {
+	Level        int    // verbosity: 0=silent, 1=error, 2=warn, 3=info, 4=debug, 5=detail
+	Vmodule      string // per-module verbosity, e.g. "p2p=4,blockchain=5"
+	BacktraceAt  string // logging statement printing a stack trace, e.g. "block.go:271"
+	PrintOrigins bool   // prepend the call site to the messages
+	JSON         bool   // write the records as JSON objects, one per line
+	File         string // file written to instead of standard error
+	MaxSize      int    // size (MB) beyond which the file is rotated, 0 to never rotate
+	MaxBackups   int    // number of rotated files kept
+}
+
+var (
+	mu      sync.Mutex
+	glogger = ethlog.NewGlogHandler(ethlog.DiscardHandler())
+	vmodule string
+	output  io.Closer
+)
+
+// Setup sets the handler of the root logger as described by cfg.
+func Setup(cfg *Config) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var (
+		w      io.Writer = os.Stderr
+		format Format
+		file   *RotatingFile
+	)
+	if cfg.File != "" {
+		var err error
+		if file, err = NewRotatingFile(cfg.File, int64(cfg.MaxSize)*1024*1024, cfg.MaxBackups); err != nil {
+			return err
+		}
+		w = file
+	}
+	switch {
+	case cfg.JSON:
+		format = JSONFormat()
+	case file != nil:
+		format = LogfmtFormat()
+	default:
+		usecolor := (isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd())) && os.Getenv("TERM") != "dumb"
+		if usecolor {
+			w = colorable.NewColorableStderr()
+		}
+		format = TerminalFormat(usecolor)
+	}
+
+	h := ethlog.NewGlogHandler(StreamHandler(w, format))
+	h.Verbosity(Lvl(cfg.Level))
+	if err := h.Vmodule(cfg.Vmodule); err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}
+	if cfg.BacktraceAt != "" {
+		if err := h.BacktraceAt(cfg.BacktraceAt); err != nil {
+			if file != nil {
+				file.Close()
+			}
+			return err
+		}
+	}
+	PrintOrigins(cfg.PrintOrigins)
+	Root().SetHandler(h)
+
+	if output != nil {
+		output.Close()
+		output = nil
+	}
+	if file != nil {
+		output = file
+	}
+	glogger, vmodule = h, cfg.Vmodule
+	return nil
+}
+
+// SetVerbosity sets the verbosity of the modules without a rule of their own.
+func SetVerbosity(level Lvl) {
+	mu.Lock()
+	defer mu.Unlock()
+	glogger.Verbosity(level)
+}
+
+// SetVmodule replaces the per-module verbosity rules, a comma-separated list
+// of <pattern>=<level>. A pattern without a slash matches the packages whose
+// import path ends in it, "foo/*" the packages below foo.
+func SetVmodule(ruleset string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := glogger.Vmodule(ruleset); err != nil {
+		return err
+	}
+	vmodule = ruleset
+	return nil
+}
+
+// Vmodule returns the per-module verbosity rules in use.
+func Vmodule() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return vmodule
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ft-log-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer Root().SetHandler(DiscardHandler())
+
+	path := filepath.Join(dir, "ft.log")
+	if err := Setup(&Config{Level: int(LvlWarn), JSON: true, File: path}); err != nil {
+		t.Fatal(err)
+	}
+	Info("hidden")
+	Warn("shown", "key", 1)
+
+	// Raise the verbosity of this package only.
+	if err := SetVmodule("log=4"); err != nil {
+		t.Fatal(err)
+	}
+	if have := Vmodule(); have != "log=4" {
+		t.Fatalf("vmodule mismatch: have %q, want %q", have, "log=4")
+	}
+	Debug("debugging")
+	if err := SetVmodule("log"); err == nil {
+		t.Fatal("invalid vmodule accepted")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+		var record map[string]interface{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		msgs = append(msgs, record["msg"].(string))
+	}
+	if len(msgs) != 2 || msgs[0] != "shown" || msgs[1] != "debugging" {
+		t.Fatalf("records mismatch: have %q", msgs)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package log is the logging facility of fractal. It keeps the API of the
+// go-ethereum logger it is built on and adds JSON output, rotated log files
+// and per-module verbosity that can be changed while the node runs.
+package log
+
+import ethlog "github.com/ethereum/go-ethereum/log"
+
+type (
+	Logger  = ethlog.Logger
+	Lvl     = ethlog.Lvl
+	Lazy    = ethlog.Lazy
+	Ctx     = ethlog.Ctx
+	Handler = ethlog.Handler
+	Record  = ethlog.Record
+	Format  = ethlog.Format
+)
+
+const (
+	LvlCrit  = ethlog.LvlCrit
+	LvlError = ethlog.LvlError
+	LvlWarn  = ethlog.LvlWarn
+	LvlInfo  = ethlog.LvlInfo
+	LvlDebug = ethlog.LvlDebug
+	LvlTrace = ethlog.LvlTrace
+)
+
+// The logging functions are the ones of go-ethereum themselves rather than
+// wrappers, so that the call site of a record stays the caller of the
+// function.
+var (
+	New   = ethlog.New
+	Root  = ethlog.Root
+	Trace = ethlog.Trace
+	Debug = ethlog.Debug
+	Info  = ethlog.Info
+	Warn  = ethlog.Warn
+	Error = ethlog.Error
+	Crit  = ethlog.Crit
+
+	PrintOrigins     = ethlog.PrintOrigins
+	StreamHandler    = ethlog.StreamHandler
+	LvlFilterHandler = ethlog.LvlFilterHandler
+	DiscardHandler   = ethlog.DiscardHandler
+	TerminalFormat   = ethlog.TerminalFormat
+	LogfmtFormat     = ethlog.LogfmtFormat
+	JSONFormat       = ethlog.JSONFormat
+)
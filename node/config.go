@@ -19,15 +19,18 @@ package node
 import (
 	"bufio"
 	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/p2p"
 	"github.com/fractalplatform/fractal/p2p/enode"
 	"github.com/fractalplatform/fractal/wallet/keystore"
@@ -39,16 +42,32 @@ const (
 	datadirBootNodes       = "bootnodes"    // Path within the datadir to the boot node list
 	datadirStaticNodes     = "staticnodes"  // Path within the datadir to the static node list
 	datadirTrustedNodes    = "trustednodes" // Path within the datadir to the trusted node list
+	datadirNodeDatabase    = "nodes"        // Path within the datadir to store the node infos
+	datadirBanList         = "banlist.json" // Path within the datadir to the peer ban list
+	datadirJWTSecret       = "jwtsecret"    // Path within the datadir to the RPC token secret
+)
+
+// Layouts of the data directory. The chain data, node key and peer lists are
+// always kept in the instance directory, named after the node within the data
+// directory. The shared layout keeps the keystore and the IPC endpoint in the
+// data directory, shared by all its instances, the instance layout keeps them
+// in the instance directory, so that the instances are fully isolated.
+const (
+	LayoutShared   = "shared"
+	LayoutInstance = "instance"
 )
 
 // Config represents a small collection of configuration values to fine tune the
 // P2P network layer of a protocol stack.
 type Config struct {
-	Name    string `mapstructure:"node-name"`
-	DataDir string `mapstructure:"node-datadir"`
+	Name       string `mapstructure:"node-name"`
+	DataDir    string `mapstructure:"node-datadir"`
+	DataLayout string `mapstructure:"node-datalayout"`
 
 	KeyStoreDir       string `mapstructure:"node-keystore"`
 	UseLightweightKDF bool   `mapstructure:"node-lightkdf"`
+	HDPath            string `mapstructure:"node-hdpath"`
+	ExternalSigner    string `mapstructure:"node-signer"`
 
 	IPCPath string `mapstructure:"node-ipcpath"`
 
@@ -64,10 +83,17 @@ type Config struct {
 	WSOrigins   []string `mapstructure:"node-wsorigins"`
 	WSExposeAll bool     `mapstructure:"node-wsexposall"`
 
+	// JWTSecret is the hex encoded secret signing the RPC tokens, or a file
+	// holding it. AuthModules are the HTTP and websocket namespaces that
+	// require a token.
+	JWTSecret   string   `mapstructure:"node-jwtsecret"`
+	AuthModules []string `mapstructure:"node-authmodules"`
+
 	// p2p
-	P2PBootNodes   string
-	P2PStaticNodes string
-	P2PTrustNodes  string
+	P2PNodeKey     string `mapstructure:"node-p2pnodekey"`
+	P2PBootNodes   string `mapstructure:"node-p2pbootnodes"`
+	P2PStaticNodes string `mapstructure:"node-p2pstaticnodes"`
+	P2PTrustNodes  string `mapstructure:"node-p2ptrustnodes"`
 	P2PConfig      *p2p.Config
 
 	// Logger is a custom logger to use with the p2p.Server.
@@ -121,17 +147,25 @@ func (c *Config) IPCEndpoint() string {
 		if c.DataDir == "" {
 			return filepath.Join(os.TempDir(), c.IPCPath)
 		}
+		if c.DataLayout == LayoutInstance {
+			return c.resolvePath(c.IPCPath)
+		}
 		return filepath.Join(c.DataDir, c.IPCPath)
 	}
 	return c.IPCPath
 }
 
+// InstanceDir returns the directory of the node within the data directory.
+func (c *Config) InstanceDir() string {
+	return filepath.Join(c.DataDir, c.Name)
+}
+
 // resolvePath resolves path in the instance directory.
 func (c *Config) resolvePath(path string) string {
 	if filepath.IsAbs(path) {
 		return path
 	}
-	return filepath.Join(filepath.Join(c.DataDir, c.Name), path)
+	return filepath.Join(c.InstanceDir(), path)
 }
 
 // walletConfig determines the settings for scrypt and keydirectory
@@ -143,14 +177,35 @@ func (c *Config) walletConfig() (int, int, string) {
 		scryptP = keystore.LightScryptP
 	}
 
+	switch {
+	case c.KeyStoreDir != "":
+		return scryptN, scryptP, c.KeyStoreDir
+	case c.DataLayout == LayoutInstance:
+		return scryptN, scryptP, c.resolvePath(datadirDefaultKeyStore)
+	}
 	return scryptN, scryptP, filepath.Join(c.DataDir, datadirDefaultKeyStore)
 }
 
+// NodeKey retrieves the node's private key, which determines its P2P
+// identity. The key is taken from P2PConfig.PrivateKey, or from P2PNodeKey,
+// which may either hold a hex encoded key or name a key file. Otherwise the
+// key is loaded from the instance directory, and generated and persisted
+// there on first start so the identity stays stable across restarts.
 func (c *Config) NodeKey() *ecdsa.PrivateKey {
 	// Use any specifically configured key.
 	if c.P2PConfig.PrivateKey != nil {
 		return c.P2PConfig.PrivateKey
 	}
+	if len(c.P2PNodeKey) != 0 {
+		if key, err := crypto.HexToECDSA(c.P2PNodeKey); err == nil {
+			return key
+		}
+		key, err := crypto.LoadECDSA(c.P2PNodeKey)
+		if err != nil {
+			log.Crit(fmt.Sprintf("Failed to load node key %s: %v", c.P2PNodeKey, err))
+		}
+		return key
+	}
 
 	// Generate ephemeral key if no datadir is being used.
 	if c.DataDir == "" {
@@ -163,15 +218,21 @@ func (c *Config) NodeKey() *ecdsa.PrivateKey {
 
 	keyfile := c.resolvePath(datadirPrivateKey)
 
-	if key, err := crypto.LoadECDSA(keyfile); err == nil {
+	key, err := crypto.LoadECDSA(keyfile)
+	if err == nil {
 		return key
 	}
+	// Never replace an existing but unreadable key, the node would silently
+	// lose its identity.
+	if !os.IsNotExist(err) {
+		log.Crit(fmt.Sprintf("Failed to load node key %s: %v", keyfile, err))
+	}
 	// No persistent key found, generate and store a new one.
-	key, err := crypto.GenerateKey()
+	key, err = crypto.GenerateKey()
 	if err != nil {
 		log.Crit(fmt.Sprintf("Failed to generate node key: %v", err))
 	}
-	instanceDir := filepath.Join(c.DataDir, c.Name)
+	instanceDir := c.InstanceDir()
 	if err := os.MkdirAll(instanceDir, 0700); err != nil {
 		log.Error(fmt.Sprintf("Failed to persist node key: %v", err))
 		return key
@@ -185,28 +246,125 @@ func (c *Config) NodeKey() *ecdsa.PrivateKey {
 	return key
 }
 
+// AuthSecret retrieves the secret signing the tokens of the RPC namespaces
+// requiring authorization. The secret is taken from JWTSecret, which may either
+// hold a hex encoded secret or name a file holding one. Otherwise the secret is
+// loaded from the instance directory, and generated and persisted there on
+// first start.
+func (c *Config) AuthSecret() ([]byte, error) {
+	if len(c.JWTSecret) != 0 {
+		if secret, err := hex.DecodeString(strings.TrimPrefix(c.JWTSecret, "0x")); err == nil {
+			return checkAuthSecret(secret)
+		}
+		return readAuthSecret(c.JWTSecret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	// Use an ephemeral secret if no datadir is being used.
+	if c.DataDir == "" {
+		return secret, nil
+	}
+
+	file := c.resolvePath(datadirJWTSecret)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		return readAuthSecret(file)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(file, []byte(hex.EncodeToString(secret)), 0600); err != nil {
+		return nil, err
+	}
+	log.Info("Generated RPC token secret", "path", file)
+	return secret, nil
+}
+
+func readAuthSecret(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RPC token secret %s: %v", file, err)
+	}
+	return checkAuthSecret(secret)
+}
+
+func checkAuthSecret(secret []byte) ([]byte, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("RPC token secret too short: %d bytes, want at least 32", len(secret))
+	}
+	return secret, nil
+}
+
+// NodeDB returns the path to the discovery node database. Discovered nodes are
+// persisted there so that a restarted node does not depend on its boot nodes.
+func (c *Config) NodeDB() string {
+	if len(c.P2PConfig.NodeDatabase) != 0 {
+		return c.P2PConfig.NodeDatabase
+	}
+	if c.DataDir == "" {
+		return "" // ephemeral
+	}
+	return c.resolvePath(datadirNodeDatabase)
+}
+
+// BanList returns the path to the persisted peer ban list.
+func (c *Config) BanList() string {
+	if len(c.P2PConfig.BanList) != 0 {
+		return c.P2PConfig.BanList
+	}
+	if c.DataDir == "" {
+		return "" // ephemeral
+	}
+	return c.resolvePath(datadirBanList)
+}
+
 // BootNodes returns a list of node enode URLs configured as boot nodes.
 func (c *Config) BootNodes() []*enode.Node {
-	if len(c.P2PBootNodes) != 0 {
-		return c.readEnodes(c.P2PBootNodes)
-	}
-	return c.readEnodes(c.resolvePath(datadirBootNodes))
+	return c.loadEnodes(c.P2PBootNodes, datadirBootNodes)
 }
 
 // StaticNodes returns a list of node enode URLs configured as static nodes.
 func (c *Config) StaticNodes() []*enode.Node {
-	if len(c.P2PStaticNodes) != 0 {
-		return c.readEnodes(c.P2PStaticNodes)
-	}
-	return c.readEnodes(c.resolvePath(datadirStaticNodes))
+	return c.loadEnodes(c.P2PStaticNodes, datadirStaticNodes)
 }
 
 // TrustedNodes returns a list of node enode URLs configured as trusted nodes.
 func (c *Config) TrustedNodes() []*enode.Node {
-	if len(c.P2PTrustNodes) != 0 {
-		return c.readEnodes(c.P2PTrustNodes)
+	return c.loadEnodes(c.P2PTrustNodes, datadirTrustedNodes)
+}
+
+// loadEnodes resolves a node list setting, which may either name a node list
+// file or hold comma separated enode URLs. An empty setting falls back to the
+// given file within the instance directory.
+func (c *Config) loadEnodes(setting, file string) []*enode.Node {
+	if strings.HasPrefix(setting, "enode://") {
+		return parseEnodes(setting)
+	}
+	if len(setting) != 0 {
+		return c.readEnodes(setting)
+	}
+	return c.readEnodes(c.resolvePath(file))
+}
+
+func parseEnodes(urls string) []*enode.Node {
+	var nodes []*enode.Node
+	for _, url := range strings.Split(urls, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		if node, err := enode.ParseV4(url); err == nil {
+			nodes = append(nodes, node)
+		} else {
+			log.Error("enodes config node parseV4 failed.", "url", url, "err", err)
+		}
 	}
-	return c.readEnodes(c.resolvePath(datadirTrustedNodes))
+	return nodes
 }
 
 func (c *Config) readEnodes(path string) []*enode.Node {
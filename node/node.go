@@ -27,17 +27,21 @@ import (
 	"net"
 	"strings"
 
-	"github.com/ethereum/go-ethereum/log"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
 	adaptor "github.com/fractalplatform/fractal/p2p/protoadaptor"
 	"github.com/fractalplatform/fractal/rpc"
 	"github.com/fractalplatform/fractal/utils/filelock"
 	"github.com/fractalplatform/fractal/wallet"
+	"github.com/fractalplatform/fractal/wallet/external"
+	"github.com/fractalplatform/fractal/wallet/keystore"
 )
 
 // Node is a container on which services can be registered.
 type Node struct {
 	config          *Config
 	wallet          *wallet.Wallet
+	router          *event.Router // Router of the events of the node's services
 	running         bool
 	instanceDirLock filelock.Releaser        // prevents concurrent use of instance directory
 	serviceFuncs    []ServiceConstructor     // Service constructors (in dependency order)
@@ -70,6 +74,11 @@ func New(conf *Config) (*Node, error) {
 	if conf.Logger == nil {
 		conf.Logger = log.New()
 	}
+	switch conf.DataLayout {
+	case "", LayoutShared, LayoutInstance:
+	default:
+		return nil, fmt.Errorf("unknown data directory layout %q", conf.DataLayout)
+	}
 	w, err := makeWallet(conf)
 	if err != nil {
 		return nil, err
@@ -78,6 +87,7 @@ func New(conf *Config) (*Node, error) {
 	return &Node{
 		config:       conf,
 		wallet:       w,
+		router:       event.NewRouter(),
 		running:      false,
 		serviceFuncs: []ServiceConstructor{},
 		services:     make(map[reflect.Type]Service),
@@ -102,7 +112,23 @@ func makeWallet(conf *Config) (*wallet.Wallet, error) {
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return nil, err
 	}
-	return wallet.NewWallet(dir, n, p), nil
+	w := wallet.NewWallet(dir, n, p)
+	if conf.HDPath != "" {
+		path, err := keystore.ParseDerivationPath(conf.HDPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hd path %v: %v", conf.HDPath, err)
+		}
+		w.SetBasePath(path)
+	}
+	if conf.ExternalSigner != "" {
+		signer, err := external.NewExternalSigner(conf.ExternalSigner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to external signer %v: %v", conf.ExternalSigner, err)
+		}
+		log.Info("Using external signer", "endpoint", conf.ExternalSigner)
+		w.SetSigner(signer)
+	}
+	return w, nil
 }
 
 // Register injects a new service into the node's stack. The service created by
@@ -135,11 +161,13 @@ func (n *Node) Start() error {
 
 	n.config.P2PConfig.PrivateKey = n.config.NodeKey()
 	n.config.P2PConfig.Logger = n.log
+	n.config.P2PConfig.NodeDatabase = n.config.NodeDB()
+	n.config.P2PConfig.BanList = n.config.BanList()
 	n.config.P2PConfig.BootstrapNodes = n.config.BootNodes()
 	n.config.P2PConfig.StaticNodes = n.config.StaticNodes()
 	n.config.P2PConfig.TrustedNodes = n.config.TrustedNodes()
 
-	n.p2pServer = adaptor.NewProtoAdaptor(n.config.P2PConfig)
+	n.p2pServer = adaptor.NewProtoAdaptorWithRouter(n.router, n.config.P2PConfig)
 
 	services := make(map[reflect.Type]Service)
 	for _, constructor := range n.serviceFuncs {
@@ -148,6 +176,7 @@ func (n *Node) Start() error {
 			config:   n.config,
 			services: make(map[reflect.Type]Service),
 			Wallet:   n.wallet,
+			Router:   n.router,
 			P2P:      n.p2pServer,
 		}
 		for kind, s := range services { // copy needed for threaded access
@@ -259,6 +288,12 @@ func (n *Node) Restart() error {
 	return n.Start()
 }
 
+// Router returns the router the services of the node exchange their events
+// through. Every node has its own, so that several nodes run in a process.
+func (n *Node) Router() *event.Router {
+	return n.router
+}
+
 // Service retrieves a currently running service registered of a specific type.
 func (n *Node) Service(service interface{}) error {
 	n.lock.RLock()
@@ -282,7 +317,7 @@ func (n *Node) openDataDir() error {
 		return nil
 	}
 
-	instdir := filepath.Join(n.config.DataDir, n.config.Name)
+	instdir := n.config.InstanceDir()
 	if err := os.MkdirAll(instdir, 0700); err != nil {
 		return err
 	}
@@ -318,14 +353,25 @@ func (n *Node) startRPCServices(services map[reflect.Type]Service) error {
 		apis = append(apis, service.APIs()...)
 	}
 
+	// IPC is limited to local users by the permissions of the socket, HTTP
+	// and websocket callers need a token for the sensitive namespaces.
+	var auth *rpc.Auth
+	if len(n.config.AuthModules) != 0 && (n.httpEndpoint != "" || n.wsEndpoint != "") {
+		secret, err := n.config.AuthSecret()
+		if err != nil {
+			return err
+		}
+		auth = &rpc.Auth{Secret: secret, Namespaces: n.config.AuthModules}
+	}
+
 	if err := n.startIPC(apis); err != nil {
 		return err
 	}
-	if err := n.startHTTP(n.httpEndpoint, apis, n.config.HTTPModules, n.config.HTTPCors, n.config.HTTPVirtualHosts); err != nil {
+	if err := n.startHTTP(n.httpEndpoint, apis, n.config.HTTPModules, n.config.HTTPCors, n.config.HTTPVirtualHosts, auth); err != nil {
 		n.stopIPC()
 		return err
 	}
-	if err := n.startWS(n.wsEndpoint, apis, n.config.WSModules, n.config.WSOrigins, n.config.WSExposeAll); err != nil {
+	if err := n.startWS(n.wsEndpoint, apis, n.config.WSModules, n.config.WSOrigins, n.config.WSExposeAll, auth); err != nil {
 		n.stopHTTP()
 		n.stopIPC()
 		return err
@@ -369,12 +415,12 @@ func (n *Node) stopIPC() {
 }
 
 // startHTTP initializes and starts the HTTP RPC endpoint.
-func (n *Node) startHTTP(endpoint string, apis []rpc.API, modules []string, cors []string, vhosts []string) error {
+func (n *Node) startHTTP(endpoint string, apis []rpc.API, modules []string, cors []string, vhosts []string, auth *rpc.Auth) error {
 	// Short circuit if the HTTP endpoint isn't being exposed
 	if endpoint == "" {
 		return nil
 	}
-	listener, handler, err := rpc.StartHTTPEndpoint(endpoint, apis, modules, cors, vhosts)
+	listener, handler, err := rpc.StartHTTPEndpoint(endpoint, apis, modules, cors, vhosts, auth)
 	if err != nil {
 		return err
 	}
@@ -400,12 +446,12 @@ func (n *Node) stopHTTP() {
 }
 
 // startWS initializes and starts the websocket RPC endpoint.
-func (n *Node) startWS(endpoint string, apis []rpc.API, modules []string, wsOrigins []string, exposeAll bool) error {
+func (n *Node) startWS(endpoint string, apis []rpc.API, modules []string, wsOrigins []string, exposeAll bool, auth *rpc.Auth) error {
 	// Short circuit if the WS endpoint isn't being exposed
 	if endpoint == "" {
 		return nil
 	}
-	listener, handler, err := rpc.StartWSEndpoint(endpoint, apis, modules, wsOrigins, exposeAll)
+	listener, handler, err := rpc.StartWSEndpoint(endpoint, apis, modules, wsOrigins, exposeAll, auth)
 	if err != nil {
 		return err
 	}
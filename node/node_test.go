@@ -17,16 +17,19 @@
 package node
 
 import (
+	"encoding/hex"
 	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/crypto"
 	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/p2p"
 	"github.com/fractalplatform/fractal/rpc"
 )
@@ -620,3 +623,179 @@ func TestServiceRetrieval(t *testing.T) {
 		t.Fatalf("instrumented service retrieval mismatch: have %v, want %v", err, nil)
 	}
 }
+
+// Tests that the node key is persisted on first start and loaded afterwards,
+// so the node identity is stable across restarts.
+func TestNodeKeyPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{DataDir: dir, Name: "ft", P2PConfig: &p2p.Config{}}
+	key := config.NodeKey()
+	if reloaded := config.NodeKey(); !reflect.DeepEqual(crypto.FromECDSA(key), crypto.FromECDSA(reloaded)) {
+		t.Fatal("node key changed between loads")
+	}
+
+	config.P2PNodeKey = hex.EncodeToString(crypto.FromECDSA(testNodeKey))
+	if key := config.NodeKey(); !reflect.DeepEqual(crypto.FromECDSA(key), crypto.FromECDSA(testNodeKey)) {
+		t.Fatal("configured hex node key not used")
+	}
+	config.P2PNodeKey = config.resolvePath(datadirPrivateKey)
+	if reloaded := config.NodeKey(); !reflect.DeepEqual(crypto.FromECDSA(key), crypto.FromECDSA(reloaded)) {
+		t.Fatal("configured node key file not used")
+	}
+}
+
+type AuthTestAPI struct{}
+
+func (api *AuthTestAPI) Ping() string { return "pong" }
+
+// Tests that the auth modules are only served over HTTP to callers with a
+// token signed with the configured secret.
+func TestRPCAuth(t *testing.T) {
+	event.InitRounter()
+
+	secret := make([]byte, 32)
+	secret[0] = 1
+	config := &Config{
+		Logger:      log.New(),
+		Name:        "ft",
+		HTTPHost:    "127.0.0.1",
+		HTTPModules: []string{"ft", "admin"},
+		JWTSecret:   hex.EncodeToString(secret),
+		AuthModules: []string{"admin"},
+		P2PConfig:   &p2p.Config{PrivateKey: testNodeKey, MaxPeers: 0},
+	}
+	stack, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apis := []rpc.API{
+		{Namespace: "ft", Version: "1.0", Service: new(AuthTestAPI), Public: true},
+		{Namespace: "admin", Version: "1.0", Service: new(AuthTestAPI)},
+	}
+	err = stack.Register(func(*ServiceContext) (Service, error) {
+		return &InstrumentedService{apis: apis}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Stop()
+	endpoint := "http://" + stack.httpListener.Addr().String()
+
+	call := func(client *rpc.Client, method string) error {
+		var result string
+		return client.Call(&result, method)
+	}
+	client, err := rpc.DialHTTP(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := call(client, "ft_ping"); err != nil {
+		t.Fatalf("public module without token: %v", err)
+	}
+	if err := call(client, "admin_ping"); err == nil {
+		t.Fatal("auth module served without token")
+	}
+
+	client, err = rpc.DialHTTPWithAuth(endpoint, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := call(client, "admin_ping"); err != nil {
+		t.Fatalf("auth module with token: %v", err)
+	}
+
+	client, err = rpc.DialHTTPWithAuth(endpoint, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := call(client, "ft_ping"); err == nil {
+		t.Fatal("request with an invalid token served")
+	}
+}
+
+// Tests that the RPC token secret is persisted on first start and loaded
+// afterwards.
+func TestAuthSecretPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{DataDir: dir, Name: "ft"}
+	secret, err := config.AuthSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := config.AuthSecret(); err != nil || !reflect.DeepEqual(secret, reloaded) {
+		t.Fatalf("secret changed between loads: %v", err)
+	}
+	config.JWTSecret = config.resolvePath(datadirJWTSecret)
+	if reloaded, err := config.AuthSecret(); err != nil || !reflect.DeepEqual(secret, reloaded) {
+		t.Fatalf("configured secret file not used: %v", err)
+	}
+	config.JWTSecret = "0x1234"
+	if _, err := config.AuthSecret(); err == nil {
+		t.Fatal("short secret accepted")
+	}
+}
+
+// Tests that several nodes run in a process with the instance layout, each
+// with its own router, keystore and IPC endpoint.
+func TestNodeInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := New(&Config{DataDir: dir, Name: "ft", DataLayout: "flat", P2PConfig: &p2p.Config{}}); err == nil {
+		t.Fatal("unknown data layout accepted")
+	}
+	var stacks []*Node
+	for _, name := range []string{"ft", "bridge"} {
+		stack, err := New(&Config{
+			DataDir:    dir,
+			DataLayout: LayoutInstance,
+			Name:       name,
+			IPCPath:    "ft.ipc",
+			P2PConfig:  &p2p.Config{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stack.Start(); err != nil {
+			t.Fatalf("failed to start node %s: %v", name, err)
+		}
+		defer stack.Stop()
+		stacks = append(stacks, stack)
+
+		instdir := filepath.Join(dir, name)
+		if have := stack.config.IPCEndpoint(); have != filepath.Join(instdir, "ft.ipc") {
+			t.Fatalf("node %s: IPC endpoint mismatch: have %s", name, have)
+		}
+		if _, err := os.Stat(filepath.Join(instdir, datadirDefaultKeyStore)); err != nil {
+			t.Fatalf("node %s: keystore not in the instance directory: %v", name, err)
+		}
+	}
+	if stacks[0].Router() == stacks[1].Router() {
+		t.Fatal("nodes share their router")
+	}
+	ch := make(chan *event.Event, 1)
+	sub := stacks[1].Router().Subscribe(nil, ch, event.RouterTestInt, 0)
+	defer sub.Unsubscribe()
+	stacks[0].Router().SendEvent(&event.Event{Typecode: event.RouterTestInt, Data: 1})
+	select {
+	case <-ch:
+		t.Fatal("event delivered to another node")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
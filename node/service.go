@@ -19,6 +19,7 @@ package node
 import (
 	"reflect"
 
+	"github.com/fractalplatform/fractal/event"
 	"github.com/fractalplatform/fractal/p2p"
 	adaptor "github.com/fractalplatform/fractal/p2p/protoadaptor"
 	"github.com/fractalplatform/fractal/rpc"
@@ -33,6 +34,7 @@ type ServiceContext struct {
 	config   *Config
 	services map[reflect.Type]Service // Index of the already constructed services
 	Wallet   *wallet.Wallet
+	Router   *event.Router // router of the node, shared by its services
 	P2P      *adaptor.ProtoAdaptor
 }
 
@@ -0,0 +1,222 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/p2p/enode"
+)
+
+// defaultBanDuration is used when a ban is requested without a duration.
+const defaultBanDuration = 24 * time.Hour
+
+// BanEntry is a single ban, keyed either by node ID or by IP address.
+type BanEntry struct {
+	ID      enode.ID  `json:"id"`
+	IP      net.IP    `json:"ip,omitempty"`
+	Reason  string    `json:"reason"`
+	Expires time.Time `json:"expires"`
+}
+
+func (e *BanEntry) expired(now time.Time) bool {
+	return !e.Expires.After(now)
+}
+
+// BanList keeps track of banned nodes and IP addresses. If a path is given
+// the list is persisted to that file on every change, so bans survive
+// restarts. Expired entries are dropped lazily.
+type BanList struct {
+	path   string
+	mutex  sync.RWMutex
+	saveMu sync.Mutex // serializes the snapshots and writes of the file
+	nodes  map[enode.ID]*BanEntry
+	ips    map[string]*BanEntry
+}
+
+// NewBanList creates a ban list, loading previously persisted bans from path.
+// An empty path creates an in-memory list.
+func NewBanList(path string) (*BanList, error) {
+	bl := &BanList{
+		path:  path,
+		nodes: make(map[enode.ID]*BanEntry),
+		ips:   make(map[string]*BanEntry),
+	}
+	if path == "" {
+		return bl, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return bl, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*BanEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, e := range entries {
+		if !e.expired(now) {
+			bl.add(e)
+		}
+	}
+	return bl, nil
+}
+
+func (bl *BanList) add(e *BanEntry) {
+	if e.ID != (enode.ID{}) {
+		bl.nodes[e.ID] = e
+	} else if e.IP != nil {
+		bl.ips[e.IP.String()] = e
+	}
+}
+
+// BanNode bans the given node ID for the duration d.
+func (bl *BanList) BanNode(id enode.ID, d time.Duration, reason string) {
+	bl.ban(&BanEntry{ID: id, Reason: reason}, d)
+}
+
+// BanIP bans all connections from the given IP address for the duration d.
+func (bl *BanList) BanIP(ip net.IP, d time.Duration, reason string) {
+	bl.ban(&BanEntry{IP: ip, Reason: reason}, d)
+}
+
+func (bl *BanList) ban(e *BanEntry, d time.Duration) {
+	if d <= 0 {
+		d = defaultBanDuration
+	}
+	e.Expires = time.Now().Add(d)
+	bl.mutex.Lock()
+	bl.add(e)
+	bl.mutex.Unlock()
+	bl.save()
+}
+
+// UnbanNode lifts the ban of the given node ID.
+func (bl *BanList) UnbanNode(id enode.ID) bool {
+	bl.mutex.Lock()
+	_, ok := bl.nodes[id]
+	delete(bl.nodes, id)
+	bl.mutex.Unlock()
+	if ok {
+		bl.save()
+	}
+	return ok
+}
+
+// UnbanIP lifts the ban of the given IP address.
+func (bl *BanList) UnbanIP(ip net.IP) bool {
+	bl.mutex.Lock()
+	_, ok := bl.ips[ip.String()]
+	delete(bl.ips, ip.String())
+	bl.mutex.Unlock()
+	if ok {
+		bl.save()
+	}
+	return ok
+}
+
+// IsBanned reports whether the node ID or the IP address is banned.
+func (bl *BanList) IsBanned(id enode.ID, ip net.IP) bool {
+	now := time.Now()
+	bl.mutex.RLock()
+	defer bl.mutex.RUnlock()
+	if e, ok := bl.nodes[id]; ok && !e.expired(now) {
+		return true
+	}
+	if ip != nil {
+		if e, ok := bl.ips[ip.String()]; ok && !e.expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries returns all bans which have not expired yet.
+func (bl *BanList) Entries() []*BanEntry {
+	now := time.Now()
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+	entries := make([]*BanEntry, 0, len(bl.nodes)+len(bl.ips))
+	for id, e := range bl.nodes {
+		if e.expired(now) {
+			delete(bl.nodes, id)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	for ip, e := range bl.ips {
+		if e.expired(now) {
+			delete(bl.ips, ip)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// save persists the bans. The snapshot and the write happen under the save
+// lock, so a concurrent save can't overwrite a newer list with an older one.
+func (bl *BanList) save() {
+	if bl.path == "" {
+		return
+	}
+	bl.saveMu.Lock()
+	defer bl.saveMu.Unlock()
+
+	data, err := json.Marshal(bl.Entries())
+	if err != nil {
+		log.Error("Failed to encode ban list", "err", err)
+		return
+	}
+	if err := writeBanFile(bl.path, data); err != nil {
+		log.Error("Failed to persist ban list", "err", err)
+	}
+}
+
+// writeBanFile writes the file atomically: the data goes to a temporary file
+// first, which is then moved into place, so a crash never leaves a partial
+// list behind.
+func writeBanFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// TempFile assigns mode 0600
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/p2p/enode"
+)
+
+func TestBanListPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "banlist.json")
+
+	id := enode.ID{1}
+	ip := net.ParseIP("10.0.0.1")
+	bl, err := NewBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl.BanNode(id, time.Hour, "test")
+	bl.BanIP(ip, time.Hour, "test")
+
+	bl, err = NewBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bl.IsBanned(id, nil) {
+		t.Error("node ban not restored")
+	}
+	if !bl.IsBanned(enode.ID{2}, ip) {
+		t.Error("ip ban not restored")
+	}
+	if len(bl.Entries()) != 2 {
+		t.Errorf("wrong number of entries: got %d, want 2", len(bl.Entries()))
+	}
+
+	if !bl.UnbanNode(id) || !bl.UnbanIP(ip) {
+		t.Fatal("unban failed")
+	}
+	bl, err = NewBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bl.IsBanned(id, ip) {
+		t.Error("ban still present after unban")
+	}
+}
+
+func TestBanListExpiry(t *testing.T) {
+	bl, _ := NewBanList("")
+	id := enode.ID{1}
+	bl.BanNode(id, 20*time.Millisecond, "test")
+	if !bl.IsBanned(id, nil) {
+		t.Fatal("node not banned")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if bl.IsBanned(id, nil) {
+		t.Error("ban did not expire")
+	}
+	if n := len(bl.Entries()); n != 0 {
+		t.Errorf("expired entry listed: got %d entries", n)
+	}
+}
+
+func TestServerBanRejectsConn(t *testing.T) {
+	srv := &Server{Config: Config{MaxPeers: 10, PrivateKey: newkey()}}
+	srv.banlist, _ = NewBanList("")
+	id := enode.ID{1}
+	c := &conn{flags: inboundConn, node: newNode(id, net.ParseIP("10.0.0.1"))}
+
+	srv.banlist.BanNode(id, time.Hour, "test")
+	if err := srv.encHandshakeChecks(nil, 0, c); err != DiscBanned {
+		t.Errorf("got %v, want %v", err, DiscBanned)
+	}
+	c.flags |= trustedConn
+	if err := srv.encHandshakeChecks(nil, 0, c); err != nil {
+		t.Errorf("trusted conn rejected: %v", err)
+	}
+}
+
+func TestBanListConcurrentSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "banlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "banlist.json")
+
+	bl, err := NewBanList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bl.BanNode(enode.ID{byte(i + 1)}, time.Hour, "test")
+			bl.BanIP(net.IPv4(10, 0, 0, byte(i)), time.Hour, "test")
+		}(i)
+	}
+	wg.Wait()
+
+	// The last save holds every ban and no temporary file is left behind.
+	restored, err := NewBanList(path)
+	if err != nil {
+		t.Fatalf("ban list corrupted: %v", err)
+	}
+	if n := len(restored.Entries()); n != 64 {
+		t.Errorf("wrong number of entries: got %d, want 64", n)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("temporary files left: got %d files, want 1", len(files))
+	}
+}
@@ -21,9 +21,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/p2p/enode"
 	"github.com/fractalplatform/fractal/p2p/netutil"
 )
@@ -33,6 +34,10 @@ const (
 	// redialing a certain node.
 	dialHistoryExpiration = 30 * time.Second
 
+	// Static nodes that keep failing are redialed with exponential
+	// backoff, up to this amount of time between attempts.
+	maxStaticDialBackoff = 10 * time.Minute
+
 	// Discovery lookups are throttled and can only run
 	// once every few seconds.
 	lookupInterval = 4 * time.Second
@@ -44,8 +49,25 @@ const (
 	// Endpoint resolution is throttled with bounded backoff.
 	initialResolveDelay = 60 * time.Second
 	maxResolveDelay     = time.Hour
+
+	// Dynamic dials are spread across /24 IPv4 and /48 IPv6 subnets, and the
+	// measured dial latencies of up to maxDialLatencies nodes are kept to
+	// spread them across latency buckets as well.
+	dialSubnet       = 24
+	dialSubnet6      = 48
+	maxDialLatencies = 1024
 )
 
+// dialLatencyBuckets are the upper bounds of the latency buckets used to
+// approximate the geographic distance of a node: same site, same region,
+// same continent and intercontinental. Slower nodes fall into a last bucket.
+var dialLatencyBuckets = [...]time.Duration{
+	25 * time.Millisecond,
+	75 * time.Millisecond,
+	150 * time.Millisecond,
+	300 * time.Millisecond,
+}
+
 // NodeDialer is used to connect to nodes in the network, typically by using
 // an underlying net.Dialer but also using net.Pipe in tests
 type NodeDialer interface {
@@ -71,11 +93,15 @@ type dialstate struct {
 	maxDynDials int
 	ntab        discoverTable
 	netrestrict *netutil.Netlist
+	banlist     *BanList // optional, nodes on the list are not dialed
+	subnetLimit int      // dynamic peers per subnet, zero for no limit
 
 	lookupRunning bool
 	dialing       map[enode.ID]connFlag
-	lookupBuf     []*enode.Node // current discovery lookup results
-	randomNodes   []*enode.Node // filled from Table
+	dynDialing    map[enode.ID]*enode.Node   // destinations of running dynamic dials
+	latencies     map[enode.ID]time.Duration // dial latencies of recently dialed nodes
+	lookupBuf     []*enode.Node              // current discovery lookup results
+	randomNodes   []*enode.Node              // filled from Table
 	static        map[enode.ID]*dialTask
 	hist          *dialHistory
 
@@ -111,6 +137,8 @@ type dialTask struct {
 	dest         *enode.Node
 	lastResolved time.Time
 	resolveDelay time.Duration
+	failures     int           // consecutive failed dials, used for static backoff
+	latency      time.Duration // connect time of the last successful dial
 }
 
 // discoverTask runs discovery table operations.
@@ -133,6 +161,8 @@ func newDialState(static []*enode.Node, bootnodes []*enode.Node, ntab discoverTa
 		netrestrict: netrestrict,
 		static:      make(map[enode.ID]*dialTask),
 		dialing:     make(map[enode.ID]connFlag),
+		dynDialing:  make(map[enode.ID]*enode.Node),
+		latencies:   make(map[enode.ID]time.Duration),
 		bootnodes:   make([]*enode.Node, len(bootnodes)),
 		randomNodes: make([]*enode.Node, maxdyn/2),
 		hist:        new(dialHistory),
@@ -164,12 +194,18 @@ func (s *dialstate) newTasks(nRunning int, peers map[enode.ID]*Peer, now time.Ti
 	}
 
 	var newtasks []task
+	div := s.diversity(peers)
 	addDial := func(flag connFlag, n *enode.Node) bool {
-		if err := s.checkDial(n, peers); err != nil {
+		err := s.checkDial(n, peers)
+		if err == nil && !div.add(n) {
+			err = errSubnetFull
+		}
+		if err != nil {
 			log.Trace("Skipping dial candidate", "id", n.ID(), "addr", &net.TCPAddr{IP: n.IP(), Port: n.TCP()}, "err", err)
 			return false
 		}
 		s.dialing[n.ID()] = flag
+		s.dynDialing[n.ID()] = n
 		newtasks = append(newtasks, &dialTask{flags: flag, dest: n})
 		return true
 	}
@@ -215,10 +251,11 @@ func (s *dialstate) newTasks(nRunning int, peers map[enode.ID]*Peer, now time.Ti
 		}
 	}
 	// Use random nodes from the table for half of the necessary
-	// dynamic dials.
+	// dynamic dials, preferring the ones that add the most diversity.
 	randomCandidates := needDynDials / 2
 	if randomCandidates > 0 {
 		n := s.ntab.ReadRandomNodes(s.randomNodes)
+		div.sort(s.randomNodes[:n])
 		for i := 0; i < randomCandidates && i < n; i++ {
 			if addDial(dynDialedConn, s.randomNodes[i]) {
 				needDynDials--
@@ -227,6 +264,7 @@ func (s *dialstate) newTasks(nRunning int, peers map[enode.ID]*Peer, now time.Ti
 	}
 	// Create dynamic dials from random lookup results, removing tried
 	// items from the result buffer.
+	div.sort(s.lookupBuf)
 	i := 0
 	for ; i < len(s.lookupBuf) && needDynDials > 0; i++ {
 		if addDial(dynDialedConn, s.lookupBuf[i]) {
@@ -257,6 +295,8 @@ var (
 	errAlreadyConnected = errors.New("already connected")
 	errRecentlyDialed   = errors.New("recently dialed")
 	errNotWhitelisted   = errors.New("not contained in netrestrict whitelist")
+	errBanned           = errors.New("banned")
+	errSubnetFull       = errors.New("too many dynamic peers in subnet")
 )
 
 func (s *dialstate) checkDial(n *enode.Node, peers map[enode.ID]*Peer) error {
@@ -270,17 +310,136 @@ func (s *dialstate) checkDial(n *enode.Node, peers map[enode.ID]*Peer) error {
 		return errSelf
 	case s.netrestrict != nil && !s.netrestrict.Contains(n.IP()):
 		return errNotWhitelisted
+	case s.banlist != nil && s.banlist.IsBanned(n.ID(), n.IP()):
+		return errBanned
 	case s.hist.contains(n.ID()):
 		return errRecentlyDialed
 	}
 	return nil
 }
 
+// addLatency records the dial latency of a node, evicting an arbitrary
+// entry when the table is full.
+func (s *dialstate) addLatency(id enode.ID, d time.Duration) {
+	if _, ok := s.latencies[id]; !ok && len(s.latencies) >= maxDialLatencies {
+		for old := range s.latencies {
+			delete(s.latencies, old)
+			break
+		}
+	}
+	s.latencies[id] = d
+}
+
+// diversity collects the subnets and latency buckets of the dynamically
+// dialed peers and the running dynamic dials.
+func (s *dialstate) diversity(peers map[enode.ID]*Peer) *dialDiversity {
+	d := &dialDiversity{
+		subnets:   netutil.DistinctNetSet{Subnet: dialSubnet, Limit: ^uint(0)},
+		subnets6:  netutil.DistinctNetSet{Subnet: dialSubnet6, Limit: ^uint(0)},
+		latencies: s.latencies,
+	}
+	if s.subnetLimit > 0 {
+		d.subnets.Limit = uint(s.subnetLimit)
+		d.subnets6.Limit = uint(s.subnetLimit)
+	}
+	for _, p := range peers {
+		if p.rw.is(dynDialedConn) {
+			d.add(p.Node())
+		}
+	}
+	for _, n := range s.dynDialing {
+		d.add(n)
+	}
+	return d
+}
+
+// dialDiversity tracks how the dynamic connections are spread over subnets
+// and latency buckets. Nodes on a LAN or without a known IP are not
+// assigned a subnet, nodes that were never dialed have no latency bucket.
+type dialDiversity struct {
+	subnets   netutil.DistinctNetSet           // IPv4 subnets
+	subnets6  netutil.DistinctNetSet           // IPv6 subnets
+	buckets   [len(dialLatencyBuckets) + 1]int // connections per latency bucket
+	known     int                              // connections with a known latency bucket
+	latencies map[enode.ID]time.Duration
+}
+
+// add accounts for a new connection to n. It returns false if the subnet
+// of n already holds the maximum number of connections.
+func (d *dialDiversity) add(n *enode.Node) bool {
+	if ip := n.IP(); hasSubnet(ip) && !d.subnetsOf(ip).Add(ip) {
+		return false
+	}
+	if b := d.bucket(n); b >= 0 {
+		d.buckets[b]++
+		d.known++
+	}
+	return true
+}
+
+// bucket returns the latency bucket of n, or -1 if it is unknown.
+func (d *dialDiversity) bucket(n *enode.Node) int {
+	latency, ok := d.latencies[n.ID()]
+	if !ok {
+		return -1
+	}
+	for i, limit := range dialLatencyBuckets {
+		if latency < limit {
+			return i
+		}
+	}
+	return len(dialLatencyBuckets)
+}
+
+// sort orders dial candidates so that nodes from subnets without connections
+// come first, followed by the ones from the least used latency buckets.
+// Candidates adding the same diversity keep their order.
+func (d *dialDiversity) sort(nodes []*enode.Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		ci, cj := d.crowded(nodes[i]), d.crowded(nodes[j])
+		if ci != cj {
+			return cj
+		}
+		return d.share(nodes[i]) < d.share(nodes[j])
+	})
+}
+
+// crowded reports whether the subnet of n already has connections.
+func (d *dialDiversity) crowded(n *enode.Node) bool {
+	ip := n.IP()
+	return hasSubnet(ip) && d.subnetsOf(ip).Contains(ip)
+}
+
+// subnetsOf returns the subnets of the IP version of ip.
+func (d *dialDiversity) subnetsOf(ip net.IP) *netutil.DistinctNetSet {
+	if ip.To4() != nil {
+		return &d.subnets
+	}
+	return &d.subnets6
+}
+
+// share returns the number of connections in the latency bucket of n. Nodes
+// of unknown latency are assumed to land in an average bucket.
+func (d *dialDiversity) share(n *enode.Node) float64 {
+	if b := d.bucket(n); b >= 0 {
+		return float64(d.buckets[b])
+	}
+	return float64(d.known) / float64(len(d.buckets))
+}
+
+func hasSubnet(ip net.IP) bool {
+	return ip != nil && !ip.IsUnspecified() && !netutil.IsLAN(ip)
+}
+
 func (s *dialstate) taskDone(t task, now time.Time) {
 	switch t := t.(type) {
 	case *dialTask:
-		s.hist.add(t.dest.ID(), now.Add(dialHistoryExpiration))
+		s.hist.add(t.dest.ID(), now.Add(t.redialDelay()))
 		delete(s.dialing, t.dest.ID())
+		delete(s.dynDialing, t.dest.ID())
+		if t.latency > 0 {
+			s.addLatency(t.dest.ID(), t.latency)
+		}
 	case *discoverTask:
 		s.lookupRunning = false
 		s.lookupBuf = append(s.lookupBuf, t.results...)
@@ -299,10 +458,32 @@ func (t *dialTask) Do(srv *Server) {
 		// Try resolving the ID of static nodes if dialing failed.
 		if _, ok := err.(*dialError); ok && t.flags&staticDialedConn != 0 {
 			if t.resolve(srv) {
-				t.dial(srv, t.dest)
+				err = t.dial(srv, t.dest)
 			}
 		}
 	}
+	if err != nil {
+		t.failures++
+	} else {
+		t.failures = 0
+	}
+}
+
+// redialDelay returns how long the destination stays in the dial history.
+// Static nodes back off exponentially while they keep failing, so an
+// unreachable static peer is retried forever without hammering it.
+func (t *dialTask) redialDelay() time.Duration {
+	delay := dialHistoryExpiration
+	if t.flags&staticDialedConn == 0 {
+		return delay
+	}
+	for i := 0; i < t.failures && delay < maxStaticDialBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxStaticDialBackoff {
+		delay = maxStaticDialBackoff
+	}
+	return delay
 }
 
 // resolve attempts to find the current endpoint for the destination
@@ -345,10 +526,12 @@ type dialError struct {
 
 // dial performs the actual connection attempt.
 func (t *dialTask) dial(srv *Server, dest *enode.Node) error {
+	start := time.Now()
 	fd, err := srv.Dialer.Dial(dest)
 	if err != nil {
 		return &dialError{err}
 	}
+	t.latency = time.Since(start)
 	mfd := newMeteredConn(fd, false)
 	return srv.SetupConn(mfd, t.flags, dest)
 }
@@ -665,6 +665,115 @@ func TestDialResolve(t *testing.T) {
 	}
 }
 
+func TestDialStaticBackoff(t *testing.T) {
+	state := newDialState(nil, nil, fakeTable{}, 0, nil)
+	dest := newNode(uintID(1), net.IP{127, 0, 0, 1})
+	state.addStatic(dest)
+	task := state.static[dest.ID()]
+
+	// A static task that never failed is redialed after the default expiration.
+	if d := task.redialDelay(); d != dialHistoryExpiration {
+		t.Fatalf("wrong initial delay: got %v, want %v", d, dialHistoryExpiration)
+	}
+	// Consecutive failures double the delay up to the cap.
+	want := dialHistoryExpiration
+	for i := 1; i <= 10; i++ {
+		task.failures = i
+		want *= 2
+		if want > maxStaticDialBackoff {
+			want = maxStaticDialBackoff
+		}
+		if d := task.redialDelay(); d != want {
+			t.Fatalf("failures %d: got delay %v, want %v", i, d, want)
+		}
+	}
+	// The dial history must honor the backoff.
+	now := time.Now()
+	state.dialing[dest.ID()] = task.flags
+	state.taskDone(task, now)
+	if !state.hist.contains(dest.ID()) || state.hist.min().exp != now.Add(maxStaticDialBackoff) {
+		t.Fatalf("dial history not backed off: %v", spew.Sdump(state.hist))
+	}
+	// Dynamic dials are not affected by failures.
+	dyn := &dialTask{flags: dynDialedConn, dest: dest, failures: 5}
+	if d := dyn.redialDelay(); d != dialHistoryExpiration {
+		t.Fatalf("dynamic dial delay: got %v, want %v", d, dialHistoryExpiration)
+	}
+}
+
+// This test checks that dynamic dials prefer candidates from unused subnets
+// and latency buckets, and respect the subnet limit.
+func TestDialDiversity(t *testing.T) {
+	dests := func(tasks []task) (ids []uint32) {
+		for _, t := range tasks {
+			if dt, ok := t.(*dialTask); ok {
+				ids = append(ids, binary.BigEndian.Uint32(dt.dest.ID().Bytes()))
+			}
+		}
+		return ids
+	}
+
+	// Subnets: the crowded subnet is dialed last and only up to the limit,
+	// LAN addresses are not limited.
+	state := newDialState(nil, nil, fakeTable{}, 10, nil)
+	state.subnetLimit = 2
+	state.lookupBuf = []*enode.Node{
+		newNode(uintID(1), net.IP{1, 2, 3, 1}),
+		newNode(uintID(2), net.IP{1, 2, 3, 2}),
+		newNode(uintID(3), net.IP{5, 6, 7, 8}),
+		newNode(uintID(4), net.IP{10, 0, 0, 1}),
+		newNode(uintID(5), net.IP{10, 0, 0, 2}),
+	}
+	peers := map[enode.ID]*Peer{
+		uintID(9): {rw: &conn{flags: dynDialedConn, node: newNode(uintID(9), net.IP{1, 2, 3, 9})}},
+	}
+	if got, want := dests(state.newTasks(0, peers, time.Now())), []uint32{3, 4, 5, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong subnet dial order: got %v, want %v", got, want)
+	}
+
+	// IPv6 nodes are spread across /48 subnets.
+	state = newDialState(nil, nil, fakeTable{}, 10, nil)
+	state.subnetLimit = 1
+	state.lookupBuf = []*enode.Node{
+		newNode(uintID(1), net.ParseIP("2001:db8:1:1::1")),
+		newNode(uintID(2), net.ParseIP("2001:db8:1:2::1")),
+		newNode(uintID(3), net.ParseIP("2001:db8:2:1::1")),
+	}
+	if got, want := dests(state.newTasks(0, nil, time.Now())), []uint32{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong IPv6 subnet dial order: got %v, want %v", got, want)
+	}
+
+	// Latency buckets: nodes from empty buckets come first, nodes of unknown
+	// latency count as an average bucket.
+	state = newDialState(nil, nil, fakeTable{}, 10, nil)
+	state.latencies[uintID(1)] = 10 * time.Millisecond
+	state.latencies[uintID(3)] = 100 * time.Millisecond
+	state.latencies[uintID(8)] = 10 * time.Millisecond
+	state.latencies[uintID(9)] = 20 * time.Millisecond
+	state.lookupBuf = []*enode.Node{
+		newNode(uintID(1), net.IP{1, 1, 1, 1}),
+		newNode(uintID(2), net.IP{2, 2, 2, 2}),
+		newNode(uintID(3), net.IP{3, 3, 3, 3}),
+	}
+	peers = map[enode.ID]*Peer{
+		uintID(8): {rw: &conn{flags: dynDialedConn, node: newNode(uintID(8), net.IP{8, 8, 8, 8})}},
+		uintID(9): {rw: &conn{flags: dynDialedConn, node: newNode(uintID(9), net.IP{9, 9, 9, 9})}},
+	}
+	if got, want := dests(state.newTasks(0, peers, time.Now())), []uint32{3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong latency dial order: got %v, want %v", got, want)
+	}
+
+	// Completed dials record their latency.
+	task := &dialTask{flags: dynDialedConn, dest: newNode(uintID(2), nil), latency: 40 * time.Millisecond}
+	state.taskDone(task, time.Now())
+	if d := state.latencies[uintID(2)]; d != 40*time.Millisecond {
+		t.Fatalf("latency not recorded: got %v", d)
+	}
+	if _, ok := state.dynDialing[uintID(2)]; ok {
+		t.Fatal("finished dial still tracked")
+	}
+}
+
 // compares task lists but doesn't care about the order.
 func sametasks(a, b []task) bool {
 	if len(a) != len(b) {
@@ -257,6 +257,27 @@ func TestTable_ReadRandomNodesGetAll(t *testing.T) {
 	}
 }
 
+func TestTable_Nodes(t *testing.T) {
+	transport := newPingRecorder()
+	tab, db := newTestTable(transport)
+	defer tab.Close()
+	defer db.Close()
+
+	if nodes := tab.Nodes(); len(nodes) != 0 {
+		t.Fatalf("empty table returned %d nodes", len(nodes))
+	}
+	for i := 1; i <= 5; i++ {
+		tab.stuff([]*node{nodeAtDistance(tab.self.ID(), 250+i, intIP(i))})
+	}
+	nodes := tab.Nodes()
+	if len(nodes) != tab.len() {
+		t.Fatalf("wrong number of nodes, got %d, want %d", len(nodes), tab.len())
+	}
+	if hasDuplicates(wrapNodes(nodes)) {
+		t.Errorf("result contains duplicates")
+	}
+}
+
 type closeTest struct {
 	Self   enode.ID
 	Target enode.ID
@@ -1,12 +1,12 @@
 package protoadaptor
 
 import (
-	"reflect"
+	"errors"
 
-	"github.com/ethereum/go-ethereum/log"
 	router "github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/p2p"
-	"github.com/fractalplatform/fractal/utils/rlp"
+	"github.com/fractalplatform/fractal/utils/supervisor"
 )
 
 type pack struct {
@@ -14,24 +14,49 @@ type pack struct {
 	To       string
 	Typecode uint32
 	Payload  []byte
+	Version  []uint `rlp:"tail"` // payload version, omitted for version 0
 }
 
+// syncSendQueue is the number of sync messages queued per peer while their
+// upload is throttled.
+const syncSendQueue = 64
+
+// errSyncQueueFull is returned for the sync messages to a peer whose queue of
+// throttled messages is full.
+var errSyncQueueFull = errors.New("sync message queue of the peer is full")
+
 type remotePeer struct {
-	peer *p2p.Peer
-	ws   p2p.MsgReadWriter
+	peer     *p2p.Peer
+	ws       p2p.MsgReadWriter
+	upload   *tokenBucket
+	download *tokenBucket
+	syncq    chan *pack    // throttled sync messages to send
+	quit     chan struct{} // closed once the peer is gone
 }
 
 // ProtoAdaptor is subprotocol on p2p
 type ProtoAdaptor struct {
 	p2p.Server
 	peerMangaer
-	event   chan *router.Event
-	station router.Station
+	router   *router.Router // router of the node, the default one if nil
+	event    chan *router.Event
+	station  router.Station
+	upload   *tokenBucket // global rate limits of sync traffic
+	download *tokenBucket
+	quit     chan struct{} // closed once the adaptor stops
 }
 
 // NewProtoAdaptor return new ProtoAdaptor
 func NewProtoAdaptor(config *p2p.Config) *ProtoAdaptor {
+	return NewProtoAdaptorWithRouter(nil, config)
+}
+
+// NewProtoAdaptorWithRouter returns a ProtoAdaptor exchanging the events of
+// the remotes through the router instead of the default one, letting several
+// nodes run in a process.
+func NewProtoAdaptorWithRouter(r *router.Router, config *p2p.Config) *ProtoAdaptor {
 	adaptor := &ProtoAdaptor{
+		router: r,
 		Server: p2p.Server{
 			Config: *config,
 		},
@@ -39,8 +64,11 @@ func NewProtoAdaptor(config *p2p.Config) *ProtoAdaptor {
 			activePeers: make(map[[8]byte]*remotePeer),
 			station:     nil,
 		},
-		event:   make(chan *router.Event),
-		station: router.NewLocalStation("p2p", nil),
+		event:    make(chan *router.Event),
+		station:  router.NewLocalStation("p2p", nil),
+		upload:   newTokenBucket(config.MaxUploadRate),
+		download: newTokenBucket(config.MaxDownloadRate),
+		quit:     make(chan struct{}),
 	}
 	adaptor.peerMangaer.station = router.NewBroadcastStation("broadcast", &adaptor.peerMangaer)
 	adaptor.Server.Config.Protocols = adaptor.Protocols()
@@ -49,37 +77,62 @@ func NewProtoAdaptor(config *p2p.Config) *ProtoAdaptor {
 
 // Start start p2p protocol adaptor
 func (adaptor *ProtoAdaptor) Start() error {
-	router.StationRegister(adaptor.peerMangaer.station)
-	router.AdaptorRegister(adaptor)
-	router.Subscribe(nil, adaptor.event, router.P2pDisconectPeer, nil)
-	go adaptor.adaptorEvent()
+	adaptor.router.StationRegister(adaptor.peerMangaer.station)
+	adaptor.router.AdaptorRegister(adaptor)
+	adaptor.router.Subscribe(nil, adaptor.event, router.P2pDisconectPeer, nil)
+	adaptor.router.Subscribe(nil, adaptor.event, router.P2pBanPeer, nil)
+	supervisor.Go("p2p events", adaptor.quit, adaptor.adaptorEvent)
 	return adaptor.Server.Start()
 }
 
 func (adaptor *ProtoAdaptor) adaptorEvent() {
 	for {
-		e := <-adaptor.event
+		var e *router.Event
+		select {
+		case e = <-adaptor.event:
+		case <-adaptor.quit:
+			return
+		}
 		switch e.Typecode {
 		case router.P2pDisconectPeer:
 			peer := e.Data.(router.Station).Data().(*remotePeer)
 			peer.peer.Disconnect(p2p.DiscSubprotocolError)
 			//peer.Disconnect(DiscSubprotocolError)
+		case router.P2pBanPeer:
+			peer := e.Data.(router.Station).Data().(*remotePeer)
+			adaptor.banPeer(peer.peer, "misbehaving peer")
 		}
 	}
 }
 
+// banPeer bans the remote peer. Trusted peers can't be banned and are
+// disconnected only.
+func (adaptor *ProtoAdaptor) banPeer(peer *p2p.Peer, reason string) {
+	if err := adaptor.Server.BanPeer(peer.Node(), 0, reason); err != nil {
+		log.Debug("Failed to ban peer", "id", peer.ID(), "err", err)
+		peer.Disconnect(p2p.DiscSubprotocolError)
+	}
+}
+
 func (adaptor *ProtoAdaptor) adaptorLoop(peer *p2p.Peer, ws p2p.MsgReadWriter) error {
-	remote := remotePeer{ws: ws, peer: peer}
+	remote := remotePeer{
+		ws:       ws,
+		peer:     peer,
+		upload:   newTokenBucket(adaptor.Server.PeerUploadRate),
+		download: newTokenBucket(adaptor.Server.PeerDownloadRate),
+		syncq:    make(chan *pack, syncSendQueue),
+		quit:     make(chan struct{}),
+	}
+	supervisor.Go("p2p sync sender", remote.quit, func() { adaptor.syncSender(&remote) })
 	station := router.NewRemoteStation(string(remote.peer.ID().Bytes()[:8]), &remote)
 	adaptor.peerMangaer.addActivePeer(&remote)
-	router.StationRegister(station)
-	e, _ := pack2event(&pack{Typecode: uint32(router.P2pNewPeer)}, station)
-	router.SendEvent(e)
+	adaptor.router.StationRegister(station)
+	adaptor.router.SendEvent(&router.Event{From: station, Typecode: router.P2pNewPeer})
 	defer func() {
+		close(remote.quit)
 		adaptor.peerMangaer.delActivePeer(&remote)
-		router.StationUnregister(station)
-		e, _ := pack2event(&pack{Typecode: uint32(router.P2pDelPeer)}, station)
-		router.SendEvent(e)
+		adaptor.router.StationUnregister(station)
+		adaptor.router.SendEvent(&router.Event{From: station, Typecode: router.P2pDelPeer})
 	}()
 
 	for {
@@ -89,12 +142,23 @@ func (adaptor *ProtoAdaptor) adaptorLoop(peer *p2p.Peer, ws p2p.MsgReadWriter) e
 		}
 		pack := pack{}
 		if err := msg.Decode(&pack); err != nil {
+			adaptor.banPeer(peer, "undecodable message")
+			return err
+		}
+		e, err := pack2event(adaptor.router, &pack, station)
+		if err == router.ErrUnknownPayload || err == router.ErrPayloadVersion {
+			log.Debug("Unexpected message", "id", peer.ID(), "typecode", pack.Typecode, "err", err)
+			peer.Disconnect(p2p.DiscProtocolError)
 			return err
 		}
-		e, err := pack2event(&pack, station)
 		if err != nil {
+			adaptor.banPeer(peer, "undecodable message")
 			return err
 		}
+		if isSyncMsg(e.Typecode) {
+			// stop reading from the peer until the download budget allows it.
+			throttle(int(msg.Size), remote.download, adaptor.download)
+		}
 		// if e.Typecode == 15 {
 		// 	data := e.Data.([]*types.Transaction)
 		// 	for _, tx := range data {
@@ -102,7 +166,7 @@ func (adaptor *ProtoAdaptor) adaptorLoop(peer *p2p.Peer, ws p2p.MsgReadWriter) e
 		// 		log.Info(fmt.Sprintf("huyl Recieve Hash:%s from remote station:%x", tx.Hash().String(), []byte(e.From.Name())))
 		// 	}
 		// }
-		go router.SendEvent(e)
+		go adaptor.router.SendEvent(e)
 		//peer.Disconnect(DiscSubprotocolError)
 	}
 }
@@ -121,6 +185,7 @@ func (adaptor *ProtoAdaptor) Protocols() []p2p.Protocol {
 
 // Stop .
 func (adaptor *ProtoAdaptor) Stop() {
+	close(adaptor.quit)
 	adaptor.Server.Stop()
 	log.Info("P2P networking stopped")
 }
@@ -139,7 +204,34 @@ func (adaptor *ProtoAdaptor) msgSend(e *router.Event) error {
 	if err != nil {
 		return err
 	}
-	return p2p.Send(e.To.Data().(*remotePeer).ws, 0, pack)
+	peer := e.To.Data().(*remotePeer)
+	if isSyncMsg(e.Typecode) && (peer.upload != nil || adaptor.upload != nil) {
+		// SendOut runs under the lock of the router, the throttled
+		// messages wait in the queue of the peer instead.
+		select {
+		case peer.syncq <- pack:
+			return nil
+		default:
+			return errSyncQueueFull
+		}
+	}
+	return p2p.Send(peer.ws, 0, pack)
+}
+
+// syncSender sends the queued sync messages to the peer as the upload limits
+// allow, until the peer is gone.
+func (adaptor *ProtoAdaptor) syncSender(peer *remotePeer) {
+	for {
+		select {
+		case pack := <-peer.syncq:
+			throttle(len(pack.Payload), peer.upload, adaptor.upload)
+			if err := p2p.Send(peer.ws, 0, pack); err != nil {
+				log.Debug("Failed to send sync message", "id", peer.peer.ID(), "err", err)
+			}
+		case <-peer.quit:
+			return
+		}
+	}
 }
 
 func (adaptor *ProtoAdaptor) msgBroadcast(e *router.Event) {
@@ -175,7 +267,7 @@ func (adaptor *ProtoAdaptor) msgBroadcast(e *router.Event) {
 }
 
 func event2pack(e *router.Event) (*pack, error) {
-	buf, err := rlp.EncodeToBytes(e.Data)
+	buf, err := router.EncodePayload(e.Typecode, e.Data)
 	if err != nil {
 		return nil, err
 	}
@@ -187,43 +279,39 @@ func event2pack(e *router.Event) (*pack, error) {
 	if e.To != nil {
 		to = e.To.Name()[8:]
 	}
-	return &pack{
+	pack := &pack{
 		From:     from,
 		To:       to,
 		Typecode: uint32(e.Typecode),
 		Payload:  buf,
-	}, nil
+	}
+	if version := router.PayloadVersionOf(e.Typecode, e.Data); version != 0 {
+		pack.Version = []uint{version}
+	}
+	return pack, nil
 }
 
-func pack2event(pack *pack, station router.Station) (*router.Event, error) {
-	var elem interface{}
-
-	isPtr := false
-	typ := router.GetTypeByCode(int(pack.Typecode))
-	if typ == nil {
-		elem = pack.Payload
-	} else {
-		//for typ.Kind() == reflect.Ptr {
-		if typ.Kind() == reflect.Ptr {
-			isPtr = true
-			typ = typ.Elem()
-		}
-		obj := reflect.New(typ)
-		if err := rlp.DecodeBytes(pack.Payload, obj.Interface()); err != nil {
-			return nil, err
-		}
-		if isPtr {
-			elem = obj.Interface()
-		} else {
-			elem = obj.Elem().Interface()
-		}
+// pack2event decodes a message received from a remote station. Messages of
+// typecodes not exchanged between nodes, or whose payload isn't of the
+// registered version and shape, are rejected. The local station addressed is
+// looked up in the router r.
+func pack2event(r *router.Router, pack *pack, station router.Station) (*router.Event, error) {
+	var version uint
+	if len(pack.Version) > 1 {
+		return nil, router.ErrInvalidPayload
+	} else if len(pack.Version) == 1 {
+		version = pack.Version[0]
+	}
+	elem, err := router.DecodePayload(int(pack.Typecode), version, pack.Payload)
+	if err != nil {
+		return nil, err
 	}
 	if pack.From != "" {
 		station = router.NewRemoteStation(station.Name()+pack.From, station.Data())
 	}
 	return &router.Event{
 		From:     station,
-		To:       router.GetStationByName(pack.To),
+		To:       r.GetStationByName(pack.To),
 		Typecode: int(pack.Typecode),
 		Data:     elem,
 	}, nil
@@ -0,0 +1,78 @@
+package protoadaptor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
+	router "github.com/fractalplatform/fractal/event"
+)
+
+func TestPackEvent(t *testing.T) {
+	router.InitRounter()
+	router.RegisterPayload(router.TxHashMsg, 0, []common.Hash{}, nil)
+	router.RegisterPayload(router.GetTxsMsg, 1, []common.Hash{}, nil)
+	station := router.NewRemoteStation("remote", nil)
+
+	hashes := []common.Hash{{1}, {2}}
+	for _, typecode := range []int{router.TxHashMsg, router.GetTxsMsg} {
+		pack, err := event2pack(&router.Event{Typecode: typecode, Data: hashes})
+		if err != nil {
+			t.Fatalf("typecode %d: failed to pack event: %v", typecode, err)
+		}
+		e, err := pack2event(nil, pack, station)
+		if err != nil {
+			t.Fatalf("typecode %d: failed to unpack event: %v", typecode, err)
+		}
+		if have, ok := e.Data.([]common.Hash); !ok || len(have) != 2 || have[1] != hashes[1] {
+			t.Fatalf("typecode %d: data mismatch: have %v, want %v", typecode, e.Data, hashes)
+		}
+	}
+
+	// version 0 payloads are packed as before versions were introduced
+	pack, _ := event2pack(&router.Event{Typecode: router.TxHashMsg, Data: hashes})
+	if pack.Version != nil {
+		t.Fatalf("version packed for version 0: %v", pack.Version)
+	}
+	pack, _ = event2pack(&router.Event{Typecode: router.GetTxsMsg, Data: hashes})
+	pack.Version = nil
+	if _, err := pack2event(nil, pack, station); err != router.ErrPayloadVersion {
+		t.Fatalf("other version: error mismatch: have %v, want %v", err, router.ErrPayloadVersion)
+	}
+	// local only events are never accepted from remote stations
+	pack.Typecode = uint32(router.P2pBanPeer)
+	if _, err := pack2event(nil, pack, station); err != router.ErrUnknownPayload {
+		t.Fatalf("local event: error mismatch: have %v, want %v", err, router.ErrUnknownPayload)
+	}
+	pack.Typecode = uint32(router.TxHashMsg)
+	pack.Payload = []byte{0xc1, 0x01}
+	if _, err := pack2event(nil, pack, station); err == nil {
+		t.Fatal("malformed payload accepted")
+	}
+}
+
+func TestThrottledSendQueued(t *testing.T) {
+	router.RegisterPayload(router.BlockHashMsg, 0, []common.Hash{}, nil)
+	adaptor := &ProtoAdaptor{upload: newTokenBucket(1)}
+	peer := &remotePeer{syncq: make(chan *pack, syncSendQueue), quit: make(chan struct{})}
+	to := router.NewRemoteStation("remotepeer", peer)
+	hashes := []common.Hash{common.HexToHash("0x01")}
+
+	// sync messages over the limit are queued instead of sent, without
+	// waiting in SendOut
+	start := time.Now()
+	for i := 0; i < syncSendQueue; i++ {
+		if err := adaptor.msgSend(&router.Event{To: to, Typecode: router.BlockHashMsg, Data: hashes}); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	if err := adaptor.msgSend(&router.Event{To: to, Typecode: router.BlockHashMsg, Data: hashes}); err != errSyncQueueFull {
+		t.Fatalf("full queue error mismatch: have %v, want %v", err, errSyncQueueFull)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("throttled sends waited %v", elapsed)
+	}
+	if len(peer.syncq) != syncSendQueue {
+		t.Fatalf("%d messages queued, want %d", len(peer.syncq), syncSendQueue)
+	}
+}
@@ -0,0 +1,72 @@
+package protoadaptor
+
+import (
+	"sync"
+	"time"
+
+	router "github.com/fractalplatform/fractal/event"
+)
+
+// tokenBucket is a byte rate limiter. Tokens are refilled at rate bytes per
+// second up to a burst of one second worth of traffic. A nil bucket doesn't
+// limit anything.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// reserve takes n bytes from the bucket and returns how long the caller has
+// to wait until the bytes are covered. Messages larger than the burst drive
+// the bucket negative, delaying the following ones.
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttle reserves n bytes in all given buckets and sleeps until the most
+// limiting of them allows the transfer.
+func throttle(n int, buckets ...*tokenBucket) {
+	now := time.Now()
+	var wait time.Duration
+	for _, b := range buckets {
+		if d := b.reserve(n, now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// isSyncMsg reports whether messages of the given type carry chain data served
+// to or fetched by the downloader. Only these are throttled, block and
+// transaction propagation is never delayed.
+func isSyncMsg(typecode int) bool {
+	switch typecode {
+	case router.BlockHashMsg, router.BlockHeadersMsg, router.BlockBodiesMsg, router.BlockTxChunkMsg:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package protoadaptor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{rate: 1000, tokens: 1000, last: now}
+
+	if d := b.reserve(1000, now); d != 0 {
+		t.Fatalf("burst delayed: %v", d)
+	}
+	if d := b.reserve(500, now); d != 500*time.Millisecond {
+		t.Fatalf("wait mismatch: have %v, want %v", d, 500*time.Millisecond)
+	}
+	// after a second the debt is paid and the bucket refilled by half.
+	now = now.Add(time.Second)
+	if d := b.reserve(500, now); d != 0 {
+		t.Fatalf("refilled bucket delayed: %v", d)
+	}
+	if d := newTokenBucket(0).reserve(1<<30, now); d != 0 {
+		t.Fatalf("unlimited bucket delayed: %v", d)
+	}
+}
@@ -20,6 +20,7 @@ package p2p
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
@@ -27,10 +28,10 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/crypto"
 	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/p2p/discover"
 	"github.com/fractalplatform/fractal/p2p/enode"
 	"github.com/fractalplatform/fractal/p2p/netutil"
@@ -43,6 +44,7 @@ const (
 	maxActiveDialTasks     = 16
 	defaultMaxPendingPeers = 50
 	defaultDialRatio       = 3
+	defaultMaxSubnetDials  = 2
 
 	// Maximum time allowed for reading a complete message.
 	// This is effectively the amount of time a connection can be idle.
@@ -73,6 +75,29 @@ type Config struct {
 	// Setting DialRatio to zero defaults it to 3.
 	DialRatio int `mapstructure:"p2p-dialratio"`
 
+	// MaxInboundPeers is the maximum number of inbound connections. Zero
+	// reserves every slot that isn't used for dialing.
+	MaxInboundPeers int `mapstructure:"p2p-maxinbound"`
+
+	// MaxOutboundPeers is the maximum number of dynamically dialed
+	// connections. Zero derives the value from MaxPeers and DialRatio.
+	// Static and trusted connections are not counted against it.
+	MaxOutboundPeers int `mapstructure:"p2p-maxoutbound"`
+
+	// MaxSubnetDials is the maximum number of dynamically dialed peers
+	// in the same /24 subnet. Setting it to zero defaults it to 2, a
+	// negative value removes the limit. LAN addresses are exempt.
+	MaxSubnetDials int `mapstructure:"p2p-maxsubnetdials"`
+
+	// MaxUploadRate and MaxDownloadRate limit the bandwidth in bytes per
+	// second used for serving and fetching chain data, across all peers.
+	// PeerUploadRate and PeerDownloadRate limit it per peer. Zero means
+	// unlimited. Block and transaction propagation is never throttled.
+	MaxUploadRate    int `mapstructure:"p2p-maxupload"`
+	MaxDownloadRate  int `mapstructure:"p2p-maxdownload"`
+	PeerUploadRate   int `mapstructure:"p2p-peerupload"`
+	PeerDownloadRate int `mapstructure:"p2p-peerdownload"`
+
 	// NoDiscovery can be used to disable the peer discovery mechanism.
 	// Disabling is useful for protocol debugging (manual topology).
 	NoDiscovery bool `mapstructure:"p2p-nodiscover"`
@@ -102,6 +127,14 @@ type Config struct {
 	// live nodes in the network.
 	NodeDatabase string `mapstructure:"p2p-nodedb"`
 
+	// BanList is the path of the file the peer ban list is persisted to.
+	// If empty, bans are kept in memory only.
+	BanList string `mapstructure:"p2p-banlist"`
+
+	// BanDuration is the default time a misbehaving peer stays banned.
+	// Zero defaults to defaultBanDuration.
+	BanDuration time.Duration `mapstructure:"p2p-banduration"`
+
 	// Protocols should contain the protocols supported
 	// by the server. Matching protocols are launched for
 	// each peer.
@@ -169,6 +202,11 @@ type Server struct {
 	loopWG        sync.WaitGroup // loop, listenLoop
 	peerFeed      event.Feed
 	log           log.Logger
+
+	trustedLock sync.RWMutex // protects trusted
+	trusted     map[enode.ID]*enode.Node
+
+	banlist *BanList
 }
 
 type peerOpFunc func(map[enode.ID]*Peer)
@@ -327,6 +365,117 @@ func (srv *Server) RemoveTrustedPeer(node *enode.Node) {
 	}
 }
 
+// DiscoveredNodes returns the nodes currently known to the discovery table.
+// It returns nil if discovery is disabled or the server is not running.
+func (srv *Server) DiscoveredNodes() []*enode.Node {
+	srv.lock.Lock()
+	ntab := srv.ntab
+	srv.lock.Unlock()
+
+	if tab, ok := ntab.(*discover.Table); ok {
+		return tab.Nodes()
+	}
+	return nil
+}
+
+// TrustedPeers returns the nodes currently in the trusted peer set.
+func (srv *Server) TrustedPeers() []*enode.Node {
+	srv.trustedLock.RLock()
+	defer srv.trustedLock.RUnlock()
+	nodes := make([]*enode.Node, 0, len(srv.trusted))
+	for _, n := range srv.trusted {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// IsTrusted reports whether the node with the given ID is a trusted peer.
+// Trusted peers bypass the peer limits and are never banned.
+func (srv *Server) IsTrusted(id enode.ID) bool {
+	srv.trustedLock.RLock()
+	defer srv.trustedLock.RUnlock()
+	_, ok := srv.trusted[id]
+	return ok
+}
+
+func (srv *Server) setTrusted(n *enode.Node, trusted bool) {
+	srv.trustedLock.Lock()
+	defer srv.trustedLock.Unlock()
+	if trusted {
+		srv.trusted[n.ID()] = n
+	} else {
+		delete(srv.trusted, n.ID())
+	}
+}
+
+// BanPeer bans the given node for the duration d and disconnects it if it is
+// connected. A non-positive duration uses the configured BanDuration.
+// Trusted nodes can't be banned.
+func (srv *Server) BanPeer(node *enode.Node, d time.Duration, reason string) error {
+	if srv.banlist == nil {
+		return errServerStopped
+	}
+	if srv.IsTrusted(node.ID()) {
+		return errors.New("trusted peer can't be banned")
+	}
+	if d <= 0 {
+		d = srv.BanDuration
+	}
+	srv.banlist.BanNode(node.ID(), d, reason)
+	srv.log.Debug("Banned peer", "id", node.ID(), "duration", d, "reason", reason)
+	srv.disconnect(node.ID())
+	return nil
+}
+
+// BanIP bans every connection from the given IP address for the duration d.
+func (srv *Server) BanIP(ip net.IP, d time.Duration, reason string) error {
+	if srv.banlist == nil {
+		return errServerStopped
+	}
+	if d <= 0 {
+		d = srv.BanDuration
+	}
+	srv.banlist.BanIP(ip, d, reason)
+	srv.log.Debug("Banned address", "ip", ip, "duration", d, "reason", reason)
+	return nil
+}
+
+// UnbanPeer lifts the ban of the given node.
+func (srv *Server) UnbanPeer(id enode.ID) bool {
+	if srv.banlist == nil {
+		return false
+	}
+	return srv.banlist.UnbanNode(id)
+}
+
+// UnbanIP lifts the ban of the given IP address.
+func (srv *Server) UnbanIP(ip net.IP) bool {
+	if srv.banlist == nil {
+		return false
+	}
+	return srv.banlist.UnbanIP(ip)
+}
+
+// Bans returns the active entries of the ban list.
+func (srv *Server) Bans() []*BanEntry {
+	if srv.banlist == nil {
+		return nil
+	}
+	return srv.banlist.Entries()
+}
+
+func (srv *Server) disconnect(id enode.ID) {
+	select {
+	case srv.peerOp <- func(peers map[enode.ID]*Peer) {
+		if p := peers[id]; p != nil {
+			p.Disconnect(DiscBanned)
+		}
+	}:
+		<-srv.peerOpDone
+	case <-srv.quit:
+	}
+}
+
 // SubscribePeers subscribes the given channel to peer events
 func (srv *Server) SubscribeEvents(ch chan *PeerEvent) event.Subscription {
 	return srv.peerFeed.Subscribe(ch)
@@ -448,6 +597,9 @@ func (srv *Server) Start() (err error) {
 	srv.removetrusted = make(chan *enode.Node)
 	srv.peerOp = make(chan peerOpFunc)
 	srv.peerOpDone = make(chan struct{})
+	if srv.banlist, err = NewBanList(srv.BanList); err != nil {
+		return err
+	}
 
 	if !srv.NoDiscovery {
 		addr, err := net.ResolveUDPAddr("udp", srv.ListenAddr)
@@ -476,6 +628,8 @@ func (srv *Server) Start() (err error) {
 
 	dynPeers := srv.maxDialedConns()
 	dialer := newDialState(srv.StaticNodes, srv.BootstrapNodes, srv.ntab, dynPeers, srv.NetRestrict)
+	dialer.banlist = srv.banlist
+	dialer.subnetLimit = srv.maxSubnetDials()
 
 	// handshake
 	pubkey := crypto.FromECDSAPub(&srv.PrivateKey.PublicKey)
@@ -524,16 +678,18 @@ func (srv *Server) run(dialstate dialer) {
 	var (
 		peers        = make(map[enode.ID]*Peer)
 		inboundCount = 0
-		trusted      = make(map[enode.ID]bool, len(srv.TrustedNodes))
 		taskdone     = make(chan task, maxActiveDialTasks)
 		runningTasks []task
 		queuedTasks  []task // tasks that can't run yet
 	)
 	// Put trusted nodes into a map to speed up checks.
 	// Trusted peers are loaded on startup or added via AddTrustedPeer RPC.
+	srv.trustedLock.Lock()
+	srv.trusted = make(map[enode.ID]*enode.Node, len(srv.TrustedNodes))
 	for _, n := range srv.TrustedNodes {
-		trusted[n.ID()] = true
+		srv.trusted[n.ID()] = n
 	}
+	srv.trustedLock.Unlock()
 
 	// removes t from runningTasks
 	delTask := func(t task) {
@@ -592,7 +748,7 @@ running:
 			// This channel is used by AddTrustedPeer to add an enode
 			// to the trusted node set.
 			srv.log.Trace("Adding trusted node", "node", n)
-			trusted[n.ID()] = true
+			srv.setTrusted(n, true)
 			// Mark any already-connected peer as trusted
 			if p, ok := peers[n.ID()]; ok {
 				p.rw.set(trustedConn, true)
@@ -601,9 +757,7 @@ running:
 			// This channel is used by RemoveTrustedPeer to remove an enode
 			// from the trusted node set.
 			srv.log.Trace("Removing trusted node", "node", n)
-			if _, ok := trusted[n.ID()]; ok {
-				delete(trusted, n.ID())
-			}
+			srv.setTrusted(n, false)
 			// Unmark any already-connected peer as trusted
 			if p, ok := peers[n.ID()]; ok {
 				p.rw.set(trustedConn, false)
@@ -622,7 +776,7 @@ running:
 		case c := <-srv.posthandshake:
 			// A connection has passed the encryption handshake so
 			// the remote identity is known (but hasn't been verified yet).
-			if trusted[c.node.ID()] {
+			if srv.IsTrusted(c.node.ID()) {
 				// Ensure that the trusted flag is set before checking against MaxPeers.
 				c.flags |= trustedConn
 			}
@@ -712,22 +866,35 @@ func (srv *Server) encHandshakeChecks(peers map[enode.ID]*Peer, inboundCount int
 		return DiscTooManyPeers
 	case !c.is(trustedConn) && c.is(inboundConn) && inboundCount >= srv.maxInboundConns():
 		return DiscTooManyPeers
+	case srv.MaxOutboundPeers > 0 && !c.is(trustedConn|staticDialedConn) && c.is(dynDialedConn) && dialedCount(peers) >= srv.maxDialedConns():
+		return DiscTooManyPeers
 	case peers[c.node.ID()] != nil:
 		return DiscAlreadyConnected
 	case c.node.ID() == srv.Self().ID():
 		return DiscSelf
+	case srv.banlist != nil && !c.is(trustedConn) && srv.banlist.IsBanned(c.node.ID(), c.node.IP()):
+		return DiscBanned
 	default:
 		return nil
 	}
 }
 
 func (srv *Server) maxInboundConns() int {
+	if srv.MaxInboundPeers > 0 && srv.MaxInboundPeers < srv.MaxPeers {
+		return srv.MaxInboundPeers
+	}
 	return srv.MaxPeers - srv.maxDialedConns()
 }
 func (srv *Server) maxDialedConns() int {
 	if srv.NoDiscovery || srv.NoDial {
 		return 0
 	}
+	if srv.MaxOutboundPeers > 0 {
+		if srv.MaxOutboundPeers > srv.MaxPeers {
+			return srv.MaxPeers
+		}
+		return srv.MaxOutboundPeers
+	}
 	r := srv.DialRatio
 	if r == 0 {
 		r = defaultDialRatio
@@ -735,6 +902,59 @@ func (srv *Server) maxDialedConns() int {
 	return srv.MaxPeers / r
 }
 
+// maxSubnetDials returns the dynamic peer limit per subnet, zero if unlimited.
+func (srv *Server) maxSubnetDials() int {
+	switch {
+	case srv.MaxSubnetDials < 0:
+		return 0
+	case srv.MaxSubnetDials == 0:
+		return defaultMaxSubnetDials
+	}
+	return srv.MaxSubnetDials
+}
+
+// dialedCount returns the number of dynamically dialed peers.
+func dialedCount(peers map[enode.ID]*Peer) (n int) {
+	for _, p := range peers {
+		if p.rw.is(dynDialedConn) {
+			n++
+		}
+	}
+	return n
+}
+
+// PeerSlots summarizes how the connection slots of the server are used.
+type PeerSlots struct {
+	MaxPeers    int `json:"maxPeers"`
+	Inbound     int `json:"inbound"`
+	MaxInbound  int `json:"maxInbound"`
+	Outbound    int `json:"outbound"`
+	MaxOutbound int `json:"maxOutbound"`
+}
+
+// PeerSlots returns the current inbound/outbound slot usage. Outbound counts
+// dynamically dialed peers only, static and trusted peers are not limited.
+func (srv *Server) PeerSlots() *PeerSlots {
+	slots := &PeerSlots{
+		MaxPeers:    srv.MaxPeers,
+		MaxInbound:  srv.maxInboundConns(),
+		MaxOutbound: srv.maxDialedConns(),
+	}
+	select {
+	case srv.peerOp <- func(ps map[enode.ID]*Peer) {
+		for _, p := range ps {
+			if p.Inbound() {
+				slots.Inbound++
+			}
+		}
+		slots.Outbound = dialedCount(ps)
+	}:
+		<-srv.peerOpDone
+	case <-srv.quit:
+	}
+	return slots
+}
+
 type tempError interface {
 	Temporary() bool
 }
@@ -784,6 +1004,14 @@ func (srv *Server) listenLoop() {
 			}
 		}
 
+		// Reject connections from banned addresses.
+		if tcp, ok := fd.RemoteAddr().(*net.TCPAddr); ok && srv.banlist.IsBanned(enode.ID{}, tcp.IP) {
+			srv.log.Debug("Rejected conn (banned)", "addr", fd.RemoteAddr())
+			fd.Close()
+			slots <- struct{}{}
+			continue
+		}
+
 		fd = newMeteredConn(fd, true)
 		srv.log.Trace("Accepted connection", "addr", fd.RemoteAddr())
 		go func() {
@@ -933,11 +1161,12 @@ func (srv *Server) runPeer(p *Peer) {
 
 // NodeInfo represents a short summary of the information known about the host.
 type NodeInfo struct {
-	ID    string `json:"id"`    // Unique node identifier (also the encryption key)
-	Name  string `json:"name"`  // Name of the node, including client type, version, OS, custom data
-	Enode string `json:"enode"` // Enode URL for adding this peer from remote peers
-	IP    string `json:"ip"`    // IP address of the node
-	Ports struct {
+	ID        string `json:"id"`        // Unique node identifier (also the encryption key)
+	PublicKey string `json:"publicKey"` // Hex encoded secp256k1 public key of the node
+	Name      string `json:"name"`      // Name of the node, including client type, version, OS, custom data
+	Enode     string `json:"enode"`     // Enode URL for adding this peer from remote peers
+	IP        string `json:"ip"`        // IP address of the node
+	Ports     struct {
 		Discovery int `json:"discovery"` // UDP listening port for discovery protocol
 		Listener  int `json:"listener"`  // TCP listening port for RLPx
 	} `json:"ports"`
@@ -954,6 +1183,7 @@ func (srv *Server) NodeInfo() *NodeInfo {
 		Name:       srv.Name,
 		Enode:      node.String(),
 		ID:         node.ID().String(),
+		PublicKey:  hex.EncodeToString(crypto.FromECDSAPub(&srv.PrivateKey.PublicKey)[1:]),
 		IP:         node.IP().String(),
 		ListenAddr: srv.ListenAddr,
 		Protocols:  make(map[string]interface{}),
@@ -25,8 +25,8 @@ import (
 	"testing"
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/p2p/enode"
 	"github.com/fractalplatform/fractal/p2p/enr"
 	"golang.org/x/crypto/sha3"
@@ -610,3 +610,28 @@ func randomID() (id enode.ID) {
 	}
 	return id
 }
+
+func TestServerSlotLimits(t *testing.T) {
+	tests := []struct {
+		cfg         Config
+		maxInbound  int
+		maxOutbound int
+	}{
+		{Config{MaxPeers: 30}, 20, 10},
+		{Config{MaxPeers: 30, DialRatio: 2}, 15, 15},
+		{Config{MaxPeers: 30, NoDial: true}, 30, 0},
+		{Config{MaxPeers: 30, MaxOutboundPeers: 5}, 25, 5},
+		{Config{MaxPeers: 30, MaxOutboundPeers: 50}, 0, 30},
+		{Config{MaxPeers: 30, MaxInboundPeers: 8}, 8, 10},
+		{Config{MaxPeers: 30, MaxInboundPeers: 8, MaxOutboundPeers: 12}, 8, 12},
+	}
+	for i, test := range tests {
+		srv := &Server{Config: test.cfg}
+		if n := srv.maxInboundConns(); n != test.maxInbound {
+			t.Errorf("test %d: max inbound mismatch: have %d, want %d", i, n, test.maxInbound)
+		}
+		if n := srv.maxDialedConns(); n != test.maxOutbound {
+			t.Errorf("test %d: max outbound mismatch: have %d, want %d", i, n, test.maxOutbound)
+		}
+	}
+}
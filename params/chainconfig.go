@@ -17,6 +17,7 @@
 package params
 
 import (
+	"math"
 	"math/big"
 
 	"github.com/fractalplatform/fractal/common"
@@ -27,11 +28,222 @@ const DefaultPubkeyHex = "047db227d7094ce215c3a0f57e1bcc732551fe351f942494719345
 // ChainConfig is the core config which determines the blockchain settings.
 // ChainConfig is stored in the database on a per block basis.
 type ChainConfig struct {
-	ChainID          *big.Int    `json:"chainId"`  // chainId identifies the current chain and is used for replay protection
-	SysName          common.Name `json:"sysName"`  // system name
-	SysToken         string      `json:"sysToken"` // system token
-	SysTokenID       uint64      `json:"-"`
-	SysTokenDecimals uint64      `json:"-"`
+	ChainID          *big.Int           `json:"chainId"`  // chainId identifies the current chain and is used for replay protection
+	SysName          common.Name        `json:"sysName"`  // system name
+	SysToken         string             `json:"sysToken"` // system token
+	SysTokenID       uint64             `json:"-"`
+	SysTokenDecimals uint64             `json:"-"`
+	FeeToken         string             `json:"feeToken,omitempty"` // asset gas is paid in, the system token if empty
+	FeeTokenID       uint64             `json:"-"`
+	StorageRent      *big.Int           `json:"storageRent,omitempty"`      // rent per byte of contract code and storage per block, in the fee asset
+	StorageRentBlock *big.Int           `json:"storageRentBlock,omitempty"` // storage rent is charged from this block on, never if nil
+	ForkBlocks       []uint64           `json:"forkBlocks,omitempty"`       // block numbers at which protocol upgrades activate
+	NameAuction      *NameAuctionConfig `json:"nameAuction,omitempty"`      // auction of the short account names, disabled if nil
+	BlockLimits      *BlockLimitsConfig `json:"blockLimits,omitempty"`      // bounds of the block contents, the defaults if nil
+	BlockLimitsBlock *big.Int           `json:"blockLimitsBlock,omitempty"` // blocks are bounded by BlockLimits from this block on, never if nil
+	SysPrefix        string             `json:"sysPrefix,omitempty"`        // names reserved for system accounts, DefaultSysPrefix if empty
+	FeePoolName      common.Name        `json:"feePoolName,omitempty"`      // system account of the fee pool, DefaultFeePoolName if empty
+	ExtensionBlock   *big.Int           `json:"extensionBlock,omitempty"`   // actions with unknown extensions are rejected from this block on, never if nil
+	WasmBlock        *big.Int           `json:"wasmBlock,omitempty"`        // WebAssembly contracts run from this block on, never if nil
+	NativeBlock      *big.Int           `json:"nativeBlock,omitempty"`      // native contracts run from this block on, never if nil
+	FeeAssetBlock    *big.Int           `json:"feeAssetBlock,omitempty"`    // gas is priced in the fee token from this block on, never if nil
+	CreateOpBlock    *big.Int           `json:"createOpBlock,omitempty"`    // the CREATE opcode consumes its arguments from this block on, never if nil
+	ActionForks      []*ActionFork      `json:"actionForks,omitempty"`      // blocks from which action types are enabled
+	Resources        *ResourceConfig    `json:"resources,omitempty"`        // gas quotas granted by staking the system token, disabled if nil
+	Treasury         *TreasuryConfig    `json:"treasury,omitempty"`         // fee pool share and its withdrawal governance, disabled if nil
+}
+
+const (
+	// DefaultSysPrefix is the default prefix of the names reserved for system
+	// accounts.
+	DefaultSysPrefix = "ftsystem"
+	// DefaultFeePoolName is the default name of the fee pool system account.
+	DefaultFeePoolName = common.Name("ftsystemfee")
+)
+
+// ReservedPrefix returns the prefix of the names reserved for system accounts.
+func (c *ChainConfig) ReservedPrefix() string {
+	if c.SysPrefix == "" {
+		return DefaultSysPrefix
+	}
+	return c.SysPrefix
+}
+
+// FeePool returns the name of the fee pool system account.
+func (c *ChainConfig) FeePool() common.Name {
+	if c.FeePoolName == "" {
+		return DefaultFeePoolName
+	}
+	return c.FeePoolName
+}
+
+// IsStrictExtensions reports whether actions with unknown extensions are
+// rejected in the block with the given number.
+func (c *ChainConfig) IsStrictExtensions(number uint64) bool {
+	return c.ExtensionBlock != nil && c.ExtensionBlock.Cmp(new(big.Int).SetUint64(number)) <= 0
+}
+
+// IsBlockLimits reports whether the block with the given number is bounded by
+// the block limits.
+func (c *ChainConfig) IsBlockLimits(number uint64) bool {
+	return c.BlockLimitsBlock != nil && c.BlockLimitsBlock.Cmp(new(big.Int).SetUint64(number)) <= 0
+}
+
+// IsStorageRent reports whether contracts pay storage rent in the block with
+// the given number.
+func (c *ChainConfig) IsStorageRent(number uint64) bool {
+	return c.StorageRentBlock != nil && c.StorageRentBlock.Cmp(new(big.Int).SetUint64(number)) <= 0
+}
+
+// IsWasm reports whether WebAssembly contracts are deployed and run in the
+// block with the given number.
+func (c *ChainConfig) IsWasm(number uint64) bool {
+	return c.WasmBlock != nil && c.WasmBlock.Cmp(new(big.Int).SetUint64(number)) <= 0
+}
+
+// IsFeeAsset reports whether gas prices are given in the fee token in the
+// block with the given number. Before, they are given in the asset the gas is
+// paid in, whatever it is.
+func (c *ChainConfig) IsFeeAsset(number uint64) bool {
+	return c.FeeAssetBlock != nil && c.FeeAssetBlock.Cmp(new(big.Int).SetUint64(number)) <= 0
+}
+
+// IsCreateOp reports whether the CREATE opcode pops its arguments and pushes
+// its failure in the block with the given number. Before, it leaves the stack
+// untouched.
+func (c *ChainConfig) IsCreateOp(number uint64) bool {
+	return c.CreateOpBlock != nil && c.CreateOpBlock.Cmp(new(big.Int).SetUint64(number)) <= 0
+}
+
+// IsNative reports whether the native contracts run in the block with the
+// given number. Before, their names are plain account names.
+func (c *ChainConfig) IsNative(number uint64) bool {
+	return c.NativeBlock != nil && c.NativeBlock.Cmp(new(big.Int).SetUint64(number)) <= 0
+}
+
+// ActionFork enables action types from a block on. Transactions carrying an
+// action of a type enabled by a fork are invalid before its block, the types
+// no fork lists are enabled from the genesis.
+type ActionFork struct {
+	Block       uint64   `json:"block"`
+	ActionTypes []uint64 `json:"actionTypes"`
+}
+
+// IsActionEnabled reports whether actions of the type are valid in the block
+// with the given number. A type listed by several forks is enabled by the
+// earliest.
+func (c *ChainConfig) IsActionEnabled(actionType uint64, number uint64) bool {
+	listed := false
+	for _, fork := range c.ActionForks {
+		for _, t := range fork.ActionTypes {
+			if t != actionType {
+				continue
+			}
+			if fork.Block <= number {
+				return true
+			}
+			listed = true
+		}
+	}
+	return !listed
+}
+
+// NameAuctionConfig configures the auction of short account names, which can
+// only be created by the winner of their auction.
+type NameAuctionConfig struct {
+	MaxLength    uint64   `json:"maxLength"`    // names up to this length are auctioned
+	Duration     uint64   `json:"duration"`     // blocks after the last bid until the highest bid wins
+	MinBid       *big.Int `json:"minBid"`       // lowest first bid, in the system token
+	MinIncrement uint64   `json:"minIncrement"` // percent a bid must raise the highest bid by
+}
+
+// IsAuctioned reports whether the name can only be created through its
+// auction.
+func (c *NameAuctionConfig) IsAuctioned(name common.Name) bool {
+	return c != nil && uint64(len(name.String())) <= c.MaxLength
+}
+
+// ResourceConfig grants renewable gas quotas to the accounts staking the
+// system token. The gas of the actions of a transaction with a zero gas
+// price is taken from the quotas of their senders instead of paid in fees.
+type ResourceConfig struct {
+	Unit       *big.Int `json:"unit"`       // staked amount of the system token granting GasPerUnit
+	GasPerUnit uint64   `json:"gasPerUnit"` // gas granted per window for each staked unit
+	Window     uint64   `json:"window"`     // blocks after which the used quotas are renewed
+}
+
+// Quota returns the gas granted per window for the staked amount.
+func (c *ResourceConfig) Quota(staked *big.Int) uint64 {
+	if c == nil || staked == nil || c.Unit == nil || c.Unit.Sign() <= 0 {
+		return 0
+	}
+	quota := new(big.Int).Div(staked, c.Unit)
+	quota.Mul(quota, new(big.Int).SetUint64(c.GasPerUnit))
+	if !quota.IsUint64() {
+		return math.MaxUint64
+	}
+	return quota.Uint64()
+}
+
+// WindowStart returns the first block of the window of the block with the
+// given number.
+func (c *ResourceConfig) WindowStart(number uint64) uint64 {
+	if c != nil && c.Window > 1 {
+		return number - number%c.Window
+	}
+	return number
+}
+
+// TreasuryConfig pays a share of the fees to the fee pool, from which the
+// activated producers withdraw by proposals a quorum of them approves.
+type TreasuryConfig struct {
+	FeeShare       uint64 `json:"feeShare"`       // percent of the fees paid to the fee pool instead of the producer
+	VotingDuration uint64 `json:"votingDuration"` // blocks a withdrawal proposal can be approved and executed in
+}
+
+// PoolFee returns the share of the fee paid to the fee pool.
+func (c *TreasuryConfig) PoolFee(fee *big.Int) *big.Int {
+	if c == nil || c.FeeShare == 0 || fee.Sign() <= 0 {
+		return new(big.Int)
+	}
+	share := c.FeeShare
+	if share > 100 {
+		share = 100
+	}
+	pool := new(big.Int).Mul(fee, new(big.Int).SetUint64(share))
+	return pool.Div(pool, big.NewInt(100))
+}
+
+// BlockLimitsConfig bounds the contents of a block. Zero fields take the
+// default limits.
+type BlockLimitsConfig struct {
+	MaxSize      uint64 `json:"maxSize,omitempty"`      // RLP encoded size of a block in bytes
+	MaxTxs       uint64 `json:"maxTxs,omitempty"`       // transactions in a block
+	MaxTxActions uint64 `json:"maxTxActions,omitempty"` // actions in a transaction
+}
+
+// BlockSize returns the maximum RLP encoded size of a block.
+func (c *BlockLimitsConfig) BlockSize() uint64 {
+	if c == nil || c.MaxSize == 0 {
+		return DefaultMaxBlockSize
+	}
+	return c.MaxSize
+}
+
+// BlockTxs returns the maximum number of transactions in a block.
+func (c *BlockLimitsConfig) BlockTxs() uint64 {
+	if c == nil || c.MaxTxs == 0 {
+		return DefaultMaxBlockTxs
+	}
+	return c.MaxTxs
+}
+
+// TxActions returns the maximum number of actions in a transaction.
+func (c *BlockLimitsConfig) TxActions() uint64 {
+	if c == nil || c.MaxTxActions == 0 {
+		return DefaultMaxTxActions
+	}
+	return c.MaxTxActions
 }
 
 var DefaultChainconfig = &ChainConfig{
@@ -39,3 +251,17 @@ var DefaultChainconfig = &ChainConfig{
 	SysName:  "ftsystemio",
 	SysToken: "ftoken",
 }
+
+// TestnetChainconfig is the chain config of the test network.
+var TestnetChainconfig = &ChainConfig{
+	ChainID:  big.NewInt(2),
+	SysName:  "ftsystemio",
+	SysToken: "ftoken",
+}
+
+// DevChainconfig is the chain config of a local development network.
+var DevChainconfig = &ChainConfig{
+	ChainID:  big.NewInt(100),
+	SysName:  "ftsystemio",
+	SysToken: "ftoken",
+}
@@ -88,3 +88,30 @@ func CalcGasLimit(parent *types.Block) uint64 {
 	}
 	return limit
 }
+
+// CalcGasLimitTarget computes the gas limit of the next block after parent,
+// moving towards the target gas limit voted by the producer by at most the
+// adjustment accepted by the validators. A zero target falls back to
+// CalcGasLimit.
+func CalcGasLimitTarget(parent *types.Block, target uint64) uint64 {
+	if target == 0 {
+		return CalcGasLimit(parent)
+	}
+	if target < MinGasLimit {
+		target = MinGasLimit
+	}
+	// the validators accept a change below parentGasLimit / 1024
+	step := parent.GasLimit()/GasLimitBoundDivisor - 1
+	limit := parent.GasLimit()
+	switch {
+	case limit+step < target:
+		limit += step
+	case limit < target:
+		limit = target
+	case limit > target+step:
+		limit -= step
+	default:
+		limit = target
+	}
+	return limit
+}
@@ -27,6 +27,12 @@ const (
 	GasLimitBoundDivisor uint64 = 1024
 	// MaximumExtraDataSize Maximum size extra data may be after Genesis.
 	MaximumExtraDataSize uint64 = 32 + 65
+	// DefaultMaxBlockSize Default maximum RLP encoded size of a block.
+	DefaultMaxBlockSize uint64 = 4 * 1024 * 1024
+	// DefaultMaxBlockTxs Default maximum number of transactions in a block.
+	DefaultMaxBlockTxs uint64 = 20000
+	// DefaultMaxTxActions Default maximum number of actions in a transaction.
+	DefaultMaxTxActions uint64 = 32
 	// ActionGas Per action not creating a contract. NOTE: Not payable on data of calls between transactions.
 	ActionGas uint64 = 21000
 	// ActionGasContractCreation Per action that creates a contract. NOTE: Not payable on data of calls between transactions.
@@ -84,6 +90,20 @@ const (
 	Bn256PairingBaseGas     uint64 = 100000 // Base price for an elliptic curve pairing check
 	Bn256PairingPerPointGas uint64 = 80000  // Per-point price for an elliptic curve pairing check
 
+	// Native contract gas prices
+
+	NativeTransferGas    uint64 = 9000  // Gas needed for an asset transfer by a contract
+	NativeBalanceGas     uint64 = 400   // Gas needed to query the balance of an account
+	NativeIssueAssetGas  uint64 = 32000 // Gas needed to issue a new asset
+	NativeResolveNameGas uint64 = 700   // Gas needed to resolve an account name
+
+	// WebAssembly contract limits and gas prices
+
+	WasmInstructionGas uint64 = 1    // Once per executed WebAssembly instruction
+	WasmPageGas        uint64 = 6144 // Per 64KiB page of linear memory, the price of as many EVM memory words
+	WasmMaxPages       uint32 = 16   // Maximum linear memory of a contract in pages
+	WasmCallDepth      int    = 256  // Maximum nesting of function calls within a contract
+
 	Wei   = 1
 	GWei  = 1e9
 	Ether = 1e18
@@ -51,6 +51,37 @@ var (
 	// one present in the local chain.
 	ErrNonceTooLow = errors.New("nonce too low")
 
+	// ErrInvalidGasAsset is returned if a transaction pays gas in an asset
+	// that is neither the fee asset of the chain nor whitelisted to pay fees.
+	ErrInvalidGasAsset = errors.New("invalid gas asset")
+
+	// ErrNotSystemAccount is returned if an action only the system account may
+	// send is sent by another account.
+	ErrNotSystemAccount = errors.New("not the system account")
+
+	// ErrNotProducer is returned if an action only the activated producers
+	// may send is sent by another account.
+	ErrNotProducer = errors.New("not an activated producer")
+
+	// ErrBlockTooLarge is returned if a block exceeds the maximum block size.
+	ErrBlockTooLarge = errors.New("block too large")
+
+	// ErrTooManyTxs is returned if a block holds more than the maximum number
+	// of transactions.
+	ErrTooManyTxs = errors.New("too many transactions in block")
+
+	// ErrTooManyActions is returned if a transaction holds no actions or more
+	// than the maximum number of actions.
+	ErrTooManyActions = errors.New("invalid number of actions in transaction")
+
+	// ErrUnknownExtension is returned if an action carries an extension the
+	// protocol does not know after strict extensions are activated.
+	ErrUnknownExtension = errors.New("unknown action extension")
+
+	// ErrActionDisabled is returned if an action is of a type the fork of the
+	// block doesn't enable yet.
+	ErrActionDisabled = errors.New("action type not enabled")
+
 	errZeroBlockTime = errors.New("timestamp equals parent's")
 )
 
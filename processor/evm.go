@@ -70,6 +70,9 @@ type EgnineContext interface {
 	Author(header *types.Header) (common.Name, error)
 
 	ProcessAction(chainCfg *params.ChainConfig, state *state.StateDB, action *types.Action) error
+
+	// ActivatedProducers returns the producers of the activated schedule in the state.
+	ActivatedProducers(state *state.StateDB) ([]string, error)
 }
 
 type EvmContext struct {
@@ -17,9 +17,12 @@
 package processor
 
 import (
+	"math/big"
+
 	"github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/processor/vm"
 	"github.com/fractalplatform/fractal/state"
 	"github.com/fractalplatform/fractal/types"
@@ -88,19 +91,39 @@ func (p *StateProcessor) ApplyTransaction(author *common.Name, gp *common.GasPoo
 	}
 
 	assetID := tx.GasAssetID()
+	if config.IsFeeAsset(header.Number.Uint64()) {
+		if _, err := accountDB.FeeAmount(assetID, config.FeeTokenID, new(big.Int)); err != nil {
+			return nil, 0, ErrInvalidGasAsset
+		}
+	}
 	gasPrice := tx.GasPrice()
+	snap := statedb.Snapshot()
+	// the gas of free transactions is taken from the staked quotas
+	quota := config.Resources != nil && gasPrice.Sign() == 0
 
 	var totalGas uint64
 	var ios []*types.ActionResult
+	var fees []*types.FeePayment
 	for i, action := range tx.GetActions() {
+		if err := validateAction(config, accountDB, action, header.Number.Uint64()); err != nil {
+			return nil, 0, err
+		}
+
 		fromPubkey, err := types.Recover(types.NewSigner(config.ChainID), action, tx)
 		if err != nil {
 			return nil, 0, err
 		}
 
-		if err := accountDB.IsValidSign(action.Sender(), action.Type(), fromPubkey); err != nil {
+		check, err := accountDB.CheckSign(action.Sender(), action.Type(), fromPubkey)
+		if err != nil {
 			return nil, 0, err
 		}
+		auth := &types.ActionAuth{
+			Account:   action.Sender(),
+			Keys:      check.Matched,
+			Weight:    check.Weight,
+			Threshold: check.Threshold,
+		}
 
 		nonce, err := accountDB.GetNonce(action.Sender())
 		if err != nil {
@@ -111,7 +134,20 @@ func (p *StateProcessor) ApplyTransaction(author *common.Name, gp *common.GasPoo
 		} else if nonce > action.Nonce() {
 			return nil, 0, ErrNonceTooLow
 		}
+		// the system account of the chain isn't limited, next to the
+		// accounts of the system namespace
+		if action.Sender() != config.SysName {
+			if err := accountDB.UseRateLimit(action.Sender(), header.Number.Uint64()); err != nil {
+				return nil, 0, err
+			}
+		}
+		if quota {
+			if err := accountDB.UseResource(action.Sender(), header.Number.Uint64(), action.Gas(), config.Resources); err != nil {
+				return nil, 0, err
+			}
+		}
 
+		statedb.PrepareAction(i)
 		evmcontext := &EvmContext{
 			ChainContext:  p.bc,
 			EgnineContext: p.engine,
@@ -119,10 +155,19 @@ func (p *StateProcessor) ApplyTransaction(author *common.Name, gp *common.GasPoo
 		context := NewEVMContext(action.Sender(), fromPubkey, assetID, tx.GasPrice(), header, evmcontext, author)
 		vmenv := vm.NewEVM(context, accountDB, statedb, config, cfg)
 
-		_, gas, failed, err, vmerr := ApplyMessage(accountDB, vmenv, action, gp, gasPrice, assetID, config, p.engine)
+		st := NewStateTransition(accountDB, vmenv, action, gp, gasPrice, assetID, config, p.engine)
+		_, gas, failed, err, vmerr := st.TransitionDb()
 		if err != nil {
 			return nil, 0, err
 		}
+		if quota && gas < action.Gas() {
+			if err := accountDB.RefundResource(action.Sender(), header.Number.Uint64(), action.Gas()-gas, config.Resources); err != nil {
+				return nil, 0, err
+			}
+		}
+		for _, fee := range st.Fees() {
+			fees = types.AddFeePayment(fees, fee.Kind, fee.Recipient, fee.Amount)
+		}
 
 		*usedGas += gas
 		totalGas += gas
@@ -138,16 +183,41 @@ func (p *StateProcessor) ApplyTransaction(author *common.Name, gp *common.GasPoo
 		if vmerr != nil {
 			vmerrstr = vmerr.Error()
 		}
-		ios = append(ios, &types.ActionResult{Status: status, Index: uint64(i), GasUsed: gas, Error: vmerrstr})
+		ios = append(ios, &types.ActionResult{Status: status, Index: uint64(i), GasUsed: gas, Error: vmerrstr, Authorizations: []*types.ActionAuth{auth}})
 
 	}
+	changes, err := accountmanager.BalanceChangesSince(statedb, snap)
+	if err != nil {
+		return nil, 0, err
+	}
 	root := statedb.ReceiptRoot()
 	receipt := types.NewReceipt(root[:], *usedGas, totalGas)
 	receipt.TxHash = tx.Hash()
 	receipt.ActionResults = ios
+	receipt.FeeAssetID = assetID
+	receipt.FeePayments = fees
+	receipt.BalanceChanges = changes
+	for _, fee := range fees {
+		receipt.Fee.Add(receipt.Fee, fee.Amount)
+	}
 	// Set the receipt logs and create a bloom for filtering
 	receipt.Logs = statedb.GetLogs(tx.Hash())
 	receipt.Bloom = types.CreateBloom([]*types.Receipt{receipt})
 
 	return receipt, totalGas, nil
 }
+
+// validateAction checks that the action is valid in the block with the number
+// and that its sender can send it. The actions of the transactions and the
+// ones of the executed multisig proposals are checked alike.
+func validateAction(config *params.ChainConfig, accountDB *accountmanager.AccountManager, action *types.Action, number uint64) error {
+	if config.IsStrictExtensions(number) {
+		if _, unknown := action.UnknownExtension(); unknown {
+			return ErrUnknownExtension
+		}
+	}
+	if !config.IsActionEnabled(uint64(action.Type()), number) {
+		return ErrActionDisabled
+	}
+	return accountDB.IsValidSender(action.Sender(), action.Type())
+}
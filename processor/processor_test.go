@@ -0,0 +1,634 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package processor_test
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/blockchain"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/consensus"
+	"github.com/fractalplatform/fractal/consensus/dpos"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/processor"
+	"github.com/fractalplatform/fractal/processor/vm"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/txpool"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+var sysKey, _ = crypto.HexToECDSA("289c2857d4598e37fb9647507e47a309d6133539bf21a8b9cb6df88fd5232032")
+
+// testEnv applies transactions on the genesis state of a chain, as the
+// producer of the following blocks would.
+type testEnv struct {
+	t         *testing.T
+	config    *params.ChainConfig
+	chain     *blockchain.BlockChain
+	processor *processor.StateProcessor
+	statedb   *state.StateDB
+	number    uint64
+	feeAsset  uint64 // asset the gas is paid in, the fee token if zero
+	keys      map[common.Name]*ecdsa.PrivateKey
+}
+
+// testAction is an action of a transaction applied by the test environment,
+// its nonce is the next one of its sender.
+type testAction struct {
+	typ   types.ActionType
+	from  common.Name
+	to    common.Name
+	value int64
+	data  []byte
+}
+
+func newTestEnv(t *testing.T, configure func(*params.ChainConfig)) *testEnv {
+	event.InitRounter()
+	var (
+		db     = fdb.NewMemDatabase()
+		gspec  = blockchain.DefaultGenesis()
+		config = *gspec.Config
+	)
+	if configure != nil {
+		configure(&config)
+	}
+	gspec.Config = &config
+	if _, err := gspec.Commit(db); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.NewBlockChain(db, nil, vm.Config{}, &config, txpool.SenderCacher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := dpos.New(dpos.DefaultConfig, chain)
+	statedb, err := chain.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{
+		t:      t,
+		config: &config,
+		chain:  chain,
+		processor: processor.NewStateProcessor(&struct {
+			*blockchain.BlockChain
+			consensus.IEngine
+		}{chain, engine}, engine),
+		statedb: statedb,
+		number:  1,
+		keys:    map[common.Name]*ecdsa.PrivateKey{config.SysName: sysKey},
+	}
+}
+
+// createAccounts creates the accounts with new keys, each funded with the
+// value in the system token.
+func (env *testEnv) createAccounts(value int64, names ...common.Name) {
+	var actions []testAction
+	for _, name := range names {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			env.t.Fatal(err)
+		}
+		env.keys[name] = key
+		pub := common.BytesToPubKey(crypto.FromECDSAPub(&key.PublicKey))
+		actions = append(actions, testAction{types.CreateAccount, env.config.SysName, name, value, pub[:]})
+	}
+	env.mustApply(actions...)
+}
+
+// apply signs the actions in a transaction and applies it in the next block.
+func (env *testEnv) apply(actions ...testAction) (*types.Receipt, error) {
+	am, err := accountmanager.NewAccountManager(env.statedb)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	var (
+		nonces = make(map[common.Name]uint64)
+		txActs []*types.Action
+	)
+	for _, a := range actions {
+		if _, ok := nonces[a.from]; !ok {
+			if nonces[a.from], err = am.GetNonce(a.from); err != nil {
+				env.t.Fatal(err)
+			}
+		}
+		txActs = append(txActs, types.NewAction(a.typ, a.from, a.to, nonces[a.from], env.config.SysTokenID, 1000000, big.NewInt(a.value), a.data))
+		nonces[a.from]++
+	}
+	return env.applyActions(txActs...)
+}
+
+// applyActions signs the actions in a transaction paying its gas in the fee
+// asset of the environment and applies it in the next block.
+func (env *testEnv) applyActions(actions ...*types.Action) (*types.Receipt, error) {
+	feeAsset := env.feeAsset
+	if feeAsset == 0 {
+		feeAsset = env.config.FeeTokenID
+	}
+	signer := types.NewSigner(env.config.ChainID)
+	tx := types.NewTransaction(feeAsset, big.NewInt(1), actions...)
+	for _, action := range actions {
+		if err := types.SignAction(action, tx, signer, env.keys[action.Sender()]); err != nil {
+			env.t.Fatal(err)
+		}
+	}
+	genesis := env.chain.Genesis()
+	header := &types.Header{
+		ParentHash: genesis.Hash(),
+		Coinbase:   env.config.SysName,
+		Number:     new(big.Int).SetUint64(env.number),
+		GasLimit:   genesis.GasLimit(),
+		Time:       new(big.Int).SetUint64(genesis.Time().Uint64() + env.number),
+		Difficulty: genesis.Difficulty(),
+	}
+	env.number++
+
+	var usedGas uint64
+	gp := new(common.GasPool).AddGas(header.GasLimit)
+	env.statedb.Prepare(tx.Hash(), common.Hash{}, 0)
+	receipt, _, err := env.processor.ApplyTransaction(nil, gp, env.statedb, header, tx, &usedGas, vm.Config{})
+	return receipt, err
+}
+
+// mustApply applies the actions and fails the test unless all succeed.
+func (env *testEnv) mustApply(actions ...testAction) *types.Receipt {
+	receipt, err := env.apply(actions...)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	for i, result := range receipt.ActionResults {
+		if result.Status != types.ReceiptStatusSuccessful {
+			env.t.Fatalf("action %d failed: %s", i, result.Error)
+		}
+	}
+	return receipt
+}
+
+// balance returns the balance of the account in the system token.
+func (env *testEnv) balance(name common.Name) *big.Int {
+	am, err := accountmanager.NewAccountManager(env.statedb)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	balance, err := am.GetAccountBalanceByID(name, env.config.SysTokenID)
+	if err != nil {
+		env.t.Fatal(err)
+	}
+	return balance
+}
+
+func mustEncode(t *testing.T, val interface{}) []byte {
+	b, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMultisigTransactions(t *testing.T) {
+	env := newTestEnv(t, func(config *params.ChainConfig) {
+		config.ActionForks = []*params.ActionFork{{Block: 1000, ActionTypes: []uint64{uint64(types.StakeResource)}}}
+	})
+	var (
+		wallet  = common.Name("multiwallet")
+		signerA = common.Name("multisignera")
+		signerB = common.Name("multisignerb")
+	)
+	env.createAccounts(1000000000, wallet, signerA, signerB)
+
+	m := &accountmanager.Multisig{Threshold: 2, Approvers: []*accountmanager.MultisigApprover{{Name: signerA, Weight: 1}, {Name: signerB, Weight: 1}}}
+	env.mustApply(testAction{types.SetMultisig, wallet, wallet, 0, mustEncode(t, m)})
+
+	// the key of a multisig account can't sign its actions anymore
+	if _, err := env.apply(testAction{types.Transfer, wallet, signerA, 1, nil}); err != accountmanager.ErrMultisigSender {
+		t.Fatalf("signed multisig action error mismatch: have %v, want %v", err, accountmanager.ErrMultisigSender)
+	}
+
+	var id uint64
+	propose := func(actions ...*types.Action) (*types.Transaction, []byte) {
+		tx := types.NewTransaction(env.config.FeeTokenID, big.NewInt(0), actions...)
+		proposal := &accountmanager.MultisigProposal{TxHash: tx.Hash(), Expiry: env.number + 100}
+		env.mustApply(testAction{types.ProposeMultisig, signerA, wallet, 0, mustEncode(t, proposal)})
+		id++
+		return tx, mustEncode(t, &accountmanager.MultisigExecution{ID: id, Tx: tx})
+	}
+	execute := func(exec []byte) *types.ActionResult {
+		receipt, err := env.apply(testAction{types.ExecuteMultisig, signerB, wallet, 0, exec})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return receipt.ActionResults[0]
+	}
+	transfer := func(value int64) *types.Action {
+		return types.NewAction(types.Transfer, wallet, signerA, 0, env.config.SysTokenID, 100000, big.NewInt(value), nil)
+	}
+
+	// a proposal is executed once approved by the threshold
+	_, exec := propose(transfer(100))
+	if result := execute(exec); result.Status != types.ReceiptStatusFailed || result.Error != accountmanager.ErrMultisigThreshold.Error() {
+		t.Fatalf("unapproved execution mismatch: status %d, error %q", result.Status, result.Error)
+	}
+	env.mustApply(testAction{types.ApproveMultisig, signerB, wallet, 0, mustEncode(t, id)})
+	walletBalance, signerBalance := env.balance(wallet), env.balance(signerA)
+	if result := execute(exec); result.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("approved execution failed: %s", result.Error)
+	}
+	if have, want := env.balance(wallet), new(big.Int).Sub(walletBalance, big.NewInt(100)); have.Cmp(want) != 0 {
+		t.Fatalf("wallet balance mismatch: have %v, want %v", have, want)
+	}
+	if have, want := env.balance(signerA), new(big.Int).Add(signerBalance, big.NewInt(100)); have.Cmp(want) != 0 {
+		t.Fatalf("recipient balance mismatch: have %v, want %v", have, want)
+	}
+	if result := execute(exec); result.Status != types.ReceiptStatusFailed || result.Error != accountmanager.ErrMultisigTxExecuted.Error() {
+		t.Fatalf("repeated execution mismatch: status %d, error %q", result.Status, result.Error)
+	}
+
+	// a failing action reverts the whole proposal, and the inner actions are
+	// validated as the ones of a transaction
+	walletBalance = env.balance(wallet)
+	for _, inner := range []struct {
+		actions []*types.Action
+		err     error
+	}{
+		{[]*types.Action{transfer(1), transfer(walletBalance.Int64())}, accountmanager.ErrInsufficientBalance},
+		{[]*types.Action{transfer(1), types.NewAction(types.StakeResource, wallet, wallet, 0, env.config.SysTokenID, 100000, big.NewInt(1), nil)}, processor.ErrActionDisabled},
+	} {
+		_, exec := propose(inner.actions...)
+		env.mustApply(testAction{types.ApproveMultisig, signerB, wallet, 0, mustEncode(t, id)})
+		if result := execute(exec); result.Status != types.ReceiptStatusFailed || result.Error != inner.err.Error() {
+			t.Fatalf("failing execution mismatch: status %d, error %q, want %v", result.Status, result.Error, inner.err)
+		}
+		if have := env.balance(wallet); have.Cmp(walletBalance) != 0 {
+			t.Fatalf("wallet balance not reverted: have %v, want %v", have, walletBalance)
+		}
+		am, _ := accountmanager.NewAccountManager(env.statedb)
+		if tx, err := am.GetMultisigTx(wallet, id); err != nil || tx.Executed {
+			t.Fatalf("failed proposal marked executed: %v", err)
+		}
+	}
+}
+
+func TestNameAuctionActions(t *testing.T) {
+	env := newTestEnv(t, func(config *params.ChainConfig) {
+		config.NameAuction = &params.NameAuctionConfig{MaxLength: 8, Duration: 2, MinBid: big.NewInt(100), MinIncrement: 10}
+	})
+	bidder, name := common.Name("auctionbidder"), common.Name("shortnm1")
+	env.createAccounts(1000000000, bidder)
+	key := env.keys[bidder]
+	pub := common.BytesToPubKey(crypto.FromECDSAPub(&key.PublicKey))
+
+	// an auctioned name isn't created by any other action
+	for _, typ := range []types.ActionType{types.CreateAccount, types.Transfer} {
+		receipt, err := env.apply(testAction{typ, bidder, name, 1, pub[:]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := receipt.ActionResults[0]; result.Status != types.ReceiptStatusFailed || result.Error != accountmanager.ErrNameAuctioned.Error() {
+			t.Fatalf("action %d mismatch: status %d, error %q", typ, result.Status, result.Error)
+		}
+	}
+
+	env.mustApply(testAction{types.BidName, bidder, name, 200, nil})
+	if receipt, err := env.apply(testAction{types.SettleName, bidder, name, 0, pub[:]}); err != nil {
+		t.Fatal(err)
+	} else if result := receipt.ActionResults[0]; result.Error != accountmanager.ErrAuctionOpen.Error() {
+		t.Fatalf("open settlement mismatch: status %d, error %q", result.Status, result.Error)
+	}
+	env.mustApply(testAction{types.Transfer, bidder, bidder, 0, nil})
+	env.mustApply(testAction{types.SettleName, bidder, name, 0, pub[:]})
+
+	am, _ := accountmanager.NewAccountManager(env.statedb)
+	if ok, err := am.AccountIsExist(name); err != nil || !ok {
+		t.Fatalf("settled account not created: %v", err)
+	}
+}
+
+func TestReservedNameActions(t *testing.T) {
+	env := newTestEnv(t, func(config *params.ChainConfig) {
+		config.SysPrefix = params.DefaultSysPrefix
+	})
+	user := common.Name("reserveduser")
+	env.createAccounts(1000000000, user)
+	name := common.Name(params.DefaultSysPrefix + "new")
+	for _, typ := range []types.ActionType{types.CreateAccount, types.Transfer, types.CreateContract} {
+		receipt, err := env.apply(testAction{typ, user, name, 1, nil})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := receipt.ActionResults[0]; result.Status != types.ReceiptStatusFailed || result.Error != accountmanager.ErrReservedName.Error() {
+			t.Fatalf("action %d mismatch: status %d, error %q", typ, result.Status, result.Error)
+		}
+	}
+}
+
+func TestFeeAssetFork(t *testing.T) {
+	env := newTestEnv(t, func(config *params.ChainConfig) {
+		config.FeeAssetBlock = big.NewInt(10)
+	})
+	am, err := accountmanager.NewAccountManager(env.statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys := env.config.SysName
+	if err := am.IssueAsset(&asset.AssetObject{AssetName: "oldcoin", Symbol: "old", Amount: big.NewInt(1000000000), Decimals: 2, Owner: sys}); err != nil {
+		t.Fatal(err)
+	}
+	coin, err := am.GetAssetInfoByName("oldcoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.feeAsset = coin.AssetId
+
+	// before the fork gas is paid in any asset at its nominal price
+	receipt, err := env.apply(testAction{types.Transfer, sys, sys, 1, nil})
+	if err != nil {
+		t.Fatalf("transaction paying gas in another asset before the fork: %v", err)
+	}
+	if receipt.FeeAssetID != coin.AssetId || receipt.Fee.Uint64() != receipt.TotalGasUsed {
+		t.Fatalf("fee mismatch: have %v in asset %d, want %d in asset %d", receipt.Fee, receipt.FeeAssetID, receipt.TotalGasUsed, coin.AssetId)
+	}
+
+	// from the fork on only the fee token and whitelisted assets pay gas
+	env.number = 10
+	if _, err := env.apply(testAction{types.Transfer, sys, sys, 1, nil}); err != processor.ErrInvalidGasAsset {
+		t.Fatalf("error mismatch: have %v, want %v", err, processor.ErrInvalidGasAsset)
+	}
+}
+
+func TestFeeRefundKeepsChargedRate(t *testing.T) {
+	env := newTestEnv(t, func(config *params.ChainConfig) {
+		config.FeeAssetBlock = big.NewInt(0)
+	})
+	am, err := accountmanager.NewAccountManager(env.statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys := env.config.SysName
+	if err := am.IssueAsset(&asset.AssetObject{AssetName: "feecoin", Symbol: "fee", Amount: big.NewInt(1000000000), Decimals: 2, Owner: sys}); err != nil {
+		t.Fatal(err)
+	}
+	coin, err := am.GetAssetInfoByName("feecoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := am.SetFeeRate(coin.AssetId, &accountmanager.FeeRate{Num: big.NewInt(2), Den: big.NewInt(1)}); err != nil {
+		t.Fatal(err)
+	}
+	env.feeAsset = coin.AssetId
+
+	// the fee of an action changing or removing the rate of the asset it pays
+	// its gas in is the used gas at the rate it was bought at
+	for _, c := range []struct {
+		data []byte
+		rate uint64
+	}{
+		{mustEncode(t, &accountmanager.FeeRate{Num: big.NewInt(10), Den: big.NewInt(1)}), 2},
+		{nil, 10},
+	} {
+		nonce, err := am.GetNonce(sys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receipt, err := env.applyActions(types.NewAction(types.SetFeeAsset, sys, sys, nonce, coin.AssetId, 1000000, big.NewInt(0), c.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := receipt.ActionResults[0]; result.Status != types.ReceiptStatusSuccessful {
+			t.Fatalf("fee asset action failed: %s", result.Error)
+		}
+		want := new(big.Int).SetUint64(receipt.TotalGasUsed * c.rate)
+		if receipt.Fee.Cmp(want) != 0 {
+			t.Fatalf("fee mismatch: have %v, want %v", receipt.Fee, want)
+		}
+	}
+}
+
+func TestStorageRentFork(t *testing.T) {
+	env := newTestEnv(t, func(config *params.ChainConfig) {
+		config.StorageRent = big.NewInt(1)
+		config.StorageRentBlock = big.NewInt(5)
+	})
+	var (
+		user     = common.Name("rentuser")
+		plain    = common.Name("rentplain")
+		contract = common.Name("rentcontract")
+		// init code deploying the one byte runtime code STOP
+		code = common.Hex2Bytes("600060005360016000f3")
+	)
+	env.createAccounts(1000000000, user, plain)
+	env.mustApply(testAction{types.CreateContract, user, contract, 100, code})
+
+	rentPaid := func(name common.Name) uint64 {
+		am, err := accountmanager.NewAccountManager(env.statedb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := am.GetRentInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.RentPaid
+	}
+
+	// No rent is charged before the fork block.
+	env.mustApply(testAction{types.Transfer, user, contract, 0, nil})
+	if balance := env.balance(contract); balance.Cmp(big.NewInt(100)) != 0 {
+		t.Fatalf("balance before the fork mismatch: have %v, want 100", balance)
+	}
+	if paid := rentPaid(contract); paid != 0 {
+		t.Fatalf("rent paid before the fork: %d", paid)
+	}
+
+	// From the fork on the contract pays for its byte of code, counted from
+	// the fork block.
+	env.number = 8
+	env.mustApply(testAction{types.Transfer, user, contract, 0, nil}, testAction{types.Transfer, user, plain, 0, nil})
+	if balance := env.balance(contract); balance.Cmp(big.NewInt(97)) != 0 {
+		t.Fatalf("balance after the fork mismatch: have %v, want 97", balance)
+	}
+	if paid := rentPaid(contract); paid != 8 {
+		t.Fatalf("rent paid mismatch: have %d, want 8", paid)
+	}
+	// Accounts without code or storage keep no rent state.
+	if paid := rentPaid(plain); paid != 0 {
+		t.Fatalf("rent state written for an account without code: paid %d", paid)
+	}
+}
+
+func TestRateLimitActions(t *testing.T) {
+	env := newTestEnv(t, nil)
+	sys, user := env.config.SysName, common.Name("ratelimited")
+	env.createAccounts(1000000000, user)
+	limit := &accountmanager.RateLimit{MaxActions: 2, Window: 100}
+	env.mustApply(testAction{types.SetRateLimit, sys, sys, 0, mustEncode(t, limit)})
+
+	// An account sends at most two actions in the window of blocks 100-199.
+	env.number = 100
+	env.mustApply(testAction{types.Transfer, user, sys, 1, nil}, testAction{types.Transfer, user, sys, 1, nil})
+	env.number = 199
+	if _, err := env.apply(testAction{types.Transfer, user, sys, 1, nil}); err != accountmanager.ErrRateLimited {
+		t.Fatalf("error mismatch: have %v, want %v", err, accountmanager.ErrRateLimited)
+	}
+	// The system account isn't limited.
+	env.mustApply(testAction{types.Transfer, sys, user, 1, nil}, testAction{types.Transfer, sys, user, 1, nil}, testAction{types.Transfer, sys, user, 1, nil})
+
+	// The next window starts afresh.
+	env.number = 200
+	env.mustApply(testAction{types.Transfer, user, sys, 1, nil})
+
+	// Without limit the account sends any number of actions.
+	env.mustApply(testAction{types.SetRateLimit, sys, sys, 0, nil})
+	env.mustApply(testAction{types.Transfer, user, sys, 1, nil}, testAction{types.Transfer, user, sys, 1, nil}, testAction{types.Transfer, user, sys, 1, nil})
+}
+
+func TestReceiptBalanceChanges(t *testing.T) {
+	env := newTestEnv(t, nil)
+	alice, bob := common.Name("deltaalice"), common.Name("deltabob")
+	env.createAccounts(1000000000, alice, bob)
+
+	aliceBalance, bobBalance := env.balance(alice), env.balance(bob)
+	receipt := env.mustApply(testAction{types.Transfer, alice, bob, 100, nil})
+
+	// The deltas are the balance changes of the execution, the fee included.
+	deltas := make(map[common.Name]*big.Int)
+	for _, d := range types.NewBalanceDeltas(receipt.BalanceChanges) {
+		if d.AssetID != env.config.SysTokenID {
+			t.Fatalf("delta of asset %d, want %d", d.AssetID, env.config.SysTokenID)
+		}
+		deltas[d.Account] = d.Delta
+	}
+	for name, before := range map[common.Name]*big.Int{alice: aliceBalance, bob: bobBalance} {
+		want := new(big.Int).Sub(env.balance(name), before)
+		if have := deltas[name]; have == nil || have.Cmp(want) != 0 {
+			t.Errorf("delta of %s mismatch: have %v, want %v", name, have, want)
+		}
+	}
+	if want := new(big.Int).Neg(new(big.Int).Add(big.NewInt(100), receipt.Fee)); deltas[alice].Cmp(want) != 0 {
+		t.Errorf("sender delta mismatch: have %v, want %v", deltas[alice], want)
+	}
+	// The changes are stored with the receipt.
+	enc, err := rlp.EncodeToBytes((*types.ReceiptForStorage)(receipt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec types.ReceiptForStorage
+	if err := rlp.DecodeBytes(enc, &dec); err != nil {
+		t.Fatal(err)
+	}
+	if len(dec.BalanceChanges) != len(receipt.BalanceChanges) {
+		t.Fatalf("decoded changes mismatch: have %d, want %d", len(dec.BalanceChanges), len(receipt.BalanceChanges))
+	}
+}
+
+func TestWithdrawalActions(t *testing.T) {
+	env := newTestEnv(t, func(config *params.ChainConfig) {
+		config.Treasury = &params.TreasuryConfig{FeeShare: 20, VotingDuration: 10}
+	})
+	sys, pool, user := env.config.SysName, env.config.FeePool(), common.Name("treasuryuser")
+	env.createAccounts(1000000000, user)
+
+	// The fee pool is paid its share of the fees, the producer the rest.
+	poolBalance := env.balance(pool)
+	receipt := env.mustApply(testAction{types.Transfer, user, sys, 1, nil})
+	share := new(big.Int).Div(new(big.Int).Mul(receipt.Fee, big.NewInt(20)), big.NewInt(100))
+	if share.Sign() == 0 {
+		t.Fatal("no fee share paid")
+	}
+	var paid *big.Int
+	for _, p := range receipt.FeePayments {
+		if p.Kind == types.FeeToPool && p.Recipient == pool {
+			paid = p.Amount
+		}
+	}
+	if paid == nil || paid.Cmp(share) != 0 {
+		t.Fatalf("fee pool payment mismatch: have %v, want %v", paid, share)
+	}
+	if have, want := env.balance(pool), new(big.Int).Add(poolBalance, share); have.Cmp(want) != 0 {
+		t.Fatalf("fee pool balance mismatch: have %v, want %v", have, want)
+	}
+
+	// poolFee returns the share of the fee of the receipt paid to the pool.
+	poolFee := func(receipt *types.Receipt) *big.Int {
+		fee := new(big.Int)
+		for _, p := range receipt.FeePayments {
+			if p.Kind == types.FeeToPool {
+				fee.Add(fee, p.Amount)
+			}
+		}
+		return fee
+	}
+	// checkLog checks the audit log of the withdrawal in the receipt.
+	checkLog := func(receipt *types.Receipt, topic common.Hash, executed bool) {
+		if len(receipt.Logs) != 1 {
+			t.Fatalf("withdrawal logs mismatch: have %d, want 1", len(receipt.Logs))
+		}
+		l := receipt.Logs[0]
+		if l.Name != pool || len(l.Topics) != 2 || l.Topics[0] != topic || l.Topics[1] != common.BigToHash(big.NewInt(1)) {
+			t.Fatalf("withdrawal log mismatch: %+v", l)
+		}
+		var w accountmanager.Withdrawal
+		if err := rlp.DecodeBytes(l.Data, &w); err != nil {
+			t.Fatal(err)
+		}
+		if w.ID != 1 || w.Proposer != sys || w.Recipient != user || w.Amount.Cmp(share) != 0 || w.Executed != executed {
+			t.Fatalf("logged withdrawal mismatch: %+v", w)
+		}
+	}
+	failed := func(receipt *types.Receipt, err error, want error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := receipt.ActionResults[0]; result.Status != types.ReceiptStatusFailed || result.Error != want.Error() {
+			t.Fatalf("action result mismatch: have status %d error %q, want %v", result.Status, result.Error, want)
+		}
+		if len(receipt.Logs) != 0 {
+			t.Fatalf("failed action logged: %+v", receipt.Logs)
+		}
+	}
+
+	// Only the activated producers propose and approve withdrawals.
+	proposal := mustEncode(t, &accountmanager.WithdrawalProposal{Recipient: user, AssetID: env.config.SysTokenID, Amount: share, Memo: "grant"})
+	receipt, err := env.apply(testAction{types.ProposeWithdrawal, user, pool, 0, proposal})
+	failed(receipt, err, processor.ErrNotProducer)
+
+	checkLog(env.mustApply(testAction{types.ProposeWithdrawal, sys, pool, 0, proposal}), processor.WithdrawalProposedTopic, false)
+
+	receipt, err = env.apply(testAction{types.ApproveWithdrawal, user, pool, 0, mustEncode(t, uint64(1))})
+	failed(receipt, err, processor.ErrNotProducer)
+	receipt, err = env.apply(testAction{types.ApproveWithdrawal, sys, pool, 0, mustEncode(t, uint64(1))})
+	failed(receipt, err, accountmanager.ErrWithdrawalApproved)
+
+	// Anyone executes the approved withdrawal, paying the recipient from the pool.
+	poolBalance, userBalance := env.balance(pool), env.balance(user)
+	receipt = env.mustApply(testAction{types.ExecuteWithdrawal, user, pool, 0, mustEncode(t, uint64(1))})
+	checkLog(receipt, processor.WithdrawalExecutedTopic, true)
+	if have, want := env.balance(pool), new(big.Int).Add(new(big.Int).Sub(poolBalance, share), poolFee(receipt)); have.Cmp(want) != 0 {
+		t.Fatalf("fee pool balance mismatch: have %v, want %v", have, want)
+	}
+	if have, want := env.balance(user), new(big.Int).Sub(new(big.Int).Add(userBalance, share), receipt.Fee); have.Cmp(want) != 0 {
+		t.Fatalf("recipient balance mismatch: have %v, want %v", have, want)
+	}
+	receipt, err = env.apply(testAction{types.ExecuteWithdrawal, user, pool, 0, mustEncode(t, uint64(1))})
+	failed(receipt, err, accountmanager.ErrWithdrawalExecuted)
+}
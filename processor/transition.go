@@ -20,19 +20,37 @@ import (
 	"errors"
 	"math/big"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/processor/vm"
 	"github.com/fractalplatform/fractal/txpool"
 	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
 )
 
 var (
 	errInsufficientBalanceForGas = errors.New("insufficient balance to pay for gas")
 )
 
+// Topics of the logs the fee pool emits for the changes of the withdrawal
+// proposals, followed by the id of the proposal. The data of a log is the
+// RLP encoded proposal after the change.
+var (
+	WithdrawalProposedTopic = crypto.Keccak256Hash([]byte("WithdrawalProposed"))
+	WithdrawalApprovedTopic = crypto.Keccak256Hash([]byte("WithdrawalApproved"))
+	WithdrawalExecutedTopic = crypto.Keccak256Hash([]byte("WithdrawalExecuted"))
+)
+
+// systemActions are the action types only system accounts may send.
+var systemActions = map[types.ActionType]bool{
+	types.SetFeeAsset:    true,
+	types.SetMinGasPrice: true,
+	types.SetRateLimit:   true,
+}
+
 type StateTransition struct {
 	engine     EgnineContext
 	from       common.Name
@@ -44,6 +62,9 @@ type StateTransition struct {
 	assetID    uint64
 	account    *accountmanager.AccountManager
 	evm        *vm.EVM
+	charged    *big.Int // fee taken for the gas bought, in the gas asset
+	refunded   *big.Int // fee refunded for the remaining gas
+	fees       []*types.FeePayment
 }
 
 // NewStateTransition initialises and returns a new state transition object.
@@ -79,24 +100,25 @@ func (st *StateTransition) preCheck() error {
 
 func (st *StateTransition) buyGas() error {
 	mgval := new(big.Int).Mul(new(big.Int).SetUint64(st.action.Gas()), st.gasPrice)
-	balance, err := st.account.GetAccountBalanceByID(st.from, st.assetID)
-	//balance, err := st.account.GetAccountBalanceByID(st.from, st.assetID)
-	if err != nil {
-		return errInsufficientBalanceForGas
-	}
-	if balance.Cmp(mgval) < 0 {
-		return errInsufficientBalanceForGas
-	}
 	if err := st.gp.SubGas(st.action.Gas()); err != nil {
 		return err
 	}
-	st.gas += st.action.Gas()
-	st.initialGas = st.action.Gas()
-	err = st.account.SubAccountBalanceByID(st.from, st.assetID, mgval)
+	// before the fee asset fork gas is priced in the asset it is paid in
+	feeTokenID := st.assetID
+	if cfg := st.evm.ChainConfig(); cfg.IsFeeAsset(st.evm.BlockNumber.Uint64()) {
+		feeTokenID = cfg.FeeTokenID
+	}
+	charged, err := st.account.ChargeFee(st.from, st.assetID, feeTokenID, mgval)
 	if err != nil {
-		return err
+		st.gp.AddGas(st.action.Gas())
+		if err == accountmanager.ErrFeeAssetNotAllowed {
+			return err
+		}
+		return errInsufficientBalanceForGas
 	}
-	//st.account.SubAccountBalanceByID(st.from, st.assetID, mgval)
+	st.gas += st.action.Gas()
+	st.initialGas = st.action.Gas()
+	st.charged = charged
 	return nil
 }
 
@@ -116,20 +138,82 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bo
 		return nil, 0, true, err, vmerr
 	}
 
-	sender := vm.AccountRef(st.from)
+	// vm errors do not effect consensus and are therefor
+	// not assigned to err, except for insufficient balance
+	// error.
+	ret, vmerr = st.apply()
+	if vmerr != nil {
+		log.Debug("VM returned with error", "err", vmerr)
+		// The only possible consensus-error would be if there wasn't
+		// sufficient balance to make the transfer happen. The first
+		// balance transfer may never fail.
+		if vmerr == vm.ErrInsufficientBalance {
+			return nil, 0, false, vmerr, vmerr
+		}
+	}
+	nonce, err := st.account.GetNonce(st.from)
+	if err != nil {
+		return nil, st.gasUsed(), true, err, vmerr
+	}
+	err = st.account.SetNonce(st.from, nonce+1)
+	if err != nil {
+		return nil, st.gasUsed(), true, err, vmerr
+	}
+	st.refundGas()
+	st.payFee()
+	return ret, st.gasUsed(), vmerr != nil, nil, vmerr
+}
 
-	var (
-		evm = st.evm
-		// vm errors do not effect consensus and are therefor
-		// not assigned to err, except for insufficient balance
-		// error.
-	)
+// apply executes the action of the state transition, returning the error of
+// the execution.
+func (st *StateTransition) apply() (ret []byte, vmerr error) {
+	sender := vm.AccountRef(st.from)
+	evm := st.evm
 	actionType := st.action.Type()
 	switch {
+	case systemActions[actionType] && !st.fromSystemAccount():
+		vmerr = ErrNotSystemAccount
 	case actionType == types.CreateContract:
 		ret, st.gas, vmerr = evm.Create(sender, st.action, st.gas)
 	case actionType == types.Transfer:
 		ret, st.gas, vmerr = evm.Call(sender, st.action, st.gas)
+	case actionType == types.CallContract:
+		ret, st.gas, vmerr = evm.CallContract(sender, st.action, st.gas)
+	case actionType == types.CreateAccount && vm.IsNativeContract(st.action.Recipient()) && evm.ChainConfig().IsNative(evm.BlockNumber.Uint64()):
+		vmerr = vm.ErrReservedName
+	case actionType == types.ReviveAccount:
+		cfg := evm.ChainConfig()
+		vmerr = st.account.ReviveAccount(st.from, st.action.Recipient(), evm.BlockNumber.Uint64(), cfg.StorageRent, cfg.FeeTokenID, evm.Coinbase)
+	case actionType == types.BidName:
+		vmerr = st.bidName()
+	case actionType == types.SettleName:
+		var key common.PubKey
+		key.SetBytes(st.action.Data())
+		vmerr = st.account.SettleName(st.from, st.action.Recipient(), key, evm.BlockNumber.Uint64(), evm.ChainConfig().SysName)
+	case actionType == types.SetFeeAsset:
+		vmerr = st.setFeeAsset()
+	case actionType == types.SetMinGasPrice:
+		vmerr = st.setMinGasPrice()
+	case actionType == types.SetRateLimit:
+		vmerr = st.setRateLimit()
+	case actionType == types.StakeResource:
+		fallthrough
+	case actionType == types.UnstakeResource:
+		vmerr = st.stakeResource()
+	case actionType == types.ProposeWithdrawal:
+		fallthrough
+	case actionType == types.ApproveWithdrawal:
+		fallthrough
+	case actionType == types.ExecuteWithdrawal:
+		vmerr = st.withdrawal()
+	case actionType == types.SetMultisig:
+		vmerr = st.setMultisig()
+	case actionType == types.ProposeMultisig:
+		fallthrough
+	case actionType == types.ApproveMultisig:
+		vmerr = st.approveMultisig()
+	case actionType == types.ExecuteMultisig:
+		ret, vmerr = st.executeMultisig()
 	case actionType == types.RegProducer:
 		fallthrough
 	case actionType == types.UpdateProducer:
@@ -143,45 +227,302 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bo
 	case actionType == types.ChangeProducer:
 		fallthrough
 	case actionType == types.UnvoteProducer:
+		fallthrough
+	case actionType == types.UpdateProducerKey:
 		vmerr = st.engine.ProcessAction(st.evm.ChainConfig(), st.evm.StateDB, st.action)
 	default:
 		vmerr = st.account.Process(st.action)
 	}
-	if vmerr != nil {
-		log.Debug("VM returned with error", "err", vmerr)
-		// The only possible consensus-error would be if there wasn't
-		// sufficient balance to make the transfer happen. The first
-		// balance transfer may never fail.
-		if vmerr == vm.ErrInsufficientBalance {
-			return nil, 0, false, vmerr, vmerr
+	return ret, vmerr
+}
+
+// fromSystemAccount reports whether the sender is the system account of the
+// chain or another system account.
+func (st *StateTransition) fromSystemAccount() bool {
+	if st.from == st.evm.ChainConfig().SysName {
+		return true
+	}
+	ok, err := st.account.IsSystemAccount(st.from)
+	return err == nil && ok
+}
+
+// bidName bids the value of the action, in the system token, for the
+// recipient name.
+func (st *StateTransition) bidName() error {
+	cfg := st.evm.ChainConfig()
+	if !cfg.NameAuction.IsAuctioned(st.action.Recipient()) {
+		return accountmanager.ErrNoAuction
+	}
+	if st.action.AssetID() != cfg.SysTokenID {
+		return accountmanager.ErrAssetIDInvalid
+	}
+	return st.account.BidName(st.from, st.action.Recipient(), st.action.AssetID(), st.action.Value(), st.evm.BlockNumber.Uint64(), cfg.NameAuction)
+}
+
+// setFeeAsset sets the fee exchange rate of the asset of the action to the
+// rate in its data, or removes the asset from the fee whitelist if the data is
+// empty. Only the system account governs the whitelist.
+func (st *StateTransition) setFeeAsset() error {
+	cfg := st.evm.ChainConfig()
+	if st.from != cfg.SysName {
+		return ErrNotSystemAccount
+	}
+	if st.action.AssetID() == cfg.FeeTokenID {
+		return accountmanager.ErrAssetIDInvalid
+	}
+	if len(st.action.Data()) == 0 {
+		return st.account.SetFeeRate(st.action.AssetID(), nil)
+	}
+	var rate accountmanager.FeeRate
+	if err := rlp.DecodeBytes(st.action.Data(), &rate); err != nil {
+		return err
+	}
+	return st.account.SetFeeRate(st.action.AssetID(), &rate)
+}
+
+// setMinGasPrice sets the minimum gas price of an action type to the one in
+// the data of the action. Only the system account governs the minimums.
+func (st *StateTransition) setMinGasPrice() error {
+	if st.from != st.evm.ChainConfig().SysName {
+		return ErrNotSystemAccount
+	}
+	var price accountmanager.MinGasPrice
+	if err := rlp.DecodeBytes(st.action.Data(), &price); err != nil {
+		return err
+	}
+	return st.account.SetMinGasPrice(price.Type, price.Price)
+}
+
+// setRateLimit sets the limit of the actions of an account to the one in the
+// data of the action, or removes it if the data is empty.
+func (st *StateTransition) setRateLimit() error {
+	if st.from != st.evm.ChainConfig().SysName {
+		return ErrNotSystemAccount
+	}
+	if len(st.action.Data()) == 0 {
+		return st.account.SetRateLimit(nil)
+	}
+	var limit accountmanager.RateLimit
+	if err := rlp.DecodeBytes(st.action.Data(), &limit); err != nil {
+		return err
+	}
+	return st.account.SetRateLimit(&limit)
+}
+
+// stakeResource stakes or unstakes the value of the action, in the system
+// token, for a gas quota of the sender.
+func (st *StateTransition) stakeResource() error {
+	cfg := st.evm.ChainConfig()
+	if cfg.Resources == nil {
+		return accountmanager.ErrResourcesDisabled
+	}
+	if st.action.AssetID() != cfg.SysTokenID {
+		return accountmanager.ErrAssetIDInvalid
+	}
+	if st.action.Type() == types.UnstakeResource {
+		return st.account.UnstakeResource(st.from, st.action.AssetID(), st.action.Value(), st.evm.BlockNumber.Uint64(), cfg.Resources)
+	}
+	return st.account.StakeResource(st.from, st.action.AssetID(), st.action.Value())
+}
+
+// withdrawal proposes, approves or executes a withdrawal from the fee pool and
+// logs the proposal after the change. Only the activated producers propose
+// and approve withdrawals, anyone executes an approved one.
+func (st *StateTransition) withdrawal() error {
+	cfg := st.evm.ChainConfig()
+	if cfg.Treasury == nil {
+		return accountmanager.ErrTreasuryDisabled
+	}
+	producers, err := st.engine.ActivatedProducers(st.evm.StateDB)
+	if err != nil {
+		return err
+	}
+	number := st.evm.BlockNumber.Uint64()
+	if st.action.Type() == types.ExecuteWithdrawal {
+		var id uint64
+		if err := rlp.DecodeBytes(st.action.Data(), &id); err != nil {
+			return err
 		}
+		w, err := st.account.ExecuteWithdrawal(id, cfg.FeePool(), number, producers)
+		if err != nil {
+			return err
+		}
+		return st.logWithdrawal(WithdrawalExecutedTopic, w)
 	}
-	nonce, err := st.account.GetNonce(st.from)
+
+	producer := false
+	for _, name := range producers {
+		if common.Name(name) == st.from {
+			producer = true
+			break
+		}
+	}
+	if !producer {
+		return ErrNotProducer
+	}
+	if st.action.Type() == types.ProposeWithdrawal {
+		var proposal accountmanager.WithdrawalProposal
+		if err := rlp.DecodeBytes(st.action.Data(), &proposal); err != nil {
+			return err
+		}
+		w, err := st.account.ProposeWithdrawal(st.from, &proposal, number, cfg.Treasury.VotingDuration)
+		if err != nil {
+			return err
+		}
+		return st.logWithdrawal(WithdrawalProposedTopic, w)
+	}
+	var id uint64
+	if err := rlp.DecodeBytes(st.action.Data(), &id); err != nil {
+		return err
+	}
+	w, err := st.account.ApproveWithdrawal(id, st.from, number)
 	if err != nil {
-		return nil, st.gasUsed(), true, err, vmerr
+		return err
 	}
-	err = st.account.SetNonce(st.from, nonce+1)
+	return st.logWithdrawal(WithdrawalApprovedTopic, w)
+}
+
+// logWithdrawal adds the log of a change of the withdrawal proposal to the
+// receipt, as the audit record of the fee pool.
+func (st *StateTransition) logWithdrawal(topic common.Hash, w *accountmanager.Withdrawal) error {
+	data, err := rlp.EncodeToBytes(w)
 	if err != nil {
-		return nil, st.gasUsed(), true, err, vmerr
+		return err
 	}
-	st.refundGas()
-	st.account.AddAccountBalanceByID(st.evm.Coinbase, st.assetID, new(big.Int).Mul(st.gasPrice, new(big.Int).SetUint64(st.gasUsed())))
-	return ret, st.gasUsed(), vmerr != nil, nil, vmerr
+	st.evm.StateDB.AddLog(&types.Log{
+		Name:        st.evm.ChainConfig().FeePool(),
+		Topics:      []common.Hash{topic, common.BigToHash(new(big.Int).SetUint64(w.ID))},
+		Data:        data,
+		BlockNumber: st.evm.BlockNumber.Uint64(),
+	})
+	return nil
+}
+
+// setMultisig sets the approvers of the multisig proposals of the sender to
+// the ones in the data of the action, or removes them if the data is empty.
+func (st *StateTransition) setMultisig() error {
+	if len(st.action.Data()) == 0 {
+		return st.account.SetMultisig(st.from, nil)
+	}
+	var m accountmanager.Multisig
+	if err := rlp.DecodeBytes(st.action.Data(), &m); err != nil {
+		return err
+	}
+	return st.account.SetMultisig(st.from, &m)
+}
+
+// approveMultisig proposes a transaction of the multisig recipient, or
+// approves one of its proposals, on behalf of the sender.
+func (st *StateTransition) approveMultisig() error {
+	number := st.evm.BlockNumber.Uint64()
+	if st.action.Type() == types.ProposeMultisig {
+		var proposal accountmanager.MultisigProposal
+		if err := rlp.DecodeBytes(st.action.Data(), &proposal); err != nil {
+			return err
+		}
+		_, err := st.account.ProposeMultisig(st.action.Recipient(), st.from, &proposal, number)
+		return err
+	}
+	var id uint64
+	if err := rlp.DecodeBytes(st.action.Data(), &id); err != nil {
+		return err
+	}
+	_, err := st.account.ApproveMultisig(st.action.Recipient(), id, st.from, number)
+	return err
+}
+
+// executeMultisig executes the transaction of an approved proposal of the
+// multisig recipient. Its actions are sent by the multisig account with the
+// gas of the action, validated and rate limited as the actions of a
+// transaction, and are all reverted if one of them fails.
+func (st *StateTransition) executeMultisig() ([]byte, error) {
+	var exec accountmanager.MultisigExecution
+	if err := rlp.DecodeBytes(st.action.Data(), &exec); err != nil {
+		return nil, err
+	}
+	name := st.action.Recipient()
+	if exec.Tx == nil || len(exec.Tx.GetActions()) == 0 {
+		return nil, accountmanager.ErrMultisigTxInvalid
+	}
+	for _, action := range exec.Tx.GetActions() {
+		if action.Sender() != name || action.Type() == types.ExecuteMultisig {
+			return nil, accountmanager.ErrMultisigTxInvalid
+		}
+	}
+
+	number := st.evm.BlockNumber.Uint64()
+	snap := st.evm.StateDB.Snapshot()
+	if _, err := st.account.ExecuteMultisig(name, exec.ID, exec.Tx.Hash(), number); err != nil {
+		return nil, err
+	}
+	var ret []byte
+	for _, action := range exec.Tx.GetActions() {
+		err := validateAction(st.evm.ChainConfig(), st.account, action, number)
+		if err == nil && action.Sender() != st.evm.ChainConfig().SysName {
+			err = st.account.UseRateLimit(action.Sender(), number)
+		}
+		if err == nil {
+			var gas uint64
+			if gas, err = txpool.IntrinsicGas(action); err == nil {
+				err = st.useGas(gas)
+			}
+		}
+		if err == nil {
+			inner := *st
+			inner.action, inner.from = action, action.Sender()
+			ret, err = inner.apply()
+			st.gas = inner.gas
+		}
+		if err != nil {
+			st.evm.StateDB.RevertToSnapshot(snap)
+			// The balance of the multisig account isn't checked before the
+			// execution, so its shortage is a failure of the action only.
+			if err == vm.ErrInsufficientBalance {
+				err = accountmanager.ErrInsufficientBalance
+			}
+			return nil, err
+		}
+	}
+	return ret, nil
 }
 
 func (st *StateTransition) refundGas() {
 	st.gas += st.evm.StateDB.GetRefund()
 
-	// Return remaining gas, exchanged at the original rate.
-	remaining := new(big.Int).Mul(new(big.Int).SetUint64(st.gas), st.gasPrice)
-	st.account.AddAccountBalanceByID(st.from, st.assetID, remaining)
-	//st.account.AddAccountBalanceByID(st.from, st.assetID, remaining)
+	// Return the share of the charged fee of the remaining gas, so the
+	// refund keeps the rate the gas was bought at even if the action changed
+	// or removed the rate of the fee asset.
+	st.refunded = new(big.Int)
+	if st.initialGas > 0 {
+		st.refunded.Mul(st.charged, new(big.Int).SetUint64(st.gas))
+		st.refunded.Div(st.refunded, new(big.Int).SetUint64(st.initialGas))
+	}
+	st.account.AddAccountBalanceByID(st.from, st.assetID, st.refunded)
 
 	// Also return remaining gas to the block gas counter so it is
 	// available for the next message.
 	st.gp.AddGas(st.gas)
 }
 
+// payFee pays the price of the used gas to the block producer, less the share
+// of the fee pool if the chain has a treasury.
+func (st *StateTransition) payFee() {
+	fee := new(big.Int).Sub(st.charged, st.refunded)
+	cfg := st.evm.ChainConfig()
+	if pool := cfg.Treasury.PoolFee(fee); pool.Sign() > 0 {
+		fee.Sub(fee, pool)
+		st.account.AddAccountBalanceByID(cfg.FeePool(), st.assetID, pool)
+		st.fees = types.AddFeePayment(st.fees, types.FeeToPool, cfg.FeePool(), pool)
+	}
+	st.account.AddAccountBalanceByID(st.evm.Coinbase, st.assetID, fee)
+	st.fees = types.AddFeePayment(st.fees, types.FeeToProducer, st.evm.Coinbase, fee)
+}
+
+// Fees returns the fee payments made by the state transition.
+func (st *StateTransition) Fees() []*types.FeePayment {
+	return st.fees
+}
+
 // gasUsed returns the amount of gas used up by the state transition.
 func (st *StateTransition) gasUsed() uint64 {
 	return st.initialGas - st.gas
@@ -89,15 +89,9 @@ func (v *BlockValidator) ValidateHeader(header *types.Header, seal bool) error {
 		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
 	}
 
-	// Verify that the gas limit remains within allowed bounds
-	diff := int64(parent.GasLimit) - int64(header.GasLimit)
-	if diff < 0 {
-		diff *= -1
-	}
-	limit := parent.GasLimit / params.GasLimitBoundDivisor
-
-	if uint64(diff) >= limit || header.GasLimit < params.MinGasLimit {
-		return fmt.Errorf("invalid gas limit: have %d, want %d += %d", header.GasLimit, parent.GasLimit, limit)
+	// Verify that the gas limit voted by the producer remains within allowed bounds
+	if err := verifyGasLimit(parent.GasLimit, header.GasLimit); err != nil {
+		return err
 	}
 	// Verify that the block number is parent's +1
 	if diff := new(big.Int).Sub(header.Number, parent.Number); diff.Cmp(big.NewInt(1)) != 0 {
@@ -113,6 +107,21 @@ func (v *BlockValidator) ValidateHeader(header *types.Header, seal bool) error {
 	return nil
 }
 
+// verifyGasLimit checks that the gas limit moves from the one of the parent by
+// less than the bound a producer may adjust it by in a block.
+func verifyGasLimit(parentGasLimit, gasLimit uint64) error {
+	diff := int64(parentGasLimit) - int64(gasLimit)
+	if diff < 0 {
+		diff *= -1
+	}
+	limit := parentGasLimit / params.GasLimitBoundDivisor
+
+	if uint64(diff) >= limit || gasLimit < params.MinGasLimit {
+		return fmt.Errorf("invalid gas limit: have %d, want %d += %d", gasLimit, parentGasLimit, limit)
+	}
+	return nil
+}
+
 // ValidateBody validates the given block's uncles and verifies the the block
 // header's transaction and uncle roots. The headers are assumed to be already
 // validated at this point.
@@ -129,6 +138,22 @@ func (v *BlockValidator) ValidateBody(block *types.Block) error {
 		return ErrPrunedAncestor
 	}
 
+	// Bound the block contents before hashing them
+	if config := v.bc.Config(); config.IsBlockLimits(block.NumberU64()) {
+		limits := config.BlockLimits
+		if uint64(block.Size()) > limits.BlockSize() {
+			return ErrBlockTooLarge
+		}
+		if uint64(len(block.Txs)) > limits.BlockTxs() {
+			return ErrTooManyTxs
+		}
+		for _, tx := range block.Txs {
+			if n := uint64(len(tx.GetActions())); n == 0 || n > limits.TxActions() {
+				return ErrTooManyActions
+			}
+		}
+	}
+
 	// Header validity is known at this point, check the uncles and transactions
 	header := block.Header()
 	if hash := types.DeriveTxMerkleRoot(block.Txs); hash != header.TxsRoot {
@@ -15,12 +15,45 @@
 // along with this program. If not, see <http://www.gnu.org/licenses/>.
 package processor
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/types"
+)
 
 func TestValidateHeader(t *testing.T) {
 	// todo
 }
 
+func TestGasLimitVote(t *testing.T) {
+	for _, target := range []uint64{params.GenesisGasLimit * 2, params.GenesisGasLimit / 2, params.GenesisGasLimit + 100, 1} {
+		limit := params.GenesisGasLimit
+		for i := 0; i < 20000 && limit != target; i++ {
+			parent := types.NewBlockWithHeader(&types.Header{GasLimit: limit})
+			next := params.CalcGasLimitTarget(parent, target)
+			if err := verifyGasLimit(limit, next); err != nil {
+				t.Fatalf("target %d, block %d: %v", target, i, err)
+			}
+			if next == limit {
+				break
+			}
+			limit = next
+		}
+		want := target
+		if want < params.MinGasLimit {
+			want = params.MinGasLimit
+		}
+		if limit != want {
+			t.Errorf("target %d: gas limit %d, want %d", target, limit, want)
+		}
+	}
+	// a producer can't move the limit by the full bound
+	if err := verifyGasLimit(params.GenesisGasLimit, params.GenesisGasLimit+params.GenesisGasLimit/params.GasLimitBoundDivisor); err == nil {
+		t.Error("gas limit moved by the bound accepted")
+	}
+}
+
 func TestValidateTxs(t *testing.T) {
 	// todo
 }
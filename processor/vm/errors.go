@@ -25,4 +25,7 @@ var (
 	ErrTraceLimitReached        = errors.New("the number of logs reached the specified limit")
 	ErrInsufficientBalance      = errors.New("insufficient balance for transfer")
 	ErrContractAddressCollision = errors.New("contract name collision")
+	ErrNotContract              = errors.New("recipient is not a contract")
+	ErrHibernated               = errors.New("contract is hibernated for unpaid storage rent")
+	ErrExecutionReverted        = errors.New("evm: execution reverted")
 )
@@ -38,7 +38,6 @@ var (
 	tt255                    = math.BigPow(2, 255)
 	errWriteProtection       = errors.New("evm: write protection")
 	errReturnDataOutOfBounds = errors.New("evm: return data out of bounds")
-	errExecutionReverted     = errors.New("evm: execution reverted")
 	errMaxCodeSizeExceeded   = errors.New("evm: max code size exceeded")
 )
 
@@ -631,10 +630,16 @@ func opSload(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *St
 	return nil, nil
 }
 
+// storageSlotSize is the number of bytes a storage slot, key and value,
+// counts for storage rent.
+const storageSlotSize = 2 * common.HashLength
+
 func opSstore(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
 	loc := common.BigToHash(stack.pop())
 	val := stack.pop()
-	evm.StateDB.SetState(contract.Name().String(), loc, common.BigToHash(val))
+	if err := evm.setState(contract.Name(), loc, common.BigToHash(val)); err != nil {
+		return nil, err
+	}
 
 	evm.interpreter.intPool.put(val)
 	return nil, nil
@@ -687,40 +692,18 @@ func opGas(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stac
 	return nil, nil
 }
 
+// opCreate always fails: contract accounts are addressed by name, which the
+// CREATE opcode has no way to supply. Contracts are deployed with a
+// CreateContract action instead. From the CreateOpBlock fork on the arguments
+// are popped and the failure pushed so the stack stays consistent for the
+// calling contract, before the stack is left untouched.
 func opCreate(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
-	// var (
-	// 	value        = stack.pop()
-	// 	offset, size = stack.pop(), stack.pop()
-	// 	input        = memory.Get(offset.Int64(), size.Int64())
-	// 	gas          = contract.Gas
-	// 	assetAddr    = contract.AssetId
-	// )
-	// //if evm.ChainConfig().IsEIP150(evm.BlockNumber) {
-	// //	gas -= gas / 64
-	// //}
-
-	// contract.UseGas(gas)
-	// // todo
-	// action := types.NewAction(types.CreateContract, contract.Name(), "", 0, gas, value, input)
-
-	// res, name, returnGas, suberr := evm.Create(contract, action, gas)
-	// // Push item on the stack based on the returned error. If the ruleset is
-	// // homestead we must check for CodeStoreOutOfGasError (homestead only
-	// // rule) and treat as an error, if the ruleset is frontier we must
-	// // ignore this error and pretend the operation was successful.
-	// //if evm.ChainConfig().IsHomestead(evm.BlockNumber) && suberr == ErrCodeStoreOutOfGas {
-	// //	stack.push(evm.interpreter.intPool.getZero())
-	// if suberr != nil && suberr != ErrCodeStoreOutOfGas {
-	// 	stack.push(evm.interpreter.intPool.getZero())
-	// } else {
-	// 	stack.push(name.Big())
-	// }
-	// contract.Gas += returnGas
-	// evm.interpreter.intPool.put(value, offset, size)
-
-	// if suberr == errExecutionReverted {
-	// 	return res, nil
-	// }
+	if !evm.createOpEnabled() {
+		return nil, nil
+	}
+	value, offset, size := stack.pop(), stack.pop(), stack.pop()
+	evm.interpreter.intPool.put(value, offset, size)
+	stack.push(evm.interpreter.intPool.getZero())
 	return nil, nil
 }
 
@@ -747,7 +730,7 @@ func opCall(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *Sta
 	} else {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	}
-	if err == nil || err == errExecutionReverted {
+	if err == nil || err == ErrExecutionReverted {
 		memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
 	}
 	contract.Gas += returnGas
@@ -781,7 +764,7 @@ func opCallCode(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack
 	} else {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	}
-	if err == nil || err == errExecutionReverted {
+	if err == nil || err == ErrExecutionReverted {
 		memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
 	}
 	contract.Gas += returnGas
@@ -806,7 +789,7 @@ func opDelegateCall(pc *uint64, evm *EVM, contract *Contract, memory *Memory, st
 	} else {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	}
-	if err == nil || err == errExecutionReverted {
+	if err == nil || err == ErrExecutionReverted {
 		memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
 	}
 	contract.Gas += returnGas
@@ -940,7 +923,7 @@ func opCallEx(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stack *S
 	} else {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	}
-	if err == nil || err == errExecutionReverted {
+	if err == nil || err == ErrExecutionReverted {
 		memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
 	}
 	contract.Gas += returnGas
@@ -965,7 +948,7 @@ func opStaticCall(pc *uint64, evm *EVM, contract *Contract, memory *Memory, stac
 	} else {
 		stack.push(evm.interpreter.intPool.get().SetUint64(1))
 	}
-	if err == nil || err == errExecutionReverted {
+	if err == nil || err == ErrExecutionReverted {
 		memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
 	}
 	contract.Gas += returnGas
@@ -0,0 +1,53 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/params"
+)
+
+func TestOpCreateFork(t *testing.T) {
+	config := &params.ChainConfig{CreateOpBlock: big.NewInt(10)}
+	for _, test := range []struct {
+		number int64
+		depth  int
+	}{
+		// Before the fork CREATE leaves the stack untouched.
+		{9, 3},
+		// From the fork on it pops its arguments and pushes a zero.
+		{10, 1},
+	} {
+		evm := NewEVM(Context{BlockNumber: big.NewInt(test.number)}, nil, nil, config, Config{})
+		stack := newstack()
+		for i := int64(1); i <= 3; i++ {
+			stack.push(big.NewInt(i))
+		}
+		pc := uint64(0)
+		if _, err := opCreate(&pc, evm, nil, nil, stack); err != nil {
+			t.Fatalf("block %d: %v", test.number, err)
+		}
+		if stack.len() != test.depth {
+			t.Fatalf("block %d: stack depth mismatch: have %d, want %d", test.number, stack.len(), test.depth)
+		}
+		if test.depth == 1 && stack.peek().Sign() != 0 {
+			t.Fatalf("block %d: pushed %v, want 0", test.number, stack.peek())
+		}
+	}
+}
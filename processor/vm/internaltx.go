@@ -0,0 +1,77 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// internalTransfer is the type of internal transactions made by asset
+// transfers from contract code rather than by a call.
+const internalTransfer = "TRANSFER"
+
+// addInternalTx records a call or creation made by contract code. Top level
+// actions aren't recorded since they are part of the transaction. The record
+// is added before the callee runs so the index keeps the call order, and is
+// dropped with the state changes if an enclosing call reverts. It returns nil
+// if nothing was recorded.
+func (evm *EVM) addInternalTx(typ OpCode, from, to common.Name, assetID uint64, value *big.Int, gas uint64) *types.InternalTx {
+	if evm.depth == 0 {
+		return nil
+	}
+	return evm.recordInternalTx(typ.String(), from, to, assetID, value, gas)
+}
+
+// closeInternalTx fills in the outcome of an internal transaction added by
+// addInternalTx. It is meant to be deferred with the named results of the call.
+func (evm *EVM) closeInternalTx(itx *types.InternalTx, gas uint64, leftOverGas *uint64, err *error) {
+	if itx == nil {
+		return
+	}
+	if *leftOverGas <= gas {
+		itx.GasUsed = gas - *leftOverGas
+	}
+	if *err != nil {
+		itx.Error = (*err).Error()
+	}
+}
+
+// addInternalTransfer records an asset transfer made by contract code
+// through a native contract or a host function.
+func (evm *EVM) addInternalTransfer(from, to common.Name, assetID uint64, amount *big.Int) {
+	evm.recordInternalTx(internalTransfer, from, to, assetID, amount, 0)
+}
+
+func (evm *EVM) recordInternalTx(typ string, from, to common.Name, assetID uint64, value *big.Int, gas uint64) *types.InternalTx {
+	if value == nil {
+		value = new(big.Int)
+	}
+	itx := &types.InternalTx{
+		Type:    typ,
+		From:    from,
+		To:      to,
+		AssetID: assetID,
+		Value:   new(big.Int).Set(value),
+		Gas:     gas,
+		Depth:   uint64(evm.depth),
+	}
+	evm.StateDB.AddInternalTx(itx)
+	return itx
+}
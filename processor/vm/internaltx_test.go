@@ -0,0 +1,108 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func TestInternalTxs(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+	am, _ := accountmanager.NewAccountManager(statedb)
+
+	key, _ := crypto.GenerateKey()
+	pubkey := common.BytesToPubKey(crypto.FromECDSAPub(&key.PublicKey))
+	sender, receiver := common.Name("senderacct"), common.Name("receiveracct")
+	for _, name := range []common.Name{sender, receiver} {
+		if err := am.CreateAccount(name, pubkey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ao, _ := asset.NewAssetObject("internalcoin", "icn", big.NewInt(1000), 0, sender)
+	if err := am.IssueAsset(ao); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := am.GetAssetInfoByName("internalcoin")
+	id := info.GetAssetId()
+
+	config := &params.ChainConfig{NativeBlock: big.NewInt(0)}
+	evm := NewEVM(Context{BlockNumber: big.NewInt(0)}, am, statedb, config, Config{})
+	transfer := func(amount uint64) error {
+		input := concat(nameWord(receiver), uintWord(id), uintWord(amount))
+		action := types.NewAction(types.CallContract, sender, "systransfer", 0, id, 100000, big.NewInt(0), input)
+		_, _, err := evm.Call(AccountRef(sender), action, 100000)
+		return err
+	}
+
+	// Calls of top level actions are part of the transaction, but the
+	// transfers they make are not.
+	statedb.Prepare(common.BytesToHash([]byte{1}), common.Hash{}, 0)
+	if err := transfer(100); err != nil {
+		t.Fatal(err)
+	}
+	if itxs := statedb.InternalTxs(); len(itxs) != 1 || itxs[0].Type != internalTransfer || itxs[0].To != receiver || itxs[0].Value.Uint64() != 100 {
+		t.Fatalf("top level internal transactions mismatch: %+v", itxs)
+	}
+
+	// Calls made by contract code are recorded in order, failed ones included.
+	evm.depth = 1
+	statedb.PrepareAction(1)
+	if err := transfer(100); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer(10000); err != ErrInsufficientBalance {
+		t.Fatalf("transfer error mismatch: have %v, want %v", err, ErrInsufficientBalance)
+	}
+	itxs := statedb.InternalTxs()
+	if len(itxs) != 4 {
+		t.Fatalf("internal transaction count mismatch: have %d, want 4", len(itxs))
+	}
+	for i, want := range []struct {
+		typ     string
+		to      common.Name
+		failed  bool
+		gasUsed bool
+	}{{"CALL", "systransfer", false, true}, {internalTransfer, receiver, false, false}, {"CALL", "systransfer", true, true}} {
+		itx := itxs[i+1]
+		if itx.Type != want.typ || itx.To != want.to || (itx.Error != "") != want.failed || (itx.GasUsed != 0) != want.gasUsed {
+			t.Errorf("itx #%d mismatch: %+v", i+1, itx)
+		}
+		if itx.Index != uint(i+1) || itx.ActionIndex != 1 || itx.TxHash != common.BytesToHash([]byte{1}) {
+			t.Errorf("itx #%d position mismatch: %+v", i+1, itx)
+		}
+	}
+
+	// Internal transactions are dropped with the state of reverted calls.
+	snapshot := statedb.Snapshot()
+	if err := transfer(1); err != nil {
+		t.Fatal(err)
+	}
+	statedb.RevertToSnapshot(snapshot)
+	if n := len(statedb.InternalTxs()); n != 4 {
+		t.Fatalf("internal transaction count after revert mismatch: have %d, want 4", n)
+	}
+}
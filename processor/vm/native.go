@@ -0,0 +1,244 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/params"
+)
+
+var (
+	// ErrReservedName is returned when an account is created with the name of
+	// a native contract.
+	ErrReservedName = errors.New("name is reserved for a native contract")
+	errNativeValue  = errors.New("native contracts don't accept value")
+)
+
+// NativeContract is a precompiled contract operating on the account and asset
+// model of the chain. Native contracts live at reserved account names and are
+// called like any other contract. Their input is a sequence of 32 byte words:
+// account names are right aligned like the name operand of CALL, asset names
+// and symbols are left aligned like a bytes32 string.
+type NativeContract interface {
+	RequiredGas(input []byte) uint64
+	Run(evm *EVM, contract *Contract, input []byte) ([]byte, error)
+}
+
+// NativeContracts contains the native contracts by the name they are called at.
+var NativeContracts = map[common.Name]NativeContract{
+	"systransfer":   &nativeTransfer{},
+	"sysbalance":    &nativeBalance{},
+	"sysissueasset": &nativeIssueAsset{},
+	"sysresolve":    &nativeResolveName{},
+}
+
+// IsNativeContract reports whether name is reserved for a native contract.
+func IsNativeContract(name common.Name) bool {
+	_, ok := NativeContracts[name]
+	return ok
+}
+
+// RunNativeContract runs and evaluates the output of a native contract.
+func RunNativeContract(evm *EVM, p NativeContract, input []byte, contract *Contract) ([]byte, error) {
+	gas := p.RequiredGas(input)
+	if contract.UseGas(gas) {
+		return p.Run(evm, contract, input)
+	}
+	return nil, ErrOutOfGas
+}
+
+// callNative runs the native contract at name for caller. All gas is consumed
+// and the state is reverted if it fails.
+func (evm *EVM) callNative(p NativeContract, caller ContractRef, name common.Name, input []byte, gas uint64) (ret []byte, leftOverGas uint64, err error) {
+	snapshot := evm.StateDB.Snapshot()
+	contract := NewContract(caller, AccountRef(name), new(big.Int), gas, evm.AssetID)
+	if evm.vmConfig.Debug {
+		evm.vmConfig.Tracer.OnCallEnter(evm, CALL, caller.Name(), name, input, gas, nil)
+		defer func() { evm.vmConfig.Tracer.OnCallExit(evm, ret, gas-contract.Gas, err) }()
+	}
+	ret, err = RunNativeContract(evm, p, input, contract)
+	if err != nil {
+		evm.StateDB.RevertToSnapshot(snapshot)
+		contract.UseGas(contract.Gas)
+	}
+	return ret, contract.Gas, err
+}
+
+// nativeWord returns the n-th 32 byte word of input, zero padded.
+func nativeWord(input []byte, n uint64) []byte {
+	return getData(input, n*32, 32)
+}
+
+// nativeName decodes the right aligned account name in the n-th word.
+func nativeName(input []byte, n uint64) (common.Name, error) {
+	return common.BigToName(new(big.Int).SetBytes(nativeWord(input, n)))
+}
+
+// nativeString decodes the left aligned string in the n-th word.
+func nativeString(input []byte, n uint64) string {
+	return string(bytes.TrimRight(nativeWord(input, n), "\x00"))
+}
+
+// nativeUint decodes the n-th word as an unsigned integer.
+func nativeUint(input []byte, n uint64) *big.Int {
+	return new(big.Int).SetBytes(nativeWord(input, n))
+}
+
+// nativeUint64 decodes the n-th word as an unsigned 64 bit integer.
+func nativeUint64(input []byte, n uint64) (uint64, error) {
+	v := nativeUint(input, n)
+	if !v.IsUint64() {
+		return 0, errors.New("integer out of range")
+	}
+	return v.Uint64(), nil
+}
+
+var nativeTrue = common.LeftPadBytes([]byte{1}, 32)
+
+// nativeTransfer transfers an asset from the calling account.
+//
+// Input: to name, asset id, amount. Output: 1.
+type nativeTransfer struct{}
+
+func (c *nativeTransfer) RequiredGas(input []byte) uint64 {
+	return params.NativeTransferGas
+}
+
+func (c *nativeTransfer) Run(evm *EVM, contract *Contract, input []byte) ([]byte, error) {
+	if evm.interpreter.readOnly {
+		return nil, errWriteProtection
+	}
+	to, err := nativeName(input, 0)
+	if err != nil {
+		return nil, err
+	}
+	assetID, err := nativeUint64(input, 1)
+	if err != nil {
+		return nil, err
+	}
+	amount := nativeUint(input, 2)
+	if ok, err := evm.AccountDB.CanTransfer(contract.CallerName, assetID, amount); !ok || err != nil {
+		return nil, ErrInsufficientBalance
+	}
+	if err := evm.transferAsset(contract.CallerName, to, assetID, amount); err != nil {
+		return nil, err
+	}
+	evm.addInternalTransfer(contract.CallerName, to, assetID, amount)
+	return nativeTrue, nil
+}
+
+// nativeBalance returns the balance of an account in an asset.
+//
+// Input: account name, asset id. Output: balance.
+type nativeBalance struct{}
+
+func (c *nativeBalance) RequiredGas(input []byte) uint64 {
+	return params.NativeBalanceGas
+}
+
+func (c *nativeBalance) Run(evm *EVM, contract *Contract, input []byte) ([]byte, error) {
+	name, err := nativeName(input, 0)
+	if err != nil {
+		return nil, err
+	}
+	assetID, err := nativeUint64(input, 1)
+	if err != nil {
+		return nil, err
+	}
+	account, err := evm.AccountDB.GetAccountByName(name)
+	if err != nil {
+		return nil, err
+	}
+	balance := new(big.Int)
+	if account != nil {
+		if b, err := account.GetBalanceByID(assetID); err == nil {
+			balance = b
+		}
+	}
+	return math.PaddedBigBytes(balance, 32), nil
+}
+
+// nativeIssueAsset issues a new asset owned by the calling account, which
+// receives the whole initial amount.
+//
+// Input: asset name, symbol, amount, decimals. Output: asset id.
+type nativeIssueAsset struct{}
+
+func (c *nativeIssueAsset) RequiredGas(input []byte) uint64 {
+	return params.NativeIssueAssetGas
+}
+
+func (c *nativeIssueAsset) Run(evm *EVM, contract *Contract, input []byte) ([]byte, error) {
+	if evm.interpreter.readOnly {
+		return nil, errWriteProtection
+	}
+	decimals, err := nativeUint64(input, 3)
+	if err != nil {
+		return nil, err
+	}
+	ao, err := asset.NewAssetObject(nativeString(input, 0), nativeString(input, 1), nativeUint(input, 2), decimals, contract.CallerName)
+	if err != nil {
+		return nil, err
+	}
+	if err := evm.AccountDB.IssueAsset(ao); err != nil {
+		return nil, err
+	}
+	info, err := evm.AccountDB.GetAssetInfoByName(ao.GetAssetName())
+	if err != nil {
+		return nil, err
+	}
+	return math.PaddedBigBytes(new(big.Int).SetUint64(info.GetAssetId()), 32), nil
+}
+
+// nativeResolveName resolves an account name to the address of its key.
+//
+// Input: account name. Output: 1 and the address if the account exists,
+// two zero words otherwise.
+type nativeResolveName struct{}
+
+func (c *nativeResolveName) RequiredGas(input []byte) uint64 {
+	return params.NativeResolveNameGas
+}
+
+func (c *nativeResolveName) Run(evm *EVM, contract *Contract, input []byte) ([]byte, error) {
+	ret := make([]byte, 64)
+	name, err := nativeName(input, 0)
+	if err != nil {
+		return ret, nil
+	}
+	account, err := evm.AccountDB.GetAccountByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return ret, nil
+	}
+	pub, err := crypto.UnmarshalPubkey(account.GetPubKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	copy(ret, nativeTrue)
+	copy(ret[32+12:], crypto.PubkeyToAddress(*pub).Bytes())
+	return ret, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func nameWord(name common.Name) []byte {
+	return common.LeftPadBytes([]byte(name), 32)
+}
+
+func stringWord(s string) []byte {
+	return common.RightPadBytes([]byte(s), 32)
+}
+
+func uintWord(v uint64) []byte {
+	return common.LeftPadBytes(new(big.Int).SetUint64(v).Bytes(), 32)
+}
+
+func concat(words ...[]byte) []byte {
+	return bytes.Join(words, nil)
+}
+
+func TestNativeContracts(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+	am, _ := accountmanager.NewAccountManager(statedb)
+
+	key, _ := crypto.GenerateKey()
+	pubkey := common.BytesToPubKey(crypto.FromECDSAPub(&key.PublicKey))
+	sender, receiver := common.Name("senderacct"), common.Name("receiveracct")
+	for _, name := range []common.Name{sender, receiver} {
+		if err := am.CreateAccount(name, pubkey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ao, _ := asset.NewAssetObject("nativecoin", "ncn", big.NewInt(1000), 0, sender)
+	if err := am.IssueAsset(ao); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := am.GetAssetInfoByName("nativecoin")
+	id := info.GetAssetId()
+
+	// Before the fork the native contracts are plain account names.
+	config := &params.ChainConfig{NativeBlock: big.NewInt(10)}
+	evm := NewEVM(Context{AssetID: id, BlockNumber: big.NewInt(9)}, am, statedb, config, Config{})
+	input := concat(nameWord(receiver), uintWord(id), uintWord(300))
+	action := types.NewAction(types.CallContract, sender, "systransfer", 0, id, 100000, big.NewInt(0), input)
+	if _, _, err := evm.CallContract(AccountRef(sender), action, 100000); err == nil {
+		t.Error("native transfer ran before the fork")
+	}
+	if balance, _ := am.GetAccountBalanceByID(receiver, id); balance.Sign() != 0 {
+		t.Errorf("receiver balance before the fork: have %v, want 0", balance)
+	}
+	action = types.NewAction(types.CreateContract, sender, "sysresolve", 0, id, 100000, big.NewInt(0), nil)
+	if _, _, err := evm.Create(AccountRef(sender), action, 100000); err != nil {
+		t.Errorf("create before the fork: %v", err)
+	}
+
+	evm = NewEVM(Context{AssetID: id, BlockNumber: big.NewInt(10)}, am, statedb, config, Config{})
+	call := func(to common.Name, input []byte) []byte {
+		action := types.NewAction(types.CallContract, sender, to, 0, id, 100000, big.NewInt(0), input)
+		ret, _, err := evm.CallContract(AccountRef(sender), action, 100000)
+		if err != nil {
+			t.Fatalf("call %s: %v", to, err)
+		}
+		return ret
+	}
+
+	call("systransfer", concat(nameWord(receiver), uintWord(id), uintWord(300)))
+	if ret := call("sysbalance", concat(nameWord(receiver), uintWord(id))); new(big.Int).SetBytes(ret).Uint64() != 300 {
+		t.Errorf("receiver balance mismatch: have %x, want 300", ret)
+	}
+	if ret := call("sysbalance", concat(nameWord(sender), uintWord(id))); new(big.Int).SetBytes(ret).Uint64() != 700 {
+		t.Errorf("sender balance mismatch: have %x, want 700", ret)
+	}
+
+	ret := call("sysissueasset", concat(stringWord("subcoin"), stringWord("sub"), uintWord(50), uintWord(2)))
+	sub, _ := am.GetAssetInfoByName("subcoin")
+	if sub == nil || new(big.Int).SetBytes(ret).Uint64() != sub.GetAssetId() || sub.GetAssetOwner() != sender {
+		t.Errorf("issued asset mismatch: have id %x, asset %v", ret, sub)
+	}
+
+	ret = call("sysresolve", nameWord(receiver))
+	want := concat(uintWord(1), common.LeftPadBytes(crypto.PubkeyToAddress(key.PublicKey).Bytes(), 32))
+	if !bytes.Equal(ret, want) {
+		t.Errorf("resolved name mismatch: have %x, want %x", ret, want)
+	}
+	if ret := call("sysresolve", nameWord("unknownacct")); !bytes.Equal(ret, make([]byte, 64)) {
+		t.Errorf("unknown name resolved: %x", ret)
+	}
+
+	// State changing native contracts fail in static calls.
+	input = concat(nameWord(receiver), uintWord(id), uintWord(1))
+	if _, gas, err := evm.StaticCall(AccountRef(sender), "systransfer", input, 100000); err != errWriteProtection || gas != 0 {
+		t.Errorf("static transfer: have err %v gas %d, want %v and no gas left", err, gas, errWriteProtection)
+	}
+	// Native contracts can't be replaced by contract accounts.
+	action = types.NewAction(types.CreateContract, sender, "sysbalance", 0, id, 100000, big.NewInt(0), nil)
+	if _, _, err := evm.Create(AccountRef(sender), action, 100000); err != ErrReservedName {
+		t.Errorf("create error mismatch: have %v, want %v", err, ErrReservedName)
+	}
+}
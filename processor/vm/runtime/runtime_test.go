@@ -28,6 +28,7 @@ import (
 	"github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/asset"
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/processor/vm"
 	"github.com/fractalplatform/fractal/state"
 	"github.com/fractalplatform/fractal/types"
 	"github.com/fractalplatform/fractal/utils/abi"
@@ -196,6 +197,36 @@ func issueAssetAction(ownerName, toName common.Name) *types.Action {
 	return action
 }
 
+func TestCallContract(t *testing.T) {
+	state, _ := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+	account, _ := accountmanager.NewAccountManager(state)
+
+	senderName := common.Name("jacobwolf")
+	receiverName := common.Name("denverfolk")
+	pubkey := common.HexToPubKey("12345")
+	for _, name := range []common.Name{senderName, receiverName} {
+		if err := account.CreateAccount(name, pubkey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &Config{Origin: senderName, FromPubkey: pubkey, State: state, Account: account, GasLimit: 100000}
+	setDefaults(cfg)
+
+	for _, to := range []common.Name{receiverName, common.Name("missingname")} {
+		action := types.NewAction(types.CallContract, senderName, to, 0, 1, cfg.GasLimit, big.NewInt(0), nil)
+		_, gas, err := NewEnv(cfg).CallContract(vm.AccountRef(senderName), action, cfg.GasLimit)
+		if err != vm.ErrNotContract {
+			t.Fatalf("call %s: error mismatch: have %v, want %v", to, err, vm.ErrNotContract)
+		}
+		if gas != cfg.GasLimit {
+			t.Fatalf("call %s: gas consumed by failed lookup: %d", to, cfg.GasLimit-gas)
+		}
+	}
+	if ok, _ := account.AccountIsExist(common.Name("missingname")); ok {
+		t.Fatal("contract call created the recipient")
+	}
+}
+
 func TestAsset(t *testing.T) {
 	state, _ := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
 	account, _ := accountmanager.NewAccountManager(state)
@@ -0,0 +1,244 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/common"
+)
+
+// Tracer is used to collect execution traces from an EVM transaction
+// execution. It is called when entering and leaving every call frame, for
+// each step of the VM with the current VM state, and for each change of
+// balances and contract storage.
+// Note that reference types are actual VM data structures; make copies
+// if you need to retain them beyond the current call.
+type Tracer interface {
+	OnCallEnter(env *EVM, typ OpCode, from, to common.Name, input []byte, gas uint64, value *big.Int)
+	OnCallExit(env *EVM, output []byte, gasUsed uint64, err error)
+	OnOpcode(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error)
+	OnStateChange(env *EVM, change *StateChange)
+}
+
+// StateChangeKind is the kind of state touched by a StateChange.
+type StateChangeKind string
+
+const (
+	BalanceChange StateChangeKind = "balance"
+	StorageChange StateChangeKind = "storage"
+)
+
+// StateChange describes a single balance or storage modification.
+type StateChange struct {
+	Kind    StateChangeKind
+	Account common.Name
+	AssetID uint64      // asset of a balance change
+	Slot    common.Hash // slot of a storage change
+	Prev    *big.Int
+	New     *big.Int
+}
+
+// traceStorage reports a storage write to the tracer in debug mode.
+func (evm *EVM) traceStorage(name common.Name, slot, prev, value common.Hash) {
+	if !evm.vmConfig.Debug || prev == value {
+		return
+	}
+	evm.vmConfig.Tracer.OnStateChange(evm, &StateChange{
+		Kind:    StorageChange,
+		Account: name,
+		Slot:    slot,
+		Prev:    prev.Big(),
+		New:     value.Big(),
+	})
+}
+
+// transferAsset moves an asset between accounts and reports the balance
+// changes to the tracer in debug mode.
+func (evm *EVM) transferAsset(from, to common.Name, assetID uint64, amount *big.Int) error {
+	if !evm.vmConfig.Debug || amount == nil || amount.Sign() == 0 || from == to {
+		return evm.AccountDB.TransferAsset(from, to, assetID, amount)
+	}
+	fromPrev, _ := evm.AccountDB.GetAccountBalanceByID(from, assetID)
+	toPrev, _ := evm.AccountDB.GetAccountBalanceByID(to, assetID)
+	if err := evm.AccountDB.TransferAsset(from, to, assetID, amount); err != nil {
+		return err
+	}
+	for _, c := range []struct {
+		name common.Name
+		prev *big.Int
+	}{{from, fromPrev}, {to, toPrev}} {
+		balance, _ := evm.AccountDB.GetAccountBalanceByID(c.name, assetID)
+		evm.vmConfig.Tracer.OnStateChange(evm, &StateChange{
+			Kind:    BalanceChange,
+			Account: c.name,
+			AssetID: assetID,
+			Prev:    c.prev,
+			New:     balance,
+		})
+	}
+	return nil
+}
+
+// CallFrame is a call in the tree built by CallTracer.
+type CallFrame struct {
+	Type    string         `json:"type"`
+	From    common.Name    `json:"from"`
+	To      common.Name    `json:"to"`
+	Value   *hexutil.Big   `json:"value,omitempty"`
+	Gas     hexutil.Uint64 `json:"gas"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Input   hexutil.Bytes  `json:"input"`
+	Output  hexutil.Bytes  `json:"output,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	Calls   []*CallFrame   `json:"calls,omitempty"`
+}
+
+// CallTracer is a Tracer which records the tree of calls made by a
+// transaction, including calls between contracts.
+type CallTracer struct {
+	root  *CallFrame
+	stack []*CallFrame
+}
+
+// NewCallTracer returns a new call tree tracer.
+func NewCallTracer() *CallTracer {
+	return &CallTracer{}
+}
+
+// OnCallEnter pushes a new frame onto the call tree.
+func (t *CallTracer) OnCallEnter(env *EVM, typ OpCode, from, to common.Name, input []byte, gas uint64, value *big.Int) {
+	frame := &CallFrame{
+		Type:  typ.String(),
+		From:  from,
+		To:    to,
+		Gas:   hexutil.Uint64(gas),
+		Input: common.CopyBytes(input),
+	}
+	if value != nil {
+		frame.Value = (*hexutil.Big)(new(big.Int).Set(value))
+	}
+	if len(t.stack) == 0 {
+		t.root = frame
+	} else {
+		parent := t.stack[len(t.stack)-1]
+		parent.Calls = append(parent.Calls, frame)
+	}
+	t.stack = append(t.stack, frame)
+}
+
+// OnCallExit completes the innermost open frame.
+func (t *CallTracer) OnCallExit(env *EVM, output []byte, gasUsed uint64, err error) {
+	if len(t.stack) == 0 {
+		return
+	}
+	frame := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	frame.GasUsed = hexutil.Uint64(gasUsed)
+	frame.Output = common.CopyBytes(output)
+	if err != nil {
+		frame.Error = err.Error()
+	}
+}
+
+// OnOpcode implements Tracer.
+func (t *CallTracer) OnOpcode(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) {
+}
+
+// OnStateChange implements Tracer.
+func (t *CallTracer) OnStateChange(env *EVM, change *StateChange) {}
+
+// Result returns the outermost call, or nil if nothing was called.
+func (t *CallTracer) Result() *CallFrame { return t.root }
+
+// ValueDiff is the value of a balance or storage slot before and after a
+// transaction.
+type ValueDiff struct {
+	From *hexutil.Big `json:"from"`
+	To   *hexutil.Big `json:"to"`
+}
+
+// AccountDiff lists the balances and storage slots changed in an account.
+type AccountDiff struct {
+	Balances map[uint64]*ValueDiff      `json:"balances,omitempty"`
+	Storage  map[common.Hash]*ValueDiff `json:"storage,omitempty"`
+}
+
+// StateDiffTracer is a Tracer which collects the net balance and storage
+// changes made by a transaction.
+type StateDiffTracer struct {
+	diff map[common.Name]*AccountDiff
+}
+
+// NewStateDiffTracer returns a new state diff tracer.
+func NewStateDiffTracer() *StateDiffTracer {
+	return &StateDiffTracer{diff: make(map[common.Name]*AccountDiff)}
+}
+
+// OnCallEnter implements Tracer.
+func (t *StateDiffTracer) OnCallEnter(env *EVM, typ OpCode, from, to common.Name, input []byte, gas uint64, value *big.Int) {
+}
+
+// OnCallExit implements Tracer.
+func (t *StateDiffTracer) OnCallExit(env *EVM, output []byte, gasUsed uint64, err error) {}
+
+// OnOpcode implements Tracer.
+func (t *StateDiffTracer) OnOpcode(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) {
+}
+
+// OnStateChange merges the change into the diff, keeping the first previous
+// value and the last new value of every balance and slot.
+func (t *StateDiffTracer) OnStateChange(env *EVM, change *StateChange) {
+	account := t.diff[change.Account]
+	if account == nil {
+		account = &AccountDiff{}
+		t.diff[change.Account] = account
+	}
+	var d *ValueDiff
+	switch change.Kind {
+	case BalanceChange:
+		if account.Balances == nil {
+			account.Balances = make(map[uint64]*ValueDiff)
+		}
+		if d = account.Balances[change.AssetID]; d == nil {
+			d = &ValueDiff{From: copyBig(change.Prev)}
+			account.Balances[change.AssetID] = d
+		}
+	case StorageChange:
+		if account.Storage == nil {
+			account.Storage = make(map[common.Hash]*ValueDiff)
+		}
+		if d = account.Storage[change.Slot]; d == nil {
+			d = &ValueDiff{From: copyBig(change.Prev)}
+			account.Storage[change.Slot] = d
+		}
+	default:
+		return
+	}
+	d.To = copyBig(change.New)
+}
+
+// Result returns the changed accounts.
+func (t *StateDiffTracer) Result() map[common.Name]*AccountDiff { return t.diff }
+
+func copyBig(v *big.Int) *hexutil.Big {
+	if v == nil {
+		return (*hexutil.Big)(new(big.Int))
+	}
+	return (*hexutil.Big)(new(big.Int).Set(v))
+}
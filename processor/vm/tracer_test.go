@@ -0,0 +1,105 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+// storeCode deploys a contract which sets storage slot 0 to 1 when called.
+var storeCode = []byte{
+	byte(PUSH1), 6, byte(PUSH1), 12, byte(PUSH1), 0, byte(CODECOPY),
+	byte(PUSH1), 6, byte(PUSH1), 0, byte(RETURN),
+	byte(PUSH1), 1, byte(PUSH1), 0, byte(SSTORE), byte(STOP),
+}
+
+// traceStoreCall deploys the store contract and traces a call sending it 5
+// units of an asset.
+func traceStoreCall(t *testing.T, tracer Tracer) (common.Name, common.Name, uint64) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+	am, _ := accountmanager.NewAccountManager(statedb)
+
+	key, _ := crypto.GenerateKey()
+	pubkey := common.BytesToPubKey(crypto.FromECDSAPub(&key.PublicKey))
+	sender, contract := common.Name("tracesender"), common.Name("tracestore")
+	if err := am.CreateAccount(sender, pubkey); err != nil {
+		t.Fatal(err)
+	}
+	ao, _ := asset.NewAssetObject("tracecoin", "trc", big.NewInt(1000), 0, sender)
+	if err := am.IssueAsset(ao); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := am.GetAssetInfoByName("tracecoin")
+	id := info.GetAssetId()
+
+	evm := NewEVM(Context{AssetID: id}, am, statedb, nil, Config{})
+	create := types.NewAction(types.CreateContract, sender, contract, 0, id, 100000, big.NewInt(0), storeCode)
+	if _, _, err := evm.Create(AccountRef(sender), create, 100000); err != nil {
+		t.Fatal(err)
+	}
+
+	evm = NewEVM(Context{AssetID: id}, am, statedb, nil, Config{Debug: true, Tracer: tracer})
+	call := types.NewAction(types.Transfer, sender, contract, 0, id, 100000, big.NewInt(5), nil)
+	if _, _, err := evm.Call(AccountRef(sender), call, 100000); err != nil {
+		t.Fatal(err)
+	}
+	return sender, contract, id
+}
+
+func TestCallTracer(t *testing.T) {
+	tracer := NewCallTracer()
+	sender, contract, _ := traceStoreCall(t, tracer)
+
+	frame := tracer.Result()
+	if frame == nil {
+		t.Fatal("no call traced")
+	}
+	if frame.Type != "CALL" || frame.From != sender || frame.To != contract {
+		t.Errorf("frame mismatch: have %s %s -> %s", frame.Type, frame.From, frame.To)
+	}
+	if frame.Value.ToInt().Uint64() != 5 || frame.GasUsed == 0 || frame.Error != "" {
+		t.Errorf("frame result mismatch: value %v, gas used %d, error %q", frame.Value, frame.GasUsed, frame.Error)
+	}
+	if len(frame.Calls) != 0 {
+		t.Errorf("unexpected inner calls: %d", len(frame.Calls))
+	}
+}
+
+func TestStateDiffTracer(t *testing.T) {
+	tracer := NewStateDiffTracer()
+	sender, contract, id := traceStoreCall(t, tracer)
+
+	diff := tracer.Result()
+	if d := diff[sender].Balances[id]; d == nil || d.From.ToInt().Uint64() != 1000 || d.To.ToInt().Uint64() != 995 {
+		t.Errorf("sender balance diff mismatch: %+v", d)
+	}
+	if d := diff[contract].Balances[id]; d == nil || d.From.ToInt().Sign() != 0 || d.To.ToInt().Uint64() != 5 {
+		t.Errorf("contract balance diff mismatch: %+v", d)
+	}
+	if d := diff[contract].Storage[common.Hash{}]; d == nil || d.From.ToInt().Sign() != 0 || d.To.ToInt().Uint64() != 1 {
+		t.Errorf("storage diff mismatch: %+v", d)
+	}
+}
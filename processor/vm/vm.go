@@ -20,12 +20,12 @@ package vm
 import (
 	"math/big"
 	"sync/atomic"
-	"time"
 
 	"github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/crypto"
 	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/processor/vm/wasm"
 	"github.com/fractalplatform/fractal/state"
 	"github.com/fractalplatform/fractal/types"
 )
@@ -114,6 +114,9 @@ func run(evm *EVM, contract *Contract, input []byte) ([]byte, error) {
 	//		return RunPrecompiledContract(p, input, contract)
 	//	}
 	//}
+	if evm.wasmEnabled() && wasm.IsWasm(contract.Code) {
+		return runWasm(evm, contract, input, wasmCall)
+	}
 	return evm.interpreter.Run(contract, input)
 }
 
@@ -135,6 +138,15 @@ func (evm *EVM) Call(caller ContractRef, action *types.Action, gas uint64) (ret
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	itx := evm.addInternalTx(CALL, caller.Name(), action.Recipient(), action.AssetID(), action.Value(), gas)
+	defer evm.closeInternalTx(itx, gas, &leftOverGas, &err)
+
+	if p := evm.nativeContract(action.Recipient()); p != nil {
+		if action.Value().Sign() != 0 {
+			return nil, gas, errNativeValue
+		}
+		return evm.callNative(p, caller, action.Recipient(), action.Data(), gas)
+	}
 	// Fail if we're trying to transfer more than the available balance
 
 	if ok, err := evm.AccountDB.CanTransfer(caller.Name(), action.AssetID(), action.Value()); !ok || err != nil {
@@ -142,6 +154,9 @@ func (evm *EVM) Call(caller ContractRef, action *types.Action, gas uint64) (ret
 	}
 
 	toName := action.Recipient()
+	if err := evm.chargeRent(toName); err != nil {
+		return nil, gas, err
+	}
 
 	var (
 		to       = AccountRef(toName)
@@ -155,7 +170,7 @@ func (evm *EVM) Call(caller ContractRef, action *types.Action, gas uint64) (ret
 		}
 	}
 
-	if err := evm.AccountDB.TransferAsset(action.Sender(), action.Recipient(), action.AssetID(), action.Value()); err != nil {
+	if err := evm.transferAsset(action.Sender(), action.Recipient(), action.AssetID(), action.Value()); err != nil {
 		return nil, gas, err
 	}
 
@@ -176,13 +191,11 @@ func (evm *EVM) Call(caller ContractRef, action *types.Action, gas uint64) (ret
 	//code, _ := evm.AccountDB.GetCode(toName)
 	contract.SetCallCode(&toName, codeHash, code)
 
-	start := time.Now()
-
-	// Capture the tracer start/end events in debug mode
-	if evm.vmConfig.Debug && evm.depth == 0 {
-		evm.vmConfig.Tracer.CaptureStart(caller.Name(), toName, false, action.Data(), gas, action.Value())
+	// Capture the tracer enter/exit events in debug mode
+	if evm.vmConfig.Debug {
+		evm.vmConfig.Tracer.OnCallEnter(evm, CALL, caller.Name(), toName, action.Data(), gas, action.Value())
 		defer func() { // Lazy evaluation of the parameters
-			evm.vmConfig.Tracer.CaptureEnd(ret, gas-contract.Gas, time.Since(start), err)
+			evm.vmConfig.Tracer.OnCallExit(evm, ret, gas-contract.Gas, err)
 		}()
 	}
 
@@ -193,7 +206,7 @@ func (evm *EVM) Call(caller ContractRef, action *types.Action, gas uint64) (ret
 	// when we're in homestead this also counts for code storage gas errors.
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
@@ -201,6 +214,23 @@ func (evm *EVM) Call(caller ContractRef, action *types.Action, gas uint64) (ret
 	return ret, contract.Gas, err
 }
 
+// CallContract executes the code of an existing contract account. Unlike Call
+// it neither creates the recipient nor accepts accounts without code, so a
+// mistyped contract name can't swallow the transferred value.
+func (evm *EVM) CallContract(caller ContractRef, action *types.Action, gas uint64) (ret []byte, leftOverGas uint64, err error) {
+	if evm.nativeContract(action.Recipient()) != nil {
+		return evm.Call(caller, action, gas)
+	}
+	acct, err := evm.AccountDB.GetAccountByName(action.Recipient())
+	if err != nil {
+		return nil, gas, err
+	}
+	if acct == nil || acct.GetCodeSize() == 0 {
+		return nil, gas, ErrNotContract
+	}
+	return evm.Call(caller, action, gas)
+}
+
 // CallCode executes the contract associated with the addr with the given input
 // as parameters. It also handles any necessary value transfer required and takes
 // the necessary steps to create accounts and reverses the state in case of an
@@ -217,12 +247,18 @@ func (evm *EVM) CallCode(caller ContractRef, action *types.Action, gas uint64) (
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	itx := evm.addInternalTx(CALLCODE, caller.Name(), action.Recipient(), evm.AssetID, action.Value(), gas)
+	defer evm.closeInternalTx(itx, gas, &leftOverGas, &err)
+
 	// Fail if we're trying to transfer more than the available balance
 	if ok, err := evm.AccountDB.CanTransfer(caller.Name(), evm.AssetID, action.Value()); !ok || err != nil {
 		return nil, gas, ErrInsufficientBalance
 	}
 
 	toName := action.Recipient()
+	if err := evm.chargeRent(toName); err != nil {
+		return nil, gas, err
+	}
 
 	var (
 		snapshot = evm.StateDB.Snapshot()
@@ -245,10 +281,14 @@ func (evm *EVM) CallCode(caller ContractRef, action *types.Action, gas uint64) (
 	//code, _ := evm.AccountDB.GetCode(toName)
 	contract.SetCallCode(&toName, codeHash, code)
 
+	if evm.vmConfig.Debug {
+		evm.vmConfig.Tracer.OnCallEnter(evm, CALLCODE, caller.Name(), toName, action.Data(), gas, action.Value())
+		defer func() { evm.vmConfig.Tracer.OnCallExit(evm, ret, gas-contract.Gas, err) }()
+	}
 	ret, err = run(evm, contract, action.Data())
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
@@ -268,6 +308,12 @@ func (evm *EVM) DelegateCall(caller ContractRef, name common.Name, input []byte,
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	itx := evm.addInternalTx(DELEGATECALL, caller.Name(), name, evm.AssetID, nil, gas)
+	defer evm.closeInternalTx(itx, gas, &leftOverGas, &err)
+
+	if err := evm.chargeRent(name); err != nil {
+		return nil, gas, err
+	}
 
 	var (
 		snapshot = evm.StateDB.Snapshot()
@@ -289,10 +335,14 @@ func (evm *EVM) DelegateCall(caller ContractRef, name common.Name, input []byte,
 	//code, _ := evm.AccountDB.GetCode(name)
 	contract.SetCallCode(&name, codeHash, code)
 
+	if evm.vmConfig.Debug {
+		evm.vmConfig.Tracer.OnCallEnter(evm, DELEGATECALL, caller.Name(), name, input, gas, nil)
+		defer func() { evm.vmConfig.Tracer.OnCallExit(evm, ret, gas-contract.Gas, err) }()
+	}
 	ret, err = run(evm, contract, input)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
@@ -311,6 +361,9 @@ func (evm *EVM) StaticCall(caller ContractRef, name common.Name, input []byte, g
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	itx := evm.addInternalTx(STATICCALL, caller.Name(), name, evm.AssetID, nil, gas)
+	defer evm.closeInternalTx(itx, gas, &leftOverGas, &err)
+
 	// Make sure the readonly is only set if we aren't in readonly yet
 	// this makes also sure that the readonly flag isn't removed for
 	// child calls.
@@ -318,6 +371,12 @@ func (evm *EVM) StaticCall(caller ContractRef, name common.Name, input []byte, g
 		evm.interpreter.readOnly = true
 		defer func() { evm.interpreter.readOnly = false }()
 	}
+	if p := evm.nativeContract(name); p != nil {
+		return evm.callNative(p, caller, name, input, gas)
+	}
+	if err := evm.chargeRent(name); err != nil {
+		return nil, gas, err
+	}
 
 	var (
 		to       = AccountRef(name)
@@ -343,10 +402,14 @@ func (evm *EVM) StaticCall(caller ContractRef, name common.Name, input []byte, g
 	// When an error was returned by the EVM or when setting the creation code
 	// above we revert to the snapshot and consume any gas remaining. Additionally
 	// when we're in Homestead this also counts for code storage gas errors.
+	if evm.vmConfig.Debug {
+		evm.vmConfig.Tracer.OnCallEnter(evm, STATICCALL, caller.Name(), name, input, gas, nil)
+		defer func() { evm.vmConfig.Tracer.OnCallExit(evm, ret, gas-contract.Gas, err) }()
+	}
 	ret, err = run(evm, contract, input)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
@@ -361,6 +424,12 @@ func (evm *EVM) Create(caller ContractRef, action *types.Action, gas uint64) (re
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	itx := evm.addInternalTx(CREATE, caller.Name(), action.Recipient(), evm.AssetID, action.Value(), gas)
+	defer evm.closeInternalTx(itx, gas, &leftOverGas, &err)
+
+	if evm.nativeContract(action.Recipient()) != nil {
+		return nil, gas, ErrReservedName
+	}
 	if ok, err := evm.AccountDB.CanTransfer(caller.Name(), evm.AssetID, action.Value()); !ok || err != nil {
 		return nil, gas, ErrInsufficientBalance
 	}
@@ -372,7 +441,7 @@ func (evm *EVM) Create(caller ContractRef, action *types.Action, gas uint64) (re
 		return nil, 0, err
 	}
 
-	if err := evm.AccountDB.TransferAsset(action.Sender(), action.Recipient(), evm.AssetID, action.Value()); err != nil {
+	if err := evm.transferAsset(action.Sender(), action.Recipient(), evm.AssetID, action.Value()); err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
 		return nil, gas, err
 	}
@@ -387,12 +456,19 @@ func (evm *EVM) Create(caller ContractRef, action *types.Action, gas uint64) (re
 		return nil, gas, nil
 	}
 
-	if evm.vmConfig.Debug && evm.depth == 0 {
-		evm.vmConfig.Tracer.CaptureStart(caller.Name(), contractName, true, action.Data(), gas, action.Value())
+	if evm.vmConfig.Debug {
+		evm.vmConfig.Tracer.OnCallEnter(evm, CREATE, caller.Name(), contractName, action.Data(), gas, action.Value())
+		defer func() { evm.vmConfig.Tracer.OnCallExit(evm, ret, gas-contract.Gas, err) }()
 	}
-	start := time.Now()
 
-	ret, err = run(evm, contract, nil)
+	if evm.wasmEnabled() && wasm.IsWasm(action.Data()) {
+		// WebAssembly contracts keep the deployed binary as their code.
+		if ret, err = runWasm(evm, contract, nil, wasmDeploy); err == nil {
+			ret = action.Data()
+		}
+	} else {
+		ret, err = run(evm, contract, nil)
+	}
 
 	// check whether the max code size has been exceeded
 	//maxCodeSizeExceeded := evm.ChainConfig().IsEIP158(evm.BlockNumber) && len(ret) > params.MaxCodeSize
@@ -410,6 +486,9 @@ func (evm *EVM) Create(caller ContractRef, action *types.Action, gas uint64) (re
 			}
 			acct.SetCode(ret)
 			evm.AccountDB.SetAccount(acct)
+			if evm.BlockNumber != nil && evm.chainConfig != nil && evm.chainConfig.IsStorageRent(evm.BlockNumber.Uint64()) {
+				evm.AccountDB.SetRentPaid(contractName, evm.BlockNumber.Uint64())
+			}
 			//evm.AccountDB.SetCode(contractName, ret)
 		} else {
 			err = ErrCodeStoreOutOfGas
@@ -421,7 +500,7 @@ func (evm *EVM) Create(caller ContractRef, action *types.Action, gas uint64) (re
 	// when we're in homestead this also counts for code storage gas errors.
 	if maxCodeSizeExceeded || (err != nil && err != ErrCodeStoreOutOfGas) {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
@@ -429,12 +508,89 @@ func (evm *EVM) Create(caller ContractRef, action *types.Action, gas uint64) (re
 	if maxCodeSizeExceeded && err == nil {
 		err = errMaxCodeSizeExceeded
 	}
-	if evm.vmConfig.Debug && evm.depth == 0 {
-		evm.vmConfig.Tracer.CaptureEnd(ret, gas-contract.Gas, time.Since(start), err)
-	}
 	return ret, contract.Gas, err
 }
 
+// chargeRent collects the storage rent of a contract account before it is
+// executed. Hibernated accounts can't be executed until they are revived. In
+// read only calls the rent is left for the next writing call.
+func (evm *EVM) chargeRent(name common.Name) error {
+	if ok, err := evm.AccountDB.AccountIsExist(name); !ok || err != nil {
+		return err
+	}
+	if hibernated, err := evm.AccountDB.IsHibernated(name); hibernated || err != nil {
+		if err != nil {
+			return err
+		}
+		return ErrHibernated
+	}
+	if evm.interpreter.readOnly || evm.chainConfig == nil || evm.BlockNumber == nil {
+		return nil
+	}
+	number := evm.BlockNumber.Uint64()
+	price := evm.chainConfig.StorageRent
+	if price == nil || price.Sign() == 0 || !evm.chainConfig.IsStorageRent(number) {
+		return nil
+	}
+	if size, err := evm.AccountDB.RentBytes(name); size == 0 || err != nil {
+		return err
+	}
+	// Contracts created before storage rent started pay from its block on.
+	info, err := evm.AccountDB.GetRentInfo(name)
+	if err != nil {
+		return err
+	}
+	if start := evm.chainConfig.StorageRentBlock.Uint64(); info.RentPaid < start {
+		if err := evm.AccountDB.SetRentPaid(name, start); err != nil {
+			return err
+		}
+	}
+	err = evm.AccountDB.ChargeRent(name, number, price, evm.chainConfig.FeeTokenID, evm.Coinbase)
+	if err == accountmanager.ErrAccountHibernated {
+		return ErrHibernated
+	}
+	return err
+}
+
+// wasmEnabled reports whether WebAssembly contracts are deployed and run in
+// the block of the environment. Before, their binaries are EVM bytecode.
+func (evm *EVM) wasmEnabled() bool {
+	return evm.BlockNumber != nil && evm.chainConfig != nil && evm.chainConfig.IsWasm(evm.BlockNumber.Uint64())
+}
+
+// createOpEnabled reports whether the CREATE opcode consumes its arguments
+// in the block of the environment.
+func (evm *EVM) createOpEnabled() bool {
+	return evm.BlockNumber != nil && evm.chainConfig != nil && evm.chainConfig.IsCreateOp(evm.BlockNumber.Uint64())
+}
+
+// nativeContract returns the native contract called at name, nil if there is
+// none or the native contracts don't run in the block of the environment.
+func (evm *EVM) nativeContract(name common.Name) NativeContract {
+	if evm.BlockNumber == nil || evm.chainConfig == nil || !evm.chainConfig.IsNative(evm.BlockNumber.Uint64()) {
+		return nil
+	}
+	return NativeContracts[name]
+}
+
+// setState writes a storage slot of a contract, tracking the storage size of
+// the contract for storage rent.
+func (evm *EVM) setState(name common.Name, loc, value common.Hash) error {
+	current := evm.StateDB.GetState(name.String(), loc)
+	if current == (common.Hash{}) && value != (common.Hash{}) {
+		if err := evm.AccountDB.AddStorageSize(name, storageSlotSize); err != nil {
+			return err
+		}
+	} else if current != (common.Hash{}) && value == (common.Hash{}) {
+		if err := evm.AccountDB.AddStorageSize(name, -storageSlotSize); err != nil {
+			return err
+		}
+	}
+	evm.StateDB.SetState(name.String(), loc, value)
+	evm.traceStorage(name, loc, current, value)
+	return nil
+}
+
 // ChainConfig returns the environment's chain configuration
 func (evm *EVM) ChainConfig() *params.ChainConfig { return evm.chainConfig }
 
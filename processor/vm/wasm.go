@@ -0,0 +1,282 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/processor/vm/wasm"
+)
+
+// WebAssembly contracts are deployed by creating a contract with a
+// WebAssembly binary instead of EVM init code, the binary itself becomes the
+// contract code. Its exported "deploy" function, if any, runs at creation and
+// its exported "call" function runs on every call. Both take no parameters
+// and return nothing; the contract reads its input and sets its output
+// through the functions of the "env" module.
+
+var wasmConfig = wasm.Config{
+	InstructionGas: params.WasmInstructionGas,
+	PageGas:        params.WasmPageGas,
+	CopyGas:        params.CopyGas,
+	MaxPages:       params.WasmMaxPages,
+	MaxCallDepth:   params.WasmCallDepth,
+	MaxStack:       int(params.StackLimit),
+}
+
+// runWasm runs the entry function of a WebAssembly contract.
+func runWasm(evm *EVM, contract *Contract, input []byte, entry string) (ret []byte, err error) {
+	evm.depth++
+	defer func() { evm.depth-- }()
+
+	module, err := wasm.Decode(contract.Code)
+	if err != nil {
+		return nil, err
+	}
+	host := &wasmHost{evm: evm, contract: contract, input: input}
+	m, err := wasm.Instantiate(module, host.imports(), wasmConfig, contract.Gas)
+	if err != nil {
+		contract.Gas = 0
+		if err == wasm.ErrOutOfGas {
+			return nil, ErrOutOfGas
+		}
+		return nil, err
+	}
+	defer func() { contract.Gas = m.Gas() }()
+
+	if !m.HasExport(entry) {
+		if entry == wasmDeploy {
+			return nil, nil
+		}
+		return nil, wasm.ErrMissingExport
+	}
+	if _, err := m.Invoke(entry); err != nil {
+		if err == wasm.ErrOutOfGas {
+			return nil, ErrOutOfGas
+		}
+		if err == ErrExecutionReverted {
+			return host.output, err
+		}
+		return nil, err
+	}
+	return host.output, nil
+}
+
+const (
+	wasmDeploy = "deploy"
+	wasmCall   = "call"
+)
+
+// wasmHost implements the functions WebAssembly contracts can import.
+type wasmHost struct {
+	evm      *EVM
+	contract *Contract
+	input    []byte
+	output   []byte
+}
+
+func (h *wasmHost) imports() wasm.Imports {
+	var (
+		i32 = wasm.I32
+		i64 = wasm.I64
+		fn  = func(params, results []wasm.ValueType, gas uint64, call func(*wasm.Machine, []uint64) ([]uint64, error)) *wasm.HostFunc {
+			return &wasm.HostFunc{Type: wasm.FuncType{Params: params, Results: results}, Gas: gas, Call: call}
+		}
+		none []wasm.ValueType
+	)
+	return wasm.Imports{
+		"env.input_size":    fn(none, []wasm.ValueType{i32}, GasQuickStep, h.inputSize),
+		"env.input_copy":    fn([]wasm.ValueType{i32}, none, GasFastestStep, h.inputCopy),
+		"env.set_output":    fn([]wasm.ValueType{i32, i32}, none, GasFastestStep, h.setOutput),
+		"env.revert":        fn([]wasm.ValueType{i32, i32}, none, 0, h.revert),
+		"env.storage_load":  fn([]wasm.ValueType{i32, i32}, none, params.SloadGas, h.storageLoad),
+		"env.storage_store": fn([]wasm.ValueType{i32, i32}, none, 0, h.storageStore),
+		"env.caller":        fn([]wasm.ValueType{i32}, []wasm.ValueType{i32}, GasQuickStep, h.caller),
+		"env.self":          fn([]wasm.ValueType{i32}, []wasm.ValueType{i32}, GasQuickStep, h.self),
+		"env.value":         fn([]wasm.ValueType{i32}, none, GasQuickStep, h.value),
+		"env.asset_id":      fn(none, []wasm.ValueType{i64}, GasQuickStep, h.assetID),
+		"env.balance":       fn([]wasm.ValueType{i32, i32, i64, i32}, none, params.NativeBalanceGas, h.balance),
+		"env.transfer":      fn([]wasm.ValueType{i32, i32, i64, i32}, []wasm.ValueType{i32}, params.NativeTransferGas, h.transfer),
+		"env.block_number":  fn(none, []wasm.ValueType{i64}, GasQuickStep, h.blockNumber),
+		"env.timestamp":     fn(none, []wasm.ValueType{i64}, GasQuickStep, h.timestamp),
+	}
+}
+
+// copyGas charges the copying of size bytes.
+func copyGas(m *wasm.Machine, size uint64) error {
+	if !m.UseGas(toWordSize(size) * params.CopyGas) {
+		return ErrOutOfGas
+	}
+	return nil
+}
+
+// readName reads an account name of length size at ptr.
+func readName(m *wasm.Machine, ptr, size uint64) (common.Name, error) {
+	b, err := m.Memory(uint32(ptr), uint32(size))
+	if err != nil {
+		return "", err
+	}
+	return common.BytesToName(b)
+}
+
+// writeName writes an account name at ptr and returns its length.
+func writeName(m *wasm.Machine, ptr uint64, name common.Name) ([]uint64, error) {
+	if err := m.SetMemory(uint32(ptr), []byte(name)); err != nil {
+		return nil, err
+	}
+	return []uint64{uint64(len(name))}, nil
+}
+
+func (h *wasmHost) inputSize(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	return []uint64{uint64(len(h.input))}, nil
+}
+
+func (h *wasmHost) inputCopy(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	if err := copyGas(m, uint64(len(h.input))); err != nil {
+		return nil, err
+	}
+	return nil, m.SetMemory(uint32(args[0]), h.input)
+}
+
+func (h *wasmHost) setOutput(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	if err := copyGas(m, uint64(uint32(args[1]))); err != nil {
+		return nil, err
+	}
+	b, err := m.Memory(uint32(args[0]), uint32(args[1]))
+	if err != nil {
+		return nil, err
+	}
+	h.output = common.CopyBytes(b)
+	return nil, nil
+}
+
+func (h *wasmHost) revert(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	if _, err := h.setOutput(m, args); err != nil {
+		return nil, err
+	}
+	return nil, ErrExecutionReverted
+}
+
+func (h *wasmHost) storageLoad(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	key, err := m.Memory(uint32(args[0]), common.HashLength)
+	if err != nil {
+		return nil, err
+	}
+	value := h.evm.StateDB.GetState(h.contract.Name().String(), common.BytesToHash(key))
+	return nil, m.SetMemory(uint32(args[1]), value[:])
+}
+
+// storageStore writes a slot at the gas prices of SSTORE.
+func (h *wasmHost) storageStore(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	if h.evm.interpreter.readOnly {
+		return nil, errWriteProtection
+	}
+	key, err := m.Memory(uint32(args[0]), common.HashLength)
+	if err != nil {
+		return nil, err
+	}
+	b, err := m.Memory(uint32(args[1]), common.HashLength)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		loc     = common.BytesToHash(key)
+		value   = common.BytesToHash(b)
+		current = h.evm.StateDB.GetState(h.contract.Name().String(), loc)
+		gas     = params.SstoreResetGas
+	)
+	switch {
+	case current == (common.Hash{}) && value != (common.Hash{}):
+		gas = params.SstoreSetGas
+	case current != (common.Hash{}) && value == (common.Hash{}):
+		h.evm.StateDB.AddRefund(params.SstoreRefundGas)
+		gas = params.SstoreClearGas
+	}
+	if !m.UseGas(gas) {
+		return nil, ErrOutOfGas
+	}
+	return nil, h.evm.setState(h.contract.Name(), loc, value)
+}
+
+func (h *wasmHost) caller(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	return writeName(m, args[0], h.contract.Caller())
+}
+
+func (h *wasmHost) self(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	return writeName(m, args[0], h.contract.Name())
+}
+
+func (h *wasmHost) value(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	return nil, m.SetMemory(uint32(args[0]), common.BigToHash(h.contract.Value()).Bytes())
+}
+
+func (h *wasmHost) assetID(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	return []uint64{h.contract.AssetId}, nil
+}
+
+func (h *wasmHost) balance(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	name, err := readName(m, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	balance, err := h.evm.AccountDB.GetAccountBalanceByID(name, args[2])
+	if err != nil {
+		balance = new(big.Int)
+	}
+	return nil, m.SetMemory(uint32(args[3]), common.BigToHash(balance).Bytes())
+}
+
+// transfer sends an asset from the contract and returns 1, or 0 if the
+// balance of the contract is insufficient.
+func (h *wasmHost) transfer(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	if h.evm.interpreter.readOnly {
+		return nil, errWriteProtection
+	}
+	to, err := readName(m, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	b, err := m.Memory(uint32(args[3]), common.HashLength)
+	if err != nil {
+		return nil, err
+	}
+	amount := new(big.Int).SetBytes(b)
+	if ok, err := h.evm.AccountDB.CanTransfer(h.contract.Name(), args[2], amount); !ok || err != nil {
+		return []uint64{0}, nil
+	}
+	if err := h.evm.transferAsset(h.contract.Name(), to, args[2], amount); err != nil {
+		return nil, err
+	}
+	h.evm.addInternalTransfer(h.contract.Name(), to, args[2], amount)
+	return []uint64{1}, nil
+}
+
+func (h *wasmHost) blockNumber(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	if h.evm.BlockNumber == nil {
+		return []uint64{0}, nil
+	}
+	return []uint64{h.evm.BlockNumber.Uint64()}, nil
+}
+
+func (h *wasmHost) timestamp(m *wasm.Machine, args []uint64) ([]uint64, error) {
+	if h.evm.Time == nil {
+		return []uint64{0}, nil
+	}
+	return []uint64{h.evm.Time.Uint64()}, nil
+}
@@ -0,0 +1,336 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import "fmt"
+
+// Opcodes of the supported instruction set.
+const (
+	opUnreachable = 0x00
+	opNop         = 0x01
+	opBlock       = 0x02
+	opLoop        = 0x03
+	opIf          = 0x04
+	opElse        = 0x05
+	opEnd         = 0x0b
+	opBr          = 0x0c
+	opBrIf        = 0x0d
+	opBrTable     = 0x0e
+	opReturn      = 0x0f
+	opCall        = 0x10
+	opCallIndir   = 0x11
+	opDrop        = 0x1a
+	opSelect      = 0x1b
+	opLocalGet    = 0x20
+	opLocalSet    = 0x21
+	opLocalTee    = 0x22
+	opGlobalGet   = 0x23
+	opGlobalSet   = 0x24
+
+	opI32Load    = 0x28
+	opI64Load    = 0x29
+	opI32Load8S  = 0x2c
+	opI32Load8U  = 0x2d
+	opI32Load16S = 0x2e
+	opI32Load16U = 0x2f
+	opI64Load8S  = 0x30
+	opI64Load8U  = 0x31
+	opI64Load16S = 0x32
+	opI64Load16U = 0x33
+	opI64Load32S = 0x34
+	opI64Load32U = 0x35
+	opI32Store   = 0x36
+	opI64Store   = 0x37
+	opI32Store8  = 0x3a
+	opI32Store16 = 0x3b
+	opI64Store8  = 0x3c
+	opI64Store16 = 0x3d
+	opI64Store32 = 0x3e
+	opMemorySize = 0x3f
+	opMemoryGrow = 0x40
+
+	opI32Const = 0x41
+	opI64Const = 0x42
+
+	opI32Eqz = 0x45
+	opI32Eq  = 0x46
+	opI32Ne  = 0x47
+	opI32LtS = 0x48
+	opI32LtU = 0x49
+	opI32GtS = 0x4a
+	opI32GtU = 0x4b
+	opI32LeS = 0x4c
+	opI32LeU = 0x4d
+	opI32GeS = 0x4e
+	opI32GeU = 0x4f
+	opI64Eqz = 0x50
+	opI64Eq  = 0x51
+	opI64Ne  = 0x52
+	opI64LtS = 0x53
+	opI64LtU = 0x54
+	opI64GtS = 0x55
+	opI64GtU = 0x56
+	opI64LeS = 0x57
+	opI64LeU = 0x58
+	opI64GeS = 0x59
+	opI64GeU = 0x5a
+
+	opI32Clz    = 0x67
+	opI32Ctz    = 0x68
+	opI32Popcnt = 0x69
+	opI32Add    = 0x6a
+	opI32Sub    = 0x6b
+	opI32Mul    = 0x6c
+	opI32DivS   = 0x6d
+	opI32DivU   = 0x6e
+	opI32RemS   = 0x6f
+	opI32RemU   = 0x70
+	opI32And    = 0x71
+	opI32Or     = 0x72
+	opI32Xor    = 0x73
+	opI32Shl    = 0x74
+	opI32ShrS   = 0x75
+	opI32ShrU   = 0x76
+	opI32Rotl   = 0x77
+	opI32Rotr   = 0x78
+	opI64Clz    = 0x79
+	opI64Ctz    = 0x7a
+	opI64Popcnt = 0x7b
+	opI64Add    = 0x7c
+	opI64Sub    = 0x7d
+	opI64Mul    = 0x7e
+	opI64DivS   = 0x7f
+	opI64DivU   = 0x80
+	opI64RemS   = 0x81
+	opI64RemU   = 0x82
+	opI64And    = 0x83
+	opI64Or     = 0x84
+	opI64Xor    = 0x85
+	opI64Shl    = 0x86
+	opI64ShrS   = 0x87
+	opI64ShrU   = 0x88
+	opI64Rotl   = 0x89
+	opI64Rotr   = 0x8a
+
+	opI32WrapI64     = 0xa7
+	opI64ExtendI32S  = 0xac
+	opI64ExtendI32U  = 0xad
+	opI32Extend8S    = 0xc0
+	opI32Extend16S   = 0xc1
+	opI64Extend8S    = 0xc2
+	opI64Extend16S   = 0xc3
+	opI64Extend32S   = 0xc4
+	opPrefixMisc     = 0xfc
+	opMemoryCopy     = 0x0a // after opPrefixMisc
+	opMemoryFill     = 0x0b // after opPrefixMisc
+	blockTypeEmpty   = 0x40
+	memoryIndexFirst = 0x00
+)
+
+// isPlain reports whether op is a supported instruction without immediates.
+func isPlain(op byte) bool {
+	switch {
+	case op == opUnreachable, op == opNop, op == opReturn, op == opDrop, op == opSelect:
+		return true
+	case op >= opI32Eqz && op <= opI64GeU:
+		return true
+	case op >= opI32Clz && op <= opI64Rotr:
+		return true
+	case op == opI32WrapI64, op == opI64ExtendI32S, op == opI64ExtendI32U:
+		return true
+	case op >= opI32Extend8S && op <= opI64Extend32S:
+		return true
+	}
+	return false
+}
+
+// isMemory reports whether op is a supported load or store.
+func isMemory(op byte) bool {
+	return op >= opI32Load && op <= opI64Store32 && op != 0x2a && op != 0x2b && op != 0x38 && op != 0x39
+}
+
+// blockArity decodes a block type into the number of values it results in.
+func blockArity(bt byte) (int, error) {
+	switch bt {
+	case blockTypeEmpty:
+		return 0, nil
+	case byte(I32), byte(I64):
+		return 1, nil
+	}
+	return 0, fmt.Errorf("%v: block type 0x%x", ErrUnsupported, bt)
+}
+
+// compile checks the instructions of a function body and resolves the
+// positions of the ends of its blocks.
+func (m *Module) compile(f *Function) error {
+	typ := m.Types[f.Type]
+	var (
+		r       = &reader{b: f.Code}
+		open    []int // offsets of the enclosing block, loop and if opcodes
+		nlocals = uint32(len(typ.Params) + len(f.Locals))
+		nfuncs  = uint32(len(m.Imports) + len(m.Funcs))
+	)
+	f.ends, f.elses = make(map[int]int), make(map[int]int)
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%v: "+format, append([]interface{}{ErrInvalidModule}, args...)...)
+	}
+	for r.pos < len(f.Code) {
+		start := r.pos
+		op, _ := r.byte()
+		switch {
+		case isPlain(op):
+		case op == opBlock || op == opLoop || op == opIf:
+			bt, err := r.byte()
+			if err != nil {
+				return err
+			}
+			if _, err := blockArity(bt); err != nil {
+				return err
+			}
+			open = append(open, start)
+		case op == opElse:
+			if len(open) == 0 || f.Code[open[len(open)-1]] != opIf {
+				return fail("else without if")
+			}
+			opener := open[len(open)-1]
+			if _, ok := f.elses[opener]; ok {
+				return fail("duplicate else")
+			}
+			f.elses[opener] = start
+		case op == opEnd:
+			if len(open) == 0 {
+				if r.pos != len(f.Code) {
+					return fail("instructions after function end")
+				}
+				return nil
+			}
+			f.ends[open[len(open)-1]] = start
+			open = open[:len(open)-1]
+		case op == opBr || op == opBrIf:
+			depth, err := r.u32()
+			if err != nil {
+				return err
+			}
+			if int(depth) > len(open) {
+				return fail("branch depth %d out of range", depth)
+			}
+		case op == opBrTable:
+			targets, err := r.vecU32()
+			if err != nil {
+				return err
+			}
+			def, err := r.u32()
+			if err != nil {
+				return err
+			}
+			for _, depth := range append(targets, def) {
+				if int(depth) > len(open) {
+					return fail("branch depth %d out of range", depth)
+				}
+			}
+		case op == opCall:
+			index, err := r.u32()
+			if err != nil {
+				return err
+			}
+			if index >= nfuncs {
+				return fail("function index %d out of range", index)
+			}
+		case op == opCallIndir:
+			index, err := r.u32()
+			if err != nil {
+				return err
+			}
+			if int(index) >= len(m.Types) {
+				return fail("type index %d out of range", index)
+			}
+			if m.Table == nil {
+				return fail("indirect call without table")
+			}
+			if table, err := r.byte(); err != nil || table != 0 {
+				return fail("bad table index")
+			}
+		case op == opPrefixMisc:
+			sub, err := r.u32()
+			if err != nil {
+				return err
+			}
+			if sub != opMemoryCopy && sub != opMemoryFill {
+				return fmt.Errorf("%v: opcode 0x%x 0x%x", ErrUnsupported, op, sub)
+			}
+			if m.Memory == nil {
+				return fail("memory access without memory")
+			}
+			indices := 1
+			if sub == opMemoryCopy {
+				indices = 2
+			}
+			for i := 0; i < indices; i++ {
+				if index, err := r.byte(); err != nil || index != memoryIndexFirst {
+					return fail("bad memory index")
+				}
+			}
+		case op == opLocalGet || op == opLocalSet || op == opLocalTee:
+			index, err := r.u32()
+			if err != nil {
+				return err
+			}
+			if index >= nlocals {
+				return fail("local index %d out of range", index)
+			}
+		case op == opGlobalGet || op == opGlobalSet:
+			index, err := r.u32()
+			if err != nil {
+				return err
+			}
+			if int(index) >= len(m.Globals) {
+				return fail("global index %d out of range", index)
+			}
+			if op == opGlobalSet && !m.Globals[index].Mutable {
+				return fail("global %d is immutable", index)
+			}
+		case isMemory(op):
+			if m.Memory == nil {
+				return fail("memory access without memory")
+			}
+			if _, err := r.u32(); err != nil { // alignment hint
+				return err
+			}
+			if _, err := r.u32(); err != nil {
+				return err
+			}
+		case op == opMemorySize || op == opMemoryGrow:
+			if m.Memory == nil {
+				return fail("memory access without memory")
+			}
+			if index, err := r.byte(); err != nil || index != memoryIndexFirst {
+				return fail("bad memory index")
+			}
+		case op == opI32Const:
+			if _, err := r.sleb(32); err != nil {
+				return err
+			}
+		case op == opI64Const:
+			if _, err := r.sleb(64); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%v: opcode 0x%x", ErrUnsupported, op)
+		}
+	}
+	return fail("function without end")
+}
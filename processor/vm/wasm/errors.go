@@ -0,0 +1,36 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import "errors"
+
+var (
+	ErrInvalidModule    = errors.New("wasm: invalid module")
+	ErrUnsupported      = errors.New("wasm: unsupported feature")
+	ErrMissingImport    = errors.New("wasm: unresolved import")
+	ErrMissingExport    = errors.New("wasm: function not exported")
+	ErrOutOfGas         = errors.New("wasm: out of gas")
+	ErrUnreachable      = errors.New("wasm: unreachable executed")
+	ErrStackUnderflow   = errors.New("wasm: operand stack underflow")
+	ErrStackOverflow    = errors.New("wasm: operand stack overflow")
+	ErrCallDepth        = errors.New("wasm: max call depth exceeded")
+	ErrMemoryAccess     = errors.New("wasm: out of bounds memory access")
+	ErrTableAccess      = errors.New("wasm: undefined table element")
+	ErrDivideByZero     = errors.New("wasm: integer divide by zero")
+	ErrIntegerOverflow  = errors.New("wasm: integer overflow")
+	ErrInvalidSignature = errors.New("wasm: function signature mismatch")
+)
@@ -0,0 +1,742 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// PageSize is the size of a linear memory page.
+const PageSize = 65536
+
+// maxTable bounds the initial table size, tables can't grow.
+const maxTable = 1 << 16
+
+// HostFunc is a function provided by the host to modules importing it.
+type HostFunc struct {
+	Type FuncType
+	Gas  uint64 // charged before every call
+	Call func(m *Machine, args []uint64) ([]uint64, error)
+}
+
+// Imports maps "module.name" to the host functions modules may import.
+type Imports map[string]*HostFunc
+
+// Config bounds the resources a machine may use.
+type Config struct {
+	InstructionGas uint64 // gas per executed instruction
+	PageGas        uint64 // gas per allocated memory page
+	CopyGas        uint64 // gas per word copied or filled by bulk memory instructions
+	MaxPages       uint32 // memory limit in pages
+	MaxCallDepth   int    // nesting limit of function calls
+	MaxStack       int    // operand stack limit of a function
+}
+
+// label is an entered block, loop or if.
+type label struct {
+	cont    int  // offset to continue at when branching to the label
+	height  int  // operand stack height at entry
+	results int  // number of values the block results in
+	loop    bool // branches re-enter the loop instead of leaving it
+}
+
+// trap aborts the execution of a machine.
+type trap struct{ err error }
+
+// Machine is an instantiated module.
+type Machine struct {
+	module  *Module
+	cfg     Config
+	host    []*HostFunc
+	globals []uint64
+	table   []int64 // function indices, -1 for empty slots
+	memory  []byte
+	gas     uint64
+	depth   int
+}
+
+// Instantiate links the module against the host functions and initializes
+// its memory and globals. The initial memory pages are paid from gas.
+func Instantiate(module *Module, imports Imports, cfg Config, gas uint64) (*Machine, error) {
+	m := &Machine{module: module, cfg: cfg, gas: gas}
+	for _, imp := range module.Imports {
+		fn := imports[imp.Module+"."+imp.Name]
+		if fn == nil {
+			return nil, fmt.Errorf("%v: %s.%s", ErrMissingImport, imp.Module, imp.Name)
+		}
+		if !fn.Type.Equal(module.Types[imp.Type]) {
+			return nil, fmt.Errorf("%v: %s.%s", ErrInvalidSignature, imp.Module, imp.Name)
+		}
+		m.host = append(m.host, fn)
+	}
+	for _, g := range module.Globals {
+		m.globals = append(m.globals, g.Init)
+	}
+	if module.Memory != nil {
+		if module.Memory.Min > cfg.MaxPages {
+			return nil, ErrMemoryAccess
+		}
+		if !m.UseGas(uint64(module.Memory.Min) * cfg.PageGas) {
+			return nil, ErrOutOfGas
+		}
+		m.memory = make([]byte, int(module.Memory.Min)*PageSize)
+	}
+	if module.Table != nil {
+		if module.Table.Min > maxTable {
+			return nil, ErrTableAccess
+		}
+		m.table = make([]int64, module.Table.Min)
+		for i := range m.table {
+			m.table[i] = -1
+		}
+	}
+	for _, e := range module.Elements {
+		if uint64(e.Offset)+uint64(len(e.Init)) > uint64(len(m.table)) {
+			return nil, ErrTableAccess
+		}
+		for i, index := range e.Init {
+			m.table[int(e.Offset)+i] = int64(index)
+		}
+	}
+	for _, d := range module.Data {
+		if uint64(d.Offset)+uint64(len(d.Init)) > uint64(len(m.memory)) {
+			return nil, ErrMemoryAccess
+		}
+		copy(m.memory[d.Offset:], d.Init)
+	}
+	return m, nil
+}
+
+// Gas returns the gas left.
+func (m *Machine) Gas() uint64 { return m.gas }
+
+// UseGas consumes gas and reports whether enough was left.
+func (m *Machine) UseGas(gas uint64) bool {
+	if m.gas < gas {
+		m.gas = 0
+		return false
+	}
+	m.gas -= gas
+	return true
+}
+
+// Memory returns size bytes of the linear memory at offset.
+func (m *Machine) Memory(offset, size uint32) ([]byte, error) {
+	if uint64(offset)+uint64(size) > uint64(len(m.memory)) {
+		return nil, ErrMemoryAccess
+	}
+	return m.memory[offset : offset+size], nil
+}
+
+// SetMemory copies data into the linear memory at offset.
+func (m *Machine) SetMemory(offset uint32, data []byte) error {
+	mem, err := m.Memory(offset, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+	copy(mem, data)
+	return nil
+}
+
+// HasExport reports whether the module exports a function called name.
+func (m *Machine) HasExport(name string) bool {
+	exp, ok := m.module.Exports[name]
+	return ok && exp.Kind == ExportFunc
+}
+
+// Invoke calls the exported function name with args.
+func (m *Machine) Invoke(name string, args ...uint64) (results []uint64, err error) {
+	exp, ok := m.module.Exports[name]
+	if !ok || exp.Kind != ExportFunc {
+		return nil, fmt.Errorf("%v: %s", ErrMissingExport, name)
+	}
+	typ, _ := m.module.FuncType(exp.Index)
+	if len(args) != len(typ.Params) {
+		return nil, fmt.Errorf("%v: %s", ErrInvalidSignature, name)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			t, ok := r.(trap)
+			if !ok {
+				panic(r)
+			}
+			results, err = nil, t.err
+		}
+	}()
+	return m.call(exp.Index, args), nil
+}
+
+// call executes the function at index.
+func (m *Machine) call(index uint32, args []uint64) []uint64 {
+	if int(index) < len(m.host) {
+		fn := m.host[index]
+		if !m.UseGas(fn.Gas) {
+			panic(trap{ErrOutOfGas})
+		}
+		results, err := fn.Call(m, args)
+		if err != nil {
+			panic(trap{err})
+		}
+		if len(results) != len(fn.Type.Results) {
+			panic(trap{ErrInvalidSignature})
+		}
+		return results
+	}
+	if m.depth >= m.cfg.MaxCallDepth {
+		panic(trap{ErrCallDepth})
+	}
+	m.depth++
+	defer func() { m.depth-- }()
+
+	f := m.module.Funcs[int(index)-len(m.host)]
+	return m.execute(f, m.module.Types[f.Type], args)
+}
+
+// execute interprets the body of a function.
+func (m *Machine) execute(f *Function, typ FuncType, args []uint64) []uint64 {
+	var (
+		code   = f.Code
+		r      = &reader{b: code}
+		locals = make([]uint64, len(args)+len(f.Locals))
+		stack  = make([]uint64, 0, 16)
+		labels = []label{{cont: len(code), results: len(typ.Results)}}
+	)
+	copy(locals, args)
+
+	push := func(v uint64) {
+		if len(stack) >= m.cfg.MaxStack {
+			panic(trap{ErrStackOverflow})
+		}
+		stack = append(stack, v)
+	}
+	pop := func() uint64 {
+		if len(stack) == 0 {
+			panic(trap{ErrStackUnderflow})
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	// unwind drops the operands pushed since the label was entered except
+	// for the keep topmost ones.
+	unwind := func(l label, keep int) {
+		if len(stack) < l.height+keep {
+			panic(trap{ErrStackUnderflow})
+		}
+		stack = append(stack[:l.height], stack[len(stack)-keep:]...)
+	}
+	// branch continues at the label depth levels up and reports whether the
+	// function returns.
+	branch := func(depth uint32) bool {
+		index := len(labels) - 1 - int(depth)
+		l := labels[index]
+		r.pos = l.cont
+		if l.loop {
+			unwind(l, 0)
+			labels = labels[:index+1]
+			return false
+		}
+		unwind(l, l.results)
+		labels = labels[:index]
+		return index == 0
+	}
+	result := func() []uint64 {
+		n := len(typ.Results)
+		if len(stack) < n {
+			panic(trap{ErrStackUnderflow})
+		}
+		return append([]uint64(nil), stack[len(stack)-n:]...)
+	}
+	must := func(v uint64, err error) uint64 {
+		if err != nil {
+			panic(trap{err})
+		}
+		return v
+	}
+	// invoke calls a function with the arguments on top of the stack.
+	invoke := func(index uint32, ft FuncType) {
+		n := len(ft.Params)
+		if len(stack) < n {
+			panic(trap{ErrStackUnderflow})
+		}
+		args := append([]uint64(nil), stack[len(stack)-n:]...)
+		stack = stack[:len(stack)-n]
+		for _, v := range m.call(index, args) {
+			push(v)
+		}
+	}
+	address := func(size uint64) uint64 {
+		must(r.uleb(32)) // alignment hint
+		offset := must(r.uleb(32))
+		ea := uint64(uint32(pop())) + offset
+		if ea+size > uint64(len(m.memory)) {
+			panic(trap{ErrMemoryAccess})
+		}
+		return ea
+	}
+
+	for r.pos < len(code) {
+		if !m.UseGas(m.cfg.InstructionGas) {
+			panic(trap{ErrOutOfGas})
+		}
+		start := r.pos
+		op := code[start]
+		r.pos++
+
+		switch op {
+		case opUnreachable:
+			panic(trap{ErrUnreachable})
+		case opNop:
+		case opBlock, opLoop:
+			arity, _ := blockArity(code[r.pos])
+			r.pos++
+			l := label{cont: f.ends[start] + 1, height: len(stack), results: arity}
+			if op == opLoop {
+				l.cont, l.loop = r.pos, true
+			}
+			labels = append(labels, l)
+		case opIf:
+			arity, _ := blockArity(code[r.pos])
+			r.pos++
+			cond := uint32(pop())
+			l := label{cont: f.ends[start] + 1, height: len(stack), results: arity}
+			if cond == 0 {
+				els, ok := f.elses[start]
+				if !ok {
+					r.pos = l.cont
+					break
+				}
+				r.pos = els + 1
+			}
+			labels = append(labels, l)
+		case opElse:
+			// The then branch is done, leave the if.
+			if branch(0) {
+				return result()
+			}
+		case opEnd:
+			unwind(labels[len(labels)-1], labels[len(labels)-1].results)
+			labels = labels[:len(labels)-1]
+			if len(labels) == 0 {
+				return result()
+			}
+		case opBr:
+			if branch(uint32(must(r.uleb(32)))) {
+				return result()
+			}
+		case opBrIf:
+			depth := uint32(must(r.uleb(32)))
+			if uint32(pop()) != 0 && branch(depth) {
+				return result()
+			}
+		case opBrTable:
+			n := must(r.uleb(32))
+			targets := make([]uint32, n)
+			for i := range targets {
+				targets[i] = uint32(must(r.uleb(32)))
+			}
+			depth := uint32(must(r.uleb(32)))
+			if i := uint32(pop()); uint64(i) < n {
+				depth = targets[i]
+			}
+			if branch(depth) {
+				return result()
+			}
+		case opReturn:
+			return result()
+		case opCall:
+			index := uint32(must(r.uleb(32)))
+			ft, _ := m.module.FuncType(index)
+			invoke(index, ft)
+		case opCallIndir:
+			want := m.module.Types[must(r.uleb(32))]
+			r.pos++ // table index
+			slot := uint64(uint32(pop()))
+			if slot >= uint64(len(m.table)) || m.table[slot] < 0 {
+				panic(trap{ErrTableAccess})
+			}
+			index := uint32(m.table[slot])
+			if ft, _ := m.module.FuncType(index); !ft.Equal(want) {
+				panic(trap{ErrInvalidSignature})
+			}
+			invoke(index, want)
+		case opPrefixMisc:
+			sub := must(r.uleb(32))
+			n, src, dst := uint64(uint32(pop())), uint64(uint32(pop())), uint64(uint32(pop()))
+			if !m.UseGas((n + 31) / 32 * m.cfg.CopyGas) {
+				panic(trap{ErrOutOfGas})
+			}
+			if dst+n > uint64(len(m.memory)) {
+				panic(trap{ErrMemoryAccess})
+			}
+			if sub == opMemoryCopy {
+				r.pos += 2
+				if src+n > uint64(len(m.memory)) {
+					panic(trap{ErrMemoryAccess})
+				}
+				copy(m.memory[dst:dst+n], m.memory[src:src+n])
+			} else {
+				r.pos++
+				for i := dst; i < dst+n; i++ {
+					m.memory[i] = byte(src)
+				}
+			}
+		case opDrop:
+			pop()
+		case opSelect:
+			c, b, a := pop(), pop(), pop()
+			if uint32(c) != 0 {
+				push(a)
+			} else {
+				push(b)
+			}
+		case opLocalGet:
+			push(locals[must(r.uleb(32))])
+		case opLocalSet:
+			index := must(r.uleb(32))
+			locals[index] = pop()
+		case opLocalTee:
+			index := must(r.uleb(32))
+			v := pop()
+			locals[index] = v
+			push(v)
+		case opGlobalGet:
+			push(m.globals[must(r.uleb(32))])
+		case opGlobalSet:
+			index := must(r.uleb(32))
+			m.globals[index] = pop()
+
+		case opI32Load:
+			ea := address(4)
+			push(uint64(binary.LittleEndian.Uint32(m.memory[ea:])))
+		case opI64Load:
+			ea := address(8)
+			push(binary.LittleEndian.Uint64(m.memory[ea:]))
+		case opI32Load8S:
+			ea := address(1)
+			push(uint64(uint32(int32(int8(m.memory[ea])))))
+		case opI32Load8U, opI64Load8U:
+			ea := address(1)
+			push(uint64(m.memory[ea]))
+		case opI32Load16S:
+			ea := address(2)
+			push(uint64(uint32(int32(int16(binary.LittleEndian.Uint16(m.memory[ea:]))))))
+		case opI32Load16U, opI64Load16U:
+			ea := address(2)
+			push(uint64(binary.LittleEndian.Uint16(m.memory[ea:])))
+		case opI64Load8S:
+			ea := address(1)
+			push(uint64(int64(int8(m.memory[ea]))))
+		case opI64Load16S:
+			ea := address(2)
+			push(uint64(int64(int16(binary.LittleEndian.Uint16(m.memory[ea:])))))
+		case opI64Load32S:
+			ea := address(4)
+			push(uint64(int64(int32(binary.LittleEndian.Uint32(m.memory[ea:])))))
+		case opI64Load32U:
+			ea := address(4)
+			push(uint64(binary.LittleEndian.Uint32(m.memory[ea:])))
+		case opI32Store, opI64Store32:
+			v := pop()
+			ea := address(4)
+			binary.LittleEndian.PutUint32(m.memory[ea:], uint32(v))
+		case opI64Store:
+			v := pop()
+			ea := address(8)
+			binary.LittleEndian.PutUint64(m.memory[ea:], v)
+		case opI32Store8, opI64Store8:
+			v := pop()
+			ea := address(1)
+			m.memory[ea] = byte(v)
+		case opI32Store16, opI64Store16:
+			v := pop()
+			ea := address(2)
+			binary.LittleEndian.PutUint16(m.memory[ea:], uint16(v))
+		case opMemorySize:
+			r.pos++
+			push(uint64(len(m.memory) / PageSize))
+		case opMemoryGrow:
+			r.pos++
+			push(m.grow(uint32(pop())))
+
+		case opI32Const:
+			push(uint64(uint32(must2(r.sleb(32)))))
+		case opI64Const:
+			push(uint64(must2(r.sleb(64))))
+
+		case opI32Eqz:
+			push(b2u(uint32(pop()) == 0))
+		case opI64Eqz:
+			push(b2u(pop() == 0))
+		default:
+			switch {
+			case op >= opI32Eq && op <= opI32GeU:
+				b, a := uint32(pop()), uint32(pop())
+				push(b2u(compare32(op, a, b)))
+			case op >= opI64Eq && op <= opI64GeU:
+				b, a := pop(), pop()
+				push(b2u(compare64(op, a, b)))
+			case op >= opI32Clz && op <= opI32Popcnt:
+				push(uint64(unary32(op, uint32(pop()))))
+			case op >= opI32Add && op <= opI32Rotr:
+				b, a := uint32(pop()), uint32(pop())
+				push(uint64(binary32(op, a, b)))
+			case op >= opI64Clz && op <= opI64Popcnt:
+				push(unary64(op, pop()))
+			case op >= opI64Add && op <= opI64Rotr:
+				b, a := pop(), pop()
+				push(binary64(op, a, b))
+			default:
+				push(convert(op, pop()))
+			}
+		}
+	}
+	return result()
+}
+
+// grow adds pages to the linear memory and returns the previous size in
+// pages, or -1 if the memory can't grow.
+func (m *Machine) grow(pages uint32) uint64 {
+	size := uint32(len(m.memory) / PageSize)
+	limit := m.cfg.MaxPages
+	if max := m.module.Memory.Max; m.module.Memory.HasMax && max < limit {
+		limit = max
+	}
+	if uint64(size)+uint64(pages) > uint64(limit) {
+		return uint64(^uint32(0))
+	}
+	if !m.UseGas(uint64(pages) * m.cfg.PageGas) {
+		panic(trap{ErrOutOfGas})
+	}
+	m.memory = append(m.memory, make([]byte, int(pages)*PageSize)...)
+	return uint64(size)
+}
+
+func must2(v int64, err error) int64 {
+	if err != nil {
+		panic(trap{err})
+	}
+	return v
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func compare32(op byte, a, b uint32) bool {
+	switch op {
+	case opI32Eq:
+		return a == b
+	case opI32Ne:
+		return a != b
+	case opI32LtS:
+		return int32(a) < int32(b)
+	case opI32LtU:
+		return a < b
+	case opI32GtS:
+		return int32(a) > int32(b)
+	case opI32GtU:
+		return a > b
+	case opI32LeS:
+		return int32(a) <= int32(b)
+	case opI32LeU:
+		return a <= b
+	case opI32GeS:
+		return int32(a) >= int32(b)
+	default:
+		return a >= b
+	}
+}
+
+func compare64(op byte, a, b uint64) bool {
+	switch op {
+	case opI64Eq:
+		return a == b
+	case opI64Ne:
+		return a != b
+	case opI64LtS:
+		return int64(a) < int64(b)
+	case opI64LtU:
+		return a < b
+	case opI64GtS:
+		return int64(a) > int64(b)
+	case opI64GtU:
+		return a > b
+	case opI64LeS:
+		return int64(a) <= int64(b)
+	case opI64LeU:
+		return a <= b
+	case opI64GeS:
+		return int64(a) >= int64(b)
+	default:
+		return a >= b
+	}
+}
+
+func unary32(op byte, a uint32) uint32 {
+	switch op {
+	case opI32Clz:
+		return uint32(bits.LeadingZeros32(a))
+	case opI32Ctz:
+		return uint32(bits.TrailingZeros32(a))
+	default:
+		return uint32(bits.OnesCount32(a))
+	}
+}
+
+func unary64(op byte, a uint64) uint64 {
+	switch op {
+	case opI64Clz:
+		return uint64(bits.LeadingZeros64(a))
+	case opI64Ctz:
+		return uint64(bits.TrailingZeros64(a))
+	default:
+		return uint64(bits.OnesCount64(a))
+	}
+}
+
+func binary32(op byte, a, b uint32) uint32 {
+	switch op {
+	case opI32Add:
+		return a + b
+	case opI32Sub:
+		return a - b
+	case opI32Mul:
+		return a * b
+	case opI32DivS:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int32(a) == -1<<31 && int32(b) == -1 {
+			panic(trap{ErrIntegerOverflow})
+		}
+		return uint32(int32(a) / int32(b))
+	case opI32DivU:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		return a / b
+	case opI32RemS:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case opI32RemU:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		return a % b
+	case opI32And:
+		return a & b
+	case opI32Or:
+		return a | b
+	case opI32Xor:
+		return a ^ b
+	case opI32Shl:
+		return a << (b & 31)
+	case opI32ShrS:
+		return uint32(int32(a) >> (b & 31))
+	case opI32ShrU:
+		return a >> (b & 31)
+	case opI32Rotl:
+		return bits.RotateLeft32(a, int(b&31))
+	default:
+		return bits.RotateLeft32(a, -int(b&31))
+	}
+}
+
+func binary64(op byte, a, b uint64) uint64 {
+	switch op {
+	case opI64Add:
+		return a + b
+	case opI64Sub:
+		return a - b
+	case opI64Mul:
+		return a * b
+	case opI64DivS:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int64(a) == -1<<63 && int64(b) == -1 {
+			panic(trap{ErrIntegerOverflow})
+		}
+		return uint64(int64(a) / int64(b))
+	case opI64DivU:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		return a / b
+	case opI64RemS:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case opI64RemU:
+		if b == 0 {
+			panic(trap{ErrDivideByZero})
+		}
+		return a % b
+	case opI64And:
+		return a & b
+	case opI64Or:
+		return a | b
+	case opI64Xor:
+		return a ^ b
+	case opI64Shl:
+		return a << (b & 63)
+	case opI64ShrS:
+		return uint64(int64(a) >> (b & 63))
+	case opI64ShrU:
+		return a >> (b & 63)
+	case opI64Rotl:
+		return bits.RotateLeft64(a, int(b&63))
+	default:
+		return bits.RotateLeft64(a, -int(b&63))
+	}
+}
+
+func convert(op byte, a uint64) uint64 {
+	switch op {
+	case opI32WrapI64:
+		return uint64(uint32(a))
+	case opI64ExtendI32S:
+		return uint64(int64(int32(a)))
+	case opI64ExtendI32U:
+		return uint64(uint32(a))
+	case opI32Extend8S:
+		return uint64(uint32(int32(int8(a))))
+	case opI32Extend16S:
+		return uint64(uint32(int32(int16(a))))
+	case opI64Extend8S:
+		return uint64(int64(int8(a)))
+	case opI64Extend16S:
+		return uint64(int64(int16(a)))
+	default:
+		return uint64(int64(int32(a)))
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wasm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func uleb(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func vec(items ...[]byte) []byte {
+	return append(uleb(uint64(len(items))), bytes.Join(items, nil)...)
+}
+
+func section(id byte, body []byte) []byte {
+	return append(append([]byte{id}, uleb(uint64(len(body)))...), body...)
+}
+
+func str(s string) []byte {
+	return append(uleb(uint64(len(s))), s...)
+}
+
+func funcBody(locals []byte, code ...byte) []byte {
+	body := append(locals, code...)
+	return append(uleb(uint64(len(body))), body...)
+}
+
+func buildModule(sections ...[]byte) []byte {
+	return append(append([]byte(nil), Magic...), bytes.Join(sections, nil)...)
+}
+
+// testModule exports fac(i64) i64, fib(i32) i32, mem() i32, div0() i32,
+// spin() and indirect() i32.
+func testModule() []byte {
+	return buildModule(
+		section(sectionType, vec(
+			[]byte{0x60, 1, byte(I64), 1, byte(I64)},
+			[]byte{0x60, 1, byte(I32), 1, byte(I32)},
+			[]byte{0x60, 0, 1, byte(I32)},
+			[]byte{0x60, 0, 0},
+		)),
+		section(sectionFunction, vec([]byte{0}, []byte{1}, []byte{2}, []byte{2}, []byte{3}, []byte{2})),
+		section(sectionTable, vec([]byte{0x70, 0, 1})),
+		section(sectionMemory, vec([]byte{0, 1})),
+		section(sectionExport, vec(
+			append(str("fac"), ExportFunc, 0),
+			append(str("fib"), ExportFunc, 1),
+			append(str("mem"), ExportFunc, 2),
+			append(str("div0"), ExportFunc, 3),
+			append(str("spin"), ExportFunc, 4),
+			append(str("indirect"), ExportFunc, 5),
+		)),
+		section(sectionElement, vec([]byte{0, opI32Const, 0, opEnd, 1, 1})),
+		section(sectionCode, vec(
+			// fac: iterative factorial
+			funcBody([]byte{1, 1, byte(I64)},
+				opI64Const, 1, opLocalSet, 1,
+				opBlock, blockTypeEmpty, opLoop, blockTypeEmpty,
+				opLocalGet, 0, opI64Eqz, opBrIf, 1,
+				opLocalGet, 1, opLocalGet, 0, opI64Mul, opLocalSet, 1,
+				opLocalGet, 0, opI64Const, 1, opI64Sub, opLocalSet, 0,
+				opBr, 0,
+				opEnd, opEnd,
+				opLocalGet, 1, opEnd),
+			// fib: recursive fibonacci
+			funcBody([]byte{0},
+				opLocalGet, 0, opI32Const, 2, opI32LtU,
+				opIf, byte(I32),
+				opLocalGet, 0,
+				opElse,
+				opLocalGet, 0, opI32Const, 1, opI32Sub, opCall, 1,
+				opLocalGet, 0, opI32Const, 2, opI32Sub, opCall, 1,
+				opI32Add,
+				opEnd, opEnd),
+			// mem: data segment and sign extending loads
+			funcBody([]byte{0},
+				opI32Const, 50, opI32Const, 0x7f, opI32Store, 2, 0, // stores -1
+				opI32Const, 0, opI32Load8U, 0, 1,
+				opI32Const, 50, opI32Load8S, 0, 0,
+				opI32Add, opEnd),
+			// div0
+			funcBody([]byte{0}, opI32Const, 1, opI32Const, 0, opI32DivU, opEnd),
+			// spin: endless loop
+			funcBody([]byte{0}, opLoop, blockTypeEmpty, opBr, 0, opEnd, opEnd),
+			// indirect: fib(10) through the table
+			funcBody([]byte{0}, opI32Const, 10, opI32Const, 0, opCallIndir, 1, 0, opEnd),
+		)),
+		section(sectionData, vec(append([]byte{0, opI32Const, 0, opEnd}, str("hi")...))),
+	)
+}
+
+var testConfig = Config{
+	InstructionGas: 1,
+	PageGas:        10,
+	CopyGas:        1,
+	MaxPages:       4,
+	MaxCallDepth:   64,
+	MaxStack:       1024,
+}
+
+func TestExecute(t *testing.T) {
+	module, err := Decode(testModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		arg  []uint64
+		want uint64
+		err  error
+	}{
+		{"fac", []uint64{10}, 3628800, nil},
+		{"fib", []uint64{15}, 610, nil},
+		{"mem", nil, 'i' - 1, nil},
+		{"indirect", nil, 55, nil},
+		{"div0", nil, 0, ErrDivideByZero},
+		{"spin", nil, 0, ErrOutOfGas},
+	}
+	for _, tt := range tests {
+		m, err := Instantiate(module, nil, testConfig, 100000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := m.Invoke(tt.name, tt.arg...)
+		if err != tt.err {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && (len(results) != 1 || results[0] != tt.want) {
+			t.Errorf("%s: result mismatch: have %v, want %d", tt.name, results, tt.want)
+		}
+		if used := 100000 - m.Gas(); tt.err != ErrOutOfGas && (used <= testConfig.PageGas || used >= 100000) {
+			t.Errorf("%s: unexpected gas use %d", tt.name, used)
+		}
+	}
+}
+
+func TestHostCall(t *testing.T) {
+	code := buildModule(
+		section(sectionType, vec([]byte{0x60, 1, byte(I32), 1, byte(I32)}, []byte{0x60, 0, 1, byte(I32)})),
+		section(sectionImport, vec(append(append(str("env"), str("double")...), ExportFunc, 0))),
+		section(sectionFunction, vec([]byte{1})),
+		section(sectionExport, vec(append(str("main"), ExportFunc, 1))),
+		section(sectionCode, vec(funcBody([]byte{0}, opI32Const, 21, opCall, 0, opEnd))),
+	)
+	module, err := Decode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Instantiate(module, nil, testConfig, 1000); err == nil {
+		t.Fatal("instantiated with unresolved import")
+	}
+	imports := Imports{"env.double": {
+		Type: FuncType{Params: []ValueType{I32}, Results: []ValueType{I32}},
+		Gas:  100,
+		Call: func(m *Machine, args []uint64) ([]uint64, error) { return []uint64{args[0] * 2}, nil },
+	}}
+	m, err := Instantiate(module, imports, testConfig, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := m.Invoke("main")
+	if err != nil || len(results) != 1 || results[0] != 42 {
+		t.Fatalf("result mismatch: have %v %v, want 42", results, err)
+	}
+	if used := 1000 - m.Gas(); used != 103 {
+		t.Errorf("gas mismatch: have %d, want 103", used)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+	}{
+		{"preamble", []byte{0x00, 0x61, 0x73}},
+		{"float type", buildModule(section(sectionType, vec([]byte{0x60, 1, 0x7d, 0})))},
+		{"start", buildModule(section(sectionStart, uleb(0)))},
+		{"float op", buildModule(
+			section(sectionType, vec([]byte{0x60, 0, 0})),
+			section(sectionFunction, vec([]byte{0})),
+			section(sectionCode, vec(funcBody([]byte{0}, 0x43, 0, 0, 0, 0, opDrop, opEnd))),
+		)},
+		{"branch depth", buildModule(
+			section(sectionType, vec([]byte{0x60, 0, 0})),
+			section(sectionFunction, vec([]byte{0})),
+			section(sectionCode, vec(funcBody([]byte{0}, opBr, 1, opEnd))),
+		)},
+		{"missing end", buildModule(
+			section(sectionType, vec([]byte{0x60, 0, 0})),
+			section(sectionFunction, vec([]byte{0})),
+			section(sectionCode, vec(funcBody([]byte{0}, opNop))),
+		)},
+	}
+	for _, tt := range tests {
+		if _, err := Decode(tt.code); err == nil {
+			t.Errorf("%s: decoded invalid module", tt.name)
+		}
+	}
+}
@@ -0,0 +1,707 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package wasm implements a deterministic WebAssembly interpreter for
+// contracts.
+//
+// Only the integer subset of the WebAssembly MVP is accepted, plus the sign
+// extension operators and memory.copy and memory.fill. Floating point types
+// and operators, start functions and imports other than functions are
+// rejected when a module is decoded, so every accepted module executes the
+// same way on every node. Function bodies are not type checked; operand
+// stack misuse traps at run time.
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Magic is the preamble of every WebAssembly binary.
+var Magic = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+// IsWasm reports whether code is a WebAssembly binary.
+func IsWasm(code []byte) bool {
+	return bytes.HasPrefix(code, Magic)
+}
+
+// ValueType is the type of a WebAssembly value.
+type ValueType byte
+
+const (
+	I32 ValueType = 0x7f
+	I64 ValueType = 0x7e
+)
+
+// FuncType is the signature of a function.
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+// Equal reports whether both signatures are identical.
+func (t FuncType) Equal(o FuncType) bool {
+	return bytes.Equal(valueBytes(t.Params), valueBytes(o.Params)) &&
+		bytes.Equal(valueBytes(t.Results), valueBytes(o.Results))
+}
+
+func valueBytes(vs []ValueType) []byte {
+	b := make([]byte, len(vs))
+	for i, v := range vs {
+		b[i] = byte(v)
+	}
+	return b
+}
+
+// Import is an imported host function.
+type Import struct {
+	Module string
+	Name   string
+	Type   uint32
+}
+
+// Global is a module global variable.
+type Global struct {
+	Type    ValueType
+	Mutable bool
+	Init    uint64
+}
+
+// Export kinds.
+const (
+	ExportFunc   byte = 0x00
+	ExportTable  byte = 0x01
+	ExportMemory byte = 0x02
+	ExportGlobal byte = 0x03
+)
+
+// Export is an exported module item.
+type Export struct {
+	Kind  byte
+	Index uint32
+}
+
+// Limits bounds the size of the linear memory in pages.
+type Limits struct {
+	Min    uint32
+	Max    uint32
+	HasMax bool
+}
+
+// Element is a segment of function indices copied into the table at
+// instantiation.
+type Element struct {
+	Offset uint32
+	Init   []uint32
+}
+
+// Data is a segment copied into the linear memory at instantiation.
+type Data struct {
+	Offset uint32
+	Init   []byte
+}
+
+// Function is a function defined by the module.
+type Function struct {
+	Type   uint32
+	Locals []ValueType
+	Code   []byte
+
+	// Positions resolved by compile, keyed by the offset of the opcode.
+	ends  map[int]int // block, loop and if to their end
+	elses map[int]int // if to its else
+}
+
+// Module is a decoded WebAssembly module.
+type Module struct {
+	Types    []FuncType
+	Imports  []Import
+	Funcs    []*Function
+	Table    *Limits
+	Memory   *Limits
+	Globals  []Global
+	Exports  map[string]Export
+	Elements []Element
+	Data     []Data
+}
+
+// FuncType returns the signature of the function at index, which counts the
+// imported functions first.
+func (m *Module) FuncType(index uint32) (FuncType, bool) {
+	if int(index) < len(m.Imports) {
+		return m.Types[m.Imports[index].Type], true
+	}
+	index -= uint32(len(m.Imports))
+	if int(index) >= len(m.Funcs) {
+		return FuncType{}, false
+	}
+	return m.Types[m.Funcs[index].Type], true
+}
+
+// Section ids.
+const (
+	sectionCustom   = 0
+	sectionType     = 1
+	sectionImport   = 2
+	sectionFunction = 3
+	sectionTable    = 4
+	sectionMemory   = 5
+	sectionGlobal   = 6
+	sectionExport   = 7
+	sectionStart    = 8
+	sectionElement  = 9
+	sectionCode     = 10
+	sectionData     = 11
+)
+
+// Decode parses and checks a WebAssembly binary.
+func Decode(code []byte) (*Module, error) {
+	if !IsWasm(code) {
+		return nil, fmt.Errorf("%v: bad preamble", ErrInvalidModule)
+	}
+	m := &Module{Exports: make(map[string]Export)}
+	r := &reader{b: code, pos: len(Magic)}
+	var funcTypes []uint32
+	last := byte(0)
+	for r.pos < len(r.b) {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		body, err := r.bytes(int(size))
+		if err != nil {
+			return nil, err
+		}
+		if id == sectionCustom {
+			continue
+		}
+		if id <= last {
+			return nil, fmt.Errorf("%v: section %d out of order", ErrInvalidModule, id)
+		}
+		last = id
+		sr := &reader{b: body}
+		switch id {
+		case sectionType:
+			err = m.decodeTypes(sr)
+		case sectionImport:
+			err = m.decodeImports(sr)
+		case sectionFunction:
+			funcTypes, err = sr.vecU32()
+		case sectionTable:
+			m.Table, err = sr.limitsVec(0x70)
+		case sectionMemory:
+			m.Memory, err = sr.limitsVec(0)
+		case sectionGlobal:
+			err = m.decodeGlobals(sr)
+		case sectionExport:
+			err = m.decodeExports(sr)
+		case sectionCode:
+			err = m.decodeCode(sr, funcTypes)
+		case sectionElement:
+			err = m.decodeElements(sr)
+		case sectionData:
+			err = m.decodeData(sr)
+		case sectionStart:
+			err = fmt.Errorf("%v: section %d", ErrUnsupported, id)
+		default:
+			err = fmt.Errorf("%v: unknown section %d", ErrInvalidModule, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sr.pos != len(sr.b) {
+			return nil, fmt.Errorf("%v: section %d size mismatch", ErrInvalidModule, id)
+		}
+	}
+	if len(funcTypes) != len(m.Funcs) {
+		return nil, fmt.Errorf("%v: function and code sections mismatch", ErrInvalidModule)
+	}
+	return m, m.check()
+}
+
+// check verifies the indices referenced by the module.
+func (m *Module) check() error {
+	for _, imp := range m.Imports {
+		if int(imp.Type) >= len(m.Types) {
+			return fmt.Errorf("%v: type index out of range", ErrInvalidModule)
+		}
+	}
+	for _, f := range m.Funcs {
+		if int(f.Type) >= len(m.Types) {
+			return fmt.Errorf("%v: type index out of range", ErrInvalidModule)
+		}
+	}
+	for name, exp := range m.Exports {
+		switch exp.Kind {
+		case ExportFunc:
+			if _, ok := m.FuncType(exp.Index); !ok {
+				return fmt.Errorf("%v: export %q out of range", ErrInvalidModule, name)
+			}
+		case ExportTable:
+			if m.Table == nil || exp.Index != 0 {
+				return fmt.Errorf("%v: export %q out of range", ErrInvalidModule, name)
+			}
+		case ExportMemory:
+			if m.Memory == nil || exp.Index != 0 {
+				return fmt.Errorf("%v: export %q out of range", ErrInvalidModule, name)
+			}
+		case ExportGlobal:
+			if int(exp.Index) >= len(m.Globals) {
+				return fmt.Errorf("%v: export %q out of range", ErrInvalidModule, name)
+			}
+		default:
+			return fmt.Errorf("%v: export %q kind %d", ErrInvalidModule, name, exp.Kind)
+		}
+	}
+	if len(m.Elements) > 0 && m.Table == nil {
+		return fmt.Errorf("%v: elements without table", ErrInvalidModule)
+	}
+	for _, e := range m.Elements {
+		for _, index := range e.Init {
+			if _, ok := m.FuncType(index); !ok {
+				return fmt.Errorf("%v: element function %d out of range", ErrInvalidModule, index)
+			}
+		}
+	}
+	if len(m.Data) > 0 && m.Memory == nil {
+		return fmt.Errorf("%v: data without memory", ErrInvalidModule)
+	}
+	for _, f := range m.Funcs {
+		if err := m.compile(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Module) decodeTypes(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != 0x60 {
+			return fmt.Errorf("%v: bad function type form 0x%x", ErrInvalidModule, form)
+		}
+		params, err := r.valueTypes()
+		if err != nil {
+			return err
+		}
+		results, err := r.valueTypes()
+		if err != nil {
+			return err
+		}
+		if len(results) > 1 {
+			return fmt.Errorf("%v: multiple results", ErrUnsupported)
+		}
+		m.Types = append(m.Types, FuncType{Params: params, Results: results})
+	}
+	return nil
+}
+
+func (m *Module) decodeImports(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		module, err := r.name()
+		if err != nil {
+			return err
+		}
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if kind != ExportFunc {
+			return fmt.Errorf("%v: import %s.%s kind %d", ErrUnsupported, module, name, kind)
+		}
+		typ, err := r.u32()
+		if err != nil {
+			return err
+		}
+		m.Imports = append(m.Imports, Import{Module: module, Name: name, Type: typ})
+	}
+	return nil
+}
+
+func (m *Module) decodeElements(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		mode, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if mode != 0 {
+			return fmt.Errorf("%v: element segment mode %d", ErrUnsupported, mode)
+		}
+		offset, err := r.constExpr(I32)
+		if err != nil {
+			return err
+		}
+		init, err := r.vecU32()
+		if err != nil {
+			return err
+		}
+		m.Elements = append(m.Elements, Element{Offset: uint32(offset), Init: init})
+	}
+	return nil
+}
+
+func (m *Module) decodeGlobals(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		typ, err := r.valueType()
+		if err != nil {
+			return err
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if mut > 1 {
+			return fmt.Errorf("%v: global mutability 0x%x", ErrInvalidModule, mut)
+		}
+		init, err := r.constExpr(typ)
+		if err != nil {
+			return err
+		}
+		m.Globals = append(m.Globals, Global{Type: typ, Mutable: mut == 1, Init: init})
+	}
+	return nil
+}
+
+func (m *Module) decodeExports(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		index, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if _, ok := m.Exports[name]; ok {
+			return fmt.Errorf("%v: duplicate export %q", ErrInvalidModule, name)
+		}
+		m.Exports[name] = Export{Kind: kind, Index: index}
+	}
+	return nil
+}
+
+func (m *Module) decodeCode(r *reader, types []uint32) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if int(n) != len(types) {
+		return fmt.Errorf("%v: function and code sections mismatch", ErrInvalidModule)
+	}
+	for i := uint32(0); i < n; i++ {
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		body, err := r.bytes(int(size))
+		if err != nil {
+			return err
+		}
+		br := &reader{b: body}
+		groups, err := br.u32()
+		if err != nil {
+			return err
+		}
+		f := &Function{Type: types[i]}
+		for j := uint32(0); j < groups; j++ {
+			count, err := br.u32()
+			if err != nil {
+				return err
+			}
+			typ, err := br.valueType()
+			if err != nil {
+				return err
+			}
+			if uint64(len(f.Locals))+uint64(count) > maxLocals {
+				return fmt.Errorf("%v: too many locals", ErrInvalidModule)
+			}
+			for k := uint32(0); k < count; k++ {
+				f.Locals = append(f.Locals, typ)
+			}
+		}
+		f.Code = body[br.pos:]
+		m.Funcs = append(m.Funcs, f)
+	}
+	return nil
+}
+
+func (m *Module) decodeData(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		memory, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if memory != 0 {
+			return fmt.Errorf("%v: data segment mode %d", ErrUnsupported, memory)
+		}
+		offset, err := r.constExpr(I32)
+		if err != nil {
+			return err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		init, err := r.bytes(int(size))
+		if err != nil {
+			return err
+		}
+		m.Data = append(m.Data, Data{Offset: uint32(offset), Init: init})
+	}
+	return nil
+}
+
+// maxLocals bounds the locals of a function, so a tiny body can't make the
+// interpreter allocate huge frames.
+const maxLocals = 50000
+
+// reader decodes the binary encoding.
+type reader struct {
+	b   []byte
+	pos int
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, fmt.Errorf("%v: %v", ErrInvalidModule, io.ErrUnexpectedEOF)
+	}
+	b := r.b[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.b)-r.pos {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidModule, io.ErrUnexpectedEOF)
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uleb decodes an unsigned LEB128 integer of at most bits bits.
+func (r *reader) uleb(bits uint) (uint64, error) {
+	var result uint64
+	for shift := uint(0); ; shift += 7 {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits || (bits-shift < 7 && uint64(b&0x7f)>>(bits-shift) != 0) {
+			return 0, fmt.Errorf("%v: integer too large", ErrInvalidModule)
+		}
+		result |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+	}
+}
+
+// sleb decodes a signed LEB128 integer of at most bits bits.
+func (r *reader) sleb(bits uint) (int64, error) {
+	var result int64
+	shift := uint(0)
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits {
+			return 0, fmt.Errorf("%v: integer too large", ErrInvalidModule)
+		}
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			break
+		}
+	}
+	if bits < 64 && (result < -(1<<(bits-1)) || result >= 1<<(bits-1)) {
+		return 0, fmt.Errorf("%v: integer too large", ErrInvalidModule)
+	}
+	return result, nil
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return uint32(v), err
+}
+
+func (r *reader) vecU32() ([]uint32, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > len(r.b)-r.pos {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidModule, io.ErrUnexpectedEOF)
+	}
+	vs := make([]uint32, n)
+	for i := range vs {
+		if vs[i], err = r.u32(); err != nil {
+			return nil, err
+		}
+	}
+	return vs, nil
+}
+
+// limitsVec decodes a table or memory section, which holds at most one
+// entry. Tables are prefixed by their element type.
+func (r *reader) limitsVec(elemType byte) (*Limits, error) {
+	n, err := r.u32()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("%v: multiple tables or memories", ErrUnsupported)
+	}
+	if elemType != 0 {
+		if t, err := r.byte(); err != nil || t != elemType {
+			return nil, fmt.Errorf("%v: table element type", ErrUnsupported)
+		}
+	}
+	flags, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	if flags > 1 {
+		return nil, fmt.Errorf("%v: limits flags 0x%x", ErrUnsupported, flags)
+	}
+	limits := &Limits{HasMax: flags == 1}
+	if limits.Min, err = r.u32(); err != nil {
+		return nil, err
+	}
+	if limits.HasMax {
+		if limits.Max, err = r.u32(); err != nil {
+			return nil, err
+		}
+		if limits.Max < limits.Min {
+			return nil, fmt.Errorf("%v: limits", ErrInvalidModule)
+		}
+	}
+	return limits, nil
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(n))
+	return string(b), err
+}
+
+func (r *reader) valueType() (ValueType, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch t := ValueType(b); t {
+	case I32, I64:
+		return t, nil
+	case 0x7d, 0x7c:
+		return 0, fmt.Errorf("%v: floating point", ErrUnsupported)
+	default:
+		return 0, fmt.Errorf("%v: value type 0x%x", ErrInvalidModule, b)
+	}
+}
+
+func (r *reader) valueTypes() ([]ValueType, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > len(r.b)-r.pos {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidModule, io.ErrUnexpectedEOF)
+	}
+	ts := make([]ValueType, n)
+	for i := range ts {
+		if ts[i], err = r.valueType(); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+// constExpr decodes a constant initializer expression of type typ.
+func (r *reader) constExpr(typ ValueType) (uint64, error) {
+	op, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	switch {
+	case op == opI32Const && typ == I32:
+		c, err := r.sleb(32)
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(uint32(c))
+	case op == opI64Const && typ == I64:
+		c, err := r.sleb(64)
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(c)
+	default:
+		return 0, fmt.Errorf("%v: initializer opcode 0x%x", ErrUnsupported, op)
+	}
+	if end, err := r.byte(); err != nil || end != opEnd {
+		return 0, fmt.Errorf("%v: unterminated initializer", ErrInvalidModule)
+	}
+	return v, nil
+}
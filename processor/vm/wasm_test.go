@@ -0,0 +1,102 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/accountmanager"
+	"github.com/fractalplatform/fractal/asset"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/params"
+	"github.com/fractalplatform/fractal/state"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+// echoWasm is a WebAssembly contract which stores the first 32 bytes of its
+// input in slot 0 and returns them. It imports input_copy, storage_store and
+// set_output from the env module and exports call.
+var echoWasm = common.Hex2Bytes("0061736d01000000010d0360017f0060027f7f0060000002370303656e760a696e7075745f636f7079000003656e760d73746f726167655f73746f7265000103656e760a7365745f6f757470757400010302010205030100010708010463616c6c00030a150113004100100041c000410010014100412010020b")
+
+func TestWasmContract(t *testing.T) {
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(fdb.NewMemDatabase()))
+	am, _ := accountmanager.NewAccountManager(statedb)
+
+	key, _ := crypto.GenerateKey()
+	pubkey := common.BytesToPubKey(crypto.FromECDSAPub(&key.PublicKey))
+	sender, contract := common.Name("wasmsender"), common.Name("wasmecho")
+	if err := am.CreateAccount(sender, pubkey); err != nil {
+		t.Fatal(err)
+	}
+	ao, _ := asset.NewAssetObject("wasmcoin", "wsm", big.NewInt(1000), 0, sender)
+	if err := am.IssueAsset(ao); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := am.GetAssetInfoByName("wasmcoin")
+	id := info.GetAssetId()
+
+	// Before the fork the module is EVM bytecode, which stops at once.
+	config := &params.ChainConfig{WasmBlock: big.NewInt(10)}
+	evm := NewEVM(Context{AssetID: id, BlockNumber: big.NewInt(9)}, am, statedb, config, Config{})
+	create := types.NewAction(types.CreateContract, sender, "wasmearly", 0, id, 1000000, big.NewInt(0), echoWasm)
+	if _, _, err := evm.Create(AccountRef(sender), create, 1000000); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	acct, _ := am.GetAccountByName("wasmearly")
+	if code, _ := acct.GetCode(); len(code) != 0 {
+		t.Fatalf("module deployed before the fork: code %x", code)
+	}
+
+	evm = NewEVM(Context{AssetID: id, BlockNumber: big.NewInt(10)}, am, statedb, config, Config{})
+	create = types.NewAction(types.CreateContract, sender, contract, 0, id, 1000000, big.NewInt(0), echoWasm)
+	if _, _, err := evm.Create(AccountRef(sender), create, 1000000); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	acct, _ = am.GetAccountByName(contract)
+	if code, _ := acct.GetCode(); !bytes.Equal(code, echoWasm) {
+		t.Fatal("deployed code is not the module")
+	}
+
+	input := common.BytesToHash([]byte("hello wasm")).Bytes()
+	call := types.NewAction(types.CallContract, sender, contract, 0, id, 100000, big.NewInt(0), input)
+	ret, gas, err := evm.CallContract(AccountRef(sender), call, 100000)
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if !bytes.Equal(ret, input) {
+		t.Errorf("output mismatch: have %x, want %x", ret, input)
+	}
+	if stored := statedb.GetState(contract.String(), common.Hash{}); !bytes.Equal(stored[:], input) {
+		t.Errorf("storage mismatch: have %x, want %x", stored, input)
+	}
+	if gas >= 100000 {
+		t.Error("call consumed no gas")
+	}
+
+	// Out of gas reverts the storage write.
+	call = types.NewAction(types.CallContract, sender, contract, 0, id, 1000, big.NewInt(0), make([]byte, 32))
+	if _, _, err := evm.CallContract(AccountRef(sender), call, 1000); err != ErrOutOfGas {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrOutOfGas)
+	}
+	if stored := statedb.GetState(contract.String(), common.Hash{}); !bytes.Equal(stored[:], input) {
+		t.Errorf("storage changed by failed call: %x", stored)
+	}
+}
@@ -18,14 +18,21 @@ package rawdb
 
 import (
 	"bytes"
+	"compress/flate"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"math/big"
+	"sort"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
 	"github.com/fractalplatform/fractal/utils/rlp"
+	"github.com/golang/snappy"
 )
 
 // ReadCanonicalHash retrieves the hash assigned to a canonical block number.
@@ -37,6 +44,22 @@ func ReadCanonicalHash(db DatabaseReader, number uint64) common.Hash {
 	return common.BytesToHash(data)
 }
 
+// ReadAllHashes retrieves the hashes of all the headers stored at a block
+// number, the canonical one and the ones of its non-canonical siblings.
+func ReadAllHashes(db fdb.Iteratee, number uint64) []common.Hash {
+	prefix := append(append([]byte{}, headerPrefix...), encodeBlockNumber(number)...)
+	it := db.NewIteratorWithPrefix(prefix)
+	defer it.Release()
+
+	var hashes []common.Hash
+	for it.Next() {
+		if key := it.Key(); len(key) == len(prefix)+common.HashLength {
+			hashes = append(hashes, common.BytesToHash(key[len(prefix):]))
+		}
+	}
+	return hashes
+}
+
 // WriteCanonicalHash stores the hash assigned to a canonical block number.
 func WriteCanonicalHash(db DatabaseWriter, hash common.Hash, number uint64) {
 	if err := db.Put(headerHashKey(number), hash.Bytes()); err != nil {
@@ -293,8 +316,13 @@ func ReadReceipts(db DatabaseReader, hash common.Hash, number uint64) []*types.R
 	if len(data) == 0 {
 		return nil
 	}
+	data, err := decodeReceiptsBundle(db, data)
+	if err != nil {
+		log.Error("Invalid compressed receipts", "hash", hash, "err", err)
+		return nil
+	}
 	// Convert the revceipts from their storage form to their internal representation
-	storageReceipts := []*types.Receipt{}
+	storageReceipts := []*types.ReceiptForStorage{}
 	if err := rlp.DecodeBytes(data, &storageReceipts); err != nil {
 		fmt.Println("Invalid receipt array RLP", "hash", hash.String(), "err", err)
 		return nil
@@ -306,19 +334,20 @@ func ReadReceipts(db DatabaseReader, hash common.Hash, number uint64) []*types.R
 	return receipts
 }
 
-// WriteReceipts stores all the transaction receipts belonging to a block.
+// WriteReceipts stores all the transaction receipts belonging to a block,
+// together with their logs, as a single snappy compressed bundle.
 func WriteReceipts(db DatabaseWriter, hash common.Hash, number uint64, receipts []*types.Receipt) {
 	// Convert the receipts into their storage form and serialize them
-	storageReceipts := make([]*types.Receipt, len(receipts))
+	storageReceipts := make([]*types.ReceiptForStorage, len(receipts))
 	for i, receipt := range receipts {
-		storageReceipts[i] = (*types.Receipt)(receipt)
+		storageReceipts[i] = (*types.ReceiptForStorage)(receipt)
 	}
 	bytes, err := rlp.EncodeToBytes(storageReceipts)
 	if err != nil {
 		log.Crit("Failed to encode block receipts", "err", err)
 	}
 	// Store the flattened receipt slice
-	if err := db.Put(blockReceiptsKey(number, hash), bytes); err != nil {
+	if err := db.Put(blockReceiptsKey(number, hash), encodeReceiptsBundle(bytes)); err != nil {
 		log.Crit("Failed to store block receipts", "err", err)
 	}
 }
@@ -330,6 +359,321 @@ func DeleteReceipts(db DatabaseDeleter, hash common.Hash, number uint64) {
 	}
 }
 
+// ReceiptsEpochLength is the number of blocks whose receipts share a
+// compression dictionary.
+const ReceiptsEpochLength = 4096
+
+const (
+	// receiptsSnappyPrefix marks a receipts bundle compressed with snappy on
+	// its own. Receipts stored before compression was introduced are a plain
+	// RLP list, whose first byte is never below 0xc0.
+	receiptsSnappyPrefix = 0x01
+	// receiptsDeflatePrefix marks a receipts bundle deflated with the dictionary
+	// of its epoch, whose number follows the prefix.
+	receiptsDeflatePrefix = 0x02
+
+	maxReceiptsDictSize    = 32 * 1024  // size of the deflate window
+	maxReceiptsDictSamples = 128 * 1024 // bytes of receipts a dictionary is trained on
+	receiptsDictSegment    = 32         // length of the segments of a dictionary
+)
+
+// ReadReceiptsDict retrieves the receipts compression dictionary of an epoch.
+func ReadReceiptsDict(db DatabaseReader, epoch uint64) []byte {
+	data, _ := db.Get(receiptsDictKey(epoch))
+	return data
+}
+
+// WriteReceiptsDict stores the receipts compression dictionary of an epoch.
+func WriteReceiptsDict(db DatabaseWriter, epoch uint64, dict []byte) {
+	if err := db.Put(receiptsDictKey(epoch), dict); err != nil {
+		log.Crit("Failed to store receipts dictionary", "err", err)
+	}
+}
+
+// encodeReceiptsBundle compresses the RLP encoded receipts of a block on its
+// own. The bundles of the blocks of an epoch are recompressed with a shared
+// dictionary once the epoch is compacted, see CompactReceiptsEpoch.
+func encodeReceiptsBundle(data []byte) []byte {
+	return append([]byte{receiptsSnappyPrefix}, snappy.Encode(nil, data)...)
+}
+
+// encodeEpochReceiptsBundle deflates the RLP encoded receipts of a block with
+// the dictionary of its epoch.
+func encodeEpochReceiptsBundle(epoch uint64, dict, data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte{receiptsDeflatePrefix}, encodeBlockNumber(epoch)...))
+	w, err := flate.NewWriterDict(buf, flate.BestCompression, dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeReceiptsBundle returns the RLP encoded receipts of a stored bundle,
+// compressed on its own, with the dictionary of its epoch or in the legacy
+// uncompressed layout.
+func decodeReceiptsBundle(db DatabaseReader, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	switch data[0] {
+	case receiptsSnappyPrefix:
+		return snappy.Decode(nil, data[1:])
+	case receiptsDeflatePrefix:
+		if len(data) < 9 {
+			return nil, errors.New("truncated receipts bundle")
+		}
+		epoch := binary.BigEndian.Uint64(data[1:9])
+		dict := ReadReceiptsDict(db, epoch)
+		if len(dict) == 0 {
+			return nil, fmt.Errorf("missing receipts dictionary of epoch %d", epoch)
+		}
+		r := flate.NewReaderDict(bytes.NewReader(data[9:]), dict)
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	default:
+		return data, nil
+	}
+}
+
+// trainReceiptsDict builds a dictionary from samples of the receipts of an
+// epoch: the segments found in the most samples, the most common last since
+// deflate encodes the matches closer to the data in fewer bits. The tail of
+// the samples makes up the dictionary if no segment repeats.
+func trainReceiptsDict(samples [][]byte) []byte {
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		seen := make(map[string]bool)
+		for i := 0; i+receiptsDictSegment <= len(sample); i++ {
+			segment := string(sample[i : i+receiptsDictSegment])
+			if !seen[segment] {
+				seen[segment] = true
+				counts[segment]++
+			}
+		}
+	}
+	var segments []string
+	for segment, n := range counts {
+		if n > 1 {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		dict := bytes.Join(samples, nil)
+		if len(dict) > maxReceiptsDictSize {
+			dict = dict[len(dict)-maxReceiptsDictSize:]
+		}
+		return common.CopyBytes(dict)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		if counts[segments[i]] != counts[segments[j]] {
+			return counts[segments[i]] > counts[segments[j]]
+		}
+		return segments[i] < segments[j]
+	})
+	var (
+		picked []string
+		size   int
+		joined []byte
+	)
+	for _, segment := range segments {
+		if size+len(segment) > maxReceiptsDictSize {
+			break
+		}
+		// overlapping segments of a common run are picked once
+		if bytes.Contains(joined, []byte(segment)) {
+			continue
+		}
+		picked = append(picked, segment)
+		joined = append(joined, segment...)
+		size += len(segment)
+	}
+	dict := make([]byte, 0, size)
+	for i := len(picked) - 1; i >= 0; i-- {
+		dict = append(dict, picked[i]...)
+	}
+	return dict
+}
+
+// CompactReceiptsEpoch rewrites the receipts of the blocks of an epoch as
+// bundles deflated with the dictionary of the epoch, which is trained on the
+// receipts of the epoch and stored first unless the epoch already has one.
+// Bundles already using the dictionary are left alone, so blocks written to
+// the epoch later are compacted by running it again. It returns the number of
+// rewritten blocks and the bytes saved.
+func CompactReceiptsEpoch(db fdb.Database, epoch uint64) (int, uint64, error) {
+	start, end := epoch*ReceiptsEpochLength, (epoch+1)*ReceiptsEpochLength
+	each := func(fn func(key, data []byte) error) error {
+		it := db.NewIteratorWithStart(blockReceiptsPrefix, encodeBlockNumber(start))
+		defer it.Release()
+		for it.Next() {
+			key := it.Key()
+			if len(key) != len(blockReceiptsPrefix)+8+common.HashLength {
+				continue
+			}
+			if binary.BigEndian.Uint64(key[len(blockReceiptsPrefix):]) >= end {
+				break
+			}
+			if err := fn(key, it.Value()); err != nil {
+				return err
+			}
+		}
+		return it.Error()
+	}
+
+	dict := ReadReceiptsDict(db, epoch)
+	if len(dict) == 0 {
+		var (
+			samples [][]byte
+			size    int
+		)
+		err := each(func(key, data []byte) error {
+			if size >= maxReceiptsDictSamples {
+				return nil
+			}
+			data, err := decodeReceiptsBundle(db, data)
+			if err != nil {
+				return err
+			}
+			samples = append(samples, data)
+			size += len(data)
+			return nil
+		})
+		if err != nil || len(samples) == 0 {
+			return 0, 0, err
+		}
+		dict = trainReceiptsDict(samples)
+		if len(dict) == 0 {
+			return 0, 0, nil
+		}
+		WriteReceiptsDict(db, epoch, dict)
+	}
+
+	var (
+		batch     = db.NewBatch()
+		compacted int
+		saved     uint64
+	)
+	err := each(func(key, data []byte) error {
+		if len(data) == 0 || data[0] == receiptsDeflatePrefix {
+			return nil
+		}
+		raw, err := decodeReceiptsBundle(db, data)
+		if err != nil {
+			return err
+		}
+		bundle, err := encodeEpochReceiptsBundle(epoch, dict, raw)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(common.CopyBytes(key), bundle); err != nil {
+			return err
+		}
+		compacted++
+		if len(bundle) < len(data) {
+			saved += uint64(len(data) - len(bundle))
+		}
+		if batch.ValueSize() >= fdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		return nil
+	})
+	if err != nil {
+		return compacted, saved, err
+	}
+	return compacted, saved, batch.Write()
+}
+
+// CompactReceipts compacts the receipts of every epoch with stored receipts,
+// the legacy uncompressed layout included, see CompactReceiptsEpoch. An
+// interrupted compaction continues where it stopped when run again. The
+// report callback is invoked after each epoch with the number of compacted
+// epochs and blocks and the bytes saved so far.
+func CompactReceipts(db fdb.Database, report func(epochs, compacted int, saved uint64)) error {
+	var (
+		epochs    int
+		compacted int
+		saved     uint64
+	)
+	for epoch := uint64(0); ; epoch++ {
+		// skip to the next epoch with receipts
+		it := db.NewIteratorWithStart(blockReceiptsPrefix, encodeBlockNumber(epoch*ReceiptsEpochLength))
+		found := false
+		for it.Next() {
+			if key := it.Key(); len(key) == len(blockReceiptsPrefix)+8+common.HashLength {
+				epoch = binary.BigEndian.Uint64(key[len(blockReceiptsPrefix):]) / ReceiptsEpochLength
+				found = true
+				break
+			}
+		}
+		err := it.Error()
+		it.Release()
+		if err != nil {
+			return err
+		}
+		if !found {
+			return nil
+		}
+		n, s, err := CompactReceiptsEpoch(db, epoch)
+		if err != nil {
+			return err
+		}
+		epochs++
+		compacted += n
+		saved += s
+		report(epochs, compacted, saved)
+		if epoch == math.MaxUint64/ReceiptsEpochLength {
+			return nil
+		}
+	}
+}
+
+// ReadInternalTxs retrieves the internal transactions recorded while
+// executing a block.
+func ReadInternalTxs(db DatabaseReader, hash common.Hash, number uint64) []*types.InternalTx {
+	data, _ := db.Get(internalTxsKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	itxs := []*types.InternalTx{}
+	if err := rlp.DecodeBytes(data, &itxs); err != nil {
+		log.Error("Invalid internal transaction array RLP", "hash", hash, "err", err)
+		return nil
+	}
+	for _, itx := range itxs {
+		itx.BlockNumber = number
+		itx.BlockHash = hash
+	}
+	return itxs
+}
+
+// WriteInternalTxs stores the internal transactions recorded while executing
+// a block.
+func WriteInternalTxs(db DatabaseWriter, hash common.Hash, number uint64, itxs []*types.InternalTx) {
+	bytes, err := rlp.EncodeToBytes(itxs)
+	if err != nil {
+		log.Crit("Failed to encode block internal transactions", "err", err)
+	}
+	if err := db.Put(internalTxsKey(number, hash), bytes); err != nil {
+		log.Crit("Failed to store block internal transactions", "err", err)
+	}
+}
+
+// DeleteInternalTxs removes the internal transactions of a block.
+func DeleteInternalTxs(db DatabaseDeleter, hash common.Hash, number uint64) {
+	if err := db.Delete(internalTxsKey(number, hash)); err != nil {
+		log.Crit("Failed to delete block internal transactions", "err", err)
+	}
+}
+
 // FindCommonAncestor returns the last common ancestor of two block headers
 func FindCommonAncestor(db DatabaseReader, a, b *types.Header) *types.Header {
 	for bn := b.Number.Uint64(); a.Number.Uint64() > bn; {
@@ -387,6 +731,32 @@ func DeleteBlockStateOut(db DatabaseDeleter, hash common.Hash) {
 	}
 }
 
+// ForEachBlockStateOut calls fn with the state changes of each block in block
+// hash order, starting after the block with hash start, until fn returns false.
+func ForEachBlockStateOut(db fdb.Iteratee, start common.Hash, fn func(hash common.Hash, stateOut *types.StateOut) bool) error {
+	it := db.NewIteratorWithPrefix(blockStateOutPrefix)
+	defer it.Release()
+
+	startKey := blockStateOutKey(start)
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(blockStateOutPrefix)+common.HashLength {
+			continue
+		}
+		if start != (common.Hash{}) && bytes.Compare(key, startKey) <= 0 {
+			continue
+		}
+		stateOut := new(types.StateOut)
+		if err := rlp.DecodeBytes(it.Value(), stateOut); err != nil {
+			return fmt.Errorf("invalid block state RLP %x: %v", key, err)
+		}
+		if !fn(common.BytesToHash(key[len(blockStateOutPrefix):]), stateOut) {
+			break
+		}
+	}
+	return it.Error()
+}
+
 func WriteOptBlockHash(db DatabaseWriter, hash common.Hash) {
 	if err := db.Put(blockOptHash, hash.Bytes()); err != nil {
 		log.Crit("Failed to store last opt block's hash", "err", err)
@@ -400,3 +770,151 @@ func ReadOptBlockHash(db DatabaseReader) common.Hash {
 	}
 	return common.BytesToHash(data)
 }
+
+// WriteStateCommitMarker records that the state is being moved to the block
+// with the given hash. The marker is written ahead of the commit and removed
+// with it, so a marker left behind means the commit was interrupted.
+func WriteStateCommitMarker(db DatabaseWriter, hash common.Hash) {
+	if err := db.Put(stateCommitKey, hash.Bytes()); err != nil {
+		log.Crit("Failed to store state commit marker", "err", err)
+	}
+}
+
+// ReadStateCommitMarker retrieves the hash of the block of an interrupted
+// state commit, or the zero hash if there is none.
+func ReadStateCommitMarker(db DatabaseReader) common.Hash {
+	data, _ := db.Get(stateCommitKey)
+	if len(data) == 0 {
+		data, _ = db.Get(legacyStateCommitKey)
+	}
+	if len(data) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// DeleteStateCommitMarker removes the state commit marker.
+func DeleteStateCommitMarker(db DatabaseDeleter) {
+	for _, key := range [][]byte{stateCommitKey, legacyStateCommitKey} {
+		if err := db.Delete(key); err != nil {
+			log.Crit("Failed to delete state commit marker", "err", err)
+		}
+	}
+}
+
+// ReadChainIntent retrieves the interrupted chain mutation, or nil if there
+// is none.
+func ReadChainIntent(db DatabaseReader) *ChainIntent {
+	data, _ := db.Get(chainIntentKey)
+	if len(data) == 0 {
+		return nil
+	}
+	intent := new(ChainIntent)
+	if err := rlp.DecodeBytes(data, intent); err != nil {
+		log.Error("Invalid chain intent RLP", "err", err)
+		return nil
+	}
+	return intent
+}
+
+// WriteChainIntent records the chain mutation about to be written.
+func WriteChainIntent(db DatabaseWriter, intent *ChainIntent) {
+	data, err := rlp.EncodeToBytes(intent)
+	if err != nil {
+		log.Crit("Failed to encode chain intent", "err", err)
+	}
+	if err := db.Put(chainIntentKey, data); err != nil {
+		log.Crit("Failed to store chain intent", "err", err)
+	}
+}
+
+// DeleteChainIntent removes the intent of a complete chain mutation.
+func DeleteChainIntent(db DatabaseDeleter) {
+	if err := db.Delete(chainIntentKey); err != nil {
+		log.Crit("Failed to delete chain intent", "err", err)
+	}
+}
+
+// ReadStatePruneProgress retrieves the progress of an interrupted state
+// pruning, or nil if there is none.
+func ReadStatePruneProgress(db DatabaseReader) *StatePruneProgress {
+	data, _ := db.Get(statePruneKey)
+	if len(data) == 0 {
+		return nil
+	}
+	progress := new(StatePruneProgress)
+	if err := rlp.DecodeBytes(data, progress); err != nil {
+		log.Error("Invalid state prune progress RLP", "err", err)
+		return nil
+	}
+	return progress
+}
+
+// WriteStatePruneProgress stores the progress of a state pruning.
+func WriteStatePruneProgress(db DatabaseWriter, progress *StatePruneProgress) {
+	data, err := rlp.EncodeToBytes(progress)
+	if err != nil {
+		log.Crit("Failed to encode state prune progress", "err", err)
+	}
+	if err := db.Put(statePruneKey, data); err != nil {
+		log.Crit("Failed to store state prune progress", "err", err)
+	}
+}
+
+// DeleteStatePruneProgress removes the progress of a finished state pruning.
+func DeleteStatePruneProgress(db DatabaseDeleter) {
+	if err := db.Delete(statePruneKey); err != nil {
+		log.Crit("Failed to delete state prune progress", "err", err)
+	}
+}
+
+// ReadCheckpoint retrieves the latest checkpoint signed by a quorum of the
+// producers, or nil if there is none.
+func ReadCheckpoint(db DatabaseReader) *types.SignedCheckpoint {
+	data, _ := db.Get(checkpointKey)
+	if len(data) == 0 {
+		return nil
+	}
+	checkpoint := new(types.SignedCheckpoint)
+	if err := rlp.DecodeBytes(data, checkpoint); err != nil {
+		log.Error("Invalid checkpoint RLP", "err", err)
+		return nil
+	}
+	return checkpoint
+}
+
+// WriteCheckpoint stores the latest checkpoint signed by a quorum of the
+// producers, keeping the previous ones for the remotes verifying the chain of
+// checkpoints.
+func WriteCheckpoint(db DatabaseWriter, checkpoint *types.SignedCheckpoint) {
+	data, err := rlp.EncodeToBytes(checkpoint)
+	if err != nil {
+		log.Crit("Failed to encode checkpoint", "err", err)
+	}
+	if err := db.Put(append(append([]byte{}, checkpointPrefix...), encodeBlockNumber(checkpoint.Checkpoint.Number)...), data); err != nil {
+		log.Crit("Failed to store checkpoint", "err", err)
+	}
+	if err := db.Put(checkpointKey, data); err != nil {
+		log.Crit("Failed to store checkpoint", "err", err)
+	}
+}
+
+// ReadCheckpointAfter retrieves the lowest signed checkpoint above the block
+// number, or nil if there is none.
+func ReadCheckpointAfter(db fdb.Iteratee, number uint64) *types.SignedCheckpoint {
+	it := db.NewIteratorWithStart(checkpointPrefix, encodeBlockNumber(number+1))
+	defer it.Release()
+
+	for it.Next() {
+		if len(it.Key()) != len(checkpointPrefix)+8 {
+			continue
+		}
+		checkpoint := new(types.SignedCheckpoint)
+		if err := rlp.DecodeBytes(it.Value(), checkpoint); err != nil {
+			log.Error("Invalid checkpoint RLP", "err", err)
+			return nil
+		}
+		return checkpoint
+	}
+	return nil
+}
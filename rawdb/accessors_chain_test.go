@@ -215,6 +215,72 @@ func TestCanonicalMappingStorage(t *testing.T) {
 	}
 }
 
+// Tests that the hashes of all the headers at a number are retrieved.
+func TestAllHashesStorage(t *testing.T) {
+	db := fdb.NewMemDatabase()
+
+	canon := &types.Header{Number: big.NewInt(7), Extra: []byte("canonical")}
+	side := &types.Header{Number: big.NewInt(7), Extra: []byte("side")}
+	next := &types.Header{Number: big.NewInt(8), Extra: []byte("next")}
+	for _, header := range []*types.Header{canon, side, next} {
+		WriteHeader(db, header)
+	}
+	WriteCanonicalHash(db, canon.Hash(), 7)
+	WriteTd(db, canon.Hash(), 7, big.NewInt(1))
+
+	hashes := ReadAllHashes(db, 7)
+	if len(hashes) != 2 {
+		t.Fatalf("hashes mismatch: have %d, want 2", len(hashes))
+	}
+	for _, header := range []*types.Header{canon, side} {
+		if hashes[0] != header.Hash() && hashes[1] != header.Hash() {
+			t.Fatalf("hash %x not found", header.Hash())
+		}
+	}
+	if hashes := ReadAllHashes(db, 9); len(hashes) != 0 {
+		t.Fatalf("hashes of an empty number: have %d, want 0", len(hashes))
+	}
+}
+
+// Tests that the state commit marker is found under the legacy key and isn't
+// iterated as the state changes of a block.
+func TestStateCommitMarker(t *testing.T) {
+	if bytes.HasPrefix(stateCommitKey, blockStateOutPrefix) {
+		t.Fatal("state commit marker key starts with the block state prefix")
+	}
+	db := fdb.NewMemDatabase()
+	hash := common.Hash{1}
+	WriteBlockStateOut(db, common.Hash{2}, new(types.StateOut))
+	WriteStateCommitMarker(db, hash)
+	if marker := ReadStateCommitMarker(db); marker != hash {
+		t.Fatalf("marker mismatch: have %x, want %x", marker, hash)
+	}
+	count := 0
+	if err := ForEachBlockStateOut(db, common.Hash{}, func(common.Hash, *types.StateOut) bool {
+		count++
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("block states mismatch: have %d, want 1", count)
+	}
+	DeleteStateCommitMarker(db)
+	if marker := ReadStateCommitMarker(db); marker != (common.Hash{}) {
+		t.Fatalf("marker not deleted: %x", marker)
+	}
+
+	// a commit interrupted under the legacy key is recovered
+	db.Put(legacyStateCommitKey, hash.Bytes())
+	if marker := ReadStateCommitMarker(db); marker != hash {
+		t.Fatalf("legacy marker mismatch: have %x, want %x", marker, hash)
+	}
+	DeleteStateCommitMarker(db)
+	if marker := ReadStateCommitMarker(db); marker != (common.Hash{}) {
+		t.Fatalf("legacy marker not deleted: %x", marker)
+	}
+}
+
 // Tests that head headers and head blocks can be assigned, individually.
 func TestHeadStorage(t *testing.T) {
 	db := fdb.NewMemDatabase()
@@ -306,3 +372,118 @@ func TestBlockReceiptStorage(t *testing.T) {
 		t.Fatalf("deleted receipts returned: %v", rs)
 	}
 }
+
+// Tests that receipts stored in the legacy uncompressed layout stay readable
+// and are rewritten, like the bundles compressed on their own, as bundles
+// deflated with the dictionary of their epoch by the compaction.
+func TestCompactReceipts(t *testing.T) {
+	db := fdb.NewMemDatabase()
+
+	receipts := []*types.Receipt{{
+		ActionResults:     []*types.ActionResult{{Status: types.ReceiptStatusSuccessful, GasUsed: 100}},
+		CumulativeGasUsed: 100,
+		Logs: []*types.Log{
+			{Name: common.StrToName("33333333"), Topics: []common.Hash{{3}}},
+			{Name: common.StrToName("33333333"), Topics: []common.Hash{{3}}},
+			{Name: common.StrToName("33333333"), Topics: []common.Hash{{3}}},
+		},
+		TxHash:       common.BytesToHash([]byte{0x33, 0x33}),
+		TotalGasUsed: 100,
+		Fee:          big.NewInt(0),
+	}}
+	legacy, err := rlp.EncodeToBytes(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := common.BytesToHash([]byte{0x03, 0x15})
+	db.Put(blockReceiptsKey(1, hash), legacy)
+	WriteReceipts(db, common.Hash{0x01}, 2, receipts)
+
+	if rs := ReadReceipts(db, hash, 1); len(rs) != 1 || rs[0].TxHash != receipts[0].TxHash {
+		t.Fatalf("legacy receipts mismatch: have %v", rs)
+	}
+	// a block of the next epoch gets a dictionary of its own
+	WriteReceipts(db, common.Hash{0x02}, ReceiptsEpochLength, receipts)
+
+	var epochs, compacted int
+	if err := CompactReceipts(db, func(e, n int, saved uint64) { epochs, compacted = e, n }); err != nil {
+		t.Fatalf("failed to compact receipts: %v", err)
+	}
+	if epochs != 2 || compacted != 3 {
+		t.Fatalf("compaction count mismatch: have %d/%d, want 2/3", epochs, compacted)
+	}
+	for epoch := uint64(0); epoch < 2; epoch++ {
+		if len(ReadReceiptsDict(db, epoch)) == 0 {
+			t.Fatalf("dictionary of epoch %d missing", epoch)
+		}
+	}
+	data, _ := db.Get(blockReceiptsKey(1, hash))
+	if len(data) >= len(legacy) || data[0] != receiptsDeflatePrefix {
+		t.Fatalf("receipts not compacted: have %d bytes, legacy %d", len(data), len(legacy))
+	}
+	for _, block := range []struct {
+		hash   common.Hash
+		number uint64
+	}{{hash, 1}, {common.Hash{0x01}, 2}, {common.Hash{0x02}, ReceiptsEpochLength}} {
+		rs := ReadReceipts(db, block.hash, block.number)
+		if len(rs) != 1 {
+			t.Fatalf("compacted receipts of block %d missing", block.number)
+		}
+		if have, _ := rlp.EncodeToBytes(rs); !bytes.Equal(have, legacy) {
+			t.Fatalf("compacted receipts of block %d mismatch", block.number)
+		}
+	}
+
+	// Blocks written to a compacted epoch are compacted with its dictionary
+	// by the next run, the compacted ones are left alone.
+	dict := ReadReceiptsDict(db, 0)
+	WriteReceipts(db, common.Hash{0x03}, 3, receipts)
+	if n, _, err := CompactReceiptsEpoch(db, 0); err != nil || n != 1 {
+		t.Fatalf("recompaction mismatch: have %d blocks, err %v, want 1", n, err)
+	}
+	if !bytes.Equal(ReadReceiptsDict(db, 0), dict) {
+		t.Fatal("dictionary of a compacted epoch replaced")
+	}
+	if rs := ReadReceipts(db, common.Hash{0x03}, 3); len(rs) != 1 || rs[0].TxHash != receipts[0].TxHash {
+		t.Fatalf("receipts compacted later mismatch: have %v", rs)
+	}
+}
+
+// Tests that the dictionary of an epoch shrinks the bundles of its blocks
+// below the ones compressed on their own.
+func TestReceiptsDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 16; i++ {
+		receipts := []*types.Receipt{{
+			ActionResults:     []*types.ActionResult{{Status: types.ReceiptStatusSuccessful, GasUsed: uint64(21000 + i)}},
+			CumulativeGasUsed: uint64(21000 + i),
+			Logs: []*types.Log{
+				{Name: common.StrToName("dicttoken"), Topics: []common.Hash{{0xdd, 0x01}, common.BigToHash(big.NewInt(int64(i)))}, Data: []byte("transfer of the dictionary token")},
+			},
+			TxHash:       common.BytesToHash([]byte{byte(i), 0x44}),
+			TotalGasUsed: uint64(21000 + i),
+			Fee:          big.NewInt(0),
+		}}
+		data, err := rlp.EncodeToBytes(receipts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, data)
+	}
+	dict := trainReceiptsDict(samples)
+	if len(dict) == 0 || len(dict) > maxReceiptsDictSize {
+		t.Fatalf("dictionary size %d out of range", len(dict))
+	}
+	var alone, shared int
+	for _, data := range samples {
+		bundle, err := encodeEpochReceiptsBundle(0, dict, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alone += len(encodeReceiptsBundle(data))
+		shared += len(bundle)
+	}
+	if shared >= alone {
+		t.Fatalf("dictionary bundles not smaller: have %d bytes, %d without dictionary", shared, alone)
+	}
+}
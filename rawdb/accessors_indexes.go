@@ -17,9 +17,12 @@
 package rawdb
 
 import (
-	"github.com/ethereum/go-ethereum/log"
+	"encoding/binary"
+
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
 	"github.com/fractalplatform/fractal/utils/rlp"
 )
 
@@ -105,3 +108,253 @@ func WriteBloomBits(db DatabaseWriter, bit uint, section uint64, head common.Has
 		log.Crit("Failed to store bloom bits", "err", err)
 	}
 }
+
+// ReadInternalTxIndex retrieves the ascending numbers of the blocks with
+// internal transactions sent or received by an account. The blocks aren't
+// necessarily canonical.
+func ReadInternalTxIndex(db DatabaseReader, name common.Name) []uint64 {
+	data, _ := db.Get(internalTxIndexKey(name))
+	if len(data) == 0 {
+		return nil
+	}
+	var numbers []uint64
+	if err := rlp.DecodeBytes(data, &numbers); err != nil {
+		log.Error("Invalid internal transaction index RLP", "name", name, "err", err)
+		return nil
+	}
+	return numbers
+}
+
+// WriteInternalTxIndex stores the block numbers of the internal transactions
+// of an account.
+func WriteInternalTxIndex(db DatabaseWriter, name common.Name, numbers []uint64) {
+	data, err := rlp.EncodeToBytes(numbers)
+	if err != nil {
+		log.Crit("Failed to encode internal transaction index", "err", err)
+	}
+	if err := db.Put(internalTxIndexKey(name), data); err != nil {
+		log.Crit("Failed to store internal transaction index", "err", err)
+	}
+}
+
+// ReadAssetStats retrieves the statistics of an asset, nil if there are none.
+func ReadAssetStats(db DatabaseReader, assetID uint64) *types.AssetStats {
+	data, _ := db.Get(assetStatsKey(assetID))
+	if len(data) == 0 {
+		return nil
+	}
+	stats := new(types.AssetStats)
+	if err := rlp.DecodeBytes(data, stats); err != nil {
+		log.Error("Invalid asset statistics RLP", "asset", assetID, "err", err)
+		return nil
+	}
+	return stats
+}
+
+// WriteAssetStats stores the statistics of an asset.
+func WriteAssetStats(db DatabaseWriter, stats *types.AssetStats) {
+	data, err := rlp.EncodeToBytes(stats)
+	if err != nil {
+		log.Crit("Failed to encode asset statistics", "err", err)
+	}
+	if err := db.Put(assetStatsKey(stats.AssetID), data); err != nil {
+		log.Crit("Failed to store asset statistics", "err", err)
+	}
+}
+
+// ReadAssetStatsDeltas retrieves the changes a block made to the statistics of
+// the assets.
+func ReadAssetStatsDeltas(db DatabaseReader, hash common.Hash, number uint64) []*types.AssetStatsDelta {
+	data, _ := db.Get(assetStatsDeltasKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	var deltas []*types.AssetStatsDelta
+	if err := rlp.DecodeBytes(data, &deltas); err != nil {
+		log.Error("Invalid asset statistics deltas RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return deltas
+}
+
+// WriteAssetStatsDeltas stores the changes a block made to the statistics of
+// the assets.
+func WriteAssetStatsDeltas(db DatabaseWriter, hash common.Hash, number uint64, deltas []*types.AssetStatsDelta) {
+	data, err := rlp.EncodeToBytes(deltas)
+	if err != nil {
+		log.Crit("Failed to encode asset statistics deltas", "err", err)
+	}
+	if err := db.Put(assetStatsDeltasKey(number, hash), data); err != nil {
+		log.Crit("Failed to store asset statistics deltas", "err", err)
+	}
+}
+
+// ReadAssetStatsHead retrieves the hash of the block the asset statistics are
+// at.
+func ReadAssetStatsHead(db DatabaseReader) common.Hash {
+	data, _ := db.Get(assetStatsHeadKey)
+	if len(data) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// WriteAssetStatsHead stores the hash of the block the asset statistics are at.
+func WriteAssetStatsHead(db DatabaseWriter, hash common.Hash) {
+	if err := db.Put(assetStatsHeadKey, hash.Bytes()); err != nil {
+		log.Crit("Failed to store asset statistics head", "err", err)
+	}
+}
+
+// TxSearchField selects the actions of the transaction search index by their
+// sender, recipient, either of them, asset or type.
+type TxSearchField []byte
+
+// TxSearchBySender selects the actions sent by an account.
+func TxSearchBySender(name common.Name) TxSearchField {
+	return txSearchNameField('s', name)
+}
+
+// TxSearchByRecipient selects the actions received by an account.
+func TxSearchByRecipient(name common.Name) TxSearchField {
+	return txSearchNameField('r', name)
+}
+
+// TxSearchByAccount selects the actions sent or received by an account.
+func TxSearchByAccount(name common.Name) TxSearchField {
+	return txSearchNameField('c', name)
+}
+
+// TxSearchByAsset selects the actions of an asset.
+func TxSearchByAsset(assetID uint64) TxSearchField {
+	return append(TxSearchField{'a'}, encodeBlockNumber(assetID)...)
+}
+
+// TxSearchByActionType selects the actions of a type.
+func TxSearchByActionType(actionType types.ActionType) TxSearchField {
+	return append(TxSearchField{'t'}, encodeBlockNumber(uint64(actionType))...)
+}
+
+// txSearchNameField prefixes the name with its length, so that no field is
+// the prefix of another.
+func txSearchNameField(kind byte, name common.Name) TxSearchField {
+	return append(TxSearchField{kind, byte(len(name))}, name...)
+}
+
+// txSearchFields returns the fields an action is indexed by.
+func txSearchFields(action *types.Action) []TxSearchField {
+	fields := []TxSearchField{
+		TxSearchBySender(action.Sender()),
+		TxSearchByRecipient(action.Recipient()),
+		TxSearchByAccount(action.Sender()),
+		TxSearchByAsset(action.AssetID()),
+		TxSearchByActionType(action.Type()),
+	}
+	if action.Recipient() != action.Sender() {
+		fields = append(fields, TxSearchByAccount(action.Recipient()))
+	}
+	return fields
+}
+
+// TxSearchEntry locates an action found in the transaction search index.
+type TxSearchEntry struct {
+	BlockHash   common.Hash
+	BlockNumber uint64
+	TxHash      common.Hash
+	TxIndex     uint32
+	ActionIndex uint32
+}
+
+// WriteTxSearchEntries adds the actions of a block to the transaction search
+// index.
+func WriteTxSearchEntries(db DatabaseWriter, block *types.Block) {
+	hash, number := block.Hash(), block.NumberU64()
+	for i, tx := range block.Txs {
+		value := append(hash.Bytes(), tx.Hash().Bytes()...)
+		for j, action := range tx.GetActions() {
+			for _, field := range txSearchFields(action) {
+				if err := db.Put(txSearchKey(field, number, uint32(i), uint32(j)), value); err != nil {
+					log.Crit("Failed to store transaction search entry", "err", err)
+				}
+			}
+		}
+	}
+}
+
+// DeleteTxSearchEntries removes the actions of a block from the transaction
+// search index.
+func DeleteTxSearchEntries(db DatabaseDeleter, block *types.Block) {
+	number := block.NumberU64()
+	for i, tx := range block.Txs {
+		for j, action := range tx.GetActions() {
+			for _, field := range txSearchFields(action) {
+				if err := db.Delete(txSearchKey(field, number, uint32(i), uint32(j))); err != nil {
+					log.Crit("Failed to delete transaction search entry", "err", err)
+				}
+			}
+		}
+	}
+}
+
+// ReadTxSearchEntries returns the actions selected by field in the blocks from
+// from to to inclusive, in block order. Once limit entries are read it stops at
+// the next block and returns its number to continue from, 0 if the range is
+// done. A limit of 0 reads the whole range.
+func ReadTxSearchEntries(db fdb.Iteratee, field TxSearchField, from, to uint64, limit int) ([]*TxSearchEntry, uint64, error) {
+	prefix := append(append([]byte{}, txSearchPrefix...), field...)
+	it := db.NewIteratorWithStart(prefix, encodeBlockNumber(from))
+	defer it.Release()
+
+	var entries []*TxSearchEntry
+	for it.Next() {
+		key, value := it.Key(), it.Value()
+		if len(key) != len(prefix)+16 || len(value) != 2*common.HashLength {
+			continue
+		}
+		number := binary.BigEndian.Uint64(key[len(prefix):])
+		if number < from {
+			continue
+		}
+		if number > to {
+			break
+		}
+		if limit > 0 && len(entries) >= limit && entries[len(entries)-1].BlockNumber != number {
+			return entries, number, it.Error()
+		}
+		entries = append(entries, &TxSearchEntry{
+			BlockHash:   common.BytesToHash(value[:common.HashLength]),
+			BlockNumber: number,
+			TxHash:      common.BytesToHash(value[common.HashLength:]),
+			TxIndex:     binary.BigEndian.Uint32(key[len(prefix)+8:]),
+			ActionIndex: binary.BigEndian.Uint32(key[len(prefix)+12:]),
+		})
+	}
+	return entries, 0, it.Error()
+}
+
+// ReadTxSearchTail retrieves the number of the first block of the transaction
+// search index, nil if the index is disabled.
+func ReadTxSearchTail(db DatabaseReader) *uint64 {
+	data, _ := db.Get(txSearchTailKey)
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+// WriteTxSearchTail stores the number of the first block of the transaction
+// search index.
+func WriteTxSearchTail(db DatabaseWriter, number uint64) {
+	if err := db.Put(txSearchTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store transaction search tail", "err", err)
+	}
+}
+
+// DeleteTxSearchTail removes the first block of the transaction search index
+// once it is disabled.
+func DeleteTxSearchTail(db DatabaseDeleter) {
+	if err := db.Delete(txSearchTailKey); err != nil {
+		log.Crit("Failed to delete transaction search tail", "err", err)
+	}
+}
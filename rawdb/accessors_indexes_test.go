@@ -17,6 +17,7 @@
 package rawdb
 
 import (
+	"fmt"
 	"math/big"
 	"testing"
 
@@ -74,3 +75,149 @@ func TestLookupStorage(t *testing.T) {
 		}
 	}
 }
+
+// Tests that internal transactions and their account index can be stored and
+// retrieved.
+func TestInternalTxStorage(t *testing.T) {
+	db := fdb.NewMemDatabase()
+
+	hash := common.BytesToHash([]byte{0x01, 0x02})
+	itxs := []*types.InternalTx{
+		{Type: "CALL", From: "contract", To: "callee", Value: big.NewInt(10), Gas: 5000, GasUsed: 700, Depth: 1, TxHash: common.BytesToHash([]byte{0x11})},
+		{Type: "TRANSFER", From: "callee", To: "payee", AssetID: 1, Value: big.NewInt(3), Depth: 2, Error: "failed", Index: 1},
+	}
+	if have := ReadInternalTxs(db, hash, 0); have != nil {
+		t.Fatalf("non existent internal transactions returned: %v", have)
+	}
+	WriteInternalTxs(db, hash, 0, itxs)
+	have := ReadInternalTxs(db, hash, 0)
+	if len(have) != len(itxs) {
+		t.Fatalf("internal transaction count mismatch: have %d, want %d", len(have), len(itxs))
+	}
+	for i, itx := range have {
+		want := *itxs[i]
+		want.BlockHash = hash
+		if itx.Value.Cmp(want.Value) != 0 {
+			t.Fatalf("itx #%d: value mismatch: have %v, want %v", i, itx.Value, want.Value)
+		}
+		itx.Value, want.Value = nil, nil
+		if *itx != want {
+			t.Fatalf("itx #%d: mismatch: have %+v, want %+v", i, itx, want)
+		}
+	}
+	DeleteInternalTxs(db, hash, 0)
+	if have := ReadInternalTxs(db, hash, 0); have != nil {
+		t.Fatalf("deleted internal transactions returned: %v", have)
+	}
+
+	if numbers := ReadInternalTxIndex(db, "contract"); numbers != nil {
+		t.Fatalf("non existent internal transaction index returned: %v", numbers)
+	}
+	WriteInternalTxIndex(db, "contract", []uint64{3, 7})
+	if numbers := ReadInternalTxIndex(db, "contract"); len(numbers) != 2 || numbers[0] != 3 || numbers[1] != 7 {
+		t.Fatalf("internal transaction index mismatch: have %v, want [3 7]", numbers)
+	}
+}
+
+func TestAssetStatsStorage(t *testing.T) {
+	db := fdb.NewMemDatabase()
+
+	if stats := ReadAssetStats(db, 1); stats != nil {
+		t.Fatalf("non existent asset statistics returned: %v", stats)
+	}
+	stats := types.NewAssetStats(1)
+	stats.Apply(&types.AssetStatsDelta{AssetID: 1, SupplyAdded: big.NewInt(100), SupplyRemoved: big.NewInt(0), HoldersAdded: 2, Transfers: 1, Volume: big.NewInt(40)}, 10)
+	WriteAssetStats(db, stats)
+	have := ReadAssetStats(db, 1)
+	if have == nil || have.Supply.Int64() != 100 || have.Holders != 2 || have.Transfers != 1 || len(have.Hourly) != 1 || have.Hourly[0].Volume.Int64() != 40 {
+		t.Fatalf("asset statistics mismatch: have %+v", have)
+	}
+
+	hash := common.BytesToHash([]byte{0x03})
+	if deltas := ReadAssetStatsDeltas(db, hash, 5); deltas != nil {
+		t.Fatalf("non existent asset statistics deltas returned: %v", deltas)
+	}
+	WriteAssetStatsDeltas(db, hash, 5, []*types.AssetStatsDelta{types.NewAssetStatsDelta(1), types.NewAssetStatsDelta(2)})
+	if deltas := ReadAssetStatsDeltas(db, hash, 5); len(deltas) != 2 || deltas[1].AssetID != 2 {
+		t.Fatalf("asset statistics deltas mismatch: have %v", deltas)
+	}
+
+	if head := ReadAssetStatsHead(db); head != (common.Hash{}) {
+		t.Fatalf("non existent asset statistics head returned: %x", head)
+	}
+	WriteAssetStatsHead(db, hash)
+	if head := ReadAssetStatsHead(db); head != hash {
+		t.Fatalf("asset statistics head mismatch: have %x, want %x", head, hash)
+	}
+}
+
+func TestTxSearchStorage(t *testing.T) {
+	db := fdb.NewMemDatabase()
+
+	newBlock := func(number int64, actions ...*types.Action) *types.Block {
+		var txs []*types.Transaction
+		for _, action := range actions {
+			txs = append(txs, types.NewTransaction(0, big.NewInt(1), action))
+		}
+		return &types.Block{Head: &types.Header{Number: big.NewInt(number)}, Txs: txs}
+	}
+	blocks := []*types.Block{
+		newBlock(3, types.NewAction(types.Transfer, "alice", "bob", 0, 1, 0, big.NewInt(1), nil)),
+		newBlock(5, types.NewAction(types.Transfer, "bob", "bob", 0, 2, 0, big.NewInt(1), nil), types.NewAction(types.Transfer, "alicebob", "bob", 0, 1, 0, big.NewInt(1), nil)),
+		newBlock(8, types.NewAction(types.CreateAccount, "alice", "carol", 0, 1, 0, big.NewInt(1), nil)),
+	}
+	for _, block := range blocks {
+		WriteTxSearchEntries(db, block)
+	}
+
+	tests := []struct {
+		field    TxSearchField
+		from, to uint64
+		limit    int
+		want     []uint64 // block numbers of the entries
+		next     uint64
+	}{
+		{TxSearchByAccount("alice"), 0, 10, 0, []uint64{3, 8}, 0},
+		{TxSearchBySender("alice"), 4, 10, 0, []uint64{8}, 0},
+		{TxSearchByRecipient("bob"), 0, 10, 0, []uint64{3, 5, 5}, 0},
+		{TxSearchByAccount("bob"), 0, 10, 2, []uint64{3, 5, 5}, 0},
+		{TxSearchByAccount("bob"), 0, 10, 1, []uint64{3}, 5},
+		{TxSearchByAsset(1), 0, 7, 0, []uint64{3, 5}, 0},
+		{TxSearchByAsset(1), 0, 10, 2, []uint64{3, 5}, 8},
+		{TxSearchByActionType(types.CreateAccount), 0, 10, 0, []uint64{8}, 0},
+	}
+	for i, tt := range tests {
+		entries, next, err := ReadTxSearchEntries(db, tt.field, tt.from, tt.to, tt.limit)
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		var have []uint64
+		for _, entry := range entries {
+			have = append(have, entry.BlockNumber)
+		}
+		if fmt.Sprint(have) != fmt.Sprint(tt.want) || next != tt.next {
+			t.Errorf("test %d: have %v next %d, want %v next %d", i, have, next, tt.want, tt.next)
+		}
+	}
+	entries, _, _ := ReadTxSearchEntries(db, TxSearchBySender("alicebob"), 0, 10, 0)
+	if len(entries) != 1 || entries[0].BlockHash != blocks[1].Hash() || entries[0].TxHash != blocks[1].Txs[1].Hash() || entries[0].TxIndex != 1 || entries[0].ActionIndex != 0 {
+		t.Fatalf("entry mismatch: have %+v", entries)
+	}
+
+	DeleteTxSearchEntries(db, blocks[1])
+	if entries, _, _ := ReadTxSearchEntries(db, TxSearchByAccount("bob"), 0, 10, 0); len(entries) != 1 {
+		t.Fatalf("deleted entries returned: have %d entries, want 1", len(entries))
+	}
+
+	if tail := ReadTxSearchTail(db); tail != nil {
+		t.Fatalf("non existent tail returned: %d", *tail)
+	}
+	WriteTxSearchTail(db, 3)
+	if tail := ReadTxSearchTail(db); tail == nil || *tail != 3 {
+		t.Fatalf("tail mismatch: have %v, want 3", tail)
+	}
+	DeleteTxSearchTail(db)
+	if tail := ReadTxSearchTail(db); tail != nil {
+		t.Fatalf("deleted tail returned: %d", *tail)
+	}
+}
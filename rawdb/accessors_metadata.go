@@ -19,8 +19,8 @@ package rawdb
 import (
 	"encoding/json"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/utils/rlp"
 )
@@ -43,6 +43,20 @@ func WriteDatabaseVersion(db DatabaseWriter, version int) {
 	}
 }
 
+// ReadHeaderOnly reports whether the database stores the headers and total
+// difficulties of the blocks only.
+func ReadHeaderOnly(db DatabaseReader) bool {
+	has, _ := db.Has(headerOnlyKey)
+	return has
+}
+
+// WriteHeaderOnly marks the database as storing the headers of the blocks only.
+func WriteHeaderOnly(db DatabaseWriter) {
+	if err := db.Put(headerOnlyKey, []byte{1}); err != nil {
+		log.Crit("Failed to store the header-only mark", "err", err)
+	}
+}
+
 // ReadChainConfig retrieves the consensus settings based on the given genesis hash.
 func ReadChainConfig(db DatabaseReader, hash common.Hash) *params.ChainConfig {
 	data, _ := db.Get(configKey(hash))
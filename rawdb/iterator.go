@@ -0,0 +1,119 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"fmt"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// iteratorPrefetch is the number of blocks read ahead of the block being
+// iterated.
+const iteratorPrefetch = 256
+
+// iteratedBlock is a block read ahead by an iteration.
+type iteratedBlock struct {
+	block    *types.Block
+	receipts []*types.Receipt
+	err      error
+}
+
+// ForEachBlock calls fn with each canonical block numbered from start to end
+// in order, until fn returns an error, which is returned. The iteration ends
+// early at the head block if end is above it. The blocks are read ahead of fn
+// in another goroutine, so jobs going through millions of blocks don't wait
+// for the database.
+func ForEachBlock(db DatabaseReader, start, end uint64, fn func(block *types.Block) error) error {
+	return iterateBlocks(db, start, end, false, func(block *types.Block, _ []*types.Receipt) error {
+		return fn(block)
+	})
+}
+
+// ForEachReceipt is like ForEachBlock, calling fn with the receipts of the
+// transactions of each block too.
+func ForEachReceipt(db DatabaseReader, start, end uint64, fn func(block *types.Block, receipts []*types.Receipt) error) error {
+	return iterateBlocks(db, start, end, true, fn)
+}
+
+// ForEachTransaction is like ForEachReceipt, calling fn with each transaction
+// of the blocks and its receipt.
+func ForEachTransaction(db DatabaseReader, start, end uint64, fn func(block *types.Block, index int, tx *types.Transaction, receipt *types.Receipt) error) error {
+	return iterateBlocks(db, start, end, true, func(block *types.Block, receipts []*types.Receipt) error {
+		for i, tx := range block.Transactions() {
+			if err := fn(block, i, tx, receipts[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func iterateBlocks(db DatabaseReader, start, end uint64, withReceipts bool, fn func(block *types.Block, receipts []*types.Receipt) error) error {
+	if start > end {
+		return nil
+	}
+	var (
+		blocks = make(chan *iteratedBlock, iteratorPrefetch)
+		quit   = make(chan struct{})
+	)
+	defer close(quit)
+
+	go func() {
+		defer close(blocks)
+		for number := start; ; number++ {
+			hash := ReadCanonicalHash(db, number)
+			if hash == (common.Hash{}) {
+				return
+			}
+			item := readIteratedBlock(db, hash, number, withReceipts)
+			select {
+			case blocks <- item:
+			case <-quit:
+				return
+			}
+			if item.err != nil || number == end {
+				return
+			}
+		}
+	}()
+	for item := range blocks {
+		if item.err != nil {
+			return item.err
+		}
+		if err := fn(item.block, item.receipts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readIteratedBlock(db DatabaseReader, hash common.Hash, number uint64, withReceipts bool) *iteratedBlock {
+	block := ReadBlock(db, hash, number)
+	if block == nil {
+		return &iteratedBlock{err: fmt.Errorf("block %d not found", number)}
+	}
+	item := &iteratedBlock{block: block}
+	if withReceipts {
+		item.receipts = ReadReceipts(db, hash, number)
+		if len(item.receipts) != len(block.Transactions()) {
+			item.err = fmt.Errorf("receipts of block %d not found", number)
+		}
+	}
+	return item
+}
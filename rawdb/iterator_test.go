@@ -0,0 +1,94 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+func TestForEachBlock(t *testing.T) {
+	db := fdb.NewMemDatabase()
+	var blocks []*types.Block
+	for i := 0; i < 5; i++ {
+		action := types.NewAction(types.Transfer, common.Name("from"), common.Name("to"), uint64(i), uint64(1), uint64(2000), big.NewInt(1000), nil)
+		block := &types.Block{
+			Head: &types.Header{Number: big.NewInt(int64(i))},
+			Txs:  []*types.Transaction{types.NewTransaction(uint64(1), big.NewInt(1), action)},
+		}
+		WriteBlock(db, block)
+		WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		WriteReceipts(db, block.Hash(), block.NumberU64(), []*types.Receipt{{TxHash: block.Txs[0].Hash(), TotalGasUsed: uint64(i)}})
+		blocks = append(blocks, block)
+	}
+
+	// The iteration ends at the head block.
+	var numbers []uint64
+	err := ForEachBlock(db, 1, 10, func(block *types.Block) error {
+		numbers = append(numbers, block.NumberU64())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(numbers) != 4 || numbers[0] != 1 || numbers[3] != 4 {
+		t.Fatalf("iterated blocks %v, want 1 to 4", numbers)
+	}
+
+	var txs int
+	err = ForEachTransaction(db, 0, 2, func(block *types.Block, index int, tx *types.Transaction, receipt *types.Receipt) error {
+		if tx.Hash() != blocks[block.NumberU64()].Txs[index].Hash() || receipt.TxHash != tx.Hash() {
+			t.Errorf("block %d: transaction %d mismatch", block.NumberU64(), index)
+		}
+		txs++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txs != 3 {
+		t.Fatalf("iterated %d transactions, want 3", txs)
+	}
+
+	// An error of fn stops the iteration.
+	stop := errors.New("stop")
+	numbers = nil
+	err = ForEachReceipt(db, 0, 4, func(block *types.Block, receipts []*types.Receipt) error {
+		numbers = append(numbers, block.NumberU64())
+		if block.NumberU64() == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || len(numbers) != 3 {
+		t.Fatalf("iteration not stopped: err %v, blocks %v", err, numbers)
+	}
+
+	// Missing receipts are reported.
+	DeleteReceipts(db, blocks[3].Hash(), 3)
+	if err := ForEachReceipt(db, 0, 4, func(*types.Block, []*types.Receipt) error { return nil }); err == nil {
+		t.Fatal("missing receipts not reported")
+	}
+	if err := ForEachBlock(db, 0, 4, func(*types.Block) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
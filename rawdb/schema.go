@@ -45,6 +45,10 @@ var (
 
 	blockBodyPrefix     = []byte("b") // blockBodyPrefix + num (uint64 big endian) + hash -> block body
 	blockReceiptsPrefix = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
+	receiptsDictPrefix  = []byte("R") // receiptsDictPrefix + epoch (uint64 big endian) -> receipts compression dictionary
+	internalTxsPrefix   = []byte("I") // internalTxsPrefix + num (uint64 big endian) + hash -> block internal transactions
+
+	assetStatsDeltasPrefix = []byte("d") // assetStatsDeltasPrefix + num (uint64 big endian) + hash -> block asset statistics deltas
 
 	txLookupPrefix  = []byte("l") // txLookupPrefix + hash -> transaction/receipt lookup metadata
 	bloomBitsPrefix = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
@@ -53,11 +57,41 @@ var (
 	configPrefix   = []byte("ft-config-")  // config prefix for the db
 
 	// Chain index prefixes (use `i` + single byte to avoid mixing data types).
-	BloomBitsIndexPrefix = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
+	BloomBitsIndexPrefix  = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
+	internalTxIndexPrefix = []byte("iI") // internalTxIndexPrefix + name -> numbers of blocks with internal transactions of the account
+	assetStatsPrefix      = []byte("iA") // assetStatsPrefix + asset id (uint64 big endian) -> asset statistics
+	txSearchPrefix        = []byte("iT") // txSearchPrefix + field + num (uint64 big endian) + tx index + action index (uint32 big endian) -> block hash + tx hash
+	checkpointPrefix      = []byte("iC") // checkpointPrefix + num (uint64 big endian) -> checkpoint signed by a producer quorum
 
 	blockStateOutPrefix = []byte("S") // blockRevertPrefix + num (uint64 big endian) + hash -> block revert info
 
 	blockOptHash = []byte("LastOptHash")
+
+	// stateCommitKey marks a state commit that is being written. It must not
+	// start with blockStateOutPrefix.
+	stateCommitKey = []byte("PendingStateCommit")
+
+	// legacyStateCommitKey is the state commit marker of the databases written
+	// before stateCommitKey, read to recover their interrupted commits.
+	legacyStateCommitKey = []byte("StateCommit")
+
+	// chainIntentKey records a multi-key chain mutation being written.
+	chainIntentKey = []byte("ChainIntent")
+
+	// statePruneKey tracks the progress of a state pruning.
+	statePruneKey = []byte("PruneProgress")
+
+	// assetStatsHeadKey tracks the block the asset statistics are at.
+	assetStatsHeadKey = []byte("AssetStatsHead")
+
+	// txSearchTailKey tracks the first block of the transaction search index.
+	txSearchTailKey = []byte("TxSearchTail")
+
+	// checkpointKey tracks the latest checkpoint signed by a producer quorum.
+	checkpointKey = []byte("LastCheckpoint")
+
+	// headerOnlyKey marks a database storing the headers of the blocks only.
+	headerOnlyKey = []byte("HeaderOnly")
 )
 
 // TxLookupEntry is a positional metadata to help looking up the data content of
@@ -68,6 +102,30 @@ type TxLookupEntry struct {
 	Index      uint64
 }
 
+// StatePruneProgress is the progress of a state pruning, saved so that an
+// interrupted pruning can be resumed.
+type StatePruneProgress struct {
+	Number uint64      // state changes of blocks below Number are pruned
+	Last   common.Hash // hash of the last block whose state changes were checked
+}
+
+// Chain intents are the multi-key chain mutations recorded ahead of their
+// writes.
+const (
+	IntentInsertBlock uint8 = iota // the block becomes the head
+	IntentSetHead                  // the heads are moved to the block
+)
+
+// ChainIntent is a multi-key chain mutation. It is written before the
+// mutation and removed once the mutation is complete, so an intent left
+// behind means the mutation was interrupted.
+type ChainIntent struct {
+	Op     uint8
+	Hash   common.Hash // block the mutation moves the chain to
+	Number uint64
+	Head   common.Hash // head block before the mutation
+}
+
 // encodeBlockNumber encodes a block number as big endian uint64
 func encodeBlockNumber(number uint64) []byte {
 	enc := make([]byte, 8)
@@ -104,6 +162,40 @@ func blockReceiptsKey(number uint64, hash common.Hash) []byte {
 	return append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
 
+// receiptsDictKey = receiptsDictPrefix + epoch (uint64 big endian)
+func receiptsDictKey(epoch uint64) []byte {
+	return append(receiptsDictPrefix, encodeBlockNumber(epoch)...)
+}
+
+// internalTxsKey = internalTxsPrefix + num (uint64 big endian) + hash
+func internalTxsKey(number uint64, hash common.Hash) []byte {
+	return append(append(internalTxsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
+// txSearchKey = txSearchPrefix + field + num (uint64 big endian) + tx index (uint32 big endian) + action index (uint32 big endian)
+func txSearchKey(field TxSearchField, number uint64, txIndex, actionIndex uint32) []byte {
+	key := append(append(append([]byte{}, txSearchPrefix...), field...), encodeBlockNumber(number)...)
+	key = append(key, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(key[len(key)-8:], txIndex)
+	binary.BigEndian.PutUint32(key[len(key)-4:], actionIndex)
+	return key
+}
+
+// assetStatsDeltasKey = assetStatsDeltasPrefix + num (uint64 big endian) + hash
+func assetStatsDeltasKey(number uint64, hash common.Hash) []byte {
+	return append(append(assetStatsDeltasPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
+// assetStatsKey = assetStatsPrefix + asset id (uint64 big endian)
+func assetStatsKey(assetID uint64) []byte {
+	return append(assetStatsPrefix, encodeBlockNumber(assetID)...)
+}
+
+// internalTxIndexKey = internalTxIndexPrefix + name
+func internalTxIndexKey(name common.Name) []byte {
+	return append(internalTxIndexPrefix, []byte(name)...)
+}
+
 // blockStatePrefix + num (uint64 big endian) + hash -> block revert info
 func blockStateOutKey(hash common.Hash) []byte {
 	return append(blockStateOutPrefix, hash.Bytes()...)
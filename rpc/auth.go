@@ -0,0 +1,163 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// authTokenDrift is how far the issue time of a token may be from the time
+// of the server, which limits the replay of a leaked token.
+const authTokenDrift = 60 * time.Second
+
+var (
+	errAuthMissing = errors.New("missing authorization token")
+	errAuthInvalid = errors.New("invalid authorization token")
+	errAuthExpired = errors.New("stale authorization token")
+)
+
+// Auth restricts namespaces to the callers authorized by a token.
+type Auth struct {
+	Secret     []byte   // shared secret signing the tokens
+	Namespaces []string // namespaces requiring a token
+}
+
+type authKey struct{}
+
+// authHeader is the JOSE header of the tokens, the only one accepted.
+var authHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type authClaims struct {
+	IssuedAt int64 `json:"iat"`
+}
+
+// NewAuthToken returns a JSON web token issued at now and signed with secret
+// using HMAC-SHA256, to be sent as an "Authorization: Bearer" header.
+func NewAuthToken(secret []byte, now time.Time) (string, error) {
+	claims, err := json.Marshal(&authClaims{IssuedAt: now.Unix()})
+	if err != nil {
+		return "", err
+	}
+	signed := authHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signed + "." + authSignature(secret, signed), nil
+}
+
+func authSignature(secret []byte, signed string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyAuthToken checks that token is signed with secret and was issued
+// around now.
+func verifyAuthToken(secret []byte, token string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != authHeader {
+		return errAuthInvalid
+	}
+	signature := authSignature(secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(signature), []byte(parts[2])) {
+		return errAuthInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return errAuthInvalid
+	}
+	var claims authClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return errAuthInvalid
+	}
+	issued := time.Unix(claims.IssuedAt, 0)
+	if issued.Before(now.Add(-authTokenDrift)) || issued.After(now.Add(authTokenDrift)) {
+		return errAuthExpired
+	}
+	return nil
+}
+
+// setAuth restricts the namespaces of auth to the requests carrying a token
+// signed with its secret. It must be called before serving.
+func (s *Server) setAuth(auth *Auth) {
+	if auth == nil || len(auth.Namespaces) == 0 {
+		return
+	}
+	s.authSecret = auth.Secret
+	s.authNamespaces = make(map[string]bool)
+	for _, namespace := range auth.Namespaces {
+		s.authNamespaces[namespace] = true
+	}
+}
+
+// authorize returns the context of the requests of r, which is marked as
+// authorized if r carries a valid token. It fails if r carries an invalid one.
+func (s *Server) authorize(ctx context.Context, r *http.Request) (context.Context, error) {
+	if s.authNamespaces == nil {
+		return ctx, nil
+	}
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return ctx, nil
+	}
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ctx, errAuthInvalid
+	}
+	if err := verifyAuthToken(s.authSecret, strings.TrimPrefix(header, "Bearer "), time.Now()); err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, authKey{}, true), nil
+}
+
+// checkAuth fails if the namespace requires a token the request didn't carry.
+func (s *Server) checkAuth(ctx context.Context, namespace string) Error {
+	if !s.authNamespaces[namespace] {
+		return nil
+	}
+	if authorized, _ := ctx.Value(authKey{}).(bool); authorized {
+		return nil
+	}
+	return &unauthorizedError{fmt.Sprintf("%s: %v", namespace, errAuthMissing)}
+}
+
+// authTransport adds a fresh token to each request.
+type authTransport struct {
+	secret []byte
+	next   http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := NewAuthToken(t.secret, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(req)
+}
+
+// DialHTTPWithAuth creates a new RPC client that connects to an RPC server over
+// HTTP, authorizing its requests with tokens signed with secret.
+func DialHTTPWithAuth(endpoint string, secret []byte) (*Client, error) {
+	return DialHTTPWithClient(endpoint, &http.Client{Transport: &authTransport{secret, http.DefaultTransport}})
+}
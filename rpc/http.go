@@ -158,6 +158,13 @@ func (t *httpReadWriteNopCloser) Close() error {
 	return nil
 }
 
+// Flush sends the data written so far to the client.
+func (t *httpReadWriteNopCloser) Flush() {
+	if flusher, ok := t.Writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // NewHTTPServer creates a new HTTP RPC server around an API provider.
 //
 // Deprecated: Server implements http.Handler
@@ -186,7 +193,11 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// All checks passed, create a codec that reads direct from the request body
 	// untilEOF and writes the response to w and order the server to process a
 	// single request.
-	ctx := r.Context()
+	ctx, err := srv.authorize(r.Context(), r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 	ctx = context.WithValue(ctx, "remote", r.RemoteAddr)
 	ctx = context.WithValue(ctx, "scheme", r.Proto)
 	ctx = context.WithValue(ctx, "local", r.Host)
@@ -26,7 +26,7 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/ethereum/go-ethereum/log"
+	"github.com/fractalplatform/fractal/log"
 )
 
 const (
@@ -83,6 +83,7 @@ type jsonCodec struct {
 	encMu  sync.Mutex                // guards the encoder
 	encode func(v interface{}) error // encoder to allow multiple transports
 	rw     io.ReadWriteCloser        // connection
+	stream bool                      // whether streams are written element by element to rw
 }
 
 func (err *jsonError) Error() string {
@@ -96,6 +97,10 @@ func (err *jsonError) ErrorCode() int {
 	return err.Code
 }
 
+func (err *jsonError) ErrorData() interface{} {
+	return err.Data
+}
+
 // NewCodec creates a new RPC server codec with support for JSON-RPC 2.0 based
 // on explicitly given encoding and decoding methods.
 func NewCodec(rwc io.ReadWriteCloser, encode, decode func(v interface{}) error) ServerCodec {
@@ -118,6 +123,7 @@ func NewJSONCodec(rwc io.ReadWriteCloser) ServerCodec {
 		encode: enc.Encode,
 		decode: dec.Decode,
 		rw:     rwc,
+		stream: true,
 	}
 }
 
@@ -346,9 +352,80 @@ func (c *jsonCodec) Write(res interface{}) error {
 	c.encMu.Lock()
 	defer c.encMu.Unlock()
 
+	if resp, ok := res.(*jsonSuccessResponse); ok && c.stream {
+		if stream, ok := resp.Result.(Stream); ok {
+			return c.writeStream(resp, stream)
+		}
+	}
+	// The messages of the other transports are encoded whole.
+	collectStreams(res)
 	return c.encode(res)
 }
 
+// writeStream writes the response of a stream result, flushing the elements
+// as they are written if the connection buffers them.
+func (c *jsonCodec) writeStream(resp *jsonSuccessResponse, stream Stream) error {
+	// Write the envelope up to the opening bracket of the result.
+	head := []byte(`{"jsonrpc":"` + resp.Version + `"`)
+	if resp.Id != nil {
+		id, err := json.Marshal(resp.Id)
+		if err != nil {
+			return err
+		}
+		head = append(append(head, `,"id":`...), id...)
+	}
+	if _, err := c.rw.Write(append(head, `,"result":[`...)); err != nil {
+		return err
+	}
+	flusher, _ := c.rw.(interface{ Flush() })
+	for i := 0; ; i++ {
+		elem, ok := stream.Next()
+		if !ok {
+			break
+		}
+		data, err := json.Marshal(elem)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			data = append([]byte{','}, data...)
+		}
+		if _, err := c.rw.Write(data); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	_, err := c.rw.Write([]byte("]}\n"))
+	return err
+}
+
+// collectStreams replaces the stream results of a response or a batch of
+// responses by their elements.
+func collectStreams(res interface{}) {
+	collect := func(res interface{}) {
+		resp, ok := res.(*jsonSuccessResponse)
+		if !ok {
+			return
+		}
+		if stream, ok := resp.Result.(Stream); ok {
+			elems := []interface{}{}
+			for elem, ok := stream.Next(); ok; elem, ok = stream.Next() {
+				elems = append(elems, elem)
+			}
+			resp.Result = elems
+		}
+	}
+	if batch, ok := res.([]interface{}); ok {
+		for _, res := range batch {
+			collect(res)
+		}
+		return
+	}
+	collect(res)
+}
+
 // Close the underlying connection
 func (c *jsonCodec) Close() {
 	c.closer.Do(func() {
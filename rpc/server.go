@@ -25,7 +25,7 @@ import (
 	"sync"
 	"sync/atomic"
 
-	"github.com/ethereum/go-ethereum/log"
+	"github.com/fractalplatform/fractal/log"
 	"gopkg.in/fatih/set.v0"
 )
 
@@ -259,6 +259,9 @@ func (s *Server) handle(ctx context.Context, codec ServerCodec, req *serverReque
 	if req.err != nil {
 		return codec.CreateErrorResponse(&req.id, req.err), nil
 	}
+	if err := s.checkAuth(ctx, req.svcname); err != nil {
+		return codec.CreateErrorResponse(&req.id, err), nil
+	}
 
 	if req.isUnsubscribe { // cancel subscription, first param must be the subscription id
 		if len(req.args) >= 1 && req.args[0].Kind() == reflect.String {
@@ -316,13 +319,25 @@ func (s *Server) handle(ctx context.Context, codec ServerCodec, req *serverReque
 	if req.callb.errPos >= 0 { // test if method returned an error
 		if !reply[req.callb.errPos].IsNil() {
 			e := reply[req.callb.errPos].Interface().(error)
-			res := codec.CreateErrorResponse(&req.id, &callbackError{e.Error()})
-			return res, nil
+			return createCallbackErrorResponse(codec, &req.id, e), nil
 		}
 	}
 	return codec.CreateResponse(req.id, reply[0].Interface()), nil
 }
 
+// createCallbackErrorResponse creates the error response of an error returned
+// by a callback, keeping its code and data if it carries them.
+func createCallbackErrorResponse(codec ServerCodec, id interface{}, err error) interface{} {
+	rpcErr, ok := err.(Error)
+	if !ok {
+		rpcErr = &callbackError{err.Error()}
+	}
+	if de, ok := err.(DataError); ok {
+		return codec.CreateErrorResponseWithInfo(id, rpcErr, de.ErrorData())
+	}
+	return codec.CreateErrorResponse(id, rpcErr)
+}
+
 // exec executes the given request and writes the result back using the codec.
 func (s *Server) exec(ctx context.Context, codec ServerCodec, req *serverRequest) {
 	var response interface{}
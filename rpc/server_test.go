@@ -0,0 +1,177 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testDataError struct {
+	code int
+	data interface{}
+}
+
+func (e *testDataError) Error() string          { return "execution reverted" }
+func (e *testDataError) ErrorCode() int         { return e.code }
+func (e *testDataError) ErrorData() interface{} { return e.data }
+
+type testDataOnlyError struct{}
+
+func (e *testDataOnlyError) Error() string          { return "data only" }
+func (e *testDataOnlyError) ErrorData() interface{} { return "details" }
+
+type ErrorService struct{}
+
+func (s *ErrorService) Plain() error { return errors.New("plain") }
+func (s *ErrorService) Coded() error { return &testDataError{code: -32010} }
+func (s *ErrorService) Data() error {
+	return &testDataError{code: 3, data: map[string]string{"return": "0x01"}}
+}
+func (s *ErrorService) DataOnly() error { return &testDataOnlyError{} }
+
+func TestCallbackErrorResponse(t *testing.T) {
+	server := NewServer()
+	if err := server.RegisterName("test", new(ErrorService)); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeCodec(NewJSONCodec(serverConn), OptionMethodInvocation)
+
+	tests := []struct {
+		method string
+		want   jsonError
+	}{
+		{"test_plain", jsonError{Code: -32000, Message: "plain"}},
+		{"test_coded", jsonError{Code: -32010, Message: "execution reverted"}},
+		{"test_data", jsonError{Code: 3, Message: "execution reverted", Data: map[string]interface{}{"return": "0x01"}}},
+		{"test_dataOnly", jsonError{Code: -32000, Message: "data only", Data: "details"}},
+	}
+	reader := bufio.NewReader(clientConn)
+	for i, test := range tests {
+		req := map[string]interface{}{"jsonrpc": "2.0", "id": i, "method": test.method, "params": []interface{}{}}
+		if err := json.NewEncoder(clientConn).Encode(req); err != nil {
+			t.Fatal(err)
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp jsonErrResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resp.Error, test.want) {
+			t.Errorf("%s: error mismatch: have %+v, want %+v", test.method, resp.Error, test.want)
+		}
+	}
+}
+
+// countStream streams the numbers from 0 below n.
+type countStream struct{ next, n int }
+
+func (s *countStream) Next() (interface{}, bool) {
+	if s.next >= s.n {
+		return nil, false
+	}
+	s.next++
+	return s.next - 1, true
+}
+
+type StreamService struct{}
+
+func (s *StreamService) Count(n int) (Stream, error) { return &countStream{n: n}, nil }
+
+func TestStreamResponse(t *testing.T) {
+	server := NewServer()
+	if err := server.RegisterName("test", new(StreamService)); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeCodec(NewJSONCodec(serverConn), OptionMethodInvocation)
+
+	reader := bufio.NewReader(clientConn)
+	for _, n := range []int{3, 0} {
+		req := map[string]interface{}{"jsonrpc": "2.0", "id": n, "method": "test_count", "params": []interface{}{n}}
+		if err := json.NewEncoder(clientConn).Encode(req); err != nil {
+			t.Fatal(err)
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp struct {
+			Id     int
+			Result []int
+		}
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatalf("invalid response %s: %v", line, err)
+		}
+		if resp.Id != n || len(resp.Result) != n || resp.Result == nil {
+			t.Fatalf("response mismatch: have %s", line)
+		}
+	}
+
+	// a batch collects the streams
+	batch := []map[string]interface{}{{"jsonrpc": "2.0", "id": 1, "method": "test_count", "params": []interface{}{2}}}
+	if err := json.NewEncoder(clientConn).Encode(batch); err != nil {
+		t.Fatal(err)
+	}
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resps []struct{ Result []int }
+	if err := json.Unmarshal(line, &resps); err != nil || len(resps) != 1 || !reflect.DeepEqual(resps[0].Result, []int{0, 1}) {
+		t.Fatalf("batch response mismatch: have %s", line)
+	}
+}
+
+func TestStreamResponseHTTP(t *testing.T) {
+	server := NewServer()
+	if err := server.RegisterName("test", new(StreamService)); err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"test_count","params":[1000]}`
+	resp, err := http.Post(httpServer.URL, contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("response not chunked: transfer encoding %v", resp.TransferEncoding)
+	}
+	var result struct{ Result []int }
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Result) != 1000 || result.Result[999] != 999 {
+		t.Fatalf("result mismatch: have %d elements", len(result.Result))
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package rpc
+
+import "testing"
+
+func TestNotifyBufferBound(t *testing.T) {
+	n := newNotifier(nil)
+	sub := n.CreateSubscription()
+	for i := 0; i < maxBufferedNotifications; i++ {
+		if err := n.Notify(sub.ID, i); err != nil {
+			t.Fatalf("notification %d: %v", i, err)
+		}
+	}
+	if err := n.Notify(sub.ID, maxBufferedNotifications); err != ErrNotificationBufferFull {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrNotificationBufferFull)
+	}
+	if len(sub.buffer) != maxBufferedNotifications {
+		t.Fatalf("buffered notifications mismatch: have %d, want %d", len(sub.buffer), maxBufferedNotifications)
+	}
+}
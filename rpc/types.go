@@ -71,6 +71,9 @@ type subscriptions map[string]*callback  // collection of subscription callbacks
 type Server struct {
 	services serviceRegistry
 
+	authSecret     []byte
+	authNamespaces map[string]bool // namespaces requiring a token
+
 	run      int32
 	codecsMu sync.Mutex
 	codecs   set.Interface
@@ -92,6 +95,21 @@ type Error interface {
 	ErrorCode() int // returns the code
 }
 
+// DataError is an error carrying structured data, returned in the data field
+// of the JSON-RPC error object.
+type DataError interface {
+	Error() string          // returns the message
+	ErrorData() interface{} // returns the error data
+}
+
+// Stream is a result returned by a method as a JSON array written element by
+// element, so that a large result isn't held in memory in full. Over HTTP the
+// response is sent in chunks as the elements are written.
+type Stream interface {
+	// Next returns the next element, false once there are none left.
+	Next() (interface{}, bool)
+}
+
 // ServerCodec implements reading, parsing and writing RPC messages for the server side of
 // a RPC session. Implementations must be go-routine safe since the codec can be called in
 // multiple go-routines concurrently.
@@ -17,9 +17,12 @@
 package state
 
 import (
+	"math"
 	"sync"
+	"sync/atomic"
 
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/metrics"
 	"github.com/fractalplatform/fractal/rawdb"
 	"github.com/fractalplatform/fractal/utils/fdb"
 	"github.com/hashicorp/golang-lru"
@@ -27,7 +30,13 @@ import (
 )
 
 const (
-	kvCacheSize = 100000
+	// DefaultCacheSize is the memory allowance in megabytes of the state cache.
+	DefaultCacheSize = 64
+)
+
+var (
+	cacheHitMeter  = metrics.NewRegisteredMeter("state/cache/hit", nil)
+	cacheMissMeter = metrics.NewRegisteredMeter("state/cache/miss", nil)
 )
 
 //Database cache db exported
@@ -49,20 +58,52 @@ type Database interface {
 
 // NewDatabase creates a backing store for state.
 func NewDatabase(db fdb.Database) Database {
-	kvch, _ := lru.New(kvCacheSize)
-	//get cache hash from db
-	curHash := rawdb.ReadOptBlockHash(db)
+	return NewDatabaseWithCache(db, DefaultCacheSize)
+}
 
-	return &cachingDB{db: db,
-		kvCache: kvch,
-		hash:    curHash}
+// NewDatabaseWithCache creates a backing store for state that caches up to
+// cache megabytes of state values in memory.
+func NewDatabaseWithCache(db fdb.Database, cache int) Database {
+	if cache <= 0 {
+		cache = DefaultCacheSize
+	}
+	cdb := &cachingDB{db: db,
+		cacheLimit: int64(cache) * 1024 * 1024,
+		//get cache hash from db
+		hash: rawdb.ReadOptBlockHash(db)}
+	// The cache is bounded by the size of its values, not their count.
+	cdb.kvCache, _ = lru.NewWithEvict(math.MaxInt32, cdb.onEvict)
+	return cdb
 }
 
 type cachingDB struct {
-	db      fdb.Database
-	lock    sync.RWMutex
-	kvCache *lru.Cache
-	hash    common.Hash
+	cacheSize  int64 // bytes held by kvCache, accessed atomically
+	cacheLimit int64
+	db         fdb.Database
+	lock       sync.RWMutex
+	kvCache    *lru.Cache
+	hash       common.Hash
+}
+
+// cacheEntrySize is the memory accounted to a cached value.
+func cacheEntrySize(key string, value []byte) int64 {
+	return int64(len(key) + len(value))
+}
+
+func (db *cachingDB) onEvict(key interface{}, value interface{}) {
+	atomic.AddInt64(&db.cacheSize, -cacheEntrySize(key.(string), value.([]byte)))
+}
+
+// addCache caches a value, evicting the least recently used ones while the
+// cache is over its memory allowance.
+func (db *cachingDB) addCache(key string, value []byte) {
+	// Replacing a value doesn't call onEvict, so remove the old one first.
+	db.kvCache.Remove(key)
+	db.kvCache.Add(key, value)
+	atomic.AddInt64(&db.cacheSize, cacheEntrySize(key, value))
+	for atomic.LoadInt64(&db.cacheSize) > db.cacheLimit && db.kvCache.Len() > 0 {
+		db.kvCache.RemoveOldest()
+	}
 }
 
 func (db *cachingDB) Lock() {
@@ -99,8 +140,10 @@ func (db *cachingDB) Purge() {
 
 func (db *cachingDB) Get(key string) ([]byte, error) {
 	if cached, ok := db.kvCache.Get(key); ok {
+		cacheHitMeter.Mark(1)
 		return cached.([]byte), nil
 	}
+	cacheMissMeter.Mark(1)
 
 	value, err := db.db.Get([]byte(key))
 	if err != nil {
@@ -110,7 +153,7 @@ func (db *cachingDB) Get(key string) ([]byte, error) {
 		//not found return nil
 	}
 
-	db.kvCache.Add(key, common.CopyBytes(value))
+	db.addCache(key, common.CopyBytes(value))
 
 	return value, nil
 }
@@ -120,14 +163,14 @@ func (db *cachingDB) Put(key string, value []byte) error {
 	if err != nil {
 		return err
 	}
-	db.kvCache.Add(key, common.CopyBytes(value))
+	db.addCache(key, common.CopyBytes(value))
 
 	return nil
 }
 
 //only put value to cache
 func (db *cachingDB) PutCache(key string, value []byte) error {
-	db.kvCache.Add(key, common.CopyBytes(value))
+	db.addCache(key, common.CopyBytes(value))
 
 	return nil
 }
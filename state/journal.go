@@ -76,7 +76,8 @@ type (
 	addLogChange struct {
 		txhash common.Hash
 	}
-	addPreimageChange struct {
+	addInternalTxChange struct{}
+	addPreimageChange   struct {
 		hash common.Hash
 	}
 )
@@ -111,6 +112,14 @@ func (ch addLogChange) dirtied() *string {
 	return nil
 }
 
+func (ch addInternalTxChange) revert(s *StateDB) {
+	s.internalTxs = s.internalTxs[:len(s.internalTxs)-1]
+}
+
+func (ch addInternalTxChange) dirtied() *string {
+	return nil
+}
+
 func (ch addPreimageChange) revert(s *StateDB) {
 	delete(s.preimages, ch.hash)
 }
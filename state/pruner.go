@@ -0,0 +1,206 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+)
+
+// pruneBatchBlocks is the number of blocks checked between writes of the
+// pruning progress.
+const pruneBatchBlocks = 4096
+
+// PruneStateOuts deletes the state changes saved for the blocks more than
+// keep blocks below the head block, on every branch. They are needed to move
+// the state to another branch and to trace the block, so neither is possible
+// for pruned blocks. It must not run while the chain is in use.
+//
+// If interval isn't zero, the state stays readable at every interval-th
+// canonical block: the changes of the canonical blocks between two of them
+// are merged into the changes of the upper one instead of deleted. The state
+// of the blocks in between is reconstructed by replaying the blocks from the
+// closest one below, trading replay time for disk space. A smaller interval
+// than the one of an earlier pruning doesn't bring back its pruned blocks.
+//
+// Deletes are written in batches along with the progress, so an interrupted
+// pruning continues where it stopped when run again. report is called after
+// each batch with the number of blocks checked and pruned so far.
+func PruneStateOuts(db fdb.Database, keep uint64, interval uint64, report func(checked, pruned int)) error {
+	progress := rawdb.ReadStatePruneProgress(db)
+	if progress == nil {
+		number := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db))
+		if number == nil {
+			return errors.New("head block not found")
+		}
+		if *number <= keep {
+			return nil
+		}
+		progress = &rawdb.StatePruneProgress{Number: *number - keep}
+	}
+	// The state must stay at a block whose changes are kept.
+	if optHash := rawdb.ReadOptBlockHash(db); optHash != (common.Hash{}) {
+		if stateOut := rawdb.ReadBlockStateOut(db, optHash); stateOut != nil && stateOut.Number < progress.Number {
+			return errors.New("state is below the pruned blocks")
+		}
+	}
+
+	var (
+		batch   = db.NewBatch()
+		checked int
+		pruned  int
+		err     error
+	)
+	if interval > 0 && progress.Number > 0 {
+		// The changes of the lowest kept block are merged down to the
+		// closest multiple too, linking the kept blocks to the merged ones.
+		top := progress.Number - 1
+		top -= top % interval
+		for lower := uint64(0); lower <= top; lower += interval {
+			number := lower + interval
+			if lower == top {
+				number = progress.Number
+			}
+			merged, err := mergeStateOuts(db, batch, number, lower)
+			if err != nil {
+				return err
+			}
+			pruned += merged
+			if batch.ValueSize() >= fdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					return err
+				}
+				batch.Reset()
+			}
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+		report(checked, pruned)
+	}
+	flush := func() error {
+		rawdb.WriteStatePruneProgress(batch, progress)
+		if err := batch.Write(); err != nil {
+			return err
+		}
+		batch.Reset()
+		report(checked, pruned)
+		return nil
+	}
+	iterErr := rawdb.ForEachBlockStateOut(db, progress.Last, func(hash common.Hash, stateOut *types.StateOut) bool {
+		if stateOut.Number < progress.Number && !retainedStateOut(db, stateOut, interval) {
+			rawdb.DeleteBlockStateOut(batch, hash)
+			pruned++
+		}
+		checked++
+		progress.Last = hash
+		if checked%pruneBatchBlocks == 0 {
+			err = flush()
+		}
+		return err == nil
+	})
+	if iterErr != nil {
+		return iterErr
+	}
+	if err != nil {
+		return err
+	}
+	rawdb.DeleteStatePruneProgress(batch)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	report(checked, pruned)
+	return nil
+}
+
+// retainedStateOut reports whether the state changes of the block are kept by
+// a pruning with the interval.
+func retainedStateOut(db fdb.Database, stateOut *types.StateOut, interval uint64) bool {
+	return interval > 0 && stateOut.Number%interval == 0 && rawdb.ReadCanonicalHash(db, stateOut.Number) == stateOut.Hash
+}
+
+// mergeStateOuts merges the state changes of the canonical blocks above the
+// lower block number into the changes of the canonical block with the given
+// number, and returns the number of blocks whose changes were deleted. Blocks
+// already merged, or whose changes below were pruned, are left as they are.
+func mergeStateOuts(db fdb.Database, batch fdb.Batch, number uint64, lower uint64) (int, error) {
+	var (
+		parent = rawdb.ReadCanonicalHash(db, number)
+		outs   []*types.StateOut
+	)
+	for {
+		stateOut := rawdb.ReadBlockStateOut(db, parent)
+		if stateOut == nil {
+			return 0, nil
+		}
+		outs = append(outs, stateOut)
+		parent = stateOut.ParentHash
+		n := rawdb.ReadHeaderNumber(db, parent)
+		if n == nil {
+			return 0, errors.New("parent block of state changes not found")
+		}
+		if *n <= lower {
+			break
+		}
+	}
+	if len(outs) == 1 {
+		return 0, nil
+	}
+
+	// The reverts of the lowest block and the changes of the highest block
+	// changing a key win.
+	reverts := make(map[string]*types.OptInfo)
+	for _, stateOut := range outs {
+		for _, revert := range stateOut.Reverts {
+			reverts[revert.Key] = revert
+		}
+	}
+	changes := make(map[string]*types.OptInfo)
+	for i := len(outs) - 1; i >= 0; i-- {
+		for _, change := range outs[i].Changes {
+			changes[change.Key] = change
+		}
+	}
+	merged := &types.StateOut{
+		ParentHash: parent,
+		Number:     outs[0].Number,
+		Hash:       outs[0].Hash,
+		ReadSet:    outs[0].ReadSet,
+		Reverts:    sortedOptInfos(reverts),
+		Changes:    sortedOptInfos(changes),
+	}
+	rawdb.WriteBlockStateOut(batch, merged.Hash, merged)
+	for _, stateOut := range outs[1:] {
+		rawdb.DeleteBlockStateOut(batch, stateOut.Hash)
+	}
+	return len(outs) - 1, nil
+}
+
+func sortedOptInfos(infos map[string]*types.OptInfo) []*types.OptInfo {
+	sorted := make([]*types.OptInfo, 0, len(infos))
+	for _, info := range infos {
+		sorted = append(sorted, info)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
@@ -0,0 +1,292 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/fdb"
+	"github.com/fractalplatform/fractal/utils/rlp"
+	"golang.org/x/crypto/sha3"
+)
+
+// statePrefixes are the prefixes of the keys holding the state, in key order.
+var statePrefixes = []string{acctDataPrefix + linkSymbol, statePrefix + linkSymbol}
+
+// IsStateKey reports whether key is a key of the state, as opposed to a key of
+// the chain sharing the database.
+func IsStateKey(key []byte) bool {
+	for _, prefix := range statePrefixes {
+		if !bytes.HasPrefix(key, []byte(prefix)) {
+			continue
+		}
+		// The block state changes are keyed by "S" and a hash, which may
+		// start with "T*".
+		if prefix == statePrefix+linkSymbol && len(key) == 1+common.HashLength {
+			return false
+		}
+		rest := string(key[len(prefix):])
+		i := strings.Index(rest, linkSymbol)
+		return i > 0 && i < len(rest)-len(linkSymbol)
+	}
+	return false
+}
+
+// stateReverts returns the values the keys changed above the block with the
+// given hash had at the block, nil for the keys which didn't exist.
+func stateReverts(db fdb.Database, hash common.Hash) (map[string][]byte, error) {
+	return stateRevertsFrom(db, rawdb.ReadOptBlockHash(db), hash, 0)
+}
+
+// stateRevertsFrom is like stateReverts, reverting the state changes from the
+// block from down to the block. At most maxDepth blocks of state changes are
+// reverted, unbounded if 0.
+func stateRevertsFrom(db fdb.Database, from, hash common.Hash, maxDepth uint64) (map[string][]byte, error) {
+	number := rawdb.ReadHeaderNumber(db, hash)
+	if number == nil {
+		return nil, fmt.Errorf("block %x not found", hash)
+	}
+	// Revert the changes of the blocks above, the lower blocks last.
+	var (
+		reverts = make(map[string][]byte)
+		optHash = from
+		depth   uint64
+	)
+	for optHash != hash {
+		if depth++; maxDepth > 0 && depth > maxDepth {
+			return nil, fmt.Errorf("state of block %x is more than %d blocks of state changes deep", hash, maxDepth)
+		}
+		stateOut := rawdb.ReadBlockStateOut(db, optHash)
+		if stateOut == nil {
+			return nil, fmt.Errorf("state changes of block %x not found", optHash)
+		}
+		if stateOut.Number <= *number {
+			return nil, fmt.Errorf("block %x is not an ancestor of the state block", hash)
+		}
+		for _, revert := range stateOut.Reverts {
+			if revert.Opt == optDel {
+				reverts[revert.Key] = nil
+			} else {
+				reverts[revert.Key] = revert.Value
+			}
+		}
+		optHash = stateOut.ParentHash
+	}
+	return reverts, nil
+}
+
+// ForEachStateItem calls fn with each key and value of the state at the block
+// with the given hash, in key order, until fn returns an error. The block must
+// be an ancestor of the block the state is at, whose state changes haven't been
+// pruned. The database must not be written meanwhile.
+func ForEachStateItem(db fdb.Database, hash common.Hash, fn func(key, value []byte) error) error {
+	return ForEachStateItemFrom(db, hash, nil, fn)
+}
+
+// ForEachStateItemFrom is like ForEachStateItem, starting from the first key
+// not below origin. The database iterators are seeked to origin, so stopping
+// fn bounds the work to the items it was called with and the state changes
+// of the blocks above.
+func ForEachStateItemFrom(db fdb.Database, hash common.Hash, origin []byte, fn func(key, value []byte) error) error {
+	reverts, err := stateReverts(db, hash)
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range statePrefixes {
+		var start []byte
+		switch {
+		case strings.HasPrefix(string(origin), prefix):
+			start = origin[len(prefix):]
+		case string(origin) > prefix:
+			// every key of the prefix is below origin
+			continue
+		}
+		var pending []string
+		for key := range reverts {
+			if strings.HasPrefix(key, prefix) && key >= string(origin) {
+				pending = append(pending, key)
+			}
+		}
+		sort.Strings(pending)
+		emit := func(key string, value []byte) error {
+			// deleted keys have no value
+			if len(value) == 0 {
+				return nil
+			}
+			return fn([]byte(key), value)
+		}
+
+		it := db.NewIteratorWithStart([]byte(prefix), start)
+		for it.Next() {
+			if !IsStateKey(it.Key()) {
+				continue
+			}
+			key := string(it.Key())
+			for len(pending) > 0 && pending[0] < key {
+				if err := emit(pending[0], reverts[pending[0]]); err != nil {
+					it.Release()
+					return err
+				}
+				pending = pending[1:]
+			}
+			value := it.Value()
+			if len(pending) > 0 && pending[0] == key {
+				value = reverts[key]
+				pending = pending[1:]
+			}
+			if err := emit(key, value); err != nil {
+				it.Release()
+				return err
+			}
+		}
+		it.Release()
+		if err := it.Error(); err != nil {
+			return err
+		}
+		for _, key := range pending {
+			if err := emit(key, reverts[key]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReadStateItems returns the values of the keys in the state at the block with
+// the given hash, nil for the keys which don't exist. The block must be an
+// ancestor of the block the state is at, whose state changes haven't been
+// pruned. The database must not be written meanwhile.
+func ReadStateItems(db fdb.Database, hash common.Hash, keys [][]byte) ([][]byte, error) {
+	reverts, err := stateReverts(db, hash)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		if !IsStateKey(key) {
+			continue
+		}
+		if value, ok := reverts[string(key)]; ok {
+			values[i] = common.CopyBytes(value)
+			continue
+		}
+		if has, _ := db.Has(key); !has {
+			continue
+		}
+		if values[i], err = db.Get(key); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// HealStateItems writes values of keys which are missing or stale in the state
+// at the block with the given hash, to the database and the cache, deleting
+// the keys with a nil value. Keys changed by the blocks above are skipped,
+// their values were written since. It returns the number of keys written or
+// deleted.
+func HealStateItems(cache Database, hash common.Hash, keys, values [][]byte) (int, error) {
+	cache.Lock()
+	defer cache.UnLock()
+	db := cache.GetDB()
+	reverts, err := stateReverts(db, hash)
+	if err != nil {
+		return 0, err
+	}
+	batch := db.NewBatch()
+	var healed []int
+	for i, key := range keys {
+		if !IsStateKey(key) || (values[i] != nil && len(values[i]) == 0) {
+			return 0, fmt.Errorf("invalid state item %q", key)
+		}
+		if _, ok := reverts[string(key)]; ok {
+			continue
+		}
+		if values[i] == nil {
+			err = batch.Delete(key)
+		} else {
+			err = batch.Put(key, values[i])
+		}
+		if err != nil {
+			return 0, err
+		}
+		healed = append(healed, i)
+	}
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	for _, i := range healed {
+		if values[i] == nil {
+			cache.DeleteCache(string(keys[i]))
+		} else {
+			cache.PutCache(string(keys[i]), values[i])
+		}
+	}
+	return len(healed), nil
+}
+
+// StateHasher computes the commitment to a whole state, the keccak256 hash of
+// the RLP encoded keys and value hashes of its items in key order. Hashing the
+// values first lets the commitment be checked against the listings of the
+// remotes, without their values.
+type StateHasher struct {
+	hasher hash.Hash
+	items  uint64
+}
+
+// NewStateHasher creates a hasher of the state items.
+func NewStateHasher() *StateHasher {
+	return &StateHasher{hasher: sha3.NewLegacyKeccak256()}
+}
+
+// Add hashes the next item of the state, the keys must be added in order.
+func (h *StateHasher) Add(key []byte, valueHash common.Hash) error {
+	h.items++
+	return rlp.Encode(h.hasher, []interface{}{key, valueHash})
+}
+
+// Items returns the number of items hashed.
+func (h *StateHasher) Items() uint64 {
+	return h.items
+}
+
+// Sum returns the commitment to the items hashed.
+func (h *StateHasher) Sum() (sum common.Hash) {
+	h.hasher.Sum(sum[:0])
+	return sum
+}
+
+// CommitImportedState moves the state to the given block after its keys and
+// values have been written to batch, then writes the batch. The block has no
+// state changes saved, so the state can't be moved below it.
+func CommitImportedState(db fdb.Database, batch fdb.Batch, parentHash, blockHash common.Hash, blockNum uint64) error {
+	rawdb.WriteBlockStateOut(batch, blockHash, &types.StateOut{
+		ParentHash: parentHash,
+		Number:     blockNum,
+		Hash:       blockHash,
+	})
+	rawdb.WriteOptBlockHash(batch, blockHash)
+	return WriteCommit(db, batch, blockHash)
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/fractalplatform/fractal/common"
@@ -42,6 +43,10 @@ var (
 	linkSymbol     = "*"
 )
 
+// maxReplayRetries is the number of times the state changes of a replay are
+// read again when the state moves meanwhile.
+const maxReplayRetries = 3
+
 const (
 	optAdd = 1 // Reverts/Changes record add key value
 	optUpd = 2 // Reverts/Changes record update key value
@@ -63,10 +68,13 @@ type StateDB struct {
 
 	thash, bhash common.Hash // current transaction hash and current block hash
 	txIndex      int         // transaction index in block
+	actionIndex  int         // action index in transaction
 
 	logs    map[common.Hash][]*types.Log
 	logSize uint
 
+	internalTxs []*types.InternalTx
+
 	preimages map[common.Hash][]byte
 
 	journal        *journal
@@ -77,6 +85,9 @@ type StateDB struct {
 
 	stateTrace bool // replay transaction, true is replayed , false is not replayed
 
+	replay     map[string][]byte // values at the replayed block of the keys changed above it
+	replayHead common.Hash       // block the database was at when the replay started
+
 	lock sync.Mutex
 }
 
@@ -130,8 +141,10 @@ func (s *StateDB) Reset() error {
 	s.thash = common.Hash{}
 	s.bhash = common.Hash{}
 	s.txIndex = 0
+	s.actionIndex = 0
 	s.logs = make(map[common.Hash][]*types.Log)
 	s.logSize = 0
+	s.internalTxs = nil
 	s.preimages = make(map[common.Hash][]byte)
 	s.dbErr = nil
 	s.clearJournalAndRefund()
@@ -145,6 +158,7 @@ func (s *StateDB) AddLog(log *types.Log) {
 	log.TxHash = s.thash
 	log.BlockHash = s.bhash
 	log.TxIndex = uint(s.txIndex)
+	log.ActionIndex = uint(s.actionIndex)
 	log.Index = s.logSize
 	s.logs[s.thash] = append(s.logs[s.thash], log)
 	s.logSize++
@@ -164,6 +178,22 @@ func (s *StateDB) Logs() []*types.Log {
 	return logs
 }
 
+// save internal transaction made by a contract
+func (s *StateDB) AddInternalTx(itx *types.InternalTx) {
+	s.journal.append(addInternalTxChange{})
+
+	itx.TxHash = s.thash
+	itx.TxIndex = uint(s.txIndex)
+	itx.ActionIndex = uint(s.actionIndex)
+	itx.Index = uint(len(s.internalTxs))
+	s.internalTxs = append(s.internalTxs, itx)
+}
+
+// get all internal transactions recorded in the block
+func (s *StateDB) InternalTxs() []*types.InternalTx {
+	return s.internalTxs
+}
+
 // hash is preimageHash
 func (s *StateDB) AddPreimage(hash common.Hash, preimage []byte) {
 	if _, ok := s.preimages[hash]; !ok {
@@ -234,6 +264,9 @@ func (s *StateDB) get(key string) ([]byte, error) {
 		s.setError(err)
 		return nil, err
 	}
+	if s.replay != nil {
+		return s.getReplay(key)
+	}
 
 	s.db.RLock()
 	hash := s.db.GetHash()
@@ -260,6 +293,29 @@ func (s *StateDB) get(key string) ([]byte, error) {
 	return common.CopyBytes(value), nil
 }
 
+// getReplay reads a key of a replayed state, whose changes by the blocks
+// above the replayed block are reverted.
+func (s *StateDB) getReplay(key string) ([]byte, error) {
+	s.db.RLock()
+	defer s.db.RUnLock()
+	if hash := s.db.GetHash(); hash != s.replayHead {
+		err := fmt.Errorf("state moved from %x to %x during replay", s.replayHead, hash)
+		s.setError(err)
+		return nil, err
+	}
+	value, ok := s.replay[key]
+	if !ok {
+		var err error
+		if value, err = s.db.Get(key); err != nil {
+			s.setError(err)
+			return nil, err
+		}
+	}
+	s.readSet[key] = common.CopyBytes(value)
+	s.writeSet[key] = common.CopyBytes(value)
+	return common.CopyBytes(value), nil
+}
+
 //RpcGetState provide get value of the key to rpc
 //when called please RLock cachedb
 func (s *StateDB) RpcGetState(account string, key common.Hash) common.Hash {
@@ -287,6 +343,111 @@ func (s *StateDB) RpcGet(account string, key string) ([]byte, error) {
 	return common.CopyBytes(value), nil
 }
 
+// ForEachData calls fn with the data each account stores under key, in key
+// order, until fn returns false. Writes not committed yet are included. It
+// fails if the database has moved to another block since the state was
+// opened.
+func (s *StateDB) ForEachData(key string, fn func(account string, value []byte) bool) error {
+	prefix := acctDataPrefix + linkSymbol
+	account := func(optKey string) (string, bool) { return dataAccount(optKey, key) }
+	emit := func(optKey string, value []byte) bool {
+		// deleted keys have no value
+		if len(value) == 0 {
+			return true
+		}
+		name, _ := account(optKey)
+		return fn(name, value)
+	}
+
+	var pending []string
+	for optKey := range s.writeSet {
+		if _, ok := account(optKey); ok {
+			pending = append(pending, optKey)
+		}
+	}
+	sort.Strings(pending)
+
+	if hash := s.db.GetHash(); hash != s.parentHash {
+		return fmt.Errorf("state moved to block %x, parent: %x", hash, s.parentHash)
+	}
+	it := s.db.GetDB().NewIteratorWithPrefix([]byte(prefix))
+	defer it.Release()
+	for it.Next() {
+		optKey := string(it.Key())
+		if _, ok := account(optKey); !ok {
+			continue
+		}
+		for len(pending) > 0 && pending[0] < optKey {
+			if !emit(pending[0], s.writeSet[pending[0]]) {
+				return nil
+			}
+			pending = pending[1:]
+		}
+		value := it.Value()
+		if len(pending) > 0 && pending[0] == optKey {
+			value = s.writeSet[optKey]
+			pending = pending[1:]
+		}
+		if !emit(optKey, value) {
+			return nil
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	for _, optKey := range pending {
+		if !emit(optKey, s.writeSet[optKey]) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// dataAccount returns the account of a key holding the data an account stores
+// under key, reporting false if optKey is another key.
+func dataAccount(optKey, key string) (string, bool) {
+	prefix, suffix := acctDataPrefix+linkSymbol, linkSymbol+key
+	if !strings.HasPrefix(optKey, prefix) || !strings.HasSuffix(optKey, suffix) {
+		return "", false
+	}
+	name := strings.TrimSuffix(optKey[len(prefix):], suffix)
+	return name, name != "" && !strings.Contains(name, linkSymbol)
+}
+
+// ForEachDataChange calls fn with the data each account stores under key which
+// was changed since the state was opened, with the values before and after,
+// nil if the data didn't exist. The accounts are in no particular order.
+func (s *StateDB) ForEachDataChange(key string, fn func(account string, before, after []byte)) {
+	changed := func(optKey string) {
+		if name, ok := dataAccount(optKey, key); ok {
+			fn(name, s.readSet[optKey], s.writeSet[optKey])
+		}
+	}
+	for optKey := range s.dirtySet {
+		changed(optKey)
+	}
+	for optKey := range s.journal.dirties {
+		if _, ok := s.dirtySet[optKey]; !ok {
+			changed(optKey)
+		}
+	}
+}
+
+// Size returns the approximate memory used by the values read and written.
+func (s *StateDB) Size() common.StorageSize {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var size int
+	for key, value := range s.readSet {
+		size += len(key) + len(value)
+	}
+	for key, value := range s.writeSet {
+		size += len(key) + len(value)
+	}
+	return common.StorageSize(size)
+}
+
 func (s *StateDB) Database() Database {
 	return s.db
 }
@@ -296,22 +457,31 @@ func (s *StateDB) Copy() *StateDB {
 	defer s.lock.Unlock()
 
 	state := &StateDB{db: s.db,
-		readSet:    make(map[string][]byte, len(s.writeSet)),
-		writeSet:   make(map[string][]byte, len(s.writeSet)),
-		dirtySet:   make(map[string]struct{}, len(s.dirtySet)),
-		dirtyHash:  make(map[string]common.Hash),
-		parentHash: s.parentHash,
-		refund:     s.refund,
-		logs:       make(map[common.Hash][]*types.Log, len(s.logs)),
-		logSize:    s.logSize,
-		preimages:  make(map[common.Hash][]byte),
-		journal:    newJournal()}
+		readSet:     make(map[string][]byte, len(s.writeSet)),
+		writeSet:    make(map[string][]byte, len(s.writeSet)),
+		dirtySet:    make(map[string]struct{}, len(s.dirtySet)),
+		dirtyHash:   make(map[string]common.Hash),
+		parentHash:  s.parentHash,
+		refund:      s.refund,
+		logs:        make(map[common.Hash][]*types.Log, len(s.logs)),
+		logSize:     s.logSize,
+		internalTxs: append([]*types.InternalTx(nil), s.internalTxs...),
+		preimages:   make(map[common.Hash][]byte),
+		journal:     newJournal(),
+		replay:      s.replay,
+		replayHead:  s.replayHead}
 
 	for key := range s.journal.dirties {
 		value := s.writeSet[key]
 		state.readSet[key] = common.CopyBytes(value)
 		state.writeSet[key] = common.CopyBytes(value)
 	}
+	// a replayed state keeps the values the replayed blocks wrote
+	if s.replay != nil {
+		for key, value := range s.writeSet {
+			state.writeSet[key] = common.CopyBytes(value)
+		}
+	}
 
 	for hash, logs := range s.logs {
 		state.logs[hash] = make([]*types.Log, len(logs))
@@ -331,16 +501,40 @@ func (s *StateDB) Snapshot() int {
 }
 
 func (s *StateDB) RevertToSnapshot(revid int) {
+	idx := s.revision(revid)
+	snapshot := s.validRevisions[idx].journalIndex
+
+	s.journal.revert(s, snapshot)
+	s.validRevisions = s.validRevisions[:idx]
+}
+
+// revision returns the index of the snapshot revid in the valid revisions.
+func (s *StateDB) revision(revid int) int {
 	idx := sort.Search(len(s.validRevisions), func(i int) bool {
 		return s.validRevisions[i].id >= revid
 	})
 	if idx == len(s.validRevisions) || s.validRevisions[idx].id != revid {
 		panic(fmt.Errorf("revision id %v cannot be reverted", revid))
 	}
-	snapshot := s.validRevisions[idx].journalIndex
+	return idx
+}
 
-	s.journal.revert(s, snapshot)
-	s.validRevisions = s.validRevisions[:idx]
+// ForEachDataChangeSince calls fn like ForEachDataChange with the data changed
+// since the snapshot revid was taken, before being the value at the snapshot.
+func (s *StateDB) ForEachDataChangeSince(revid int, key string, fn func(account string, before, after []byte)) {
+	before := make(map[string][]byte)
+	for _, entry := range s.journal.entries[s.validRevisions[s.revision(revid)].journalIndex:] {
+		if ch, ok := entry.(stateChange); ok {
+			if _, seen := before[*ch.key]; !seen {
+				before[*ch.key] = ch.prevalue
+			}
+		}
+	}
+	for optKey, prev := range before {
+		if name, ok := dataAccount(optKey, key); ok {
+			fn(name, prev, s.writeSet[optKey])
+		}
+	}
 }
 
 //Put account's data to db
@@ -361,6 +555,22 @@ func (s *StateDB) Delete(account string, key string) {
 	s.put(optKey, nil)
 }
 
+// ApplyChanges replays the state changes recorded for a block by another
+// node, without executing its transactions. Only state keys may be changed.
+func (s *StateDB) ApplyChanges(changes []*types.OptInfo) error {
+	for _, change := range changes {
+		if !IsStateKey([]byte(change.Key)) {
+			return fmt.Errorf("invalid state key %x", change.Key)
+		}
+		value := change.Value
+		if change.Opt == optDel {
+			value = nil
+		}
+		s.put(change.Key, value)
+	}
+	return s.Error()
+}
+
 func kvRlpHash(kvNode *types.KvNode) (h common.Hash) {
 	hw := sha3.NewLegacyKeccak256()
 	rlp.Encode(hw, kvNode)
@@ -420,6 +630,12 @@ func (s *StateDB) Prepare(thash, bhash common.Hash, ti int) {
 	s.thash = thash
 	s.bhash = bhash
 	s.txIndex = ti
+	s.actionIndex = 0
+}
+
+// PrepareAction sets the index of the action whose logs are recorded next.
+func (s *StateDB) PrepareAction(ai int) {
+	s.actionIndex = ai
 }
 
 func (s *StateDB) clearJournalAndRefund() {
@@ -525,6 +741,19 @@ func (s *StateDB) Commit(batch fdb.Batch, blockHash common.Hash, blockNum uint64
 	return hash, nil
 }
 
+// WriteCommit writes a batch holding the state commit of a block. A marker is
+// written to db ahead of the batch and removed by it, so a commit interrupted
+// by a crash can be detected and recovered from when the node restarts.
+func WriteCommit(db fdb.Database, batch fdb.Batch, blockHash common.Hash) error {
+	rawdb.WriteStateCommitMarker(db, blockHash)
+	rawdb.DeleteStateCommitMarker(batch)
+	if err := batch.Write(); err != nil {
+		rawdb.DeleteStateCommitMarker(db)
+		return err
+	}
+	return nil
+}
+
 //CommitCache commit the block state to cache
 //call after state commit to db success
 func (s *StateDB) CommitCache(blockHash common.Hash) {
@@ -570,12 +799,11 @@ func recoverCacheByOptInfos(cache Database, optInfos []*types.OptInfo) {
 	}
 }
 
-func writeTransferToDb(db fdb.Database, transInfo *transferInfo, to common.Hash) error {
+func writeTransferToDb(db fdb.Database, batch fdb.Batch, transInfo *transferInfo, to common.Hash) error {
 	var err error
 	var state *types.StateOut
 	rollList := &transInfo.rollBack
 	fwdList := &transInfo.forworad
-	batch := db.NewBatch()
 
 	for node := rollList.Front(); node != nil; node = node.Next() {
 		state = node.Value.(*types.StateOut)
@@ -596,8 +824,7 @@ func writeTransferToDb(db fdb.Database, transInfo *transferInfo, to common.Hash)
 		}
 	}
 	rawdb.WriteOptBlockHash(batch, to)
-	err = batch.Write()
-	return err
+	return WriteCommit(db, batch, to)
 }
 
 func writeTransferToCache(cache Database, transInfo *transferInfo, to common.Hash) {
@@ -671,7 +898,8 @@ func fetchBranch(db fdb.Database, from common.Hash, to common.Hash) (*transferIn
 }
 
 //TransToSpecBlock change block state (from->to)
-func TransToSpecBlock(db fdb.Database, cache Database, from common.Hash, to common.Hash) error {
+//batch: writes of the caller committed atomically with the state
+func TransToSpecBlock(batch fdb.Batch, db fdb.Database, cache Database, from common.Hash, to common.Hash) error {
 	//get near parent hash of from and to
 	transInfo, err := fetchBranch(db, from, to)
 	if err != nil {
@@ -686,7 +914,7 @@ func TransToSpecBlock(db fdb.Database, cache Database, from common.Hash, to comm
 		return errors.New(errInfo)
 	}
 	//exe rollback and forward
-	err = writeTransferToDb(db, transInfo, to)
+	err = writeTransferToDb(db, batch, transInfo, to)
 	if err != nil {
 		return err
 	}
@@ -696,6 +924,68 @@ func TransToSpecBlock(db fdb.Database, cache Database, from common.Hash, to comm
 	return nil
 }
 
+// NewReplay returns the state at the block with the given hash for replaying
+// the blocks above it. It reads the state the database is at, with the
+// changes of the blocks above the block reverted, so the block must be an
+// ancestor of the block the state is at whose state changes haven't been
+// pruned, at most maxDepth blocks of state changes below it, unbounded if 0.
+// The state can't be committed and fails once the database moves to another
+// block.
+//
+// The state changes are read without holding the database lock, and read
+// again if the database moved meanwhile.
+func NewReplay(hash common.Hash, db Database, maxDepth uint64) (*StateDB, error) {
+	for i := 0; ; i++ {
+		db.RLock()
+		head, optHash := db.GetHash(), rawdb.ReadOptBlockHash(db.GetDB())
+		db.RUnLock()
+
+		reverts, err := stateRevertsFrom(db.GetDB(), optHash, hash, maxDepth)
+
+		db.RLock()
+		moved := db.GetHash() != head
+		db.RUnLock()
+		if moved && i < maxReplayRetries {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if moved {
+			return nil, fmt.Errorf("state moved from %x while reading the state changes", head)
+		}
+		return newReplayState(db, hash, head, reverts), nil
+	}
+}
+
+func newReplayState(db Database, hash, head common.Hash, reverts map[string][]byte) *StateDB {
+	return &StateDB{
+		db:         db,
+		parentHash: hash,
+		readSet:    make(map[string][]byte),
+		writeSet:   make(map[string][]byte),
+		dirtySet:   make(map[string]struct{}),
+		logs:       make(map[common.Hash][]*types.Log),
+		preimages:  make(map[common.Hash][]byte),
+		dirtyHash:  make(map[string]common.Hash),
+		journal:    newJournal(),
+		replay:     reverts,
+		replayHead: head}
+}
+
+// NextReplayBlock starts replaying the block above the replayed block with the
+// given hash, on the state the replayed block left.
+func (s *StateDB) NextReplayBlock(blockHash common.Hash) {
+	s.Finalise()
+	s.parentHash = blockHash
+	s.readSet = make(map[string][]byte)
+	s.dirtySet = make(map[string]struct{})
+	s.dirtyHash = make(map[string]common.Hash)
+	s.logs = make(map[common.Hash][]*types.Log)
+	s.logSize = 0
+	s.internalTxs = nil
+}
+
 //TraceNew get state of special block hash for trace
 //blockHash: the hash of block
 func TraceNew(blockHash common.Hash, cache Database) (*StateDB, error) {
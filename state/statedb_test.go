@@ -18,11 +18,15 @@ package state
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/rawdb"
+	"github.com/fractalplatform/fractal/types"
 	"github.com/fractalplatform/fractal/utils/fdb"
 )
 
@@ -136,7 +140,7 @@ func TestTransToSpecBlock1(t *testing.T) {
 
 	from := curHash
 	to := common.BytesToHash([]byte("hash" + strconv.Itoa(1)))
-	err := TransToSpecBlock(db, cachedb, from, to)
+	err := TransToSpecBlock(db.NewBatch(), db, cachedb, from, to)
 
 	if err != nil {
 		t.Error("TransToSpecBlock return fail")
@@ -187,7 +191,7 @@ func TestTransToSpecBlock2(t *testing.T) {
 	batch.Write()
 	state1.CommitCache(curHash1)
 
-	err = TransToSpecBlock(db, cachedb, curHash1, prevHash)
+	err = TransToSpecBlock(db.NewBatch(), db, cachedb, curHash1, prevHash)
 
 	if err != nil {
 		t.Error("Trans to spec block failed ")
@@ -211,7 +215,7 @@ func TestTransToSpecBlock2(t *testing.T) {
 
 	from := curHash
 	to := common.BytesToHash([]byte("hash" + strconv.Itoa(3)))
-	err = TransToSpecBlock(db, cachedb, from, to)
+	err = TransToSpecBlock(db.NewBatch(), db, cachedb, from, to)
 
 	if err != nil {
 		t.Error("TransToSpecBlock return fail")
@@ -257,3 +261,230 @@ func TestStateDB_IntermediateRoot(t *testing.T) {
 	state.IntermediateRoot()
 	fmt.Println("time: ", time.Since(st))
 }
+
+func TestLogActionIndex(t *testing.T) {
+	state, _ := New(common.Hash{}, NewDatabase(fdb.NewMemDatabase()))
+	txHash := common.BytesToHash([]byte("tx"))
+	state.Prepare(txHash, common.Hash{}, 3)
+	state.AddLog(&types.Log{})
+	state.PrepareAction(1)
+	state.AddLog(&types.Log{})
+
+	logs := state.GetLogs(txHash)
+	if len(logs) != 2 {
+		t.Fatalf("log count mismatch: have %d, want 2", len(logs))
+	}
+	for i, log := range logs {
+		if log.TxIndex != 3 || log.ActionIndex != uint(i) || log.Index != uint(i) {
+			t.Errorf("log %d: indexes mismatch: tx %d, action %d, log %d", i, log.TxIndex, log.ActionIndex, log.Index)
+		}
+	}
+}
+
+func TestWriteCommit(t *testing.T) {
+	db := fdb.NewMemDatabase()
+	cachedb := NewDatabase(db)
+	curHash := common.BytesToHash([]byte("1111111"))
+	state, _ := New(common.Hash{}, cachedb)
+	state.SetState("a", common.BytesToHash([]byte("k")), common.BytesToHash([]byte("v")))
+
+	// A commit whose batch is never written leaves the marker and the
+	// previous state behind.
+	if _, err := state.Copy().Commit(db.NewBatch(), curHash, 1); err != nil {
+		t.Fatal(err)
+	}
+	rawdb.WriteStateCommitMarker(db, curHash)
+	if hash := NewDatabase(db).GetHash(); hash != (common.Hash{}) {
+		t.Fatalf("state hash after interrupted commit: have %x, want empty", hash)
+	}
+
+	batch := db.NewBatch()
+	if _, err := state.Commit(batch, curHash, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCommit(db, batch, curHash); err != nil {
+		t.Fatal(err)
+	}
+	if marker := rawdb.ReadStateCommitMarker(db); marker != (common.Hash{}) {
+		t.Fatalf("state commit marker left behind: %x", marker)
+	}
+	if hash := NewDatabase(db).GetHash(); hash != curHash {
+		t.Fatalf("state hash mismatch: have %x, want %x", hash, curHash)
+	}
+}
+
+func TestCacheLimit(t *testing.T) {
+	db := fdb.NewMemDatabase()
+	cachedb := NewDatabaseWithCache(db, 1).(*cachingDB)
+
+	value := make([]byte, 64*1024)
+	for i := 0; i < 32; i++ {
+		if err := cachedb.Put(strconv.Itoa(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cachedb.cacheSize > cachedb.cacheLimit {
+		t.Fatalf("cache over its allowance: have %d bytes, limit %d", cachedb.cacheSize, cachedb.cacheLimit)
+	}
+	if cachedb.kvCache.Contains("0") || !cachedb.kvCache.Contains("31") {
+		t.Fatal("cache didn't evict the least recently used values")
+	}
+	// Evicted values are read from the database.
+	if v, err := cachedb.Get("0"); err != nil || len(v) != len(value) {
+		t.Fatalf("evicted value mismatch: have %d bytes, err %v", len(v), err)
+	}
+
+	cachedb.Purge()
+	if cachedb.cacheSize != 0 {
+		t.Fatalf("purged cache size mismatch: have %d, want 0", cachedb.cacheSize)
+	}
+}
+
+func TestPruneStateOuts(t *testing.T) {
+	db := fdb.NewMemDatabase()
+	hash := func(n, branch int) common.Hash { return common.BytesToHash([]byte{byte(branch), byte(n)}) }
+	for i := 0; i < 10; i++ {
+		rawdb.WriteBlockStateOut(db, hash(i, 0), &types.StateOut{Number: uint64(i), Hash: hash(i, 0)})
+	}
+	rawdb.WriteBlockStateOut(db, hash(3, 1), &types.StateOut{Number: 3, Hash: hash(3, 1)})
+	head := &types.Header{Number: big.NewInt(9)}
+	rawdb.WriteHeader(db, head)
+	rawdb.WriteHeadBlockHash(db, head.Hash())
+	rawdb.WriteOptBlockHash(db, hash(9, 0))
+
+	// A resumed pruning keeps the block number of the interrupted one.
+	rawdb.WriteStatePruneProgress(db, &rawdb.StatePruneProgress{Number: 6})
+	var checked, pruned int
+	if err := PruneStateOuts(db, 4, 0, func(c, p int) { checked, pruned = c, p }); err != nil {
+		t.Fatal(err)
+	}
+	if checked != 11 || pruned != 7 {
+		t.Fatalf("pruning mismatch: checked %d pruned %d, want 11 and 7", checked, pruned)
+	}
+	for i := 0; i < 10; i++ {
+		if have := rawdb.ReadBlockStateOut(db, hash(i, 0)) != nil; have != (i >= 6) {
+			t.Errorf("block %d: state changes kept %v", i, have)
+		}
+	}
+	if rawdb.ReadStatePruneProgress(db) != nil {
+		t.Error("prune progress left behind")
+	}
+
+	// Without progress the blocks below head - keep are pruned.
+	if err := PruneStateOuts(db, 2, 0, func(c, p int) { checked, pruned = c, p }); err != nil {
+		t.Fatal(err)
+	}
+	if checked != 4 || pruned != 1 {
+		t.Fatalf("pruning mismatch: checked %d pruned %d, want 4 and 1", checked, pruned)
+	}
+}
+
+func TestPruneStateOutsInterval(t *testing.T) {
+	db := fdb.NewMemDatabase()
+	cachedb := NewDatabase(db)
+	addr := "addr01"
+	key := common.BytesToHash([]byte("sk"))
+	value := func(i int) common.Hash { return common.BytesToHash([]byte("sv" + strconv.Itoa(i))) }
+
+	var hashes []common.Hash
+	parent := common.Hash{}
+	for i := 0; i < 12; i++ {
+		header := &types.Header{ParentHash: parent, Number: big.NewInt(int64(i))}
+		rawdb.WriteHeader(db, header)
+		rawdb.WriteCanonicalHash(db, header.Hash(), uint64(i))
+		state, _ := New(parent, cachedb)
+		state.SetState(addr, key, value(i))
+		state.SetState(addr, common.BytesToHash([]byte("sk"+strconv.Itoa(i))), value(i))
+		batch := db.NewBatch()
+		if _, err := state.Commit(batch, header.Hash(), uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+		batch.Write()
+		state.CommitCache(header.Hash())
+		parent = header.Hash()
+		hashes = append(hashes, parent)
+	}
+	rawdb.WriteHeadBlockHash(db, parent)
+
+	var pruned int
+	if err := PruneStateOuts(db, 3, 4, func(c, p int) { pruned = p }); err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 6 {
+		t.Fatalf("pruned %d blocks, want 6", pruned)
+	}
+	for i, hash := range hashes {
+		if have := rawdb.ReadBlockStateOut(db, hash) != nil; have != (i%4 == 0 || i >= 8) {
+			t.Errorf("block %d: state changes kept %v", i, have)
+		}
+	}
+
+	// The state is readable at the retained blocks only.
+	for _, i := range []int{0, 4, 8} {
+		state, err := NewReplay(hashes[i], cachedb, 0)
+		if err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+		if have := state.GetState(addr, key); have != value(i) {
+			t.Errorf("block %d: value %x, want %x", i, have, value(i))
+		}
+		if have := state.GetState(addr, common.BytesToHash([]byte("sk"+strconv.Itoa(i+1)))); have != (common.Hash{}) {
+			t.Errorf("block %d: value %x of a later block", i, have)
+		}
+	}
+	if _, err := NewReplay(hashes[5], cachedb, 0); err == nil {
+		t.Error("state of a pruned block readable")
+	}
+	// The blocks 11 to 8 are reverted for the state of block 4.
+	if _, err := NewReplay(hashes[4], cachedb, 3); err == nil {
+		t.Error("state readable beyond the revert limit")
+	}
+	if _, err := NewReplay(hashes[4], cachedb, 4); err != nil {
+		t.Errorf("state within the revert limit unreadable: %v", err)
+	}
+
+	// A replayed block's changes are seen by the blocks above.
+	state, _ := NewReplay(hashes[4], cachedb, 0)
+	state.SetState(addr, key, value(5))
+	state.NextReplayBlock(hashes[5])
+	if have := state.Copy().GetState(addr, key); have != value(5) {
+		t.Errorf("replayed value %x, want %x", have, value(5))
+	}
+
+	// The state fails once the database moves.
+	next, _ := New(parent, cachedb)
+	next.SetState(addr, key, value(12))
+	batch := db.NewBatch()
+	if _, err := next.Commit(batch, common.BytesToHash([]byte("hash12")), 12); err != nil {
+		t.Fatal(err)
+	}
+	batch.Write()
+	next.CommitCache(common.BytesToHash([]byte("hash12")))
+	state.GetState(addr, common.BytesToHash([]byte("sk3")))
+	if state.Error() == nil {
+		t.Error("replayed state readable after the database moved")
+	}
+}
+
+func TestIsStateKey(t *testing.T) {
+	hash := common.BytesToHash([]byte("T*" + strings.Repeat("h", 30)))
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{statePrefix + linkSymbol + "acct" + linkSymbol + "key", true},
+		{acctDataPrefix + linkSymbol + "acct" + linkSymbol + "key", true},
+		// account data keys as long as the block state change keys
+		{acctDataPrefix + linkSymbol + "acct" + linkSymbol + strings.Repeat("k", 25), true},
+		// the state changes of a block whose hash starts with "T*"
+		{"S" + string(hash[:]), false},
+		{statePrefix + linkSymbol + "acct" + linkSymbol, false},
+		{statePrefix + linkSymbol + linkSymbol + "key", false},
+		{"LastBlock", false},
+	}
+	for _, test := range tests {
+		if got := IsStateKey([]byte(test.key)); got != test.want {
+			t.Errorf("IsStateKey(%q) = %v, want %v", test.key, got, test.want)
+		}
+	}
+}
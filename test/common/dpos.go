@@ -155,6 +155,34 @@ func (acc *Account) UpdateProducer(to common.Name, value *big.Int, id uint64, ga
 	return rawtx
 }
 
+// UpdateProducerKey
+func (acc *Account) UpdateProducerKey(to common.Name, value *big.Int, id uint64, gas uint64, key common.PubKey) []byte {
+	arg := &args.UpdateProducerKey{
+		Key: key,
+	}
+	payload, err := rlp.EncodeToBytes(arg)
+	if err != nil {
+		panic(err)
+	}
+	if acc.getnonce != nil {
+		acc.nonce = acc.getnonce(acc.name)
+	}
+	action := types.NewAction(types.UpdateProducerKey, acc.name, to, acc.nonce, id, gas, value, payload)
+	if acc.getnonce == nil {
+		acc.nonce++
+	}
+
+	tx := types.NewTransaction(acc.feeid, big.NewInt(1e10), []*types.Action{action}...)
+	if err := types.SignAction(action, tx, signer, acc.priv); err != nil {
+		panic(err)
+	}
+	rawtx, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		panic(err)
+	}
+	return rawtx
+}
+
 // UnRegProducer
 func (acc *Account) UnRegProducer(to common.Name, value *big.Int, id uint64, gas uint64) []byte {
 	if acc.getnonce != nil {
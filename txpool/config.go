@@ -32,7 +32,10 @@ type Config struct {
 	AccountQueue uint64 `mapstructure:"txpool-accountqueue"` // Maximum number of non-executable transaction slots permitted per account
 	GlobalQueue  uint64 `mapstructure:"txpool-globalqueue"`  // Maximum number of non-executable transaction slots for all accounts
 
-	Lifetime time.Duration `mapstructure:"txpool-lifetime"` // Maximum amount of time non-executable transaction are queued
+	Lifetime        time.Duration `mapstructure:"txpool-lifetime"`        // Maximum amount of time non-executable transaction are queued
+	PendingLifetime time.Duration `mapstructure:"txpool-pendinglifetime"` // Maximum amount of time any transaction stays in the pool, 0 for no limit
+
+	DryRun bool `mapstructure:"txpool-dryrun"` // Whether to execute executable transactions on the head state before accepting them
 
 	GasAssetID uint64
 }
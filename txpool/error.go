@@ -56,8 +56,32 @@ var (
 	// transaction with a negative value.
 	ErrNegativeValue = errors.New("negative value")
 
+	// ErrInvalidGasAsset is returned if a transaction pays gas in another asset
+	// than the one configured for the pool.
+	ErrInvalidGasAsset = errors.New("invalid gas asset")
+
 	// ErrOversizedData is returned if the input data of a transaction is greater
 	// than some meaningful limit a user might use. This is not a consensus error
 	// making the transaction invalid, rather a DOS protection.
 	ErrOversizedData = errors.New("oversized data")
+
+	// ErrTooManyActions is returned if a transaction holds no actions or more
+	// than a block may hold in a transaction.
+	ErrTooManyActions = errors.New("invalid number of actions")
+
+	// ErrUnknownExtension is returned if an action carries an extension the
+	// protocol does not know after strict extensions are activated.
+	ErrUnknownExtension = errors.New("unknown action extension")
+
+	// ErrActionDisabled is returned if an action is of a type the next block
+	// doesn't enable yet.
+	ErrActionDisabled = errors.New("action type not enabled")
+
+	// ErrDryRunFailed is returned if a transaction executed on the head state
+	// couldn't be included in the next block.
+	ErrDryRunFailed = errors.New("transaction dry-run failed")
+
+	// ErrUnderMinGasPrice is returned if a transaction's gas price is below the
+	// minimum governed for the type of one of its actions.
+	ErrUnderMinGasPrice = errors.New("gas price under the minimum of the action type")
 )
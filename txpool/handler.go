@@ -17,49 +17,219 @@
 package txpool
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/fractalplatform/fractal/common"
 	router "github.com/fractalplatform/fractal/event"
 	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/supervisor"
+	"github.com/hashicorp/golang-lru"
 )
 
-type TxpoolStation struct {
+const (
+	maxKnownTxs    = 32768           // Maximum transactions hashes to keep in the known list per peer
+	maxTxFetch     = 256             // Maximum transactions requested in a single message
+	txFetchTimeout = 5 * time.Second // Time after which an unanswered fetch may be retried
+)
+
+// txPeer is a remote station along with the transactions it is known to have.
+type txPeer struct {
 	station router.Station
-	txChan  chan *router.Event
-	txpool  *TxPool
+	known   *lru.Cache
+}
+
+func newTxPeer(station router.Station) *txPeer {
+	known, _ := lru.New(maxKnownTxs)
+	return &txPeer{station: station, known: known}
+}
+
+// markKnown marks the transactions as known by the peer, evicting the least
+// recently marked ones once the limit is reached.
+func (p *txPeer) markKnown(hashes ...common.Hash) {
+	for _, hash := range hashes {
+		p.known.Add(hash, nil)
+	}
+}
+
+// TxpoolStation propagates transactions between peers. New transactions are
+// announced by hash only, peers request the bodies they don't know yet.
+type TxpoolStation struct {
+	station   router.Station
+	router    *router.Router // router of the node, the default one if nil
+	txChan    chan *router.Event
+	txpool    *TxPool
+	peers     map[string]*txPeer
+	requested map[common.Hash]time.Time // transactions fetched but not delivered yet
+	subs      []router.Subscription
+}
+
+func init() {
+	router.RegisterPayload(router.TxMsg, 0, []*types.Transaction{}, nil)
+	router.RegisterPayload(router.TxHashMsg, 0, []common.Hash{}, nil)
+	router.RegisterPayload(router.GetTxsMsg, 0, []common.Hash{}, validateGetTxs)
+}
+
+// validateGetTxs checks that a request asks for no more transactions than
+// are fetched in a single message.
+func validateGetTxs(data interface{}) error {
+	if n := len(data.([]common.Hash)); n > maxTxFetch {
+		return fmt.Errorf("request of %d transactions", n)
+	}
+	return nil
 }
 
 func NewTxpoolStation(txpool *TxPool) *TxpoolStation {
 	station := &TxpoolStation{
-		station: router.NewLocalStation("txpool", nil),
-		txChan:  make(chan *router.Event),
-		txpool:  txpool,
+		station:   router.NewLocalStation("txpool", nil),
+		router:    txpool.chain.Router(),
+		txChan:    make(chan *router.Event),
+		txpool:    txpool,
+		peers:     make(map[string]*txPeer),
+		requested: make(map[common.Hash]time.Time),
 	}
-	router.Subscribe(nil, station.txChan, router.TxMsg, []*types.Transaction{})
-	router.Subscribe(nil, station.txChan, router.P2pNewPeer, nil)
-	go station.handleMsg()
+	station.subs = append(station.subs,
+		station.router.Subscribe(nil, station.txChan, router.TxMsg, []*types.Transaction{}),
+		station.router.Subscribe(nil, station.txChan, router.TxHashMsg, []common.Hash{}),
+		station.router.Subscribe(nil, station.txChan, router.GetTxsMsg, []common.Hash{}),
+		station.router.Subscribe(nil, station.txChan, router.TxEv, []*types.Transaction{}),
+		station.router.Subscribe(nil, station.txChan, router.P2pNewPeer, nil),
+		station.router.Subscribe(nil, station.txChan, router.P2pDelPeer, nil),
+	)
+	supervisor.Go("txpool station", txpool.quit, station.handleMsg)
 	return station
 }
 
 func (s *TxpoolStation) handleMsg() {
+	cleanup := time.NewTicker(txFetchTimeout)
+	defer cleanup.Stop()
 	for {
-		e := <-s.txChan
-		switch e.Typecode {
-		case router.TxMsg:
-			txs := e.Data.([]*types.Transaction)
-			s.txpool.AddRemotes(txs)
-		case router.P2pNewPeer:
-			go s.syncTransactions(e)
+		select {
+		case <-s.txpool.quit:
+			for _, sub := range s.subs {
+				sub.Unsubscribe()
+			}
+			return
+		case e := <-s.txChan:
+			s.handleEvent(e)
+		case now := <-cleanup.C:
+			for hash, t := range s.requested {
+				if now.Sub(t) > txFetchTimeout {
+					delete(s.requested, hash)
+				}
+			}
+		}
+	}
+}
+
+func (s *TxpoolStation) handleEvent(e *router.Event) {
+	switch e.Typecode {
+	case router.P2pNewPeer:
+		peer := newTxPeer(e.From)
+		s.peers[peerKey(e.From)] = peer
+		s.syncTransactions(peer)
+	case router.P2pDelPeer:
+		delete(s.peers, peerKey(e.From))
+	case router.TxEv:
+		s.announce(e.Data.([]*types.Transaction))
+	case router.TxHashMsg:
+		s.fetch(e.From, e.Data.([]common.Hash))
+	case router.GetTxsMsg:
+		hashes := e.Data.([]common.Hash)
+		txs := make([]*types.Transaction, 0, len(hashes))
+		for _, hash := range hashes {
+			if tx := s.txpool.Get(hash); tx != nil {
+				txs = append(txs, tx)
+			}
+		}
+		if peer := s.peers[peerKey(e.From)]; peer != nil {
+			for _, tx := range txs {
+				peer.markKnown(tx.Hash())
+			}
+		}
+		if len(txs) > 0 {
+			go s.router.SendTo(nil, e.From, router.TxMsg, txs)
+		}
+	case router.TxMsg:
+		txs := e.Data.([]*types.Transaction)
+		peer := s.peers[peerKey(e.From)]
+		for _, tx := range txs {
+			delete(s.requested, tx.Hash())
+			if peer != nil {
+				peer.markKnown(tx.Hash())
+			}
+		}
+		s.txpool.AddRemotes(txs)
+	}
+}
+
+// announce sends the hashes of new transactions to every peer which is not
+// known to have them.
+func (s *TxpoolStation) announce(txs []*types.Transaction) {
+	for _, peer := range s.peers {
+		var hashes []common.Hash
+		for _, tx := range txs {
+			if hash := tx.Hash(); !peer.known.Contains(hash) {
+				hashes = append(hashes, hash)
+			}
+		}
+		if len(hashes) == 0 {
+			continue
+		}
+		peer.markKnown(hashes...)
+		go s.router.SendTo(nil, peer.station, router.TxHashMsg, hashes)
+	}
+}
+
+// fetch requests the announced transactions which are neither in the pool nor
+// already requested from another peer.
+func (s *TxpoolStation) fetch(from router.Station, hashes []common.Hash) {
+	peer := s.peers[peerKey(from)]
+	if peer != nil {
+		peer.markKnown(hashes...)
+	}
+	now := time.Now()
+	unknown := make([]common.Hash, 0, len(hashes))
+	for _, hash := range hashes {
+		if _, ok := s.requested[hash]; ok || s.txpool.Get(hash) != nil {
+			continue
+		}
+		s.requested[hash] = now
+		unknown = append(unknown, hash)
+	}
+	for len(unknown) > 0 {
+		n := len(unknown)
+		if n > maxTxFetch {
+			n = maxTxFetch
 		}
+		go s.router.SendTo(nil, from, router.GetTxsMsg, unknown[:n])
+		unknown = unknown[n:]
 	}
 }
 
-func (s *TxpoolStation) syncTransactions(e *router.Event) {
-	var txs []*types.Transaction
+// syncTransactions announces all pending transactions to a new peer.
+func (s *TxpoolStation) syncTransactions(peer *txPeer) {
+	var hashes []common.Hash
 	pending, _ := s.txpool.Pending()
 	for _, batch := range pending {
-		txs = append(txs, batch...)
+		for _, tx := range batch {
+			hashes = append(hashes, tx.Hash())
+		}
 	}
-	if len(txs) == 0 {
+	if len(hashes) == 0 {
 		return
 	}
-	router.SendTo(nil, e.From, router.TxMsg, txs)
+	peer.markKnown(hashes...)
+	go s.router.SendTo(nil, peer.station, router.TxHashMsg, hashes)
+}
+
+// peerKey returns the name of the peer a remote station belongs to. Remote
+// station names start with the eight byte peer id, followed by the name of
+// the sending station.
+func peerKey(station router.Station) string {
+	name := station.Name()
+	if len(name) > 8 {
+		return name[:8]
+	}
+	return name
 }
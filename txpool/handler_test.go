@@ -0,0 +1,59 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package txpool
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestTxPeerKnownLimit(t *testing.T) {
+	peer := newTxPeer(nil)
+	hash := func(i int) common.Hash { return common.BigToHash(big.NewInt(int64(i))) }
+	for i := 0; i < maxKnownTxs; i++ {
+		peer.markKnown(hash(i))
+	}
+	// Marking a hash again keeps it the longest.
+	peer.markKnown(hash(0))
+	for i := maxKnownTxs; i < maxKnownTxs+10; i++ {
+		peer.markKnown(hash(i))
+	}
+	if n := peer.known.Len(); n != maxKnownTxs {
+		t.Fatalf("known set size mismatch: have %d, want %d", n, maxKnownTxs)
+	}
+	for i := 1; i <= 10; i++ {
+		if peer.known.Contains(hash(i)) {
+			t.Fatalf("hash %d not evicted first", i)
+		}
+	}
+	for _, i := range []int{0, 11, maxKnownTxs + 9} {
+		if !peer.known.Contains(hash(i)) {
+			t.Fatalf("hash %d evicted", i)
+		}
+	}
+}
+
+func TestValidateGetTxs(t *testing.T) {
+	if err := validateGetTxs(make([]common.Hash, maxTxFetch)); err != nil {
+		t.Fatalf("request of %d transactions rejected: %v", maxTxFetch, err)
+	}
+	if err := validateGetTxs(make([]common.Hash, maxTxFetch+1)); err == nil {
+		t.Fatalf("request of %d transactions accepted", maxTxFetch+1)
+	}
+}
@@ -28,19 +28,16 @@ import (
 // the executable/pending queue; and for storing gapped transactions for the non-
 // executable/future queue, with minor behavioral changes.
 type txList struct {
-	strict  bool         // Whether nonces are strictly continuous or not
-	txs     *txSortedMap // Heap indexed sorted hash map of the transactions
-	costcap *big.Int     // Price of the highest costing transaction (reset only if exceeds balance)
-	gascap  uint64       // Gas limit of the highest spending transaction (reset only if exceeds block limit)
+	strict bool         // Whether nonces are strictly continuous or not
+	txs    *txSortedMap // Heap indexed sorted hash map of the transactions
 }
 
 // newTxList create a new transaction list for maintaining nonce-indexable fast,
 // gapped, sortable transaction lists.
 func newTxList(strict bool) *txList {
 	return &txList{
-		strict:  strict,
-		txs:     newTxSortedMap(),
-		costcap: new(big.Int),
+		strict: strict,
+		txs:    newTxSortedMap(),
 	}
 }
 
@@ -69,16 +66,6 @@ func (l *txList) Add(tx *types.Transaction, priceBump uint64) (bool, *types.Tran
 
 	// Otherwise overwrite the old transaction with the current one
 	l.txs.Put(tx)
-
-	if cost := tx.Cost(); l.costcap.Cmp(cost) < 0 {
-
-		l.costcap = cost
-	}
-
-	// todo change action
-	if gas := tx.GetActions()[0].Gas(); l.gascap < gas {
-		l.gascap = gas
-	}
 	return true, old
 }
 
@@ -89,22 +76,15 @@ func (l *txList) Forward(threshold uint64) []*types.Transaction {
 	return l.txs.Forward(threshold)
 }
 
-// Filter removes all transactions from the list with a cost or gas limit higher
-// than the provided thresholds. Every removed transaction is returned for any
-// post-removal maintenance. Strict-mode invalidated transactions are also
-// returned.
-func (l *txList) Filter(costLimit *big.Int, gasLimit uint64) ([]*types.Transaction, []*types.Transaction) {
-	// If all transactions are below the threshold, short circuit
-	if l.costcap.Cmp(costLimit) <= 0 && l.gascap <= gasLimit {
-		return nil, nil
-	}
-	l.costcap = new(big.Int).Set(costLimit) // Lower the caps to the thresholds
-	l.gascap = gasLimit
-
+// Filter removes all transactions from the list the account can't pay for or
+// with a gas limit higher than the provided threshold. Every removed
+// transaction is returned for any post-removal maintenance. Strict-mode
+// invalidated transactions are also returned.
+func (l *txList) Filter(unpayable func(*types.Transaction) bool, gasLimit uint64) ([]*types.Transaction, []*types.Transaction) {
 	// Filter out all the transactions above the account's funds
 	removed := l.txs.Filter(func(tx *types.Transaction) bool {
 		// todo change action
-		return tx.Cost().Cmp(costLimit) > 0 || tx.GetActions()[0].Gas() > gasLimit
+		return tx.GetActions()[0].Gas() > gasLimit || unpayable(tx)
 	})
 
 	// If the list was strict, filter anything above the lowest nonce
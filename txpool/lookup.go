@@ -18,6 +18,7 @@ package txpool
 
 import (
 	"sync"
+	"time"
 
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/types"
@@ -33,14 +34,16 @@ import (
 // peeking into the pool in TxPool.Get without having to acquire the widely scoped
 // TxPool.mu mutex.
 type txLookup struct {
-	all  map[common.Hash]*types.Transaction
-	lock sync.RWMutex
+	all   map[common.Hash]*types.Transaction
+	added map[common.Hash]time.Time // time each transaction entered the pool
+	lock  sync.RWMutex
 }
 
 // newTxLookup returns a new txLookup structure.
 func newTxLookup() *txLookup {
 	return &txLookup{
-		all: make(map[common.Hash]*types.Transaction),
+		all:   make(map[common.Hash]*types.Transaction),
+		added: make(map[common.Hash]time.Time),
 	}
 }
 
@@ -78,6 +81,7 @@ func (t *txLookup) Add(tx *types.Transaction) {
 	defer t.lock.Unlock()
 
 	t.all[tx.Hash()] = tx
+	t.added[tx.Hash()] = time.Now()
 }
 
 // Remove removes a transaction from the lookup.
@@ -86,4 +90,20 @@ func (t *txLookup) Remove(hash common.Hash) {
 	defer t.lock.Unlock()
 
 	delete(t.all, hash)
+	delete(t.added, hash)
+}
+
+// AddedBefore returns the hashes of the transactions which entered the pool
+// before the time.
+func (t *txLookup) AddedBefore(cutoff time.Time) []common.Hash {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	var hashes []common.Hash
+	for hash, added := range t.added {
+		if added.Before(cutoff) {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
 }
@@ -24,13 +24,14 @@ import (
 	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
 	am "github.com/fractalplatform/fractal/accountmanager"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/event"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/params"
 	"github.com/fractalplatform/fractal/state"
 	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/supervisor"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
 
@@ -42,6 +43,10 @@ var (
 const (
 	// chainHeadChanSize is the size of channel listening to ChainHeadEvent.
 	chainHeadChanSize = 10
+
+	// conflictChanSize is the number of the conflicts waiting to be posted,
+	// the ones reported past it are dropped.
+	conflictChanSize = 64
 )
 
 // TxStatus is the current status of a transaction as seen by the tp.
@@ -60,19 +65,34 @@ type blockChain interface {
 	CurrentBlock() *types.Block
 	GetBlock(hash common.Hash, number uint64) *types.Block
 	StateAt(root common.Hash) (*state.StateDB, error)
+	SubscribeChainHeadEvent(ch chan<- *types.Block) event.Subscription
+	Router() *event.Router
+}
+
+// TxExpiredEvent is posted when a transaction is dropped from the pool
+// because it stayed in it longer than the pending lifetime.
+type TxExpiredEvent struct {
+	Hash   common.Hash
+	Reason string
 }
 
+// TxExecutor executes a transaction on a state as the producer of the block of
+// the header would, returning an error if the block can't include it.
+type TxExecutor func(statedb *state.StateDB, header *types.Header, tx *types.Transaction) error
+
 // TxPool contains all currently known transactions.
 type TxPool struct {
 	config                Config
 	gasPrice              *big.Int
 	chain                 blockChain
 	signer                types.Signer
-	chainHeadCh           chan *event.Event
+	chainconfig           *params.ChainConfig
+	chainHeadCh           chan *types.Block
 	chainHeadSub          event.Subscription
 	curAccountManager     *am.AccountManager
 	pendingAccountManager *am.AccountManager
 	currentMaxGas         uint64      // Current gas limit for transaction caps
+	maxActions            uint64      // Maximum number of actions in a transaction
 	locals                *accountSet // Set of local transaction to exempt from eviction rules
 	journal               *txJournal  // Journal of local transaction to back up to disk
 	pending               map[common.Name]*txList
@@ -80,9 +100,13 @@ type TxPool struct {
 	beats                 map[common.Name]time.Time // Last heartbeat from each known account
 	all                   *txLookup                 // All transactions to allow lookups
 	priced                *txPricedList
+	executor              TxExecutor             // dry-runs transactions if set and enabled
+	headState             *state.StateDB         // state of the head block, copied by the dry-runs
+	conflictCh            chan *types.TxConflict // conflicts waiting to be posted by postConflicts
 
-	mu sync.RWMutex
-	wg sync.WaitGroup // for shutdown sync
+	mu   sync.RWMutex
+	wg   sync.WaitGroup // for shutdown sync
+	quit chan struct{}  // stops restarting the loop after a panic
 }
 
 // New creates a new transaction pool to gather, sort and filter inbound
@@ -95,13 +119,17 @@ func New(config Config, chainconfig *params.ChainConfig, bc blockChain) *TxPool
 		chain:       bc,
 		signer:      signer,
 		locals:      newAccountSet(signer),
-		chainHeadCh: make(chan *event.Event, chainHeadChanSize),
+		chainHeadCh: make(chan *types.Block, chainHeadChanSize),
+		conflictCh:  make(chan *types.TxConflict, conflictChanSize),
 		pending:     make(map[common.Name]*txList),
 		queue:       make(map[common.Name]*txList),
 		beats:       make(map[common.Name]time.Time),
 		all:         all,
 		priced:      newTxPricedList(all),
 		gasPrice:    new(big.Int).SetUint64(config.PriceLimit),
+		quit:        make(chan struct{}),
+		maxActions:  chainconfig.BlockLimits.TxActions(),
+		chainconfig: chainconfig,
 	}
 	tp.reset(nil, bc.CurrentBlock().Header())
 
@@ -117,21 +145,37 @@ func New(config Config, chainconfig *params.ChainConfig, bc blockChain) *TxPool
 	}
 
 	// Subscribe feeds from blockchain
-	tp.chainHeadSub = event.Subscribe(nil, tp.chainHeadCh, event.ChainHeadEv, &types.Block{})
+	tp.chainHeadSub = bc.SubscribeChainHeadEvent(tp.chainHeadCh)
 
 	NewTxpoolStation(tp)
 	// Start the feed loop and return
 	tp.wg.Add(1)
-	go tp.loop()
+	go func() {
+		defer tp.wg.Done()
+		supervisor.Run("txpool", tp.quit, tp.loop)
+	}()
+	tp.wg.Add(1)
+	go tp.postConflicts()
 	return tp
 }
 
+// postConflicts posts the reported conflicts one by one until the pool stops.
+func (tp *TxPool) postConflicts() {
+	defer tp.wg.Done()
+	for {
+		select {
+		case conflict := <-tp.conflictCh:
+			tp.chain.Router().SendEvent(&event.Event{Typecode: event.TxConflictEv, Data: conflict})
+		case <-tp.quit:
+			return
+		}
+	}
+}
+
 // loop is the transaction pool's main feed loop, waiting for and reacting to
 // outside blockchain feeds as well as for various reporting and transaction
 // eviction feeds.
 func (tp *TxPool) loop() {
-	defer tp.wg.Done()
-
 	// Start the stats reporting and transaction eviction tickers
 	var prevPending, prevQueued, prevStales int
 
@@ -151,13 +195,10 @@ func (tp *TxPool) loop() {
 	for {
 		select {
 		// Handle ChainHeadfeed
-		case ev := <-tp.chainHeadCh:
-			block := ev.Data.(*types.Block)
+		case block := <-tp.chainHeadCh:
 			if block != nil {
-				tp.mu.Lock()
-				tp.reset(head.Header(), block.Header())
+				tp.lockedReset(head.Header(), block.Header())
 				head = block
-				tp.mu.Unlock()
 			}
 			// Be unsubscribed due to system stopped
 		case <-tp.chainHeadSub.Err():
@@ -176,36 +217,50 @@ func (tp *TxPool) loop() {
 
 			// Handle inactive account transaction eviction
 		case <-evict.C:
-			tp.mu.Lock()
-			for addr := range tp.queue {
-				// Skip local transactions from the eviction mechanism
-				if tp.locals.contains(addr) {
-					continue
-				}
-				// Any non-locals old enough should be removed
-				if time.Since(tp.beats[addr]) > tp.config.Lifetime {
-					for _, tx := range tp.queue[addr].Flatten() {
-						tp.removeTx(tx.Hash(), true)
-					}
-				}
-			}
-			tp.mu.Unlock()
+			tp.evict()
 
 			// Handle local transaction journal rotation
 		case <-journal.C:
 			if tp.journal != nil {
-				tp.mu.Lock()
-				if err := tp.journal.rotate(tp.local()); err != nil {
-					log.Warn("Failed to rotate local tx journal", "err", err)
-				}
-				tp.mu.Unlock()
+				tp.rotateJournal()
 			}
 		}
 	}
 }
 
+// evict drops the queued transactions of the inactive remote accounts and the
+// transactions staying in the pool longer than the pending lifetime.
+func (tp *TxPool) evict() {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	for addr := range tp.queue {
+		// Skip local transactions from the eviction mechanism
+		if tp.locals.contains(addr) {
+			continue
+		}
+		// Any non-locals old enough should be removed
+		if time.Since(tp.beats[addr]) > tp.config.Lifetime {
+			for _, tx := range tp.queue[addr].Flatten() {
+				tp.removeTx(tx.Hash(), true)
+			}
+		}
+	}
+	tp.expire(time.Now())
+}
+
+// rotateJournal regenerates the journal from the local transactions.
+func (tp *TxPool) rotateJournal() {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	if err := tp.journal.rotate(tp.local()); err != nil {
+		log.Warn("Failed to rotate local tx journal", "err", err)
+	}
+}
+
 // lockedReset is a wrapper around reset to allow calling it in a thread safe
-// manner. This method is only ever used in the tester!
+// manner, keeping the pool unlocked if the reset panics.
 func (tp *TxPool) lockedReset(oldHead, newHead *types.Header) {
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
@@ -281,6 +336,7 @@ func (tp *TxPool) reset(oldHead, newHead *types.Header) {
 		log.Error("Failed to create pending  NewAccountManager state", "err", err)
 		return
 	}
+	tp.headState = statedb.Copy()
 	tp.currentMaxGas = newHead.GasLimit
 	// Inject any transactions discarded due to reorgs
 	log.Debug("Reinjecting stale transactions", "count", len(reinject))
@@ -320,6 +376,7 @@ func (tp *TxPool) reset(oldHead, newHead *types.Header) {
 func (tp *TxPool) Stop() {
 	// Unsubscribe subscriptions registered from blockchain
 	tp.chainHeadSub.Unsubscribe()
+	close(tp.quit)
 	tp.wg.Wait()
 
 	if tp.journal != nil {
@@ -428,12 +485,13 @@ func (tp *TxPool) local() map[common.Name][]*types.Transaction {
 // validateTx checks whether a transaction is valid according to the consensus
 // rules and adheres to some heuristic limits of the local node (price and size).
 func (tp *TxPool) validateTx(tx *types.Transaction, local bool) error {
+	var staked bool
 	validateAction := func(tx *types.Transaction, action *types.Action) error {
 		from := action.Sender()
 
 		// Drop non-local transactions under our own minimal accepted gas price
 		local = local || tp.locals.contains(from) // account may be local even if the transaction arrived from the network
-		if !local && tp.gasPrice.Cmp(tx.GasPrice()) > 0 {
+		if !local && !staked && tp.gasPrice.Cmp(tx.GasPrice()) > 0 {
 			return ErrUnderpriced
 		}
 		// Ensure the transaction adheres to nonce ordering
@@ -452,7 +510,10 @@ func (tp *TxPool) validateTx(tx *types.Transaction, local bool) error {
 			return err
 		}
 
-		gascost := new(big.Int).Mul(tx.GasPrice(), new(big.Int).SetUint64(action.Gas()))
+		gascost, err := tp.curAccountManager.FeeAmount(tx.GasAssetID(), tp.feeTokenID(tx), new(big.Int).Mul(tx.GasPrice(), new(big.Int).SetUint64(action.Gas())))
+		if err != nil {
+			return ErrInvalidGasAsset
+		}
 		if balance.Cmp(gascost) < 0 {
 			return ErrInsufficientFundsForGas
 		}
@@ -489,12 +550,68 @@ func (tp *TxPool) validateTx(tx *types.Transaction, local bool) error {
 		return ErrOversizedData
 	}
 
+	// Blocks only hold transactions of a bounded number of actions, once the
+	// next block enforces the block limits
+	n := uint64(len(tx.GetActions()))
+	if n == 0 || (n > tp.maxActions && tp.chainconfig.IsBlockLimits(tp.chain.CurrentBlock().NumberU64()+1)) {
+		return ErrTooManyActions
+	}
+
+	// Unknown action extensions are rejected once the next block enforces it
+	if tp.chainconfig.IsStrictExtensions(tp.chain.CurrentBlock().NumberU64() + 1) {
+		for _, a := range tx.GetActions() {
+			if _, unknown := a.UnknownExtension(); unknown {
+				return ErrUnknownExtension
+			}
+		}
+	}
+
+	// Action types are rejected until the fork enabling them
+	for _, a := range tx.GetActions() {
+		if !tp.chainconfig.IsActionEnabled(uint64(a.Type()), tp.chain.CurrentBlock().NumberU64()+1) {
+			return ErrActionDisabled
+		}
+	}
+
 	// Make sure the transaction is signed properly
 	if err := tp.curAccountManager.RecoverTx(tp.signer, tx); err != nil {
 		log.Error("account Manager reocver faild ", "err", err)
 		return ErrInvalidSender
 	}
 
+	// Gas is only paid in the fee asset of the chain or a whitelisted asset
+	if _, err := tp.curAccountManager.FeeAmount(tx.GasAssetID(), tp.feeTokenID(tx), new(big.Int)); err != nil {
+		return ErrInvalidGasAsset
+	}
+
+	// The gas of free transactions is taken from the quotas of their senders
+	if tp.chainconfig.Resources != nil && tx.GasPrice().Sign() == 0 {
+		if err := tp.validateResources(tx); err != nil {
+			return err
+		}
+		staked = true
+	}
+
+	// The senders may only send a bounded number of actions per window
+	if err := tp.validateRateLimit(tx); err != nil {
+		return err
+	}
+
+	// Drop transactions under the minimum gas price governed for their actions
+	minPrice, err := tp.curAccountManager.MinGasPrice(tx)
+	if err != nil {
+		return err
+	}
+	if minPrice.Sign() > 0 && !staked {
+		minPrice, err = tp.curAccountManager.FeeAmount(tx.GasAssetID(), tp.feeTokenID(tx), minPrice)
+		if err != nil {
+			return ErrInvalidGasAsset
+		}
+		if tx.GasPrice().Cmp(minPrice) < 0 {
+			return ErrUnderMinGasPrice
+		}
+	}
+
 	// Transaction action  value can't be negative.
 	var allgas uint64
 	for _, a := range tx.GetActions() {
@@ -511,10 +628,168 @@ func (tp *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if tp.currentMaxGas < allgas {
 		return ErrGasLimit
 	}
+	return nil
+}
+
+// validateResources checks that the gas quotas of the senders left in the
+// window of the next block cover the gas of their actions, after the free
+// transactions they already have in the pool.
+func (tp *TxPool) validateResources(tx *types.Transaction) error {
+	number := tp.chain.CurrentBlock().NumberU64() + 1
+	gas := make(map[common.Name]uint64)
+	for _, a := range tx.GetActions() {
+		gas[a.Sender()] += a.Gas()
+	}
+	for name, g := range gas {
+		usage, err := tp.curAccountManager.GetResourceUsage(name, number, tp.chainconfig.Resources)
+		if err != nil {
+			return err
+		}
+		if usage.Available < g+tp.pooledFreeGas(name, tx) {
+			return am.ErrResourceExhausted
+		}
+	}
+	return nil
+}
+
+// pooledFreeGas returns the gas the free transactions of the account in the
+// pool take from its quota, except the one tx would replace.
+func (tp *TxPool) pooledFreeGas(name common.Name, tx *types.Transaction) uint64 {
+	first := tx.GetActions()[0]
+	var gas uint64
+	for _, list := range []*txList{tp.pending[name], tp.queue[name]} {
+		if list == nil {
+			continue
+		}
+		for _, pooled := range list.Flatten() {
+			if pooled.GasPrice().Sign() != 0 {
+				continue
+			}
+			if first.Sender() == name && pooled.GetActions()[0].Nonce() == first.Nonce() {
+				continue
+			}
+			for _, a := range pooled.GetActions() {
+				if a.Sender() == name {
+					gas += a.Gas()
+				}
+			}
+		}
+	}
+	return gas
+}
 
+// validateRateLimit checks that the actions the senders may still send in
+// the window of the next block cover their actions, after the actions they
+// already have in the pool, so that a rate limited account can't fill the
+// pool with transactions no block can include.
+func (tp *TxPool) validateRateLimit(tx *types.Transaction) error {
+	number := tp.chain.CurrentBlock().NumberU64() + 1
+	actions := make(map[common.Name]uint64)
+	for _, a := range tx.GetActions() {
+		actions[a.Sender()]++
+	}
+	for name, n := range actions {
+		if name == tp.chainconfig.SysName {
+			continue
+		}
+		remaining, limited, err := tp.curAccountManager.RemainingActions(name, number)
+		if err != nil {
+			return err
+		}
+		if limited && remaining < n+tp.pooledActions(name, tx) {
+			return am.ErrRateLimited
+		}
+	}
 	return nil
 }
 
+// pooledActions returns the actions of the account in the pool, except the
+// ones of the transaction tx would replace.
+func (tp *TxPool) pooledActions(name common.Name, tx *types.Transaction) uint64 {
+	first := tx.GetActions()[0]
+	var actions uint64
+	for _, list := range []*txList{tp.pending[name], tp.queue[name]} {
+		if list == nil {
+			continue
+		}
+		for _, pooled := range list.Flatten() {
+			if first.Sender() == name && pooled.GetActions()[0].Nonce() == first.Nonce() {
+				continue
+			}
+			for _, a := range pooled.GetActions() {
+				if a.Sender() == name {
+					actions++
+				}
+			}
+		}
+	}
+	return actions
+}
+
+// SetExecutor sets the executor dry-running the transactions before they are
+// accepted, if enabled by the configuration.
+func (tp *TxPool) SetExecutor(executor TxExecutor) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.executor = executor
+}
+
+// dryRunTxs executes the transactions executable on the head state on copies
+// of it and rejects the ones the next block couldn't include, for example for
+// an insufficient balance or a bad authority. The state of the transactions
+// after a future one is unknown, they aren't dry-run.
+//
+// The pool lock is only held while copying the head state, the executions run
+// outside of it.
+func (tp *TxPool) dryRunTxs(txs []*types.Transaction) []error {
+	errs := make([]error, len(txs))
+
+	tp.mu.Lock()
+	if !tp.config.DryRun || tp.executor == nil || tp.headState == nil {
+		tp.mu.Unlock()
+		return errs
+	}
+	var (
+		executor = tp.executor
+		head     = tp.chain.CurrentBlock().Header()
+		states   = make([]*state.StateDB, len(txs))
+	)
+	for i, tx := range txs {
+		if len(tx.GetActions()) == 0 || tp.all.Get(tx.Hash()) != nil {
+			continue
+		}
+		action := tx.GetActions()[0]
+		nonce, err := tp.curAccountManager.GetNonce(action.Sender())
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if nonce == action.Nonce() {
+			states[i] = tp.headState.Copy()
+		}
+	}
+	tp.mu.Unlock()
+
+	header := &types.Header{
+		ParentHash: head.Hash(),
+		Coinbase:   head.Coinbase,
+		Number:     new(big.Int).Add(head.Number, big.NewInt(1)),
+		GasLimit:   head.GasLimit,
+		Time:       new(big.Int).SetInt64(time.Now().UnixNano()),
+		Difficulty: head.Difficulty,
+	}
+	for i, tx := range txs {
+		if states[i] == nil {
+			continue
+		}
+		if err := executor(states[i], header, tx); err != nil {
+			log.Trace("Discarding transaction failing its dry-run", "hash", tx.Hash(), "err", err)
+			errs[i] = fmt.Errorf("%v: %v", ErrDryRunFailed, err)
+		}
+	}
+	return errs
+}
+
 func (tp *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 	// If the transaction is already known, discard it
 	hash := tx.Hash()
@@ -547,6 +822,9 @@ func (tp *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 	from := tx.GetActions()[0].Sender()
 	if list := tp.pending[from]; list != nil && list.Overlaps(tx) {
 		// Nonce already pending, check if required price bump is met
+		prev := list.txs.Get(tx.GetActions()[0].Nonce())
+		tp.reportConflict(prev, tx)
+
 		inserted, old := list.Add(tx, tp.config.PriceBump)
 		if !inserted {
 			return false, ErrReplaceUnderpriced
@@ -563,15 +841,14 @@ func (tp *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 		log.Trace("Pooled new executable transaction", "hash", hash, "from", from)
 
 		// We've directly injected a replacement transaction, notify subsystems
-		events := []*event.Event{
-			{Typecode: event.TxEv, Data: []*types.Transaction{tx}},
-			{To: event.GetStationByName("broadcast"), Typecode: event.TxMsg, Data: []*types.Transaction{tx}},
-		}
-		go event.SendEvents(events)
+		go tp.chain.Router().SendEvent(&event.Event{Typecode: event.TxEv, Data: []*types.Transaction{tx}})
 
 		return old != nil, nil
 	}
 	// New transaction isn't replacing a pending one, push into queue
+	if list := tp.queue[from]; list != nil {
+		tp.reportConflict(list.txs.Get(tx.GetActions()[0].Nonce()), tx)
+	}
 	replace, err := tp.enqueueTx(hash, tx)
 	if err != nil {
 		return false, err
@@ -586,6 +863,50 @@ func (tp *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 	return replace, nil
 }
 
+// reportConflict posts a TxConflictEv if the transaction tx spends the same
+// nonce as the pooled transaction prev, whether it replaces it or not.
+func (tp *TxPool) reportConflict(prev, tx *types.Transaction) {
+	conflict := types.NewTxConflict(prev, tx, types.ConflictTxPool)
+	if conflict == nil {
+		return
+	}
+	conflict.Number = tp.chain.CurrentBlock().NumberU64()
+	conflict.Time = time.Now().Unix()
+	log.Debug("Conflicting transaction", "account", conflict.Account, "nonce", conflict.Nonce, "first", conflict.First, "second", conflict.Second, "feebump", conflict.FeeBump)
+	select {
+	case tp.conflictCh <- conflict:
+	default:
+		log.Debug("Dropping conflicting transaction report", "account", conflict.Account, "nonce", conflict.Nonce, "second", conflict.Second)
+	}
+}
+
+// expire drops the transactions which entered the pool longer than the
+// pending lifetime before now, posting a TxExpiredEvent for each.
+//
+// Note, this method assumes the pool lock is held!
+func (tp *TxPool) expire(now time.Time) {
+	if tp.config.PendingLifetime == 0 {
+		return
+	}
+	var (
+		hashes = tp.all.AddedBefore(now.Add(-tp.config.PendingLifetime))
+		events = make([]*event.Event, 0, len(hashes))
+		reason = fmt.Sprintf("not included in %v", tp.config.PendingLifetime)
+	)
+	for _, hash := range hashes {
+		// skip the transactions dropped while removing an earlier one
+		if tp.all.Get(hash) == nil {
+			continue
+		}
+		tp.removeTx(hash, true)
+		events = append(events, &event.Event{Typecode: event.TxExpiredEv, Data: &TxExpiredEvent{Hash: hash, Reason: reason}})
+	}
+	if len(events) > 0 {
+		log.Debug("Dropped expired transactions", "count", len(events))
+		go tp.chain.Router().SendEvents(events)
+	}
+}
+
 // enqueueTx inserts a new transaction into the non-executable transaction queue.
 //
 // Note, this method assumes the pool lock is held!
@@ -693,6 +1014,9 @@ func (tp *TxPool) AddRemotes(txs []*types.Transaction) []error {
 
 // addTx enqueues a single transaction into the pool if it is valid.
 func (tp *TxPool) addTx(tx *types.Transaction, local bool) error {
+	if err := tp.dryRunTxs([]*types.Transaction{tx})[0]; err != nil {
+		return err
+	}
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
 
@@ -712,10 +1036,24 @@ func (tp *TxPool) addTx(tx *types.Transaction, local bool) error {
 
 // addTxs attempts to queue a batch of transactions if they are valid.
 func (tp *TxPool) addTxs(txs []*types.Transaction, local bool) []error {
+	errs := tp.dryRunTxs(txs)
+	valid := make([]*types.Transaction, 0, len(txs))
+	for i, tx := range txs {
+		if errs[i] == nil {
+			valid = append(valid, tx)
+		}
+	}
+
 	tp.mu.Lock()
 	defer tp.mu.Unlock()
 
-	return tp.addTxsLocked(txs, local)
+	added := tp.addTxsLocked(valid, local)
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i], added = added[0], added[1:]
+		}
+	}
+	return errs
 }
 
 // addTxsLocked attempts to queue a batch of transactions if they are valid,
@@ -822,6 +1160,39 @@ func (tp *TxPool) removeTx(hash common.Hash, outofbound bool) {
 	}
 }
 
+// feeTokenID returns the asset the gas price of the transaction is given in:
+// the fee token from the fee asset fork on, the gas asset of the transaction
+// before.
+func (tp *TxPool) feeTokenID(tx *types.Transaction) uint64 {
+	if tp.chainconfig.IsFeeAsset(tp.chain.CurrentBlock().NumberU64() + 1) {
+		return tp.config.GasAssetID
+	}
+	return tx.GasAssetID()
+}
+
+// unpayable returns a filter of the transactions of the account whose gas
+// costs more than its balance of the asset they pay gas in.
+func (tp *TxPool) unpayable(name common.Name) func(*types.Transaction) bool {
+	balances := make(map[uint64]*big.Int)
+	return func(tx *types.Transaction) bool {
+		assetID := tx.GasAssetID()
+		balance, ok := balances[assetID]
+		if !ok {
+			var err error
+			balance, err = tp.curAccountManager.GetAccountBalanceByID(name, assetID)
+			if err != nil {
+				if err != am.ErrAccountNotExist {
+					log.Error("current account manager get balance err ", "name", name, "assetID", assetID, "err", err)
+				}
+				balance = new(big.Int)
+			}
+			balances[assetID] = balance
+		}
+		cost, err := tp.curAccountManager.FeeAmount(assetID, tp.feeTokenID(tx), tx.Cost())
+		return err != nil || balance.Cmp(cost) < 0
+	}
+}
+
 // promoteExecutables moves transactions that have become processable from the
 // future queue to the set of pending transactions. During this process, all
 // invalidated transactions (low nonce, low balance) are deleted.
@@ -854,12 +1225,7 @@ func (tp *TxPool) promoteExecutables(accounts []common.Name) {
 			tp.priced.Removed()
 		}
 		// Drop all transactions that are too costly (low balance or out of gas)
-		// todo assetID
-		balance, err := tp.curAccountManager.GetAccountBalanceByID(addr, tp.config.GasAssetID)
-		if err != nil {
-			log.Error("promoteExecutables current account manager get balance err ", "name", addr, "assetID", tp.config.GasAssetID, "err", err)
-		}
-		drops, _ := list.Filter(balance, tp.currentMaxGas)
+		drops, _ := list.Filter(tp.unpayable(addr), tp.currentMaxGas)
 		for _, tx := range drops {
 			hash := tx.Hash()
 			log.Trace("Removed unpayable queued transaction", "hash", hash)
@@ -894,12 +1260,7 @@ func (tp *TxPool) promoteExecutables(accounts []common.Name) {
 	}
 	// Notify subsystem for new promoted transactions.
 	if len(promoted) > 0 {
-		// go event.SendEvent(&event.Event{Typecode: event.TxEv, Data: promoted})
-		events := []*event.Event{
-			{Typecode: event.TxEv, Data: promoted},
-			{To: event.GetStationByName("broadcast"), Typecode: event.TxMsg, Data: promoted},
-		}
-		go event.SendEvents(events)
+		go tp.chain.Router().SendEvent(&event.Event{Typecode: event.TxEv, Data: promoted})
 	}
 	// If the pending limit is overflown, start equalizing allowances
 	pending := uint64(0)
@@ -1040,12 +1401,7 @@ func (tp *TxPool) demoteUnexecutables() {
 			tp.priced.Removed()
 		}
 		// Drop all transactions that are too costly (low balance or out of gas), and queue any invalids back for later
-		balance, err := tp.curAccountManager.GetAccountBalanceByID(addr, tp.config.GasAssetID)
-		if err != nil && err != am.ErrAccountNotExist {
-			log.Error("promoteExecutables current account manager get balance err ", "name", addr, "assetID", tp.config.GasAssetID, "err", err)
-		}
-
-		drops, invalids := list.Filter(balance, tp.currentMaxGas)
+		drops, invalids := list.Filter(tp.unpayable(addr), tp.currentMaxGas)
 
 		for _, tx := range drops {
 			hash := tx.Hash()
@@ -18,11 +18,13 @@ package txpool
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"io/ioutil"
 	"math/big"
 	"math/rand"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -638,6 +640,124 @@ func TestTransactionPostponing(t *testing.T) {
 	}
 }
 
+// Tests that transactions staying in the pool longer than the pending lifetime
+// are dropped with an expiry event, the later ones of the account postponed.
+func TestTransactionExpiry(t *testing.T) {
+	var (
+		fname   = common.Name("fromname")
+		tname   = common.Name("totestname")
+		assetID = uint64(1)
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	pool.config.PendingLifetime = time.Minute
+	fkey := generateAccount(t, fname, manager, pool.pendingAccountManager)
+	generateAccount(t, tname, manager, pool.pendingAccountManager)
+
+	pool.curAccountManager.AddAccountBalanceByID(fname, assetID, big.NewInt(1000000))
+
+	events := make(chan *event.Event, 10)
+	sub := event.Subscribe(nil, events, event.TxExpiredEv, &TxExpiredEvent{})
+	defer sub.Unsubscribe()
+
+	txs := []*types.Transaction{
+		transaction(0, fname, tname, 100000, fkey),
+		transaction(1, fname, tname, 100000, fkey),
+	}
+	for _, tx := range txs {
+		if err := pool.AddRemote(tx); err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+	// Nothing expires within the lifetime
+	now := time.Now()
+	pool.mu.Lock()
+	pool.expire(now)
+	pool.mu.Unlock()
+	if pending, queued := pool.Stats(); pending != 2 || queued != 0 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want 2/0", pending, queued)
+	}
+	// Age the first transaction past the lifetime
+	pool.all.lock.Lock()
+	pool.all.added[txs[0].Hash()] = now.Add(-2 * time.Minute)
+	pool.all.lock.Unlock()
+
+	pool.mu.Lock()
+	pool.expire(now)
+	pool.mu.Unlock()
+	if pending, queued := pool.Stats(); pending != 0 || queued != 1 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want 0/1", pending, queued)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	select {
+	case ev := <-events:
+		if expired := ev.Data.(*TxExpiredEvent); expired.Hash != txs[0].Hash() || expired.Reason == "" {
+			t.Fatalf("expiry event mismatch: have %x %q, want %x", expired.Hash, expired.Reason, txs[0].Hash())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expiry event not fired")
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected expiry event: %v", ev.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Tests that a transaction spending the nonce of a pooled one posts a conflict
+// event, whether it is rejected as underpriced or replaces the pooled one.
+func TestTransactionConflictEvent(t *testing.T) {
+	var (
+		fname   = common.Name("fromname")
+		tname   = common.Name("totestname")
+		assetID = uint64(1)
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	fkey := generateAccount(t, fname, manager, pool.pendingAccountManager)
+	generateAccount(t, tname, manager, pool.pendingAccountManager)
+
+	pool.curAccountManager.AddAccountBalanceByID(fname, assetID, big.NewInt(1000000))
+
+	events := make(chan *event.Event, 10)
+	sub := event.Subscribe(nil, events, event.TxConflictEv, &types.TxConflict{})
+	defer sub.Unsubscribe()
+
+	first := pricedTransaction(0, fname, tname, 100000, big.NewInt(1), fkey)
+	underpriced := pricedTransaction(0, fname, tname, 100001, big.NewInt(1), fkey)
+	replacement := pricedTransaction(0, fname, tname, 100000, big.NewInt(2), fkey)
+
+	if err := pool.AddRemote(first); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	if err := pool.AddRemote(underpriced); err != ErrReplaceUnderpriced {
+		t.Fatalf("underpriced replacement error mismatch: have %v, want %v", err, ErrReplaceUnderpriced)
+	}
+	if err := pool.AddRemote(replacement); err != nil {
+		t.Fatalf("failed to replace transaction: %v", err)
+	}
+	want := map[common.Hash]bool{underpriced.Hash(): true, replacement.Hash(): true}
+	for len(want) > 0 {
+		select {
+		case ev := <-events:
+			conflict := ev.Data.(*types.TxConflict)
+			if conflict.Account != fname || conflict.Nonce != 0 || conflict.First != first.Hash() || !want[conflict.Second] || !conflict.FeeBump || conflict.Source != types.ConflictTxPool {
+				t.Fatalf("conflict event mismatch: %+v", conflict)
+			}
+			delete(want, conflict.Second)
+		case <-time.After(time.Second):
+			t.Fatal("conflict event not fired")
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected conflict event: %v", ev.Data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
 // Tests that if the transaction pool has both executable and non-executable
 // transactions from an origin account, filling the nonce gap moves all queued
 // ones into the pending pool.
@@ -1978,3 +2098,332 @@ func benchmarkPoolBatchInsert(b *testing.B, size int) {
 		pool.AddRemotes(batch)
 	}
 }
+
+func TestTransactionInvalidGasAsset(t *testing.T) {
+	var (
+		fname = common.Name("fromname")
+		tname = common.Name("totestname")
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	config := *params.DefaultChainconfig
+	config.FeeAssetBlock = big.NewInt(0)
+	pool.chainconfig = &config
+	fkey := generateAccount(t, fname, manager)
+	generateAccount(t, tname, manager)
+
+	tx := types.NewTransaction(testTxPoolConfig.GasAssetID+1, big.NewInt(1), newAction(0, fname, tname, big.NewInt(1), 100, nil))
+	if err := types.SignAction(tx.GetActions()[0], tx, types.NewSigner(params.DefaultChainconfig.ChainID), fkey); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddRemote(tx); err != ErrInvalidGasAsset {
+		t.Fatal("expected", ErrInvalidGasAsset, "got", err)
+	}
+}
+
+func TestTransactionWhitelistedGasAsset(t *testing.T) {
+	var (
+		fname = common.Name("fromname")
+		tname = common.Name("totestname")
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	config := *params.DefaultChainconfig
+	config.FeeAssetBlock = big.NewInt(0)
+	pool.chainconfig = &config
+	fkey := generateAccount(t, fname, manager)
+	generateAccount(t, tname, manager)
+	manager.AddAccountBalanceByID(fname, testTxPoolConfig.GasAssetID, big.NewInt(100))
+
+	if err := manager.IssueAsset(&asset.AssetObject{AssetName: "popularcoin", Symbol: "pop", Amount: big.NewInt(1000000), Decimals: 2, Owner: fname}); err != nil {
+		t.Fatal(err)
+	}
+	pop, err := manager.GetAssetInfoByName("popularcoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetFeeRate(pop.AssetId, &am.FeeRate{Num: big.NewInt(2), Den: big.NewInt(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := types.NewTransaction(pop.AssetId, big.NewInt(1), newAction(0, fname, tname, big.NewInt(1), 100000, nil))
+	if err := types.SignAction(tx.GetActions()[0], tx, types.NewSigner(params.DefaultChainconfig.ChainID), fkey); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddRemote(tx); err != nil {
+		t.Fatal("whitelisted gas asset rejected:", err)
+	}
+
+	// Gas costing more than the balance in the fee asset is rejected.
+	tx = types.NewTransaction(pop.AssetId, big.NewInt(10), newAction(1, fname, tname, big.NewInt(1), 100000, nil))
+	if err := types.SignAction(tx.GetActions()[0], tx, types.NewSigner(params.DefaultChainconfig.ChainID), fkey); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddRemote(tx); err != ErrInsufficientFundsForGas {
+		t.Fatal("expected", ErrInsufficientFundsForGas, "got", err)
+	}
+}
+
+func TestTransactionMinGasPrice(t *testing.T) {
+	var (
+		fname = common.Name("fromname")
+		tname = common.Name("totestname")
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	config := *params.DefaultChainconfig
+	config.FeeAssetBlock = big.NewInt(0)
+	pool.chainconfig = &config
+	fkey := generateAccount(t, fname, manager)
+	generateAccount(t, tname, manager)
+	manager.AddAccountBalanceByID(fname, testTxPoolConfig.GasAssetID, big.NewInt(100000000000000))
+
+	if err := manager.SetMinGasPrice(types.Transfer, big.NewInt(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddRemote(pricedTransaction(0, fname, tname, 100000, big.NewInt(4), fkey)); err != ErrUnderMinGasPrice {
+		t.Fatal("expected", ErrUnderMinGasPrice, "got", err)
+	}
+	if err := pool.AddLocal(pricedTransaction(0, fname, tname, 100000, big.NewInt(4), fkey)); err != ErrUnderMinGasPrice {
+		t.Fatal("expected", ErrUnderMinGasPrice, "for a local transaction, got", err)
+	}
+	if err := pool.AddRemote(pricedTransaction(0, fname, tname, 100000, big.NewInt(5), fkey)); err != nil {
+		t.Fatal("transaction at the minimum gas price rejected:", err)
+	}
+
+	// Minimums are converted to the rate of a whitelisted gas asset.
+	if err := manager.IssueAsset(&asset.AssetObject{AssetName: "popularcoin", Symbol: "pop", Amount: big.NewInt(1000000), Decimals: 2, Owner: fname}); err != nil {
+		t.Fatal(err)
+	}
+	pop, err := manager.GetAssetInfoByName("popularcoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetFeeRate(pop.AssetId, &am.FeeRate{Num: big.NewInt(2), Den: big.NewInt(1)}); err != nil {
+		t.Fatal(err)
+	}
+	for i, price := range []int64{9, 10} {
+		tx := types.NewTransaction(pop.AssetId, big.NewInt(price), newAction(1, fname, tname, big.NewInt(1), 30000, nil))
+		if err := types.SignAction(tx.GetActions()[0], tx, types.NewSigner(params.DefaultChainconfig.ChainID), fkey); err != nil {
+			t.Fatal(err)
+		}
+		err := pool.AddRemote(tx)
+		if i == 0 && err != ErrUnderMinGasPrice {
+			t.Fatal("expected", ErrUnderMinGasPrice, "got", err)
+		}
+		if i == 1 && err != nil {
+			t.Fatal("transaction at the converted minimum gas price rejected:", err)
+		}
+	}
+}
+
+func TestTransactionPooledFreeGas(t *testing.T) {
+	var (
+		fname = common.Name("fromname")
+		tname = common.Name("totestname")
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	config := *params.DefaultChainconfig
+	config.Resources = &params.ResourceConfig{Unit: big.NewInt(100), GasPerUnit: 100000, Window: 100}
+	pool.chainconfig = &config
+	fkey := generateAccount(t, fname, manager)
+	generateAccount(t, tname, manager)
+	manager.AddAccountBalanceByID(fname, testTxPoolConfig.GasAssetID, big.NewInt(100000000000000))
+	if err := manager.StakeResource(fname, testTxPoolConfig.GasAssetID, big.NewInt(300)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The free transactions in the pool take their gas from the quota first.
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		if err := pool.AddRemote(pricedTransaction(nonce, fname, tname, 100000, big.NewInt(0), fkey)); err != nil {
+			t.Fatalf("free transaction %d within the quota rejected: %v", nonce, err)
+		}
+	}
+	if err := pool.AddRemote(pricedTransaction(3, fname, tname, 100000, big.NewInt(0), fkey)); err != am.ErrResourceExhausted {
+		t.Fatal("expected", am.ErrResourceExhausted, "got", err)
+	}
+	if err := pool.AddRemote(pricedTransaction(3, fname, tname, 100000, big.NewInt(1), fkey)); err != nil {
+		t.Fatal("priced transaction rejected:", err)
+	}
+}
+
+func TestTransactionRateLimit(t *testing.T) {
+	var (
+		fname = common.Name("fromname")
+		tname = common.Name("totestname")
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	fkey := generateAccount(t, fname, manager)
+	generateAccount(t, tname, manager)
+	manager.AddAccountBalanceByID(fname, testTxPoolConfig.GasAssetID, big.NewInt(100000000000000))
+	if err := manager.SetRateLimit(&am.RateLimit{MaxActions: 2, Window: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pool holds no more actions of an account than it may send in the
+	// window of the next block.
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		if err := pool.AddRemote(transaction(nonce, fname, tname, 100000, fkey)); err != nil {
+			t.Fatalf("transaction %d within the rate limit rejected: %v", nonce, err)
+		}
+	}
+	if err := pool.AddRemote(transaction(2, fname, tname, 100000, fkey)); err != am.ErrRateLimited {
+		t.Fatal("expected", am.ErrRateLimited, "got", err)
+	}
+	// Replacing a pooled transaction takes no more actions.
+	if err := pool.AddRemote(pricedTransaction(1, fname, tname, 100000, big.NewInt(2), fkey)); err != nil {
+		t.Fatal("replacement rejected:", err)
+	}
+}
+
+func TestTransactionTooManyActions(t *testing.T) {
+	var (
+		fname = common.Name("fromname")
+		tname = common.Name("totestname")
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	fkey := generateAccount(t, fname, manager)
+	generateAccount(t, tname, manager)
+
+	if err := pool.AddRemote(newTx(big.NewInt(1))); err != ErrTooManyActions {
+		t.Fatal("expected", ErrTooManyActions, "got", err)
+	}
+	actions := make([]*types.Action, pool.maxActions+1)
+	for i := range actions {
+		actions[i] = newAction(uint64(i), fname, tname, big.NewInt(1), 100000, nil)
+	}
+	tx := newTx(big.NewInt(1), actions...)
+	for _, action := range actions {
+		if err := types.SignAction(action, tx, types.NewSigner(params.DefaultChainconfig.ChainID), fkey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The actions are only bounded once the block limits are activated.
+	if err := pool.AddRemote(tx); err == ErrTooManyActions {
+		t.Fatal("transaction rejected before the block limits are activated")
+	}
+	config := *pool.chainconfig
+	config.BlockLimitsBlock = big.NewInt(1)
+	pool.chainconfig = &config
+	if err := pool.AddRemote(tx); err != ErrTooManyActions {
+		t.Fatal("expected", ErrTooManyActions, "got", err)
+	}
+}
+
+func TestTransactionUnknownExtension(t *testing.T) {
+	var (
+		fname   = common.Name("fromname")
+		tname   = common.Name("totestname")
+		assetID = uint64(1)
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	fkey := generateAccount(t, fname, manager)
+	generateAccount(t, tname, manager)
+	pool.curAccountManager.AddAccountBalanceByID(fname, assetID, big.NewInt(1000000000))
+
+	newExtendedTx := func(nonce uint64) *types.Transaction {
+		action := newAction(nonce, fname, tname, big.NewInt(1), 100000, nil)
+		action.SetExtensions(&types.ActionExtension{Version: 1, Data: []byte{1}})
+		tx := newTx(big.NewInt(1), action)
+		if err := types.SignAction(action, tx, types.NewSigner(params.DefaultChainconfig.ChainID), fkey); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	// Unknown extensions are accepted until strict extensions are activated.
+	if err := pool.AddRemote(newExtendedTx(0)); err != nil {
+		t.Fatal(err)
+	}
+	config := *pool.chainconfig
+	config.ExtensionBlock = big.NewInt(1)
+	pool.chainconfig = &config
+	if err := pool.AddRemote(newExtendedTx(1)); err != ErrUnknownExtension {
+		t.Fatal("expected", ErrUnknownExtension, "got", err)
+	}
+}
+
+func TestTransactionActionFork(t *testing.T) {
+	var (
+		fname   = common.Name("fromname")
+		tname   = common.Name("totestname")
+		assetID = uint64(1)
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	fkey := generateAccount(t, fname, manager)
+	generateAccount(t, tname, manager)
+	pool.curAccountManager.AddAccountBalanceByID(fname, assetID, big.NewInt(1000000000))
+
+	// Transfers are rejected until the block of the fork enabling them.
+	next := pool.chain.CurrentBlock().NumberU64() + 1
+	config := *pool.chainconfig
+	config.ActionForks = []*params.ActionFork{{Block: next + 1, ActionTypes: []uint64{uint64(types.Transfer)}}}
+	pool.chainconfig = &config
+	if err := pool.AddRemote(transaction(0, fname, tname, 100000, fkey)); err != ErrActionDisabled {
+		t.Fatal("expected", ErrActionDisabled, "got", err)
+	}
+	config.ActionForks[0].Block = next
+	if err := pool.AddRemote(transaction(0, fname, tname, 100000, fkey)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Tests that executable transactions failing their dry-run are rejected, while
+// future ones and the ones of a pool with dry-runs disabled are not executed.
+func TestTransactionDryRun(t *testing.T) {
+	var (
+		fname   = common.Name("fromname")
+		tname   = common.Name("totestname")
+		assetID = uint64(1)
+	)
+	pool, manager := setupTxPool(fname)
+	defer pool.Stop()
+	fkey := generateAccount(t, fname, manager)
+	generateAccount(t, tname, manager)
+
+	manager.AddAccountBalanceByID(fname, assetID, big.NewInt(100000000000000))
+
+	failing := transaction(0, fname, tname, 100001, fkey)
+	executed := 0
+	pool.SetExecutor(func(statedb *state.StateDB, header *types.Header, tx *types.Transaction) error {
+		executed++
+		if tx.Hash() == failing.Hash() {
+			return errors.New("insufficient balance")
+		}
+		return nil
+	})
+
+	// Dry-runs are disabled by default
+	if err := pool.AddRemote(failing); err != nil {
+		t.Fatalf("failed to add transaction with dry-runs disabled: %v", err)
+	}
+	if executed != 0 {
+		t.Fatalf("executed transactions mismatch: have %d, want 0", executed)
+	}
+	pool.removeTx(failing.Hash(), true)
+
+	pool.config.DryRun = true
+	if err := pool.AddRemote(failing); err == nil || !strings.HasPrefix(err.Error(), ErrDryRunFailed.Error()) {
+		t.Fatalf("failing transaction error mismatch: have %v, want %v", err, ErrDryRunFailed)
+	}
+	if err := pool.AddRemote(transaction(1, fname, tname, 100000, fkey)); err != nil {
+		t.Fatalf("failed to add future transaction: %v", err)
+	}
+	if executed != 1 {
+		t.Fatalf("executed transactions mismatch: have %d, want 1", executed)
+	}
+	if err := pool.AddRemote(transaction(0, fname, tname, 100000, fkey)); err != nil {
+		t.Fatalf("failed to add executable transaction: %v", err)
+	}
+	if executed != 2 {
+		t.Fatalf("executed transactions mismatch: have %d, want 2", executed)
+	}
+	if pending, queued := pool.Stats(); pending != 2 || queued != 0 {
+		t.Fatalf("pool stats mismatch: have %d pending and %d queued, want 2 and 0", pending, queued)
+	}
+}
@@ -55,6 +55,42 @@ const (
 	VoteProducer
 	ChangeProducer
 	UnvoteProducer
+	// CallContract represents a call of an existing contract.
+	CallContract
+	// ReviveAccount represents paying the storage rent of a hibernated account.
+	ReviveAccount
+	// BidName represents a bid of the value in the auction of the recipient name.
+	BidName
+	// SettleName represents creating the account of a won name auction.
+	SettleName
+	// SetFeeAsset represents setting the fee exchange rate of the asset.
+	SetFeeAsset
+	// UpdateProducerKey represents setting the block signing key of a producer.
+	UpdateProducerKey
+	// SetNameRecord represents publishing a record under the sender name.
+	SetNameRecord
+	// SetMinGasPrice represents setting the minimum gas price of an action type.
+	SetMinGasPrice
+	// SetRateLimit represents setting the limit of the actions of an account.
+	SetRateLimit
+	// StakeResource represents staking the value for a gas quota.
+	StakeResource
+	// UnstakeResource represents returning the value from the stake.
+	UnstakeResource
+	// ProposeWithdrawal represents proposing a withdrawal from the fee pool.
+	ProposeWithdrawal
+	// ApproveWithdrawal represents a producer approving a withdrawal proposal.
+	ApproveWithdrawal
+	// ExecuteWithdrawal represents paying out an approved withdrawal proposal.
+	ExecuteWithdrawal
+	// SetMultisig represents setting the approvers of the multisig proposals of the sender.
+	SetMultisig
+	// ProposeMultisig represents proposing a transaction of a multisig account.
+	ProposeMultisig
+	// ApproveMultisig represents an approver approving a multisig proposal.
+	ApproveMultisig
+	// ExecuteMultisig represents executing the transaction of an approved multisig proposal.
+	ExecuteMultisig
 )
 
 type actionData struct {
@@ -71,8 +107,24 @@ type actionData struct {
 	V *big.Int
 	R *big.Int
 	S *big.Int
+
+	// Extensions follow the signature values, so that actions without
+	// extensions keep their encoding and signature hash.
+	Extensions []*ActionExtension `rlp:"tail"`
+}
+
+// ActionExtension is a versioned blob of data extending an action, signed
+// along with it. The version identifies the feature the data belongs to.
+type ActionExtension struct {
+	Version uint64        `json:"version"`
+	Data    hexutil.Bytes `json:"data"`
 }
 
+// KnownExtensions contains the versions of the action extensions the
+// protocol understands. Once strict extensions are activated, actions with
+// other extensions are rejected.
+var KnownExtensions = map[uint64]bool{}
+
 // Action represents an entire action in the transaction.
 type Action struct {
 	data actionData
@@ -114,6 +166,37 @@ func (a *Action) Data() []byte           { return common.CopyBytes(a.data.Payloa
 func (a *Action) Gas() uint64            { return a.data.GasLimit }
 func (a *Action) Value() *big.Int        { return new(big.Int).Set(a.data.Amount) }
 
+// Extensions returns a copy of the extensions of the action.
+func (a *Action) Extensions() []*ActionExtension {
+	exts := make([]*ActionExtension, len(a.data.Extensions))
+	for i, ext := range a.data.Extensions {
+		exts[i] = &ActionExtension{Version: ext.Version, Data: common.CopyBytes(ext.Data)}
+	}
+	return exts
+}
+
+// SetExtensions sets the extensions of the action, which must happen before
+// it is signed.
+func (a *Action) SetExtensions(exts ...*ActionExtension) {
+	a.data.Extensions = nil
+	for _, ext := range exts {
+		a.data.Extensions = append(a.data.Extensions, &ActionExtension{Version: ext.Version, Data: common.CopyBytes(ext.Data)})
+	}
+	a.hash = atomic.Value{}
+	a.sender = atomic.Value{}
+}
+
+// UnknownExtension returns the version of the first extension of the action
+// not in KnownExtensions, and whether there is one.
+func (a *Action) UnknownExtension() (uint64, bool) {
+	for _, ext := range a.data.Extensions {
+		if !KnownExtensions[ext.Version] {
+			return ext.Version, true
+		}
+	}
+	return 0, false
+}
+
 // EncodeRLP implements rlp.Encoder
 func (a *Action) EncodeRLP(w io.Writer) error {
 	return rlp.Encode(w, &a.data)
@@ -121,7 +204,13 @@ func (a *Action) EncodeRLP(w io.Writer) error {
 
 // DecodeRLP implements rlp.Decoder
 func (a *Action) DecodeRLP(s *rlp.Stream) error {
-	return s.Decode(&a.data)
+	if err := s.Decode(&a.data); err != nil {
+		return err
+	}
+	if len(a.data.Extensions) == 0 {
+		a.data.Extensions = nil
+	}
+	return nil
 }
 
 // ChainID returns which chain id this action was signed for (if at all)
@@ -156,19 +245,20 @@ func (a *Action) RawSignatureValues() (*big.Int, *big.Int, *big.Int) {
 
 // RPCAction represents a action that will serialize to the RPC representation of a action.
 type RPCAction struct {
-	Type       uint64        `json:"type"`
-	Nonce      uint64        `json:"nonce"`
-	From       common.Name   `json:"from"`
-	To         common.Name   `json:"to"`
-	AssetID    uint64        `json:"assetID"`
-	GasLimit   uint64        `json:"gas"`
-	Amount     *big.Int      `json:"value"`
-	Payload    hexutil.Bytes `json:"payload"`
-	V          *hexutil.Big  `json:"v"`
-	R          *hexutil.Big  `json:"r"`
-	S          *hexutil.Big  `json:"s"`
-	Hash       common.Hash   `json:"actionHash"`
-	ActionIdex uint64        `json:"actionIndex"`
+	Type       uint64             `json:"type"`
+	Nonce      uint64             `json:"nonce"`
+	From       common.Name        `json:"from"`
+	To         common.Name        `json:"to"`
+	AssetID    uint64             `json:"assetID"`
+	GasLimit   uint64             `json:"gas"`
+	Amount     *big.Int           `json:"value"`
+	Payload    hexutil.Bytes      `json:"payload"`
+	V          *hexutil.Big       `json:"v"`
+	R          *hexutil.Big       `json:"r"`
+	S          *hexutil.Big       `json:"s"`
+	Hash       common.Hash        `json:"actionHash"`
+	ActionIdex uint64             `json:"actionIndex"`
+	Extensions []*ActionExtension `json:"extensions,omitempty"`
 }
 
 // NewRPCAction returns a action that will serialize to the RPC.
@@ -188,6 +278,7 @@ func (a *Action) NewRPCAction(index uint64) *RPCAction {
 		R:          (*hexutil.Big)(r),
 		S:          (*hexutil.Big)(s),
 		ActionIdex: index,
+		Extensions: a.Extensions(),
 	}
 	return result
 }
@@ -54,3 +54,39 @@ func TestActionEncodeAndDecode(t *testing.T) {
 	assert.Equal(t, testAction, actAction)
 	assert.Equal(t, testAction.Hash(), actAction.Hash())
 }
+
+func TestActionExtensions(t *testing.T) {
+	plain := NewAction(Transfer, common.Name("from"), common.Name("to"), 1, 3, 2000, big.NewInt(1000), nil)
+	extended := NewAction(Transfer, common.Name("from"), common.Name("to"), 1, 3, 2000, big.NewInt(1000), nil)
+	extended.SetExtensions(&ActionExtension{Version: 7, Data: []byte{1, 2, 3}})
+
+	// Actions without extensions keep their encoding and signature hash.
+	signer := NewSigner(big.NewInt(1))
+	empty := NewAction(Transfer, common.Name("from"), common.Name("to"), 1, 3, 2000, big.NewInt(1000), nil)
+	empty.SetExtensions()
+	plainBytes, _ := rlp.EncodeToBytes(plain)
+	emptyBytes, _ := rlp.EncodeToBytes(empty)
+	assert.Equal(t, plainBytes, emptyBytes)
+	assert.Equal(t, signer.Hash(NewTransaction(0, big.NewInt(1), plain)), signer.Hash(NewTransaction(0, big.NewInt(1), empty)))
+
+	// Extensions are encoded and signed.
+	extendedBytes, err := rlp.EncodeToBytes(extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Action{}
+	if err := rlp.DecodeBytes(extendedBytes, decoded); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, extended.Extensions(), decoded.Extensions())
+	assert.Equal(t, extended.Hash(), decoded.Hash())
+	assert.NotEqual(t, signer.Hash(NewTransaction(0, big.NewInt(1), plain)), signer.Hash(NewTransaction(0, big.NewInt(1), extended)))
+
+	version, unknown := extended.UnknownExtension()
+	assert.True(t, unknown)
+	assert.Equal(t, uint64(7), version)
+	KnownExtensions[7] = true
+	defer delete(KnownExtensions, 7)
+	_, unknown = extended.UnknownExtension()
+	assert.False(t, unknown)
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+	"sort"
+)
+
+// assetStatsHours is the number of hours of volume kept in the statistics.
+const assetStatsHours = 24
+
+// AssetStatsDelta is the change a block made to the statistics of an asset.
+// RLP can't encode negative numbers, so changes which may be negative are
+// split in the amounts added and removed.
+type AssetStatsDelta struct {
+	AssetID        uint64
+	SupplyAdded    *big.Int // sum of the balance increases
+	SupplyRemoved  *big.Int // sum of the balance decreases
+	HoldersAdded   uint64   // accounts whose balance became non-zero
+	HoldersRemoved uint64   // accounts whose balance became zero
+	Transfers      uint64   // successful actions and internal transactions with a value
+	Volume         *big.Int // value of the transfers
+}
+
+// NewAssetStatsDelta returns an empty delta of the asset.
+func NewAssetStatsDelta(assetID uint64) *AssetStatsDelta {
+	return &AssetStatsDelta{
+		AssetID:       assetID,
+		SupplyAdded:   new(big.Int),
+		SupplyRemoved: new(big.Int),
+		Volume:        new(big.Int),
+	}
+}
+
+// AssetVolume is the transfer volume of an asset in an hour.
+type AssetVolume struct {
+	Hour      uint64 // hours since the unix epoch
+	Transfers uint64
+	Volume    *big.Int
+}
+
+// AssetStats are the statistics of an asset over the canonical chain. They are
+// maintained by the node as the blocks are written and are not secured by
+// consensus.
+type AssetStats struct {
+	AssetID   uint64
+	Supply    *big.Int       // sum of the balances of the accounts
+	Holders   uint64         // accounts with a non-zero balance
+	Transfers uint64         // transfers since the statistics began
+	Volume    *big.Int       // value of the transfers since the statistics began
+	Hourly    []*AssetVolume // volume per hour of the last day, ascending by hour
+	Pruned    uint64         // hour before which the hourly volume was dropped
+}
+
+// NewAssetStats returns empty statistics of the asset.
+func NewAssetStats(assetID uint64) *AssetStats {
+	return &AssetStats{AssetID: assetID, Supply: new(big.Int), Volume: new(big.Int)}
+}
+
+// TimeHour returns the hour since the unix epoch of a block time.
+func TimeHour(time *big.Int) uint64 {
+	return new(big.Int).Div(time, big.NewInt(3600*1e9)).Uint64()
+}
+
+// Apply adds the delta of a block made in the given hour, dropping the hourly
+// volume older than a day.
+func (s *AssetStats) Apply(d *AssetStatsDelta, hour uint64) {
+	s.Supply.Add(s.Supply, d.SupplyAdded)
+	s.Supply.Sub(s.Supply, d.SupplyRemoved)
+	s.Holders += d.HoldersAdded
+	s.Holders = subFloor(s.Holders, d.HoldersRemoved)
+	s.Transfers += d.Transfers
+	s.Volume.Add(s.Volume, d.Volume)
+	if d.Transfers == 0 {
+		return
+	}
+	i := sort.Search(len(s.Hourly), func(i int) bool { return s.Hourly[i].Hour >= hour })
+	if i == len(s.Hourly) || s.Hourly[i].Hour != hour {
+		s.Hourly = append(s.Hourly, nil)
+		copy(s.Hourly[i+1:], s.Hourly[i:])
+		s.Hourly[i] = &AssetVolume{Hour: hour, Volume: new(big.Int)}
+	}
+	s.Hourly[i].Transfers += d.Transfers
+	s.Hourly[i].Volume.Add(s.Hourly[i].Volume, d.Volume)
+
+	latest := s.Hourly[len(s.Hourly)-1].Hour
+	for len(s.Hourly) > 0 && s.Hourly[0].Hour+assetStatsHours <= latest {
+		s.Pruned = s.Hourly[0].Hour + 1
+		s.Hourly = s.Hourly[1:]
+	}
+}
+
+// Revertible reports whether the hourly volume of the day up to the given
+// hour is still kept, so that the statistics can be reverted to a block made
+// in that hour.
+func (s *AssetStats) Revertible(hour uint64) bool {
+	return s.Pruned+assetStatsHours <= hour+1
+}
+
+// Revert removes the delta of a block made in the given hour. The hourly
+// volume dropped by later blocks isn't restored, see Revertible.
+func (s *AssetStats) Revert(d *AssetStatsDelta, hour uint64) {
+	s.Supply.Sub(s.Supply, d.SupplyAdded)
+	s.Supply.Add(s.Supply, d.SupplyRemoved)
+	s.Holders = subFloor(s.Holders, d.HoldersAdded)
+	s.Holders += d.HoldersRemoved
+	s.Transfers = subFloor(s.Transfers, d.Transfers)
+	s.Volume.Sub(s.Volume, d.Volume)
+	for i, v := range s.Hourly {
+		if v.Hour != hour {
+			continue
+		}
+		v.Transfers = subFloor(v.Transfers, d.Transfers)
+		v.Volume.Sub(v.Volume, d.Volume)
+		if v.Transfers == 0 {
+			s.Hourly = append(s.Hourly[:i], s.Hourly[i+1:]...)
+		}
+		break
+	}
+}
+
+// LastDay returns the transfers and their value in the day up to the given
+// hour.
+func (s *AssetStats) LastDay(hour uint64) (uint64, *big.Int) {
+	transfers, volume := uint64(0), new(big.Int)
+	for _, v := range s.Hourly {
+		if v.Hour+assetStatsHours > hour && v.Hour <= hour {
+			transfers += v.Transfers
+			volume.Add(volume, v.Volume)
+		}
+	}
+	return transfers, volume
+}
+
+func subFloor(a, b uint64) uint64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
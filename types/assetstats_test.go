@@ -0,0 +1,59 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestAssetStatsApplyRevert(t *testing.T) {
+	stats := NewAssetStats(1)
+	mint := &AssetStatsDelta{AssetID: 1, SupplyAdded: big.NewInt(100), SupplyRemoved: new(big.Int), HoldersAdded: 1, Volume: new(big.Int)}
+	send := &AssetStatsDelta{AssetID: 1, SupplyAdded: big.NewInt(30), SupplyRemoved: big.NewInt(30), HoldersAdded: 1, Transfers: 2, Volume: big.NewInt(30)}
+	burn := &AssetStatsDelta{AssetID: 1, SupplyAdded: new(big.Int), SupplyRemoved: big.NewInt(70), HoldersRemoved: 1, Transfers: 1, Volume: big.NewInt(5)}
+
+	stats.Apply(mint, 100)
+	stats.Apply(send, 100)
+	stats.Apply(burn, 110)
+	if stats.Supply.Int64() != 30 || stats.Holders != 1 || stats.Transfers != 3 || stats.Volume.Int64() != 35 {
+		t.Fatalf("statistics mismatch: have %+v", stats)
+	}
+	if transfers, volume := stats.LastDay(110); transfers != 3 || volume.Int64() != 35 {
+		t.Fatalf("last day mismatch: have %d %v, want 3 35", transfers, volume)
+	}
+	if transfers, volume := stats.LastDay(124); transfers != 1 || volume.Int64() != 5 {
+		t.Fatalf("last day mismatch: have %d %v, want 1 5", transfers, volume)
+	}
+
+	// a transfer a day later drops the volume of the first hour
+	stats.Apply(send, 124)
+	if len(stats.Hourly) != 2 || stats.Hourly[0].Hour != 110 {
+		t.Fatalf("hourly volume not pruned: have %d buckets", len(stats.Hourly))
+	}
+	// the statistics can't go back to a day needing the dropped volume
+	if !stats.Revertible(124) {
+		t.Fatal("statistics not revertible to the last day")
+	}
+	if stats.Revertible(123) {
+		t.Fatal("statistics revertible past the pruned volume")
+	}
+	stats.Revert(send, 124)
+	if stats.Supply.Int64() != 30 || stats.Holders != 1 || stats.Transfers != 3 || len(stats.Hourly) != 1 {
+		t.Fatalf("reverted statistics mismatch: have %+v", stats)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+// BalanceChange is a change of the balance of an asset of an account made by
+// the execution of a block.
+type BalanceChange struct {
+	Account  common.Name `json:"account"`
+	AssetID  uint64      `json:"assetID"`
+	Previous *big.Int    `json:"previous"`
+	Balance  *big.Int    `json:"balance"`
+}
+
+// BalanceDelta is the change of the balance of an asset of an account.
+type BalanceDelta struct {
+	Account common.Name `json:"account"`
+	AssetID uint64      `json:"assetID"`
+	Delta   *big.Int    `json:"delta"`
+}
+
+// NewBalanceDeltas returns the deltas of the balance changes, nil if none.
+func NewBalanceDeltas(changes []*BalanceChange) []*BalanceDelta {
+	var deltas []*BalanceDelta
+	for _, c := range changes {
+		deltas = append(deltas, &BalanceDelta{Account: c.Account, AssetID: c.AssetID, Delta: new(big.Int).Sub(c.Balance, c.Previous)})
+	}
+	return deltas
+}
@@ -17,10 +17,12 @@
 package types
 
 import (
+	"bytes"
 	"math/big"
 	"testing"
 
 	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -82,3 +84,25 @@ func TestBlockHeaderEncodeRLPAndDecodeRLP(t *testing.T) {
 	}
 	assert.Equal(t, testHeader, newHeader)
 }
+
+func FuzzDecodeHeader(f *testing.F) {
+	enc, err := testHeader.EncodeRLP()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(enc)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var header Header
+		if err := rlp.DecodeBytes(data, &header); err != nil {
+			return
+		}
+		enc, err := header.EncodeRLP()
+		if err != nil {
+			t.Fatalf("failed to encode decoded header: %v", err)
+		}
+		if !bytes.Equal(enc, data) {
+			t.Fatalf("re-encoding mismatch: have %x, want %x", enc, data)
+		}
+		header.Hash()
+	})
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+// ownValueActions are the actions whose value isn't transferred from their
+// sender to their recipient: the value is staked, bid or only describes the
+// action.
+var ownValueActions = map[ActionType]bool{
+	ReviveAccount:     true,
+	BidName:           true,
+	SettleName:        true,
+	SetFeeAsset:       true,
+	SetMinGasPrice:    true,
+	SetRateLimit:      true,
+	StakeResource:     true,
+	UnstakeResource:   true,
+	ProposeWithdrawal: true,
+	ApproveWithdrawal: true,
+	ExecuteWithdrawal: true,
+	SetMultisig:       true,
+	ProposeMultisig:   true,
+	ApproveMultisig:   true,
+	ExecuteMultisig:   true,
+}
+
+// MovesValue reports whether the value of an action of the type is
+// transferred from its sender to its recipient.
+func (t ActionType) MovesValue() bool {
+	return !ownValueActions[t]
+}
+
+// ActionTrace is the execution trace of an action: its result, the internal
+// transactions it made and the logs it emitted.
+type ActionTrace struct {
+	Index       uint64        `json:"index"`
+	ActionType  uint64        `json:"actionType"`
+	From        common.Name   `json:"from"`
+	To          common.Name   `json:"to"`
+	AssetID     uint64        `json:"assetID"`
+	Value       *big.Int      `json:"value"`
+	Status      uint64        `json:"status"`
+	GasUsed     uint64        `json:"gasUsed"`
+	Error       string        `json:"error"`
+	InternalTxs []*InternalTx `json:"internalTxs"`
+	Logs        []*Log        `json:"logs"`
+}
+
+// TxTrace is the execution trace of a transaction.
+type TxTrace struct {
+	Hash          common.Hash     `json:"hash"`
+	Index         uint64          `json:"index"`
+	Actions       []*ActionTrace  `json:"actions"`
+	GasUsed       uint64          `json:"gasUsed"`
+	FeeAssetID    uint64          `json:"feeAssetID"`
+	Fee           *big.Int        `json:"fee"`
+	FeePayments   []*FeePayment   `json:"feePayments"`
+	BalanceDeltas []*BalanceDelta `json:"balanceDeltas"`
+}
+
+// BlockTrace is the execution trace of the transactions of a block. Removed
+// is set on the traces of blocks dropped from the canonical chain by a
+// reorganisation.
+type BlockTrace struct {
+	Number     uint64      `json:"number"`
+	Hash       common.Hash `json:"hash"`
+	ParentHash common.Hash `json:"parentHash"`
+	Time       *big.Int    `json:"timestamp"`
+	Coinbase   common.Name `json:"coinbase"`
+	Removed    bool        `json:"removed"`
+	Txs        []*TxTrace  `json:"transactions"`
+}
+
+// NewBlockTrace returns the execution trace of a block from its receipts and
+// internal transactions. The balance deltas of a transaction are the balance
+// changes recorded in its receipt by the execution, none for the receipts
+// written before they were recorded.
+func NewBlockTrace(block *Block, receipts []*Receipt, itxs []*InternalTx) *BlockTrace {
+	trace := &BlockTrace{
+		Number:     block.NumberU64(),
+		Hash:       block.Hash(),
+		ParentHash: block.ParentHash(),
+		Time:       block.Time(),
+		Coinbase:   block.Coinbase(),
+		Txs:        []*TxTrace{},
+	}
+	for i, tx := range block.Transactions() {
+		if i >= len(receipts) {
+			break
+		}
+		trace.Txs = append(trace.Txs, newTxTrace(tx, uint64(i), receipts[i], itxs))
+	}
+	return trace
+}
+
+func newTxTrace(tx *Transaction, index uint64, receipt *Receipt, itxs []*InternalTx) *TxTrace {
+	trace := &TxTrace{
+		Hash:          tx.Hash(),
+		Index:         index,
+		Actions:       []*ActionTrace{},
+		GasUsed:       receipt.TotalGasUsed,
+		FeeAssetID:    receipt.FeeAssetID,
+		Fee:           receipt.Fee,
+		FeePayments:   receipt.FeePayments,
+		BalanceDeltas: []*BalanceDelta{},
+	}
+	if receipt.BalanceChanges != nil {
+		trace.BalanceDeltas = NewBalanceDeltas(receipt.BalanceChanges)
+	}
+	for j, action := range tx.GetActions() {
+		at := &ActionTrace{
+			Index:       uint64(j),
+			ActionType:  uint64(action.Type()),
+			From:        action.Sender(),
+			To:          action.Recipient(),
+			AssetID:     action.AssetID(),
+			Value:       action.Value(),
+			InternalTxs: []*InternalTx{},
+			Logs:        []*Log{},
+		}
+		if j < len(receipt.ActionResults) {
+			result := receipt.ActionResults[j]
+			at.Status, at.GasUsed, at.Error = result.Status, result.GasUsed, result.Error
+		}
+		for _, itx := range itxs {
+			if uint64(itx.TxIndex) == index && uint64(itx.ActionIndex) == at.Index {
+				at.InternalTxs = append(at.InternalTxs, itx)
+			}
+		}
+		for _, l := range receipt.Logs {
+			if uint64(l.ActionIndex) == at.Index {
+				at.Logs = append(at.Logs, l)
+			}
+		}
+		trace.Actions = append(trace.Actions, at)
+	}
+	return trace
+}
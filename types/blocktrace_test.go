@@ -0,0 +1,86 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestNewBlockTrace(t *testing.T) {
+	transfer := NewTransaction(0, big.NewInt(1), NewAction(Transfer, common.Name("alice"), common.Name("bob"), 0, 1, 21000, big.NewInt(100), nil))
+	call := NewTransaction(0, big.NewInt(1),
+		NewAction(CallContract, common.Name("alice"), common.Name("contract"), 1, 1, 50000, big.NewInt(50), nil),
+		NewAction(StakeResource, common.Name("alice"), common.Name("alice"), 2, 1, 21000, big.NewInt(30), nil),
+		NewAction(Transfer, common.Name("alice"), common.Name("bob"), 3, 1, 21000, big.NewInt(70), nil),
+	)
+	block := &Block{Head: &Header{Number: big.NewInt(7), Time: big.NewInt(1), Coinbase: common.Name("producer")}, Txs: []*Transaction{transfer, call}}
+
+	fee := func(amount int64) []*FeePayment {
+		return []*FeePayment{{Kind: FeeToProducer, Recipient: common.Name("producer"), Amount: big.NewInt(amount)}}
+	}
+	receipts := []*Receipt{
+		{
+			ActionResults: []*ActionResult{{Status: ReceiptStatusSuccessful, GasUsed: 21000}},
+			FeeAssetID:    1,
+			Fee:           big.NewInt(10),
+			FeePayments:   fee(10),
+		},
+		{
+			ActionResults: []*ActionResult{
+				{Status: ReceiptStatusSuccessful, Index: 0, GasUsed: 30000},
+				{Status: ReceiptStatusSuccessful, Index: 1, GasUsed: 21000},
+				{Status: ReceiptStatusFailed, Index: 2, GasUsed: 21000, Error: "insufficient balance"},
+			},
+			Logs:        []*Log{{Name: common.Name("contract"), ActionIndex: 0}},
+			FeeAssetID:  1,
+			Fee:         big.NewInt(5),
+			FeePayments: fee(5),
+		},
+	}
+	itxs := []*InternalTx{
+		{Type: "call", From: common.Name("contract"), To: common.Name("carol"), AssetID: 1, Value: big.NewInt(20), TxIndex: 1, ActionIndex: 0},
+		{Type: "call", From: common.Name("contract"), To: common.Name("dave"), AssetID: 1, Value: big.NewInt(5), Error: "reverted", TxIndex: 1, ActionIndex: 0},
+	}
+
+	trace := NewBlockTrace(block, receipts, itxs)
+	if trace.Number != 7 || trace.Hash != block.Hash() || len(trace.Txs) != 2 {
+		t.Fatalf("block trace mismatch: have number %d, %d transactions", trace.Number, len(trace.Txs))
+	}
+	if actions := trace.Txs[1].Actions; len(actions[0].InternalTxs) != 2 || len(actions[0].Logs) != 1 || len(actions[2].Logs) != 0 {
+		t.Fatalf("internal transactions or logs not attached to their action")
+	}
+	if a := trace.Txs[1].Actions[2]; a.Status != ReceiptStatusFailed || a.Error == "" {
+		t.Fatalf("failed action result mismatch: have status %d, error %q", a.Status, a.Error)
+	}
+
+	// The deltas are those recorded by the execution, none for the receipts
+	// written before they were.
+	if deltas := trace.Txs[0].BalanceDeltas; len(deltas) != 0 {
+		t.Fatalf("deltas of a receipt without record: have %d, want 0", len(deltas))
+	}
+	receipts[1].BalanceChanges = []*BalanceChange{
+		{Account: common.Name("alice"), AssetID: 1, Previous: big.NewInt(100), Balance: big.NewInt(15)},
+		{Account: common.Name("contract"), AssetID: 1, Previous: big.NewInt(0), Balance: big.NewInt(30)},
+	}
+	trace = NewBlockTrace(block, receipts, itxs)
+	if deltas := trace.Txs[1].BalanceDeltas; len(deltas) != 2 || deltas[0].Delta.Int64() != -85 || deltas[1].Account != "contract" {
+		t.Fatalf("deltas mismatch: %+v", deltas)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/common"
+)
+
+// Checkpoint identifies a block, its state and the producer schedule activated
+// at it, co-signed by the producers so that nodes syncing the chain can trust
+// it without executing every block. The producers of the schedule attested by
+// a checkpoint sign the next one.
+//
+// The state root of a header only commits to the state changed by the block,
+// so StateHash commits to the whole state at the block, see
+// state.StateHasher.
+type Checkpoint struct {
+	Number    uint64      `json:"number"`
+	Hash      common.Hash `json:"hash"`
+	Root      common.Hash `json:"root"`
+	StateHash common.Hash `json:"stateHash"`
+	Schedule  common.Hash `json:"schedule"`
+}
+
+// SigHash returns the hash producers sign to vote for the checkpoint on the
+// chain of the given id.
+func (cp *Checkpoint) SigHash(chainID *big.Int) common.Hash {
+	return rlpHash([]interface{}{cp.Number, cp.Hash, cp.Root, cp.StateHash, cp.Schedule, chainID})
+}
+
+// CheckpointProducer is a producer of a schedule attested by a checkpoint and
+// the key it signs checkpoints with.
+type CheckpointProducer struct {
+	Name   string        `json:"name"`
+	PubKey hexutil.Bytes `json:"pubKey"`
+}
+
+// ScheduleHash returns the hash of a producer schedule attested by
+// checkpoints.
+func ScheduleHash(schedule []*CheckpointProducer) common.Hash {
+	return rlpHash(schedule)
+}
+
+// CheckpointSignature is the signature of a producer on a checkpoint.
+type CheckpointSignature struct {
+	Producer  string        `json:"producer"`
+	Signature hexutil.Bytes `json:"signature"`
+}
+
+// CheckpointVote is a checkpoint signed by a single producer, gossiped until
+// a quorum of the producers signed it. It carries the schedule the checkpoint
+// attests, so that the nodes without the state of the block can verify it.
+type CheckpointVote struct {
+	Checkpoint Checkpoint            `json:"checkpoint"`
+	Schedule   []*CheckpointProducer `json:"schedule"`
+	CheckpointSignature
+}
+
+// Hash identifies the vote when it is relayed between nodes.
+func (v *CheckpointVote) Hash() common.Hash {
+	return rlpHash(v)
+}
+
+// SignedCheckpoint is a checkpoint signed by a quorum of the producers of the
+// schedule attested by the previous one, the genesis schedule for the first.
+type SignedCheckpoint struct {
+	Checkpoint Checkpoint            `json:"checkpoint"`
+	Schedule   []*CheckpointProducer `json:"schedule"`
+	Signatures []CheckpointSignature `json:"signatures"`
+}
+
+// Votes returns the signatures of the checkpoint as single votes.
+func (sc *SignedCheckpoint) Votes() []*CheckpointVote {
+	votes := make([]*CheckpointVote, len(sc.Signatures))
+	for i, sig := range sc.Signatures {
+		votes[i] = &CheckpointVote{Checkpoint: sc.Checkpoint, Schedule: sc.Schedule, CheckpointSignature: sig}
+	}
+	return votes
+}
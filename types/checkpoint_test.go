@@ -0,0 +1,68 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+func TestCheckpointSigHash(t *testing.T) {
+	cp := &Checkpoint{Number: 100, Hash: common.HexToHash("0x01"), Root: common.HexToHash("0x02")}
+	if cp.SigHash(big.NewInt(1)) == cp.SigHash(big.NewInt(2)) {
+		t.Fatal("signature hash independent of the chain id")
+	}
+	other := *cp
+	other.Root = common.HexToHash("0x03")
+	if cp.SigHash(big.NewInt(1)) == other.SigHash(big.NewInt(1)) {
+		t.Fatal("signature hash independent of the state root")
+	}
+	other = *cp
+	other.StateHash = common.HexToHash("0x04")
+	if cp.SigHash(big.NewInt(1)) == other.SigHash(big.NewInt(1)) {
+		t.Fatal("signature hash independent of the state hash")
+	}
+	other = *cp
+	other.Schedule = ScheduleHash([]*CheckpointProducer{{Name: "alice", PubKey: []byte{1}}})
+	if cp.SigHash(big.NewInt(1)) == other.SigHash(big.NewInt(1)) {
+		t.Fatal("signature hash independent of the attested schedule")
+	}
+}
+
+func TestSignedCheckpointRLP(t *testing.T) {
+	sc := &SignedCheckpoint{
+		Checkpoint: Checkpoint{Number: 100, Hash: common.HexToHash("0x01"), Root: common.HexToHash("0x02")},
+		Schedule:   []*CheckpointProducer{{Name: "alice", PubKey: []byte{4}}, {Name: "bob", PubKey: []byte{5}}},
+		Signatures: []CheckpointSignature{{Producer: "alice", Signature: []byte{1, 2}}, {Producer: "bob", Signature: []byte{3}}},
+	}
+	sc.Checkpoint.Schedule = ScheduleHash(sc.Schedule)
+	data, err := rlp.EncodeToBytes(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := new(SignedCheckpoint)
+	if err := rlp.DecodeBytes(data, dec); err != nil {
+		t.Fatal(err)
+	}
+	votes := dec.Votes()
+	if len(votes) != 2 || votes[1].Producer != "bob" || votes[1].Checkpoint != sc.Checkpoint || votes[0].Hash() == votes[1].Hash() || ScheduleHash(votes[0].Schedule) != sc.Checkpoint.Schedule {
+		t.Fatalf("votes mismatch: %+v", votes)
+	}
+}
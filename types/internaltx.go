@@ -0,0 +1,56 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+// InternalTx is a call or contract creation made by a contract during the
+// execution of an action. Internal transactions are recorded by the node and
+// are not secured by consensus.
+type InternalTx struct {
+	Type    string      `json:"type"`
+	From    common.Name `json:"from"`
+	To      common.Name `json:"to"`
+	AssetID uint64      `json:"assetID"`
+	Value   *big.Int    `json:"value"`
+	Gas     uint64      `json:"gas"`
+	GasUsed uint64      `json:"gasUsed"`
+	Depth   uint64      `json:"depth"`
+	Error   string      `json:"error"`
+
+	// Derived fields.
+	// hash of the transaction
+	TxHash common.Hash `json:"transactionHash"`
+	// index of the transaction in the block
+	TxIndex uint `json:"transactionIndex"`
+	// index of the action in the transaction
+	ActionIndex uint `json:"actionIndex"`
+	// index of the internal transaction in the block
+	Index uint `json:"index"`
+	// block in which the transaction was included, filled in when read
+	BlockNumber uint64      `json:"blockNumber" rlp:"-"`
+	BlockHash   common.Hash `json:"blockHash" rlp:"-"`
+}
+
+// Involves reports whether name sent or received the internal transaction.
+func (itx *InternalTx) Involves(name common.Name) bool {
+	return itx.From == name || itx.To == name
+}
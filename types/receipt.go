@@ -17,6 +17,9 @@
 package types
 
 import (
+	"io"
+	"math/big"
+
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/utils/rlp"
@@ -30,12 +33,62 @@ const (
 	ReceiptStatusSuccessful = uint64(1)
 )
 
+const (
+	// FeeToProducer is the kind of a fee payment to the block producer.
+	FeeToProducer = uint64(0)
+	// FeeToPool is the kind of a fee payment to the fee pool.
+	FeeToPool = uint64(1)
+)
+
+// FeePayment is the part of a transaction fee paid to one recipient.
+type FeePayment struct {
+	Kind      uint64      `json:"kind"`
+	Recipient common.Name `json:"recipient"`
+	Amount    *big.Int    `json:"amount"`
+}
+
+// AddFeePayment adds amount to the payment of the given kind to the recipient,
+// keeping the payments in the order their recipients were first paid.
+func AddFeePayment(payments []*FeePayment, kind uint64, recipient common.Name, amount *big.Int) []*FeePayment {
+	for _, p := range payments {
+		if p.Kind == kind && p.Recipient == recipient {
+			p.Amount = new(big.Int).Add(p.Amount, amount)
+			return payments
+		}
+	}
+	return append(payments, &FeePayment{Kind: kind, Recipient: recipient, Amount: new(big.Int).Set(amount)})
+}
+
+// ActionAuth records the authority that approved an action: the account
+// whose authority was satisfied, the keys that signed for it and their
+// weight against the threshold of the authority.
+type ActionAuth struct {
+	Account   common.Name     `json:"account"`
+	Keys      []common.PubKey `json:"keys"`
+	Weight    uint64          `json:"weight"`
+	Threshold uint64          `json:"threshold"`
+}
+
 // ActionResult represents the results the transaction action.
 type ActionResult struct {
 	Status  uint64
 	Index   uint64
 	GasUsed uint64
 	Error   string
+
+	// Authorizations follow the other results, so that the receipts written
+	// before they were recorded still decode. They are left out of the
+	// receipts root like the fee records.
+	Authorizations []*ActionAuth `rlp:"tail"`
+}
+
+// consensusActionResult is the part of an action result committed to by the
+// receipts root.
+type consensusActionResult struct {
+	Status  uint64
+	Index   uint64
+	GasUsed uint64
+	Error   string
 }
 
 // EncodeRLP implements rlp.Encoder
@@ -45,7 +98,19 @@ func (a *ActionResult) EncodeRLP() ([]byte, error) {
 
 // DecodeRLP implements rlp.Decoder
 func (a *ActionResult) DecodeRLP(data []byte) error {
-	return rlp.DecodeBytes(data, a)
+	if err := rlp.DecodeBytes(data, a); err != nil {
+		return err
+	}
+	a.normalize()
+	return nil
+}
+
+// normalize leaves the authorizations of a result decoded without any nil,
+// like those of a result never encoded.
+func (a *ActionResult) normalize() {
+	if len(a.Authorizations) == 0 {
+		a.Authorizations = nil
+	}
 }
 
 // RPCActionResult that will serialize to the RPC representation of a ActionResult.
@@ -55,6 +120,8 @@ type RPCActionResult struct {
 	Index      uint64 `json:"index"`
 	GasUsed    uint64 `json:"gasUsed"`
 	Error      string `json:"error"`
+
+	Authorizations []*ActionAuth `json:"authorizations,omitempty"`
 }
 
 // NewRPCActionResult returns a ActionResult that will serialize to the RPC.
@@ -65,6 +132,8 @@ func (a *ActionResult) NewRPCActionResult(aType ActionType) *RPCActionResult {
 		Index:      a.Index,
 		GasUsed:    a.GasUsed,
 		Error:      a.Error,
+
+		Authorizations: a.Authorizations,
 	}
 }
 
@@ -77,21 +146,104 @@ type Receipt struct {
 	Logs              []*Log
 	TxHash            common.Hash
 	TotalGasUsed      uint64
+
+	// The records of the execution are stored in the tail of the storage
+	// encoding, see ReceiptForStorage.
+	FeeAssetID     uint64           `rlp:"-"`
+	Fee            *big.Int         `rlp:"-"`
+	FeePayments    []*FeePayment    `rlp:"-"`
+	BalanceChanges []*BalanceChange `rlp:"-"`
 }
 
 // NewReceipt creates a barebone transaction receipt, copying the init fields.
 func NewReceipt(root []byte, cumulativeGasUsed, totalGasUsed uint64) *Receipt {
-	return &Receipt{PostState: common.CopyBytes(root), CumulativeGasUsed: cumulativeGasUsed, TotalGasUsed: totalGasUsed}
+	return &Receipt{PostState: common.CopyBytes(root), CumulativeGasUsed: cumulativeGasUsed, TotalGasUsed: totalGasUsed, Fee: new(big.Int)}
 }
 
 // EncodeRLP implements rlp.Encoder
 func (r *Receipt) EncodeRLP() ([]byte, error) {
-	return rlp.EncodeToBytes(r)
+	return rlp.EncodeToBytes((*ReceiptForStorage)(r))
 }
 
 // DecodeRLP implements rlp.Decoder
 func (r *Receipt) DecodeRLP(data []byte) error {
-	return rlp.DecodeBytes(data, r)
+	return rlp.DecodeBytes(data, (*ReceiptForStorage)(r))
+}
+
+// ReceiptForStorage is the storage encoding of a receipt: the fields of the
+// receipts written before the records of the execution, followed by the
+// records in the tail, so that those receipts still decode.
+type ReceiptForStorage Receipt
+
+// storedReceipt is the RLP layout of a ReceiptForStorage.
+type storedReceipt struct {
+	PostState         []byte
+	ActionResults     []*ActionResult
+	CumulativeGasUsed uint64
+	Bloom             Bloom
+	Logs              []*Log
+	TxHash            common.Hash
+	TotalGasUsed      uint64
+	Records           []*receiptRecords `rlp:"tail"`
+}
+
+// receiptRecords are the records of the execution of a transaction.
+type receiptRecords struct {
+	FeeAssetID     uint64
+	Fee            *big.Int
+	FeePayments    []*FeePayment
+	BalanceChanges []*BalanceChange
+}
+
+// EncodeRLP implements rlp.Encoder
+func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, &storedReceipt{
+		PostState:         r.PostState,
+		ActionResults:     r.ActionResults,
+		CumulativeGasUsed: r.CumulativeGasUsed,
+		Bloom:             r.Bloom,
+		Logs:              r.Logs,
+		TxHash:            r.TxHash,
+		TotalGasUsed:      r.TotalGasUsed,
+		Records: []*receiptRecords{{
+			FeeAssetID:     r.FeeAssetID,
+			Fee:            r.Fee,
+			FeePayments:    r.FeePayments,
+			BalanceChanges: r.BalanceChanges,
+		}},
+	})
+}
+
+// DecodeRLP implements rlp.Decoder
+func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
+	var stored storedReceipt
+	if err := s.Decode(&stored); err != nil {
+		return err
+	}
+	*r = ReceiptForStorage{
+		PostState:         stored.PostState,
+		ActionResults:     stored.ActionResults,
+		CumulativeGasUsed: stored.CumulativeGasUsed,
+		Bloom:             stored.Bloom,
+		Logs:              stored.Logs,
+		TxHash:            stored.TxHash,
+		TotalGasUsed:      stored.TotalGasUsed,
+		Fee:               new(big.Int),
+	}
+	for _, a := range r.ActionResults {
+		a.normalize()
+	}
+	if len(stored.Records) > 0 {
+		records := stored.Records[0]
+		r.FeeAssetID, r.Fee = records.FeeAssetID, records.Fee
+		if len(records.FeePayments) > 0 {
+			r.FeePayments = records.FeePayments
+		}
+		if len(records.BalanceChanges) > 0 {
+			r.BalanceChanges = records.BalanceChanges
+		}
+	}
+	return nil
 }
 
 // Size returns the approximate memory used by all internal contents
@@ -100,9 +252,34 @@ func (r *Receipt) Size() common.StorageSize {
 	return common.StorageSize(len(bytes))
 }
 
-// Hash hashes the RLP encoding of Receipt.
+// consensusReceipt is the part of a receipt committed to by the receipts
+// root of its block. The fee records are derived from the execution and left
+// out, so that recording them kept the roots of the blocks.
+type consensusReceipt struct {
+	PostState         []byte
+	ActionResults     []*consensusActionResult
+	CumulativeGasUsed uint64
+	Bloom             Bloom
+	Logs              []*Log
+	TxHash            common.Hash
+	TotalGasUsed      uint64
+}
+
+// Hash hashes the RLP encoding of the consensus fields of the Receipt.
 func (r *Receipt) Hash() common.Hash {
-	return rlpHash(r)
+	results := make([]*consensusActionResult, len(r.ActionResults))
+	for i, a := range r.ActionResults {
+		results[i] = &consensusActionResult{Status: a.Status, Index: a.Index, GasUsed: a.GasUsed, Error: a.Error}
+	}
+	return rlpHash(&consensusReceipt{
+		PostState:         r.PostState,
+		ActionResults:     results,
+		CumulativeGasUsed: r.CumulativeGasUsed,
+		Bloom:             r.Bloom,
+		Logs:              r.Logs,
+		TxHash:            r.TxHash,
+		TotalGasUsed:      r.TotalGasUsed,
+	})
 }
 
 // RPCReceipt that will serialize to the RPC representation of a Receipt.
@@ -115,6 +292,9 @@ type RPCReceipt struct {
 	ActionResults     []*RPCActionResult `json:"actionResults"`
 	CumulativeGasUsed uint64             `json:"cumulativeGasUsed"`
 	TotalGasUsed      uint64             `json:"totalGasUsed"`
+	FeeAssetID        uint64             `json:"feeAssetID"`
+	Fee               *big.Int           `json:"fee"`
+	FeePayments       []*FeePayment      `json:"feePayments"`
 	Bloom             Bloom              `json:"logsBloom"`
 	Logs              []*Log             `json:"logs"`
 }
@@ -129,6 +309,9 @@ func (r *Receipt) NewRPCReceipt(blockHash common.Hash, blockNumber uint64, index
 		PostState:         hexutil.Bytes(r.PostState),
 		CumulativeGasUsed: r.CumulativeGasUsed,
 		TotalGasUsed:      r.TotalGasUsed,
+		FeeAssetID:        r.FeeAssetID,
+		Fee:               r.Fee,
+		FeePayments:       r.FeePayments,
 		Bloom:             r.Bloom,
 		Logs:              r.Logs,
 	}
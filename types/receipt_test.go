@@ -17,8 +17,12 @@
 package types
 
 import (
+	"math/big"
 	"testing"
 
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/utils/rlp"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,6 +30,9 @@ func TestReceiptEncodeAndDecode(t *testing.T) {
 	testR := NewReceipt([]byte("root"), 1000, 1000)
 	testR.Logs = make([]*Log, 0)
 	testR.ActionResults = append(testR.ActionResults, &ActionResult{Status: ReceiptStatusFailed, Index: uint64(0), GasUsed: uint64(100)})
+	testR.FeeAssetID = 1
+	testR.Fee = big.NewInt(2000)
+	testR.FeePayments = AddFeePayment(nil, FeeToProducer, common.Name("producer"), testR.Fee)
 	bytes, err := testR.EncodeRLP()
 	if err != nil {
 		t.Fatal(err)
@@ -35,3 +42,120 @@ func TestReceiptEncodeAndDecode(t *testing.T) {
 
 	assert.Equal(t, testR, newR)
 }
+
+func TestReceiptHashConsensusFields(t *testing.T) {
+	testR := NewReceipt([]byte("root"), 1000, 1000)
+	testR.ActionResults = append(testR.ActionResults, &ActionResult{Status: ReceiptStatusSuccessful, GasUsed: uint64(100)})
+	legacy := struct {
+		PostState         []byte
+		ActionResults     []*ActionResult
+		CumulativeGasUsed uint64
+		Bloom             Bloom
+		Logs              []*Log
+		TxHash            common.Hash
+		TotalGasUsed      uint64
+	}{testR.PostState, testR.ActionResults, testR.CumulativeGasUsed, testR.Bloom, testR.Logs, testR.TxHash, testR.TotalGasUsed}
+	hash := testR.Hash()
+	assert.Equal(t, rlpHash(&legacy), hash)
+
+	testR.FeeAssetID = 1
+	testR.Fee = big.NewInt(2000)
+	testR.FeePayments = AddFeePayment(nil, FeeToProducer, common.Name("producer"), testR.Fee)
+	assert.Equal(t, hash, testR.Hash())
+
+	testR.ActionResults[0].Authorizations = []*ActionAuth{{Account: common.Name("authuser"), Weight: 1, Threshold: 1}}
+	assert.Equal(t, hash, testR.Hash())
+}
+
+func TestAddFeePayment(t *testing.T) {
+	var payments []*FeePayment
+	payments = AddFeePayment(payments, FeeToProducer, common.Name("producera"), big.NewInt(10))
+	payments = AddFeePayment(payments, FeeToProducer, common.Name("producerb"), big.NewInt(20))
+	payments = AddFeePayment(payments, FeeToProducer, common.Name("producera"), big.NewInt(5))
+
+	assert.Equal(t, 2, len(payments))
+	assert.Equal(t, common.Name("producera"), payments[0].Recipient)
+	assert.Equal(t, big.NewInt(15), payments[0].Amount)
+	assert.Equal(t, big.NewInt(20), payments[1].Amount)
+}
+
+func TestActionResultAuthorizations(t *testing.T) {
+	legacy := struct {
+		Status  uint64
+		Index   uint64
+		GasUsed uint64
+		Error   string
+	}{ReceiptStatusSuccessful, 1, 100, ""}
+	data, err := rlp.EncodeToBytes(&legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(ActionResult)
+	if err := rlp.DecodeBytes(data, result); err != nil {
+		t.Fatalf("legacy result doesn't decode: %v", err)
+	}
+	if result.GasUsed != 100 || len(result.Authorizations) != 0 {
+		t.Fatalf("legacy result mismatch: %+v", result)
+	}
+
+	result.Authorizations = []*ActionAuth{{
+		Account:   common.Name("authuser"),
+		Keys:      []common.PubKey{common.HexToPubKey("0x0401")},
+		Weight:    1,
+		Threshold: 1,
+	}}
+	if data, err = rlp.EncodeToBytes(result); err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(ActionResult)
+	if err := rlp.DecodeBytes(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, result, decoded)
+}
+
+func TestReceiptDecodeLegacy(t *testing.T) {
+	// A receipt as written before the records of the execution.
+	legacy := struct {
+		PostState         []byte
+		ActionResults     []*consensusActionResult
+		CumulativeGasUsed uint64
+		Bloom             Bloom
+		Logs              []*Log
+		TxHash            common.Hash
+		TotalGasUsed      uint64
+	}{
+		PostState:         []byte("root"),
+		ActionResults:     []*consensusActionResult{{Status: ReceiptStatusSuccessful, GasUsed: 100}},
+		CumulativeGasUsed: 1000,
+		Logs:              []*Log{},
+		TxHash:            common.BytesToHash([]byte{1}),
+		TotalGasUsed:      100,
+	}
+	bytes, err := rlp.EncodeToBytes([]interface{}{&legacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var receipts []*ReceiptForStorage
+	if err := rlp.DecodeBytes(bytes, &receipts); err != nil {
+		t.Fatal(err)
+	}
+	want := NewReceipt([]byte("root"), 1000, 100)
+	want.ActionResults = []*ActionResult{{Status: ReceiptStatusSuccessful, GasUsed: 100}}
+	want.Logs = []*Log{}
+	want.TxHash = legacy.TxHash
+	assert.Equal(t, want, (*Receipt)(receipts[0]))
+
+	// The records are kept in the tail.
+	want.FeeAssetID, want.Fee = 1, big.NewInt(10)
+	want.FeePayments = AddFeePayment(nil, FeeToProducer, common.Name("producer"), want.Fee)
+	want.BalanceChanges = []*BalanceChange{{Account: common.Name("producer"), AssetID: 1, Previous: big.NewInt(0), Balance: big.NewInt(10)}}
+	if bytes, err = rlp.EncodeToBytes((*ReceiptForStorage)(want)); err != nil {
+		t.Fatal(err)
+	}
+	have := new(ReceiptForStorage)
+	if err := rlp.DecodeBytes(bytes, have); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, want, (*Receipt)(have))
+}
@@ -129,7 +129,7 @@ func (s Signer) SignatureValues(sig []byte) (R, S, V *big.Int, err error) {
 func (s Signer) Hash(tx *Transaction) common.Hash {
 	actionHashs := make([]common.Hash, len(tx.GetActions()))
 	for _, a := range tx.GetActions() {
-		hash := rlpHash([]interface{}{
+		fields := []interface{}{
 			a.data.AType,
 			a.data.Nonce,
 			a.data.To,
@@ -137,8 +137,13 @@ func (s Signer) Hash(tx *Transaction) common.Hash {
 			a.data.Amount,
 			a.data.Payload,
 			s.chainID, uint(0), uint(0),
-		})
-		actionHashs = append(actionHashs, hash)
+		}
+		// Extensions are only signed if present, leaving the hash of actions
+		// without extensions unchanged.
+		if len(a.data.Extensions) != 0 {
+			fields = append(fields, a.data.Extensions)
+		}
+		actionHashs = append(actionHashs, rlpHash(fields))
 	}
 
 	return rlpHash([]interface{}{
@@ -17,9 +17,11 @@
 package types
 
 import (
+	"bytes"
 	"container/heap"
 	"io"
 	"math/big"
+	"sort"
 	"sync/atomic"
 
 	"github.com/fractalplatform/fractal/common"
@@ -194,12 +196,58 @@ func TxDifference(a, b []*Transaction) []*Transaction {
 	return keep
 }
 
+// TxFeeFunc returns the effective gas price of a transaction, the price a
+// block producer is paid per unit of gas in the fee token of the chain.
+type TxFeeFunc func(tx *Transaction) *big.Int
+
+// txWithFee is a transaction along with its cached effective gas price.
+type txWithFee struct {
+	tx     *Transaction
+	sender common.Name
+	fee    *big.Int
+}
+
+// txsByFee implements the heap interface over the next transaction of each
+// account, ordering them by descending effective gas price. Ties are broken by
+// the ascending sender name and then by the ascending transaction hash, so the
+// order is total and doesn't depend on the order the accounts were added in.
+type txsByFee []*txWithFee
+
+func (s txsByFee) Len() int { return len(s) }
+func (s txsByFee) Less(i, j int) bool {
+	if cmp := s[i].fee.Cmp(s[j].fee); cmp != 0 {
+		return cmp > 0
+	}
+	if s[i].sender != s[j].sender {
+		return s[i].sender < s[j].sender
+	}
+	hi, hj := s[i].tx.Hash(), s[j].tx.Hash()
+	return bytes.Compare(hi[:], hj[:]) < 0
+}
+func (s txsByFee) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s *txsByFee) Push(x interface{}) {
+	*s = append(*s, x.(*txWithFee))
+}
+func (s *txsByFee) Pop() interface{} {
+	old := *s
+	n := len(old)
+	x := old[n-1]
+	*s = old[0 : n-1]
+	return x
+}
+
 // TransactionsByPriceAndNonce represents a set of transactions that can return
 // transactions in a profit-maximizing sorted order, while supporting removing
 // entire batches of transactions for non-executable accounts.
+//
+// The transactions are grouped by the sender of their first action, each group
+// is ordered by ascending nonce and the groups are ordered by the effective gas
+// price of their next transaction, see txsByFee for the tie-breakers. The same
+// set of transactions thus always yields the same sequence.
 type TransactionsByPriceAndNonce struct {
 	txs   map[common.Name][]*Transaction // Per account nonce-sorted list of transactions
-	heads TxByPrice                      // Next transaction for each unique account (price heap)
+	heads txsByFee                       // Next transaction for each unique account (fee heap)
+	fee   TxFeeFunc                      // Effective gas price of the transactions
 }
 
 // NewTransactionsByPriceAndNonce creates a transaction set that can retrieve
@@ -208,24 +256,48 @@ type TransactionsByPriceAndNonce struct {
 // Note, the input map is reowned so the caller should not interact any more with
 // if after providing it to the constructor.
 func NewTransactionsByPriceAndNonce(txs map[common.Name][]*Transaction) *TransactionsByPriceAndNonce {
-	// Initialize a price based heap with the head transactions
-	heads := make(TxByPrice, 0, len(txs))
-	for from, accTxs := range txs {
-		heads = append(heads, accTxs[0])
-		// Ensure the sender address is from the signer
-		action := accTxs[0].actions[0]
-		acc := action.Sender()
-		txs[acc] = accTxs[1:]
-		if from != acc {
-			delete(txs, from)
+	return NewTransactionsByFeeAndNonce(txs, nil)
+}
+
+// NewTransactionsByFeeAndNonce creates a transaction set that can retrieve
+// transactions sorted by the effective gas price returned by fee in a
+// nonce-honouring way. A nil fee sorts them by their gas price.
+//
+// Note, the input map is reowned so the caller should not interact any more with
+// if after providing it to the constructor.
+func NewTransactionsByFeeAndNonce(txs map[common.Name][]*Transaction, fee TxFeeFunc) *TransactionsByPriceAndNonce {
+	if fee == nil {
+		fee = (*Transaction).GasPrice
+	}
+	// Regroup the transactions by the sender of their first action
+	groups := make(map[common.Name][]*Transaction, len(txs))
+	for _, accTxs := range txs {
+		for _, tx := range accTxs {
+			sender := tx.actions[0].Sender()
+			groups[sender] = append(groups[sender], tx)
 		}
 	}
+	// Initialize a fee based heap with the head transactions
+	heads := make(txsByFee, 0, len(groups))
+	for sender, accTxs := range groups {
+		sort.Slice(accTxs, func(i, j int) bool {
+			ni, nj := accTxs[i].actions[0].Nonce(), accTxs[j].actions[0].Nonce()
+			if ni != nj {
+				return ni < nj
+			}
+			hi, hj := accTxs[i].Hash(), accTxs[j].Hash()
+			return bytes.Compare(hi[:], hj[:]) < 0
+		})
+		heads = append(heads, &txWithFee{tx: accTxs[0], sender: sender, fee: fee(accTxs[0])})
+		groups[sender] = accTxs[1:]
+	}
 	heap.Init(&heads)
 
 	// Assemble and return the transaction set
 	return &TransactionsByPriceAndNonce{
-		txs:   txs,
+		txs:   groups,
 		heads: heads,
+		fee:   fee,
 	}
 }
 
@@ -234,15 +306,15 @@ func (t *TransactionsByPriceAndNonce) Peek() *Transaction {
 	if len(t.heads) == 0 {
 		return nil
 	}
-	return t.heads[0]
+	return t.heads[0].tx
 }
 
 // Shift replaces the current best head with the next one from the same account.
 func (t *TransactionsByPriceAndNonce) Shift() {
-	action := t.heads[0].actions[0]
-	acc := action.Sender()
-	if txs, ok := t.txs[acc]; ok && len(txs) > 0 {
-		t.heads[0], t.txs[acc] = txs[0], txs[1:]
+	head := t.heads[0]
+	if txs, ok := t.txs[head.sender]; ok && len(txs) > 0 {
+		t.heads[0] = &txWithFee{tx: txs[0], sender: head.sender, fee: t.fee(txs[0])}
+		t.txs[head.sender] = txs[1:]
 		heap.Fix(&t.heads, 0)
 	} else {
 		heap.Pop(&t.heads)
@@ -20,6 +20,7 @@ import (
 	"math/big"
 	"testing"
 
+	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/utils/rlp"
 	"github.com/stretchr/testify/assert"
 )
@@ -46,3 +47,72 @@ func TestTransactionEncodeAndDecode(t *testing.T) {
 	}
 	assert.Equal(t, newtxbytes, txbytes)
 }
+
+func TestTransactionsByFeeAndNonce(t *testing.T) {
+	newTx := func(from string, nonce, assetID uint64, price int64) *Transaction {
+		return NewTransaction(assetID, big.NewInt(price), NewAction(Transfer, common.Name(from), "bob", nonce, 1, 100000, big.NewInt(1), nil))
+	}
+	var (
+		alice = []*Transaction{newTx("alice", 2, 1, 10), newTx("alice", 0, 1, 10), newTx("alice", 1, 1, 10)}
+		bob   = []*Transaction{newTx("bob", 1, 1, 10), newTx("bob", 0, 1, 10)}
+		carol = []*Transaction{newTx("carol", 0, 2, 6)}
+	)
+	// Gas paid in asset 2 is worth twice its nominal price.
+	fee := func(tx *Transaction) *big.Int {
+		if tx.GasAssetID() == 2 {
+			return new(big.Int).Mul(tx.GasPrice(), big.NewInt(2))
+		}
+		return tx.GasPrice()
+	}
+	tests := []struct {
+		fee  TxFeeFunc
+		want []*Transaction
+	}{
+		{nil, []*Transaction{alice[1], alice[2], alice[0], bob[1], bob[0], carol[0]}},
+		{fee, []*Transaction{carol[0], alice[1], alice[2], alice[0], bob[1], bob[0]}},
+	}
+	for i, test := range tests {
+		// Map iteration varies between runs, the sequence mustn't.
+		for run := 0; run < 10; run++ {
+			txs := map[common.Name][]*Transaction{
+				"alice": append([]*Transaction{}, alice...),
+				"bob":   append([]*Transaction{}, bob...),
+				"carol": append([]*Transaction{}, carol...),
+			}
+			set := NewTransactionsByFeeAndNonce(txs, test.fee)
+			for j, want := range test.want {
+				tx := set.Peek()
+				if tx != want {
+					t.Fatalf("test %d run %d: transaction %d mismatch: have %x, want %x", i, run, j, tx.Hash(), want.Hash())
+				}
+				set.Shift()
+			}
+			if tx := set.Peek(); tx != nil {
+				t.Fatalf("test %d run %d: unexpected transaction %x", i, run, tx.Hash())
+			}
+		}
+	}
+}
+
+func FuzzDecodeTransaction(f *testing.F) {
+	txbytes, err := rlp.EncodeToBytes(testTx)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(txbytes)
+	f.Add([]byte{0xc0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var tx Transaction
+		if err := rlp.DecodeBytes(data, &tx); err != nil {
+			return
+		}
+		enc, err := rlp.EncodeToBytes(&tx)
+		if err != nil {
+			t.Fatalf("failed to encode decoded transaction: %v", err)
+		}
+		if !bytes.Equal(enc, data) {
+			t.Fatalf("re-encoding mismatch: have %x, want %x", enc, data)
+		}
+		tx.Hash()
+	})
+}
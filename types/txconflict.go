@@ -0,0 +1,103 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"bytes"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+// Sources of the transaction conflicts.
+const (
+	ConflictTxPool = "txpool" // a pooled transaction replaced, or challenged, by another one
+	ConflictFork   = "fork"   // a transaction of a dropped fork replaced by another one of the canonical chain
+)
+
+// TxConflict is an incident of two different transactions spending the same
+// nonce of an account, a possible double-spend attempt unless the second only
+// bumps the fees of the first.
+type TxConflict struct {
+	Account common.Name `json:"account"`
+	Nonce   uint64      `json:"nonce"`
+	First   common.Hash `json:"first"`   // transaction seen first
+	Second  common.Hash `json:"second"`  // transaction conflicting with the first
+	FeeBump bool        `json:"feeBump"` // whether the second differs from the first only in its fees
+	Source  string      `json:"source"`
+	Number  uint64      `json:"number"` // head block number when detected
+	Time    int64       `json:"time"`   // unix time when detected
+}
+
+// NewTxConflict returns the conflict between the transactions first and
+// second, or nil if they don't spend the same nonce of the same account.
+func NewTxConflict(first, second *Transaction, source string) *TxConflict {
+	if first == nil || second == nil || first.Hash() == second.Hash() {
+		return nil
+	}
+	// todo change action
+	a, b := first.GetActions()[0], second.GetActions()[0]
+	if a.Sender() != b.Sender() || a.Nonce() != b.Nonce() {
+		return nil
+	}
+	return &TxConflict{
+		Account: a.Sender(),
+		Nonce:   a.Nonce(),
+		First:   first.Hash(),
+		Second:  second.Hash(),
+		FeeBump: sameActions(first, second),
+		Source:  source,
+	}
+}
+
+// sameActions reports whether the transactions perform the same actions,
+// whatever their gas prices and limits.
+func sameActions(first, second *Transaction) bool {
+	a, b := first.GetActions(), second.GetActions()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type() != b[i].Type() || a[i].Sender() != b[i].Sender() || a[i].Recipient() != b[i].Recipient() ||
+			a[i].Nonce() != b[i].Nonce() || a[i].AssetID() != b[i].AssetID() ||
+			a[i].Value().Cmp(b[i].Value()) != 0 || !bytes.Equal(a[i].Data(), b[i].Data()) {
+			return false
+		}
+	}
+	return true
+}
+
+// TxConflicts returns the conflicts between the transactions of a dropped
+// fork and those of the chain replacing it.
+func TxConflicts(dropped, added []*Transaction) []*TxConflict {
+	type key struct {
+		account common.Name
+		nonce   uint64
+	}
+	spent := make(map[key]*Transaction, len(added))
+	for _, tx := range added {
+		action := tx.GetActions()[0]
+		spent[key{action.Sender(), action.Nonce()}] = tx
+	}
+	var conflicts []*TxConflict
+	for _, tx := range dropped {
+		action := tx.GetActions()[0]
+		if conflict := NewTxConflict(tx, spent[key{action.Sender(), action.Nonce()}], ConflictFork); conflict != nil {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+)
+
+func TestTxConflicts(t *testing.T) {
+	newTx := func(from string, nonce uint64, amount int64) *Transaction {
+		return NewTransaction(1, big.NewInt(1), NewAction(Transfer, common.Name(from), "bob", nonce, 1, 100000, big.NewInt(amount), nil))
+	}
+	var (
+		kept     = newTx("alice", 0, 1)
+		dropped  = newTx("alice", 1, 1)
+		spent    = newTx("alice", 1, 2)
+		unspent  = newTx("carol", 0, 1)
+		other    = newTx("carol", 1, 1)
+		conflict = TxConflicts([]*Transaction{kept, dropped, unspent}, []*Transaction{kept, spent, other})
+	)
+	if len(conflict) != 1 {
+		t.Fatalf("conflicts mismatch: have %d, want 1", len(conflict))
+	}
+	if c := conflict[0]; c.Account != "alice" || c.Nonce != 1 || c.First != dropped.Hash() || c.Second != spent.Hash() || c.Source != ConflictFork {
+		t.Fatalf("conflict mismatch: %+v", c)
+	}
+	if c := conflict[0]; c.FeeBump {
+		t.Fatalf("conflict of another amount taken for a fee bump: %+v", c)
+	}
+	bumped := NewTransaction(1, big.NewInt(2), NewAction(Transfer, "alice", "bob", 1, 1, 200000, big.NewInt(1), nil))
+	if c := NewTxConflict(dropped, bumped, ConflictTxPool); c == nil || !c.FeeBump {
+		t.Fatalf("fee bump mismatch: %+v", c)
+	}
+	if c := NewTxConflict(kept, kept, ConflictTxPool); c != nil {
+		t.Fatalf("same transaction conflicting: %+v", c)
+	}
+}
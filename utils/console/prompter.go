@@ -18,6 +18,7 @@ package console
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/peterh/liner"
 )
@@ -81,3 +82,17 @@ func (p *terminalPrompter) PromptPassword(prompt string) (passwd string, err err
 	fmt.Println()
 	return passwd, err
 }
+
+// PromptConfirm displays the given prompt to the user and requests a boolean
+// choice to be made, returning that choice.
+func (p *terminalPrompter) PromptConfirm(prompt string) (bool, error) {
+	if p.supported {
+		p.rawMode.ApplyMode()
+		defer p.normalMode.ApplyMode()
+	}
+	input, err := p.State.Prompt(prompt + " [y/N] ")
+	if len(input) > 0 && strings.ToUpper(input[:1]) == "Y" {
+		return true, nil
+	}
+	return false, err
+}
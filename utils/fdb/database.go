@@ -23,7 +23,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/metrics"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
@@ -39,6 +39,9 @@ const (
 
 var OpenFileLimit = 64
 
+// flushKey is the key deleted to flush the journal of the database.
+var flushKey = []byte("fdb-flush")
+
 type LDBDatabase struct {
 	fn string      // filename for reporting
 	db *leveldb.DB // LevelDB instance
@@ -59,6 +62,17 @@ type LDBDatabase struct {
 
 // NewLDBDatabase returns a LevelDB wrapped object.
 func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
+	return newLDBDatabase(file, cache, handles, false)
+}
+
+// NewReadOnlyLDBDatabase returns a LevelDB wrapped object that can't be
+// written to. The database is locked shared, so it can't be opened while a
+// node holds it for writing, but several readers may open it at once.
+func NewReadOnlyLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
+	return newLDBDatabase(file, cache, handles, true)
+}
+
+func newLDBDatabase(file string, cache int, handles int, readOnly bool) (*LDBDatabase, error) {
 	logger := log.New("database", file)
 
 	// Ensure we have some minimal caching and file guarantees
@@ -76,8 +90,9 @@ func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
 		BlockCacheCapacity:     cache / 2 * opt.MiB,
 		WriteBuffer:            cache / 4 * opt.MiB, // Two of these are used internally
 		Filter:                 filter.NewBloomFilter(10),
+		ReadOnly:               readOnly,
 	})
-	if _, corrupted := err.(*errors.ErrCorrupted); corrupted {
+	if _, corrupted := err.(*errors.ErrCorrupted); corrupted && !readOnly {
 		db, err = leveldb.RecoverFile(file, nil)
 	}
 	// (Re)check for errors and abort if opening of the db failed
@@ -124,10 +139,26 @@ func (db *LDBDatabase) NewIterator() iterator.Iterator {
 }
 
 // NewIteratorWithPrefix returns a iterator to iterate over subset of database content with a particular prefix.
-func (db *LDBDatabase) NewIteratorWithPrefix(prefix []byte) iterator.Iterator {
+func (db *LDBDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
 	return db.db.NewIterator(util.BytesPrefix(prefix), nil)
 }
 
+// NewIteratorWithStart returns a iterator to iterate over subset of database
+// content with a particular prefix, starting at the prefix followed by start.
+func (db *LDBDatabase) NewIteratorWithStart(prefix, start []byte) Iterator {
+	r := util.BytesPrefix(prefix)
+	r.Start = append(r.Start, start...)
+	return db.db.NewIterator(r, nil)
+}
+
+// Flush syncs the journal of the database to the disk, the writes done
+// before are durable once it returns.
+func (db *LDBDatabase) Flush() error {
+	// deleting a missing key appends a record to the journal without changing
+	// the content, the synced write syncs the records written before it
+	return db.db.Delete(flushKey, &opt.WriteOptions{Sync: true})
+}
+
 func (db *LDBDatabase) Close() {
 	// Stop the metrics collection to avoid internal database races
 	db.quitLock.Lock()
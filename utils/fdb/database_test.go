@@ -186,3 +186,119 @@ func testParallelPutGet(db Database, t *testing.T) {
 	}
 	pending.Wait()
 }
+
+func TestLDB_IteratorWithPrefix(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testIteratorWithPrefix(db, t)
+}
+
+func TestMemoryDB_IteratorWithPrefix(t *testing.T) {
+	testIteratorWithPrefix(NewMemDatabase(), t)
+}
+
+func testIteratorWithPrefix(db Database, t *testing.T) {
+	t.Parallel()
+
+	for _, k := range []string{"b2", "a1", "b1", "c1", "b3"} {
+		if err := db.Put([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	it := db.NewIteratorWithPrefix([]byte("b"))
+	defer it.Release()
+
+	// Writes made after the iterator was created aren't seen by it.
+	db.Put([]byte("b0"), []byte("vb0"))
+
+	var keys []string
+	for it.Next() {
+		if v := string(it.Value()); v != "v"+string(it.Key()) {
+			t.Fatalf("value of %q mismatch: %q", it.Key(), v)
+		}
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[b1 b2 b3]" {
+		t.Fatalf("iterated keys mismatch: have %v, want [b1 b2 b3]", keys)
+	}
+
+	// Iterators with a start skip the keys of the prefix before it.
+	start := db.NewIteratorWithStart([]byte("b"), []byte("1x"))
+	defer start.Release()
+	keys = keys[:0]
+	for start.Next() {
+		keys = append(keys, string(start.Key()))
+	}
+	if fmt.Sprint(keys) != "[b2 b3]" {
+		t.Fatalf("iterated keys from start mismatch: have %v, want [b2 b3]", keys)
+	}
+}
+
+func TestLDB_ReadOnly(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	rodb, err := NewReadOnlyLDBDatabase(db.Path(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rodb.Close()
+	if value, err := rodb.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Fatalf("get mismatch: have %q, %v", value, err)
+	}
+	if err := rodb.Put([]byte("key"), []byte("other")); err == nil {
+		t.Fatal("put to read-only database succeeded")
+	}
+}
+
+func TestLDB_Flush(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	if err := db.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+	// the flush leaves the content unchanged
+	it := db.NewIteratorWithPrefix(nil)
+	defer it.Release()
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if len(keys) != 1 || keys[0] != "key" {
+		t.Fatalf("keys mismatch after flush: have %v, want [key]", keys)
+	}
+}
+
+func TestReadOnlyDatabase(t *testing.T) {
+	memdb := NewMemDatabase()
+	memdb.Put([]byte("key"), []byte("value"))
+
+	db := NewReadOnlyDatabase(memdb)
+	if value, err := db.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Fatalf("get mismatch: have %q, %v", value, err)
+	}
+	if err := db.Put([]byte("key"), []byte("other")); err != ErrReadOnly {
+		t.Fatalf("put error mismatch: have %v, want %v", err, ErrReadOnly)
+	}
+	if err := db.Delete([]byte("key")); err != ErrReadOnly {
+		t.Fatalf("delete error mismatch: have %v, want %v", err, ErrReadOnly)
+	}
+	batch := db.NewBatch()
+	batch.Put([]byte("key"), []byte("other"))
+	if err := batch.Write(); err != ErrReadOnly {
+		t.Fatalf("batch write error mismatch: have %v, want %v", err, ErrReadOnly)
+	}
+	if value, _ := memdb.Get([]byte("key")); !bytes.Equal(value, []byte("value")) {
+		t.Fatalf("database modified: %q", value)
+	}
+}
@@ -30,16 +30,46 @@ type Deleter interface {
 	Delete(key []byte) error
 }
 
+// Iterator iterates over key/value pairs of a database in ascending key order.
+// The content seen by an iterator doesn't change while it is in use.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Release()
+	Error() error
+}
+
+// Iteratee wraps the iteration over database content.
+type Iteratee interface {
+	// NewIteratorWithPrefix returns an iterator over the content with a
+	// particular key prefix. It must be released after use.
+	NewIteratorWithPrefix(prefix []byte) Iterator
+
+	// NewIteratorWithStart returns an iterator over the content with a
+	// particular key prefix, starting at the key of the prefix followed by
+	// start. It must be released after use.
+	NewIteratorWithStart(prefix, start []byte) Iterator
+}
+
 // Database wraps all database operations. All methods are safe for concurrent use.
 type Database interface {
 	Putter
 	Deleter
+	Iteratee
 	Get(key []byte) ([]byte, error)
 	Has(key []byte) (bool, error)
 	Close()
 	NewBatch() Batch
 }
 
+// Flusher is implemented by the databases buffering writes which are not
+// durable until flushed.
+type Flusher interface {
+	// Flush makes the writes done so far durable.
+	Flush() error
+}
+
 // Batch is a write-only database that commits changes to its host database
 // when Write is called. Batch cannot be used concurrently.
 type Batch interface {
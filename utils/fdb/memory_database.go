@@ -17,7 +17,9 @@
 package fdb
 
 import (
+	"bytes"
 	"errors"
+	"sort"
 	"sync"
 
 	"github.com/fractalplatform/fractal/common"
@@ -84,6 +86,29 @@ func (db *MemDatabase) Keys() [][]byte {
 	return keys
 }
 
+// NewIteratorWithPrefix returns an iterator over a snapshot of the content
+// with a particular key prefix.
+func (db *MemDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return db.NewIteratorWithStart(prefix, nil)
+}
+
+// NewIteratorWithStart returns an iterator over a snapshot of the content
+// with a particular key prefix, starting at the prefix followed by start.
+func (db *MemDatabase) NewIteratorWithStart(prefix, start []byte) Iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	first := append(append([]byte{}, prefix...), start...)
+	it := &memIterator{index: -1}
+	for key, value := range db.db {
+		if bytes.HasPrefix([]byte(key), prefix) && bytes.Compare([]byte(key), first) >= 0 {
+			it.kvs = append(it.kvs, kv{[]byte(key), common.CopyBytes(value)})
+		}
+	}
+	sort.Slice(it.kvs, func(i, j int) bool { return bytes.Compare(it.kvs[i].k, it.kvs[j].k) < 0 })
+	return it
+}
+
 func (db *MemDatabase) Delete(key []byte) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
@@ -112,6 +137,38 @@ func (db *MemDatabase) Len() int { return len(db.db) }
 
 type kv struct{ k, v []byte }
 
+type memIterator struct {
+	kvs   []kv
+	index int
+}
+
+func (it *memIterator) Next() bool {
+	if it.index+1 >= len(it.kvs) {
+		it.index = len(it.kvs)
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *memIterator) Key() []byte {
+	if it.index < 0 || it.index >= len(it.kvs) {
+		return nil
+	}
+	return it.kvs[it.index].k
+}
+
+func (it *memIterator) Value() []byte {
+	if it.index < 0 || it.index >= len(it.kvs) {
+		return nil
+	}
+	return it.kvs[it.index].v
+}
+
+func (it *memIterator) Release() { it.kvs = nil }
+
+func (it *memIterator) Error() error { return nil }
+
 type memBatch struct {
 	db     *MemDatabase
 	writes []kv
@@ -0,0 +1,56 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package fdb
+
+import "errors"
+
+// ErrReadOnly is returned when writing to a read-only database.
+var ErrReadOnly = errors.New("database is read-only")
+
+// readOnlyDatabase rejects all writes to the wrapped database.
+type readOnlyDatabase struct {
+	Database
+}
+
+// NewReadOnlyDatabase returns a view of db failing all writes with
+// ErrReadOnly. Batches can be filled but not written.
+func NewReadOnlyDatabase(db Database) Database {
+	if _, ok := db.(*readOnlyDatabase); ok {
+		return db
+	}
+	return &readOnlyDatabase{db}
+}
+
+func (db *readOnlyDatabase) Put(key []byte, value []byte) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDatabase) Delete(key []byte) error {
+	return ErrReadOnly
+}
+
+func (db *readOnlyDatabase) NewBatch() Batch {
+	return &readOnlyBatch{db.Database.NewBatch()}
+}
+
+type readOnlyBatch struct {
+	Batch
+}
+
+func (b *readOnlyBatch) Write() error {
+	return ErrReadOnly
+}
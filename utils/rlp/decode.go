@@ -41,6 +41,7 @@ var (
 	ErrElemTooLarge     = errors.New("rlp: element is larger than containing list")
 	ErrValueTooLarge    = errors.New("rlp: value size exceeds available input length")
 	ErrMoreThanOneValue = errors.New("rlp: input contains more than one value")
+	ErrTooManyElems     = errors.New("rlp: list has more elements than allowed")
 
 	// internal errors
 	errNotInList     = errors.New("rlp: call of ListEnd outside of any list")
@@ -145,6 +146,23 @@ func DecodeBytes(b []byte, val interface{}) error {
 	return nil
 }
 
+// DecodeBytesLimit is DecodeBytes rejecting, with ErrTooManyElems, the lists
+// decoded to slices of more than maxElems elements. It bounds the memory
+// allocated for untrusted input, whose elements may decode to values much
+// larger than their encoding.
+func DecodeBytesLimit(b []byte, val interface{}, maxElems uint64) error {
+	r := bytes.NewReader(b)
+	s := NewStream(r, uint64(len(b)))
+	s.SetElemLimit(maxElems)
+	if err := s.Decode(val); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return ErrMoreThanOneValue
+	}
+	return nil
+}
+
 type decodeError struct {
 	msg string
 	typ reflect.Type
@@ -338,6 +356,12 @@ func decodeListSlice(s *Stream, val reflect.Value, elemdec decoder) error {
 func decodeSliceElems(s *Stream, val reflect.Value, elemdec decoder) error {
 	i := 0
 	for ; ; i++ {
+		if s.maxElems > 0 && uint64(i) >= s.maxElems {
+			if _, _, err := s.Kind(); err == EOL {
+				break
+			}
+			return ErrTooManyElems
+		}
 		// grow slice if necessary
 		if i >= val.Cap() {
 			newcap := val.Cap() + val.Cap()/2
@@ -597,6 +621,9 @@ type Stream struct {
 	remaining uint64
 	limited   bool
 
+	// maximum number of elements of a decoded slice, 0 for no limit.
+	maxElems uint64
+
 	// auxiliary buffer for integer decoding
 	uintbuf []byte
 
@@ -632,6 +659,13 @@ func NewStream(r io.Reader, inputLimit uint64) *Stream {
 	return s
 }
 
+// SetElemLimit limits the number of elements of the slices decoded from the
+// stream, longer lists failing with ErrTooManyElems. 0 removes the limit. The
+// limit is kept across resets.
+func (s *Stream) SetElemLimit(maxElems uint64) {
+	s.maxElems = maxElems
+}
+
 // NewListStream creates a new stream that pretends to be positioned
 // at an encoded list of the given length.
 func NewListStream(r io.Reader, len uint64) *Stream {
@@ -624,6 +624,27 @@ func TestDecodeStreamReset(t *testing.T) {
 	})
 }
 
+func TestDecodeBytesLimit(t *testing.T) {
+	input := unhex("C3010203")
+	var full []uint
+	if err := DecodeBytesLimit(input, &full, 3); err != nil || len(full) != 3 {
+		t.Fatalf("decoding at the limit: have %v %v", full, err)
+	}
+	var over []uint
+	if err := DecodeBytesLimit(input, &over, 2); err != ErrTooManyElems {
+		t.Fatalf("decoding over the limit: have %v, want %v", err, ErrTooManyElems)
+	}
+	// The limit applies to nested lists and tails too.
+	var nested [][]uint
+	if err := DecodeBytesLimit(unhex("C5C0C3010203"), &nested, 2); err != ErrTooManyElems {
+		t.Fatalf("nested list over the limit: have %v, want %v", err, ErrTooManyElems)
+	}
+	var tail structWithTail
+	if err := DecodeBytesLimit(unhex("C50102030405"), &tail, 2); err != ErrTooManyElems {
+		t.Fatalf("tail over the limit: have %v, want %v", err, ErrTooManyElems)
+	}
+}
+
 type testDecoder struct{ called bool }
 
 func (t *testDecoder) DecodeRLP(s *Stream) error {
@@ -0,0 +1,79 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package supervisor restarts the long-running goroutines of the node when
+// they panic, instead of letting one panic kill the node.
+package supervisor
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/fractalplatform/fractal/log"
+	"github.com/fractalplatform/fractal/metrics"
+)
+
+var panicMeter = metrics.NewRegisteredMeter("supervisor/panics", nil)
+
+var (
+	minBackoff = time.Second // delay of the first restart
+	maxBackoff = time.Minute // longest delay between restarts
+)
+
+// Run calls fn until it returns without panicking or quit is closed. A panic
+// of fn is recovered and logged with its stack and the name of the component,
+// and fn called again after a backoff doubling with each panic, reset once fn
+// runs longer than the longest backoff. The restarted fn reuses the state of
+// the component, so fn mustn't leave locks held when panicking.
+func Run(name string, quit <-chan struct{}, fn func()) {
+	backoff := minBackoff
+	for {
+		start := time.Now()
+		if !call(name, fn) {
+			return
+		}
+		if time.Since(start) > maxBackoff {
+			backoff = minBackoff
+		}
+		log.Warn("Restarting component", "component", name, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-quit:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Go calls fn in a new goroutine supervised by Run.
+func Go(name string, quit <-chan struct{}, fn func()) {
+	go Run(name, quit, fn)
+}
+
+// call calls fn, reporting whether it panicked.
+func call(name string, fn func()) (panicked bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			panicked = true
+			panicMeter.Mark(1)
+			log.Error("Component panicked", "component", name, "err", err, "stack", string(debug.Stack()))
+		}
+	}()
+	fn()
+	return false
+}
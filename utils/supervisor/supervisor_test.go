@@ -0,0 +1,61 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package supervisor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunRestartsAfterPanic(t *testing.T) {
+	defer func(min time.Duration) { minBackoff = min }(minBackoff)
+	minBackoff = time.Millisecond
+
+	calls := 0
+	Run("test", nil, func() {
+		if calls++; calls < 3 {
+			panic("boom")
+		}
+	})
+	if calls != 3 {
+		t.Fatalf("calls mismatch: have %d, want 3", calls)
+	}
+}
+
+func TestRunStopsOnQuit(t *testing.T) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	calls := 0
+	go func() {
+		defer close(done)
+		Run("test", quit, func() {
+			calls++
+			panic("boom")
+		})
+	}()
+	// The first restart waits a second, quitting meanwhile stops the component.
+	time.Sleep(50 * time.Millisecond)
+	close(quit)
+	select {
+	case <-done:
+	case <-time.After(time.Second / 2):
+		t.Fatal("component not stopped")
+	}
+	if calls != 1 {
+		t.Fatalf("calls mismatch: have %d, want 1", calls)
+	}
+}
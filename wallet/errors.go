@@ -23,4 +23,8 @@ var (
 	ErrNoMatch = errors.New("no key for given address or file")
 	// ErrAccountExists account already exists
 	ErrAccountExists = errors.New("account already exists")
+	// ErrLocked account is locked
+	ErrLocked = errors.New("account is locked")
+	// ErrExternalSigner keys are held by an external signer
+	ErrExternalSigner = errors.New("keys are held by an external signer")
 )
@@ -0,0 +1,146 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package external
+
+import (
+	"errors"
+	"math/big"
+	"net"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+	"github.com/fractalplatform/fractal/wallet"
+)
+
+// UI asks the key owner to approve signing requests. The returned passphrase
+// decrypts the key of the request.
+type UI interface {
+	// ApproveTx shows the decoded transaction to be signed. The signature
+	// covers every action of the transaction, not only the requested one,
+	// and hash is the hash signed.
+	ApproveTx(req *SignTxRequest, tx *types.Transaction, hash common.Hash) (approved bool, passphrase string, err error)
+	// ApproveSignData shows the hash to be signed.
+	ApproveSignData(req *SignDataRequest) (approved bool, passphrase string, err error)
+}
+
+// SignerAPI is the service run by the signer process. It holds the keys in a
+// wallet and only signs what the UI approved.
+type SignerAPI struct {
+	w  *wallet.Wallet
+	ui UI
+}
+
+// NewSignerAPI creates the signer service for the keys of w.
+func NewSignerAPI(w *wallet.Wallet, ui UI) *SignerAPI {
+	return &SignerAPI{w: w, ui: ui}
+}
+
+// StartSigner serves api on the unix socket (named pipe on Windows) at
+// endpoint.
+func StartSigner(endpoint string, api *SignerAPI) (net.Listener, *rpc.Server, error) {
+	apis := []rpc.API{{Namespace: Namespace, Version: Version, Service: api, Public: true}}
+	return rpc.StartIPCEndpoint(endpoint, apis)
+}
+
+// Version returns the version of the signer protocol.
+func (api *SignerAPI) Version() string {
+	return Version
+}
+
+// List returns the addresses of all keys.
+func (api *SignerAPI) List() []common.Address {
+	accounts := api.w.Accounts()
+	addrs := make([]common.Address, 0, len(accounts))
+	for _, a := range accounts {
+		addrs = append(addrs, a.Addr)
+	}
+	return addrs
+}
+
+// SignTransaction decodes the transaction, asks for approval of all of it and
+// signs the requested action.
+func (api *SignerAPI) SignTransaction(req SignTxRequest) (*SignResponse, error) {
+	a, err := api.w.Find(req.Address)
+	if err != nil {
+		return nil, err
+	}
+	if req.ChainID == nil {
+		return nil, errors.New("missing chain id")
+	}
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(req.Transaction, tx); err != nil {
+		return nil, err
+	}
+	actions := tx.GetActions()
+	if req.Action >= uint64(len(actions)) {
+		return nil, errors.New("action index out of range")
+	}
+	hash := types.NewSigner((*big.Int)(req.ChainID)).Hash(tx)
+	approved, passphrase, err := api.ui.ApproveTx(&req, tx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !approved {
+		return &SignResponse{Approved: false}, nil
+	}
+	sig, err := api.w.SignHashWithPassphrase(a, passphrase, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	return &SignResponse{Approved: true, Signature: sig}, nil
+}
+
+// SignData asks for approval and signs the requested hash.
+func (api *SignerAPI) SignData(req SignDataRequest) (*SignResponse, error) {
+	a, err := api.w.Find(req.Address)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Hash) != common.HashLength {
+		return nil, errors.New("hash must be 32 bytes")
+	}
+	approved, passphrase, err := api.ui.ApproveSignData(&req)
+	if err != nil {
+		return nil, err
+	}
+	if !approved {
+		return &SignResponse{Approved: false}, nil
+	}
+	sig, err := api.w.SignHashWithPassphrase(a, passphrase, req.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return &SignResponse{Approved: true, Signature: sig}, nil
+}
+
+// verifySignature checks that sig is a signature of hash by the key of addr.
+func verifySignature(addr common.Address, hash, sig []byte) error {
+	if len(sig) != 65 {
+		return errors.New("invalid signature length")
+	}
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return err
+	}
+	if crypto.PubkeyToAddress(*pub) != addr {
+		return errors.New("signature from unexpected key")
+	}
+	return nil
+}
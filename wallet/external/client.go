@@ -0,0 +1,135 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package external
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/rpc"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/utils/rlp"
+)
+
+// dialTimeout bounds establishing the connection to the signer. Signing
+// requests are not bounded, they wait for the key owner to decide.
+const dialTimeout = 5 * time.Second
+
+// ExternalSigner forwards signing requests of the wallet to a signer process.
+// It implements wallet.Signer.
+type ExternalSigner struct {
+	endpoint string
+	client   *rpc.Client
+}
+
+// NewExternalSigner connects to the signer listening on the given socket path
+// and checks that it speaks the same protocol version.
+func NewExternalSigner(endpoint string) (*ExternalSigner, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	client, err := rpc.DialIPC(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var version string
+	if err := client.CallContext(ctx, &version, Namespace+"_version"); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if version != Version {
+		client.Close()
+		return nil, ErrVersionMismatch
+	}
+	return &ExternalSigner{endpoint: endpoint, client: client}, nil
+}
+
+// Endpoint returns the socket path of the signer.
+func (s *ExternalSigner) Endpoint() string {
+	return s.endpoint
+}
+
+// Close closes the connection to the signer.
+func (s *ExternalSigner) Close() {
+	s.client.Close()
+}
+
+// Accounts returns the addresses of the keys held by the signer.
+func (s *ExternalSigner) Accounts() ([]common.Address, error) {
+	var addrs []common.Address
+	if err := s.client.Call(&addrs, Namespace+"_list"); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// SignHash asks the signer to sign hash with the key of addr.
+func (s *ExternalSigner) SignHash(addr common.Address, hash []byte) ([]byte, error) {
+	var resp SignResponse
+	req := &SignDataRequest{Address: addr, Hash: hash}
+	if err := s.client.Call(&resp, Namespace+"_signData", req); err != nil {
+		return nil, err
+	}
+	if !resp.Approved {
+		return nil, ErrRequestDenied
+	}
+	return resp.Signature, nil
+}
+
+// SignTx sends the whole transaction to the signer, which signs the given
+// action after approval. The signature is verified before it is applied.
+func (s *ExternalSigner) SignTx(addr common.Address, tx *types.Transaction, action *types.Action, chainID *big.Int) (*types.Transaction, error) {
+	index := -1
+	for i, a := range tx.GetActions() {
+		if a == action {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, errors.New("action is not part of the transaction")
+	}
+	data, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, err
+	}
+	req := &SignTxRequest{
+		Address:     addr,
+		ChainID:     (*hexutil.Big)(chainID),
+		Transaction: data,
+		Action:      uint64(index),
+	}
+	var resp SignResponse
+	if err := s.client.Call(&resp, Namespace+"_signTransaction", req); err != nil {
+		return nil, err
+	}
+	if !resp.Approved {
+		return nil, ErrRequestDenied
+	}
+	signer := types.NewSigner(chainID)
+	hash := signer.Hash(tx)
+	if err := verifySignature(addr, hash[:], resp.Signature); err != nil {
+		return nil, err
+	}
+	if err := action.WithSignature(signer, resp.Signature); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package external
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/types"
+	"github.com/fractalplatform/fractal/wallet"
+	"github.com/fractalplatform/fractal/wallet/keystore"
+)
+
+type testUI struct {
+	approve    bool
+	passphrase string
+	requests   int
+	tx         *types.Transaction // last transaction shown
+	hash       common.Hash        // last transaction hash shown
+}
+
+func (ui *testUI) ApproveTx(req *SignTxRequest, tx *types.Transaction, hash common.Hash) (bool, string, error) {
+	ui.requests++
+	ui.tx, ui.hash = tx, hash
+	return ui.approve, ui.passphrase, nil
+}
+
+func (ui *testUI) ApproveSignData(req *SignDataRequest) (bool, string, error) {
+	ui.requests++
+	return ui.approve, ui.passphrase, nil
+}
+
+func TestExternalSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys := wallet.NewWallet(filepath.Join(dir, "keystore"), keystore.LightScryptN, keystore.LightScryptP)
+	a, err := keys.NewAccount("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := &testUI{approve: true, passphrase: "secret"}
+	endpoint := filepath.Join(dir, "signer.ipc")
+	listener, _, err := StartSigner(endpoint, NewSignerAPI(keys, ui))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	signer, err := NewExternalSigner(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+
+	w := wallet.NewWallet(filepath.Join(dir, "node"), keystore.LightScryptN, keystore.LightScryptP)
+	w.SetSigner(signer)
+	if !w.HasAddress(a.Addr) {
+		t.Fatalf("account %x of the signer not found", a.Addr)
+	}
+	if _, err := w.NewAccount("secret"); err != wallet.ErrExternalSigner {
+		t.Fatalf("new account error mismatch: have %v, want %v", err, wallet.ErrExternalSigner)
+	}
+
+	chainID := big.NewInt(1)
+	other := types.NewAction(types.Transfer, "carol", "bob", 0, 1, 21000, big.NewInt(1000), nil)
+	action := types.NewAction(types.Transfer, "alice", "bob", 0, 1, 21000, big.NewInt(10), nil)
+	tx := types.NewTransaction(1, big.NewInt(1), other, action)
+	if _, err := w.SignTx(a, tx, action, chainID); err != nil {
+		t.Fatal(err)
+	}
+	// The signature covers the whole transaction, which is shown for approval.
+	if ui.tx == nil || len(ui.tx.GetActions()) != 2 {
+		t.Fatal("transaction shown without all its actions")
+	}
+	if want := types.NewSigner(chainID).Hash(tx); ui.hash != want {
+		t.Fatalf("hash shown mismatch: have %x, want %x", ui.hash, want)
+	}
+	pub, err := types.Recover(types.NewSigner(chainID), action, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.UnmarshalPubkey(pub.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := crypto.PubkeyToAddress(*key); addr != a.Addr {
+		t.Fatalf("signer mismatch: have %x, want %x", addr, a.Addr)
+	}
+
+	ui.approve = false
+	hash := crypto.Keccak256([]byte("data"))
+	if _, err := w.SignHash(a, hash); err != ErrRequestDenied {
+		t.Fatalf("sign error mismatch: have %v, want %v", err, ErrRequestDenied)
+	}
+	if ui.requests != 2 {
+		t.Fatalf("approval requests mismatch: have %d, want 2", ui.requests)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package external implements signing on behalf of the node by a separate
+// signer process, so the node never holds private keys in memory. The node
+// talks JSON-RPC to the signer over a local socket. Every signing request is
+// a typed message which the signer decodes and verifies itself, and which
+// has to be approved by the key owner before a signature is returned.
+package external
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/fractalplatform/fractal/common"
+)
+
+// Version is the version of the signer protocol.
+const Version = "1.0.0"
+
+// Namespace is the RPC namespace the signer serves its methods in.
+const Namespace = "account"
+
+var (
+	// ErrRequestDenied is returned if the key owner rejected a request.
+	ErrRequestDenied = errors.New("request denied")
+	// ErrVersionMismatch is returned if the signer speaks another protocol version.
+	ErrVersionMismatch = errors.New("signer protocol version mismatch")
+)
+
+// SignTxRequest asks the signer to sign one action of a transaction.
+type SignTxRequest struct {
+	Address     common.Address `json:"address"`     // key to sign with
+	ChainID     *hexutil.Big   `json:"chainId"`     // chain the signature is valid on
+	Transaction hexutil.Bytes  `json:"transaction"` // RLP encoded transaction
+	Action      uint64         `json:"action"`      // index of the action to sign
+}
+
+// SignDataRequest asks the signer to sign a hash.
+type SignDataRequest struct {
+	Address common.Address `json:"address"`
+	Hash    hexutil.Bytes  `json:"hash"`
+}
+
+// SignResponse is the answer to a signing request. The signature is only
+// set if the request was approved.
+type SignResponse struct {
+	Approved  bool          `json:"approved"`
+	Signature hexutil.Bytes `json:"signature,omitempty"`
+}
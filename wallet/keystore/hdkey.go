@@ -0,0 +1,218 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package keystore
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/fractalplatform/fractal/crypto"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// HardenedOffset is added to a child index to derive a hardened key.
+const HardenedOffset = 0x80000000
+
+// DefaultBaseDerivationPath is the base path from which accounts are derived,
+// the n-th account lives at m/44'/60'/0'/0/n. It is the convention of most
+// secp256k1 wallets, so their seed phrases recover the same keys.
+var DefaultBaseDerivationPath = DerivationPath{HardenedOffset + 44, HardenedOffset + 60, HardenedOffset + 0, 0}
+
+var (
+	errInvalidSeed      = errors.New("invalid seed length")
+	errInvalidChild     = errors.New("invalid child key, use the next index")
+	errInvalidMnemonic  = errors.New("invalid mnemonic")
+	errInvalidPathIndex = errors.New("derivation path index out of range")
+)
+
+// DerivationPath represents the computer friendly version of a hierarchical
+// deterministic wallet account derivation path, see BIP-32.
+type DerivationPath []uint32
+
+// ParseDerivationPath converts a user specified derivation path string, like
+// m/44'/60'/0'/0/0, to the internal binary representation. Paths without the
+// m/ prefix are relative to DefaultBaseDerivationPath.
+func ParseDerivationPath(path string) (DerivationPath, error) {
+	var result DerivationPath
+
+	components := strings.Split(strings.TrimSpace(path), "/")
+	switch {
+	case len(components) == 0 || components[0] == "":
+		return nil, errors.New("empty derivation path")
+	case components[0] == "m":
+		components = components[1:]
+	default:
+		result = append(result, DefaultBaseDerivationPath...)
+	}
+	if len(components) == 0 {
+		return nil, errors.New("empty derivation path")
+	}
+	for _, component := range components {
+		component = strings.TrimSpace(component)
+		var offset uint32
+		if strings.HasSuffix(component, "'") {
+			offset = HardenedOffset
+			component = strings.TrimSpace(strings.TrimSuffix(component, "'"))
+		}
+		value, err := strconv.ParseUint(component, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid component %q: %v", component, err)
+		}
+		if value >= HardenedOffset {
+			return nil, errInvalidPathIndex
+		}
+		result = append(result, uint32(value)+offset)
+	}
+	return result, nil
+}
+
+// String implements the stringer interface, converting a binary derivation
+// path to its canonical representation.
+func (path DerivationPath) String() string {
+	result := "m"
+	for _, component := range path {
+		if component >= HardenedOffset {
+			result = fmt.Sprintf("%s/%d'", result, component-HardenedOffset)
+		} else {
+			result = fmt.Sprintf("%s/%d", result, component)
+		}
+	}
+	return result
+}
+
+// ExtendedKey is a BIP-32 extended private key.
+type ExtendedKey struct {
+	key       []byte // 32 byte private key
+	chainCode []byte
+}
+
+// NewMasterKey creates the master key of a key tree from a seed.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errInvalidSeed
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	k := new(big.Int).SetBytes(sum[:32])
+	if k.Sign() == 0 || k.Cmp(crypto.S256().Params().N) >= 0 {
+		return nil, errInvalidSeed
+	}
+	return &ExtendedKey{key: sum[:32], chainCode: sum[32:]}, nil
+}
+
+// Child derives the child key with the given index. Indexes from
+// HardenedOffset on derive hardened keys.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	var data []byte
+	if index >= HardenedOffset {
+		data = append([]byte{0}, k.key...)
+	} else {
+		priv, err := crypto.ToECDSA(k.key)
+		if err != nil {
+			return nil, err
+		}
+		data = crypto.CompressPubkey(&priv.PublicKey)
+	}
+	var blob [4]byte
+	binary.BigEndian.PutUint32(blob[:], index)
+	data = append(data, blob[:]...)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := crypto.S256().Params().N
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, errInvalidChild
+	}
+	child := il.Add(il, new(big.Int).SetBytes(k.key))
+	child.Mod(child, n)
+	if child.Sign() == 0 {
+		return nil, errInvalidChild
+	}
+	key := make([]byte, 32)
+	childBytes := child.Bytes()
+	copy(key[32-len(childBytes):], childBytes)
+	return &ExtendedKey{key: key, chainCode: sum[32:]}, nil
+}
+
+// Derive derives the key at the given path below k.
+func (k *ExtendedKey) Derive(path DerivationPath) (*ExtendedKey, error) {
+	var err error
+	for _, index := range path {
+		if k, err = k.Child(index); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Key converts the extended key into a keystore key.
+func (k *ExtendedKey) Key() (*Key, error) {
+	priv, err := crypto.ToECDSA(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{Addr: crypto.PubkeyToAddress(priv.PublicKey), PrivateKey: priv}, nil
+}
+
+// NewMnemonic generates a BIP-39 mnemonic with the given entropy in bits,
+// which must be a multiple of 32 between 128 and 256.
+func NewMnemonic(bits int) (string, error) {
+	entropy, err := bip39.NewEntropy(bits)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// DeriveKey derives the key at path from a BIP-39 mnemonic, protected by the
+// optional mnemonic password.
+func DeriveKey(mnemonic, password string, path DerivationPath) (*Key, error) {
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, errInvalidMnemonic
+	}
+	master, err := NewMasterKey(bip39.NewSeed(mnemonic, password))
+	if err != nil {
+		return nil, err
+	}
+	k, err := master.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+	return k.Key()
+}
+
+// AccountPath returns the path of the account with the given index below
+// base, e.g. m/44'/60'/0'/0/index for the default base path.
+func AccountPath(base DerivationPath, index uint32) (DerivationPath, error) {
+	if index >= HardenedOffset {
+		return nil, errInvalidPathIndex
+	}
+	path := make(DerivationPath, len(base), len(base)+1)
+	copy(path, base)
+	return append(path, index), nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package keystore
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/fractalplatform/fractal/crypto"
+)
+
+func TestParseDerivationPath(t *testing.T) {
+	tests := []struct {
+		input  string
+		output DerivationPath
+	}{
+		{"m/44'/60'/0'/0/1", DerivationPath{HardenedOffset + 44, HardenedOffset + 60, HardenedOffset, 0, 1}},
+		{"m/0", DerivationPath{0}},
+		{"3", DerivationPath{HardenedOffset + 44, HardenedOffset + 60, HardenedOffset, 0, 3}},
+		{"", nil},
+		{"m", nil},
+		{"m/2147483648", nil},
+		{"m/a", nil},
+	}
+	for i, tt := range tests {
+		path, err := ParseDerivationPath(tt.input)
+		if tt.output == nil {
+			if err == nil {
+				t.Errorf("test %d: expected error for %q", i, tt.input)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(path, tt.output) {
+			t.Errorf("test %d: path mismatch: have %v (%v), want %v", i, path, err, tt.output)
+		}
+		if tt.input[0] == 'm' && path.String() != tt.input {
+			t.Errorf("test %d: string mismatch: have %s, want %s", i, path, tt.input)
+		}
+	}
+}
+
+// Tests the private key derivation against the BIP-32 test vector 1.
+func TestExtendedKeyDerive(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		key  string
+	}{
+		{"m/0'", "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"},
+		{"m/0'/1", "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368"},
+	}
+	if key := hex.EncodeToString(master.key); key != "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35" {
+		t.Fatalf("master key mismatch: have %s", key)
+	}
+	for _, tt := range tests {
+		path, _ := ParseDerivationPath(tt.path)
+		k, err := master.Derive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key := hex.EncodeToString(k.key); key != tt.key {
+			t.Errorf("%s: key mismatch: have %s, want %s", tt.path, key, tt.key)
+		}
+	}
+}
+
+func TestDeriveKeyFromMnemonic(t *testing.T) {
+	mnemonic, err := NewMnemonic(128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := AccountPath(DefaultBaseDerivationPath, 0)
+	key, err := DeriveKey(mnemonic, "password", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := DeriveKey(mnemonic, "password", path)
+	if !reflect.DeepEqual(crypto.FromECDSA(key.PrivateKey), crypto.FromECDSA(again.PrivateKey)) {
+		t.Fatal("derivation is not deterministic")
+	}
+	other, _ := DeriveKey(mnemonic, "other", path)
+	if key.Addr == other.Addr {
+		t.Fatal("mnemonic password ignored")
+	}
+	if _, err := DeriveKey("not a mnemonic", "", path); err != errInvalidMnemonic {
+		t.Fatalf("invalid mnemonic accepted: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 The Fractal Team Authors
+// This file is part of the fractal project.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wallet
+
+import (
+	"math/big"
+
+	"github.com/fractalplatform/fractal/common"
+	"github.com/fractalplatform/fractal/types"
+)
+
+// Signer is a key holder outside of the node process. Once a wallet is backed
+// by a signer, it never loads private keys: signing requests are forwarded to
+// the signer, and operations on the key files fail with ErrExternalSigner.
+type Signer interface {
+	// Accounts returns the addresses of the keys held by the signer.
+	Accounts() ([]common.Address, error)
+	// SignHash signs hash with the key of addr.
+	SignHash(addr common.Address, hash []byte) ([]byte, error)
+	// SignTx signs action, which is part of tx, with the key of addr.
+	SignTx(addr common.Address, tx *types.Transaction, action *types.Action, chainID *big.Int) (*types.Transaction, error)
+}
+
+// SetSigner delegates all signing of the wallet to an external signer.
+func (w *Wallet) SetSigner(s Signer) {
+	w.signer = s
+}
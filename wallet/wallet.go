@@ -23,11 +23,12 @@ import (
 	"fmt"
 	"math/big"
 	"os"
+	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/log"
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/crypto"
+	"github.com/fractalplatform/fractal/log"
 	"github.com/fractalplatform/fractal/types"
 	"github.com/fractalplatform/fractal/wallet/cache"
 	"github.com/fractalplatform/fractal/wallet/keystore"
@@ -38,20 +39,37 @@ type Wallet struct {
 	accounts cache.Accounts
 	cache    *cache.AccountCache
 	ks       *keystore.KeyStore
+
+	mutex    sync.RWMutex
+	unlocked map[common.Address]*unlocked // currently unlocked keys
+
+	basePath keystore.DerivationPath // base path of the accounts derived from a mnemonic
+
+	signer Signer // external signer holding the keys, if any
+}
+
+type unlocked struct {
+	*keystore.Key
+	abort chan struct{}
 }
 
 // NewWallet creates a wallet to sign transaction.
 func NewWallet(keyStoredir string, scryptN, scryptP int) *Wallet {
 	log.Info("Disk storage enabled for keystore", "dir", keyStoredir)
 	w := &Wallet{
-		cache: cache.NewAccountCache(keyStoredir),
-		ks:    &keystore.KeyStore{DirPath: keyStoredir, ScryptN: scryptN, ScryptP: scryptP},
+		cache:    cache.NewAccountCache(keyStoredir),
+		ks:       &keystore.KeyStore{DirPath: keyStoredir, ScryptN: scryptN, ScryptP: scryptP},
+		unlocked: make(map[common.Address]*unlocked),
+		basePath: keystore.DefaultBaseDerivationPath,
 	}
 	return w
 }
 
 // NewAccount generates a new key and stores it into the key directory.
 func (w *Wallet) NewAccount(passphrase string) (cache.Account, error) {
+	if w.signer != nil {
+		return cache.Account{}, ErrExternalSigner
+	}
 	key, err := keystore.NewKey(crand.Reader)
 	if err != nil {
 		return cache.Account{}, err
@@ -76,6 +94,7 @@ func (w *Wallet) Delete(a cache.Account, passphrase string) error {
 	if err := os.Remove(a.Path); err != nil {
 		return err
 	}
+	w.Lock(a.Addr)
 	w.cache.Delete(a.Addr)
 	return nil
 }
@@ -123,16 +142,40 @@ func (w *Wallet) ImportECDSA(priv *ecdsa.PrivateKey, passphrase string) (cache.A
 
 // HasAddress reports whether a key with the given address is present.
 func (w *Wallet) HasAddress(addr common.Address) bool {
+	if w.signer != nil {
+		_, err := w.Find(addr)
+		return err == nil
+	}
 	return w.cache.Has(addr)
 }
 
-// Accounts returns all key files
+// Accounts returns all key files, or the accounts of the external signer.
 func (w *Wallet) Accounts() cache.Accounts {
+	if w.signer != nil {
+		addrs, err := w.signer.Accounts()
+		if err != nil {
+			log.Warn("Failed to list external signer accounts", "err", err)
+			return nil
+		}
+		accounts := make(cache.Accounts, 0, len(addrs))
+		for _, addr := range addrs {
+			accounts = append(accounts, cache.Account{Addr: addr})
+		}
+		return accounts
+	}
 	return w.cache.Accounts()
 }
 
 // Find resolves the given account into a unique entry in the keystore.
 func (w *Wallet) Find(addr common.Address) (cache.Account, error) {
+	if w.signer != nil {
+		for _, a := range w.Accounts() {
+			if a.Addr == addr {
+				return a, nil
+			}
+		}
+		return cache.Account{}, ErrNoMatch
+	}
 	account := w.cache.Find(addr)
 	if account != nil {
 		return *account, nil
@@ -143,6 +186,9 @@ func (w *Wallet) Find(addr common.Address) (cache.Account, error) {
 // SignHashWithPassphrase signs hash if the private key matching the given address
 // can be decrypted with the given passphrase.
 func (w *Wallet) SignHashWithPassphrase(a cache.Account, passphrase string, hash []byte) (signature []byte, err error) {
+	if w.signer != nil {
+		return w.signer.SignHash(a.Addr, hash)
+	}
 	_, key, err := w.getDecryptedKey(a, passphrase)
 	if err != nil {
 		return nil, err
@@ -153,6 +199,9 @@ func (w *Wallet) SignHashWithPassphrase(a cache.Account, passphrase string, hash
 // SignTxWithPassphrase signs the Action if the private key matching the given address
 // can be decrypted with the given passphrase.
 func (w *Wallet) SignTxWithPassphrase(a cache.Account, passphrase string, tx *types.Transaction, action *types.Action, chainID *big.Int) (*types.Transaction, error) {
+	if w.signer != nil {
+		return w.signer.SignTx(a.Addr, tx, action, chainID)
+	}
 	_, key, err := w.getDecryptedKey(a, passphrase)
 	if err != nil {
 		return nil, err
@@ -163,7 +212,160 @@ func (w *Wallet) SignTxWithPassphrase(a cache.Account, passphrase string, tx *ty
 	return tx, nil
 }
 
+// SetBasePath sets the base derivation path of mnemonic derived accounts.
+func (w *Wallet) SetBasePath(path keystore.DerivationPath) {
+	w.basePath = path
+}
+
+// ImportMnemonic derives the account with the given index from a BIP-39
+// mnemonic and stores it into the key directory, encrypting it with the
+// passphrase.
+func (w *Wallet) ImportMnemonic(mnemonic, password string, index uint32, passphrase string) (cache.Account, error) {
+	path, err := keystore.AccountPath(w.basePath, index)
+	if err != nil {
+		return cache.Account{}, err
+	}
+	key, err := keystore.DeriveKey(mnemonic, password, path)
+	if err != nil {
+		return cache.Account{}, err
+	}
+	if w.cache.Has(key.Addr) {
+		return cache.Account{}, ErrAccountExists
+	}
+	return w.importKey(key, passphrase)
+}
+
+// RecoverMnemonic derives the first count accounts from a BIP-39 mnemonic and
+// stores the ones missing in the key directory. It returns all derived
+// accounts.
+func (w *Wallet) RecoverMnemonic(mnemonic, password string, count int, passphrase string) ([]cache.Account, error) {
+	accounts := make([]cache.Account, 0, count)
+	for i := 0; i < count; i++ {
+		path, err := keystore.AccountPath(w.basePath, uint32(i))
+		if err != nil {
+			return nil, err
+		}
+		key, err := keystore.DeriveKey(mnemonic, password, path)
+		if err != nil {
+			return nil, err
+		}
+		a, err := w.Find(key.Addr)
+		if err == ErrNoMatch {
+			a, err = w.importKey(key, passphrase)
+		}
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+// Unlock unlocks the given account indefinitely.
+func (w *Wallet) Unlock(a cache.Account, passphrase string) error {
+	return w.TimedUnlock(a, passphrase, 0)
+}
+
+// TimedUnlock unlocks the given account with the passphrase. The account
+// stays unlocked for the duration of timeout. A timeout of 0 unlocks the
+// account until the program exits or Lock is called. Unlocking an account
+// with a timeout again replaces the timeout.
+func (w *Wallet) TimedUnlock(a cache.Account, passphrase string, timeout time.Duration) error {
+	a, key, err := w.getDecryptedKey(a, passphrase)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if u, found := w.unlocked[a.Addr]; found {
+		if u.abort == nil {
+			// The account is already unlocked indefinitely, keep it that way.
+			return nil
+		}
+		close(u.abort)
+	}
+	u := &unlocked{Key: key}
+	if timeout > 0 {
+		u.abort = make(chan struct{})
+		go w.expire(a.Addr, u, timeout)
+	}
+	w.unlocked[a.Addr] = u
+	return nil
+}
+
+// Lock removes the private key with the given address from memory.
+func (w *Wallet) Lock(addr common.Address) {
+	w.mutex.Lock()
+	if u, found := w.unlocked[addr]; found {
+		if u.abort != nil {
+			close(u.abort)
+		}
+		delete(w.unlocked, addr)
+	}
+	w.mutex.Unlock()
+}
+
+// IsUnlocked reports whether the account with the given address is unlocked.
+func (w *Wallet) IsUnlocked(addr common.Address) bool {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	_, found := w.unlocked[addr]
+	return found
+}
+
+// SignHash signs hash with the key of an unlocked account.
+func (w *Wallet) SignHash(a cache.Account, hash []byte) ([]byte, error) {
+	if w.signer != nil {
+		return w.signer.SignHash(a.Addr, hash)
+	}
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	u, found := w.unlocked[a.Addr]
+	if !found {
+		return nil, ErrLocked
+	}
+	return crypto.Sign(hash, u.PrivateKey)
+}
+
+// SignTx signs the Action with the key of an unlocked account.
+func (w *Wallet) SignTx(a cache.Account, tx *types.Transaction, action *types.Action, chainID *big.Int) (*types.Transaction, error) {
+	if w.signer != nil {
+		return w.signer.SignTx(a.Addr, tx, action, chainID)
+	}
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	u, found := w.unlocked[a.Addr]
+	if !found {
+		return nil, ErrLocked
+	}
+	if err := types.SignAction(action, tx, types.NewSigner(chainID), u.PrivateKey); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (w *Wallet) expire(addr common.Address, u *unlocked, timeout time.Duration) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-u.abort:
+		// just quit
+	case <-t.C:
+		w.mutex.Lock()
+		// only drop if it's still the same key instance that TimedUnlock
+		// created, it may have been replaced by a newer unlock.
+		if w.unlocked[addr] == u {
+			delete(w.unlocked, addr)
+		}
+		w.mutex.Unlock()
+	}
+}
+
 func (w *Wallet) importKey(key *keystore.Key, passphrase string) (cache.Account, error) {
+	if w.signer != nil {
+		return cache.Account{}, ErrExternalSigner
+	}
 	a := cache.Account{Addr: key.Addr, Path: w.ks.JoinPath(keyFileName(key.Addr))}
 	if err := w.ks.StoreKey(key, a.Path, passphrase); err != nil {
 		return cache.Account{}, err
@@ -179,6 +381,9 @@ func (w *Wallet) GetPrivateKey(a cache.Account, passphrase string) (*keystore.Ke
 	return key, nil
 }
 func (w *Wallet) getDecryptedKey(a cache.Account, passphrase string) (cache.Account, *keystore.Key, error) {
+	if w.signer != nil {
+		return a, nil, ErrExternalSigner
+	}
 	a, err := w.Find(a.Addr)
 	if err != nil {
 		return a, nil, err
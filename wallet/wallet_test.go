@@ -24,6 +24,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fractalplatform/fractal/common"
 	"github.com/fractalplatform/fractal/crypto"
@@ -159,3 +160,76 @@ func TestSignWithPassphrase(t *testing.T) {
 
 	assert.Equal(t, nSig, sig)
 }
+
+func TestTimedUnlock(t *testing.T) {
+	var hash = make([]byte, 32)
+
+	d, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	w := NewWallet(d, keystore.LightScryptN, keystore.LightScryptP)
+	a, err := w.NewAccount("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signing without unlocking fails.
+	if _, err := w.SignHash(a, hash); err != ErrLocked {
+		t.Fatalf("signing a locked account: have %v, want %v", err, ErrLocked)
+	}
+	if err := w.TimedUnlock(a, "wrong", 0); err == nil {
+		t.Fatal("unlock with wrong passphrase succeeded")
+	}
+
+	// Signing with an unlocked account works until the timeout passes.
+	if err := w.TimedUnlock(a, "password", 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.SignHash(a, hash); err != nil {
+		t.Fatal("signing an unlocked account failed:", err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if _, err := w.SignHash(a, hash); err != ErrLocked {
+		t.Fatalf("signing after timeout: have %v, want %v", err, ErrLocked)
+	}
+
+	// Lock drops an indefinitely unlocked key.
+	if err := w.Unlock(a, "password"); err != nil {
+		t.Fatal(err)
+	}
+	if !w.IsUnlocked(a.Addr) {
+		t.Fatal("account not unlocked")
+	}
+	w.Lock(a.Addr)
+	if _, err := w.SignHash(a, hash); err != ErrLocked {
+		t.Fatalf("signing after lock: have %v, want %v", err, ErrLocked)
+	}
+}
+
+func TestRecoverMnemonic(t *testing.T) {
+	d, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(d)
+
+	w := NewWallet(d, keystore.LightScryptN, keystore.LightScryptP)
+	mnemonic, err := keystore.NewMnemonic(128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := w.ImportMnemonic(mnemonic, "", 0, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := w.RecoverMnemonic(mnemonic, "", 3, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(accounts))
+	assert.Equal(t, first.Addr, accounts[0].Addr)
+	assert.Equal(t, 3, len(w.Accounts()))
+}